@@ -17,7 +17,27 @@ type object struct {
 type backend interface {
 	Put(id string, reader io.Reader, untrustedLength int64) error
 	Get(id string) (io.ReadCloser, int64, error)
+	GetRange(id string, offset, length int64) (io.ReadCloser, int64, error) // Reads length bytes from offset (-1 = until the end), returns the total size
+	Stat(id string) (int64, error)
 	List() ([]object, error)
 	Delete(ids ...string) error
-	DeleteIncomplete(cutoff time.Time) error
+	DeleteIncomplete(cutoff time.Time, keepUploadIDs ...string) error // Deletes incomplete uploads older than cutoff, except the given multipart uploads
+}
+
+// presigner is implemented by backends that can hand out presigned URLs, so that clients can download
+// and upload attachments directly from/to the backend, without going through the ntfy server.
+type presigner interface {
+	PresignGet(id string, expires time.Duration, filename string) string
+	PresignPut(id string, size int64, expires time.Duration) string
+}
+
+// multipartUploader is implemented by backends that can assemble an object from parts that are uploaded one after
+// the other, possibly over a long time. This is used for resumable uploads (see Store.CreateUpload). All parts but
+// the last must be at least MinPartSize bytes. The object only becomes visible once the upload is completed.
+type multipartUploader interface {
+	CreateMultipart(id string) (uploadID string, err error)
+	PutPart(id, uploadID string, partNumber int, reader io.Reader, length int64) (etag string, err error) // Reads exactly length bytes
+	CompleteMultipart(id, uploadID string, etags []string) error
+	AbortMultipart(id, uploadID string) error
+	MinPartSize() int64
 }
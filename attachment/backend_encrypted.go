@@ -0,0 +1,133 @@
+package attachment
+
+import (
+	"bytes"
+	"io"
+
+	"heckel.io/ntfy/v2/encryption"
+)
+
+// encryptedBackend wraps another backend, and encrypts all objects before they are written to it (see encryption
+// package for the format). Objects that were written before encryption was enabled are read as is. The backend
+// intentionally does not implement presigner, since clients would otherwise up- and download encrypted objects,
+// and it does not implement multipartUploader, since encrypted streams cannot be split into arbitrary parts.
+type encryptedBackend struct {
+	backend
+	keyring *encryption.Keyring
+}
+
+var _ backend = (*encryptedBackend)(nil)
+
+func newEncryptedBackend(b backend, keyring *encryption.Keyring) *encryptedBackend {
+	return &encryptedBackend{
+		backend: b,
+		keyring: keyring,
+	}
+}
+
+func (b *encryptedBackend) Put(id string, reader io.Reader, untrustedLength int64) error {
+	var ciphertextLength int64
+	if untrustedLength > 0 {
+		reader = io.LimitReader(reader, untrustedLength)
+		ciphertextLength = encryption.CiphertextSize(untrustedLength)
+	}
+	encryptReader, err := b.keyring.NewEncryptReader(reader, []byte(id))
+	if err != nil {
+		return err
+	}
+	return b.backend.Put(id, encryptReader, ciphertextLength)
+}
+
+func (b *encryptedBackend) Get(id string) (io.ReadCloser, int64, error) {
+	rc, size, err := b.backend.Get(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := make([]byte, encryption.HeaderSize)
+	n, err := io.ReadFull(rc, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		rc.Close()
+		return nil, 0, err
+	} else if !encryption.IsEncryptedStream(header[:n]) {
+		return &limitedReadCloser{Reader: io.MultiReader(bytes.NewReader(header[:n]), rc), Closer: rc}, size, nil
+	}
+	reader, err := b.keyring.NewDecryptRangeReader(header, rc, size, 0, []byte(id))
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	return &limitedReadCloser{Reader: reader, Closer: rc}, encryption.PlaintextSize(size), nil
+}
+
+func (b *encryptedBackend) GetRange(id string, offset, length int64) (io.ReadCloser, int64, error) {
+	header, size, err := b.header(id)
+	if err != nil || !encryption.IsEncryptedStream(header) {
+		return b.backend.GetRange(id, offset, length)
+	}
+	plaintextSize := encryption.PlaintextSize(size)
+	if offset >= plaintextSize {
+		return io.NopCloser(bytes.NewReader(nil)), plaintextSize, nil
+	}
+	start, ciphertextLength := encryption.CiphertextRange(offset, length)
+	rc, _, err := b.backend.GetRange(id, start, ciphertextLength)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader, err := b.keyring.NewDecryptRangeReader(header, rc, size, offset, []byte(id))
+	if err != nil {
+		rc.Close()
+		return nil, 0, err
+	}
+	if length >= 0 {
+		reader = io.LimitReader(reader, length)
+	}
+	return &limitedReadCloser{Reader: reader, Closer: rc}, plaintextSize, nil
+}
+
+// List returns the objects of the underlying backend, with their plaintext sizes. To avoid reading every object,
+// the sizes are derived from the stored sizes, which may be slightly off for objects that are not encrypted.
+func (b *encryptedBackend) List() ([]object, error) {
+	objects, err := b.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		if size := encryption.PlaintextSize(objects[i].Size); size >= 0 {
+			objects[i].Size = size
+		}
+	}
+	return objects, nil
+}
+
+func (b *encryptedBackend) Stat(id string) (int64, error) {
+	header, size, err := b.header(id)
+	if err != nil || !encryption.IsEncryptedStream(header) {
+		return b.backend.Stat(id)
+	}
+	return encryption.PlaintextSize(size), nil
+}
+
+// NeedsReencryption returns true if the object is not encrypted, or if it was encrypted with
+// a key other than the primary key
+func (b *encryptedBackend) NeedsReencryption(id string) (bool, error) {
+	header, _, err := b.header(id)
+	if err != nil {
+		return false, err
+	}
+	return encryption.StreamKeyID(header) != b.keyring.PrimaryKeyID(), nil
+}
+
+// header reads the stream header of an object, and returns it along with the total (encrypted) object size.
+// The returned header may be shorter than encryption.HeaderSize if the object is not encrypted.
+func (b *encryptedBackend) header(id string) ([]byte, int64, error) {
+	rc, size, err := b.backend.GetRange(id, 0, int64(encryption.HeaderSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+	header, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return header, size, nil
+}
@@ -0,0 +1,147 @@
+package attachment
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/encryption"
+)
+
+const (
+	testKey1 = "key1:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzEhISE="
+	testKey2 = "key2:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzIhISE="
+)
+
+func TestEncryptedStore_WriteReadRange(t *testing.T) {
+	dir, s := newTestEncryptedFileStore(t, 10*1024*1024, newTestKeyring(t, testKey1))
+	data := make([]byte, 200*1024)
+	_, _ = rand.Read(data)
+	size, err := s.Write("abcdefghijkl", bytes.NewReader(data), int64(len(data)))
+	require.Nil(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	// File on disk is encrypted
+	raw, err := os.ReadFile(filepath.Join(dir, "abcdefghijkl"))
+	require.Nil(t, err)
+	require.True(t, encryption.IsEncryptedStream(raw))
+	require.Equal(t, encryption.CiphertextSize(int64(len(data))), int64(len(raw)))
+	require.False(t, bytes.Contains(raw, data[:64]))
+
+	// Full read, range read and stat return plaintext
+	reader, total, err := s.Read("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, int64(len(data)), total)
+	decrypted, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	require.Equal(t, data, decrypted)
+
+	reader, total, err = s.ReadRange("abcdefghijkl", 70000, 1000)
+	require.Nil(t, err)
+	require.Equal(t, int64(len(data)), total)
+	decrypted, err = io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	require.Equal(t, data[70000:71000], decrypted)
+
+	stat, err := s.Stat("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, int64(len(data)), stat)
+
+	// Presigned URLs are never handed out for encrypted stores
+	require.False(t, s.CanPresign())
+}
+
+func TestEncryptedStore_ReadUnencrypted(t *testing.T) {
+	dir, s := newTestEncryptedFileStore(t, 10*1024, newTestKeyring(t, testKey1))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "abcdefghijkl"), []byte("written before encryption was enabled"), 0600))
+	reader, total, err := s.Read("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, int64(37), total)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	require.Equal(t, "written before encryption was enabled", string(data))
+
+	reader, _, err = s.ReadRange("abcdefghijkl", 8, 6)
+	require.Nil(t, err)
+	data, err = io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	require.Equal(t, "before", string(data))
+}
+
+func TestEncryptedStore_Reencrypt(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "abcdefghijk0"), []byte("plaintext file"), 0600))
+	s1, err := NewFileStore(dir, 10*1024, time.Hour, newTestKeyring(t, testKey1), nil)
+	require.Nil(t, err)
+	_, err = s1.Write("abcdefghijk1", strings.NewReader("old key file"), 0)
+	require.Nil(t, err)
+	_, err = s1.WriteThumbnail("abcdefghijk1", strings.NewReader("old key thumbnail"))
+	require.Nil(t, err)
+	s1.Close()
+
+	// Rotate key, then re-encrypt
+	s2, err := NewFileStore(dir, 10*1024, time.Hour, newTestKeyring(t, testKey1, testKey2), nil)
+	require.Nil(t, err)
+	defer s2.Close()
+	count, err := s2.Reencrypt()
+	require.Nil(t, err)
+	require.Equal(t, 3, count)
+	for id, expected := range map[string]string{"abcdefghijk0": "plaintext file", "abcdefghijk1": "old key file", "abcdefghijk1-thumbnail": "old key thumbnail"} {
+		raw, err := os.ReadFile(filepath.Join(dir, id))
+		require.Nil(t, err)
+		require.Equal(t, "key2", encryption.StreamKeyID(raw))
+		reader, _, err := s2.backend.Get(id)
+		require.Nil(t, err)
+		data, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		reader.Close()
+		require.Equal(t, expected, string(data))
+	}
+
+	// Nothing left to do
+	count, err = s2.Reencrypt()
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+
+	// Old key can be removed now
+	s3, err := NewFileStore(dir, 10*1024, time.Hour, newTestKeyring(t, testKey2), nil)
+	require.Nil(t, err)
+	defer s3.Close()
+	reader, _, err := s3.Read("abcdefghijk1")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	reader.Close()
+	require.Equal(t, "old key file", string(data))
+}
+
+func TestStore_ReencryptNotEnabled(t *testing.T) {
+	_, s := newTestFileStore(t, 10*1024)
+	_, err := s.Reencrypt()
+	require.Equal(t, ErrEncryptionNotEnabled, err)
+}
+
+func newTestEncryptedFileStore(t *testing.T, totalSizeLimit int64, keyring *encryption.Keyring) (dir string, cache *Store) {
+	t.Helper()
+	dir = t.TempDir()
+	cache, err := NewFileStore(dir, totalSizeLimit, time.Hour, keyring, nil)
+	require.Nil(t, err)
+	t.Cleanup(func() { cache.Close() })
+	return dir, cache
+}
+
+func newTestKeyring(t *testing.T, entries ...string) *encryption.Keyring {
+	keyring, err := encryption.ReadKeyring(strings.NewReader(strings.Join(entries, "\n")))
+	require.Nil(t, err)
+	return keyring
+}
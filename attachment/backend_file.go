@@ -1,18 +1,28 @@
 package attachment
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
+// fileUploadSuffix is the file name suffix of incomplete multipart uploads, which are appended to part by
+// part, and renamed once the upload is completed
+const fileUploadSuffix = ".upload"
+
 type fileBackend struct {
 	dir string
 }
 
-var _ backend = (*fileBackend)(nil)
+var (
+	_ backend           = (*fileBackend)(nil)
+	_ multipartUploader = (*fileBackend)(nil)
+)
 
 func newFileBackend(dir string) (*fileBackend, error) {
 	if err := os.MkdirAll(dir, 0700); err != nil {
@@ -53,6 +63,9 @@ func (b *fileBackend) List() ([]object, error) {
 	}
 	objects := make([]object, 0, len(entries))
 	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), fileUploadSuffix) {
+			continue // Incomplete upload
+		}
 		info, err := e.Info()
 		if err != nil {
 			return nil, err
@@ -67,6 +80,10 @@ func (b *fileBackend) List() ([]object, error) {
 }
 
 func (b *fileBackend) Get(id string) (io.ReadCloser, int64, error) {
+	return b.GetRange(id, 0, -1)
+}
+
+func (b *fileBackend) GetRange(id string, offset, length int64) (io.ReadCloser, int64, error) {
 	file := filepath.Join(b.dir, id)
 	stat, err := os.Stat(file)
 	if err != nil {
@@ -76,7 +93,24 @@ func (b *fileBackend) Get(id string) (io.ReadCloser, int64, error) {
 	if err != nil {
 		return nil, 0, err
 	}
-	return f, stat.Size(), nil
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+	}
+	if length < 0 {
+		return f, stat.Size(), nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(f, length), Closer: f}, stat.Size(), nil
+}
+
+func (b *fileBackend) Stat(id string) (int64, error) {
+	stat, err := os.Stat(filepath.Join(b.dir, id))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
 }
 
 func (b *fileBackend) Delete(ids ...string) error {
@@ -89,6 +123,83 @@ func (b *fileBackend) Delete(ids ...string) error {
 	return nil
 }
 
-func (b *fileBackend) DeleteIncomplete(_ time.Time) error {
+// DeleteIncomplete deletes incomplete multipart uploads that were last written to before cutoff
+func (b *fileBackend) DeleteIncomplete(cutoff time.Time, keepUploadIDs ...string) error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), fileUploadSuffix)
+		if !ok || slices.Contains(keepUploadIDs, id) {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if err := os.Remove(filepath.Join(b.dir, e.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateMultipart creates an empty upload file. The upload ID is the object ID, since there can only be one
+// upload file per object.
+func (b *fileBackend) CreateMultipart(id string) (string, error) {
+	f, err := os.OpenFile(b.uploadFile(id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	return id, f.Close()
+}
+
+// PutPart appends the part to the upload file. If fewer than length bytes can be read, the part is
+// removed again, so that a failed part can be retried.
+func (b *fileBackend) PutPart(id, _ string, _ int, reader io.Reader, length int64) (string, error) {
+	f, err := os.OpenFile(b.uploadFile(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(reader, length))
+	if err == nil && n != length {
+		err = fmt.Errorf("content length mismatch: claimed %d, got %d", length, n)
+	}
+	if err != nil {
+		if truncateErr := f.Truncate(stat.Size()); truncateErr != nil {
+			return "", errors.Join(err, truncateErr)
+		}
+		return "", err
+	}
+	return "", f.Close()
+}
+
+func (b *fileBackend) CompleteMultipart(id, _ string, _ []string) error {
+	return os.Rename(b.uploadFile(id), filepath.Join(b.dir, id))
+}
+
+func (b *fileBackend) AbortMultipart(id, _ string) error {
+	if err := os.Remove(b.uploadFile(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
+
+// MinPartSize returns 0, since parts of any size can be appended to a file
+func (b *fileBackend) MinPartSize() int64 {
+	return 0
+}
+
+func (b *fileBackend) uploadFile(id string) string {
+	return filepath.Join(b.dir, id+fileUploadSuffix)
+}
+
+// limitedReadCloser reads from a limited reader, and closes the underlying file
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,378 @@
+package attachment
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsTokenLifetime   = time.Hour
+	gcsTokenLeeway     = time.Minute // Access tokens are refreshed this long before they expire
+)
+
+// gcsBackend stores attachments in a Google Cloud Storage bucket, using the JSON API. Requests are authorized
+// with OAuth 2.0 access tokens of a service account (see gcsTokenSource).
+type gcsBackend struct {
+	endpoint string // API endpoint, e.g. https://storage.googleapis.com
+	bucket   string
+	prefix   string          // Object name prefix, empty or ending with a slash
+	tokens   *gcsTokenSource // May be nil for emulators, which do not require authorization
+	client   *http.Client
+}
+
+var _ backend = (*gcsBackend)(nil)
+
+// gcsObject is the (partial) object resource of the JSON API
+type gcsObject struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size,string"`
+	Updated time.Time `json:"updated"`
+}
+
+// gcsObjectList is the response of an objects.list request
+type gcsObjectList struct {
+	Items         []*gcsObject `json:"items"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+// newGCSBackend creates a backend from a Google Cloud Storage URL of the form
+//
+//	gcs://BUCKET[/PREFIX]?credentials=KEYFILE[&endpoint=ENDPOINT]
+//
+// The credentials parameter is the path to the JSON key file of a service account. It may only be omitted if
+// a custom endpoint is set, e.g. for an emulator that does not require authorization.
+func newGCSBackend(gcsURL string) (*gcsBackend, error) {
+	u, err := url.Parse(gcsURL)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: invalid URL: %w", err)
+	} else if u.Scheme != "gcs" {
+		return nil, fmt.Errorf("gcs: URL scheme must be 'gcs', got '%s'", u.Scheme)
+	} else if u.Host == "" {
+		return nil, errors.New("gcs: bucket name must be specified as host")
+	}
+	endpoint := strings.TrimRight(u.Query().Get("endpoint"), "/")
+	credentialsFile := u.Query().Get("credentials")
+	var tokens *gcsTokenSource
+	if credentialsFile != "" {
+		tokens, err = newGCSTokenSource(credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+	} else if endpoint == "" {
+		return nil, errors.New("gcs: credentials query parameter is required")
+	}
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &gcsBackend{
+		endpoint: endpoint,
+		bucket:   u.Host,
+		prefix:   prefix,
+		tokens:   tokens,
+		client:   newHTTPClient(),
+	}, nil
+}
+
+// Put uploads the object with a single media upload request
+//
+// See https://cloud.google.com/storage/docs/uploading-objects#uploading-an-object
+func (b *gcsBackend) Put(id string, reader io.Reader, untrustedLength int64) error {
+	body, contentLength := httpUploadBody(reader, untrustedLength)
+	query := url.Values{"uploadType": {"media"}, "name": {b.prefix + id}}
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", b.endpoint, url.PathEscape(b.bucket), query.Encode())
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, uploadURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = contentLength
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := b.do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading object %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if !httpSuccess(resp) {
+		return httpError(resp, "uploading object", id)
+	}
+	return nil
+}
+
+func (b *gcsBackend) Get(id string) (io.ReadCloser, int64, error) {
+	return b.GetRange(id, 0, -1)
+}
+
+// GetRange downloads the object, or a range of it
+//
+// See https://cloud.google.com/storage/docs/json_api/v1/objects/get
+func (b *gcsBackend) GetRange(id string, offset, length int64) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, b.objectURL(id)+"?alt=media", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset > 0 || length >= 0 {
+		req.Header.Set("Range", httpRangeHeader(offset, length))
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching object %s: %w", id, err)
+	} else if !httpSuccess(resp) {
+		defer resp.Body.Close()
+		return nil, 0, httpError(resp, "fetching object", id)
+	}
+	return httpRangeResponse(resp, offset, length)
+}
+
+// Stat returns the size of the object from its metadata
+func (b *gcsBackend) Stat(id string) (int64, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, b.objectURL(id), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching object metadata %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if !httpSuccess(resp) {
+		return 0, httpError(resp, "fetching object metadata", id)
+	}
+	obj, err := util.UnmarshalJSONWithLimit[gcsObject](resp.Body, httpMaxResponseBytes, false)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching object metadata %s: %w", id, err)
+	}
+	return obj.Size, nil
+}
+
+// List returns all objects with the configured prefix
+//
+// See https://cloud.google.com/storage/docs/json_api/v1/objects/list
+func (b *gcsBackend) List() ([]object, error) {
+	objects := make([]object, 0)
+	pageToken := ""
+	for page := 0; page < httpMaxListPages; page++ {
+		query := url.Values{"fields": {"items(name,size,updated),nextPageToken"}}
+		if b.prefix != "" {
+			query.Set("prefix", b.prefix)
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		listURL := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", b.endpoint, url.PathEscape(b.bucket), query.Encode())
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, listURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects: %w", err)
+		}
+		var result *gcsObjectList
+		if !httpSuccess(resp) {
+			err = httpError(resp, "listing objects in", b.bucket)
+		} else {
+			result, err = util.UnmarshalJSONWithLimit[gcsObjectList](resp.Body, httpMaxResponseBytes, false)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Items {
+			objects = append(objects, object{
+				ID:           strings.TrimPrefix(obj.Name, b.prefix),
+				Size:         obj.Size,
+				LastModified: obj.Updated,
+			})
+		}
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = result.NextPageToken
+	}
+	return nil, fmt.Errorf("error listing objects: exceeded %d pages", httpMaxListPages)
+}
+
+// Delete deletes the given objects. Objects that do not exist are ignored.
+//
+// See https://cloud.google.com/storage/docs/json_api/v1/objects/delete
+func (b *gcsBackend) Delete(ids ...string) error {
+	for _, id := range ids {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodDelete, b.objectURL(id), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := b.do(req)
+		if err != nil {
+			return fmt.Errorf("error deleting object %s: %w", id, err)
+		}
+		if !httpSuccess(resp) && resp.StatusCode != http.StatusNotFound {
+			err = httpError(resp, "deleting object", id)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteIncomplete does nothing, since media uploads are a single request
+func (b *gcsBackend) DeleteIncomplete(_ time.Time, _ ...string) error {
+	return nil
+}
+
+func (b *gcsBackend) do(req *http.Request) (*http.Response, error) {
+	if b.tokens != nil {
+		token, err := b.tokens.Token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return b.client.Do(req)
+}
+
+func (b *gcsBackend) objectURL(id string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", b.endpoint, url.PathEscape(b.bucket), url.PathEscape(b.prefix+id))
+}
+
+// gcsTokenSource issues OAuth 2.0 access tokens for a service account, by exchanging a self-signed JWT
+// at the token endpoint of the service account. Tokens are cached until shortly before they expire.
+//
+// See https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+type gcsTokenSource struct {
+	email      string
+	privateKey *rsa.PrivateKey
+	tokenURL   string
+	client     *http.Client
+	token      string
+	expires    time.Time
+	mu         sync.Mutex
+}
+
+// gcsCredentials is the (partial) JSON key file of a service account
+type gcsCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcsTokenResponse is the response of the token endpoint
+type gcsTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func newGCSTokenSource(credentialsFile string) (*gcsTokenSource, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: cannot read credentials file: %w", err)
+	}
+	var credentials gcsCredentials
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("gcs: invalid credentials file: %w", err)
+	} else if credentials.ClientEmail == "" || credentials.PrivateKey == "" || credentials.TokenURI == "" {
+		return nil, errors.New("gcs: credentials file must be a service account key file")
+	}
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if block == nil {
+		return nil, errors.New("gcs: invalid private key in credentials file")
+	}
+	var privateKey *rsa.PrivateKey
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if privateKey, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("gcs: private key in credentials file must be an RSA key")
+		}
+	} else if privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, errors.New("gcs: invalid private key in credentials file")
+	}
+	return &gcsTokenSource{
+		email:      credentials.ClientEmail,
+		privateKey: privateKey,
+		tokenURL:   credentials.TokenURI,
+		client:     newHTTPClient(),
+	}, nil
+}
+
+// Token returns a valid access token, and requests a new one if needed
+func (s *gcsTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Add(gcsTokenLeeway).Before(s.expires) {
+		return s.token, nil
+	}
+	assertion, err := s.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	resp, err := s.client.PostForm(s.tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("gcs: error requesting access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if !httpSuccess(resp) {
+		return "", httpError(resp, "requesting access token for", s.email)
+	}
+	token, err := util.UnmarshalJSONWithLimit[gcsTokenResponse](resp.Body, httpMaxResponseBytes, false)
+	if err != nil {
+		return "", fmt.Errorf("gcs: invalid access token response: %w", err)
+	} else if token.AccessToken == "" {
+		return "", errors.New("gcs: access token response does not contain a token")
+	}
+	s.token = token.AccessToken
+	s.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// assertion returns the self-signed JWT (RS256) that is exchanged for an access token
+func (s *gcsTokenSource) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   s.email,
+		"scope": gcsScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(gcsTokenLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,189 @@
+package attachment
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testGCSKey     *rsa.PrivateKey
+	testGCSKeyOnce sync.Once
+)
+
+func TestNewGCSBackend(t *testing.T) {
+	server := newFakeGCSServer(t)
+	b, err := newGCSBackend("gcs://mybucket/some/prefix?credentials=" + url.QueryEscape(server.credentialsFile))
+	require.Nil(t, err)
+	require.Equal(t, "https://storage.googleapis.com", b.endpoint)
+	require.Equal(t, "mybucket", b.bucket)
+	require.Equal(t, "some/prefix/", b.prefix)
+	require.Equal(t, "ntfy@example.iam.gserviceaccount.com", b.tokens.email)
+	require.Equal(t, "https://storage.googleapis.com/storage/v1/b/mybucket/o/some%2Fprefix%2Fabc", b.objectURL("abc"))
+
+	b, err = newGCSBackend("gcs://mybucket?endpoint=http://localhost:4443/")
+	require.Nil(t, err)
+	require.Equal(t, "http://localhost:4443", b.endpoint)
+	require.Nil(t, b.tokens)
+
+	_, err = newGCSBackend("gcs://mybucket")
+	require.Error(t, err) // Credentials required without custom endpoint
+	_, err = newGCSBackend("gcs://mybucket?credentials=/does/not/exist.json")
+	require.Error(t, err)
+	_, err = newGCSBackend("s3://mybucket?endpoint=http://localhost:4443")
+	require.Error(t, err)
+}
+
+func TestGCSTokenSource_CachesToken(t *testing.T) {
+	server := newFakeGCSServer(t)
+	tokens, err := newGCSTokenSource(server.credentialsFile)
+	require.Nil(t, err)
+	token1, err := tokens.Token()
+	require.Nil(t, err)
+	token2, err := tokens.Token()
+	require.Nil(t, err)
+	require.Equal(t, token1, token2)
+	require.Equal(t, 1, server.tokenCount)
+}
+
+// fakeGCSServer is a minimal in-memory Google Cloud Storage JSON API server for a single bucket named "ntfy",
+// including a token endpoint that verifies the service account's signed JWT
+type fakeGCSServer struct {
+	*httptest.Server
+	store           *fakeObjectStore
+	credentialsFile string
+	tokenCount      int
+	mu              sync.Mutex
+}
+
+func newFakeGCSServer(t *testing.T) *fakeGCSServer {
+	testGCSKeyOnce.Do(func() {
+		var err error
+		testGCSKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+	})
+	s := &fakeGCSServer{store: newFakeObjectStore()}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(testGCSKey)
+	require.Nil(t, err)
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "ntfy@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		"token_uri":    s.URL + "/token",
+	})
+	require.Nil(t, err)
+	s.credentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+	require.Nil(t, os.WriteFile(s.credentialsFile, credentials, 0600))
+	return s
+}
+
+func (s *fakeGCSServer) url(prefix string) string {
+	return "gcs://ntfy/" + prefix + "?credentials=" + url.QueryEscape(s.credentialsFile) + "&endpoint=" + url.QueryEscape(s.URL)
+}
+
+func (s *fakeGCSServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		s.handleToken(w, r)
+		return
+	} else if r.Header.Get("Authorization") != "Bearer fake-access-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	name, isObject := strings.CutPrefix(r.URL.Path, "/storage/v1/b/ntfy/o/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/ntfy/o":
+		data, err := io.ReadAll(r.Body)
+		if err != nil || query.Get("uploadType") != "media" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.store.put(query.Get("name"), data)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/ntfy/o":
+		page, nextPageToken := fakePage(s.store.list(query.Get("prefix")), query.Get("pageToken"), fakePageSize)
+		result := &gcsObjectList{Items: make([]*gcsObject, 0), NextPageToken: nextPageToken}
+		for _, objectName := range page {
+			obj, _ := s.store.get(objectName)
+			result.Items = append(result.Items, &gcsObject{Name: objectName, Size: int64(len(obj.data)), Updated: obj.lastModified})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet && isObject && query.Get("alt") == "media":
+		s.store.serve(w, r, name)
+	case r.Method == http.MethodGet && isObject:
+		obj, ok := s.store.get(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&gcsObject{Name: name, Size: int64(len(obj.data)), Updated: obj.lastModified})
+	case r.Method == http.MethodDelete && isObject:
+		if !s.store.delete(name) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeGCSServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	if len(parts) != 3 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&testGCSKey.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	s.tokenCount++
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&gcsTokenResponse{AccessToken: "fake-access-token", ExpiresIn: 3600})
+}
+
+func newTestGCSStore(t *testing.T, totalSizeLimit int64) (*Store, *fakeGCSServer) {
+	server := newFakeGCSServer(t)
+	b, err := newGCSBackend(server.url("attachments"))
+	require.Nil(t, err)
+	s, err := newStore(b, totalSizeLimit, time.Hour, nil)
+	require.Nil(t, err)
+	t.Cleanup(s.Close)
+	return s, server
+}
@@ -0,0 +1,91 @@
+package attachment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shared helpers for the HTTP-based backends (WebDAV, Azure Blob Storage and Google Cloud Storage)
+const (
+	httpTimeout          = 10 * time.Minute // Timeout for a single request, including uploading or downloading the body
+	httpMaxResponseBytes = 2 * 1024 * 1024  // Caps the size of API responses we read into memory
+	httpMaxListPages     = 500              // Max number of pages to iterate through when listing objects
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: httpTimeout}
+}
+
+// httpError reads an error response, and returns it as an error. Missing objects are reported as errObjectNotFound.
+func httpError(resp *http.Response, action, id string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("error %s %s: %w", action, id, errObjectNotFound)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("error %s %s: unexpected HTTP %d: %s", action, id, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// httpSuccess returns true if the response status code is 2xx
+func httpSuccess(resp *http.Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// httpUploadBody limits the upload body to the untrusted length, if any, and returns the content length to send.
+// If the body is shorter than the claimed length, the request fails (see fileBackend.Put).
+func httpUploadBody(reader io.Reader, untrustedLength int64) (io.Reader, int64) {
+	if untrustedLength > 0 {
+		return io.LimitReader(reader, untrustedLength), untrustedLength
+	}
+	return reader, -1
+}
+
+// httpRangeHeader returns the value of a Range header for the given offset and length (-1 = until the end)
+func httpRangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// httpRangeResponse returns the body and the total object size of a response to a range request. Servers that
+// ignore the Range header respond with the entire object, in which case the range is cut out of the body.
+func httpRangeResponse(resp *http.Response, offset, length int64) (io.ReadCloser, int64, error) {
+	if resp.StatusCode == http.StatusPartialContent {
+		size, err := parseContentRangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, 0, err
+		}
+		return resp.Body, size, nil
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil && err != io.EOF {
+			resp.Body.Close()
+			return nil, 0, err
+		}
+	}
+	if length < 0 {
+		return resp.Body, resp.ContentLength, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, resp.ContentLength, nil
+}
+
+// parseContentRangeSize returns the total size from a Content-Range header, e.g. "bytes 0-9/100"
+func parseContentRangeSize(header string) (int64, error) {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok || !strings.HasPrefix(header, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range header: %q", header)
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range header: %q", header)
+	}
+	return size, nil
+}
@@ -0,0 +1,127 @@
+package attachment
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentRangeSize(t *testing.T) {
+	size, err := parseContentRangeSize("bytes 0-9/100")
+	require.Nil(t, err)
+	require.Equal(t, int64(100), size)
+
+	_, err = parseContentRangeSize("bytes 0-9/*")
+	require.Error(t, err)
+	_, err = parseContentRangeSize("")
+	require.Error(t, err)
+}
+
+func TestHTTPRangeResponse_RangeIgnored(t *testing.T) {
+	// Server ignored the Range header, and sent the entire object
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: 11,
+		Body:          io.NopCloser(strings.NewReader("hello world")),
+	}
+	reader, size, err := httpRangeResponse(resp, 6, 3)
+	require.Nil(t, err)
+	require.Equal(t, int64(11), size)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.Equal(t, "wor", string(data))
+}
+
+// fakeObjectStore is the in-memory storage of the fake WebDAV, Azure and GCS servers used in tests
+type fakeObjectStore struct {
+	objects map[string]*fakeObject // Object name (path or key) -> object
+	mu      sync.Mutex
+}
+
+type fakeObject struct {
+	data         []byte
+	lastModified time.Time
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{objects: make(map[string]*fakeObject)}
+}
+
+func (s *fakeObjectStore) put(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = &fakeObject{data: data, lastModified: time.Now()}
+}
+
+func (s *fakeObjectStore) get(name string) (*fakeObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[name]
+	return obj, ok
+}
+
+func (s *fakeObjectStore) delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[name]
+	delete(s.objects, name)
+	return ok
+}
+
+// list returns the names of all objects with the given prefix, sorted
+func (s *fakeObjectStore) list(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0)
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *fakeObjectStore) setModTime(name string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.objects[name]; ok {
+		obj.lastModified = t
+	}
+}
+
+// serve writes the object, and handles HEAD and Range requests
+func (s *fakeObjectStore) serve(w http.ResponseWriter, r *http.Request, name string) {
+	obj, ok := s.get(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", obj.lastModified, bytes.NewReader(obj.data))
+}
+
+// fakePage returns the page of names starting after marker, and the marker of the next page, if any
+func fakePage(names []string, marker string, pageSize int) ([]string, string) {
+	start := 0
+	if marker != "" {
+		start = sort.SearchStrings(names, marker) + 1
+	}
+	if start >= len(names) {
+		return nil, ""
+	}
+	end := min(start+pageSize, len(names))
+	if end < len(names) {
+		return names[start:end], names[end-1]
+	}
+	return names[start:end], ""
+}
+
+// fakePageSize is the number of objects per page in the fake list responses, small to test pagination
+const fakePageSize = 2
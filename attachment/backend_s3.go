@@ -3,6 +3,8 @@ package attachment
 import (
 	"context"
 	"io"
+	"net/url"
+	"strconv"
 	"time"
 
 	"heckel.io/ntfy/v2/s3"
@@ -12,7 +14,11 @@ type s3Backend struct {
 	client *s3.Client
 }
 
-var _ backend = (*s3Backend)(nil)
+var (
+	_ backend           = (*s3Backend)(nil)
+	_ presigner         = (*s3Backend)(nil)
+	_ multipartUploader = (*s3Backend)(nil)
+)
 
 func newS3Backend(client *s3.Client) *s3Backend {
 	return &s3Backend{client: client}
@@ -26,6 +32,26 @@ func (b *s3Backend) Get(id string) (io.ReadCloser, int64, error) {
 	return b.client.GetObject(context.Background(), id)
 }
 
+func (b *s3Backend) GetRange(id string, offset, length int64) (io.ReadCloser, int64, error) {
+	return b.client.GetObjectRange(context.Background(), id, offset, length)
+}
+
+func (b *s3Backend) Stat(id string) (int64, error) {
+	return b.client.HeadObject(context.Background(), id)
+}
+
+func (b *s3Backend) PresignGet(id string, expires time.Duration, filename string) string {
+	var query url.Values
+	if filename != "" {
+		query = url.Values{"response-content-disposition": {"attachment; filename=" + strconv.Quote(filename)}}
+	}
+	return b.client.PresignGetObject(id, expires, query)
+}
+
+func (b *s3Backend) PresignPut(id string, size int64, expires time.Duration) string {
+	return b.client.PresignPutObject(id, size, expires)
+}
+
 func (b *s3Backend) List() ([]object, error) {
 	objects, err := b.client.ListObjectsV2(context.Background())
 	if err != nil {
@@ -46,6 +72,26 @@ func (b *s3Backend) Delete(ids ...string) error {
 	return b.client.DeleteObjects(context.Background(), ids)
 }
 
-func (b *s3Backend) DeleteIncomplete(cutoff time.Time) error {
-	return b.client.AbortIncompleteUploads(context.Background(), cutoff)
+func (b *s3Backend) DeleteIncomplete(cutoff time.Time, keepUploadIDs ...string) error {
+	return b.client.AbortIncompleteUploads(context.Background(), cutoff, keepUploadIDs...)
+}
+
+func (b *s3Backend) CreateMultipart(id string) (string, error) {
+	return b.client.CreateMultipartUpload(context.Background(), id)
+}
+
+func (b *s3Backend) PutPart(id, uploadID string, partNumber int, reader io.Reader, length int64) (string, error) {
+	return b.client.UploadPart(context.Background(), id, uploadID, partNumber, reader, length)
+}
+
+func (b *s3Backend) CompleteMultipart(id, uploadID string, etags []string) error {
+	return b.client.CompleteMultipartUpload(context.Background(), id, uploadID, etags)
+}
+
+func (b *s3Backend) AbortMultipart(id, uploadID string) error {
+	return b.client.AbortMultipartUpload(context.Background(), id, uploadID)
+}
+
+func (b *s3Backend) MinPartSize() int64 {
+	return s3.MinPartSize
 }
@@ -0,0 +1,261 @@
+package attachment
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	tagWebDAV = "webdav"
+
+	webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+)
+
+// webdavBackend stores attachments as files in a collection (directory) on a WebDAV server, e.g. a NAS
+type webdavBackend struct {
+	collectionURL *url.URL // URL of the collection, with trailing slash and without credentials
+	username      string
+	password      string
+	client        *http.Client
+}
+
+var _ backend = (*webdavBackend)(nil)
+
+// webdavMultistatus is the response of a PROPFIND request
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength int64  `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// parseWebDAVURL parses a WebDAV URL of the form webdav[s]://[USER:PASS@]HOST[:PORT][/PATH]. The webdavs scheme
+// uses HTTPS, the webdav scheme uses plain HTTP.
+func parseWebDAVURL(webdavURL string) (collectionURL *url.URL, username, password string, err error) {
+	u, err := url.Parse(webdavURL)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("webdav: invalid URL: %w", err)
+	}
+	switch u.Scheme {
+	case "webdav":
+		u.Scheme = "http"
+	case "webdavs":
+		u.Scheme = "https"
+	default:
+		return nil, "", "", fmt.Errorf("webdav: URL scheme must be 'webdav' or 'webdavs', got '%s'", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, "", "", fmt.Errorf("webdav: host must be specified")
+	}
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		u.RawPath = ""
+	}
+	return u, username, password, nil
+}
+
+func newWebDAVBackend(webdavURL string) (*webdavBackend, error) {
+	collectionURL, username, password, err := parseWebDAVURL(webdavURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &webdavBackend{
+		collectionURL: collectionURL,
+		username:      username,
+		password:      password,
+		client:        newHTTPClient(),
+	}
+	if err := b.createCollection(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *webdavBackend) Put(id string, reader io.Reader, untrustedLength int64) error {
+	body, contentLength := httpUploadBody(reader, untrustedLength)
+	req, err := b.newRequest(http.MethodPut, b.objectURL(id), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = contentLength
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if !httpSuccess(resp) {
+		return httpError(resp, "uploading", id)
+	}
+	return nil
+}
+
+func (b *webdavBackend) Get(id string) (io.ReadCloser, int64, error) {
+	return b.GetRange(id, 0, -1)
+}
+
+func (b *webdavBackend) GetRange(id string, offset, length int64) (io.ReadCloser, int64, error) {
+	req, err := b.newRequest(http.MethodGet, b.objectURL(id), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset > 0 || length >= 0 {
+		req.Header.Set("Range", httpRangeHeader(offset, length))
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching %s: %w", id, err)
+	} else if !httpSuccess(resp) {
+		defer resp.Body.Close()
+		return nil, 0, httpError(resp, "fetching", id)
+	}
+	return httpRangeResponse(resp, offset, length)
+}
+
+func (b *webdavBackend) Stat(id string) (int64, error) {
+	req, err := b.newRequest(http.MethodHead, b.objectURL(id), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching metadata of %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if !httpSuccess(resp) {
+		return 0, httpError(resp, "fetching metadata of", id)
+	} else if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("error fetching metadata of %s: missing Content-Length", id)
+	}
+	return resp.ContentLength, nil
+}
+
+// List returns all files in the collection. Sub-collections are ignored.
+func (b *webdavBackend) List() ([]object, error) {
+	req, err := b.newRequest("PROPFIND", b.collectionURL.String(), strings.NewReader(webdavPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error listing collection: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, httpError(resp, "listing", "collection")
+	}
+	var multistatus webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("error listing collection: %w", err)
+	}
+	objects := make([]object, 0, len(multistatus.Responses))
+	for _, r := range multistatus.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil || strings.HasSuffix(href.Path, "/") || len(r.Propstat) == 0 {
+			continue // Collections, including the collection itself
+		}
+		prop := r.Propstat[0].Prop
+		if prop.ResourceType.Collection != nil {
+			continue
+		}
+		lastModified, _ := http.ParseTime(prop.LastModified)
+		objects = append(objects, object{
+			ID:           path.Base(href.Path),
+			Size:         prop.ContentLength,
+			LastModified: lastModified,
+		})
+	}
+	return objects, nil
+}
+
+func (b *webdavBackend) Delete(ids ...string) error {
+	for _, id := range ids {
+		req, err := b.newRequest(http.MethodDelete, b.objectURL(id), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("error deleting %s: %w", id, err)
+		}
+		resp.Body.Close()
+		if !httpSuccess(resp) && resp.StatusCode != http.StatusNotFound {
+			return httpError(resp, "deleting", id)
+		}
+	}
+	return nil
+}
+
+// DeleteIncomplete does nothing, since WebDAV uploads are a single request
+func (b *webdavBackend) DeleteIncomplete(_ time.Time, _ ...string) error {
+	return nil
+}
+
+// createCollection creates the collection and all its parents, if they do not exist yet. Servers respond
+// with 405 Method Not Allowed if a collection already exists.
+func (b *webdavBackend) createCollection() error {
+	segments := strings.Split(strings.Trim(b.collectionURL.Path, "/"), "/")
+	for i := range segments {
+		if segments[i] == "" {
+			continue
+		}
+		u := *b.collectionURL
+		u.Path = "/" + strings.Join(segments[:i+1], "/") + "/"
+		req, err := b.newRequest("MKCOL", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("webdav: error creating collection %s: %w", u.Path, err)
+		}
+		resp.Body.Close()
+		if !httpSuccess(resp) && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("webdav: error creating collection %s: unexpected HTTP %d", u.Path, resp.StatusCode)
+		}
+		log.Tag(tagWebDAV).Debug("Ensured collection %s exists", u.Path)
+	}
+	return nil
+}
+
+func (b *webdavBackend) newRequest(method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+	return req, nil
+}
+
+func (b *webdavBackend) objectURL(id string) string {
+	return b.collectionURL.String() + url.PathEscape(id)
+}
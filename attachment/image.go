@@ -0,0 +1,354 @@
+package attachment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // Register GIF decoder for thumbnails
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	thumbnailSuffix      = "-thumbnail" // Object ID suffix of thumbnails, e.g. AbCd1234EfGh-thumbnail
+	thumbnailMaxPixels   = 50_000_000   // Larger images are not decoded, to protect against decompression bombs
+	thumbnailJPEGQuality = 80
+	thumbnailSamples     = 4       // Max. number of samples per axis averaged for each thumbnail pixel
+	jpegMaxHeaderSize    = 4 << 20 // Max. size of all JPEG segments before the image data; larger headers are not stripped
+)
+
+// ErrThumbnailNotSupported is returned by Thumbnail if the image format is not supported, or if the image is too large
+var ErrThumbnailNotSupported = errors.New("image not supported for thumbnails")
+
+var (
+	errJPEGInvalid = errors.New("invalid or unsupported JPEG")
+
+	// pngMetadataChunks are the PNG chunks removed by StripMetadata
+	pngMetadataChunks = map[string]bool{
+		"eXIf": true,
+		"tEXt": true,
+		"zTXt": true,
+		"iTXt": true,
+		"tIME": true,
+	}
+)
+
+// StripMetadata returns a reader that removes metadata (EXIF, XMP, IPTC, comments and text chunks) from
+// JPEG and PNG images as they are read, without re-encoding the image. The EXIF orientation of JPEG images
+// is kept, so that photos are still displayed the right way up. If the content type is not supported,
+// the original reader is returned and ok is false. Malformed images are passed through unmodified.
+func StripMetadata(r io.Reader, contentType string) (reader io.Reader, ok bool) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(r), true
+	case "image/png":
+		return &pngStripReader{r: r}, true
+	}
+	return r, false
+}
+
+// Thumbnail decodes a JPEG, PNG or GIF image read from r, and returns a scaled-down copy that fits into
+// a square of maxSize pixels, along with its file extension. JPEG images result in JPEG thumbnails,
+// rotated according to their EXIF orientation; all other images result in PNG thumbnails to keep
+// transparency intact.
+func Thumbnail(r io.Reader, maxSize int) ([]byte, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png" && format != "gif") {
+		return nil, "", ErrThumbnailNotSupported
+	} else if int64(config.Width)*int64(config.Height) > thumbnailMaxPixels {
+		return nil, "", ErrThumbnailNotSupported
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		orientation, _ := filterJPEGHeader(bytes.NewReader(data), io.Discard)
+		if err := jpeg.Encode(&buf, orient(scale(img, maxSize), orientation), &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), ".jpg", nil
+	}
+	if err := png.Encode(&buf, scale(img, maxSize)); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), ".png", nil
+}
+
+// ThumbnailSupported returns true if a thumbnail can be generated for the given content type
+func ThumbnailSupported(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/gif"
+}
+
+// stripJPEGMetadata reads and filters all JPEG segments up to the start of the image data, and returns
+// a reader for the filtered segments, followed by the rest of the image. If the segments cannot be parsed,
+// the original bytes are returned instead.
+func stripJPEGMetadata(r io.Reader) io.Reader {
+	var raw, filtered bytes.Buffer
+	tee := io.TeeReader(io.LimitReader(r, jpegMaxHeaderSize), &raw)
+	if _, err := filterJPEGHeader(tee, &filtered); err != nil {
+		return io.MultiReader(&raw, r)
+	}
+	return io.MultiReader(&filtered, r)
+}
+
+// filterJPEGHeader copies all JPEG segments up to and including the start-of-scan marker from r to w,
+// except for metadata segments (APP1 EXIF/XMP, APP13 IPTC, comments). If the EXIF data contains an
+// orientation other than the default, a minimal EXIF segment with only the orientation is written in
+// its place. It returns the EXIF orientation (1-8), or 1 if there is none.
+func filterJPEGHeader(r io.Reader, w io.Writer) (int, error) {
+	orientation := 1
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xff || soi[1] != 0xd8 {
+		return orientation, errJPEGInvalid
+	}
+	w.Write(soi[:]) //nolint:errcheck
+	for {
+		var header [4]byte // Marker and segment length
+		if _, err := io.ReadFull(r, header[:2]); err != nil || header[0] != 0xff {
+			return orientation, errJPEGInvalid
+		}
+		if header[1] == 0xda { // Start of scan, the image data follows
+			w.Write(header[:2]) //nolint:errcheck
+			return orientation, nil
+		} else if header[1] == 0x01 || header[1] == 0xff || (header[1] >= 0xd0 && header[1] <= 0xd9) {
+			return orientation, errJPEGInvalid // Markers without length are not expected before the image data
+		}
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			return orientation, errJPEGInvalid
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return orientation, errJPEGInvalid
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return orientation, errJPEGInvalid
+		}
+		switch header[1] {
+		case 0xe1: // APP1, EXIF or XMP
+			if o := exifOrientation(payload); o > 1 {
+				orientation = o
+				writeJPEGSegment(w, 0xe1, exifWithOrientation(o))
+			}
+		case 0xed, 0xfe: // APP13 (IPTC), comment
+			// Skip
+		default:
+			w.Write(header[:]) //nolint:errcheck
+			w.Write(payload)   //nolint:errcheck
+		}
+	}
+}
+
+func writeJPEGSegment(w io.Writer, marker byte, payload []byte) {
+	header := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	w.Write(header)  //nolint:errcheck
+	w.Write(payload) //nolint:errcheck
+}
+
+// exifOrientation returns the orientation tag (1-8) from the first image directory of an EXIF APP1
+// payload, or 0 if the payload is not EXIF or has no valid orientation
+func exifOrientation(payload []byte) int {
+	tiff, ok := bytes.CutPrefix(payload, []byte("Exif\x00\x00"))
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int64(order.Uint32(tiff[4:8]))
+	if offset+2 > int64(len(tiff)) {
+		return 0
+	}
+	count := int64(order.Uint16(tiff[offset:]))
+	for i := int64(0); i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 { // Orientation, SHORT
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// exifWithOrientation returns a minimal EXIF APP1 payload that contains only the orientation tag
+func exifWithOrientation(orientation int) []byte {
+	b := make([]byte, 0, 32)
+	b = append(b, "Exif\x00\x00"...)
+	b = append(b, "MM\x00*"...)
+	b = binary.BigEndian.AppendUint32(b, 8) // Offset of first image directory
+	b = binary.BigEndian.AppendUint16(b, 1) // Number of entries
+	b = binary.BigEndian.AppendUint16(b, 0x0112)
+	b = binary.BigEndian.AppendUint16(b, 3) // SHORT
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(orientation))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0) // No next image directory
+	return b
+}
+
+// pngStripReader removes metadata chunks from a PNG image as it is read. It copies all other chunks
+// as-is, and passes malformed images through unmodified.
+type pngStripReader struct {
+	r           io.Reader
+	buf         bytes.Buffer // Pending output (signature, chunk headers)
+	remaining   int64        // Bytes of the current chunk (data and CRC) that still need to be copied
+	started     bool         // Signature has been read
+	passthrough bool         // Not a PNG, or malformed; copy the rest unmodified
+}
+
+func (p *pngStripReader) Read(b []byte) (int, error) {
+	for p.buf.Len() == 0 {
+		if p.passthrough {
+			return p.r.Read(b)
+		} else if p.remaining > 0 {
+			n, err := p.r.Read(b[:min(int64(len(b)), p.remaining)])
+			p.remaining -= int64(n)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		} else if err := p.next(); err != nil {
+			return 0, err
+		}
+	}
+	return p.buf.Read(b)
+}
+
+// next reads the next chunk header, and either queues it for output, or discards the entire chunk
+func (p *pngStripReader) next() error {
+	if !p.started {
+		p.started = true
+		signature := make([]byte, 8)
+		n, err := io.ReadFull(p.r, signature)
+		p.buf.Write(signature[:n])
+		if err != nil || string(signature) != "\x89PNG\r\n\x1a\n" {
+			p.passthrough = true
+		}
+		return nil
+	}
+	header := make([]byte, 8) // Length and chunk type
+	n, err := io.ReadFull(p.r, header)
+	if err == io.EOF {
+		return io.EOF
+	} else if err != nil {
+		p.buf.Write(header[:n])
+		p.passthrough = true
+		return nil
+	}
+	length := int64(binary.BigEndian.Uint32(header[:4]))
+	chunkType := string(header[4:])
+	if pngMetadataChunks[chunkType] {
+		if _, err := io.CopyN(io.Discard, p.r, length+4); err != nil { // Data and CRC
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+	p.buf.Write(header)
+	p.remaining = length + 4
+	return nil
+}
+
+// scale returns a copy of the image that fits into a square of maxSize pixels, keeping the aspect ratio.
+// Each pixel is the average of up to thumbnailSamples x thumbnailSamples samples from the source area
+// it covers, which is a good-enough approximation of an area average for thumbnails.
+func scale(src image.Image, maxSize int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			dw, dh = maxSize, max(1, h*maxSize/w)
+		} else {
+			dw, dh = max(1, w*maxSize/h), maxSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		ny := min(thumbnailSamples, sy1-sy0)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			nx := min(thumbnailSamples, sx1-sx0)
+			var r, g, b, a uint64
+			for j := 0; j < ny; j++ {
+				py := bounds.Min.Y + sy0 + (sy1-sy0)*(2*j+1)/(2*ny)
+				for i := 0; i < nx; i++ {
+					px := bounds.Min.X + sx0 + (sx1-sx0)*(2*i+1)/(2*nx)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+				}
+			}
+			n := uint64(nx * ny)
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// orient transforms the image according to the EXIF orientation (1-8), so that it is displayed the right way up
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 { // Rotated by 90 degrees, width and height are swapped
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // Rotated by 180 degrees
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Needs rotation by 90 degrees clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs rotation by 90 degrees counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// thumbnailID returns the object ID of the thumbnail of the given attachment
+func thumbnailID(id string) string {
+	return id + thumbnailSuffix
+}
+
+// attachmentID returns the attachment ID of a backend object, and whether the object is a thumbnail
+func attachmentID(objectID string) (string, bool) {
+	return strings.CutSuffix(objectID, thumbnailSuffix)
+}
@@ -0,0 +1,169 @@
+package attachment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripMetadata_JPEG(t *testing.T) {
+	original := newTestJPEG(t, 64, 32, 6)
+	require.Contains(t, string(original), "GPSLatitude")
+	require.Contains(t, string(original), "secret comment")
+
+	reader, ok := StripMetadata(bytes.NewReader(original), "image/jpeg")
+	require.True(t, ok)
+	stripped, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.NotContains(t, string(stripped), "GPSLatitude")
+	require.NotContains(t, string(stripped), "secret comment")
+	require.Less(t, len(stripped), len(original))
+
+	// Orientation is kept, and the image is still valid
+	orientation, err := filterJPEGHeader(bytes.NewReader(stripped), io.Discard)
+	require.Nil(t, err)
+	require.Equal(t, 6, orientation)
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	require.Nil(t, err)
+	require.Equal(t, 64, img.Bounds().Dx())
+}
+
+func TestStripMetadata_JPEGDefaultOrientation(t *testing.T) {
+	reader, ok := StripMetadata(bytes.NewReader(newTestJPEG(t, 16, 16, 1)), "image/jpeg")
+	require.True(t, ok)
+	stripped, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.NotContains(t, string(stripped), "Exif")
+	_, err = jpeg.Decode(bytes.NewReader(stripped))
+	require.Nil(t, err)
+}
+
+func TestStripMetadata_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 10))))
+	original := buf.Bytes()
+	ihdrEnd := 8 + 8 + 13 + 4 // Signature, IHDR header, IHDR data, CRC
+	original = append(original[:ihdrEnd:ihdrEnd], append(pngChunk("tEXt", []byte("Comment\x00secret comment")), original[ihdrEnd:]...)...)
+	original = append(original[:ihdrEnd:ihdrEnd], append(pngChunk("eXIf", []byte("MM\x00*GPSLatitude")), original[ihdrEnd:]...)...)
+
+	reader, ok := StripMetadata(bytes.NewReader(original), "image/png")
+	require.True(t, ok)
+	stripped, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.NotContains(t, string(stripped), "secret comment")
+	require.NotContains(t, string(stripped), "GPSLatitude")
+	require.Equal(t, buf.Len(), len(stripped))
+	img, err := png.Decode(bytes.NewReader(stripped))
+	require.Nil(t, err)
+	require.Equal(t, 20, img.Bounds().Dx())
+}
+
+func TestStripMetadata_Passthrough(t *testing.T) {
+	for _, contentType := range []string{"image/jpeg", "image/png"} {
+		reader, ok := StripMetadata(bytes.NewReader([]byte("not an image at all")), contentType)
+		require.True(t, ok)
+		data, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		require.Equal(t, "not an image at all", string(data))
+	}
+	reader, ok := StripMetadata(bytes.NewReader([]byte("some text")), "text/plain")
+	require.False(t, ok)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.Equal(t, "some text", string(data))
+}
+
+func TestThumbnail_JPEG(t *testing.T) {
+	thumbnail, ext, err := Thumbnail(bytes.NewReader(newTestJPEG(t, 1000, 500, 1)), 320)
+	require.Nil(t, err)
+	require.Equal(t, ".jpg", ext)
+	img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	require.Nil(t, err)
+	require.Equal(t, 320, img.Bounds().Dx())
+	require.Equal(t, 160, img.Bounds().Dy())
+}
+
+func TestThumbnail_JPEGRotated(t *testing.T) {
+	thumbnail, _, err := Thumbnail(bytes.NewReader(newTestJPEG(t, 1000, 500, 6)), 320)
+	require.Nil(t, err)
+	img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	require.Nil(t, err)
+	require.Equal(t, 160, img.Bounds().Dx())
+	require.Equal(t, 320, img.Bounds().Dy())
+}
+
+func TestThumbnail_PNGSmallTransparent(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 50, 40))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	require.Nil(t, png.Encode(&buf, src))
+	thumbnail, ext, err := Thumbnail(&buf, 320)
+	require.Nil(t, err)
+	require.Equal(t, ".png", ext)
+	img, err := png.Decode(bytes.NewReader(thumbnail))
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 50, 40), img.Bounds()) // Not scaled up
+	_, _, _, a := img.At(10, 10).RGBA()
+	require.Equal(t, uint32(0), a)
+	r, _, _, _ := img.At(0, 0).RGBA()
+	require.Equal(t, uint32(0xffff), r)
+}
+
+func TestThumbnail_NotSupported(t *testing.T) {
+	_, _, err := Thumbnail(bytes.NewReader([]byte("not an image")), 320)
+	require.Equal(t, ErrThumbnailNotSupported, err)
+}
+
+func TestExifOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		require.Equal(t, o, exifOrientation(exifWithOrientation(o)))
+	}
+	require.Equal(t, 0, exifOrientation([]byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")))
+	require.Equal(t, 0, exifOrientation([]byte("Exif\x00\x00II*\x00\xff\xff\xff\xff")))
+}
+
+func TestOrient(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.SetRGBA(0, 0, color.RGBA{R: 255, A: 255}) // Top left
+	expected := map[int]image.Point{
+		1: {0, 0},
+		2: {2, 0},
+		3: {2, 1},
+		4: {0, 1},
+		5: {0, 0},
+		6: {1, 0},
+		7: {1, 2},
+		8: {0, 2},
+	}
+	for orientation, p := range expected {
+		dst := orient(src, orientation)
+		require.Equal(t, uint8(255), dst.RGBAAt(p.X, p.Y).R, "orientation %d", orientation)
+	}
+}
+
+// newTestJPEG returns a JPEG image with an EXIF segment (orientation and a fake GPS marker) and a comment
+func newTestJPEG(t *testing.T, width, height, orientation int) []byte {
+	var buf bytes.Buffer
+	require.Nil(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil))
+	var b bytes.Buffer
+	b.Write(buf.Bytes()[:2]) // SOI
+	writeJPEGSegment(&b, 0xe1, append(exifWithOrientation(orientation), "GPSLatitude"...))
+	writeJPEGSegment(&b, 0xfe, []byte("secret comment"))
+	b.Write(buf.Bytes()[2:])
+	return b.Bytes()
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, chunkType...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+}
@@ -0,0 +1,56 @@
+package attachment
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const tagScanner = "attachment_scanner"
+
+// Scanner scans attachment files for viruses or other unwanted content, see Store.SetScanner
+type Scanner interface {
+	// Scan reads the file from the reader and returns nil if it is clean, an *InfectedError if it was
+	// rejected by the scanner, or any other error if the file could not be scanned
+	Scan(reader io.Reader) error
+}
+
+// InfectedError is returned by a Scanner if a file is infected, or was otherwise rejected by the scanner
+type InfectedError struct {
+	Signature string // Name of the virus or rule that matched, as reported by the scanner
+}
+
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("attachment rejected by scanner: %s", e.Signature)
+}
+
+// NewScanner creates a scanner from a URL. The type of scanner is determined by the URL scheme:
+//
+//	tcp://HOST:PORT                   ClamAV clamd, via TCP
+//	unix:///PATH/TO/SOCKET            ClamAV clamd, via a unix socket
+//	http[s]://[USER:PASS@]HOST/PATH   HTTP hook, see httpScanner
+//
+// The timeout applies to the entire scan of a file, including the upload to the scanner.
+func NewScanner(scanURL string, timeout time.Duration) (Scanner, error) {
+	u, err := url.Parse(scanURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner URL: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid scanner URL: host must be specified")
+		}
+		return newClamdScanner("tcp", u.Host, timeout), nil
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid scanner URL: socket path must be specified")
+		}
+		return newClamdScanner("unix", u.Path, timeout), nil
+	case "http", "https":
+		return newHTTPScanner(u, timeout), nil
+	default:
+		return nil, fmt.Errorf("invalid scanner URL: scheme must be 'tcp', 'unix', 'http' or 'https', got '%s'", u.Scheme)
+	}
+}
@@ -0,0 +1,102 @@
+package attachment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	clamdChunkSize    = 64 * 1024   // Size of the chunks sent to clamd, must be below clamd's StreamMaxLength
+	clamdMaxReplySize = 4 * 1024    // Replies are short, e.g. "stream: Eicar-Signature FOUND"
+	clamdCommand      = "zINSTREAM" // INSTREAM command, with NUL-terminated ("z") reply
+)
+
+// clamdScanner scans files with a ClamAV clamd daemon, using the INSTREAM command over TCP or a unix socket.
+// See https://docs.clamav.net/manual/Usage/Scanning.html#clamd for the protocol.
+type clamdScanner struct {
+	network string // "tcp" or "unix"
+	address string // HOST:PORT, or path to the unix socket
+	timeout time.Duration
+}
+
+var _ Scanner = (*clamdScanner)(nil)
+
+func newClamdScanner(network, address string, timeout time.Duration) *clamdScanner {
+	return &clamdScanner{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Scan streams the file to clamd in length-prefixed chunks, terminated by a zero-length chunk, and parses the reply
+func (s *clamdScanner) Scan(reader io.Reader) error {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	if err := s.stream(conn, reader); err != nil {
+		// clamd closes the connection if the file exceeds its StreamMaxLength, but it still sends a reply
+		if reply, replyErr := s.reply(conn); replyErr == nil {
+			return s.parse(reply)
+		}
+		return fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := s.reply(conn)
+	if err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	return s.parse(reply)
+}
+
+func (s *clamdScanner) stream(conn net.Conn, reader io.Reader) error {
+	if _, err := conn.Write([]byte(clamdCommand + "\x00")); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func (s *clamdScanner) reply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(io.LimitReader(conn, clamdMaxReplySize)).ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(reply, "\x00"), nil
+}
+
+// parse interprets a clamd reply, which is "stream: OK", "stream: <signature> FOUND", or "<message> ERROR"
+func (s *clamdScanner) parse(reply string) error {
+	result := strings.TrimPrefix(reply, "stream: ")
+	if result == "OK" {
+		return nil
+	} else if signature, ok := strings.CutSuffix(result, " FOUND"); ok {
+		return &InfectedError{Signature: signature}
+	}
+	return fmt.Errorf("clamd: unexpected reply: %s", reply)
+}
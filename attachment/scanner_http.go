@@ -0,0 +1,64 @@
+package attachment
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	httpScannerSignatureHeader = "X-Virus-ID" // As used by ICAP servers, e.g. "X-Virus-ID: Eicar-Signature"
+	httpScannerMaxBodySize     = 4 * 1024
+)
+
+// httpScanner scans files with a generic HTTP hook, similar to an ICAP server. The file is sent as the body of a
+// POST request. The hook responds with:
+//   - any 2xx status if the file is clean,
+//   - 403 Forbidden if the file is rejected, with the name of the virus or rule in the X-Virus-ID header, or else
+//     the first line of the response body,
+//   - any other status if the file could not be scanned.
+type httpScanner struct {
+	url    string // URL of the hook, including basic auth credentials, if any
+	client *http.Client
+}
+
+var _ Scanner = (*httpScanner)(nil)
+
+func newHTTPScanner(u *url.URL, timeout time.Duration) *httpScanner {
+	return &httpScanner{
+		url: u.String(),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (s *httpScanner) Scan(reader io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("scanner: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, httpScannerMaxBodySize))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	} else if resp.StatusCode != http.StatusForbidden {
+		return fmt.Errorf("scanner: unexpected response status %d", resp.StatusCode)
+	}
+	signature := resp.Header.Get(httpScannerSignatureHeader)
+	if signature == "" {
+		signature, _, _ = strings.Cut(strings.TrimSpace(string(body)), "\n")
+	}
+	if signature == "" {
+		signature = "unknown"
+	}
+	return &InfectedError{Signature: signature}
+}
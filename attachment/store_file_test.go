@@ -1,16 +1,277 @@
 package attachment
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/util"
 )
 
+func TestFileStore_PresignNotSupported(t *testing.T) {
+	_, s := newTestFileStore(t, 10*1024)
+	require.False(t, s.CanPresign())
+	_, err := s.PresignRead("abcdefghijkl", time.Minute, "")
+	require.Equal(t, ErrPresignNotSupported, err)
+	_, err = s.PresignWrite("abcdefghijkl", 10, time.Minute)
+	require.Equal(t, ErrPresignNotSupported, err)
+}
+
+func TestFileStore_Upload(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	require.True(t, s.CanResumeUploads())
+	minChunkSize, err := s.CreateUpload("abcdefghijkl", 11)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), minChunkSize)
+	require.Equal(t, int64(11), s.Size()) // Reserved right away
+
+	// First chunk
+	offset, err := s.WriteUploadChunk("abcdefghijkl", 0, strings.NewReader("hello "), 6)
+	require.Nil(t, err)
+	require.Equal(t, int64(6), offset)
+	_, err = s.FinishUpload("abcdefghijkl")
+	require.Equal(t, ErrUploadIncomplete, err)
+	require.NoFileExists(t, filepath.Join(dir, "abcdefghijkl"))
+
+	// Wrong offset, e.g. chunk sent twice
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, strings.NewReader("hello "), 6)
+	require.Equal(t, ErrUploadOffsetMismatch, err)
+	offset, length, err := s.UploadOffset("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, int64(6), offset)
+	require.Equal(t, int64(11), length)
+
+	// Last chunk completes the upload
+	offset, err = s.WriteUploadChunk("abcdefghijkl", 6, strings.NewReader("world"), 5)
+	require.Nil(t, err)
+	require.Equal(t, int64(11), offset)
+	object, err := s.FinishUpload("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, "abcdefghijkl", object.ID)
+	require.Equal(t, int64(11), object.Size)
+	require.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", object.SHA256)
+	require.Equal(t, int64(11), s.Size())
+
+	reader, _, err := s.Read("abcdefghijkl")
+	require.Nil(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	require.Equal(t, "hello world", string(data))
+
+	// Upload is gone
+	_, err = s.FinishUpload("abcdefghijkl")
+	require.Equal(t, ErrUploadNotFound, err)
+}
+
+func TestFileStore_UploadFailedChunkDiscarded(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	_, err := s.CreateUpload("abcdefghijkl", 11)
+	require.Nil(t, err)
+
+	// Client disconnects halfway through the chunk
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, strings.NewReader("hel"), 6)
+	require.Error(t, err)
+	offset, _, err := s.UploadOffset("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, int64(0), offset)
+	stat, err := os.Stat(filepath.Join(dir, "abcdefghijkl"+fileUploadSuffix))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), stat.Size())
+
+	// Limiter rejects the chunk
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, strings.NewReader("hello "), 6, util.NewFixedLimiter(3))
+	require.ErrorIs(t, err, util.ErrLimitReached)
+
+	// Retry succeeds, and the hash does not include the failed chunks
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, strings.NewReader("hello "), 6)
+	require.Nil(t, err)
+	_, err = s.WriteUploadChunk("abcdefghijkl", 6, strings.NewReader("world"), 5)
+	require.Nil(t, err)
+	object, err := s.FinishUpload("abcdefghijkl")
+	require.Nil(t, err)
+	require.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", object.SHA256)
+}
+
+func TestFileStore_UploadLimits(t *testing.T) {
+	_, s := newTestFileStore(t, 100)
+	_, err := s.CreateUpload("abcdefghijkl", 101)
+	require.Equal(t, util.ErrLimitReached, err)
+	_, err = s.CreateUpload("abcdefghijkl", 60)
+	require.Nil(t, err)
+	_, err = s.CreateUpload("mnopqrstuvwx", 41) // Only 40 bytes left
+	require.Equal(t, util.ErrLimitReached, err)
+
+	// Chunk exceeds the announced length
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, bytes.NewReader(make([]byte, 61)), 61)
+	require.Equal(t, util.ErrLimitReached, err)
+
+	// Abort releases the reserved size
+	require.Nil(t, s.AbortUpload("abcdefghijkl"))
+	require.Equal(t, int64(0), s.Size())
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, bytes.NewReader(make([]byte, 10)), 10)
+	require.Equal(t, ErrUploadNotFound, err)
+}
+
+func TestFileStore_UploadDeduplicated(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	_, err := s.WriteDeduplicated("abcdefghijkl", strings.NewReader("hello world"), 0)
+	require.Nil(t, err)
+
+	_, err = s.CreateUpload("mnopqrstuvwx", 11)
+	require.Nil(t, err)
+	_, err = s.WriteUploadChunk("mnopqrstuvwx", 0, strings.NewReader("hello world"), 11)
+	require.Nil(t, err)
+	object, err := s.FinishUpload("mnopqrstuvwx")
+	require.Nil(t, err)
+	require.Equal(t, "abcdefghijkl", object.ID)
+	require.NoFileExists(t, filepath.Join(dir, "mnopqrstuvwx"))
+	require.Equal(t, int64(11), s.Size())
+}
+
+func TestFileStore_UploadExpiredBySync(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir, 10*1024, time.Hour, nil, &testIndex{objects: map[string]*model.AttachmentObject{}})
+	require.Nil(t, err)
+	t.Cleanup(s.Close)
+
+	// Active and expired upload, and a leftover upload file from before a restart
+	_, err = s.CreateUpload("abcdefghijkl", 11)
+	require.Nil(t, err)
+	_, err = s.CreateUpload("mnopqrstuvwx", 11)
+	require.Nil(t, err)
+	_, err = s.WriteUploadChunk("mnopqrstuvwx", 0, strings.NewReader("hello "), 6)
+	require.Nil(t, err)
+	s.uploads["mnopqrstuvwx"].updated = time.Unix(1, 0)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "yzabcdefghij"+fileUploadSuffix), []byte("leftover"), 0600))
+	require.Nil(t, os.Chtimes(filepath.Join(dir, "yzabcdefghij"+fileUploadSuffix), time.Unix(1, 0), time.Unix(1, 0)))
+	require.Nil(t, os.Chtimes(filepath.Join(dir, "abcdefghijkl"+fileUploadSuffix), time.Unix(1, 0), time.Unix(1, 0)))
+
+	require.Nil(t, s.Sync())
+	require.Equal(t, int64(11), s.Size()) // Only the active upload is still reserved
+	require.FileExists(t, filepath.Join(dir, "abcdefghijkl"+fileUploadSuffix))
+	require.NoFileExists(t, filepath.Join(dir, "mnopqrstuvwx"+fileUploadSuffix))
+	require.NoFileExists(t, filepath.Join(dir, "yzabcdefghij"+fileUploadSuffix))
+	_, _, err = s.UploadOffset("mnopqrstuvwx")
+	require.Equal(t, ErrUploadNotFound, err)
+}
+
+func TestFileStore_UploadNotSupportedWithEncryption(t *testing.T) {
+	_, s := newTestEncryptedFileStore(t, 10*1024, newTestKeyring(t, testKey1))
+	require.False(t, s.CanResumeUploads())
+	_, err := s.CreateUpload("abcdefghijkl", 10)
+	require.Equal(t, ErrUploadNotSupported, err)
+}
+
+func TestFileStore_WriteDeduplicatedInfected(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	s.SetScanner(&testScanner{})
+	_, err := s.WriteDeduplicated("abcdefghijkl", strings.NewReader("this is fine"), 0)
+	require.Nil(t, err)
+
+	_, err = s.WriteDeduplicated("mnopqrstuvwx", strings.NewReader("this is EICAR"), 0)
+	var infected *InfectedError
+	require.True(t, errors.As(err, &infected))
+	require.Equal(t, "Test-Signature", infected.Signature)
+	require.NoFileExists(t, filepath.Join(dir, "mnopqrstuvwx"))
+	require.Equal(t, int64(12), s.Size())
+
+	// Identical upload is not deduplicated against the rejected file
+	_, err = s.WriteDeduplicated("yzabcdefghij", strings.NewReader("this is EICAR"), 0)
+	require.True(t, errors.As(err, &infected))
+}
+
+func TestFileStore_UploadInfected(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	s.SetScanner(&testScanner{})
+	_, err := s.CreateUpload("abcdefghijkl", 13)
+	require.Nil(t, err)
+	_, err = s.WriteUploadChunk("abcdefghijkl", 0, strings.NewReader("this is "), 8)
+	require.Nil(t, err)
+	_, err = s.WriteUploadChunk("abcdefghijkl", 8, strings.NewReader("EICAR"), 5)
+	var infected *InfectedError
+	require.True(t, errors.As(err, &infected))
+	require.NoFileExists(t, filepath.Join(dir, "abcdefghijkl"))
+	require.NoFileExists(t, filepath.Join(dir, "abcdefghijkl"+fileUploadSuffix))
+	require.Equal(t, int64(0), s.Size())
+	_, err = s.FinishUpload("abcdefghijkl")
+	require.Equal(t, ErrUploadNotFound, err)
+}
+
+func TestFileStore_ScanFailed(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	s.SetScanner(&testScanner{err: errors.New("scanner unavailable")})
+	_, err := s.WriteDeduplicated("abcdefghijkl", strings.NewReader("this is fine"), 0)
+	require.EqualError(t, err, "scanner unavailable")
+	require.NoFileExists(t, filepath.Join(dir, "abcdefghijkl"))
+	require.Equal(t, int64(0), s.Size())
+}
+
+func TestFileStore_Scan(t *testing.T) {
+	dir, s := newTestFileStore(t, 10*1024)
+	require.Nil(t, s.Scan("abcdefghijkl")) // No scanner set
+
+	// Written directly to the backend, e.g. via a presigned URL
+	s.SetScanner(&testScanner{})
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "abcdefghijkl"), []byte("this is fine"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "mnopqrstuvwx"), []byte("this is EICAR"), 0600))
+	require.Nil(t, s.Scan("abcdefghijkl"))
+	var infected *InfectedError
+	require.True(t, errors.As(s.Scan("mnopqrstuvwx"), &infected))
+	require.FileExists(t, filepath.Join(dir, "abcdefghijkl"))
+	require.NoFileExists(t, filepath.Join(dir, "mnopqrstuvwx"))
+}
+
+// testScanner rejects files containing "EICAR", or fails with err, if set
+type testScanner struct {
+	err error
+}
+
+func (s *testScanner) Scan(reader io.Reader) error {
+	if s.err != nil {
+		return s.err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	} else if bytes.Contains(data, []byte("EICAR")) {
+		return &InfectedError{Signature: "Test-Signature"}
+	}
+	return nil
+}
+
+// testIndex is an in-memory Index, with object ID -> object for all active attachments
+type testIndex struct {
+	objects map[string]*model.AttachmentObject
+	listed  int // Number of AttachmentObjects calls
+}
+
+func (i *testIndex) AttachmentObjects() (map[string]*model.AttachmentObject, error) {
+	i.listed++
+	return i.objects, nil
+}
+
+func (i *testIndex) ReferencedAttachmentObjects(ids []string) ([]string, error) {
+	referenced := make([]string, 0)
+	for _, id := range ids {
+		if _, ok := i.objects[id]; ok {
+			referenced = append(referenced, id)
+		}
+	}
+	return referenced, nil
+}
+
 func newTestFileStore(t *testing.T, totalSizeLimit int64) (dir string, cache *Store) {
 	t.Helper()
 	dir = t.TempDir()
-	cache, err := NewFileStore(dir, totalSizeLimit, time.Hour, nil)
+	cache, err := NewFileStore(dir, totalSizeLimit, time.Hour, nil, nil)
 	require.Nil(t, err)
 	t.Cleanup(func() { cache.Close() })
 	return dir, cache
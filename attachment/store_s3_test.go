@@ -3,6 +3,7 @@ package attachment
 import (
 	"context"
 	"io"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/s3"
+	"heckel.io/ntfy/v2/util"
 )
 
 func TestS3Store_WriteWithPrefix(t *testing.T) {
@@ -43,6 +45,42 @@ func TestS3Store_WriteWithPrefix(t *testing.T) {
 	require.Equal(t, "test", string(data))
 }
 
+func TestS3Store_Presign(t *testing.T) {
+	cfg, err := s3.ParseURL("s3://AKID:SECRET@mybucket/attachments?region=us-east-1")
+	require.Nil(t, err)
+	cache, err := newStore(newS3Backend(s3.New(cfg)), 100, time.Hour, nil)
+	require.Nil(t, err)
+	t.Cleanup(cache.Close)
+	require.True(t, cache.CanPresign())
+
+	// Presigned GET URL, with content disposition
+	getURL, err := cache.PresignRead("abcdefghijkl", 5*time.Minute, "some file.txt")
+	require.Nil(t, err)
+	u, err := url.Parse(getURL)
+	require.Nil(t, err)
+	require.Equal(t, "/attachments/abcdefghijkl", strings.TrimPrefix(u.Path, "/mybucket"))
+	require.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+	require.Equal(t, `attachment; filename="some file.txt"`, u.Query().Get("response-content-disposition"))
+	require.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+
+	// Presigned PUT URL reserves the size
+	putURL, err := cache.PresignWrite("mnopqrstuvwx", 60, 5*time.Minute)
+	require.Nil(t, err)
+	u, err = url.Parse(putURL)
+	require.Nil(t, err)
+	require.Equal(t, "content-length;host", u.Query().Get("X-Amz-SignedHeaders"))
+	require.Equal(t, int64(60), cache.Size())
+
+	// Exceeding the total size limit
+	_, err = cache.PresignWrite("yzabcdefghij", 41, 5*time.Minute)
+	require.Equal(t, util.ErrLimitReached, err)
+	require.Equal(t, int64(60), cache.Size())
+
+	// Invalid ID
+	_, err = cache.PresignRead("../etc/passwd", time.Minute, "")
+	require.Equal(t, errInvalidFileID, err)
+}
+
 // --- Helpers ---
 
 func newTestRealS3Store(t *testing.T, totalSizeLimit int64) (*Store, *modTimeOverrideBackend) {
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/model"
 	"heckel.io/ntfy/v2/util"
 )
 
@@ -43,6 +44,48 @@ func TestStore_WriteReadRemove(t *testing.T) {
 	})
 }
 
+func TestStore_ReadRange(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
+		_, err := s.Write("abcdefghijkl", strings.NewReader("hello world"), 0)
+		require.Nil(t, err)
+
+		reader, size, err := s.ReadRange("abcdefghijkl", 6, 3)
+		require.Nil(t, err)
+		require.Equal(t, int64(11), size)
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		require.Nil(t, err)
+		require.Equal(t, "wor", string(data))
+
+		reader, size, err = s.ReadRange("abcdefghijkl", 6, -1)
+		require.Nil(t, err)
+		require.Equal(t, int64(11), size)
+		data, err = io.ReadAll(reader)
+		reader.Close()
+		require.Nil(t, err)
+		require.Equal(t, "world", string(data))
+
+		_, _, err = s.ReadRange("notfound1234", 0, 1)
+		require.Error(t, err)
+	})
+}
+
+func TestStore_Stat(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
+		_, err := s.Write("abcdefghijkl", strings.NewReader("hello world"), 0)
+		require.Nil(t, err)
+
+		size, err := s.Stat("abcdefghijkl")
+		require.Nil(t, err)
+		require.Equal(t, int64(11), size)
+
+		_, err = s.Stat("notfound1234")
+		require.Error(t, err)
+		_, err = s.Stat("../etc/passwd")
+		require.Equal(t, errInvalidFileID, err)
+	})
+}
+
 func TestStore_WriteRemoveMultiple(t *testing.T) {
 	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
 		for i := 0; i < 5; i++ {
@@ -162,10 +205,8 @@ func TestStore_SyncRecomputesSize(t *testing.T) {
 		s.mu.Unlock()
 		require.Equal(t, int64(999), s.Size())
 
-		// Set attachmentsWithSizes to include both files so nothing gets deleted
-		s.attachmentsWithSizes = func() (map[string]int64, error) {
-			return map[string]int64{"abcdefghijk0": 100, "abcdefghijk1": 200}, nil
-		}
+		// Set index to include both files so nothing gets deleted
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{"abcdefghijk0": {ID: "abcdefghijk0", Size: 100}, "abcdefghijk1": {ID: "abcdefghijk1", Size: 200}}}
 
 		// Sync should recompute size from the backend
 		require.Nil(t, s.sync())
@@ -280,9 +321,7 @@ func TestStore_Sync(t *testing.T) {
 		require.Equal(t, int64(15), s.Size())
 
 		// Set the ID provider to only know about file 0 and 2
-		s.attachmentsWithSizes = func() (map[string]int64, error) {
-			return map[string]int64{"abcdefghijk0": 5, "abcdefghijk2": 5}, nil
-		}
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{"abcdefghijk0": {ID: "abcdefghijk0", Size: 5}, "abcdefghijk2": {ID: "abcdefghijk2", Size: 5}}}
 
 		// Make file 1 old enough to be cleaned up
 		makeOld("abcdefghijk1")
@@ -307,6 +346,57 @@ func TestStore_Sync(t *testing.T) {
 	})
 }
 
+func TestStore_WriteReadRemoveThumbnail(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
+		_, err := s.Write("abcdefghijkl", strings.NewReader("hello world"), 0)
+		require.Nil(t, err)
+		size, err := s.WriteThumbnail("abcdefghijkl", strings.NewReader("thumb"))
+		require.Nil(t, err)
+		require.Equal(t, int64(5), size)
+		require.Equal(t, int64(16), s.Size())
+
+		reader, readSize, err := s.ReadThumbnail("abcdefghijkl")
+		require.Nil(t, err)
+		require.Equal(t, int64(5), readSize)
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		require.Nil(t, err)
+		require.Equal(t, "thumb", string(data))
+
+		// Removing the attachment removes the thumbnail
+		require.Nil(t, s.Remove("abcdefghijkl"))
+		require.Equal(t, int64(0), s.Size())
+		_, _, err = s.ReadThumbnail("abcdefghijkl")
+		require.Error(t, err)
+	})
+}
+
+func TestStore_Sync_Thumbnails(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, makeOld func(string)) {
+		_, err := s.Write("abcdefghijk0", strings.NewReader("file0"), 0)
+		require.Nil(t, err)
+		_, err = s.WriteThumbnail("abcdefghijk0", strings.NewReader("thumb0"))
+		require.Nil(t, err)
+		_, err = s.Write("abcdefghijk1", strings.NewReader("file1"), 0)
+		require.Nil(t, err)
+		_, err = s.WriteThumbnail("abcdefghijk1", strings.NewReader("thumb1"))
+		require.Nil(t, err)
+
+		// Only file 0 is known, file 1 and its thumbnail are old orphans
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{"abcdefghijk0": {ID: "abcdefghijk0", Size: 5}}}
+		makeOld("abcdefghijk1")
+		makeOld("abcdefghijk1" + thumbnailSuffix)
+		require.Nil(t, s.sync())
+
+		_, _, err = s.ReadThumbnail("abcdefghijk1")
+		require.Error(t, err)
+		reader, _, err := s.ReadThumbnail("abcdefghijk0")
+		require.Nil(t, err)
+		reader.Close()
+		require.Equal(t, int64(11), s.Size()) // File and thumbnail
+	})
+}
+
 func TestStore_Sync_SkipsRecentFiles(t *testing.T) {
 	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
 		// Write a file
@@ -314,9 +404,7 @@ func TestStore_Sync_SkipsRecentFiles(t *testing.T) {
 		require.Nil(t, err)
 
 		// Set the ID provider to return empty (no valid IDs)
-		s.attachmentsWithSizes = func() (map[string]int64, error) {
-			return map[string]int64{}, nil
-		}
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{}}
 
 		// File was just created, so it should NOT be deleted (< 1 hour old)
 		require.Nil(t, s.sync())
@@ -328,11 +416,105 @@ func TestStore_Sync_SkipsRecentFiles(t *testing.T) {
 	})
 }
 
-// forEachBackend runs f against both the file and S3 backends. It also provides a makeOld
-// callback that makes a specific object's timestamp old enough for orphan cleanup (> 1 hour).
-// For the file backend, this uses os.Chtimes; for the S3 backend, it overrides the object's
-// LastModified time via a modTimeOverrideBackend wrapper. Objects start with recent timestamps
-// by default. The S3 subtest is skipped if NTFY_TEST_S3_URL is not set.
+func TestStore_WriteDeduplicated(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
+		object0, err := s.WriteDeduplicated("abcdefghijk0", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+		require.Equal(t, "abcdefghijk0", object0.ID)
+		require.Equal(t, int64(12), object0.Size)
+		require.Len(t, object0.SHA256, 64)
+
+		// Identical file is discarded, and the existing object is returned
+		object1, err := s.WriteDeduplicated("abcdefghijk1", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+		require.Equal(t, "abcdefghijk0", object1.ID)
+		require.Equal(t, object0.SHA256, object1.SHA256)
+		require.Equal(t, int64(12), s.Size())
+		_, err = s.Stat("abcdefghijk1")
+		require.Error(t, err)
+
+		// Different file is stored separately
+		object2, err := s.WriteDeduplicated("abcdefghijk2", strings.NewReader("other content"), 0)
+		require.Nil(t, err)
+		require.Equal(t, "abcdefghijk2", object2.ID)
+		require.NotEqual(t, object0.SHA256, object2.SHA256)
+		require.Equal(t, int64(25), s.Size())
+
+		reader, _, err := s.Read(object1.ID)
+		require.Nil(t, err)
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		require.Nil(t, err)
+		require.Equal(t, "same content", string(data))
+	})
+}
+
+func TestStore_RemoveReferenced(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, _ func(string)) {
+		_, err := s.WriteDeduplicated("abcdefghijk0", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+		_, err = s.WriteDeduplicated("abcdefghijk1", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+
+		// Object is still referenced by another message, so it is kept
+		s.orphanGracePeriod = 0
+		index := &testIndex{objects: map[string]*model.AttachmentObject{"abcdefghijk0": {ID: "abcdefghijk0", Size: 12}}}
+		s.index = index
+		require.Nil(t, s.Remove("abcdefghijk0"))
+		require.Equal(t, 0, index.listed) // Only the removed IDs are looked up
+		size, err := s.Stat("abcdefghijk0")
+		require.Nil(t, err)
+		require.Equal(t, int64(12), size)
+		require.Equal(t, int64(12), s.Size())
+
+		// Last reference is gone, object is deleted
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{}}
+		require.Nil(t, s.Remove("abcdefghijk0"))
+		_, err = s.Stat("abcdefghijk0")
+		require.Error(t, err)
+		require.Equal(t, int64(0), s.Size())
+
+		// Hash index entry is gone, so the same content is stored again
+		object, err := s.WriteDeduplicated("abcdefghijk2", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+		require.Equal(t, "abcdefghijk2", object.ID)
+	})
+}
+
+func TestStore_Sync_Deduplicated(t *testing.T) {
+	forEachBackend(t, testSizeLimit, func(t *testing.T, s *Store, makeOld func(string)) {
+		object, err := s.WriteDeduplicated("abcdefghijk0", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+
+		// Two messages reference the same object, which is counted once
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{"abcdefghijk0": {ID: "abcdefghijk0", Size: 12, SHA256: object.SHA256}}}
+		makeOld("abcdefghijk0")
+		require.Nil(t, s.sync())
+		require.Equal(t, int64(12), s.Size())
+
+		// Hash index is rebuilt from the database
+		s.mu.Lock()
+		s.hashes = make(map[string]string)
+		s.mu.Unlock()
+		require.Nil(t, s.sync())
+		object, err = s.WriteDeduplicated("abcdefghijk1", strings.NewReader("same content"), 0)
+		require.Nil(t, err)
+		require.Equal(t, "abcdefghijk0", object.ID)
+
+		// Reused object is not deleted until the new message is in the database, even if it is old
+		s.index = &testIndex{objects: map[string]*model.AttachmentObject{}}
+		require.Nil(t, s.sync())
+		_, err = s.Stat("abcdefghijk0")
+		require.Nil(t, err)
+	})
+}
+
+// forEachBackend runs f against the file, encrypted file, S3, WebDAV, Azure and GCS backends. It also provides
+// a makeOld callback that makes a specific object's timestamp old enough for orphan cleanup (> 1 hour).
+// For the file backends, this uses os.Chtimes; for the S3 backend, it overrides the object's
+// LastModified time via a modTimeOverrideBackend wrapper; for the WebDAV, Azure and GCS backends, it
+// changes the object in the fake server. Objects start with recent timestamps by default. The S3 subtest
+// is skipped if NTFY_TEST_S3_URL is not set.
 func forEachBackend(t *testing.T, totalSizeLimit int64, f func(t *testing.T, s *Store, makeOld func(string))) {
 	t.Run("file", func(t *testing.T) {
 		dir, s := newTestFileStore(t, totalSizeLimit)
@@ -342,6 +524,14 @@ func forEachBackend(t *testing.T, totalSizeLimit int64, f func(t *testing.T, s *
 		}
 		f(t, s, makeOld)
 	})
+	t.Run("file_encrypted", func(t *testing.T) {
+		dir, s := newTestEncryptedFileStore(t, totalSizeLimit, newTestKeyring(t, testKey1))
+		makeOld := func(id string) {
+			oldTime := time.Unix(1, 0)
+			os.Chtimes(filepath.Join(dir, id), oldTime, oldTime)
+		}
+		f(t, s, makeOld)
+	})
 	t.Run("s3", func(t *testing.T) {
 		s, wrapper := newTestRealS3Store(t, totalSizeLimit)
 		makeOld := func(id string) {
@@ -349,4 +539,25 @@ func forEachBackend(t *testing.T, totalSizeLimit int64, f func(t *testing.T, s *
 		}
 		f(t, s, makeOld)
 	})
+	t.Run("webdav", func(t *testing.T) {
+		s, server := newTestWebDAVStore(t, totalSizeLimit)
+		makeOld := func(id string) {
+			server.store.setModTime("/dav/ntfy/"+id, time.Unix(1, 0))
+		}
+		f(t, s, makeOld)
+	})
+	t.Run("azure", func(t *testing.T) {
+		s, server := newTestAzureStore(t, totalSizeLimit)
+		makeOld := func(id string) {
+			server.store.setModTime("attachments/"+id, time.Unix(1, 0))
+		}
+		f(t, s, makeOld)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		s, server := newTestGCSStore(t, totalSizeLimit)
+		makeOld := func(id string) {
+			server.store.setModTime("attachments/"+id, time.Unix(1, 0))
+		}
+		f(t, s, makeOld)
+	})
 }
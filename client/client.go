@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"heckel.io/ntfy/v2/encryption"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
 	"io"
@@ -20,10 +21,13 @@ import (
 const (
 	// MessageEvent identifies a message event
 	MessageEvent = "message"
+
+	// EncodingJWE identifies an end-to-end encrypted message (see WithEncryption and Message.Decrypt)
+	EncodingJWE = "jwe"
 )
 
 const (
-	maxResponseBytes = 4096
+	maxResponseBytes = 16384 // Encrypted messages are about 4/3 of the size of the plaintext
 )
 
 var (
@@ -51,6 +55,7 @@ type Message struct { // TODO combine with server.message
 	Click      string
 	Icon       string
 	Attachment *Attachment
+	Encoding   string
 
 	// Additional fields
 	TopicURL       string
@@ -68,6 +73,43 @@ type Attachment struct {
 	Owner   string `json:"-"` // IP address of uploader, used for rate limiting
 }
 
+// Decrypt decrypts an end-to-end encrypted message (see WithEncryption), using the topic key derived from the given
+// encryption key and the topic URL. The message body is replaced with the plaintext, and the raw JSON message is
+// updated accordingly. Messages that are not encrypted are left untouched.
+func (m *Message) Decrypt(key string) error {
+	if m.Encoding != EncodingJWE {
+		return nil
+	}
+	topicKey, err := encryption.DeriveTopicKey(key, m.TopicURL)
+	if err != nil {
+		return err
+	}
+	plaintext, err := encryption.DecryptJWE(m.Message, topicKey)
+	if err != nil {
+		return err
+	}
+	m.Message = string(plaintext)
+	m.Encoding = ""
+	if m.Raw != "" {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(m.Raw), &raw); err != nil {
+			return err
+		}
+		message, err := json.Marshal(m.Message)
+		if err != nil {
+			return err
+		}
+		raw["message"] = message
+		delete(raw, "encoding")
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		m.Raw = string(b)
+	}
+	return nil
+}
+
 type subscription struct {
 	ID       string
 	topicURL string
@@ -213,6 +255,21 @@ func (c *Client) Unsubscribe(subscriptionID string) {
 	sub.cancel()
 }
 
+// EncryptionKey returns the end-to-end encryption key for the given topic, as defined in the "subscribe:" block
+// of the config, or an empty string if no key is defined. The topic is expanded like in PublishReader.
+func (c *Client) EncryptionKey(topic string) string {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return ""
+	}
+	for _, s := range c.config.Subscribe {
+		if subscribeTopicURL, err := c.expandTopicURL(s.Topic); err == nil && subscribeTopicURL == topicURL {
+			return s.EncryptionKey
+		}
+	}
+	return ""
+}
+
 func (c *Client) expandTopicURL(topic string) (string, error) {
 	if strings.HasPrefix(topic, "http://") || strings.HasPrefix(topic, "https://") {
 		return topic, nil
@@ -101,6 +101,35 @@ func TestClient_Publish_Poll(t *testing.T) {
 	require.Equal(t, "some delayed message", messages[1].Message)
 }
 
+func TestClient_Publish_Poll_Encrypted(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	conf := newTestConfig(port)
+	conf.Subscribe = []client.Subscribe{{Topic: "mytopic", EncryptionKey: "secret"}}
+	c := client.New(conf)
+	require.Equal(t, "secret", c.EncryptionKey("mytopic"))
+	require.Equal(t, "secret", c.EncryptionKey(fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)))
+	require.Equal(t, "", c.EncryptionKey("othertopic"))
+
+	msg, err := c.Publish("mytopic", "some secret message", client.WithTitle("some title"), client.WithEncryption("secret"))
+	require.Nil(t, err)
+	require.Equal(t, client.EncodingJWE, msg.Encoding)
+	require.NotContains(t, msg.Message, "secret")
+	require.Equal(t, "some title", msg.Title)
+
+	messages, err := c.Poll("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, client.EncodingJWE, messages[0].Encoding)
+	require.Error(t, messages[0].Decrypt("wrong key"))
+
+	require.Nil(t, messages[0].Decrypt("secret"))
+	require.Equal(t, "some secret message", messages[0].Message)
+	require.Equal(t, "", messages[0].Encoding)
+	require.Contains(t, messages[0].Raw, `"message":"some secret message"`)
+	require.NotContains(t, messages[0].Raw, `"encoding"`)
+}
+
 func newTestConfig(port int) *client.Config {
 	c := client.NewConfig()
 	c.DefaultHost = fmt.Sprintf("http://127.0.0.1:%d", port)
@@ -26,12 +26,13 @@ type Config struct {
 
 // Subscribe is the struct for a Subscription within Config
 type Subscribe struct {
-	Topic    string            `yaml:"topic"`
-	User     *string           `yaml:"user"`
-	Password *string           `yaml:"password"`
-	Token    *string           `yaml:"token"`
-	Command  string            `yaml:"command"`
-	If       map[string]string `yaml:"if"`
+	Topic         string            `yaml:"topic"`
+	User          *string           `yaml:"user"`
+	Password      *string           `yaml:"password"`
+	Token         *string           `yaml:"token"`
+	EncryptionKey string            `yaml:"encryption-key"`
+	Command       string            `yaml:"command"`
+	If            map[string]string `yaml:"if"`
 }
 
 // NewConfig creates a new Config struct for a Client
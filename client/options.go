@@ -1,8 +1,11 @@
 package client
 
 import (
+	"bytes"
 	"fmt"
+	"heckel.io/ntfy/v2/encryption"
 	"heckel.io/ntfy/v2/util"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -98,6 +101,45 @@ func WithEmail(email string) PublishOption {
 	return WithHeader("X-Email", email)
 }
 
+// WithEncryption encrypts the message body end-to-end, using a topic key derived from the given encryption key
+// (password) and the topic URL, so that the server never sees the plaintext message. The title, tags and other
+// fields are not encrypted. Encrypted messages cannot be combined with attachments, templates or e-mail
+// notifications. See https://ntfy.sh/docs/publish/#end-to-end-encryption for details.
+func WithEncryption(key string) PublishOption {
+	return func(r *http.Request) error {
+		if key == "" {
+			return nil
+		}
+		var plaintext []byte
+		if r.Body != nil {
+			var err error
+			if plaintext, err = io.ReadAll(r.Body); err != nil {
+				return err
+			}
+			_ = r.Body.Close()
+		}
+		if len(plaintext) == 0 && r.Header.Get("X-Message") != "" {
+			plaintext = []byte(r.Header.Get("X-Message"))
+			r.Header.Del("X-Message")
+		}
+		topicKey, err := encryption.DeriveTopicKey(key, topicURLFromRequest(r))
+		if err != nil {
+			return err
+		}
+		message, err := encryption.EncryptJWE(plaintext, topicKey)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(strings.NewReader(message))
+		r.ContentLength = int64(len(message))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader([]byte(message))), nil
+		}
+		r.Header.Set("X-Encoding", EncodingJWE)
+		return nil
+	}
+}
+
 // WithBasicAuth adds the Authorization header for basic auth to the request
 func WithBasicAuth(user, pass string) PublishOption {
 	return WithHeader("Authorization", util.BasicAuth(user, pass))
@@ -213,3 +255,8 @@ func RemoveHeader(header string) RequestOption {
 		return nil
 	}
 }
+
+// topicURLFromRequest returns the topic URL of a request, without query parameters (see Message.TopicURL)
+func topicURLFromRequest(r *http.Request) string {
+	return fmt.Sprintf("%s://%s%s", r.URL.Scheme, r.URL.Host, r.URL.Path)
+}
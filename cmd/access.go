@@ -8,6 +8,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"strings"
 )
 
 func init() {
@@ -112,9 +113,14 @@ func changeAccess(c *cli.Context, manager *user.Manager, username string, topic
 	} else if u.Role == user.RoleAdmin {
 		return fmt.Errorf("user %s is an admin user, access control entries have no effect", username)
 	}
+	grants, err := manager.Grants(username)
+	if err != nil {
+		return err
+	}
 	if err := manager.AllowAccess(username, topic, permission); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionAccessAllow, username, auditGrantValue(grants, topic), user.FormatGrant(topic, permission))
 	if permission.IsReadWrite() {
 		fmt.Fprintf(c.App.Writer, "granted read-write access to topic %s\n\n", topic)
 	} else if permission.IsRead() {
@@ -140,6 +146,7 @@ func resetAllAccess(c *cli.Context, manager *user.Manager) error {
 	if err := manager.ResetAccess("", ""); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionAccessReset, "*", "", "")
 	fmt.Fprintln(c.App.Writer, "reset access for all users")
 	return nil
 }
@@ -148,14 +155,20 @@ func resetUserAccess(c *cli.Context, manager *user.Manager, username string) err
 	if err := manager.ResetAccess(username, ""); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionAccessReset, username, "", "")
 	fmt.Fprintf(c.App.Writer, "reset access for user %s\n\n", username)
 	return showUserAccess(c, manager, username)
 }
 
 func resetUserTopicAccess(c *cli.Context, manager *user.Manager, username string, topic string) error {
+	grants, err := manager.Grants(username)
+	if err != nil {
+		return err
+	}
 	if err := manager.ResetAccess(username, topic); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionAccessReset, username, auditGrantValue(grants, topic), "")
 	fmt.Fprintf(c.App.Writer, "reset access for user %s and topic %s\n\n", username, topic)
 	return showUserAccess(c, manager, username)
 }
@@ -200,6 +213,14 @@ func showUsers(c *cli.Context, manager *user.Manager, users []*user.User) error
 			provisioned = ", server config"
 		}
 		fmt.Fprintf(c.App.Writer, "user %s (role: %s, tier: %s%s)\n", u.Name, u.Role, tier, provisioned)
+		if u.Name != user.Everyone {
+			groups, err := manager.UserGroups(u.Name)
+			if err != nil {
+				return err
+			} else if len(groups) > 0 {
+				fmt.Fprintf(c.App.Writer, "- member of groups: %s\n", strings.Join(groups, ", "))
+			}
+		}
 		if u.Role == user.RoleAdmin {
 			fmt.Fprintf(c.App.Writer, "- read-write access to all topics (admin role)\n")
 		} else if len(grants) > 0 {
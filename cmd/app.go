@@ -2,13 +2,16 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
 )
 
 const (
@@ -32,9 +35,24 @@ var flagsDefault = []cli.Flag{
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "log-level-overrides", Aliases: []string{"log_level_overrides"}, EnvVars: []string{"NTFY_LOG_LEVEL_OVERRIDES"}, Usage: "set log level overrides"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-format", Aliases: []string{"log_format"}, Value: log.TextFormat.String(), EnvVars: []string{"NTFY_LOG_FORMAT"}, Usage: "set log format"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-file", Aliases: []string{"log_file"}, EnvVars: []string{"NTFY_LOG_FILE"}, Usage: "set log file, default is STDOUT"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-file-max-size", Aliases: []string{"log_file_max_size"}, EnvVars: []string{"NTFY_LOG_FILE_MAX_SIZE"}, Usage: "rotate log file when it reaches this size (e.g. 100M), default is no size-based rotation"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-file-rotate-interval", Aliases: []string{"log_file_rotate_interval"}, EnvVars: []string{"NTFY_LOG_FILE_ROTATE_INTERVAL"}, Usage: "rotate log file at this interval (e.g. 24h), default is no time-based rotation"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "log-file-max-backups", Aliases: []string{"log_file_max_backups"}, EnvVars: []string{"NTFY_LOG_FILE_MAX_BACKUPS"}, Usage: "number of rotated log files to keep, default is all"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "log-file-compress", Aliases: []string{"log_file_compress"}, EnvVars: []string{"NTFY_LOG_FILE_COMPRESS"}, Usage: "compress rotated log files with gzip"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-sink", Aliases: []string{"log_sink"}, EnvVars: []string{"NTFY_LOG_SINK"}, Usage: "set log sink (stderr, file, syslog or journald), default is file if log-file is set, stderr otherwise"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "log-syslog-address", Aliases: []string{"log_syslog_address"}, Value: log.DefaultSyslogAddress, EnvVars: []string{"NTFY_LOG_SYSLOG_ADDRESS"}, Usage: "syslog address for the syslog log sink (udp://host:port, tcp://host:port or unix:///path)"}),
 }
 
+// Log sinks, see "log-sink" option
+const (
+	logSinkStderr   = "stderr"
+	logSinkFile     = "file"
+	logSinkSyslog   = "syslog"
+	logSinkJournald = "journald"
+)
+
 var (
+	logCloser             io.Closer // Log file or sink opened by initLogFunc, closed if initLogFunc is called again
 	logLevelOverrideRegex = regexp.MustCompile(`(?i)^([^=\s]+)(?:\s*=\s*(\S+))?\s*->\s*(TRACE|DEBUG|INFO|WARN|ERROR)$`)
 )
 
@@ -69,14 +87,67 @@ func initLogFunc(c *cli.Context) error {
 	if err := applyLogLevelOverrides(c.StringSlice("log-level-overrides")); err != nil {
 		return err
 	}
-	logFile := c.String("log-file")
-	if logFile != "" {
-		w, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	return initLogSink(c)
+}
+
+func initLogSink(c *cli.Context) error {
+	logSink, logFile := c.String("log-sink"), c.String("log-file")
+	if logSink == "" && logFile != "" {
+		logSink = logSinkFile // Backwards compatibility: log-file without log-sink
+	}
+	if logSink == "" {
+		return nil
+	}
+	var closer io.Closer
+	switch logSink {
+	case logSinkStderr:
+		log.SetOutput(log.DefaultOutput)
+	case logSinkFile:
+		if logFile == "" {
+			return errors.New("if log-sink is file, log-file must be set")
+		}
+		rotateConfig := &log.RotateConfig{
+			MaxBackups: c.Int("log-file-max-backups"),
+			Compress:   c.Bool("log-file-compress"),
+		}
+		var err error
+		if maxSize := c.String("log-file-max-size"); maxSize != "" {
+			if rotateConfig.MaxSize, err = util.ParseSize(maxSize); err != nil {
+				return fmt.Errorf("invalid log-file-max-size: %s", err.Error())
+			}
+		}
+		if interval := c.String("log-file-rotate-interval"); interval != "" {
+			if rotateConfig.Interval, err = util.ParseDuration(interval); err != nil {
+				return fmt.Errorf("invalid log-file-rotate-interval: %s", err.Error())
+			}
+		}
+		w, err := log.NewRotatingFile(logFile, rotateConfig)
 		if err != nil {
 			return err
 		}
 		log.SetOutput(w)
+		closer = w
+	case logSinkSyslog:
+		sink, err := log.NewSyslogSink(c.String("log-syslog-address"))
+		if err != nil {
+			return err
+		}
+		log.SetSink(sink)
+		closer = sink
+	case logSinkJournald:
+		sink, err := log.NewJournaldSink(log.DefaultJournaldSocket)
+		if err != nil {
+			return err
+		}
+		log.SetSink(sink)
+		closer = sink
+	default:
+		return fmt.Errorf("invalid log-sink %q, must be %s, %s, %s or %s", logSink, logSinkStderr, logSinkFile, logSinkSyslog, logSinkJournald)
+	}
+	if logCloser != nil {
+		logCloser.Close()
 	}
+	logCloser = closer
 	return nil
 }
 
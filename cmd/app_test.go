@@ -3,10 +3,12 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/log"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -34,3 +36,20 @@ func toMessage(t *testing.T, s string) *client.Message {
 	}
 	return m
 }
+
+func TestCLI_LogSink_File(t *testing.T) {
+	t.Cleanup(func() {
+		log.SetOutput(log.DefaultOutput)
+	})
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	app, _, _, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "--log-file", filename, "--log-file-max-size", "1M", "--log-file-max-backups", "3"}))
+	require.Equal(t, filename, log.File())
+}
+
+func TestCLI_LogSink_Invalid(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	require.EqualError(t, app.Run([]string{"ntfy", "--log-sink", "carrier-pigeon"}), `invalid log-sink "carrier-pigeon", must be stderr, file, syslog or journald`)
+	require.EqualError(t, app.Run([]string{"ntfy", "--log-sink", "file"}), "if log-sink is file, log-file must be set")
+	require.Error(t, app.Run([]string{"ntfy", "--log-file", filepath.Join(t.TempDir(), "ntfy.log"), "--log-file-max-size", "lots"}))
+}
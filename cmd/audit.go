@@ -0,0 +1,142 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	osuser "os/user"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdAudit)
+}
+
+var flagsAudit = append(
+	append([]cli.Flag{}, flagsUser...),
+	&cli.StringFlag{Name: "actor", Usage: "only show changes made by this user"},
+	&cli.StringFlag{Name: "target", Usage: "only show changes of this user or group"},
+	&cli.StringFlag{Name: "action", Usage: "only show actions with this prefix, e.g. 'access' or 'token.create'"},
+	&cli.StringFlag{Name: "since", Usage: "only show changes since this time, e.g. '24h' or a Unix timestamp"},
+	&cli.IntFlag{Name: "limit", Aliases: []string{"n"}, Value: 100, Usage: "show at most this many entries"},
+)
+
+var cmdAudit = &cli.Command{
+	Name:      "audit",
+	Usage:     "Shows the audit log of account, access control and token changes",
+	UsageText: "ntfy audit [--actor=..] [--target=..] [--action=..] [--since=..] [--limit=..]",
+	Flags:     flagsAudit,
+	Before:    initConfigFileInputSourceFunc("config", flagsAudit, initLogFunc),
+	Action:    execAudit,
+	Category:  categoryServer,
+	Description: `Shows the audit log of the ntfy server, newest entries first.
+
+The audit log records who added or removed users, changed passwords, roles or tiers, granted
+or revoked access, reserved topics, or created and removed access tokens. Changes are recorded
+regardless of whether they were made via the CLI (e.g. 'ntfy user' or 'ntfy access'), the
+admin API or the account API (e.g. the web app). Token values are never fully recorded.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy audit                              # Shows the last 100 entries
+  ntfy audit --target=phil --since=7d     # Shows all changes of user phil in the last 7 days
+  ntfy audit --action=access -n 1000      # Shows the last 1000 access control changes
+`,
+}
+
+func execAudit(c *cli.Context) error {
+	filter := &user.AuditFilter{
+		Actor:  c.String("actor"),
+		Target: c.String("target"),
+		Action: c.String("action"),
+		Limit:  c.Int("limit"),
+	}
+	if filter.Limit <= 0 {
+		return errors.New("limit must be a positive number")
+	}
+	if since := c.String("since"); since != "" {
+		if ts, err := strconv.ParseInt(since, 10, 64); err == nil {
+			filter.Since = time.Unix(ts, 0)
+		} else if d, err := util.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else {
+			return errors.New("since must be a Unix timestamp or a duration, e.g. 24h")
+		}
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	entries, err := manager.AuditEntries(filter)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(c.App.ErrWriter, "no audit log entries found")
+		return nil
+	}
+	for _, entry := range entries {
+		printAuditEntry(c, entry)
+	}
+	return nil
+}
+
+func printAuditEntry(c *cli.Context, entry *user.AuditEntry) {
+	actor := entry.Actor
+	if actor == "" {
+		actor = "anonymous"
+	}
+	source := string(entry.Source)
+	if entry.Origin.IsValid() {
+		source += ", " + entry.Origin.String()
+	}
+	fmt.Fprintf(c.App.Writer, "%s %s: %s of %s (%s)", entry.Time.Format(time.DateTime), actor, entry.Action, entry.Target, source)
+	if entry.Before != "" || entry.After != "" {
+		fmt.Fprintf(c.App.Writer, ": %s -> %s", auditValue(entry.Before), auditValue(entry.After))
+	}
+	fmt.Fprintln(c.App.Writer)
+}
+
+func auditValue(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// auditCLI records a change made via the CLI in the audit log. The actor is the operating system user
+// running the command. Since the change has already been made, errors are only logged.
+func auditCLI(manager *user.Manager, action user.AuditAction, target, before, after string) {
+	entry := &user.AuditEntry{
+		Source: user.AuditSourceCLI,
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+	}
+	if u, err := osuser.Current(); err == nil {
+		entry.Actor = u.Username
+	}
+	if err := manager.AddAuditEntry(entry); err != nil {
+		log.Tag("audit").Err(err).Warn("Unable to write audit log entry")
+	}
+}
+
+// auditGrantValue returns the "before" value of an access control entry for the audit log
+func auditGrantValue(grants []user.Grant, topic string) string {
+	for _, grant := range grants {
+		if grant.TopicPattern == topic {
+			return user.FormatGrant(grant.TopicPattern, grant.Permission)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Audit(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runAuditCommand(app, conf))
+	require.Equal(t, "no audit log entries found\n", stderr.String())
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf, "phil", "mytopic", "ro"))
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf, "phil", "mytopic", "rw"))
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "phil"))
+	token := strings.Fields(stdout.String())[1]
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAuditCommand(app, conf))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 4, len(lines))
+	require.Contains(t, lines[0], "token.create of phil (cli): (none) -> "+token[:8]+"...")
+	require.NotContains(t, lines[0], token)
+	require.Contains(t, lines[1], "access.allow of phil (cli): mytopic:read-only -> mytopic:read-write")
+	require.Contains(t, lines[2], "access.allow of phil (cli): (none) -> mytopic:read-only")
+	require.Contains(t, lines[3], "user.add of phil (cli): (none) -> user")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAuditCommand(app, conf, "--action=access", "--limit=1", "--since=1h"))
+	require.Contains(t, stdout.String(), "mytopic:read-only -> mytopic:read-write")
+	require.Equal(t, 1, strings.Count(stdout.String(), "\n"))
+
+	app, _, _, _ = newTestApp()
+	require.Error(t, runAuditCommand(app, conf, "--since=yesterday-ish"))
+}
+
+func runAuditCommand(app *cli.App, conf *server.Config, args ...string) error {
+	auditArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"audit",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+		"--auth-default-access=" + conf.AuthDefault.String(),
+	}
+	return app.Run(append(auditArgs, args...))
+}
@@ -0,0 +1,183 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"heckel.io/ntfy/v2/attachment"
+	"heckel.io/ntfy/v2/db"
+	"heckel.io/ntfy/v2/db/pg"
+	"heckel.io/ntfy/v2/encryption"
+	"heckel.io/ntfy/v2/message"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdEncryption)
+}
+
+var flagsEncryption = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG_FILE"}, Value: server.DefaultConfigFile, DefaultText: server.DefaultConfigFile, Usage: "config file"},
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "encryption-key-file", Aliases: []string{"encryption_key_file"}, EnvVars: []string{"NTFY_ENCRYPTION_KEY_FILE"}, Usage: "key file used to encrypt cached messages and attachments at rest"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "database-url", Aliases: []string{"database_url"}, EnvVars: []string{"NTFY_DATABASE_URL"}, Usage: "PostgreSQL connection string for database-backed stores"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-file", Aliases: []string{"cache_file", "C"}, EnvVars: []string{"NTFY_CACHE_FILE"}, Usage: "cache file used for message caching"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-startup-queries", Aliases: []string{"cache_startup_queries"}, EnvVars: []string{"NTFY_CACHE_STARTUP_QUERIES"}, Usage: "queries run when the cache database is initialized"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-cache-dir", Aliases: []string{"attachment_cache_dir"}, EnvVars: []string{"NTFY_ATTACHMENT_CACHE_DIR"}, Usage: "cache directory for attached files, or S3, WebDAV, Azure or GCS URL"}),
+)
+
+var cmdEncryption = &cli.Command{
+	Name:      "encryption",
+	Usage:     "Rotate encryption keys and re-encrypt cached messages and attachments",
+	UsageText: "ntfy encryption [rotate|reencrypt]",
+	Flags:     flagsEncryption,
+	Before:    initConfigFileInputSourceFunc("config", flagsEncryption, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "rotate",
+			Usage:     "Adds a new primary key to the encryption key file",
+			UsageText: "ntfy encryption rotate",
+			Action:    execEncryptionRotate,
+			Description: `Adds a new random key to the encryption key file, and makes it the primary key.
+
+The primary key is used to encrypt all new messages and attachments. Older keys stay in the
+key file, so that existing data can still be decrypted. If the key file does not exist, it is
+created. After rotating the key, restart the server, and run 'ntfy encryption reencrypt' to
+re-encrypt existing data with the new key. Older keys may only be removed from the key file
+after that.
+
+This is a server-only command. It directly modifies the key file as defined by 'encryption-key-file'
+in the server config file server.yml.
+
+Examples:
+  ntfy encryption rotate                                 # Add a key to the key file in server.yml
+  ntfy encryption rotate --encryption-key-file=keys.txt  # Add a key to keys.txt, create it if needed`,
+		},
+		{
+			Name:      "reencrypt",
+			Usage:     "Re-encrypts cached messages and attachments with the primary key",
+			UsageText: "ntfy encryption reencrypt",
+			Action:    execEncryptionReencrypt,
+			Description: `Re-encrypts all cached messages and attachments that are not encrypted with the primary key.
+
+This encrypts data that was stored before encryption at rest was enabled, as well as data that was
+encrypted with an older key (see 'ntfy encryption rotate'). Restart the server with the new key file
+before running this command, so that no new data is written with an older key.
+
+This is a server-only command. It directly reads from and writes to the message cache (cache-file or
+database-url) and the attachment cache (attachment-cache-dir) as defined in the server config file
+server.yml.
+
+Example:
+  ntfy encryption reencrypt`,
+		},
+	},
+	Description: `Manage encryption at rest for cached messages and attachments.
+
+The commands in this section directly read the encryption key file, as well as the message and
+attachment caches, as defined in the server config file server.yml.
+
+Examples:
+  ntfy encryption rotate     # Add a new primary key to the key file
+  ntfy encryption reencrypt  # Re-encrypt existing data with the primary key`,
+}
+
+func execEncryptionRotate(c *cli.Context) error {
+	keyFile := c.String("encryption-key-file")
+	if keyFile == "" {
+		return errors.New("option encryption-key-file not set; encryption at rest is unconfigured for this server")
+	}
+	var contents string
+	if util.FileExists(keyFile) {
+		existing, err := os.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		contents = string(existing)
+		if contents != "" && !strings.HasSuffix(contents, "\n") {
+			contents += "\n"
+		}
+	} else {
+		contents = "# ntfy encryption keys (<key ID>:<base64 key>), the last key is the primary key\n"
+	}
+	entry, err := encryption.GenerateKey()
+	if err != nil {
+		return err
+	}
+	contents += entry + "\n"
+	if _, err := encryption.ReadKeyring(strings.NewReader(contents)); err != nil {
+		return fmt.Errorf("invalid key file %s: %w", keyFile, err)
+	}
+	if err := os.WriteFile(keyFile, []byte(contents), 0600); err != nil {
+		return err
+	}
+	keyID, _, _ := strings.Cut(entry, ":")
+	fmt.Fprintf(c.App.Writer, "key %s added to %s, and is now the primary key\n", keyID, keyFile)
+	fmt.Fprintf(c.App.Writer, "restart the server, then run 'ntfy encryption reencrypt' to re-encrypt existing data\n")
+	return nil
+}
+
+func execEncryptionReencrypt(c *cli.Context) error {
+	keyFile := c.String("encryption-key-file")
+	databaseURL := c.String("database-url")
+	cacheFile := c.String("cache-file")
+	attachmentCacheDir := c.String("attachment-cache-dir")
+	if keyFile == "" {
+		return errors.New("option encryption-key-file not set; encryption at rest is unconfigured for this server")
+	}
+	keyring, err := encryption.LoadKeyring(keyFile)
+	if err != nil {
+		return err
+	}
+	var messageCache *message.Cache
+	if databaseURL != "" {
+		host, err := pg.Open(databaseURL)
+		if err != nil {
+			return err
+		}
+		messageCache, err = message.NewPostgresStore(db.New(host, nil), 0, 0, keyring)
+		if err != nil {
+			return err
+		}
+	} else if cacheFile != "" {
+		if !util.FileExists(cacheFile) {
+			return errors.New("cache-file does not exist; please start the server at least once to create it")
+		}
+		messageCache, err = message.NewSQLiteStore(cacheFile, c.String("cache-startup-queries"), server.DefaultCacheDuration, 0, 0, keyring, false)
+		if err != nil {
+			return err
+		}
+	}
+	if messageCache != nil {
+		defer messageCache.Close()
+		count, err := messageCache.ReencryptMessages()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "%d message(s) re-encrypted with key %s\n", count, keyring.PrimaryKeyID())
+	}
+	if attachmentCacheDir != "" {
+		store, err := attachment.NewStore(attachmentCacheDir, 0, 0, keyring, nil)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		count, err := store.Reencrypt()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "%d attachment file(s) re-encrypted with key %s\n", count, keyring.PrimaryKeyID())
+	}
+	if messageCache == nil && attachmentCacheDir == "" {
+		return errors.New("options cache-file, database-url and attachment-cache-dir not set; nothing to re-encrypt")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/encryption"
+	"heckel.io/ntfy/v2/message"
+	"heckel.io/ntfy/v2/model"
+)
+
+func TestCLI_Encryption_RotateReencrypt(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keys.txt")
+	cacheFile := filepath.Join(dir, "cache.db")
+	attachmentDir := filepath.Join(dir, "attachments")
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "server.yml"), []byte{}, 0600))
+
+	// Plaintext message and attachment, stored before encryption was enabled
+	cache, err := message.NewSQLiteStore(cacheFile, "", time.Hour, 0, 0, nil, false)
+	require.Nil(t, err)
+	m := model.NewDefaultMessage("mytopic", "secret message")
+	require.Nil(t, cache.AddMessage(m))
+	require.Nil(t, cache.Close())
+	require.Nil(t, os.Mkdir(attachmentDir, 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(attachmentDir, m.ID), []byte("secret file"), 0600))
+
+	// Create key file
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runEncryptionCommand(app, keyFile, cacheFile, attachmentDir, "rotate"))
+	require.Contains(t, stdout.String(), "is now the primary key")
+	keyring, err := encryption.LoadKeyring(keyFile)
+	require.Nil(t, err)
+	firstKeyID := keyring.PrimaryKeyID()
+
+	// Re-encrypt
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runEncryptionCommand(app, keyFile, cacheFile, attachmentDir, "reencrypt"))
+	require.Contains(t, stdout.String(), "1 message(s) re-encrypted with key "+firstKeyID)
+	require.Contains(t, stdout.String(), "1 attachment file(s) re-encrypted with key "+firstKeyID)
+	raw, err := os.ReadFile(filepath.Join(attachmentDir, m.ID))
+	require.Nil(t, err)
+	require.Equal(t, firstKeyID, encryption.StreamKeyID(raw))
+
+	// Rotate and re-encrypt again
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runEncryptionCommand(app, keyFile, cacheFile, attachmentDir, "rotate"))
+	contents, err := os.ReadFile(keyFile)
+	require.Nil(t, err)
+	require.Equal(t, 3, strings.Count(string(contents), "\n")) // Comment and two keys
+	keyring, err = encryption.LoadKeyring(keyFile)
+	require.Nil(t, err)
+	require.NotEqual(t, firstKeyID, keyring.PrimaryKeyID())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runEncryptionCommand(app, keyFile, cacheFile, attachmentDir, "reencrypt"))
+	require.Contains(t, stdout.String(), "1 message(s) re-encrypted with key "+keyring.PrimaryKeyID())
+
+	// Readable with the new key
+	cache, err = message.NewSQLiteStore(cacheFile, "", time.Hour, 0, 0, keyring, false)
+	require.Nil(t, err)
+	defer cache.Close()
+	stored, err := cache.Message(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, "secret message", stored.Message)
+}
+
+func TestCLI_Encryption_NotConfigured(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "server.yml")
+	require.Nil(t, os.WriteFile(configFile, []byte{}, 0600))
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "encryption", "--config=" + configFile, "rotate"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "encryption-key-file not set")
+}
+
+func runEncryptionCommand(app *cli.App, keyFile, cacheFile, attachmentDir string, args ...string) error {
+	encryptionArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"encryption",
+		"--config=" + filepath.Join(filepath.Dir(keyFile), "server.yml"), // Dummy config file to avoid lookups of real file
+		"--encryption-key-file=" + keyFile,
+		"--cache-file=" + cacheFile,
+		"--attachment-cache-dir=" + attachmentDir,
+	}
+	return app.Run(append(encryptionArgs, args...))
+}
@@ -0,0 +1,404 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdGroup)
+}
+
+var flagsGroup = append([]cli.Flag{}, flagsUser...)
+
+var cmdGroup = &cli.Command{
+	Name:      "group",
+	Usage:     "Manage/show groups",
+	UsageText: "ntfy group [list|add|remove|member|access|reserve] ...",
+	Flags:     flagsGroup,
+	Before:    initConfigFileInputSourceFunc("config", flagsUser, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Aliases:   []string{"a"},
+			Usage:     "Adds a new group",
+			UsageText: "ntfy group add NAME",
+			Action:    execGroupAdd,
+			Description: `Add a new, empty group to the ntfy user database.
+
+Group names may only contain letters, numbers, dashes (-), underscores (_) and dots (.).
+
+Example:
+  ntfy group add oncall
+`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Removes a group",
+			UsageText: "ntfy group remove NAME",
+			Action:    execGroupDel,
+			Description: `Remove a group from the ntfy user database.
+
+This removes the group's memberships, access control entries and topic reservations.
+The users themselves are not removed.
+
+Example:
+  ntfy group remove oncall
+`,
+		},
+		{
+			Name:      "list",
+			Aliases:   []string{"l"},
+			Usage:     "Shows a list of groups",
+			UsageText: "ntfy group list [NAME]",
+			Action:    execGroupList,
+			Description: `Shows a list of all groups (or a single group), including their members, access
+control entries and topic reservations.
+
+Examples:
+  ntfy group list           # Shows all groups
+  ntfy group list oncall    # Shows only the group "oncall"
+`,
+		},
+		{
+			Name:      "member",
+			Aliases:   []string{"m"},
+			Usage:     "Adds/removes group members",
+			UsageText: "ntfy group member [--remove] GROUP USERNAME...",
+			Action:    execGroupMember,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "remove", Aliases: []string{"r"}, Usage: "remove the users from the group"},
+			},
+			Description: `Adds users to a group, or removes them from it.
+
+Members of a group inherit all access control entries of the group, and can manage
+the group's topic reservations in the web app.
+
+Examples:
+  ntfy group member oncall phil ben       # Add phil and ben to the group "oncall"
+  ntfy group member --remove oncall ben   # Remove ben from the group "oncall"
+`,
+		},
+		{
+			Name:      "access",
+			Usage:     "Grant/revoke access to a topic for all group members",
+			UsageText: "ntfy group access [--reset] GROUP [TOPIC] [PERMISSION]",
+			Action:    execGroupAccess,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "reset", Aliases: []string{"r"}, Usage: "reset access for a group (and topic)"},
+			},
+			Description: `Grant or revoke access to a topic (or topic pattern) for all members of a group.
+
+The PERMISSION argument works exactly like in 'ntfy access'. Access control entries
+of a user always take precedence over the entries of the user's groups, and the
+entries of a user's groups take precedence over the entries of everyone (anonymous).
+If a user is a member of multiple groups, the most specific topic pattern wins.
+
+Examples:
+  ntfy group access oncall "alerts*" rw      # Allow read-write access to topics "alerts..."
+  ntfy group access oncall status ro         # Allow read-only access to topic "status"
+  ntfy group access --reset oncall status    # Reset access for topic "status"
+  ntfy group access --reset oncall           # Reset all access for the group
+`,
+		},
+		{
+			Name:      "reserve",
+			Usage:     "Reserves a topic for a group",
+			UsageText: "ntfy group reserve [--remove] GROUP TOPIC [EVERYONE]",
+			Action:    execGroupReserve,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "remove", Aliases: []string{"r"}, Usage: "remove the reservation"},
+			},
+			Description: `Reserves a topic on behalf of a group, or removes the reservation.
+
+All members of the group get read-write access to the topic, and everyone else gets the
+permission given in EVERYONE (default: deny-all). Any group member can change or remove
+the reservation in the web app. Group reservations do not count towards the reservation
+limit of the members' tiers.
+
+Examples:
+  ntfy group reserve oncall alerts            # Reserve topic "alerts" for the group
+  ntfy group reserve oncall status read-only  # Reserve topic "status", allow everyone to read
+  ntfy group reserve --remove oncall alerts   # Remove the reservation
+`,
+		},
+	},
+	Description: `Manage groups of the ntfy server.
+
+Groups make it possible to grant the same access to many users at once: access control entries
+and topic reservations of a group apply to all of its members. Adding a user to a group
+immediately grants them all the group's access.
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy group add oncall                      # Add group "oncall"
+  ntfy group member oncall phil ben          # Add phil and ben to the group
+  ntfy group access oncall "alerts*" rw      # Allow read-write access to topics "alerts..."
+  ntfy group reserve oncall status ro        # Reserve topic "status" for the group
+  ntfy group list                            # Show all groups
+`,
+}
+
+func execGroupAdd(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group add --help' for help")
+	} else if !user.AllowedGroup(name) {
+		return errors.New("group name must consist only of letters, numbers, dashes, underscores and dots")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.AddGroup(name); errors.Is(err, user.ErrGroupExists) {
+		return fmt.Errorf("group %s already exists", name)
+	} else if err != nil {
+		return err
+	}
+	auditCLI(manager, user.AuditActionGroupAdd, name, "", "")
+	fmt.Fprintf(c.App.Writer, "group %s added\n", name)
+	return nil
+}
+
+func execGroupDel(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group remove --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	group, err := manager.Group(name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	if err := manager.RemoveGroup(name); err != nil {
+		return err
+	}
+	auditCLI(manager, user.AuditActionGroupRemove, name, strings.Join(group.Members, ","), "")
+	fmt.Fprintf(c.App.Writer, "group %s removed\n", name)
+	return nil
+}
+
+func execGroupList(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if name := c.Args().Get(0); name != "" {
+		return showGroup(c, manager, name)
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	} else if len(groups) == 0 {
+		fmt.Fprintln(c.App.Writer, "no groups")
+		return nil
+	}
+	for _, group := range groups {
+		if err := printGroup(c, manager, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execGroupMember(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("group name and username(s) expected, type 'ntfy group member --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	group := c.Args().Get(0)
+	for _, username := range c.Args().Slice()[1:] {
+		if c.Bool("remove") {
+			err = manager.RemoveGroupMember(group, username)
+		} else {
+			err = manager.AddGroupMember(group, username)
+		}
+		if errors.Is(err, user.ErrGroupNotFound) {
+			return fmt.Errorf("group %s does not exist", group)
+		} else if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, user.ErrInvalidArgument) {
+			return fmt.Errorf("user %s does not exist", username)
+		} else if err != nil {
+			return err
+		}
+		if c.Bool("remove") {
+			auditCLI(manager, user.AuditActionGroupMemberRemove, group, username, "")
+			fmt.Fprintf(c.App.Writer, "removed user %s from group %s\n", username, group)
+		} else {
+			auditCLI(manager, user.AuditActionGroupMemberAdd, group, "", username)
+			fmt.Fprintf(c.App.Writer, "added user %s to group %s\n", username, group)
+		}
+	}
+	return nil
+}
+
+func execGroupAccess(c *cli.Context) error {
+	if c.NArg() > 3 {
+		return errors.New("too many arguments, please check 'ntfy group access --help' for usage details")
+	}
+	group, topic, perms := c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+	if group == "" {
+		return errors.New("group name expected, type 'ntfy group access --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("reset") {
+		if perms != "" {
+			return errors.New("too many arguments, please check 'ntfy group access --help' for usage details")
+		}
+		grants, err := manager.GroupGrants(group)
+		if errors.Is(err, user.ErrGroupNotFound) {
+			return fmt.Errorf("group %s does not exist", group)
+		} else if err != nil {
+			return err
+		}
+		if err := manager.ResetGroupAccess(group, topic); err != nil {
+			return err
+		}
+		auditCLI(manager, user.AuditActionGroupAccessReset, group, auditGrantValue(grants, topic), "")
+		if topic == "" {
+			fmt.Fprintf(c.App.Writer, "reset access for group %s\n\n", group)
+		} else {
+			fmt.Fprintf(c.App.Writer, "reset access for group %s and topic %s\n\n", group, topic)
+		}
+		return showGroup(c, manager, group)
+	} else if perms == "" {
+		if topic != "" {
+			return errors.New("invalid syntax, please check 'ntfy group access --help' for usage details")
+		}
+		return showGroup(c, manager, group)
+	}
+	if !util.Contains([]string{"read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "none", "deny"}, perms) {
+		return errors.New("permission must be one of: read-write, read-only, write-only, or deny (or the aliases: read, ro, write, wo, none)")
+	}
+	permission, err := user.ParsePermission(perms)
+	if err != nil {
+		return err
+	}
+	grants, err := manager.GroupGrants(group)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", group)
+	} else if err != nil {
+		return err
+	}
+	if err := manager.AllowGroupAccess(group, topic, permission); err != nil {
+		return err
+	}
+	auditCLI(manager, user.AuditActionGroupAccessAllow, group, auditGrantValue(grants, topic), user.FormatGrant(topic, permission))
+	fmt.Fprintf(c.App.Writer, "granted %s to topic %s for group %s\n\n", permissionDescription(permission), topic, group)
+	return showGroup(c, manager, group)
+}
+
+func execGroupReserve(c *cli.Context) error {
+	if c.NArg() < 2 || c.NArg() > 3 {
+		return errors.New("group name and topic expected, type 'ntfy group reserve --help' for help")
+	}
+	group, topic, perms := c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("remove") {
+		if perms != "" {
+			return errors.New("too many arguments, please check 'ntfy group reserve --help' for usage details")
+		}
+		if err := manager.RemoveGroupReservations(group, topic); errors.Is(err, user.ErrGroupNotFound) {
+			return fmt.Errorf("group %s does not exist", group)
+		} else if err != nil {
+			return err
+		}
+		auditCLI(manager, user.AuditActionGroupReservationRemove, group, topic, "")
+		fmt.Fprintf(c.App.Writer, "removed reservation of topic %s for group %s\n\n", topic, group)
+		return showGroup(c, manager, group)
+	}
+	if perms == "" {
+		perms = "deny-all"
+	}
+	everyone, err := user.ParsePermission(perms)
+	if err != nil {
+		return err
+	}
+	if err := manager.AllowGroupReservation(group, topic); errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", group)
+	} else if errors.Is(err, user.ErrInvalidArgument) {
+		return fmt.Errorf("invalid topic %s", topic)
+	} else if err != nil {
+		return fmt.Errorf("topic %s is already reserved, or has access control entries of other users or groups", topic)
+	}
+	if err := manager.AddGroupReservation(group, topic, everyone); err != nil {
+		return err
+	}
+	auditCLI(manager, user.AuditActionGroupReservationAdd, group, "", user.FormatGrant(topic, everyone))
+	fmt.Fprintf(c.App.Writer, "reserved topic %s for group %s\n\n", topic, group)
+	return showGroup(c, manager, group)
+}
+
+func showGroup(c *cli.Context, manager *user.Manager, name string) error {
+	group, err := manager.Group(name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	return printGroup(c, manager, group)
+}
+
+func printGroup(c *cli.Context, manager *user.Manager, group *user.Group) error {
+	grants, err := manager.GroupGrants(group.Name)
+	if err != nil {
+		return err
+	}
+	reservations, err := manager.GroupReservations(group.Name)
+	if err != nil {
+		return err
+	}
+	members := "none"
+	if len(group.Members) > 0 {
+		members = strings.Join(group.Members, ", ")
+	}
+	fmt.Fprintf(c.App.Writer, "group %s (members: %s)\n", group.Name, members)
+	if len(grants) == 0 {
+		fmt.Fprintf(c.App.Writer, "- no topic-specific permissions\n")
+	}
+	for _, grant := range grants {
+		reserved := ""
+		for _, r := range reservations {
+			if r.Topic == grant.TopicPattern {
+				reserved = fmt.Sprintf(" (reserved, everyone: %s)", r.Everyone.String())
+			}
+		}
+		fmt.Fprintf(c.App.Writer, "- %s to topic %s%s\n", permissionDescription(grant.Permission), grant.TopicPattern, reserved)
+	}
+	return nil
+}
+
+func permissionDescription(permission user.Permission) string {
+	if permission.IsReadWrite() {
+		return "read-write access"
+	} else if permission.IsRead() {
+		return "read-only access"
+	} else if permission.IsWrite() {
+		return "write-only access"
+	}
+	return "no access"
+}
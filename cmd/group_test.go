@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"testing"
+)
+
+func TestCLI_Group_AddMemberAccessReserveRemove(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "add", "oncall"))
+	require.Equal(t, "group oncall added\n", stdout.String())
+
+	app, _, _, _ = newTestApp()
+	err := runGroupCommand(app, conf, "add", "oncall")
+	require.NotNil(t, err)
+	require.Equal(t, "group oncall already exists", err.Error())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "member", "oncall", "phil", "ben"))
+	require.Equal(t, "added user phil to group oncall\nadded user ben to group oncall\n", stdout.String())
+
+	app, _, _, _ = newTestApp()
+	err = runGroupCommand(app, conf, "member", "oncall", "nobody")
+	require.NotNil(t, err)
+	require.Equal(t, "user nobody does not exist", err.Error())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "access", "oncall", "alerts*", "rw"))
+	require.Contains(t, stdout.String(), "granted read-write access to topic alerts* for group oncall")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "reserve", "oncall", "status", "read-only"))
+	require.Contains(t, stdout.String(), "reserved topic status for group oncall")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "list"))
+	require.Equal(t, `group oncall (members: ben, phil)
+- read-write access to topic alerts*
+- read-write access to topic status (reserved, everyone: read-only)
+`, stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf, "ben"))
+	require.Contains(t, stdout.String(), "- member of groups: oncall")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "member", "--remove", "oncall", "ben"))
+	require.Equal(t, "removed user ben from group oncall\n", stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "reserve", "--remove", "oncall", "status"))
+	require.Nil(t, runGroupCommand(app, conf, "access", "--reset", "oncall", "alerts*"))
+	require.Contains(t, stdout.String(), "group oncall (members: phil)\n- no topic-specific permissions\n")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "remove", "oncall"))
+	require.Equal(t, "group oncall removed\n", stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "list"))
+	require.Equal(t, "no groups\n", stdout.String())
+}
+
+func runGroupCommand(app *cli.App, conf *server.Config, args ...string) error {
+	userArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"group",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+		"--auth-default-access=" + conf.AuthDefault.String(),
+	}
+	return app.Run(append(userArgs, args...))
+}
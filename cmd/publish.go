@@ -39,6 +39,7 @@ var flagsPublish = append(
 	&cli.StringFlag{Name: "email", Aliases: []string{"mail", "e"}, EnvVars: []string{"NTFY_EMAIL"}, Usage: "also send to e-mail address"},
 	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
 	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.StringFlag{Name: "encryption-key", Aliases: []string{"encryption_key", "E"}, EnvVars: []string{"NTFY_ENCRYPTION_KEY"}, Usage: "encrypt message end-to-end with this key"},
 	&cli.IntFlag{Name: "wait-pid", Aliases: []string{"wait_pid", "pid"}, EnvVars: []string{"NTFY_WAIT_PID"}, Usage: "wait until PID exits before publishing"},
 	&cli.BoolFlag{Name: "wait-cmd", Aliases: []string{"wait_cmd", "cmd", "done"}, EnvVars: []string{"NTFY_WAIT_CMD"}, Usage: "run command and wait until it finishes before publishing"},
 	&cli.BoolFlag{Name: "no-cache", Aliases: []string{"no_cache", "C"}, EnvVars: []string{"NTFY_NO_CACHE"}, Usage: "do not cache message server-side"},
@@ -74,6 +75,7 @@ Examples:
   ntfy pub -S my-id mytopic 'Update me'                   # Send with sequence ID for updates
   echo 'message' | ntfy publish mytopic                   # Send message from stdin
   ntfy pub -u phil:mypass secret Psst                     # Publish with username/password
+  ntfy pub -E mysecret secret Psst                        # Encrypt message end-to-end with key "mysecret"
   ntfy pub --wait-pid 1234 mytopic                        # Wait for process 1234 to exit before publishing
   ntfy pub --wait-cmd mytopic rsync -av ./ /tmp/a         # Run command and publish after it completes
   NTFY_USER=phil:mypass ntfy pub secret Psst              # Use env variables to set username/password
@@ -108,6 +110,7 @@ func execPublish(c *cli.Context) error {
 	email := c.String("email")
 	user := c.String("user")
 	token := c.String("token")
+	encryptionKey := c.String("encryption-key")
 	noCache := c.Bool("no-cache")
 	noFirebase := c.Bool("no-firebase")
 	quiet := c.Bool("quiet")
@@ -123,6 +126,13 @@ func execPublish(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	cl := client.New(conf)
+	if encryptionKey == "" {
+		encryptionKey = cl.EncryptionKey(topic)
+	}
+	if encryptionKey != "" && (file != "" || filename != "" || template != "" || email != "") {
+		return errors.New("cannot use --file, --filename, --template or --email with end-to-end encryption")
+	}
 	var options []client.PublishOption
 	if title != "" {
 		options = append(options, client.WithTitle(title))
@@ -169,6 +179,9 @@ func execPublish(c *cli.Context) error {
 	if noFirebase {
 		options = append(options, client.WithNoFirebase())
 	}
+	if encryptionKey != "" {
+		options = append(options, client.WithEncryption(encryptionKey))
+	}
 	if token != "" {
 		options = append(options, client.WithBearerAuth(token))
 	} else if user != "" {
@@ -229,7 +242,6 @@ func execPublish(c *cli.Context) error {
 			}
 		}
 	}
-	cl := client.New(conf)
 	m, err := cl.PublishReader(topic, body, options...)
 	if err != nil {
 		return err
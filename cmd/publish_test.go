@@ -52,6 +52,49 @@ func TestCLI_Publish_Subscribe_Poll(t *testing.T) {
 	require.Equal(t, "some message", m.Message)
 }
 
+func TestCLI_Publish_Subscribe_Poll_Encrypted(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--encryption-key=secret", "--title=some title", topic, "some secret message"}))
+	m := toMessage(t, stdout.String())
+	require.Equal(t, "jwe", m.Encoding)
+	require.NotContains(t, m.Message, "secret")
+	require.Equal(t, "some title", m.Title)
+
+	app2, _, stdout, _ := newTestApp()
+	require.Nil(t, app2.Run([]string{"ntfy", "subscribe", "--poll", topic}))
+	m = toMessage(t, stdout.String())
+	require.Equal(t, "jwe", m.Encoding)
+
+	app3, _, stdout, _ := newTestApp()
+	require.Nil(t, app3.Run([]string{"ntfy", "subscribe", "--poll", "--encryption-key=secret", topic}))
+	m = toMessage(t, stdout.String())
+	require.Equal(t, "some secret message", m.Message)
+	require.Equal(t, "", m.Encoding)
+	require.Equal(t, "some title", m.Title)
+
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte(fmt.Sprintf(`
+subscribe:
+  - topic: %s
+    encryption-key: secret
+`, topic)), 0600))
+	app4, _, stdout, _ := newTestApp()
+	require.Nil(t, app4.Run([]string{"ntfy", "subscribe", "--poll", "--from-config", "--config=" + filename}))
+	m = toMessage(t, stdout.String())
+	require.Equal(t, "some secret message", m.Message)
+}
+
+func TestCLI_Publish_Encrypted_With_File(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "publish", "--encryption-key=secret", "--file=some.txt", "mytopic"})
+	require.Error(t, err)
+	require.Equal(t, "cannot use --file, --filename, --template or --email with end-to-end encryption", err.Error())
+}
+
 func TestCLI_Publish_All_The_Things(t *testing.T) {
 	s, port := test.StartServer(t)
 	defer test.StopServer(t, s, port)
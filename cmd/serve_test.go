@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/test"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
@@ -469,6 +470,116 @@ func TestParseTokens_Errors(t *testing.T) {
 	}
 }
 
+func TestParseTiers(t *testing.T) {
+	tiers, err := parseTiers([]string{"starter:", "pro:Pro:message-limit=10000, message-expiry-duration=1d,reservation-limit=10,attachment-total-size-limit=1G,stripe-monthly-price-id=price_1,stripe-yearly-price-id=price_2"})
+	require.NoError(t, err)
+	require.Len(t, tiers, 2)
+	assert.Equal(t, &user.Tier{
+		Code:                     "starter",
+		Name:                     "starter",
+		MessageLimit:             5000,
+		MessageExpiryDuration:    12 * time.Hour,
+		EmailLimit:               20,
+		ReservationLimit:         3,
+		AttachmentFileSizeLimit:  15 * 1024 * 1024,
+		AttachmentTotalSizeLimit: 100 * 1024 * 1024,
+		AttachmentExpiryDuration: 6 * time.Hour,
+		AttachmentBandwidthLimit: 1024 * 1024 * 1024,
+		Provisioned:              true,
+	}, tiers[0])
+	assert.Equal(t, "Pro", tiers[1].Name)
+	assert.Equal(t, int64(10000), tiers[1].MessageLimit)
+	assert.Equal(t, 24*time.Hour, tiers[1].MessageExpiryDuration)
+	assert.Equal(t, int64(10), tiers[1].ReservationLimit)
+	assert.Equal(t, int64(1024*1024*1024), tiers[1].AttachmentTotalSizeLimit)
+	assert.Equal(t, int64(15*1024*1024), tiers[1].AttachmentFileSizeLimit) // Default
+	assert.Equal(t, "price_1", tiers[1].StripeMonthlyPriceID)
+	assert.Equal(t, "price_2", tiers[1].StripeYearlyPriceID)
+
+	_, err = parseTiers([]string{"pro"})
+	require.ErrorContains(t, err, "invalid auth-tiers: pro, expected format: 'code:name[:key=value,...]'")
+	_, err = parseTiers([]string{"pro tier:Pro"})
+	require.ErrorContains(t, err, "tier code pro tier invalid")
+	_, err = parseTiers([]string{"pro:Pro", "pro:Pro again"})
+	require.ErrorContains(t, err, "tier pro is defined more than once")
+	_, err = parseTiers([]string{"pro:Pro:message-limit"})
+	require.ErrorContains(t, err, "expected 'key=value', got 'message-limit'")
+	_, err = parseTiers([]string{"pro:Pro:messages=10"})
+	require.ErrorContains(t, err, "unknown key messages")
+	_, err = parseTiers([]string{"pro:Pro:attachment-file-size-limit=lots"})
+	require.ErrorContains(t, err, "value of attachment-file-size-limit invalid")
+	_, err = parseTiers([]string{"pro:Pro:stripe-monthly-price-id=price_1"})
+	require.ErrorContains(t, err, "stripe-monthly-price-id and stripe-yearly-price-id must be set together")
+}
+
+func TestParseReservations(t *testing.T) {
+	users := []*user.User{
+		{Name: "alice"},
+		{Name: "bob"},
+	}
+	reservations, err := parseReservations(users, []string{"alice:alerts:ro", "alice:private", " bob : builds : read-write "})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]*user.Reservation{
+		"alice": {
+			{Topic: "alerts", Owner: user.PermissionReadWrite, Everyone: user.PermissionRead, Provisioned: true},
+			{Topic: "private", Owner: user.PermissionReadWrite, Everyone: user.PermissionDenyAll, Provisioned: true},
+		},
+		"bob": {
+			{Topic: "builds", Owner: user.PermissionReadWrite, Everyone: user.PermissionReadWrite, Provisioned: true},
+		},
+	}, reservations)
+
+	_, err = parseReservations(users, []string{"alice"})
+	require.ErrorContains(t, err, "invalid auth-reservations: alice, expected format: 'user:topic[:everyone]'")
+	_, err = parseReservations(users, []string{"charlie:alerts"})
+	require.ErrorContains(t, err, "user charlie is not provisioned")
+	_, err = parseReservations(users, []string{"alice:alerts*"})
+	require.ErrorContains(t, err, "topic alerts* invalid")
+	_, err = parseReservations(users, []string{"alice:alerts:everything"})
+	require.ErrorContains(t, err, "permission everything invalid")
+}
+
+func TestParseOIDCTiers(t *testing.T) {
+	tiers, err := parseOIDCTiers([]string{"ntfy-pro:pro", " ntfy-users : starter "})
+	require.NoError(t, err)
+	assert.Equal(t, []*server.OIDCTierMapping{
+		{Group: "ntfy-pro", Tier: "pro"},
+		{Group: "ntfy-users", Tier: "starter"},
+	}, tiers)
+
+	_, err = parseOIDCTiers([]string{"ntfy-pro"})
+	require.ErrorContains(t, err, "invalid auth-oidc-tiers: ntfy-pro, expected format: 'group:tier'")
+	_, err = parseOIDCTiers([]string{"ntfy-pro:pro tier"})
+	require.ErrorContains(t, err, "tier pro tier invalid")
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := parseRoles("auth-2fa-required", []string{"admin", " user"})
+	require.NoError(t, err)
+	assert.Equal(t, []user.Role{user.RoleAdmin, user.RoleUser}, roles)
+
+	_, err = parseRoles("auth-2fa-required", []string{"anonymous"})
+	require.ErrorContains(t, err, "invalid auth-2fa-required: role anonymous is not allowed")
+}
+
+func TestParseGroupAccess(t *testing.T) {
+	access, err := parseGroupAccess("auth-oidc-access", []string{"ntfy-users:alerts:ro", "ntfy-ops:alerts:wo", "ntfy-ops:ops_*:rw"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]*user.Grant{
+		"ntfy-users": {{TopicPattern: "alerts", Permission: user.PermissionRead}},
+		"ntfy-ops":   {{TopicPattern: "alerts", Permission: user.PermissionWrite}, {TopicPattern: "ops_*", Permission: user.PermissionReadWrite}},
+	}, access)
+
+	_, err = parseGroupAccess("auth-ldap-access", []string{"ntfy-users:alerts"})
+	require.ErrorContains(t, err, "invalid auth-ldap-access: ntfy-users:alerts, expected format: 'group:topic:permission'")
+	_, err = parseGroupAccess("auth-oidc-access", []string{":alerts:ro"})
+	require.ErrorContains(t, err, "group must not be empty")
+	_, err = parseGroupAccess("auth-oidc-access", []string{"ntfy-users:alerts!:ro"})
+	require.ErrorContains(t, err, "topic pattern alerts! invalid")
+	_, err = parseGroupAccess("auth-oidc-access", []string{"ntfy-users:alerts:maybe"})
+	require.ErrorContains(t, err, "permission maybe invalid")
+}
+
 func TestCLI_Serve_Unix_Curl(t *testing.T) {
 	sockFile := filepath.Join(t.TempDir(), "ntfy.sock")
 	configFile := newEmptyFile(t) // Avoid issues with existing server.yml file on system
@@ -24,6 +24,7 @@ var flagsSubscribe = append(
 	&cli.StringFlag{Name: "since", Aliases: []string{"s"}, Usage: "return events since `SINCE` (Unix timestamp, or all)"},
 	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
 	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.StringFlag{Name: "encryption-key", Aliases: []string{"encryption_key", "E"}, EnvVars: []string{"NTFY_ENCRYPTION_KEY"}, Usage: "decrypt end-to-end encrypted messages with this key"},
 	&cli.BoolFlag{Name: "from-config", Aliases: []string{"from_config", "C"}, Usage: "read subscriptions from config file (service mode)"},
 	&cli.BoolFlag{Name: "poll", Aliases: []string{"p"}, Usage: "return events and exit, do not listen for new events"},
 	&cli.BoolFlag{Name: "scheduled", Aliases: []string{"sched", "S"}, Usage: "also return scheduled/delayed events"},
@@ -51,6 +52,7 @@ ntfy subscribe TOPIC
     ntfy sub home.lan/backups         # Subscribe to topic on different server
     ntfy sub --poll home.lan/backups  # Just query for latest messages and exit
     ntfy sub -u phil:mypass secret    # Subscribe with username/password
+    ntfy sub -E mysecret secret       # Decrypt end-to-end encrypted messages
   
 ntfy subscribe TOPIC COMMAND
   This executes COMMAND for every incoming messages. The message fields are passed to the
@@ -92,6 +94,7 @@ func execSubscribe(c *cli.Context) error {
 	since := c.String("since")
 	user := c.String("user")
 	token := c.String("token")
+	encryptionKey := c.String("encryption-key")
 	poll := c.Bool("poll")
 	scheduled := c.Bool("scheduled")
 	fromConfig := c.Bool("from-config")
@@ -139,44 +142,49 @@ func execSubscribe(c *cli.Context) error {
 	if topic == "" && len(conf.Subscribe) == 0 {
 		return errors.New("must specify topic, type 'ntfy subscribe --help' for help")
 	}
+	if topic != "" && encryptionKey == "" {
+		encryptionKey = cl.EncryptionKey(topic)
+	}
 
 	// Execute poll or subscribe
 	if poll {
-		return doPoll(c, cl, conf, topic, command, options...)
+		return doPoll(c, cl, conf, topic, command, encryptionKey, options...)
 	}
-	return doSubscribe(c, cl, conf, topic, command, options...)
+	return doSubscribe(c, cl, conf, topic, command, encryptionKey, options...)
 }
 
-func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
+func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, topic, command, encryptionKey string, options ...client.SubscribeOption) error {
 	for _, s := range conf.Subscribe { // may be nil
 		if auth := maybeAddAuthHeader(s, conf); auth != nil {
 			options = append(options, auth)
 		}
-		if err := doPollSingle(c, cl, s.Topic, s.Command, options...); err != nil {
+		if err := doPollSingle(c, cl, s.Topic, s.Command, s.EncryptionKey, options...); err != nil {
 			return err
 		}
 	}
 	if topic != "" {
-		if err := doPollSingle(c, cl, topic, command, options...); err != nil {
+		if err := doPollSingle(c, cl, topic, command, encryptionKey, options...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func doPollSingle(c *cli.Context, cl *client.Client, topic, command string, options ...client.SubscribeOption) error {
+func doPollSingle(c *cli.Context, cl *client.Client, topic, command, encryptionKey string, options ...client.SubscribeOption) error {
 	messages, err := cl.Poll(topic, options...)
 	if err != nil {
 		return err
 	}
 	for _, m := range messages {
+		maybeDecryptMessage(m, encryptionKey)
 		printMessageOrRunCommand(c, m, command)
 	}
 	return nil
 }
 
-func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
+func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic, command, encryptionKey string, options ...client.SubscribeOption) error {
 	cmds := make(map[string]string)    // Subscription ID -> command
+	keys := make(map[string]string)    // Subscription ID -> end-to-end encryption key
 	for _, s := range conf.Subscribe { // May be nil
 		topicOptions := append(make([]client.SubscribeOption, 0), options...)
 		for filter, value := range s.If {
@@ -191,6 +199,7 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 		if err != nil {
 			return err
 		}
+		keys[subscriptionID] = s.EncryptionKey
 		if s.Command != "" {
 			cmds[subscriptionID] = s.Command
 		} else if conf.DefaultCommand != "" {
@@ -205,6 +214,7 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			return err
 		}
 		cmds[subscriptionID] = command
+		keys[subscriptionID] = encryptionKey
 	}
 	for m := range cl.Messages {
 		cmd, ok := cmds[m.SubscriptionID]
@@ -212,6 +222,7 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			continue
 		}
 		log.Debug("%s Dispatching received message: %s", logMessagePrefix(m), m.Raw)
+		maybeDecryptMessage(m, keys[m.SubscriptionID])
 		printMessageOrRunCommand(c, m, cmd)
 	}
 	return nil
@@ -241,6 +252,17 @@ func maybeAddAuthHeader(s client.Subscribe, conf *client.Config) client.Subscrib
 	return nil
 }
 
+// maybeDecryptMessage decrypts an end-to-end encrypted message in place, if an encryption key is set. If the
+// message cannot be decrypted, it is passed on as is (i.e. encrypted).
+func maybeDecryptMessage(m *client.Message, encryptionKey string) {
+	if encryptionKey == "" || m.Encoding != client.EncodingJWE {
+		return
+	}
+	if err := m.Decrypt(encryptionKey); err != nil {
+		log.Warn("%s Cannot decrypt message: %s", logMessagePrefix(m), err.Error())
+	}
+}
+
 func printMessageOrRunCommand(c *cli.Context, m *client.Message, command string) {
 	if command != "" {
 		runCommand(c, command, m)
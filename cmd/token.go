@@ -130,6 +130,7 @@ func execTokenAdd(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionTokenCreate, u.Name, "", user.FormatToken(token.Value))
 	if expires.Unix() == 0 {
 		fmt.Fprintf(c.App.Writer, "token %s created for user %s, never expires\n", token.Value, u.Name)
 	} else {
@@ -158,6 +159,7 @@ func execTokenDel(c *cli.Context) error {
 	if err := manager.RemoveToken(u.ID, token); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionTokenRemove, u.Name, user.FormatToken(token), "")
 	fmt.Fprintf(c.App.Writer, "token %s for user %s removed\n", token, username)
 	return nil
 }
@@ -167,6 +167,21 @@ as messages per day, attachment file sizes, etc.
 Example:
   ntfy user change-tier phil pro   # Change tier to "pro" for user "phil"  
   ntfy user change-tier phil -     # Remove tier from user "phil" entirely 
+`,
+		},
+		{
+			Name:      "reset-2fa",
+			Usage:     "Disables two-factor authentication for a user",
+			UsageText: "ntfy user reset-2fa USERNAME",
+			Action:    execUserReset2FA,
+			Description: `Disable two-factor authentication for the given user.
+
+This command removes the TOTP secret and the recovery codes of a user, e.g. if they
+have lost access to their authenticator app and their recovery codes. The user can
+then log in with their password alone, and set up two-factor authentication again.
+
+Example:
+  ntfy user reset-2fa phil   # Disable two-factor authentication for user "phil"
 `,
 		},
 		{
@@ -260,6 +275,7 @@ func execUserAdd(c *cli.Context) error {
 	if err := manager.AddUser(username, password, role, hashed); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionUserAdd, username, "", string(role))
 	fmt.Fprintf(c.App.Writer, "user %s added with role %s\n", username, role)
 	return nil
 }
@@ -275,12 +291,16 @@ func execUserDel(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	if _, err := manager.User(username); errors.Is(err, user.ErrUserNotFound) {
+	u, err := manager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
 		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
 	}
 	if err := manager.RemoveUser(username); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionUserRemove, username, string(u.Role), "")
 	fmt.Fprintf(c.App.Writer, "user %s removed\n", username)
 	return nil
 }
@@ -312,6 +332,7 @@ func execUserChangePass(c *cli.Context) error {
 	if err := manager.ChangePassword(username, password, hashed); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionUserPassword, username, "", "")
 	fmt.Fprintf(c.App.Writer, "changed password for user %s\n", username)
 	return nil
 }
@@ -383,16 +404,45 @@ func execUserChangeRole(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	if _, err := manager.User(username); errors.Is(err, user.ErrUserNotFound) {
+	u, err := manager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
 		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
 	}
 	if err := manager.ChangeRole(username, role); err != nil {
 		return err
 	}
+	auditCLI(manager, user.AuditActionUserRole, username, string(u.Role), string(role))
 	fmt.Fprintf(c.App.Writer, "changed role for user %s to %s\n", username, role)
 	return nil
 }
 
+func execUserReset2FA(c *cli.Context) error {
+	username := c.Args().Get(0)
+	if username == "" {
+		return errors.New("username expected, type 'ntfy user reset-2fa --help' for help")
+	} else if username == userEveryone || username == user.Everyone {
+		return errors.New("username not allowed")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	u, err := manager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
+	}
+	if err := manager.RemoveTOTP(u.ID); err != nil {
+		return err
+	}
+	auditCLI(manager, user.AuditActionUser2FA, username, "", "disabled")
+	fmt.Fprintf(c.App.Writer, "disabled two-factor authentication for user %s\n", username)
+	return nil
+}
+
 func execUserHash(c *cli.Context) error {
 	password, err := readPasswordAndConfirm(c)
 	if err != nil {
@@ -420,18 +470,27 @@ func execUserChangeTier(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	if _, err := manager.User(username); errors.Is(err, user.ErrUserNotFound) {
+	u, err := manager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
 		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
+	}
+	var oldTier string
+	if u.Tier != nil {
+		oldTier = u.Tier.Code
 	}
 	if tier == tierReset {
 		if err := manager.ResetTier(username); err != nil {
 			return err
 		}
+		auditCLI(manager, user.AuditActionUserTier, username, oldTier, "")
 		fmt.Fprintf(c.App.Writer, "removed tier from user %s\n", username)
 	} else {
 		if err := manager.ChangeTier(username, tier); err != nil {
 			return err
 		}
+		auditCLI(manager, user.AuditActionUserTier, username, oldTier, tier)
 		fmt.Fprintf(c.App.Writer, "changed tier for user %s to %s\n", username, tier)
 	}
 	return nil
@@ -100,6 +100,26 @@ func TestCLI_User_ChangeRole(t *testing.T) {
 	require.Contains(t, stdout.String(), "changed role for user phil to admin")
 }
 
+func TestCLI_User_Reset2FA(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	// Add user
+	app, stdin, stdout, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	require.Contains(t, stdout.String(), "user phil added with role user")
+
+	// Reset 2FA
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "reset-2fa", "phil"))
+	require.Contains(t, stdout.String(), "disabled two-factor authentication for user phil")
+
+	// Unknown user
+	app, _, _, _ = newTestApp()
+	require.Error(t, runUserCommand(app, conf, "reset-2fa", "nobody"))
+}
+
 func TestCLI_User_Delete(t *testing.T) {
 	s, conf, port := newTestServerWithAuth(t)
 	defer test.StopServer(t, s, port)
@@ -0,0 +1,115 @@
+package encryption
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// End-to-end encrypted messages are encrypted by the publisher with a topic key, and decrypted by the subscriber.
+// The server only ever sees the ciphertext. Messages are encoded as JWE (RFC 7516) in the compact serialization,
+// using direct encryption with a shared symmetric key (alg "dir") and AES-256-GCM (enc "A256GCM"):
+//
+//	BASE64URL(header) . (empty encrypted key) . BASE64URL(IV) . BASE64URL(ciphertext) . BASE64URL(tag)
+//
+// The topic key is derived from a password and the topic URL using PBKDF2, so that the same password yields
+// different keys for different topics.
+const (
+	jweAlgorithm       = "dir"
+	jweEncryption      = "A256GCM"
+	jweIVSize          = 12
+	jweTagSize         = 16
+	topicKeyIterations = 50000
+)
+
+var (
+	// ErrInvalidJWE is returned if a message is not a valid JWE, or if it cannot be decrypted with the given key
+	ErrInvalidJWE = errors.New("invalid JWE message")
+
+	jweHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + jweAlgorithm + `","enc":"` + jweEncryption + `"}`))
+)
+
+type jweProtectedHeader struct {
+	Algorithm  string `json:"alg"`
+	Encryption string `json:"enc"`
+}
+
+// DeriveTopicKey derives a 256-bit topic key from the given password and topic URL (e.g. https://ntfy.sh/mytopic)
+func DeriveTopicKey(password, topicURL string) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, []byte(topicURL), topicKeyIterations, keySize)
+}
+
+// EncryptJWE encrypts plaintext with the given topic key, and returns it as JWE in the compact serialization
+func EncryptJWE(plaintext, key []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, jweIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, iv, plaintext, []byte(jweHeader))
+	ciphertext, tag := sealed[:len(sealed)-jweTagSize], sealed[len(sealed)-jweTagSize:]
+	return strings.Join([]string{
+		jweHeader,
+		"",
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptJWE decrypts a JWE message (as returned by EncryptJWE) with the given topic key
+func DecryptJWE(message string, key []byte) ([]byte, error) {
+	parts, ok := parseJWE(message)
+	if !ok {
+		return nil, ErrInvalidJWE
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := append(parts[3], parts[4]...)
+	plaintext, err := aead.Open(nil, parts[2], sealed, []byte(strings.SplitN(message, ".", 2)[0]))
+	if err != nil {
+		return nil, ErrInvalidJWE
+	}
+	return plaintext, nil
+}
+
+// ValidJWE returns true if the message is structurally a JWE that can be decrypted with a topic key, i.e. if
+// it is in the compact serialization and uses the supported algorithms. It does not (and cannot) decrypt it.
+func ValidJWE(message string) bool {
+	_, ok := parseJWE(message)
+	return ok
+}
+
+// parseJWE splits a JWE in the compact serialization into its five decoded parts, and checks the header
+func parseJWE(message string) ([][]byte, bool) {
+	encoded := strings.Split(message, ".")
+	if len(encoded) != 5 {
+		return nil, false
+	}
+	parts := make([][]byte, len(encoded))
+	for i, p := range encoded {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = b
+	}
+	var header jweProtectedHeader
+	if err := json.Unmarshal(parts[0], &header); err != nil {
+		return nil, false
+	} else if header.Algorithm != jweAlgorithm || header.Encryption != jweEncryption {
+		return nil, false
+	} else if len(parts[1]) != 0 || len(parts[2]) != jweIVSize || len(parts[4]) != jweTagSize {
+		return nil, false
+	}
+	return parts, true
+}
@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWE_EncryptDecrypt(t *testing.T) {
+	key, err := DeriveTopicKey("secret", "https://ntfy.sh/mytopic")
+	require.Nil(t, err)
+	message, err := EncryptJWE([]byte("hi there 🔒"), key)
+	require.Nil(t, err)
+	require.True(t, ValidJWE(message))
+	require.Equal(t, 5, len(strings.Split(message, ".")))
+	require.NotContains(t, message, "hi there")
+
+	plaintext, err := DecryptJWE(message, key)
+	require.Nil(t, err)
+	require.Equal(t, "hi there 🔒", string(plaintext))
+}
+
+func TestJWE_DecryptWrongKey(t *testing.T) {
+	key, err := DeriveTopicKey("secret", "https://ntfy.sh/mytopic")
+	require.Nil(t, err)
+	otherTopicKey, err := DeriveTopicKey("secret", "https://ntfy.sh/othertopic")
+	require.Nil(t, err)
+	require.NotEqual(t, key, otherTopicKey)
+
+	message, err := EncryptJWE([]byte("hi there"), key)
+	require.Nil(t, err)
+	_, err = DecryptJWE(message, otherTopicKey)
+	require.Equal(t, ErrInvalidJWE, err)
+}
+
+func TestJWE_DecryptTampered(t *testing.T) {
+	key, err := DeriveTopicKey("secret", "https://ntfy.sh/mytopic")
+	require.Nil(t, err)
+	message, err := EncryptJWE([]byte("hi there"), key)
+	require.Nil(t, err)
+
+	parts := strings.Split(message, ".")
+	parts[0] = "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIiwieCI6MX0" // {"alg":"dir","enc":"A256GCM","x":1}
+	_, err = DecryptJWE(strings.Join(parts, "."), key)
+	require.Equal(t, ErrInvalidJWE, err)
+}
+
+func TestJWE_Valid(t *testing.T) {
+	require.False(t, ValidJWE(""))
+	require.False(t, ValidJWE("hi there"))
+	require.False(t, ValidJWE("a.b.c.d.e"))
+	require.False(t, ValidJWE("eyJhbGciOiJSU0EtT0FFUCIsImVuYyI6IkEyNTZHQ00ifQ..AAAAAAAAAAAAAAAA.AA.AAAAAAAAAAAAAAAAAAAAAA")) // alg RSA-OAEP
+	require.True(t, ValidJWE("eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..AAAAAAAAAAAAAAAA.AA.AAAAAAAAAAAAAAAAAAAAAA"))
+}
@@ -0,0 +1,198 @@
+// Package encryption implements server-side encryption at rest for the message cache and the attachment store,
+// as well as end-to-end encryption of message payloads (see EncryptJWE).
+//
+// Keys are loaded from a key file, in which each line holds a key ID and a base64-encoded 256-bit AES key,
+// separated by a colon. The last key in the file is the primary key, which is used to encrypt new data. All
+// other keys are only used for decryption, which allows rotating keys by appending a new key to the file.
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	keySize      = 32 // AES-256
+	keyIDMaxSize = 32
+	valuePrefix  = "enc:v1:" // Prefix of encrypted string values, followed by "<key ID>:<base64 ciphertext>"
+)
+
+var (
+	keyIDRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,32}$`)
+
+	// ErrUnknownKey is returned if data was encrypted with a key that is not in the keyring
+	ErrUnknownKey = errors.New("encryption key not found in keyring")
+
+	// ErrInvalidCiphertext is returned if data cannot be decrypted, e.g. because it was tampered with
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// Keyring holds a set of AES-GCM keys, identified by their key ID
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	primary string
+}
+
+// LoadKeyring reads the keyring from the given key file
+func LoadKeyring(filename string) (*Keyring, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadKeyring(f)
+}
+
+// ReadKeyring parses a keyring in the key file format. Empty lines and lines starting with "#" are ignored.
+func ReadKeyring(r io.Reader) (*Keyring, error) {
+	k := &Keyring{
+		keys: make(map[string]cipher.AEAD),
+	}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encodedKey, ok := strings.Cut(entry, ":")
+		id, encodedKey = strings.TrimSpace(id), strings.TrimSpace(encodedKey)
+		if !ok || !keyIDRegex.MatchString(id) {
+			return nil, fmt.Errorf("invalid key file entry in line %d, expected <key ID>:<base64 key>", line)
+		} else if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key ID %s in line %d", id, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("invalid key in line %d, expected %d base64-encoded bytes", line, keySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		k.primary = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if k.primary == "" {
+		return nil, errors.New("key file does not contain any keys")
+	}
+	return k, nil
+}
+
+// GenerateKey returns a new key file entry (<key ID>:<base64 key>) with a random key. The key ID is
+// derived from the current date, plus a random suffix to keep it unique.
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("%s-%x", time.Now().Format("20060102"), suffix)
+	return fmt.Sprintf("%s:%s", id, base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// PrimaryKeyID returns the ID of the key used to encrypt new data
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Seal encrypts plaintext with the primary key. The additional data is authenticated, but not encrypted,
+// and must be passed to Open as well. It returns the ID of the key used, and the nonce-prefixed ciphertext.
+func (k *Keyring) Seal(plaintext, additionalData []byte) (keyID string, ciphertext []byte, err error) {
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.primary, aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts ciphertext (as returned by Seal) with the key with the given ID
+func (k *Keyring) Open(keyID string, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	} else if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
+
+// EncryptString encrypts a string value with the primary key, and returns it in the form
+// "enc:v1:<key ID>:<base64 ciphertext>". Empty strings are not encrypted.
+func (k *Keyring) EncryptString(value, additionalData string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	keyID, ciphertext, err := k.Seal([]byte(value), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return valuePrefix + keyID + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptString decrypts a value returned by EncryptString. Values that are not encrypted are returned as is.
+func (k *Keyring) DecryptString(value, additionalData string) (string, error) {
+	keyID, encoded, ok := parseValue(value)
+	if !ok {
+		return value, nil
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	plaintext, err := k.Open(keyID, ciphertext, []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption returns true if the string value is not encrypted with the primary key,
+// i.e. if it is plaintext, or if it was encrypted with an older key
+func (k *Keyring) NeedsReencryption(value string) bool {
+	if value == "" {
+		return false
+	}
+	keyID, _, ok := parseValue(value)
+	return !ok || keyID != k.primary
+}
+
+func parseValue(value string) (keyID, encoded string, ok bool) {
+	if !strings.HasPrefix(value, valuePrefix) {
+		return "", "", false
+	}
+	keyID, encoded, ok = strings.Cut(strings.TrimPrefix(value, valuePrefix), ":")
+	if !ok || !keyIDRegex.MatchString(keyID) {
+		return "", "", false
+	}
+	return keyID, encoded, true
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
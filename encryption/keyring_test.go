@@ -0,0 +1,93 @@
+package encryption
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKey1 = "key1:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzEhISE="
+	testKey2 = "key2:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzIhISE="
+)
+
+func TestReadKeyring(t *testing.T) {
+	k, err := ReadKeyring(strings.NewReader("# Old key\n" + testKey1 + "\n\n  " + testKey2 + "  \n"))
+	require.Nil(t, err)
+	require.Equal(t, "key2", k.PrimaryKeyID())
+	require.Len(t, k.keys, 2)
+}
+
+func TestReadKeyring_Invalid(t *testing.T) {
+	for _, contents := range []string{
+		"",
+		"# only a comment",
+		"key1",
+		"key 1:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzEhISE=",
+		"key1:not base64!",
+		"key1:c2hvcnQ=",
+		testKey1 + "\n" + testKey1,
+	} {
+		_, err := ReadKeyring(strings.NewReader(contents))
+		require.Error(t, err, contents)
+	}
+}
+
+func TestLoadKeyring_GenerateKey(t *testing.T) {
+	entry1, err := GenerateKey()
+	require.Nil(t, err)
+	entry2, err := GenerateKey()
+	require.Nil(t, err)
+	require.NotEqual(t, entry1, entry2)
+	filename := filepath.Join(t.TempDir(), "keys")
+	require.Nil(t, os.WriteFile(filename, []byte(entry1+"\n"+entry2+"\n"), 0600))
+	k, err := LoadKeyring(filename)
+	require.Nil(t, err)
+	require.Equal(t, strings.Split(entry2, ":")[0], k.PrimaryKeyID())
+}
+
+func TestKeyring_EncryptDecryptString(t *testing.T) {
+	k1 := newTestKeyring(t, testKey1)
+	k12 := newTestKeyring(t, testKey1, testKey2)
+
+	encrypted, err := k1.EncryptString("hi there", "m1:message")
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(encrypted, "enc:v1:key1:"))
+	require.NotContains(t, encrypted, "hi there")
+	require.False(t, k1.NeedsReencryption(encrypted))
+	require.True(t, k12.NeedsReencryption(encrypted))
+	require.True(t, k12.NeedsReencryption("plaintext"))
+	require.False(t, k12.NeedsReencryption(""))
+
+	// Old keys can still decrypt
+	decrypted, err := k12.DecryptString(encrypted, "m1:message")
+	require.Nil(t, err)
+	require.Equal(t, "hi there", decrypted)
+
+	// Additional data must match
+	_, err = k12.DecryptString(encrypted, "m2:message")
+	require.Equal(t, ErrInvalidCiphertext, err)
+
+	// Unknown key
+	encrypted2, err := k12.EncryptString("hi there", "m1:message")
+	require.Nil(t, err)
+	_, err = k1.DecryptString(encrypted2, "m1:message")
+	require.Equal(t, ErrUnknownKey, err)
+
+	// Plaintext and empty values are passed through
+	decrypted, err = k1.DecryptString("just text", "m1:message")
+	require.Nil(t, err)
+	require.Equal(t, "just text", decrypted)
+	encrypted, err = k1.EncryptString("", "m1:message")
+	require.Nil(t, err)
+	require.Equal(t, "", encrypted)
+}
+
+func newTestKeyring(t *testing.T, entries ...string) *Keyring {
+	k, err := ReadKeyring(strings.NewReader(strings.Join(entries, "\n")))
+	require.Nil(t, err)
+	return k
+}
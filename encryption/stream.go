@@ -0,0 +1,241 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted streams (used for attachments) use envelope encryption: each stream is encrypted with a random
+// data key, which is itself encrypted with the primary key of the keyring and stored in the stream header.
+// The data is split into chunks that are encrypted individually, so that streams can be encrypted and decrypted
+// without buffering them in memory, and so that ranges can be decrypted without reading the entire stream.
+//
+// Stream format:
+//
+//	header:  magic (8 bytes) | key ID (32 bytes, zero-padded) | encrypted data key (60 bytes)
+//	chunks:  AES-GCM(data key, chunk) (up to ChunkSize + 16 bytes), repeated
+//
+// The nonce of each chunk is derived from its index, and a flag marking the final chunk, which protects
+// against reordering and truncation of chunks.
+const (
+	HeaderSize = len(streamMagic) + keyIDMaxSize + wrappedKeySize // Size of the stream header in bytes
+	ChunkSize  = 64 * 1024                                        // Size of plaintext chunks in bytes
+
+	streamMagic    = "NTFYENC1"
+	wrappedKeySize = 12 + keySize + 16 // Nonce, data key, tag
+	chunkOverhead  = 16                // GCM tag
+	encryptedChunk = ChunkSize + chunkOverhead
+)
+
+// IsEncryptedStream returns true if the given stream header (or a prefix of the stream) starts with the
+// magic bytes of an encrypted stream
+func IsEncryptedStream(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(streamMagic))
+}
+
+// StreamKeyID returns the ID of the key that the data key of the stream is encrypted with
+func StreamKeyID(header []byte) string {
+	if len(header) < HeaderSize || !IsEncryptedStream(header) {
+		return ""
+	}
+	return string(bytes.TrimRight(header[len(streamMagic):len(streamMagic)+keyIDMaxSize], "\x00"))
+}
+
+// CiphertextSize returns the size of an encrypted stream for the given plaintext size
+func CiphertextSize(plaintextSize int64) int64 {
+	return int64(HeaderSize) + plaintextSize + chunkCount(plaintextSize)*chunkOverhead
+}
+
+// PlaintextSize returns the plaintext size of an encrypted stream of the given size, or -1 if the size is invalid
+func PlaintextSize(ciphertextSize int64) int64 {
+	body := ciphertextSize - int64(HeaderSize)
+	if body < chunkOverhead {
+		return -1
+	}
+	chunks := (body + encryptedChunk - 1) / encryptedChunk
+	if body-(chunks-1)*encryptedChunk < chunkOverhead {
+		return -1
+	}
+	return body - chunks*chunkOverhead
+}
+
+// CiphertextRange returns the offset and length of the encrypted chunks that contain the plaintext range
+// starting at offset. If length is negative, the range extends until the end of the stream (length -1).
+func CiphertextRange(offset, length int64) (int64, int64) {
+	start := int64(HeaderSize) + (offset/ChunkSize)*encryptedChunk
+	if length < 0 {
+		return start, -1
+	}
+	end := int64(HeaderSize) + ((offset+length+ChunkSize-1)/ChunkSize)*encryptedChunk
+	return start, end - start
+}
+
+// NewEncryptReader returns a reader that encrypts the data from r with a new data key. The additional
+// data is authenticated, and must be passed to NewDecryptReader as well.
+func (k *Keyring) NewEncryptReader(r io.Reader, additionalData []byte) (io.Reader, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrappedKey, err := k.Seal(dataKey, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, HeaderSize)
+	header = append(header, streamMagic...)
+	header = append(header, keyID...)
+	header = append(header, make([]byte, keyIDMaxSize-len(keyID))...)
+	header = append(header, wrappedKey...)
+	return &encryptReader{
+		r:     r,
+		aead:  aead,
+		plain: make([]byte, ChunkSize+1),
+		out:   header,
+	}, nil
+}
+
+// NewDecryptReader returns a reader that decrypts the encrypted stream r of the given total size
+func (k *Keyring) NewDecryptReader(r io.Reader, ciphertextSize int64, additionalData []byte) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return k.NewDecryptRangeReader(header, r, ciphertextSize, 0, additionalData)
+}
+
+// NewDecryptRangeReader returns a reader that decrypts an encrypted stream of the given total size, starting at
+// the plaintext offset. The reader r must be positioned at the start of the range returned by CiphertextRange.
+func (k *Keyring) NewDecryptRangeReader(header []byte, r io.Reader, ciphertextSize, offset int64, additionalData []byte) (io.Reader, error) {
+	if len(header) < HeaderSize || !IsEncryptedStream(header) {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintextSize := PlaintextSize(ciphertextSize)
+	if plaintextSize < 0 || offset < 0 {
+		return nil, ErrInvalidCiphertext
+	}
+	dataKey, err := k.Open(StreamKeyID(header), header[len(streamMagic)+keyIDMaxSize:HeaderSize], additionalData)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	chunks := chunkCount(plaintextSize)
+	return &decryptReader{
+		r:         r,
+		aead:      aead,
+		index:     offset / ChunkSize,
+		chunks:    chunks,
+		finalSize: int(ciphertextSize - int64(HeaderSize) - (chunks-1)*encryptedChunk),
+		buf:       make([]byte, encryptedChunk),
+		skip:      int(offset % ChunkSize),
+	}, nil
+}
+
+type encryptReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	index uint64
+	plain []byte // Plaintext chunk, plus one byte of lookahead to detect the final chunk
+	have  int    // Number of bytes carried over in plain from the previous read
+	out   []byte // Encrypted data not yet returned to the caller
+	done  bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptReader) next() error {
+	n, err := io.ReadFull(r.r, r.plain[r.have:])
+	total := r.have + n
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		r.done = true
+	} else if err != nil {
+		return err
+	}
+	chunk := r.plain[:min(total, ChunkSize)]
+	r.out = r.aead.Seal(r.out[:0], chunkNonce(r.index, r.done), chunk, nil)
+	r.index++
+	if !r.done {
+		r.plain[0] = r.plain[ChunkSize]
+		r.have = 1
+	}
+	return nil
+}
+
+type decryptReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	index     int64
+	chunks    int64
+	finalSize int    // Size of the final encrypted chunk
+	buf       []byte // Encrypted chunk
+	out       []byte // Decrypted data not yet returned to the caller
+	skip      int    // Number of plaintext bytes to skip in the first chunk
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.index >= r.chunks {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptReader) next() error {
+	final := r.index == r.chunks-1
+	size := encryptedChunk
+	if final {
+		size = r.finalSize
+	}
+	if _, err := io.ReadFull(r.r, r.buf[:size]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	plaintext, err := r.aead.Open(r.buf[:0], chunkNonce(uint64(r.index), final), r.buf[:size], nil)
+	if err != nil {
+		return ErrInvalidCiphertext
+	}
+	r.index++
+	r.out = plaintext[min(r.skip, len(plaintext)):]
+	r.skip = 0
+	return nil
+}
+
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	if final {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return nonce
+}
+
+func chunkCount(plaintextSize int64) int64 {
+	return max(1, (plaintextSize+ChunkSize-1)/ChunkSize)
+}
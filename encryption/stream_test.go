@@ -0,0 +1,94 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream_EncryptDecrypt(t *testing.T) {
+	k := newTestKeyring(t, testKey1, testKey2)
+	for _, size := range []int{0, 1, 100, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+		ciphertext := encryptTestStream(t, k, plaintext, "file1")
+		require.Equal(t, CiphertextSize(int64(size)), int64(len(ciphertext)), "size %d", size)
+		require.Equal(t, int64(size), PlaintextSize(int64(len(ciphertext))), "size %d", size)
+		require.True(t, IsEncryptedStream(ciphertext))
+		require.Equal(t, "key2", StreamKeyID(ciphertext))
+
+		reader, err := k.NewDecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)), []byte("file1"))
+		require.Nil(t, err)
+		decrypted, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		require.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestStream_DecryptRange(t *testing.T) {
+	k := newTestKeyring(t, testKey1)
+	plaintext := make([]byte, 3*ChunkSize+17)
+	_, _ = rand.Read(plaintext)
+	ciphertext := encryptTestStream(t, k, plaintext, "file1")
+	for _, r := range [][2]int64{{0, 10}, {5, -1}, {ChunkSize - 3, 6}, {ChunkSize, ChunkSize}, {2*ChunkSize + 100, -1}, {int64(len(plaintext)) - 1, 1}} {
+		offset, length := r[0], r[1]
+		start, n := CiphertextRange(offset, length)
+		end := int64(len(ciphertext))
+		if n >= 0 {
+			end = min(start+n, end)
+		}
+		reader, err := k.NewDecryptRangeReader(ciphertext[:HeaderSize], bytes.NewReader(ciphertext[start:end]), int64(len(ciphertext)), offset, []byte("file1"))
+		require.Nil(t, err)
+		if length >= 0 {
+			reader = io.LimitReader(reader, length)
+		}
+		decrypted, err := io.ReadAll(reader)
+		require.Nil(t, err)
+		expected := plaintext[offset:]
+		if length >= 0 {
+			expected = expected[:length]
+		}
+		require.Equal(t, expected, decrypted, "range %d-%d", offset, length)
+	}
+}
+
+func TestStream_Tampered(t *testing.T) {
+	k := newTestKeyring(t, testKey1)
+	plaintext := make([]byte, 2*ChunkSize)
+	ciphertext := encryptTestStream(t, k, plaintext, "file1")
+
+	// Wrong additional data
+	_, err := k.NewDecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)), []byte("file2"))
+	require.Equal(t, ErrInvalidCiphertext, err)
+
+	// Modified chunk
+	modified := bytes.Clone(ciphertext)
+	modified[HeaderSize+10] ^= 0xff
+	reader, err := k.NewDecryptReader(bytes.NewReader(modified), int64(len(modified)), []byte("file1"))
+	require.Nil(t, err)
+	_, err = io.ReadAll(reader)
+	require.Equal(t, ErrInvalidCiphertext, err)
+
+	// Truncated after the first chunk
+	truncated := ciphertext[:HeaderSize+encryptedChunk]
+	reader, err = k.NewDecryptReader(bytes.NewReader(truncated), int64(len(truncated)), []byte("file1"))
+	require.Nil(t, err)
+	_, err = io.ReadAll(reader)
+	require.Equal(t, ErrInvalidCiphertext, err)
+
+	// Not encrypted
+	require.False(t, IsEncryptedStream([]byte("plain old file")))
+	_, err = k.NewDecryptReader(bytes.NewReader([]byte("plain old file")), 14, []byte("file1"))
+	require.Equal(t, ErrInvalidCiphertext, err)
+}
+
+func encryptTestStream(t *testing.T, k *Keyring, plaintext []byte, additionalData string) []byte {
+	reader, err := k.NewEncryptReader(bytes.NewReader(plaintext), []byte(additionalData))
+	require.Nil(t, err)
+	ciphertext, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	return ciphertext
+}
@@ -0,0 +1,179 @@
+// Package ldap verifies user passwords against an LDAP directory, e.g. OpenLDAP or Active Directory, and
+// reads the groups the user is a member of. It implements the user.Directory interface.
+//
+// Authentication follows the usual "search and bind" approach: ntfy binds as a service account (or anonymously),
+// searches for the user's entry, and then binds as the user with the given password. Nothing is written to the
+// directory.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Defaults for Config
+const (
+	DefaultUserFilter     = "(uid=%s)"
+	DefaultGroupAttribute = "memberOf"
+	DefaultTimeout        = 10 * time.Second
+)
+
+// Errors returned by Directory
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserNotFound       = errors.New("user not found in directory")
+)
+
+// Config is the configuration of a Directory
+type Config struct {
+	URL            string        // Server URL, e.g. ldap://ldap.example.com:389 or ldaps://ldap.example.com:636
+	StartTLS       bool          // Upgrade ldap:// connections via StartTLS
+	BindDN         string        // DN of the service account used to search for users; anonymous bind if empty
+	BindPassword   string        // Password of the service account
+	BaseDN         string        // Base DN to search for users, e.g. ou=people,dc=example,dc=com
+	UserFilter     string        // Filter to find a user entry, "%s" is replaced with the (escaped) username, e.g. "(uid=%s)"
+	GroupAttribute string        // Attribute of the user entry with the user's groups, e.g. "memberOf"
+	GroupBaseDN    string        // If set, groups directly below this DN can also be referred to by their name, e.g. "admins" for "cn=admins,<GroupBaseDN>"
+	Timeout        time.Duration // Timeout for connecting and for each request
+	TLSConfig      *tls.Config   // Optional, for ldaps:// and StartTLS, e.g. to trust a private CA
+}
+
+// Directory is an LDAP directory
+type Directory struct {
+	config      *Config
+	groupBaseDN *ldap.DN // Parsed Config.GroupBaseDN, nil if not set
+}
+
+// New creates a new Directory. It does not connect to the server; a new connection is established
+// for every authentication.
+func New(config *Config) *Directory {
+	if config.UserFilter == "" {
+		config.UserFilter = DefaultUserFilter
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = DefaultGroupAttribute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	d := &Directory{
+		config: config,
+	}
+	if config.GroupBaseDN != "" {
+		d.groupBaseDN, _ = ldap.ParseDN(config.GroupBaseDN) // Validated when reading the config, see ValidDN
+	}
+	return d
+}
+
+// ValidDN returns true if the given string is a valid DN, e.g. "ou=groups,dc=example,dc=com"
+func ValidDN(dn string) bool {
+	parsed, err := ldap.ParseDN(dn)
+	return err == nil && len(parsed.RDNs) > 0
+}
+
+// Authenticate verifies the password of the user with the given username, and returns the groups the user
+// is a member of, as they appear in the group attribute (usually full DNs). If Config.GroupBaseDN is set, the names of
+// groups directly below it (e.g. "admins" for "cn=admins,ou=groups,dc=example,dc=com") are returned as well. Names
+// of groups elsewhere are not, since a group with the same name could be created in any other part of the directory.
+func (d *Directory) Authenticate(username, password string) ([]string, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials // An empty password would be an unauthenticated bind, which always succeeds
+	}
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d.config.BindDN != "" {
+		err = conn.Bind(d.config.BindDN, d.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot bind as service account: %w", err)
+	}
+	filter := strings.ReplaceAll(d.config.UserFilter, "%s", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		int(d.config.Timeout.Seconds()),
+		false,
+		filter,
+		[]string{d.config.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("cannot search for user: %w", err)
+	} else if len(result.Entries) == 0 {
+		return nil, ErrUserNotFound
+	} else if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("filter %s matches %d entries, expected exactly one", filter, len(result.Entries))
+	}
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot bind as user: %w", err)
+	}
+	return d.groups(entry.GetAttributeValues(d.config.GroupAttribute)), nil
+}
+
+func (d *Directory) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(
+		d.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: d.config.Timeout}),
+		ldap.DialWithTLSConfig(d.config.TLSConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", d.config.URL, err)
+	}
+	conn.SetTimeout(d.config.Timeout)
+	if d.config.StartTLS {
+		if err := conn.StartTLS(d.tlsConfig()); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *Directory) tlsConfig() *tls.Config {
+	if d.config.TLSConfig != nil {
+		return d.config.TLSConfig
+	}
+	u, err := url.Parse(d.config.URL)
+	if err != nil {
+		return nil // Cannot happen, since DialURL has already parsed the URL
+	}
+	return &tls.Config{ServerName: u.Hostname()}
+}
+
+// groups returns the given group values, plus the value of the first component of each group DN directly
+// below the group base DN (if set)
+func (d *Directory) groups(values []string) []string {
+	result := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		result = append(result, value)
+		if d.groupBaseDN == nil {
+			continue
+		}
+		dn, err := ldap.ParseDN(value)
+		if err != nil || len(dn.RDNs) < 2 || len(dn.RDNs[0].Attributes) != 1 {
+			continue // Not a DN, e.g. a plain group name, or a multi-valued RDN
+		}
+		parent := &ldap.DN{RDNs: dn.RDNs[1:]}
+		if parent.EqualFold(d.groupBaseDN) {
+			result = append(result, dn.RDNs[0].Attributes[0].Value)
+		}
+	}
+	return result
+}
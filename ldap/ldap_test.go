@@ -0,0 +1,104 @@
+package ldap_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/ldap"
+	"heckel.io/ntfy/v2/ldap/ldaptest"
+)
+
+func TestDirectory_Authenticate(t *testing.T) {
+	d := ldap.New(newTestConfig(newTestServer(t)))
+
+	groups, err := d.Authenticate("phil", "phil-pass")
+	require.Nil(t, err)
+	require.Equal(t, []string{"cn=ntfy-admins,ou=groups,dc=example,dc=com", "cn=ntfy-admins,ou=other,dc=example,dc=com", "ops"}, groups)
+
+	groups, err = d.Authenticate("ben", "ben-pass")
+	require.Nil(t, err)
+	require.Empty(t, groups)
+}
+
+func TestDirectory_Authenticate_GroupBaseDN(t *testing.T) {
+	config := newTestConfig(newTestServer(t))
+	config.GroupBaseDN = "OU=Groups,DC=example,DC=com"
+	groups, err := ldap.New(config).Authenticate("phil", "phil-pass")
+	require.Nil(t, err)
+
+	// Only groups directly below the group base DN can be referred to by name
+	require.Equal(t, []string{"cn=ntfy-admins,ou=groups,dc=example,dc=com", "ntfy-admins", "cn=ntfy-admins,ou=other,dc=example,dc=com", "ops"}, groups)
+}
+
+func TestValidDN(t *testing.T) {
+	require.True(t, ldap.ValidDN("ou=groups,dc=example,dc=com"))
+	require.False(t, ldap.ValidDN("groups"))
+	require.False(t, ldap.ValidDN(""))
+}
+
+func TestDirectory_Authenticate_Failed(t *testing.T) {
+	d := ldap.New(newTestConfig(newTestServer(t)))
+
+	_, err := d.Authenticate("phil", "wrong")
+	require.True(t, errors.Is(err, ldap.ErrInvalidCredentials))
+
+	_, err = d.Authenticate("phil", "") // Must not be an unauthenticated bind
+	require.True(t, errors.Is(err, ldap.ErrInvalidCredentials))
+
+	_, err = d.Authenticate("nobody", "phil-pass")
+	require.True(t, errors.Is(err, ldap.ErrUserNotFound))
+
+	_, err = d.Authenticate("*", "phil-pass") // Filter is escaped
+	require.True(t, errors.Is(err, ldap.ErrUserNotFound))
+}
+
+func TestDirectory_Authenticate_ServiceAccount(t *testing.T) {
+	server := newTestServer(t)
+	config := newTestConfig(server)
+	config.BindDN = "cn=ntfy,ou=services,dc=example,dc=com"
+	config.BindPassword = "wrong"
+	_, err := ldap.New(config).Authenticate("phil", "phil-pass")
+	require.ErrorContains(t, err, "cannot bind as service account")
+
+	config.BindPassword = "service-pass"
+	_, err = ldap.New(config).Authenticate("phil", "phil-pass")
+	require.Nil(t, err)
+}
+
+func TestDirectory_Authenticate_AmbiguousFilter(t *testing.T) {
+	config := newTestConfig(newTestServer(t))
+	config.UserFilter = "(objectClass=person)"
+	_, err := ldap.New(config).Authenticate("phil", "phil-pass")
+	require.ErrorContains(t, err, "expected exactly one")
+}
+
+func TestDirectory_Authenticate_ConnectFailed(t *testing.T) {
+	d := ldap.New(&ldap.Config{URL: "ldap://127.0.0.1:1", BaseDN: "dc=example,dc=com"})
+	_, err := d.Authenticate("phil", "phil-pass")
+	require.ErrorContains(t, err, "cannot connect")
+}
+
+func newTestServer(t *testing.T) *ldaptest.Server {
+	server := ldaptest.NewServer(t)
+	server.AddEntry("cn=ntfy,ou=services,dc=example,dc=com", "service-pass", map[string][]string{
+		"objectClass": {"applicationProcess"},
+	})
+	server.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil-pass", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"phil"},
+		"memberOf":    {"cn=ntfy-admins,ou=groups,dc=example,dc=com", "cn=ntfy-admins,ou=other,dc=example,dc=com", "ops"},
+	})
+	server.AddEntry("uid=ben,ou=people,dc=example,dc=com", "ben-pass", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"ben"},
+	})
+	return server
+}
+
+func newTestConfig(server *ldaptest.Server) *ldap.Config {
+	return &ldap.Config{
+		URL:    server.URL,
+		BaseDN: "ou=people,dc=example,dc=com",
+	}
+}
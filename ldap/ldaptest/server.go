@@ -0,0 +1,254 @@
+// Package ldaptest provides an in-process LDAP server stub for tests, similar to net/http/httptest.
+// This code is not meant to be used outside of tests.
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/stretchr/testify/require"
+)
+
+// LDAP protocol operations (application tags) and result codes, see RFC 4511
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchResultItem = 4
+	opSearchResultDone = 5
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultInvalidCredentials = 49
+	resultUnwillingToPerform = 53
+
+	filterAnd      = 0
+	filterOr       = 1
+	filterNot      = 2
+	filterEquality = 3
+	filterPresent  = 7
+)
+
+// Server is a minimal LDAP server. It supports simple binds, searches with "and", "or", "not", equality and
+// presence filters, and unbinds. Everything else (e.g. StartTLS) is rejected. The server does not enforce any
+// access control: anonymous binds are always allowed, and every bound client can search all entries.
+type Server struct {
+	URL string // Server URL, e.g. ldap://127.0.0.1:12345
+
+	listener net.Listener
+	entries  map[string]*entry // Lowercase DN -> entry
+	mu       sync.Mutex
+}
+
+type entry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// NewServer starts a new LDAP server, which is stopped when the test ends
+func NewServer(t *testing.T) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := &Server{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  make(map[string]*entry),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return s
+}
+
+// AddEntry adds an entry with the given DN, password (for binds) and attributes, e.g. "uid" and "memberOf"
+func (s *Server) AddEntry(dn, password string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[strings.ToLower(dn)] = &entry{
+		dn:         dn,
+		password:   password,
+		attributes: attributes,
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		} else if len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		var responses []*ber.Packet
+		switch op.Tag {
+		case opBindRequest:
+			responses = []*ber.Packet{s.handleBind(messageID, op)}
+		case opSearchRequest:
+			responses = s.handleSearch(messageID, op)
+		case opUnbindRequest:
+			return
+		case opExtendedRequest:
+			responses = []*ber.Packet{result(messageID, opExtendedResponse, resultUnwillingToPerform, "not supported")}
+		default:
+			return
+		}
+		for _, response := range responses {
+			if _, err := conn.Write(response.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handleBind(messageID int64, op *ber.Packet) *ber.Packet {
+	if len(op.Children) < 3 {
+		return result(messageID, opBindResponse, resultProtocolError, "invalid bind request")
+	}
+	dn := op.Children[1].Data.String()
+	password := op.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return result(messageID, opBindResponse, resultSuccess, "") // Anonymous bind
+	}
+	s.mu.Lock()
+	e, ok := s.entries[strings.ToLower(dn)]
+	s.mu.Unlock()
+	if !ok || e.password == "" || e.password != password {
+		return result(messageID, opBindResponse, resultInvalidCredentials, "invalid credentials")
+	}
+	return result(messageID, opBindResponse, resultSuccess, "")
+}
+
+func (s *Server) handleSearch(messageID int64, op *ber.Packet) []*ber.Packet {
+	if len(op.Children) < 8 {
+		return []*ber.Packet{result(messageID, opSearchResultDone, resultProtocolError, "invalid search request")}
+	}
+	baseDN := strings.ToLower(op.Children[0].Data.String())
+	filter := op.Children[6]
+	requested := make([]string, 0)
+	for _, attr := range op.Children[7].Children {
+		requested = append(requested, attr.Data.String())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	responses := make([]*ber.Packet, 0)
+	for lowerDN, e := range s.entries {
+		if !strings.HasSuffix(lowerDN, baseDN) || !matches(e, filter) {
+			continue
+		}
+		responses = append(responses, searchResultEntry(messageID, e, requested))
+	}
+	return append(responses, result(messageID, opSearchResultDone, resultSuccess, ""))
+}
+
+func matches(e *entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if !matches(e, child) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.Children {
+			if matches(e, child) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(filter.Children) == 1 && !matches(e, filter.Children[0])
+	case filterEquality:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, value := range attributeValues(e, filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		name := filter.Data.String()
+		return strings.EqualFold(name, "objectClass") || len(attributeValues(e, name)) > 0
+	}
+	return false
+}
+
+func attributeValues(e *entry, name string) []string {
+	for attr, values := range e.attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func searchResultEntry(messageID int64, e *entry, requested []string) *ber.Packet {
+	item := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultItem, nil, "Search Result Entry")
+	item.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attributes {
+		if !wanted(name, requested) {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	item.AppendChild(attributes)
+	return envelope(messageID, item)
+}
+
+func wanted(name string, requested []string) bool {
+	if len(requested) == 0 {
+		return true
+	}
+	for _, r := range requested {
+		if r == "*" || strings.EqualFold(r, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func result(messageID int64, tag ber.Tag, code int64, message string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return envelope(messageID, op)
+}
+
+func envelope(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Message")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(op)
+	return packet
+}
@@ -161,11 +161,19 @@ func (e *Event) Render(l Level, message string, v ...any) string {
 	return e.String()
 }
 
-// Log logs the event to the defined output, or does nothing if Render returns an empty string
+// Log logs the event to the defined output (or sink), or does nothing if Render returns an empty string
 func (e *Event) Log(l Level, message string, v ...any) *Event {
-	if m := e.Render(l, message, v...); m != "" {
-		log.Println(m)
+	m := e.Render(l, message, v...)
+	if m == "" {
+		return e
+	}
+	if s := currentSink(); s != nil {
+		if err := s.Write(e, m); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write to log sink: %s\n%s\n", err.Error(), m) // Don't lose the event
+		}
+		return e
 	}
+	log.Println(m)
 	return e
 }
 
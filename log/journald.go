@@ -0,0 +1,99 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultJournaldSocket is the socket of the systemd journal
+	DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+	journaldFieldPrefix = "NTFY_"
+)
+
+// JournaldSink is a Sink that sends log events to the systemd journal using its native protocol, so that
+// the level and all log fields are stored as structured fields. Log fields are prefixed with NTFY_ and
+// upper-cased, e.g. the field "user_id" becomes NTFY_USER_ID and can be queried with "journalctl NTFY_USER_ID=u_123".
+type JournaldSink struct {
+	conn *net.UnixConn
+	mu   sync.Mutex
+}
+
+var _ Sink = (*JournaldSink)(nil)
+
+// NewJournaldSink creates a new journald sink for the given socket. If the socket is empty,
+// DefaultJournaldSocket is used.
+func NewJournaldSink(socket string) (*JournaldSink, error) {
+	if socket == "" {
+		socket = DefaultJournaldSocket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournaldSink{conn: conn}, nil
+}
+
+// Write sends the event to the journal
+func (s *JournaldSink) Write(e *Event, rendered string) error {
+	var b bytes.Buffer
+	writeJournaldField(&b, "MESSAGE", rendered)
+	writeJournaldField(&b, "PRIORITY", strconv.Itoa(e.Level.syslogSeverity()))
+	writeJournaldField(&b, "SYSLOG_IDENTIFIER", syslogAppName)
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeJournaldField(&b, journaldFieldName(k), fmt.Sprintf("%v", e.fields[k]))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write(b.Bytes())
+	return err
+}
+
+// Close closes the connection to the journal
+func (s *JournaldSink) Close() error {
+	return s.conn.Close()
+}
+
+// writeJournaldField writes a field in the journal's native format. Values containing newlines must be
+// written in the binary format, i.e. the field name, a newline, the value length (64-bit little endian),
+// the value and a newline.
+func writeJournaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteString("=")
+		b.WriteString(value)
+		b.WriteString("\n")
+		return
+	}
+	b.WriteString("\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+// journaldFieldName converts a log field name to a valid journal field name, which may only
+// contain upper-case letters, digits and underscores
+func journaldFieldName(field string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return '_'
+	}, field)
+	return journaldFieldPrefix + name
+}
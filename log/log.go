@@ -21,8 +21,9 @@ var (
 	format              = DefaultFormat
 	overrides           = make(map[string][]*levelOverride)
 	output    io.Writer = DefaultOutput
-	filename            = ""
-	mu                  = &sync.RWMutex{}
+	sink      Sink      // If set, events are written to the sink instead of the output writer
+	filename  = ""
+	mu        = &sync.RWMutex{}
 )
 
 // init sets the default log output (including log.SetOutput)
@@ -141,12 +142,13 @@ func SetFormat(newFormat Format) {
 	}
 }
 
-// SetOutput sets the log output writer
+// SetOutput sets the log output writer, and removes the sink (see SetSink), if any
 func SetOutput(w io.Writer) {
 	mu.Lock()
 	defer mu.Unlock()
 	output = &peekLogWriter{w}
-	if f, ok := w.(*os.File); ok {
+	sink = nil
+	if f, ok := w.(namedFile); ok {
 		filename = f.Name()
 	} else {
 		filename = ""
@@ -154,6 +156,23 @@ func SetOutput(w io.Writer) {
 	log.SetOutput(output)
 }
 
+// SetSink sets a sink that receives all log events instead of the output writer. Lines written
+// by the standard Go logger are passed to the sink as well. Call SetOutput to remove the sink.
+func SetSink(s Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sink = s
+	filename = ""
+	log.SetOutput(&sinkStdLogWriter{})
+}
+
+// currentSink returns the current sink, or nil if events are written to the output writer
+func currentSink() Sink {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sink
+}
+
 // File returns the log file, if any, or an empty string otherwise
 func File() string {
 	mu.RLock()
@@ -188,6 +207,11 @@ func IsDebug() bool {
 	return Loggable(DebugLevel)
 }
 
+// namedFile is implemented by *os.File and RotatingFile, so that File can return the log file name
+type namedFile interface {
+	Name() string
+}
+
 // peekLogWriter is an io.Writer which will peek at the rendered log event,
 // and ensure that the rendered output is valid JSON. This is a hack!
 type peekLogWriter struct {
@@ -0,0 +1,249 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rotatedFileTimeFormat = "20060102-150405.000" // Suffix of rotated log files, e.g. ntfy.log.20260102-150405.000
+	rotatedFileGzipSuffix = ".gz"
+)
+
+// rename renames the log file when rotating, overridden in tests to simulate failures
+var rename = os.Rename
+
+// RotateConfig defines when a RotatingFile is rotated, and what happens to the rotated files
+type RotateConfig struct {
+	MaxSize    int64         // Rotate if the file would grow beyond this many bytes; 0 disables size-based rotation
+	Interval   time.Duration // Rotate at every multiple of this interval (e.g. 24h rotates at midnight UTC); 0 disables time-based rotation
+	MaxBackups int           // Number of rotated files to keep; 0 keeps all of them
+	Compress   bool          // Compress rotated files with gzip
+}
+
+// RotatingFile is an io.WriteCloser that writes to a log file, and rotates it based on its size and/or on time.
+// Rotated files are renamed to <filename>.<timestamp> (e.g. ntfy.log.20260102-150405.000), compressed in the
+// background if configured, and old rotated files beyond RotateConfig.MaxBackups are deleted.
+//
+// Unlike logrotate's copytruncate, rotating happens under the write lock, so no lines are lost.
+type RotatingFile struct {
+	filename string
+	config   *RotateConfig
+	file     *os.File
+	size     int64
+	opened   time.Time      // Time the current file was started, used for time-based rotation
+	wg       sync.WaitGroup // Background compression and cleanup of rotated files
+	cleanMu  sync.Mutex     // Serializes cleanups, so that they don't race each other
+	mu       sync.Mutex
+}
+
+var _ io.WriteCloser = (*RotatingFile)(nil)
+
+// NewRotatingFile opens (or creates) the given log file for appending, and rotates it according to the given config
+func NewRotatingFile(filename string, config *RotateConfig) (*RotatingFile, error) {
+	f := &RotatingFile{
+		filename: filename,
+		config:   config,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write writes p to the log file, rotating the file first if necessary
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(int64(len(p))) {
+		_ = f.rotate() // On failure, the error is printed to stderr, and we keep writing to the reopened file
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the log file, regardless of its size or age
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// Name returns the name of the log file
+func (f *RotatingFile) Name() string {
+	return f.filename
+}
+
+// Close closes the log file, and waits for the compression and cleanup of rotated files to finish
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wg.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.closeFile()
+	f.file = nil
+	return err
+}
+
+// closeFile closes the current file, unless rotating fell back to stderr (see recoverRotate)
+func (f *RotatingFile) closeFile() error {
+	if f.file == os.Stderr {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = stat.Size()
+	f.opened = time.Now()
+	if f.size > 0 {
+		f.opened = stat.ModTime() // Existing file: rotate immediately if it was last written in a previous interval
+	}
+	return nil
+}
+
+func (f *RotatingFile) shouldRotate(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.config.MaxSize > 0 && f.size+n > f.config.MaxSize {
+		return true
+	}
+	if f.config.Interval > 0 && !time.Now().Truncate(f.config.Interval).Equal(f.opened.Truncate(f.config.Interval)) {
+		return true
+	}
+	return false
+}
+
+// rotate renames the current log file and opens a new one. If that fails, the log file is reopened (or
+// logging falls back to stderr), so that a transient error does not stop logging for good.
+func (f *RotatingFile) rotate() error {
+	if err := f.closeFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to close log file %s: %s\n", f.filename, err.Error())
+	}
+	f.file = nil
+	rotated := fmt.Sprintf("%s.%s", f.filename, time.Now().UTC().Format(rotatedFileTimeFormat))
+	if err := rename(f.filename, rotated); err != nil {
+		return f.recoverRotate(err)
+	}
+	if err := f.open(); err != nil {
+		return f.recoverRotate(err)
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.cleanup(rotated)
+	}()
+	return nil
+}
+
+// recoverRotate handles a failed rotation: it prints the error to stderr, and reopens the log file for appending,
+// or falls back to stderr if that fails too. The next rotation is only attempted once the file is due again, so that
+// a persistent error does not cause a rotation attempt on every write.
+func (f *RotatingFile) recoverRotate(err error) error {
+	fmt.Fprintf(os.Stderr, "Unable to rotate log file %s: %s\n", f.filename, err.Error())
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to reopen log file %s, logging to stderr: %s\n", f.filename, err.Error())
+			f.file = os.Stderr
+		}
+	}
+	f.size = 0
+	f.opened = time.Now()
+	return err
+}
+
+// cleanup compresses the rotated file (if configured), and deletes old rotated files beyond the configured
+// number of backups. Errors are printed to stderr, since logging them could cause another rotation.
+func (f *RotatingFile) cleanup(rotated string) {
+	f.cleanMu.Lock()
+	defer f.cleanMu.Unlock()
+	if f.config.Compress {
+		if err := gzipFile(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to compress rotated log file %s: %s\n", rotated, err.Error())
+		}
+	}
+	if f.config.MaxBackups <= 0 {
+		return
+	}
+	backups, err := f.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to list rotated log files: %s\n", err.Error())
+		return
+	}
+	for len(backups) > f.config.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to remove rotated log file %s: %s\n", backups[0], err.Error())
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns all rotated log files, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(f.filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(match, f.filename+"."), rotatedFileGzipSuffix)
+		if _, err := time.Parse(rotatedFileTimeFormat, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups) // The timestamp format sorts chronologically
+	return backups, nil
+}
+
+// gzipFile compresses the given file to <filename>.gz, and removes the original
+func gzipFile(filename string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(filename+rotatedFileGzipSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
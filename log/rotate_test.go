@@ -0,0 +1,139 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile_MaxSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, &RotateConfig{MaxSize: 10})
+	require.Nil(t, err)
+	_, err = f.Write([]byte("123456\n"))
+	require.Nil(t, err)
+	_, err = f.Write([]byte("abcdef\n")) // Would exceed 10 bytes, rotates first
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, "abcdef\n", string(contents))
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Len(t, backups, 1)
+	contents, err = os.ReadFile(backups[0])
+	require.Nil(t, err)
+	require.Equal(t, "123456\n", string(contents))
+}
+
+func TestRotatingFile_MaxBackups_Compress(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, &RotateConfig{MaxBackups: 2, Compress: true})
+	require.Nil(t, err)
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		_, err := f.Write([]byte(line))
+		require.Nil(t, err)
+		require.Nil(t, f.Rotate())
+		time.Sleep(2 * time.Millisecond) // Rotated file names have millisecond precision
+	}
+	require.Nil(t, f.Close())
+
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Len(t, backups, 2)
+	for i, expected := range []string{"three\n", "four\n"} {
+		require.True(t, filepath.Ext(backups[i]) == rotatedFileGzipSuffix)
+		in, err := os.Open(backups[i])
+		require.Nil(t, err)
+		gz, err := gzip.NewReader(in)
+		require.Nil(t, err)
+		contents, err := io.ReadAll(gz)
+		require.Nil(t, err)
+		require.Equal(t, expected, string(contents))
+		in.Close()
+	}
+}
+
+func TestRotatingFile_RenameFails(t *testing.T) {
+	rename = func(string, string) error {
+		return os.ErrPermission
+	}
+	t.Cleanup(func() { rename = os.Rename })
+
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, &RotateConfig{MaxSize: 10})
+	require.Nil(t, err)
+	_, err = f.Write([]byte("123456\n"))
+	require.Nil(t, err)
+	_, err = f.Write([]byte("abcdef\n")) // Rotation fails, file is reopened
+	require.Nil(t, err)
+	require.ErrorIs(t, f.Rotate(), os.ErrPermission)
+	_, err = f.Write([]byte("ghijkl\n"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, "123456\nabcdef\nghijkl\n", string(contents))
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Empty(t, backups)
+}
+
+func TestRotatingFile_OpenFails(t *testing.T) {
+	rename = func(oldpath, newpath string) error {
+		if err := os.Rename(oldpath, newpath); err != nil {
+			return err
+		}
+		return os.Mkdir(oldpath, 0700) // Log file cannot be opened anymore
+	}
+	t.Cleanup(func() { rename = os.Rename })
+
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, &RotateConfig{MaxSize: 10})
+	require.Nil(t, err)
+	_, err = f.Write([]byte("123456\n"))
+	require.Nil(t, err)
+	_, err = f.Write([]byte("abcdef\n")) // Rotation fails, falls back to stderr
+	require.Nil(t, err)
+	require.Equal(t, os.Stderr, f.file)
+	require.Nil(t, f.Close())
+}
+
+func TestRotatingFile_Interval(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	require.Nil(t, os.WriteFile(filename, []byte("yesterday\n"), 0600))
+	yesterday := time.Now().Add(-24 * time.Hour)
+	require.Nil(t, os.Chtimes(filename, yesterday, yesterday))
+
+	f, err := NewRotatingFile(filename, &RotateConfig{Interval: time.Hour})
+	require.Nil(t, err)
+	_, err = f.Write([]byte("today\n"))
+	require.Nil(t, err)
+	_, err = f.Write([]byte("still today\n")) // Same interval, no rotation
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	contents, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	require.Equal(t, "today\nstill today\n", string(contents))
+	backups, err := f.backups()
+	require.Nil(t, err)
+	require.Len(t, backups, 1)
+}
+
+func TestRotatingFile_SetOutput(t *testing.T) {
+	t.Cleanup(resetState)
+	filename := filepath.Join(t.TempDir(), "ntfy.log")
+	f, err := NewRotatingFile(filename, &RotateConfig{MaxSize: 1024})
+	require.Nil(t, err)
+	SetOutput(f)
+	require.True(t, IsFile())
+	require.Equal(t, filename, File())
+}
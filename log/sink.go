@@ -0,0 +1,52 @@
+package log
+
+import (
+	"strings"
+)
+
+// Sink is a log destination that receives every log event individually, including its level and fields,
+// as opposed to the output writer (see SetOutput), which only receives the rendered lines. This is used
+// for destinations that store the level and fields separately, such as syslog or journald.
+//
+// Events are only passed to the sink if they are loggable, i.e. log levels and level overrides are
+// applied before the sink is called.
+type Sink interface {
+	// Write writes the event to the sink. rendered is the event as rendered in the current log format.
+	Write(e *Event, rendered string) error
+
+	// Close closes the sink and releases all resources
+	Close() error
+}
+
+// Syslog severities, see RFC 5424, section 6.2.1
+const (
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityInfo     = 6
+	severityDebug    = 7
+)
+
+// syslogSeverity maps the log level to a syslog severity, as used by syslog and journald
+func (l Level) syslogSeverity() int {
+	switch l {
+	case FatalLevel:
+		return severityCritical
+	case ErrorLevel:
+		return severityError
+	case WarnLevel:
+		return severityWarning
+	case InfoLevel:
+		return severityInfo
+	}
+	return severityDebug
+}
+
+// sinkStdLogWriter is an io.Writer that turns lines written by the standard Go logger (e.g. from other
+// libraries) into log events, so that they are passed to the sink as well
+type sinkStdLogWriter struct{}
+
+func (w *sinkStdLogWriter) Write(p []byte) (n int, err error) {
+	newEvent().Tag(tagStdLog).Info("%s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,157 @@
+package log
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memorySink struct {
+	events   []*Event
+	rendered []string
+}
+
+func (s *memorySink) Write(e *Event, rendered string) error {
+	s.events = append(s.events, e)
+	s.rendered = append(s.rendered, rendered)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestSink_LevelOverrides(t *testing.T) {
+	t.Cleanup(resetState)
+	sink := &memorySink{}
+	SetSink(sink)
+	SetLevel(WarnLevel)
+	SetLevelOverride("tag", "stripe", DebugLevel)
+
+	Tag("manager").Info("this is not logged")
+	Tag("stripe").Debug("this is logged")
+	Error("so is this")
+	require.Len(t, sink.events, 2)
+	require.Equal(t, DebugLevel, sink.events[0].Level)
+	require.Equal(t, "this is logged", sink.events[0].Message)
+	require.Equal(t, "DEBUG this is logged (tag=stripe)", sink.rendered[0])
+	require.Equal(t, ErrorLevel, sink.events[1].Level)
+	require.False(t, IsFile())
+}
+
+func TestSink_UsingStdLogger(t *testing.T) {
+	t.Cleanup(resetState)
+	sink := &memorySink{}
+	SetSink(sink)
+	SetFormat(JSONFormat)
+	log.Println("Some other library is using the standard Go logger")
+	require.Len(t, sink.events, 1)
+	require.Equal(t, InfoLevel, sink.events[0].Level)
+	require.Equal(t, tagStdLog, sink.events[0].fields[fieldTag])
+	require.Contains(t, sink.rendered[0], `"message":"Some other library is using the standard Go logger"`)
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	t.Cleanup(resetState)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	sink, err := NewSyslogSink("udp://" + conn.LocalAddr().String())
+	require.Nil(t, err)
+	defer sink.Close()
+	SetSink(sink)
+	Tag("manager").Field("user_id", "u_123").Warn("Something is %s", "odd")
+
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.Nil(t, err)
+	hostname, _ := os.Hostname()
+	expectedPrefix := "<28>1 " // facility daemon (3) * 8 + severity warning (4)
+	expectedSuffix := fmt.Sprintf(" %s ntfy %d manager - WARN Something is odd (tag=manager, user_id=u_123)", hostname, os.Getpid())
+	require.True(t, strings.HasPrefix(string(buf[:n]), expectedPrefix), string(buf[:n]))
+	require.True(t, strings.HasSuffix(string(buf[:n]), expectedSuffix), string(buf[:n]))
+}
+
+func TestSyslogSink_TCP_OctetCounting(t *testing.T) {
+	t.Cleanup(resetState)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			var length int
+			if _, err := fmt.Fscanf(r, "%d ", &length); err != nil {
+				return
+			}
+			msg := make([]byte, length)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			lines <- string(msg)
+		}
+	}()
+
+	sink, err := NewSyslogSink("tcp://" + listener.Addr().String())
+	require.Nil(t, err)
+	defer sink.Close()
+	SetSink(sink)
+	Error("first\nline")
+	Info("second")
+	require.True(t, strings.HasPrefix(<-lines, "<27>1 "))
+	second := <-lines
+	require.True(t, strings.HasPrefix(second, "<30>1 "))
+	require.True(t, strings.HasSuffix(second, " - - INFO second"))
+}
+
+func TestSyslogSink_InvalidAddress(t *testing.T) {
+	_, err := NewSyslogSink("http://localhost:514")
+	require.Error(t, err)
+	_, err = NewSyslogSink("udp://")
+	require.Error(t, err)
+}
+
+func TestJournaldSink(t *testing.T) {
+	t.Cleanup(resetState)
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	sink, err := NewJournaldSink(socket)
+	require.Nil(t, err)
+	defer sink.Close()
+	SetSink(sink)
+	Tag("publish").Fields(Context{"user_id": "u_123", "message.id": "abc"}).Error("multi\nline")
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.Nil(t, err)
+	var multiline []byte
+	multiline = append(multiline, "MESSAGE\n"...)
+	multiline = binary.LittleEndian.AppendUint64(multiline, uint64(len("ERROR multi\nline (message.id=abc, tag=publish, user_id=u_123)")))
+	multiline = append(multiline, "ERROR multi\nline (message.id=abc, tag=publish, user_id=u_123)\n"...)
+	expected := string(multiline) +
+		"PRIORITY=3\n" +
+		"SYSLOG_IDENTIFIER=ntfy\n" +
+		"NTFY_MESSAGE_ID=abc\n" +
+		"NTFY_TAG=publish\n" +
+		"NTFY_USER_ID=u_123\n"
+	require.Equal(t, expected, string(buf[:n]))
+}
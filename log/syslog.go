@@ -0,0 +1,140 @@
+package log
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSyslogAddress is the address of the local syslog daemon
+	DefaultSyslogAddress = "unix:///dev/log"
+
+	syslogFacilityDaemon = 3 // See RFC 5424, section 6.2.1
+	syslogAppName        = "ntfy"
+	syslogNilValue       = "-"
+	syslogDialTimeout    = 5 * time.Second
+)
+
+// SyslogSink is a Sink that sends log events to a syslog server in the RFC 5424 format,
+// via UDP, TCP (with octet-counting framing, see RFC 6587) or a unix socket
+type SyslogSink struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+	mu       sync.Mutex
+}
+
+var _ Sink = (*SyslogSink)(nil)
+
+// NewSyslogSink creates a new syslog sink for the given address, e.g. udp://localhost:514, tcp://logs.example.com:601
+// or unix:///dev/log. If the address is empty, DefaultSyslogAddress is used.
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	if address == "" {
+		address = DefaultSyslogAddress
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", address, err)
+	}
+	s := &SyslogSink{
+		network: u.Scheme,
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q, host missing, e.g. udp://localhost:514", address)
+		}
+		s.address = u.Host
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid syslog address %q, socket path missing, e.g. unix:///dev/log", address)
+		}
+		s.address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q, must start with udp://, tcp:// or unix://", address)
+	}
+	s.hostname, err = os.Hostname()
+	if err != nil || s.hostname == "" {
+		s.hostname = syslogNilValue
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write sends the event to the syslog server. If sending fails, it reconnects and tries once more.
+func (s *SyslogSink) Write(e *Event, rendered string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	line := s.format(e, rendered)
+	if s.conn != nil {
+		if _, err := s.conn.Write(line); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(line)
+	return err
+}
+
+// Close closes the connection to the syslog server
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogSink) connect() error {
+	var conn net.Conn
+	var err error
+	if s.network == "unix" {
+		// Local syslog daemons usually listen on a datagram socket, but some use a stream socket
+		if conn, err = net.DialTimeout("unixgram", s.address, syslogDialTimeout); err != nil {
+			conn, err = net.DialTimeout("unix", s.address, syslogDialTimeout)
+		}
+	} else {
+		conn, err = net.DialTimeout(s.network, s.address, syslogDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// format renders the event as an RFC 5424 message, i.e. "<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG".
+// The log tag (if any) is used as MSGID. TCP messages are prefixed with their length (octet counting).
+func (s *SyslogSink) format(e *Event, rendered string) []byte {
+	msgID := syslogNilValue
+	if tag, ok := e.fields[fieldTag].(string); ok && tag != "" {
+		msgID = tag
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		syslogFacilityDaemon*8+e.Level.syslogSeverity(),
+		e.time.Format(time.RFC3339Nano),
+		s.hostname,
+		syslogAppName,
+		os.Getpid(),
+		msgID,
+		rendered,
+	)
+	if s.network == "tcp" {
+		return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+	}
+	return []byte(msg)
+}
@@ -4,12 +4,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/netip"
 	"strings"
 	"sync"
 	"time"
 
 	"heckel.io/ntfy/v2/db"
+	"heckel.io/ntfy/v2/encryption"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/model"
 	"heckel.io/ntfy/v2/util"
@@ -20,32 +22,54 @@ const (
 	schemaStore     = "message" // Store name in the schema_version table (see db/schema)
 )
 
-var errNoRows = errors.New("no rows found")
+var (
+	errNoRows               = errors.New("no rows found")
+	errEncryptionNotEnabled = errors.New("message encryption is not enabled")
+)
 
 // queries holds the database-specific SQL queries
 type queries struct {
-	insertMessage                    string
-	selectScheduledMessageIDsBySeqID string
-	deleteScheduledBySequenceID      string
-	updateMessagesForTopicExpiry     string
-	selectMessagesByID               string
-	selectMessagesSinceTime          string
-	selectMessagesSinceTimeScheduled string
-	selectMessagesSinceID            string
-	selectMessagesSinceIDScheduled   string
-	selectMessagesLatest             string
-	selectMessagesDue                string
-	deleteExpiredMessages            string
-	updateMessagePublished           string
-	selectMessagesCount              string
-	selectTopics                     string
-	markExpiredAttachmentsDeleted    string
-	selectAttachmentsSizeBySender    string
-	selectAttachmentsSizeByUserID    string
-	selectAttachmentsWithSizes       string
-	selectStats                      string
-	updateStats                      string
-	updateMessageTime                string
+	insertMessage                     string
+	selectScheduledMessageIDsBySeqID  string
+	deleteScheduledBySequenceID       string
+	updateMessagesForTopicExpiry      string
+	selectMessagesByID                string
+	selectMessagesSinceTime           string
+	selectMessagesSinceTimeScheduled  string
+	selectMessagesSinceID             string
+	selectMessagesSinceIDScheduled    string
+	selectMessagesLatest              string
+	selectMessagesDue                 string
+	deleteExpiredMessages             string
+	updateMessagePublished            string
+	selectMessagesCount               string
+	selectTopics                      string
+	markExpiredAttachmentsDeleted     string
+	selectAttachmentsSizeBySender     string
+	selectAttachmentsSizeByUserID     string
+	selectAttachmentObjects           string
+	selectReferencedAttachmentObjects string
+	selectStats                       string
+	updateStats                       string
+	updateMessageTime                 string
+	selectMessagesText                string
+	updateMessageText                 string
+	insertCallAck                     string
+	selectCallAck                     string
+	deleteCallAcksBefore              string
+	insertSMSStatus                   string
+	selectSMSStatuses                 string
+	deleteSMSStatusesBefore           string
+}
+
+// SMSStatus is a delivery status the telephony provider reported for an SMS that was sent for a message
+type SMSStatus struct {
+	MessageID   string
+	SID         string // Provider-assigned ID of the SMS
+	PhoneNumber string
+	Status      string // e.g. "sent", "delivered", "undelivered" or "failed"
+	ErrorCode   string // Provider-specific error code, if any
+	Time        int64
 }
 
 // Cache stores published messages
@@ -55,9 +79,10 @@ type Cache struct {
 	nop     bool
 	mu      *sync.Mutex // nil for PostgreSQL (concurrent writes supported), set for SQLite (single writer)
 	queries queries
+	keyring *encryption.Keyring // nil if encryption at rest is disabled
 }
 
-func newCache(db *db.DB, queries queries, mu *sync.Mutex, batchSize int, batchTimeout time.Duration, nop bool) *Cache {
+func newCache(db *db.DB, queries queries, mu *sync.Mutex, batchSize int, batchTimeout time.Duration, keyring *encryption.Keyring, nop bool) *Cache {
 	var queue *util.BatchingQueue[*model.Message]
 	if batchSize > 0 || batchTimeout > 0 {
 		queue = util.NewBatchingQueue[*model.Message](batchSize, batchTimeout)
@@ -68,6 +93,7 @@ func newCache(db *db.DB, queries queries, mu *sync.Mutex, batchSize int, batchTi
 		nop:     nop,
 		mu:      mu,
 		queries: queries,
+		keyring: keyring,
 	}
 	go c.processMessageBatches()
 	return c
@@ -121,12 +147,12 @@ func (c *Cache) addMessages(ms []*model.Message) error {
 	}
 	defer stmt.Close()
 	for _, m := range ms {
-		if m.Event != model.MessageEvent && m.Event != model.MessageDeleteEvent && m.Event != model.MessageClearEvent {
+		if m.Event != model.MessageEvent && m.Event != model.MessageDeleteEvent && m.Event != model.MessageClearEvent && m.Event != model.MessageAckEvent {
 			return model.ErrUnexpectedMessageType
 		}
 		published := m.Time <= time.Now().Unix()
 		tags := util.SanitizeUTF8(strings.Join(m.Tags, ","))
-		var attachmentName, attachmentType, attachmentURL string
+		var attachmentName, attachmentType, attachmentURL, attachmentThumbnail, attachmentObject, attachmentSHA256 string
 		var attachmentSize, attachmentExpires int64
 		var attachmentDeleted bool
 		if m.Attachment != nil {
@@ -135,6 +161,9 @@ func (c *Cache) addMessages(ms []*model.Message) error {
 			attachmentSize = m.Attachment.Size
 			attachmentExpires = m.Attachment.Expires
 			attachmentURL = util.SanitizeUTF8(m.Attachment.URL)
+			attachmentThumbnail = util.SanitizeUTF8(m.Attachment.Thumbnail)
+			attachmentObject = m.Attachment.Object
+			attachmentSHA256 = m.Attachment.SHA256
 		}
 		var actionsStr string
 		if len(m.Actions) > 0 {
@@ -148,15 +177,19 @@ func (c *Cache) addMessages(ms []*model.Message) error {
 		if m.Sender.IsValid() {
 			sender = m.Sender.String()
 		}
-		_, err := stmt.Exec(
+		msg, title, err := c.encryptText(m.ID, util.SanitizeUTF8(m.Message), util.SanitizeUTF8(m.Title))
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(
 			m.ID,
 			m.SequenceID,
 			m.Time,
 			m.Event,
 			m.Expires,
 			util.SanitizeUTF8(m.Topic),
-			util.SanitizeUTF8(m.Message),
-			util.SanitizeUTF8(m.Title),
+			msg,
+			title,
 			m.Priority,
 			tags,
 			util.SanitizeUTF8(m.Click),
@@ -167,6 +200,9 @@ func (c *Cache) addMessages(ms []*model.Message) error {
 			attachmentSize,
 			attachmentExpires,
 			attachmentURL,
+			attachmentThumbnail,
+			attachmentObject,
+			attachmentSHA256,
 			attachmentDeleted, // Always zero
 			sender,
 			m.User,
@@ -210,7 +246,7 @@ func (c *Cache) messagesSinceTime(topic string, since model.SinceMarker, schedul
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 func (c *Cache) messagesSinceID(topic string, since model.SinceMarker, scheduled bool) ([]*model.Message, error) {
@@ -225,7 +261,7 @@ func (c *Cache) messagesSinceID(topic string, since model.SinceMarker, scheduled
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 func (c *Cache) messagesLatest(topic string) ([]*model.Message, error) {
@@ -233,7 +269,7 @@ func (c *Cache) messagesLatest(topic string) ([]*model.Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 // MessagesDue returns all messages that are due for publishing
@@ -242,7 +278,7 @@ func (c *Cache) MessagesDue() ([]*model.Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 // DeleteExpiredMessages deletes up to `limit` expired messages in a single query
@@ -267,7 +303,7 @@ func (c *Cache) Message(id string) (*model.Message, error) {
 	if !rows.Next() {
 		return nil, model.ErrMessageNotFound
 	}
-	return readMessage(rows)
+	return c.readMessage(rows)
 }
 
 // UpdateMessageTime updates the time column for a message by ID. This is only used for testing.
@@ -314,7 +350,8 @@ func (c *Cache) Topics() ([]string, error) {
 }
 
 // DeleteScheduledBySequenceID deletes unpublished (scheduled) messages with the given topic and sequence ID.
-// It returns the message IDs of the deleted messages, which can be used to clean up attachment files.
+// It returns the attachment object IDs of the deleted messages (usually their message IDs, see model.Attachment),
+// which can be used to clean up attachment files.
 func (c *Cache) DeleteScheduledBySequenceID(topic, sequenceID string) ([]string, error) {
 	c.maybeLock()
 	defer c.maybeUnlock()
@@ -380,28 +417,58 @@ func (c *Cache) AttachmentBytesUsedByUser(userID string) (int64, error) {
 	return c.readAttachmentBytesUsed(rows)
 }
 
-// AttachmentsWithSizes returns a map of message ID to attachment size for all active
-// (non-expired, non-deleted) attachments. This is used to hydrate the attachment store's
-// size tracking on startup and during periodic sync.
-func (c *Cache) AttachmentsWithSizes() (map[string]int64, error) {
-	rows, err := c.db.ReadOnly().Query(c.queries.selectAttachmentsWithSizes, time.Now().Unix())
+// AttachmentObjects returns a map of object ID to attachment object for all active (non-expired,
+// non-deleted) attachments. Identical attachments share a single object, so the map contains one entry
+// per stored file. This is used to hydrate the attachment store's size tracking and deduplication index
+// on startup and during periodic sync.
+func (c *Cache) AttachmentObjects() (map[string]*model.AttachmentObject, error) {
+	rows, err := c.db.ReadOnly().Query(c.queries.selectAttachmentObjects, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	objects := make(map[string]*model.AttachmentObject)
+	for rows.Next() {
+		object := &model.AttachmentObject{}
+		if err := rows.Scan(&object.ID, &object.Size, &object.SHA256); err != nil {
+			return nil, err
+		}
+		objects[object.ID] = object
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// ReferencedAttachmentObjects returns the subset of the given object IDs that are still referenced by
+// active (non-expired, non-deleted) attachments. Unlike AttachmentObjects, this only looks at the given
+// IDs, so it is cheap enough to be called every time attachments are removed.
+func (c *Cache) ReferencedAttachmentObjects(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return []string{}, nil
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.ReadOnly().Query(c.queries.selectReferencedAttachmentObjects, string(idsJSON), time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	attachments := make(map[string]int64)
+	referenced := make([]string, 0)
 	for rows.Next() {
 		var id string
-		var size int64
-		if err := rows.Scan(&id, &size); err != nil {
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		attachments[id] = size
+		referenced = append(referenced, id)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return attachments, nil
+	return referenced, nil
 }
 
 func (c *Cache) readAttachmentBytesUsed(rows *sql.Rows) (int64, error) {
@@ -442,6 +509,80 @@ func (c *Cache) Stats() (messages int64, err error) {
 	return messages, nil
 }
 
+// AddCallAck marks the message with the given topic and sequence ID as acknowledged by the given phone number.
+// It returns false if the phone number had already acknowledged the message.
+func (c *Cache) AddCallAck(topic, sequenceID, phoneNumber string) (bool, error) {
+	c.maybeLock()
+	defer c.maybeUnlock()
+	result, err := c.db.Exec(c.queries.insertCallAck, topic, sequenceID, phoneNumber, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return added > 0, nil
+}
+
+// CallAcknowledged returns true if the given phone number acknowledged the message with the given topic and sequence ID
+func (c *Cache) CallAcknowledged(topic, sequenceID, phoneNumber string) (bool, error) {
+	var count int
+	if err := c.db.QueryRow(c.queries.selectCallAck, topic, sequenceID, phoneNumber).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteCallAcks deletes all call acknowledgements older than the given time, and returns the number of deleted rows
+func (c *Cache) DeleteCallAcks(olderThan time.Time) (int64, error) {
+	c.maybeLock()
+	defer c.maybeUnlock()
+	result, err := c.db.Exec(c.queries.deleteCallAcksBefore, olderThan.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AddSMSStatus records a delivery status for an SMS. Every distinct status of an SMS is kept, since the provider
+// may report them out of order; repeated reports of the same status are ignored.
+func (c *Cache) AddSMSStatus(status *SMSStatus) error {
+	c.maybeLock()
+	defer c.maybeUnlock()
+	_, err := c.db.Exec(c.queries.insertSMSStatus, status.MessageID, status.SID, status.PhoneNumber, status.Status, status.ErrorCode, status.Time)
+	return err
+}
+
+// SMSStatuses returns all delivery statuses recorded for SMS sent for the message with the given ID, oldest first
+func (c *Cache) SMSStatuses(messageID string) ([]*SMSStatus, error) {
+	rows, err := c.db.Query(c.queries.selectSMSStatuses, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := make([]*SMSStatus, 0)
+	for rows.Next() {
+		var status SMSStatus
+		if err := rows.Scan(&status.MessageID, &status.SID, &status.PhoneNumber, &status.Status, &status.ErrorCode, &status.Time); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, &status)
+	}
+	return statuses, rows.Err()
+}
+
+// DeleteSMSStatuses deletes all SMS delivery statuses older than the given time, and returns the number of deleted rows
+func (c *Cache) DeleteSMSStatuses(olderThan time.Time) (int64, error) {
+	c.maybeLock()
+	defer c.maybeUnlock()
+	result, err := c.db.Exec(c.queries.deleteSMSStatusesBefore, olderThan.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // Close closes the underlying database connection
 func (c *Cache) Close() error {
 	return c.db.Close()
@@ -458,11 +599,11 @@ func (c *Cache) processMessageBatches() {
 	}
 }
 
-func readMessages(rows *sql.Rows) ([]*model.Message, error) {
+func (c *Cache) readMessages(rows *sql.Rows) ([]*model.Message, error) {
 	defer rows.Close()
 	messages := make([]*model.Message, 0)
 	for rows.Next() {
-		m, err := readMessage(rows)
+		m, err := c.readMessage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -474,10 +615,10 @@ func readMessages(rows *sql.Rows) ([]*model.Message, error) {
 	return messages, nil
 }
 
-func readMessage(rows *sql.Rows) (*model.Message, error) {
+func (c *Cache) readMessage(rows *sql.Rows) (*model.Message, error) {
 	var timestamp, expires, attachmentSize, attachmentExpires int64
 	var priority int
-	var id, sequenceID, event, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding string
+	var id, sequenceID, event, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, attachmentThumbnail, attachmentObject, sender, user, contentType, encoding string
 	err := rows.Scan(
 		&id,
 		&sequenceID,
@@ -497,6 +638,8 @@ func readMessage(rows *sql.Rows) (*model.Message, error) {
 		&attachmentSize,
 		&attachmentExpires,
 		&attachmentURL,
+		&attachmentThumbnail,
+		&attachmentObject,
 		&sender,
 		&user,
 		&contentType,
@@ -505,6 +648,7 @@ func readMessage(rows *sql.Rows) (*model.Message, error) {
 	if err != nil {
 		return nil, err
 	}
+	msg, title = c.decryptText(id, msg, title)
 	var tags []string
 	if tagsStr != "" {
 		tags = strings.Split(tagsStr, ",")
@@ -522,11 +666,13 @@ func readMessage(rows *sql.Rows) (*model.Message, error) {
 	var att *model.Attachment
 	if attachmentName != "" && attachmentURL != "" {
 		att = &model.Attachment{
-			Name:    attachmentName,
-			Type:    attachmentType,
-			Size:    attachmentSize,
-			Expires: attachmentExpires,
-			URL:     attachmentURL,
+			Name:      attachmentName,
+			Type:      attachmentType,
+			Size:      attachmentSize,
+			Expires:   attachmentExpires,
+			URL:       attachmentURL,
+			Thumbnail: attachmentThumbnail,
+			Object:    attachmentObject,
 		}
 	}
 	return &model.Message{
@@ -551,6 +697,104 @@ func readMessage(rows *sql.Rows) (*model.Message, error) {
 	}, nil
 }
 
+// ReencryptMessages encrypts the message bodies and titles of all messages that are not encrypted with the
+// primary key of the keyring, i.e. messages that were stored before encryption was enabled, or before the last
+// key rotation. It returns the number of re-encrypted messages.
+func (c *Cache) ReencryptMessages() (int, error) {
+	if c.keyring == nil {
+		return 0, errEncryptionNotEnabled
+	}
+	c.maybeLock()
+	defer c.maybeUnlock()
+	rows, err := c.db.Query(c.queries.selectMessagesText)
+	if err != nil {
+		return 0, err
+	}
+	type messageText struct {
+		id, message, title string
+	}
+	var texts []*messageText
+	for rows.Next() {
+		var id, msg, title string
+		if err := rows.Scan(&id, &msg, &title); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if c.keyring.NeedsReencryption(msg) || c.keyring.NeedsReencryption(title) {
+			texts = append(texts, &messageText{id: id, message: msg, title: title})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+	if len(texts) == 0 {
+		return 0, nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, t := range texts {
+		msg, err := c.keyring.DecryptString(t.message, t.id+":message")
+		if err != nil {
+			return 0, fmt.Errorf("cannot decrypt message %s: %w", t.id, err)
+		}
+		title, err := c.keyring.DecryptString(t.title, t.id+":title")
+		if err != nil {
+			return 0, fmt.Errorf("cannot decrypt title of message %s: %w", t.id, err)
+		}
+		if msg, title, err = c.encryptText(t.id, msg, title); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(c.queries.updateMessageText, msg, title, t.id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(texts), nil
+}
+
+// encryptText encrypts the message body and title, if encryption at rest is enabled. The message ID and
+// column name are used as additional data, so that encrypted values cannot be moved to other rows or columns.
+func (c *Cache) encryptText(id, msg, title string) (string, string, error) {
+	if c.keyring == nil {
+		return msg, title, nil
+	}
+	msg, err := c.keyring.EncryptString(msg, id+":message")
+	if err != nil {
+		return "", "", err
+	}
+	title, err = c.keyring.EncryptString(title, id+":title")
+	if err != nil {
+		return "", "", err
+	}
+	return msg, title, nil
+}
+
+// decryptText decrypts the message body and title. Values that cannot be decrypted (e.g. because the key
+// was removed from the keyring) are returned as is, so that a single broken message does not break entire topics.
+func (c *Cache) decryptText(id, msg, title string) (string, string) {
+	if c.keyring == nil {
+		return msg, title
+	}
+	if decrypted, err := c.keyring.DecryptString(msg, id+":message"); err != nil {
+		log.Tag(tagMessageCache).Field("message_id", id).Err(err).Warn("Cannot decrypt message")
+	} else {
+		msg = decrypted
+	}
+	if decrypted, err := c.keyring.DecryptString(title, id+":title"); err != nil {
+		log.Tag(tagMessageCache).Field("message_id", id).Err(err).Warn("Cannot decrypt message title")
+	} else {
+		title = decrypted
+	}
+	return msg, title
+}
+
 func readStrings(rows *sql.Rows) ([]string, error) {
 	strs := make([]string, 0)
 	for rows.Next() {
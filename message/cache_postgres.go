@@ -5,36 +5,37 @@ import (
 
 	"heckel.io/ntfy/v2/db"
 	"heckel.io/ntfy/v2/db/schema"
+	"heckel.io/ntfy/v2/encryption"
 )
 
 // PostgreSQL runtime query constants
 const (
 	postgresInsertMessageQuery = `
-		INSERT INTO message (mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user_id, content_type, encoding, published)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+		INSERT INTO message (mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, attachment_sha256, attachment_deleted, sender, user_id, content_type, encoding, published)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)
 	`
-	postgresSelectScheduledMessageIDsBySeqIDQuery = `SELECT mid FROM message WHERE topic = $1 AND sequence_id = $2 AND published = FALSE`
+	postgresSelectScheduledMessageIDsBySeqIDQuery = `SELECT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END FROM message WHERE topic = $1 AND sequence_id = $2 AND published = FALSE`
 	postgresDeleteScheduledBySequenceIDQuery      = `DELETE FROM message WHERE topic = $1 AND sequence_id = $2 AND published = FALSE`
 	postgresUpdateMessagesForTopicExpiryQuery     = `UPDATE message SET expires = $1 WHERE topic = $2`
 	postgresSelectMessagesByIDQuery               = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE mid = $1
 	`
 	postgresSelectMessagesSinceTimeQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE topic = $1 AND time >= $2 AND published = TRUE
 		ORDER BY time, id
 	`
 	postgresSelectMessagesSinceTimeIncludeScheduledQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE topic = $1 AND time >= $2
 		ORDER BY time, id
 	`
 	postgresSelectMessagesSinceIDQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE topic = $1
 		  AND id > COALESCE((SELECT id FROM message WHERE mid = $2), 0)
@@ -42,21 +43,21 @@ const (
 		ORDER BY time, id
 	`
 	postgresSelectMessagesSinceIDIncludeScheduledQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE topic = $1
 		  AND (id > COALESCE((SELECT id FROM message WHERE mid = $2), 0) OR published = FALSE)
 		ORDER BY time, id
 	`
 	postgresSelectMessagesLatestQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE topic = $1 AND published = TRUE
 		ORDER BY time DESC, id DESC
 		LIMIT 1
 	`
 	postgresSelectMessagesDueQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user_id, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user_id, content_type, encoding
 		FROM message
 		WHERE time <= $1 AND published = FALSE
 		ORDER BY time, id
@@ -67,44 +68,72 @@ const (
 
 	postgresDeleteExpiredMessagesQuery         = `DELETE FROM message WHERE mid IN (SELECT mid FROM message WHERE expires <= $1 AND published = TRUE LIMIT $2)`
 	postgresMarkExpiredAttachmentsDeletedQuery = `UPDATE message SET attachment_deleted = TRUE WHERE mid IN (SELECT mid FROM message WHERE attachment_expires > 0 AND attachment_expires <= $1 AND attachment_deleted = FALSE LIMIT $2)`
-	postgresSelectAttachmentsSizeBySenderQuery = `SELECT COALESCE(SUM(attachment_size), 0) FROM message WHERE user_id = '' AND sender = $1 AND attachment_expires >= $2`
-	postgresSelectAttachmentsSizeByUserIDQuery = `SELECT COALESCE(SUM(attachment_size), 0) FROM message WHERE user_id = $1 AND attachment_expires >= $2`
-	postgresSelectAttachmentsWithSizesQuery    = `SELECT mid, attachment_size FROM message WHERE attachment_expires > $1 AND attachment_deleted = FALSE`
+	postgresSelectAttachmentsSizeBySenderQuery = `SELECT COALESCE(SUM(attachment_size), 0) FROM (SELECT DISTINCT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END, attachment_size FROM message WHERE user_id = '' AND sender = $1 AND attachment_expires >= $2) AS a`
+	postgresSelectAttachmentsSizeByUserIDQuery = `SELECT COALESCE(SUM(attachment_size), 0) FROM (SELECT DISTINCT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END, attachment_size FROM message WHERE user_id = $1 AND attachment_expires >= $2) AS a`
+	postgresSelectAttachmentObjectsQuery       = `SELECT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END, MAX(attachment_size), MAX(attachment_sha256) FROM message WHERE attachment_expires > $1 AND attachment_deleted = FALSE GROUP BY 1`
+	postgresSelectReferencedAttachmentObjects  = `
+		WITH ids AS (SELECT jsonb_array_elements_text($1::jsonb) AS id)
+		SELECT DISTINCT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END
+		FROM message
+		WHERE (attachment_object IN (SELECT id FROM ids) OR (attachment_object = '' AND mid IN (SELECT id FROM ids)))
+			AND attachment_expires > $2 AND attachment_deleted = FALSE
+	`
 
 	postgresSelectStatsQuery       = `SELECT value FROM message_stats WHERE key = 'messages'`
 	postgresUpdateStatsQuery       = `UPDATE message_stats SET value = $1 WHERE key = 'messages'`
 	postgresUpdateMessageTimeQuery = `UPDATE message SET time = $1 WHERE mid = $2`
+
+	postgresSelectMessagesTextQuery = `SELECT mid, message, title FROM message`
+	postgresUpdateMessageTextQuery  = `UPDATE message SET message = $1, title = $2 WHERE mid = $3`
+
+	postgresInsertCallAckQuery        = `INSERT INTO message_call_ack (topic, sequence_id, phone_number, time) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+	postgresSelectCallAckQuery        = `SELECT COUNT(*) FROM message_call_ack WHERE topic = $1 AND sequence_id = $2 AND phone_number = $3`
+	postgresDeleteCallAcksBeforeQuery = `DELETE FROM message_call_ack WHERE time < $1`
+
+	postgresInsertSMSStatusQuery         = `INSERT INTO message_sms_status (mid, sid, phone_number, status, error_code, time) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`
+	postgresSelectSMSStatusesQuery       = `SELECT mid, sid, phone_number, status, error_code, time FROM message_sms_status WHERE mid = $1 ORDER BY time, status`
+	postgresDeleteSMSStatusesBeforeQuery = `DELETE FROM message_sms_status WHERE time < $1`
 )
 
 var postgresQueries = queries{
-	insertMessage:                    postgresInsertMessageQuery,
-	selectScheduledMessageIDsBySeqID: postgresSelectScheduledMessageIDsBySeqIDQuery,
-	deleteScheduledBySequenceID:      postgresDeleteScheduledBySequenceIDQuery,
-	updateMessagesForTopicExpiry:     postgresUpdateMessagesForTopicExpiryQuery,
-	selectMessagesByID:               postgresSelectMessagesByIDQuery,
-	selectMessagesSinceTime:          postgresSelectMessagesSinceTimeQuery,
-	selectMessagesSinceTimeScheduled: postgresSelectMessagesSinceTimeIncludeScheduledQuery,
-	selectMessagesSinceID:            postgresSelectMessagesSinceIDQuery,
-	selectMessagesSinceIDScheduled:   postgresSelectMessagesSinceIDIncludeScheduledQuery,
-	selectMessagesLatest:             postgresSelectMessagesLatestQuery,
-	selectMessagesDue:                postgresSelectMessagesDueQuery,
-	deleteExpiredMessages:            postgresDeleteExpiredMessagesQuery,
-	updateMessagePublished:           postgresUpdateMessagePublishedQuery,
-	selectMessagesCount:              postgresSelectMessagesCountQuery,
-	selectTopics:                     postgresSelectTopicsQuery,
-	markExpiredAttachmentsDeleted:    postgresMarkExpiredAttachmentsDeletedQuery,
-	selectAttachmentsSizeBySender:    postgresSelectAttachmentsSizeBySenderQuery,
-	selectAttachmentsSizeByUserID:    postgresSelectAttachmentsSizeByUserIDQuery,
-	selectAttachmentsWithSizes:       postgresSelectAttachmentsWithSizesQuery,
-	selectStats:                      postgresSelectStatsQuery,
-	updateStats:                      postgresUpdateStatsQuery,
-	updateMessageTime:                postgresUpdateMessageTimeQuery,
+	insertMessage:                     postgresInsertMessageQuery,
+	selectScheduledMessageIDsBySeqID:  postgresSelectScheduledMessageIDsBySeqIDQuery,
+	deleteScheduledBySequenceID:       postgresDeleteScheduledBySequenceIDQuery,
+	updateMessagesForTopicExpiry:      postgresUpdateMessagesForTopicExpiryQuery,
+	selectMessagesByID:                postgresSelectMessagesByIDQuery,
+	selectMessagesSinceTime:           postgresSelectMessagesSinceTimeQuery,
+	selectMessagesSinceTimeScheduled:  postgresSelectMessagesSinceTimeIncludeScheduledQuery,
+	selectMessagesSinceID:             postgresSelectMessagesSinceIDQuery,
+	selectMessagesSinceIDScheduled:    postgresSelectMessagesSinceIDIncludeScheduledQuery,
+	selectMessagesLatest:              postgresSelectMessagesLatestQuery,
+	selectMessagesDue:                 postgresSelectMessagesDueQuery,
+	deleteExpiredMessages:             postgresDeleteExpiredMessagesQuery,
+	updateMessagePublished:            postgresUpdateMessagePublishedQuery,
+	selectMessagesCount:               postgresSelectMessagesCountQuery,
+	selectTopics:                      postgresSelectTopicsQuery,
+	markExpiredAttachmentsDeleted:     postgresMarkExpiredAttachmentsDeletedQuery,
+	selectAttachmentsSizeBySender:     postgresSelectAttachmentsSizeBySenderQuery,
+	selectAttachmentsSizeByUserID:     postgresSelectAttachmentsSizeByUserIDQuery,
+	selectAttachmentObjects:           postgresSelectAttachmentObjectsQuery,
+	selectReferencedAttachmentObjects: postgresSelectReferencedAttachmentObjects,
+	selectStats:                       postgresSelectStatsQuery,
+	updateStats:                       postgresUpdateStatsQuery,
+	updateMessageTime:                 postgresUpdateMessageTimeQuery,
+	selectMessagesText:                postgresSelectMessagesTextQuery,
+	updateMessageText:                 postgresUpdateMessageTextQuery,
+	insertCallAck:                     postgresInsertCallAckQuery,
+	selectCallAck:                     postgresSelectCallAckQuery,
+	deleteCallAcksBefore:              postgresDeleteCallAcksBeforeQuery,
+	insertSMSStatus:                   postgresInsertSMSStatusQuery,
+	selectSMSStatuses:                 postgresSelectSMSStatusesQuery,
+	deleteSMSStatusesBefore:           postgresDeleteSMSStatusesBeforeQuery,
 }
 
 // NewPostgresStore creates a new PostgreSQL-backed message cache store using an existing database connection pool.
-func NewPostgresStore(d *db.DB, batchSize int, batchTimeout time.Duration) (*Cache, error) {
+// If keyring is set, message bodies and titles are encrypted at rest.
+func NewPostgresStore(d *db.DB, batchSize int, batchTimeout time.Duration, keyring *encryption.Keyring) (*Cache, error) {
 	if err := schema.Migrate(d.Primary(), schema.Postgres, schemaStore, postgresCurrentSchemaVersion, postgresCreateTables, postgresMigrations); err != nil {
 		return nil, err
 	}
-	return newCache(d, postgresQueries, nil, batchSize, batchTimeout, false), nil
+	return newCache(d, postgresQueries, nil, batchSize, batchTimeout, keyring, false), nil
 }
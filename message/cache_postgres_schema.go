@@ -6,7 +6,7 @@ import (
 
 // Initial PostgreSQL schema
 const (
-	postgresCurrentSchemaVersion = 15
+	postgresCurrentSchemaVersion = 20
 	postgresCreateTablesQuery    = `
 		CREATE TABLE IF NOT EXISTS message (
 			id BIGSERIAL PRIMARY KEY,
@@ -28,6 +28,9 @@ const (
 			attachment_size BIGINT NOT NULL,
 			attachment_expires BIGINT NOT NULL,
 			attachment_url TEXT NOT NULL,
+			attachment_thumbnail TEXT NOT NULL,
+			attachment_object TEXT NOT NULL,
+			attachment_sha256 TEXT NOT NULL,
 			attachment_deleted BOOLEAN NOT NULL DEFAULT FALSE,
 			sender TEXT NOT NULL,
 			user_id TEXT NOT NULL,
@@ -42,11 +45,31 @@ const (
 		CREATE INDEX IF NOT EXISTS idx_message_attachment_expires ON message (attachment_expires) WHERE attachment_deleted = FALSE;
 		CREATE INDEX IF NOT EXISTS idx_message_sender_attachment_expires ON message (sender, attachment_expires) WHERE user_id = '';
 		CREATE INDEX IF NOT EXISTS idx_message_user_id_attachment_expires ON message (user_id, attachment_expires);
+		CREATE INDEX IF NOT EXISTS idx_message_attachment_object ON message (attachment_object);
 		CREATE TABLE IF NOT EXISTS message_stats (
 			key TEXT PRIMARY KEY,
 			value BIGINT
 		);
 		INSERT INTO message_stats (key, value) VALUES ('messages', 0);
+		CREATE TABLE IF NOT EXISTS message_call_ack (
+			topic TEXT NOT NULL,
+			sequence_id TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			time BIGINT NOT NULL,
+			PRIMARY KEY (topic, sequence_id, phone_number)
+		);
+		CREATE INDEX IF NOT EXISTS idx_message_call_ack_time ON message_call_ack (time);
+		CREATE TABLE IF NOT EXISTS message_sms_status (
+			mid TEXT NOT NULL,
+			sid TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_code TEXT NOT NULL,
+			time BIGINT NOT NULL,
+			PRIMARY KEY (sid, status)
+		);
+		CREATE INDEX IF NOT EXISTS idx_message_sms_status_mid ON message_sms_status (mid);
+		CREATE INDEX IF NOT EXISTS idx_message_sms_status_time ON message_sms_status (time);
 	`
 )
 
@@ -56,6 +79,49 @@ const (
 	postgresMigrate14To15CreateIndexQuery = `
 		CREATE INDEX IF NOT EXISTS idx_message_attachment_expires ON message (attachment_expires) WHERE attachment_deleted = FALSE;
 	`
+
+	// 15 -> 16
+	postgresMigrate15To16AlterMessageTableQuery = `
+		ALTER TABLE message ADD COLUMN IF NOT EXISTS attachment_thumbnail TEXT NOT NULL DEFAULT '';
+	`
+
+	// 16 -> 17
+	postgresMigrate16To17AlterMessageTableQuery = `
+		ALTER TABLE message ADD COLUMN IF NOT EXISTS attachment_object TEXT NOT NULL DEFAULT '';
+		ALTER TABLE message ADD COLUMN IF NOT EXISTS attachment_sha256 TEXT NOT NULL DEFAULT '';
+	`
+
+	// 17 -> 18
+	postgresMigrate17To18CreateCallAckTableQuery = `
+		CREATE TABLE IF NOT EXISTS message_call_ack (
+			topic TEXT NOT NULL,
+			sequence_id TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			time BIGINT NOT NULL,
+			PRIMARY KEY (topic, sequence_id, phone_number)
+		);
+		CREATE INDEX IF NOT EXISTS idx_message_call_ack_time ON message_call_ack (time);
+	`
+
+	// 18 -> 19
+	postgresMigrate18To19CreateIndexQuery = `
+		CREATE INDEX IF NOT EXISTS idx_message_attachment_object ON message (attachment_object);
+	`
+
+	// 19 -> 20
+	postgresMigrate19To20CreateSMSStatusTableQuery = `
+		CREATE TABLE IF NOT EXISTS message_sms_status (
+			mid TEXT NOT NULL,
+			sid TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_code TEXT NOT NULL,
+			time BIGINT NOT NULL,
+			PRIMARY KEY (sid, status)
+		);
+		CREATE INDEX IF NOT EXISTS idx_message_sms_status_mid ON message_sms_status (mid);
+		CREATE INDEX IF NOT EXISTS idx_message_sms_status_time ON message_sms_status (time);
+	`
 )
 
 var (
@@ -65,5 +131,10 @@ var (
 	// version. Always append migrations at the end, never insert in the middle.
 	postgresMigrations = map[int]schema.MigrateFunc{
 		14: schema.AsMigrateFunc(postgresMigrate14To15CreateIndexQuery),
+		15: schema.AsMigrateFunc(postgresMigrate15To16AlterMessageTableQuery),
+		16: schema.AsMigrateFunc(postgresMigrate16To17AlterMessageTableQuery),
+		17: schema.AsMigrateFunc(postgresMigrate17To18CreateCallAckTableQuery),
+		18: schema.AsMigrateFunc(postgresMigrate18To19CreateIndexQuery),
+		19: schema.AsMigrateFunc(postgresMigrate19To20CreateSMSStatusTableQuery),
 	}
 )
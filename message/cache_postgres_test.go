@@ -53,12 +53,12 @@ func TestPostgresStore_Migration_From14(t *testing.T) {
 		INSERT INTO schema_version (store, version) VALUES ('message', 14);
 	`)
 	require.Nil(t, err)
-	store, err := message.NewPostgresStore(testDB, 0, 0)
+	store, err := message.NewPostgresStore(testDB, 0, 0, nil)
 	require.Nil(t, err)
-	// The 14 -> 15 step ran: version bumped, partial index created
+	// The 14 -> 15 and 15 -> 16 steps ran: version bumped, partial index created
 	var version int
 	require.Nil(t, testDB.QueryRow(`SELECT version FROM schema_version WHERE store = 'message'`).Scan(&version))
-	require.Equal(t, 15, version)
+	require.Equal(t, 20, version)
 	var indexCount int
 	require.Nil(t, testDB.QueryRow(`SELECT COUNT(*) FROM pg_indexes WHERE indexname = 'idx_message_attachment_expires' AND schemaname = current_schema()`).Scan(&indexCount))
 	require.Equal(t, 1, indexCount)
@@ -70,7 +70,7 @@ func TestPostgresStore_Migration_From14(t *testing.T) {
 
 	// The migrated database must be structurally identical to a freshly created one
 	freshDB := dbtest.CreateTestPostgres(t)
-	_, err = message.NewPostgresStore(freshDB, 0, 0)
+	_, err = message.NewPostgresStore(freshDB, 0, 0, nil)
 	require.Nil(t, err)
 	require.Equal(t, dbtest.PostgresSchema(t, freshDB), dbtest.PostgresSchema(t, testDB))
 }
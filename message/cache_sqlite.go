@@ -10,56 +10,57 @@ import (
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 	"heckel.io/ntfy/v2/db"
 	"heckel.io/ntfy/v2/db/schema"
+	"heckel.io/ntfy/v2/encryption"
 	"heckel.io/ntfy/v2/util"
 )
 
 // SQLite runtime query constants
 const (
 	sqliteInsertMessageQuery = `
-		INSERT INTO messages (mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user, content_type, encoding, published)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO messages (mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, attachment_sha256, attachment_deleted, sender, user, content_type, encoding, published)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	sqliteSelectScheduledMessageIDsBySeqIDQuery = `SELECT mid FROM messages WHERE topic = ? AND sequence_id = ? AND published = 0`
+	sqliteSelectScheduledMessageIDsBySeqIDQuery = `SELECT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END FROM messages WHERE topic = ? AND sequence_id = ? AND published = 0`
 	sqliteDeleteScheduledBySequenceIDQuery      = `DELETE FROM messages WHERE topic = ? AND sequence_id = ? AND published = 0`
 	sqliteUpdateMessagesForTopicExpiryQuery     = `UPDATE messages SET expires = ? WHERE topic = ?`
 	sqliteSelectMessagesByIDQuery               = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE mid = ?
 	`
 	sqliteSelectMessagesSinceTimeQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE topic = ? AND time >= ? AND published = 1
 		ORDER BY time, id
 	`
 	sqliteSelectMessagesSinceTimeIncludeScheduledQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE topic = ? AND time >= ?
 		ORDER BY time, id
 	`
 	sqliteSelectMessagesSinceIDQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE topic = ? AND id > COALESCE((SELECT id FROM messages WHERE mid = ?), 0) AND published = 1
 		ORDER BY time, id
 	`
 	sqliteSelectMessagesSinceIDIncludeScheduledQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE topic = ? AND (id > COALESCE((SELECT id FROM messages WHERE mid = ?), 0) OR published = 0)
 		ORDER BY time, id
 	`
 	sqliteSelectMessagesLatestQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE topic = ? AND published = 1
 		ORDER BY time DESC, id DESC
 		LIMIT 1
 	`
 	sqliteSelectMessagesDueQuery = `
-		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, sequence_id, time, event, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_object, sender, user, content_type, encoding
 		FROM messages
 		WHERE time <= ? AND published = 0
 		ORDER BY time, id
@@ -70,42 +71,69 @@ const (
 
 	sqliteDeleteExpiredMessagesQuery         = `DELETE FROM messages WHERE mid IN (SELECT mid FROM messages WHERE expires <= ? AND published = 1 LIMIT ?)`
 	sqliteMarkExpiredAttachmentsDeletedQuery = `UPDATE messages SET attachment_deleted = 1 WHERE mid IN (SELECT mid FROM messages WHERE attachment_expires > 0 AND attachment_expires <= ? AND attachment_deleted = 0 LIMIT ?)`
-	sqliteSelectAttachmentsSizeBySenderQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?`
-	sqliteSelectAttachmentsSizeByUserIDQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = ? AND attachment_expires >= ?`
-	sqliteSelectAttachmentsWithSizesQuery    = `SELECT mid, attachment_size FROM messages WHERE attachment_expires > ? AND attachment_deleted = 0`
+	sqliteSelectAttachmentsSizeBySenderQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM (SELECT DISTINCT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END, attachment_size FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?) AS a`
+	sqliteSelectAttachmentsSizeByUserIDQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM (SELECT DISTINCT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END, attachment_size FROM messages WHERE user = ? AND attachment_expires >= ?) AS a`
+	sqliteSelectAttachmentObjectsQuery       = `SELECT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END, MAX(attachment_size), MAX(attachment_sha256) FROM messages WHERE attachment_expires > ? AND attachment_deleted = 0 GROUP BY 1`
+	sqliteSelectReferencedAttachmentObjects  = `
+		WITH ids (id) AS (SELECT value FROM json_each(?))
+		SELECT DISTINCT CASE WHEN attachment_object = '' THEN mid ELSE attachment_object END
+		FROM messages
+		WHERE (attachment_object IN (SELECT id FROM ids) OR (attachment_object = '' AND mid IN (SELECT id FROM ids)))
+			AND attachment_expires > ? AND attachment_deleted = 0
+	`
 
 	sqliteSelectStatsQuery       = `SELECT value FROM stats WHERE key = 'messages'`
 	sqliteUpdateStatsQuery       = `UPDATE stats SET value = ? WHERE key = 'messages'`
 	sqliteUpdateMessageTimeQuery = `UPDATE messages SET time = ? WHERE mid = ?`
+
+	sqliteSelectMessagesTextQuery = `SELECT mid, message, title FROM messages`
+	sqliteUpdateMessageTextQuery  = `UPDATE messages SET message = ?, title = ? WHERE mid = ?`
+
+	sqliteInsertCallAckQuery        = `INSERT INTO call_acks (topic, sequence_id, phone_number, time) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`
+	sqliteSelectCallAckQuery        = `SELECT COUNT(*) FROM call_acks WHERE topic = ? AND sequence_id = ? AND phone_number = ?`
+	sqliteDeleteCallAcksBeforeQuery = `DELETE FROM call_acks WHERE time < ?`
+
+	sqliteInsertSMSStatusQuery         = `INSERT INTO sms_statuses (mid, sid, phone_number, status, error_code, time) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`
+	sqliteSelectSMSStatusesQuery       = `SELECT mid, sid, phone_number, status, error_code, time FROM sms_statuses WHERE mid = ? ORDER BY time, status`
+	sqliteDeleteSMSStatusesBeforeQuery = `DELETE FROM sms_statuses WHERE time < ?`
 )
 
 var sqliteQueries = queries{
-	insertMessage:                    sqliteInsertMessageQuery,
-	selectScheduledMessageIDsBySeqID: sqliteSelectScheduledMessageIDsBySeqIDQuery,
-	deleteScheduledBySequenceID:      sqliteDeleteScheduledBySequenceIDQuery,
-	updateMessagesForTopicExpiry:     sqliteUpdateMessagesForTopicExpiryQuery,
-	selectMessagesByID:               sqliteSelectMessagesByIDQuery,
-	selectMessagesSinceTime:          sqliteSelectMessagesSinceTimeQuery,
-	selectMessagesSinceTimeScheduled: sqliteSelectMessagesSinceTimeIncludeScheduledQuery,
-	selectMessagesSinceID:            sqliteSelectMessagesSinceIDQuery,
-	selectMessagesSinceIDScheduled:   sqliteSelectMessagesSinceIDIncludeScheduledQuery,
-	selectMessagesLatest:             sqliteSelectMessagesLatestQuery,
-	selectMessagesDue:                sqliteSelectMessagesDueQuery,
-	deleteExpiredMessages:            sqliteDeleteExpiredMessagesQuery,
-	updateMessagePublished:           sqliteUpdateMessagePublishedQuery,
-	selectMessagesCount:              sqliteSelectMessagesCountQuery,
-	selectTopics:                     sqliteSelectTopicsQuery,
-	markExpiredAttachmentsDeleted:    sqliteMarkExpiredAttachmentsDeletedQuery,
-	selectAttachmentsSizeBySender:    sqliteSelectAttachmentsSizeBySenderQuery,
-	selectAttachmentsSizeByUserID:    sqliteSelectAttachmentsSizeByUserIDQuery,
-	selectAttachmentsWithSizes:       sqliteSelectAttachmentsWithSizesQuery,
-	selectStats:                      sqliteSelectStatsQuery,
-	updateStats:                      sqliteUpdateStatsQuery,
-	updateMessageTime:                sqliteUpdateMessageTimeQuery,
+	insertMessage:                     sqliteInsertMessageQuery,
+	selectScheduledMessageIDsBySeqID:  sqliteSelectScheduledMessageIDsBySeqIDQuery,
+	deleteScheduledBySequenceID:       sqliteDeleteScheduledBySequenceIDQuery,
+	updateMessagesForTopicExpiry:      sqliteUpdateMessagesForTopicExpiryQuery,
+	selectMessagesByID:                sqliteSelectMessagesByIDQuery,
+	selectMessagesSinceTime:           sqliteSelectMessagesSinceTimeQuery,
+	selectMessagesSinceTimeScheduled:  sqliteSelectMessagesSinceTimeIncludeScheduledQuery,
+	selectMessagesSinceID:             sqliteSelectMessagesSinceIDQuery,
+	selectMessagesSinceIDScheduled:    sqliteSelectMessagesSinceIDIncludeScheduledQuery,
+	selectMessagesLatest:              sqliteSelectMessagesLatestQuery,
+	selectMessagesDue:                 sqliteSelectMessagesDueQuery,
+	deleteExpiredMessages:             sqliteDeleteExpiredMessagesQuery,
+	updateMessagePublished:            sqliteUpdateMessagePublishedQuery,
+	selectMessagesCount:               sqliteSelectMessagesCountQuery,
+	selectTopics:                      sqliteSelectTopicsQuery,
+	markExpiredAttachmentsDeleted:     sqliteMarkExpiredAttachmentsDeletedQuery,
+	selectAttachmentsSizeBySender:     sqliteSelectAttachmentsSizeBySenderQuery,
+	selectAttachmentsSizeByUserID:     sqliteSelectAttachmentsSizeByUserIDQuery,
+	selectAttachmentObjects:           sqliteSelectAttachmentObjectsQuery,
+	selectReferencedAttachmentObjects: sqliteSelectReferencedAttachmentObjects,
+	selectStats:                       sqliteSelectStatsQuery,
+	updateStats:                       sqliteUpdateStatsQuery,
+	updateMessageTime:                 sqliteUpdateMessageTimeQuery,
+	selectMessagesText:                sqliteSelectMessagesTextQuery,
+	updateMessageText:                 sqliteUpdateMessageTextQuery,
+	insertCallAck:                     sqliteInsertCallAckQuery,
+	selectCallAck:                     sqliteSelectCallAckQuery,
+	deleteCallAcksBefore:              sqliteDeleteCallAcksBeforeQuery,
+	insertSMSStatus:                   sqliteInsertSMSStatusQuery,
+	selectSMSStatuses:                 sqliteSelectSMSStatusesQuery,
+	deleteSMSStatusesBefore:           sqliteDeleteSMSStatusesBeforeQuery,
 }
 
-// NewSQLiteStore creates a SQLite file-backed cache
-func NewSQLiteStore(filename, startupQueries string, cacheDuration time.Duration, batchSize int, batchTimeout time.Duration, nop bool) (*Cache, error) {
+// NewSQLiteStore creates a SQLite file-backed cache. If keyring is set, message bodies and titles are encrypted at rest.
+func NewSQLiteStore(filename, startupQueries string, cacheDuration time.Duration, batchSize int, batchTimeout time.Duration, keyring *encryption.Keyring, nop bool) (*Cache, error) {
 	parentDir := filepath.Dir(filename)
 	if !util.FileExists(parentDir) {
 		return nil, fmt.Errorf("cache database directory %s does not exist or is not accessible", parentDir)
@@ -120,18 +148,18 @@ func NewSQLiteStore(filename, startupQueries string, cacheDuration time.Duration
 	if err := schema.Migrate(d, schema.SQLite, schemaStore, sqliteCurrentSchemaVersion, sqliteCreateTables, sqliteMigrations(cacheDuration)); err != nil {
 		return nil, err
 	}
-	return newCache(db.New(&db.Host{DB: d}, nil), sqliteQueries, &sync.Mutex{}, batchSize, batchTimeout, nop), nil
+	return newCache(db.New(&db.Host{DB: d}, nil), sqliteQueries, &sync.Mutex{}, batchSize, batchTimeout, keyring, nop), nil
 }
 
 // NewMemStore creates an in-memory cache
 func NewMemStore() (*Cache, error) {
-	return NewSQLiteStore(createMemoryFilename(), "", 0, 0, 0, false)
+	return NewSQLiteStore(createMemoryFilename(), "", 0, 0, 0, nil, false)
 }
 
 // NewNopStore creates an in-memory cache that discards all messages;
 // it is always empty and can be used if caching is entirely disabled
 func NewNopStore() (*Cache, error) {
-	return NewSQLiteStore(createMemoryFilename(), "", 0, 0, 0, true)
+	return NewSQLiteStore(createMemoryFilename(), "", 0, 0, 0, nil, true)
 }
 
 // createMemoryFilename creates a unique memory filename to use for the SQLite backend.
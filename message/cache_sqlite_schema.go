@@ -9,7 +9,7 @@ import (
 
 // Initial SQLite schema
 const (
-	sqliteCurrentSchemaVersion = 15
+	sqliteCurrentSchemaVersion = 20
 	sqliteCreateTablesQuery    = `
 		CREATE TABLE IF NOT EXISTS messages (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -31,6 +31,9 @@ const (
 			attachment_size INT NOT NULL,
 			attachment_expires INT NOT NULL,
 			attachment_url TEXT NOT NULL,
+			attachment_thumbnail TEXT NOT NULL,
+			attachment_object TEXT NOT NULL,
+			attachment_sha256 TEXT NOT NULL,
 			attachment_deleted INT NOT NULL,
 			sender TEXT NOT NULL,
 			user TEXT NOT NULL,
@@ -46,11 +49,31 @@ const (
 		CREATE INDEX IF NOT EXISTS idx_sender ON messages (sender);
 		CREATE INDEX IF NOT EXISTS idx_user ON messages (user);
 		CREATE INDEX IF NOT EXISTS idx_attachment_expires ON messages (attachment_expires);
+		CREATE INDEX IF NOT EXISTS idx_attachment_object ON messages (attachment_object);
 		CREATE TABLE IF NOT EXISTS stats (
 			key TEXT PRIMARY KEY,
 			value INT
 		);
 		INSERT INTO stats (key, value) VALUES ('messages', 0);
+		CREATE TABLE IF NOT EXISTS call_acks (
+			topic TEXT NOT NULL,
+			sequence_id TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (topic, sequence_id, phone_number)
+		);
+		CREATE INDEX IF NOT EXISTS idx_call_acks_time ON call_acks (time);
+		CREATE TABLE IF NOT EXISTS sms_statuses (
+			mid TEXT NOT NULL,
+			sid TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_code TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (sid, status)
+		);
+		CREATE INDEX IF NOT EXISTS idx_sms_statuses_mid ON sms_statuses (mid);
+		CREATE INDEX IF NOT EXISTS idx_sms_statuses_time ON sms_statuses (time);
 	`
 )
 
@@ -169,6 +192,49 @@ const (
 		ALTER TABLE messages ADD COLUMN event TEXT NOT NULL DEFAULT('message');
 		CREATE INDEX IF NOT EXISTS idx_sequence_id ON messages (sequence_id);
 	`
+
+	// 15 -> 16
+	sqliteMigrate15To16AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN attachment_thumbnail TEXT NOT NULL DEFAULT('');
+	`
+
+	// 16 -> 17
+	sqliteMigrate16To17AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN attachment_object TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN attachment_sha256 TEXT NOT NULL DEFAULT('');
+	`
+
+	// 17 -> 18
+	sqliteMigrate17To18CreateCallAcksTableQuery = `
+		CREATE TABLE IF NOT EXISTS call_acks (
+			topic TEXT NOT NULL,
+			sequence_id TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (topic, sequence_id, phone_number)
+		);
+		CREATE INDEX IF NOT EXISTS idx_call_acks_time ON call_acks (time);
+	`
+
+	// 18 -> 19
+	sqliteMigrate18To19CreateIndexQuery = `
+		CREATE INDEX IF NOT EXISTS idx_attachment_object ON messages (attachment_object);
+	`
+
+	// 19 -> 20
+	sqliteMigrate19To20CreateSMSStatusesTableQuery = `
+		CREATE TABLE IF NOT EXISTS sms_statuses (
+			mid TEXT NOT NULL,
+			sid TEXT NOT NULL,
+			phone_number TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_code TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (sid, status)
+		);
+		CREATE INDEX IF NOT EXISTS idx_sms_statuses_mid ON sms_statuses (mid);
+		CREATE INDEX IF NOT EXISTS idx_sms_statuses_time ON sms_statuses (time);
+	`
 )
 
 var (
@@ -200,6 +266,11 @@ func sqliteMigrations(cacheDuration time.Duration) map[int]schema.MigrateFunc {
 		12: schema.AsMigrateFunc(sqliteMigrate12To13AlterMessagesTableQuery),
 		13: schema.AsMigrateFunc(sqliteMigrate13To14AlterMessagesTableQuery),
 		14: schema.NopMigrateFunc, // Corresponds to Postgres migration
+		15: schema.AsMigrateFunc(sqliteMigrate15To16AlterMessagesTableQuery),
+		16: schema.AsMigrateFunc(sqliteMigrate16To17AlterMessagesTableQuery),
+		17: schema.AsMigrateFunc(sqliteMigrate17To18CreateCallAcksTableQuery),
+		18: schema.AsMigrateFunc(sqliteMigrate18To19CreateIndexQuery),
+		19: schema.AsMigrateFunc(sqliteMigrate19To20CreateSMSStatusesTableQuery),
 	}
 }
 
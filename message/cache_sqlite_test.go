@@ -53,7 +53,7 @@ func TestSqliteStore_Migration_From1(t *testing.T) {
 
 	// The migrated database must be structurally identical to a freshly created one
 	freshFile := newSqliteTestStoreFile(t)
-	fresh, err := message.NewSQLiteStore(freshFile, "", time.Hour, 0, 0, false)
+	fresh, err := message.NewSQLiteStore(freshFile, "", time.Hour, 0, 0, nil, false)
 	require.Nil(t, err)
 	t.Cleanup(func() { fresh.Close() })
 	freshDB, err := sql.Open("sqlite3", freshFile)
@@ -169,7 +169,7 @@ func TestSqliteStore_Migration_From9(t *testing.T) {
 
 	// Create store to trigger migration
 	cacheDuration := 17 * time.Hour
-	s, err := message.NewSQLiteStore(filename, "", cacheDuration, 0, 0, false)
+	s, err := message.NewSQLiteStore(filename, "", cacheDuration, 0, 0, nil, false)
 	require.Nil(t, err)
 	t.Cleanup(func() { s.Close() })
 	checkSqliteSchemaVersion(t, filename)
@@ -183,7 +183,7 @@ func TestSqliteStore_Migration_From9(t *testing.T) {
 	require.True(t, rows.Next())
 	var version int
 	require.Nil(t, rows.Scan(&version))
-	require.Equal(t, 15, version)
+	require.Equal(t, 20, version)
 	require.Nil(t, rows.Close())
 
 	messages, err := s.Messages("mytopic", model.SinceAllMessages, false)
@@ -200,7 +200,7 @@ func TestSqliteStore_StartupQueries_WAL(t *testing.T) {
 	startupQueries := `pragma journal_mode = WAL;
 pragma synchronous = normal;
 pragma temp_store = memory;`
-	s, err := message.NewSQLiteStore(filename, startupQueries, time.Hour, 0, 0, false)
+	s, err := message.NewSQLiteStore(filename, startupQueries, time.Hour, 0, 0, nil, false)
 	require.Nil(t, err)
 	t.Cleanup(func() { s.Close() })
 	require.Nil(t, s.AddMessage(model.NewDefaultMessage("mytopic", "some message")))
@@ -211,7 +211,7 @@ pragma temp_store = memory;`
 
 func TestSqliteStore_StartupQueries_None(t *testing.T) {
 	filename := newSqliteTestStoreFile(t)
-	s, err := message.NewSQLiteStore(filename, "", time.Hour, 0, 0, false)
+	s, err := message.NewSQLiteStore(filename, "", time.Hour, 0, 0, nil, false)
 	require.Nil(t, err)
 	t.Cleanup(func() { s.Close() })
 	require.Nil(t, s.AddMessage(model.NewDefaultMessage("mytopic", "some message")))
@@ -222,7 +222,7 @@ func TestSqliteStore_StartupQueries_None(t *testing.T) {
 
 func TestSqliteStore_StartupQueries_Fail(t *testing.T) {
 	filename := newSqliteTestStoreFile(t)
-	_, err := message.NewSQLiteStore(filename, `xx error`, time.Hour, 0, 0, false)
+	_, err := message.NewSQLiteStore(filename, `xx error`, time.Hour, 0, 0, nil, false)
 	require.Error(t, err)
 }
 
@@ -246,7 +246,7 @@ func newSqliteTestStoreFile(t *testing.T) string {
 }
 
 func newSqliteTestStoreFromFile(t *testing.T, filename, startupQueries string) *message.Cache {
-	s, err := message.NewSQLiteStore(filename, startupQueries, time.Hour, 0, 0, false)
+	s, err := message.NewSQLiteStore(filename, startupQueries, time.Hour, 0, 0, nil, false)
 	require.Nil(t, err)
 	t.Cleanup(func() { s.Close() })
 	return s
@@ -261,6 +261,6 @@ func checkSqliteSchemaVersion(t *testing.T, filename string) {
 	require.True(t, rows.Next())
 	var schemaVersion int
 	require.Nil(t, rows.Scan(&schemaVersion))
-	require.Equal(t, 15, schemaVersion)
+	require.Equal(t, 20, schemaVersion)
 	require.Nil(t, rows.Close())
 }
@@ -3,6 +3,7 @@ package message_test
 import (
 	"net/netip"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -10,13 +11,19 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	dbtest "heckel.io/ntfy/v2/db/test"
+	"heckel.io/ntfy/v2/encryption"
 	"heckel.io/ntfy/v2/message"
 	"heckel.io/ntfy/v2/model"
 )
 
+const (
+	testKey1 = "key1:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzEhISE="
+	testKey2 = "key2:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzIhISE="
+)
+
 func newSqliteTestStore(t *testing.T) *message.Cache {
 	filename := filepath.Join(t.TempDir(), "cache.db")
-	s, err := message.NewSQLiteStore(filename, "", time.Hour, 0, 0, false)
+	s, err := message.NewSQLiteStore(filename, "", time.Hour, 0, 0, nil, false)
 	require.Nil(t, err)
 	t.Cleanup(func() { s.Close() })
 	return s
@@ -31,7 +38,7 @@ func newMemTestStore(t *testing.T) *message.Cache {
 
 func newTestPostgresStore(t *testing.T) *message.Cache {
 	testDB := dbtest.CreateTestPostgres(t)
-	store, err := message.NewPostgresStore(testDB, 0, 0)
+	store, err := message.NewPostgresStore(testDB, 0, 0, nil)
 	require.Nil(t, err)
 	return store
 }
@@ -310,11 +317,12 @@ func TestStore_Attachments(t *testing.T) {
 		m.SequenceID = "m2"
 		m.Sender = netip.MustParseAddr("1.2.3.4")
 		m.Attachment = &model.Attachment{
-			Name:    "car.jpg",
-			Type:    "image/jpeg",
-			Size:    10000,
-			Expires: expires2,
-			URL:     "https://ntfy.sh/file/aCaRURL.jpg",
+			Name:      "car.jpg",
+			Type:      "image/jpeg",
+			Size:      10000,
+			Expires:   expires2,
+			URL:       "https://ntfy.sh/file/aCaRURL.jpg",
+			Thumbnail: "https://ntfy.sh/file/aCaRURL/thumbnail.jpg",
 		}
 		require.Nil(t, s.AddMessage(m))
 
@@ -343,6 +351,7 @@ func TestStore_Attachments(t *testing.T) {
 		require.Equal(t, int64(5000), messages[0].Attachment.Size)
 		require.Equal(t, expires1, messages[0].Attachment.Expires)
 		require.Equal(t, "https://ntfy.sh/file/AbDeFgJhal.jpg", messages[0].Attachment.URL)
+		require.Equal(t, "", messages[0].Attachment.Thumbnail)
 		require.Equal(t, "1.2.3.4", messages[0].Sender.String())
 
 		require.Equal(t, "sending you a car", messages[1].Message)
@@ -351,6 +360,7 @@ func TestStore_Attachments(t *testing.T) {
 		require.Equal(t, int64(10000), messages[1].Attachment.Size)
 		require.Equal(t, expires2, messages[1].Attachment.Expires)
 		require.Equal(t, "https://ntfy.sh/file/aCaRURL.jpg", messages[1].Attachment.URL)
+		require.Equal(t, "https://ntfy.sh/file/aCaRURL/thumbnail.jpg", messages[1].Attachment.Thumbnail)
 		require.Equal(t, "1.2.3.4", messages[1].Sender.String())
 
 		size, err := s.AttachmentBytesUsedBySender("1.2.3.4")
@@ -367,6 +377,69 @@ func TestStore_Attachments(t *testing.T) {
 	})
 }
 
+func TestStore_AttachmentsDeduplicated(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *message.Cache) {
+		expires := time.Now().Add(time.Hour).Unix()
+		for _, topic := range []string{"mytopic", "another-topic"} {
+			m := model.NewDefaultMessage(topic, "build log")
+			m.Sender = netip.MustParseAddr("1.2.3.4")
+			m.Attachment = &model.Attachment{
+				Name:    "build.log",
+				Type:    "text/plain",
+				Size:    5000,
+				Expires: expires,
+				URL:     "https://ntfy.sh/file/" + m.ID + ".txt",
+				SHA256:  "0a1b2c",
+			}
+			if topic == "another-topic" {
+				m.Attachment.Object = "firstmessage"
+			} else {
+				m.ID = "firstmessage"
+			}
+			require.Nil(t, s.AddMessage(m))
+		}
+		m := model.NewDefaultMessage("mytopic", "screenshot")
+		m.ID = "screenshot12"
+		m.Sender = netip.MustParseAddr("1.2.3.4")
+		m.Attachment = &model.Attachment{
+			Name:    "screenshot.png",
+			Type:    "image/png",
+			Size:    1000,
+			Expires: expires,
+			URL:     "https://ntfy.sh/file/screenshot12.png",
+		}
+		require.Nil(t, s.AddMessage(m))
+
+		messages, err := s.Messages("another-topic", model.SinceAllMessages, false)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(messages))
+		require.Equal(t, "firstmessage", messages[0].Attachment.Object)
+		require.Equal(t, "firstmessage", messages[0].Attachment.ObjectID(messages[0].ID))
+
+		// Shared object is listed and counted once
+		objects, err := s.AttachmentObjects()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(objects))
+		require.Equal(t, &model.AttachmentObject{ID: "firstmessage", Size: 5000, SHA256: "0a1b2c"}, objects["firstmessage"])
+		require.Equal(t, &model.AttachmentObject{ID: "screenshot12", Size: 1000}, objects["screenshot12"])
+
+		// Only the given objects are looked up
+		referenced, err := s.ReferencedAttachmentObjects([]string{"firstmessage", "screenshot12", "notexisting1"})
+		require.Nil(t, err)
+		require.ElementsMatch(t, []string{"firstmessage", "screenshot12"}, referenced)
+		referenced, err = s.ReferencedAttachmentObjects([]string{"screenshot12"})
+		require.Nil(t, err)
+		require.Equal(t, []string{"screenshot12"}, referenced)
+		referenced, err = s.ReferencedAttachmentObjects([]string{})
+		require.Nil(t, err)
+		require.Empty(t, referenced)
+
+		size, err := s.AttachmentBytesUsedBySender("1.2.3.4")
+		require.Nil(t, err)
+		require.Equal(t, int64(6000), size)
+	})
+}
+
 func TestStore_AttachmentsExpired(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, s *message.Cache) {
 		m := model.NewDefaultMessage("mytopic", "flower for you")
@@ -416,6 +489,11 @@ func TestStore_AttachmentsExpired(t *testing.T) {
 		count, err := s.MarkExpiredAttachmentsDeleted(10)
 		require.Nil(t, err)
 		require.Equal(t, int64(1), count)
+
+		// Expired attachment is no longer referenced
+		referenced, err := s.ReferencedAttachmentObjects([]string{"m2", "m4"})
+		require.Nil(t, err)
+		require.Equal(t, []string{"m2"}, referenced)
 	})
 }
 
@@ -947,3 +1025,165 @@ func TestStore_AddMessage_InvalidUTF8BatchDoesNotDropValidMessages(t *testing.T)
 		require.Equal(t, 3, len(messages))
 	})
 }
+
+func TestStore_Encryption(t *testing.T) {
+	forEachEncryptedBackend(t, func(t *testing.T, open func(keyring *encryption.Keyring) *message.Cache) {
+		k1 := newTestKeyring(t, testKey1)
+		s := open(k1)
+		m := model.NewDefaultMessage("mytopic", "secret message")
+		m.Title = "secret title"
+		require.Nil(t, s.AddMessage(m))
+		require.Nil(t, s.AddMessage(model.NewDefaultMessage("mytopic", "")))
+
+		messages, err := s.Messages("mytopic", model.SinceAllMessages, false)
+		require.Nil(t, err)
+		require.Equal(t, 2, len(messages))
+		require.Equal(t, "secret message", messages[0].Message)
+		require.Equal(t, "secret title", messages[0].Title)
+		require.Equal(t, "", messages[1].Message)
+
+		// Stored encrypted
+		raw, err := open(nil).Message(m.ID)
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(raw.Message, "enc:v1:key1:"))
+		require.True(t, strings.HasPrefix(raw.Title, "enc:v1:key1:"))
+
+		// Unknown key: returned as is
+		other, err := open(newTestKeyring(t, testKey2)).Message(m.ID)
+		require.Nil(t, err)
+		require.Equal(t, raw.Message, other.Message)
+	})
+}
+
+func TestStore_ReencryptMessages(t *testing.T) {
+	forEachEncryptedBackend(t, func(t *testing.T, open func(keyring *encryption.Keyring) *message.Cache) {
+		m1 := model.NewDefaultMessage("mytopic", "plaintext message")
+		require.Nil(t, open(nil).AddMessage(m1))
+		m2 := model.NewDefaultMessage("mytopic", "old key message")
+		m2.Title = "old key title"
+		require.Nil(t, open(newTestKeyring(t, testKey1)).AddMessage(m2))
+
+		_, err := open(nil).ReencryptMessages()
+		require.Error(t, err)
+
+		// Rotate key and re-encrypt
+		s := open(newTestKeyring(t, testKey1, testKey2))
+		count, err := s.ReencryptMessages()
+		require.Nil(t, err)
+		require.Equal(t, 2, count)
+		count, err = s.ReencryptMessages()
+		require.Nil(t, err)
+		require.Equal(t, 0, count)
+
+		// Old key is not needed anymore
+		messages, err := open(newTestKeyring(t, testKey2)).Messages("mytopic", model.SinceAllMessages, false)
+		require.Nil(t, err)
+		require.Equal(t, 2, len(messages))
+		require.Equal(t, "plaintext message", messages[0].Message)
+		require.Equal(t, "old key message", messages[1].Message)
+		require.Equal(t, "old key title", messages[1].Title)
+		raw, err := open(nil).Message(m1.ID)
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(raw.Message, "enc:v1:key2:"))
+	})
+}
+
+// forEachEncryptedBackend runs f against the SQLite and PostgreSQL backends. The open function opens
+// the same underlying database with the given keyring (nil = no encryption).
+func forEachEncryptedBackend(t *testing.T, f func(t *testing.T, open func(keyring *encryption.Keyring) *message.Cache)) {
+	t.Run("sqlite", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "cache.db")
+		f(t, func(keyring *encryption.Keyring) *message.Cache {
+			s, err := message.NewSQLiteStore(filename, "", time.Hour, 0, 0, keyring, false)
+			require.Nil(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testDB := dbtest.CreateTestPostgres(t)
+		f(t, func(keyring *encryption.Keyring) *message.Cache {
+			s, err := message.NewPostgresStore(testDB, 0, 0, keyring)
+			require.Nil(t, err)
+			return s
+		})
+	})
+}
+
+func newTestKeyring(t *testing.T, entries ...string) *encryption.Keyring {
+	keyring, err := encryption.ReadKeyring(strings.NewReader(strings.Join(entries, "\n")))
+	require.Nil(t, err)
+	return keyring
+}
+
+func TestStore_CallAcks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *message.Cache) {
+		acknowledged, err := s.CallAcknowledged("mytopic", "incident1", "+12223334444")
+		require.Nil(t, err)
+		require.False(t, acknowledged)
+
+		added, err := s.AddCallAck("mytopic", "incident1", "+12223334444")
+		require.Nil(t, err)
+		require.True(t, added)
+		added, err = s.AddCallAck("mytopic", "incident1", "+12223334444")
+		require.Nil(t, err)
+		require.False(t, added) // Already acknowledged
+
+		acknowledged, err = s.CallAcknowledged("mytopic", "incident1", "+12223334444")
+		require.Nil(t, err)
+		require.True(t, acknowledged)
+		acknowledged, err = s.CallAcknowledged("mytopic", "incident1", "+19998887777")
+		require.Nil(t, err)
+		require.False(t, acknowledged)
+		acknowledged, err = s.CallAcknowledged("mytopic", "incident2", "+12223334444")
+		require.Nil(t, err)
+		require.False(t, acknowledged)
+
+		// Prune
+		count, err := s.DeleteCallAcks(time.Now().Add(-time.Minute))
+		require.Nil(t, err)
+		require.Equal(t, int64(0), count)
+		count, err = s.DeleteCallAcks(time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.Equal(t, int64(1), count)
+		acknowledged, err = s.CallAcknowledged("mytopic", "incident1", "+12223334444")
+		require.Nil(t, err)
+		require.False(t, acknowledged)
+	})
+}
+
+func TestStore_SMSStatuses(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *message.Cache) {
+		statuses, err := s.SMSStatuses("msg1")
+		require.Nil(t, err)
+		require.Len(t, statuses, 0)
+
+		now := time.Now().Unix()
+		require.Nil(t, s.AddSMSStatus(&message.SMSStatus{MessageID: "msg1", SID: "SM1", PhoneNumber: "+12223334444", Status: "sent", Time: now}))
+		require.Nil(t, s.AddSMSStatus(&message.SMSStatus{MessageID: "msg1", SID: "SM1", PhoneNumber: "+12223334444", Status: "sent", Time: now})) // Repeated, ignored
+		require.Nil(t, s.AddSMSStatus(&message.SMSStatus{MessageID: "msg1", SID: "SM1", PhoneNumber: "+12223334444", Status: "undelivered", ErrorCode: "30003", Time: now + 1}))
+		require.Nil(t, s.AddSMSStatus(&message.SMSStatus{MessageID: "msg2", SID: "SM2", PhoneNumber: "+19998887777", Status: "delivered", Time: now}))
+
+		statuses, err = s.SMSStatuses("msg1")
+		require.Nil(t, err)
+		require.Len(t, statuses, 2)
+		require.Equal(t, "sent", statuses[0].Status)
+		require.Equal(t, "undelivered", statuses[1].Status)
+		require.Equal(t, "30003", statuses[1].ErrorCode)
+		require.Equal(t, "SM1", statuses[1].SID)
+		require.Equal(t, "+12223334444", statuses[1].PhoneNumber)
+		require.Equal(t, now+1, statuses[1].Time)
+
+		// Prune
+		count, err := s.DeleteSMSStatuses(time.Unix(now, 0))
+		require.Nil(t, err)
+		require.Equal(t, int64(0), count)
+		count, err = s.DeleteSMSStatuses(time.Unix(now+1, 0))
+		require.Nil(t, err)
+		require.Equal(t, int64(2), count)
+		statuses, err = s.SMSStatuses("msg1")
+		require.Nil(t, err)
+		require.Len(t, statuses, 1)
+		require.Equal(t, "undelivered", statuses[0].Status)
+	})
+}
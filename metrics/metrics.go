@@ -48,6 +48,21 @@ var (
 	CallsMadeFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_calls_made_failure",
 	})
+	CallsAcknowledged = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_calls_acknowledged",
+	})
+	SMSSentSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_sms_sent_success",
+	})
+	SMSSentFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_sms_sent_failure",
+	})
+	SMSDeliveredSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_sms_delivered_success",
+	})
+	SMSDeliveredFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_sms_delivered_failure",
+	})
 	UnifiedPushPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_unifiedpush_published_success",
 	})
@@ -94,6 +109,11 @@ func init() {
 		EmailsReceivedFailure,
 		CallsMadeSuccess,
 		CallsMadeFailure,
+		CallsAcknowledged,
+		SMSSentSuccess,
+		SMSSentFailure,
+		SMSDeliveredSuccess,
+		SMSDeliveredFailure,
 		UnifiedPushPublishedSuccess,
 		MatrixPublishedSuccess,
 		MatrixPublishedFailure,
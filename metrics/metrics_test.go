@@ -13,6 +13,7 @@ import (
 // contract: renaming or dropping one silently breaks existing dashboards and alerts.
 var expectedMetricNames = []string{
 	"ntfy_attachments_total_size",
+	"ntfy_calls_acknowledged",
 	"ntfy_calls_made_failure",
 	"ntfy_calls_made_success",
 	"ntfy_emails_received_failure",
@@ -28,6 +29,10 @@ var expectedMetricNames = []string{
 	"ntfy_messages_cached_total",
 	"ntfy_messages_published_failure",
 	"ntfy_messages_published_success",
+	"ntfy_sms_delivered_failure",
+	"ntfy_sms_delivered_success",
+	"ntfy_sms_sent_failure",
+	"ntfy_sms_sent_success",
 	"ntfy_subscribers_total",
 	"ntfy_topics_total",
 	"ntfy_unifiedpush_published_success",
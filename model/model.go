@@ -16,6 +16,7 @@ const (
 	MessageEvent       = "message"
 	MessageDeleteEvent = "message_delete"
 	MessageClearEvent  = "message_clear"
+	MessageAckEvent    = "message_ack"
 	PollRequestEvent   = "poll_request"
 )
 
@@ -46,7 +47,7 @@ type Message struct {
 	Attachment  *Attachment `json:"attachment,omitempty"`
 	PollID      string      `json:"poll_id,omitempty"`
 	ContentType string      `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
-	Encoding    string      `json:"encoding,omitempty"`     // Empty for raw UTF-8, or "base64" for encoded bytes
+	Encoding    string      `json:"encoding,omitempty"`     // Empty for raw UTF-8, "base64" for encoded bytes, or "jwe" for end-to-end encrypted messages
 	Sender      netip.Addr  `json:"-"`                      // IP address of uploader, used for rate limiting
 	User        string      `json:"-"`                      // UserID of the uploader, used to associated attachments
 }
@@ -103,11 +104,30 @@ func (m *Message) ForJSON() *Message {
 
 // Attachment represents a file attachment on a message
 type Attachment struct {
-	Name    string `json:"name"`
-	Type    string `json:"type,omitempty"`
-	Size    int64  `json:"size,omitempty"`
-	Expires int64  `json:"expires,omitempty"`
-	URL     string `json:"url"`
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Expires   int64  `json:"expires,omitempty"`
+	URL       string `json:"url"`
+	Thumbnail string `json:"thumbnail,omitempty"` // URL of a small preview image, only set for image attachments
+	Object    string `json:"-"`                   // ID of the stored file, if it is shared with an identical attachment of another message
+	SHA256    string `json:"-"`                   // Hex-encoded SHA-256 hash of the file, used to deduplicate identical attachments
+}
+
+// ObjectID returns the ID of the stored attachment file of the message with the given ID. Identical attachments
+// are stored only once, so the file may belong to another message (see attachment.Store).
+func (a *Attachment) ObjectID(messageID string) string {
+	if a.Object != "" {
+		return a.Object
+	}
+	return messageID
+}
+
+// AttachmentObject is a stored attachment file, which may be shared by multiple messages (see attachment.Store)
+type AttachmentObject struct {
+	ID     string // Object ID, i.e. the ID of the first message with this attachment
+	Size   int64  // Size of the file in bytes
+	SHA256 string // Hex-encoded SHA-256 hash of the file, empty if unknown (e.g. for presigned uploads)
 }
 
 // Action represents a user-defined action on a message
@@ -169,7 +189,7 @@ func NewDefaultMessage(topic, msg string) *Message {
 	return NewMessage(MessageEvent, topic, msg)
 }
 
-// NewActionMessage creates a new action message (message_delete or message_clear)
+// NewActionMessage creates a new action message (message_delete, message_clear or message_ack)
 func NewActionMessage(event, topic, sequenceID string) *Message {
 	m := NewMessage(event, topic, "")
 	m.SequenceID = sequenceID
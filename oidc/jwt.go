@@ -0,0 +1,232 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a verified ID token
+type Claims map[string]any
+
+// Subject returns the "sub" claim, the unique and never reassigned identifier of the user at the issuer
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String returns the claim with the given name if it is a string, or an empty string otherwise
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim with the given name if it is a string or a list of strings (e.g. "aud" or
+// "groups"), or nil otherwise. Non-string list entries are ignored.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, entry := range v {
+			if s, ok := entry.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Time returns the claim with the given name if it is a numeric date (seconds since the epoch)
+func (c Claims) Time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// jwtHeader is the JOSE header of a signed JWT
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// jwk is a JSON web key, see RFC 7517; only RSA and EC signing keys are supported
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// jwks is a JSON web key set, as returned by the "jwks_uri" endpoint
+type jwks struct {
+	Keys []*jwk `json:"keys"`
+}
+
+// parseJWT splits a compact JWS into its header, claims, signing input and signature, without verifying it
+func parseJWT(token string) (header *jwtHeader, claims Claims, signed []byte, signature []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrInvalidIDToken, len(parts))
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: invalid header encoding", ErrInvalidIDToken)
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: invalid payload encoding", ErrInvalidIDToken)
+	}
+	signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: invalid signature encoding", ErrInvalidIDToken)
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header == nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: invalid header", ErrInvalidIDToken)
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil || claims == nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: invalid payload", ErrInvalidIDToken)
+	}
+	return header, claims, []byte(parts[0] + "." + parts[1]), signature, nil
+}
+
+// verifySignature verifies the JWS signature of the signing input with the given key. The algorithm must match
+// the key type, so that a token cannot switch to a weaker algorithm (or "none").
+func verifySignature(alg string, key any, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidIDToken, alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		if strings.HasPrefix(alg, "RS") {
+			err = rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		} else if strings.HasPrefix(alg, "PS") {
+			err = rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			return fmt.Errorf("%w: algorithm %s does not match RSA key", ErrInvalidIDToken, alg)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: invalid signature", ErrInvalidIDToken)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || k.Curve.Params().BitSize != ecdsaBitSize(alg) {
+			return fmt.Errorf("%w: algorithm %s does not match EC key", ErrInvalidIDToken, alg)
+		} else if len(signature) != 2*size {
+			return fmt.Errorf("%w: invalid signature", ErrInvalidIDToken)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("%w: invalid signature", ErrInvalidIDToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported key type", ErrInvalidIDToken)
+}
+
+func ecdsaBitSize(alg string) int {
+	switch alg {
+	case "ES256":
+		return 256
+	case "ES384":
+		return 384
+	default:
+		return 521 // ES512 uses P-521
+	}
+}
+
+// fetchKeys fetches the key set from the "jwks_uri" endpoint, and returns the signing keys by key ID. Keys that
+// are not signing keys, or of unsupported types, are skipped.
+func fetchKeys(ctx context.Context, client *http.Client, jwksURI string) (map[string]any, error) {
+	set, err := getJSON[jwks](ctx, client, jwksURI)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch signing keys: %w", err)
+	}
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+}
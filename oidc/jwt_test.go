@@ -0,0 +1,42 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaims_Strings(t *testing.T) {
+	claims := Claims{
+		"single": "a",
+		"list":   []any{"a", 1.0, "b"},
+		"number": 1.0,
+	}
+	require.Equal(t, []string{"a"}, claims.Strings("single"))
+	require.Equal(t, []string{"a", "b"}, claims.Strings("list"))
+	require.Nil(t, claims.Strings("number"))
+	require.Equal(t, "", claims.String("number"))
+}
+
+func TestVerifySignature_ECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	signed := []byte("header.payload")
+	digest := sha256.Sum256(signed)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.Nil(t, err)
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	require.Nil(t, verifySignature("ES256", &key.PublicKey, signed, signature))
+	require.True(t, errors.Is(verifySignature("ES256", &key.PublicKey, []byte("header.other"), signature), ErrInvalidIDToken))
+	require.True(t, errors.Is(verifySignature("ES384", &key.PublicKey, signed, signature), ErrInvalidIDToken))
+	require.True(t, errors.Is(verifySignature("RS256", &key.PublicKey, signed, signature), ErrInvalidIDToken))
+	require.True(t, errors.Is(verifySignature("none", &key.PublicKey, signed, nil), ErrInvalidIDToken))
+}
@@ -0,0 +1,276 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE (RFC 7636), which is used
+// to log in users via an external identity provider (IdP), e.g. Keycloak, Authentik, Okta or Entra ID.
+//
+// Only the parts of the spec that ntfy needs are implemented: provider discovery, the authorization request,
+// the code exchange, and the verification of the returned ID token (RS256/384/512, PS256/384/512 and
+// ES256/384/512 signatures, issuer, audience, expiry and nonce).
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	discoveryPath         = "/.well-known/openid-configuration"
+	defaultRequestTimeout = 10 * time.Second
+	maxResponseBytes      = 1024 * 1024     // Discovery documents, key sets and token responses are small
+	keysRefreshInterval   = time.Minute     // Don't re-fetch the key set more often than this, even for unknown key IDs
+	allowedClockSkew      = 2 * time.Minute // Tolerance for the "exp" and "nbf" claims
+	randomValueLength     = 43              // Length of state, nonce and code verifier (43 = min. length of a PKCE verifier)
+	scopeOpenID           = "openid"        // Required scope for OpenID Connect
+	codeChallengeMethod   = "S256"          // PKCE method, "plain" is not supported
+	grantTypeAuthCode     = "authorization_code"
+	responseTypeCode      = "code"
+)
+
+// Errors returned by Provider
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrExchangeFailed = errors.New("authorization code exchange failed")
+)
+
+// Config is the configuration of a Provider
+type Config struct {
+	Issuer       string   // Issuer URL, e.g. https://auth.example.com/realms/main; must match the "iss" claim
+	ClientID     string   // Client ID, as registered with the IdP; must match the "aud" claim
+	ClientSecret string   // Client secret, may be empty for public clients
+	RedirectURL  string   // Callback URL, e.g. https://ntfy.example.com/v1/account/oidc/callback
+	Scopes       []string // Requested scopes; "openid" is always requested
+}
+
+// Provider is an OpenID Connect identity provider. The provider metadata (endpoints) is discovered on first use,
+// and the signing keys are fetched on first use, and re-fetched if a token is signed with an unknown key.
+type Provider struct {
+	config      *Config
+	client      *http.Client
+	metadata    *metadata
+	keys        map[string]any // Key ID -> *rsa.PublicKey or *ecdsa.PublicKey
+	keysFetched time.Time
+	mu          sync.Mutex
+}
+
+// metadata is the subset of the provider metadata (discovery document) that is needed for the code flow
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// tokenResponse is the response of the token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// New creates a new Provider. It does not contact the identity provider.
+func New(config *Config) *Provider {
+	return &Provider{
+		config: config,
+		client: &http.Client{
+			Timeout: defaultRequestTimeout,
+		},
+	}
+}
+
+// NewAuthRequest creates the random values for a new authorization request: the state (to match the callback to
+// the request), the nonce (to match the ID token to the request), and the PKCE code verifier. All three must be
+// stored by the caller until the callback, and must never be reused.
+func NewAuthRequest() (state, nonce, verifier string) {
+	return util.RandomString(randomValueLength), util.RandomString(randomValueLength), util.RandomString(randomValueLength)
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint the user's browser should be redirected to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	query := u.Query()
+	query.Set("response_type", responseTypeCode)
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.scopes(), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", codeChallengeMethod)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Exchange exchanges the authorization code from the callback for an ID token, verifies the token, and returns
+// its claims. The verifier and nonce must be the ones that were passed to AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", grantTypeAuthCode)
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret)) // client_secret_basic, see RFC 6749, 2.3.1
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrExchangeFailed, err)
+	}
+	defer resp.Body.Close()
+	token, err := util.UnmarshalJSON[tokenResponse](io.NopCloser(io.LimitReader(resp.Body, maxResponseBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unexpected response, status %d", ErrExchangeFailed, resp.StatusCode)
+	} else if token.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchangeFailed, token.Error, token.ErrorDescription)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected response, status %d", ErrExchangeFailed, resp.StatusCode)
+	} else if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in response, is the 'openid' scope allowed?", ErrExchangeFailed)
+	}
+	return p.verify(ctx, token.IDToken, nonce)
+}
+
+// verify checks the signature and the claims of the ID token, see OpenID Connect Core, 3.1.3.7
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (Claims, error) {
+	header, claims, signed, signature, err := parseJWT(idToken)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Algorithm, key, signed, signature); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if claims.String("iss") != p.config.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIDToken, claims.String("iss"))
+	} else if !slices.Contains(claims.Strings("aud"), p.config.ClientID) {
+		return nil, fmt.Errorf("%w: audience does not contain client ID", ErrInvalidIDToken)
+	} else if azp := claims.String("azp"); azp != "" && azp != p.config.ClientID {
+		return nil, fmt.Errorf("%w: unexpected authorized party %s", ErrInvalidIDToken, azp)
+	} else if exp, ok := claims.Time("exp"); !ok || now.After(exp.Add(allowedClockSkew)) {
+		return nil, fmt.Errorf("%w: token expired or expiry missing", ErrInvalidIDToken)
+	} else if nbf, ok := claims.Time("nbf"); ok && now.Add(allowedClockSkew).Before(nbf) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidIDToken)
+	} else if claims.String("nonce") != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	} else if claims.Subject() == "" {
+		return nil, fmt.Errorf("%w: subject missing", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// discover fetches the provider metadata from the discovery endpoint, or returns the cached metadata
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	m, err := getJSON[metadata](ctx, p.client, strings.TrimSuffix(p.config.Issuer, "/")+discoveryPath)
+	if err != nil {
+		return nil, fmt.Errorf("provider discovery failed: %w", err)
+	} else if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("provider discovery failed: issuer %s does not match configured issuer %s", m.Issuer, p.config.Issuer)
+	} else if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("provider discovery failed: authorization_endpoint, token_endpoint or jwks_uri missing")
+	}
+	p.metadata = m
+	return m, nil
+}
+
+// key returns the public key with the given key ID, fetching the key set if the key is unknown. If the key ID
+// is empty (allowed if the provider has only one key), the only key in the set is returned.
+func (p *Provider) key(ctx context.Context, keyID string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(keyID)
+	refresh := !ok && time.Since(p.keysFetched) > keysRefreshInterval
+	jwksURI := p.metadata.JWKSURI
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	} else if !refresh {
+		return nil, fmt.Errorf("%w: unknown key ID %s", ErrInvalidIDToken, keyID)
+	}
+	keys, err := fetchKeys(ctx, p.client, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	if key, ok := p.lookupKey(keyID); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key ID %s", ErrInvalidIDToken, keyID)
+}
+
+func (p *Provider) lookupKey(keyID string) (any, bool) {
+	if keyID == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[keyID]
+	return key, ok
+}
+
+func (p *Provider) scopes() []string {
+	if slices.Contains(p.config.Scopes, scopeOpenID) {
+		return p.config.Scopes
+	}
+	return append([]string{scopeOpenID}, p.config.Scopes...)
+}
+
+// codeChallenge derives the S256 PKCE code challenge from the code verifier, see RFC 7636, 4.2
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON[T any](ctx context.Context, client *http.Client, u string) (*T, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d from %s", resp.StatusCode, u)
+	}
+	return util.UnmarshalJSON[T](io.NopCloser(io.LimitReader(resp.Body, maxResponseBytes)))
+}
@@ -0,0 +1,152 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/oidc"
+	"heckel.io/ntfy/v2/oidc/oidctest"
+)
+
+func TestProvider_Login(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	issuer.SetClaims(map[string]any{
+		"sub":                "f1a2b3",
+		"preferred_username": "phil",
+		"groups":             []string{"ntfy-users", "ntfy-admins"},
+	})
+	p := newTestProvider(issuer)
+
+	claims, err := login(t, issuer, p)
+	require.Nil(t, err)
+	require.Equal(t, "f1a2b3", claims.Subject())
+	require.Equal(t, "phil", claims.String("preferred_username"))
+	require.Equal(t, []string{"ntfy-users", "ntfy-admins"}, claims.Strings("groups"))
+	require.Equal(t, []string{"ntfy"}, claims.Strings("aud"))
+	require.Nil(t, claims.Strings("does-not-exist"))
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	p := oidc.New(&oidc.Config{
+		Issuer:      issuer.URL,
+		ClientID:    "ntfy",
+		RedirectURL: "https://ntfy.example.com/v1/account/oidc/callback",
+		Scopes:      []string{"profile", "groups"},
+	})
+	authURL, err := p.AuthCodeURL(context.Background(), "state1", "nonce1", "verifier1")
+	require.Nil(t, err)
+	u, err := url.Parse(authURL)
+	require.Nil(t, err)
+	challenge := sha256.Sum256([]byte("verifier1"))
+	require.Equal(t, issuer.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(t, "code", u.Query().Get("response_type"))
+	require.Equal(t, "ntfy", u.Query().Get("client_id"))
+	require.Equal(t, "https://ntfy.example.com/v1/account/oidc/callback", u.Query().Get("redirect_uri"))
+	require.Equal(t, "openid profile groups", u.Query().Get("scope"))
+	require.Equal(t, "state1", u.Query().Get("state"))
+	require.Equal(t, "nonce1", u.Query().Get("nonce"))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(challenge[:]), u.Query().Get("code_challenge"))
+	require.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+}
+
+func TestProvider_DiscoveryFailed(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	p := oidc.New(&oidc.Config{
+		Issuer:   issuer.URL + "/wrong",
+		ClientID: "ntfy",
+	})
+	_, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	require.ErrorContains(t, err, "provider discovery failed")
+}
+
+func TestProvider_ExchangeInvalid(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	p := newTestProvider(issuer)
+	ctx := context.Background()
+
+	// Wrong PKCE code verifier
+	state, nonce, verifier := oidc.NewAuthRequest()
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	require.Nil(t, err)
+	code := callbackCode(t, issuer, authURL, state)
+	_, err = p.Exchange(ctx, code, verifier+"x", nonce)
+	require.True(t, errors.Is(err, oidc.ErrExchangeFailed))
+	require.ErrorContains(t, err, "invalid_grant")
+
+	// Codes can only be used once
+	code = callbackCode(t, issuer, authURL, state)
+	_, err = p.Exchange(ctx, code, verifier, nonce)
+	require.Nil(t, err)
+	_, err = p.Exchange(ctx, code, verifier, nonce)
+	require.True(t, errors.Is(err, oidc.ErrExchangeFailed))
+
+	// Wrong nonce
+	code = callbackCode(t, issuer, authURL, state)
+	_, err = p.Exchange(ctx, code, verifier, "other-nonce")
+	require.True(t, errors.Is(err, oidc.ErrInvalidIDToken))
+
+	// Wrong client secret
+	wrongSecret := oidc.New(&oidc.Config{Issuer: issuer.URL, ClientID: "ntfy", ClientSecret: "wrong", RedirectURL: "http://127.0.0.1/callback"})
+	authURL, err = wrongSecret.AuthCodeURL(ctx, state, nonce, verifier)
+	require.Nil(t, err)
+	_, err = wrongSecret.Exchange(ctx, callbackCode(t, issuer, authURL, state), verifier, nonce)
+	require.ErrorContains(t, err, "invalid_client")
+}
+
+func TestProvider_IDTokenClaimsInvalid(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	p := newTestProvider(issuer)
+	tests := map[string]func(claims map[string]any){
+		"expired":        func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"expiry missing": func(claims map[string]any) { delete(claims, "exp") },
+		"not yet valid":  func(claims map[string]any) { claims["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"wrong issuer":   func(claims map[string]any) { claims["iss"] = "https://evil.example.com" },
+		"wrong audience": func(claims map[string]any) { claims["aud"] = []string{"other-client"} },
+		"wrong azp":      func(claims map[string]any) { claims["azp"] = "other-client" },
+		"no subject":     func(claims map[string]any) { delete(claims, "sub") },
+	}
+	for name, mutator := range tests {
+		t.Run(name, func(t *testing.T) {
+			issuer.IDTokenMutator = mutator
+			_, err := login(t, issuer, p)
+			require.True(t, errors.Is(err, oidc.ErrInvalidIDToken), err)
+		})
+	}
+
+	// Multiple audiences are fine, as long as the client ID is one of them
+	issuer.IDTokenMutator = func(claims map[string]any) { claims["aud"] = []string{"other-client", "ntfy"} }
+	_, err := login(t, issuer, p)
+	require.Nil(t, err)
+}
+
+func newTestProvider(issuer *oidctest.Issuer) *oidc.Provider {
+	return oidc.New(&oidc.Config{
+		Issuer:       issuer.URL,
+		ClientID:     issuer.ClientID,
+		ClientSecret: issuer.ClientSecret,
+		RedirectURL:  "http://127.0.0.1/callback",
+	})
+}
+
+// login runs a full authorization code flow against the mock issuer
+func login(t *testing.T, issuer *oidctest.Issuer, p *oidc.Provider) (oidc.Claims, error) {
+	ctx := context.Background()
+	state, nonce, verifier := oidc.NewAuthRequest()
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	require.Nil(t, err)
+	return p.Exchange(ctx, callbackCode(t, issuer, authURL, state), verifier, nonce)
+}
+
+func callbackCode(t *testing.T, issuer *oidctest.Issuer, authURL, state string) string {
+	callback, err := url.Parse(issuer.Authorize(t, authURL))
+	require.Nil(t, err)
+	require.Equal(t, state, callback.Query().Get("state"))
+	return callback.Query().Get("code")
+}
@@ -0,0 +1,237 @@
+// Package oidctest provides a mock OpenID Connect issuer for tests, similar to net/http/httptest.
+// This code is not meant to be used outside of tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	keyID           = "test-key"
+	idTokenLifetime = 5 * time.Minute
+)
+
+// Issuer is a mock OpenID Connect issuer. Its authorization endpoint does not ask for credentials: it issues
+// a code for the claims set via SetClaims right away, and redirects back to the client. The token endpoint
+// checks the client credentials and the PKCE code verifier, and issues an RS256-signed ID token.
+type Issuer struct {
+	URL          string // Issuer URL, e.g. http://127.0.0.1:12345
+	ClientID     string
+	ClientSecret string
+
+	// IDTokenMutator can modify the claims of issued ID tokens, e.g. to test expired tokens or wrong audiences
+	IDTokenMutator func(claims map[string]any)
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+	codes  map[string]*authRequest // Authorization code -> request
+	mu     sync.Mutex
+}
+
+type authRequest struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	claims        map[string]any
+}
+
+// NewIssuer starts a new mock issuer with the given client credentials, which is stopped when the test ends
+func NewIssuer(t *testing.T, clientID, clientSecret string) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	i := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		claims:       map[string]any{"sub": "user-1"},
+		codes:        make(map[string]*authRequest),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", i.handleDiscovery)
+	mux.HandleFunc("GET /keys", i.handleKeys)
+	mux.HandleFunc("GET /authorize", i.handleAuthorize)
+	mux.HandleFunc("POST /token", i.handleToken)
+	i.server = httptest.NewServer(mux)
+	i.URL = i.server.URL
+	t.Cleanup(i.server.Close)
+	return i
+}
+
+// SetClaims sets the claims of the user that logs in next, e.g. "sub", "preferred_username" and "groups"
+func (i *Issuer) SetClaims(claims map[string]any) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.claims = claims
+}
+
+// Authorize simulates the user's browser: it follows the authorization URL returned by the client, and returns
+// the callback URL (including the code and state) that the issuer redirects to
+func (i *Issuer) Authorize(t *testing.T, authURL string) string {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	return resp.Header.Get("Location")
+}
+
+func (i *Issuer) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) handleKeys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]any{
+			{
+				"kty": "RSA",
+				"kid": keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func (i *Issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") != i.ClientID || redirectURI == "" {
+		http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+		return
+	} else if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid response_type or code_challenge", http.StatusBadRequest)
+		return
+	}
+	i.mu.Lock()
+	code := util.RandomString(32)
+	i.codes[code] = &authRequest{
+		redirectURI:   redirectURI,
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		claims:        i.claims,
+	}
+	i.mu.Unlock()
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	callbackQuery := u.Query()
+	callbackQuery.Set("code", code)
+	callbackQuery.Set("state", query.Get("state"))
+	u.RawQuery = callbackQuery.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (i *Issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != i.ClientID || clientSecret != i.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	i.mu.Lock()
+	req, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code")) // Codes are single-use
+	i.mu.Unlock()
+	verifierSum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok || req.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeTokenError(w, "invalid_grant")
+		return
+	} else if base64.RawURLEncoding.EncodeToString(verifierSum[:]) != req.codeChallenge {
+		writeTokenError(w, "invalid_grant")
+		return
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"iss": i.URL,
+		"aud": i.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(idTokenLifetime).Unix(),
+	}
+	if req.nonce != "" {
+		claims["nonce"] = req.nonce
+	}
+	for k, v := range req.claims {
+		claims[k] = v
+	}
+	if i.IDTokenMutator != nil {
+		i.IDTokenMutator(claims)
+	}
+	idToken, err := i.sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": util.RandomString(32),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenLifetime.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (i *Issuer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeTokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
@@ -12,6 +12,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,8 +23,8 @@ const (
 	tagS3Client = "s3_client"
 )
 
-// Client is a minimal S3-compatible client. It supports PutObject, GetObject, DeleteObjects,
-// and ListObjectsV2 operations using AWS Signature V4 signing. The bucket and optional key prefix
+// Client is a minimal S3-compatible client. It supports PutObject, GetObject, HeadObject, DeleteObjects,
+// and ListObjectsV2 operations using AWS Signature V4 signing, as well as presigned GET and PUT URLs. The bucket and optional key prefix
 // are fixed at construction time. All operations target the same bucket and prefix.
 //
 // The following IAM policy is required for AWS S3:
@@ -145,6 +146,77 @@ func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, int6
 	return resp.Body, resp.ContentLength, nil
 }
 
+// GetObjectRange retrieves length bytes of an object, starting at offset, using an HTTP Range request. If length is
+// negative, the object is read until the end. It returns the (partial) body and the total size of the object. The
+// caller must close the returned reader.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObject.html#API_GetObject_RequestSyntax
+func (c *Client) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, int64, error) {
+	if offset == 0 && length < 0 {
+		return c.GetObject(ctx, key)
+	}
+	log.Tag(tagS3Client).Debug("Fetching object %s (offset %d, length %d)", key, offset, length)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.ObjectURL(key), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error creating HTTP GET request for %s: %w", key, err)
+	}
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	c.signV4(req, emptyPayloadHash)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching object %s: %w", key, err)
+	} else if !isHTTPSuccess(resp) {
+		err := parseError(resp)
+		resp.Body.Close()
+		return nil, 0, err
+	} else if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("error fetching object %s: range request not supported, got HTTP %d", key, resp.StatusCode)
+	}
+	size, err := parseContentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("error fetching object %s: %w", key, err)
+	}
+	return resp.Body, size, nil
+}
+
+// HeadObject returns the size of an object, or an error if the object does not exist
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_HeadObject.html
+func (c *Client) HeadObject(ctx context.Context, key string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.config.ObjectURL(key), nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating HTTP HEAD request for %s: %w", key, err)
+	}
+	c.signV4(req, emptyPayloadHash)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching object metadata %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if !isHTTPSuccess(resp) {
+		return 0, parseError(resp)
+	}
+	return resp.ContentLength, nil
+}
+
+// PresignGetObject returns a presigned URL that allows anyone to download the object until it expires, without
+// any credentials. query may contain response header overrides, e.g. response-content-disposition.
+func (c *Client) PresignGetObject(key string, expires time.Duration, query url.Values) string {
+	return c.presignV4(http.MethodGet, key, expires, query, nil, time.Now())
+}
+
+// PresignPutObject returns a presigned URL that allows anyone to upload the object until it expires, without
+// any credentials. The upload must have a Content-Length of exactly size bytes, since the header is signed.
+func (c *Client) PresignPutObject(key string, size int64, expires time.Duration) string {
+	return c.presignV4(http.MethodPut, key, expires, nil, map[string]string{"Content-Length": strconv.FormatInt(size, 10)}, time.Now())
+}
+
 // ListObjectsV2 returns all objects under the client's configured prefix by paginating through
 // ListObjectsV2 results automatically. Keys in the returned objects have the prefix stripped,
 // so they match the keys used with PutObject/GetObject/DeleteObjects. It stops after 10,000
@@ -4,7 +4,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -55,17 +57,77 @@ func (c *Client) signV4(req *http.Request, hash string) {
 	credentialScope := datestamp + "/" + c.config.Region + "/s3/aws4_request"
 	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + credentialScope + "\n" + sha256Hex([]byte(canonicalRequest))
 
-	// Signing key
-	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256(
-		[]byte("AWS4"+c.config.SecretKey), []byte(datestamp)),
-		[]byte(c.config.Region)),
-		[]byte("s3")),
-		[]byte("aws4_request"))
-
-	signature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+	signature := hex.EncodeToString(hmacSHA256(c.signingKey(datestamp), []byte(stringToSign)))
 	header := fmt.Sprintf(
 		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
 		c.config.AccessKey, credentialScope, signedHeadersStr, signature,
 	)
 	req.Header.Set("Authorization", header)
 }
+
+// presignV4 returns a presigned URL for the given method and object key using AWS Signature V4 query
+// parameters, valid for the given duration from now. query holds additional parameters to be signed
+// (e.g. response-content-disposition), and headers holds additional headers that the client must send
+// with exactly these values (e.g. Content-Length). The payload is always unsigned.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (c *Client) presignV4(method, key string, expires time.Duration, query url.Values, headers map[string]string, now time.Time) string {
+	now = now.UTC()
+	datestamp := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	credentialScope := datestamp + "/" + c.config.Region + "/s3/aws4_request"
+
+	// Canonical headers (host, plus the given headers, sorted by lowercase key)
+	canonHeaders := map[string]string{"host": c.config.HostHeader()}
+	for k, v := range headers {
+		canonHeaders[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	signedKeys := make([]string, 0, len(canonHeaders))
+	for k := range canonHeaders {
+		signedKeys = append(signedKeys, k)
+	}
+	sort.Strings(signedKeys)
+	signedHeadersStr := strings.Join(signedKeys, ";")
+	var chBuf strings.Builder
+	for _, k := range signedKeys {
+		chBuf.WriteString(k)
+		chBuf.WriteByte(':')
+		chBuf.WriteString(canonHeaders[k])
+		chBuf.WriteByte('\n')
+	}
+
+	// Query parameters, including the signing parameters (except the signature itself)
+	u, _ := url.Parse(c.config.ObjectURL(key))
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", c.config.AccessKey+"/"+credentialScope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.FormatInt(int64(expires.Seconds()), 10))
+	q.Set("X-Amz-SignedHeaders", signedHeadersStr)
+
+	// Canonical request and string to sign
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalURI(u),
+		canonicalQueryString(q),
+		chBuf.String(),
+		signedHeadersStr,
+		unsignedPayload,
+	}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + credentialScope + "\n" + sha256Hex([]byte(canonicalRequest))
+	q.Set("X-Amz-Signature", hex.EncodeToString(hmacSHA256(c.signingKey(datestamp), []byte(stringToSign))))
+	u.RawQuery = canonicalQueryString(q)
+	return u.String()
+}
+
+// signingKey derives the AWS Signature V4 signing key for the given date (YYYYMMDD)
+func (c *Client) signingKey(datestamp string) []byte {
+	return hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256(
+		[]byte("AWS4"+c.config.SecretKey), []byte(datestamp)),
+		[]byte(c.config.Region)),
+		[]byte("s3")),
+		[]byte("aws4_request"))
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"time"
 
 	"heckel.io/ntfy/v2/log"
@@ -16,16 +17,17 @@ import (
 
 // AbortIncompleteUploads lists all in-progress multipart uploads and aborts those initiated
 // before the given cutoff time. This cleans up orphaned upload parts from interrupted uploads.
+// Uploads with one of the given upload IDs are still in use (e.g. slow resumable uploads), and are kept.
 //
 // See https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListMultipartUploads.html
 // and https://docs.aws.amazon.com/AmazonS3/latest/API/API_AbortMultipartUpload.html
-func (c *Client) AbortIncompleteUploads(ctx context.Context, cutoff time.Time) error {
+func (c *Client) AbortIncompleteUploads(ctx context.Context, cutoff time.Time, keepUploadIDs ...string) error {
 	uploads, err := c.listMultipartUploads(ctx)
 	if err != nil {
 		return err
 	}
 	for _, u := range uploads {
-		if !u.Initiated.IsZero() && u.Initiated.Before(cutoff) {
+		if !u.Initiated.IsZero() && u.Initiated.Before(cutoff) && !slices.Contains(keepUploadIDs, u.UploadID) {
 			c.abortMultipartUpload(ctx, u.Key, u.UploadID)
 		}
 	}
@@ -60,7 +62,7 @@ func (c *Client) listMultipartUploads(ctx context.Context) ([]*multipartUpload,
 				initiated, _ = time.Parse(time.RFC3339, u.Initiated)
 			}
 			all = append(all, &multipartUpload{
-				Key:       u.Key,
+				Key:       c.config.StripPrefix(u.Key),
 				UploadID:  u.UploadID,
 				Initiated: initiated,
 			})
@@ -77,17 +79,63 @@ func (c *Client) listMultipartUploads(ctx context.Context) ([]*multipartUpload,
 // abortMultipartUpload cancels an in-progress multipart upload. Called on error to clean up.
 func (c *Client) abortMultipartUpload(ctx context.Context, key, uploadID string) {
 	log.Tag(tagS3Client).Info("Aborting multipart upload for object %s", key)
+	_ = c.AbortMultipartUpload(ctx, key, uploadID)
+}
+
+// CreateMultipartUpload starts a multipart upload of the given object and returns its upload ID. Parts can
+// then be uploaded one by one with UploadPart (e.g. for resumable uploads), and the upload must be finished
+// with CompleteMultipartUpload or AbortMultipartUpload. Parts of uploads that are never finished are deleted by
+// AbortIncompleteUploads.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_CreateMultipartUpload.html
+func (c *Client) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	log.Tag(tagS3Client).Debug("Creating multipart upload for object %s", key)
+	return c.initiateMultipartUpload(ctx, key)
+}
+
+// UploadPart uploads part partNumber (starting at 1) of a multipart upload, streaming exactly size bytes from
+// body. All parts except the last must be at least MinPartSize bytes. It returns the ETag of the part, which
+// must be passed to CompleteMultipartUpload.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_UploadPart.html
+func (c *Client) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.Reader, size int64) (string, error) {
+	return c.uploadPart(ctx, key, uploadID, partNumber, io.LimitReader(body, size), size)
+}
+
+// CompleteMultipartUpload assembles the object from the parts with the given ETags, in order, which makes
+// the object visible.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_CompleteMultipartUpload.html
+func (c *Client) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	parts := make([]*completedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = &completedPart{
+			PartNumber: i + 1,
+			ETag:       etag,
+		}
+	}
+	return c.completeMultipartUpload(ctx, key, uploadID, parts)
+}
+
+// AbortMultipartUpload cancels a multipart upload, and deletes all of its parts.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_AbortMultipartUpload.html
+func (c *Client) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
 	reqURL := fmt.Sprintf("%s?uploadId=%s", c.config.ObjectURL(key), url.QueryEscape(uploadID))
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, reqURL, nil)
 	if err != nil {
-		return
+		return fmt.Errorf("error creating abort multipart upload request for object %s: %w", key, err)
 	}
 	c.signV4(req, emptyPayloadHash)
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return
+		return fmt.Errorf("error aborting multipart upload for object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if !isHTTPSuccess(resp) && resp.StatusCode != http.StatusNotFound {
+		return parseError(resp)
 	}
-	resp.Body.Close()
+	return nil
 }
 
 // putObjectMultipart uploads body using S3 multipart upload. It reads the body in partSize
@@ -109,7 +157,7 @@ func (c *Client) putObjectMultipart(ctx context.Context, key string, body io.Rea
 	for {
 		n, err := io.ReadFull(body, buf)
 		if n > 0 {
-			etag, uploadErr := c.uploadPart(ctx, key, uploadID, partNumber, buf[:n])
+			etag, uploadErr := c.uploadPart(ctx, key, uploadID, partNumber, bytes.NewReader(buf[:n]), int64(n))
 			if uploadErr != nil {
 				c.abortMultipartUpload(ctx, key, uploadID)
 				return uploadErr
@@ -146,14 +194,14 @@ func (c *Client) initiateMultipartUpload(ctx context.Context, key string) (strin
 }
 
 // uploadPart uploads a single part of a multipart upload and returns the ETag.
-func (c *Client) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
-	log.Tag(tagS3Client).Debug("Uploading multipart part for object %s, part %d, size %d", key, partNumber, len(data))
+func (c *Client) uploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.Reader, size int64) (string, error) {
+	log.Tag(tagS3Client).Debug("Uploading multipart part for object %s, part %d, size %d", key, partNumber, size)
 	reqURL := fmt.Sprintf("%s?partNumber=%d&uploadId=%s", c.config.ObjectURL(key), partNumber, url.QueryEscape(uploadID))
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, body)
 	if err != nil {
 		return "", fmt.Errorf("error creating multipart upload part request for object %s: %w", key, err)
 	}
-	req.ContentLength = int64(len(data))
+	req.ContentLength = size
 	c.signV4(req, unsignedPayload)
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
@@ -201,6 +203,52 @@ func TestClient_PutGetObject(t *testing.T) {
 	require.Equal(t, "hello world", string(data))
 }
 
+func TestClient_GetObjectRange(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	require.Nil(t, client.PutObject(ctx, "range-key", strings.NewReader("hello world"), 0))
+
+	reader, size, err := client.GetObjectRange(ctx, "range-key", 6, 3)
+	require.Nil(t, err)
+	require.Equal(t, int64(11), size)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	require.Equal(t, "wor", string(data))
+
+	reader, size, err = client.GetObjectRange(ctx, "range-key", 6, -1)
+	require.Nil(t, err)
+	require.Equal(t, int64(11), size)
+	data, err = io.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	require.Equal(t, "world", string(data))
+}
+
+func TestClient_GetObjectRange_Header(t *testing.T) {
+	var rangeHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		require.Contains(t, r.Header.Get("Authorization"), "range")
+		w.Header().Set("Content-Range", "bytes 2-4/11")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("llo"))
+	}))
+	defer server.Close()
+	cfg, err := ParseURL("s3://AKID:SECRET@my-bucket?region=us-east-1&endpoint=" + server.URL)
+	require.Nil(t, err)
+	client := New(cfg)
+
+	reader, size, err := client.GetObjectRange(context.Background(), "key", 2, 3)
+	require.Nil(t, err)
+	defer reader.Close()
+	require.Equal(t, "bytes=2-4", rangeHeader)
+	require.Equal(t, int64(11), size)
+	data, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.Equal(t, "llo", string(data))
+}
+
 func TestClient_GetObject_NotFound(t *testing.T) {
 	client := newTestClient(t)
 
@@ -402,6 +450,49 @@ func TestClient_PutObject_NestedKey(t *testing.T) {
 	require.Equal(t, "nested", string(data))
 }
 
+func TestClient_MultipartUpload(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	// Upload two parts separately, e.g. as part of a resumable upload
+	part1 := bytes.Repeat([]byte("a"), MinPartSize)
+	uploadID, err := client.CreateMultipartUpload(ctx, "resumable")
+	require.Nil(t, err)
+	etag1, err := client.UploadPart(ctx, "resumable", uploadID, 1, bytes.NewReader(part1), int64(len(part1)))
+	require.Nil(t, err)
+	etag2, err := client.UploadPart(ctx, "resumable", uploadID, 2, strings.NewReader("the end"), 7)
+	require.Nil(t, err)
+
+	// Object is not visible before the upload is completed
+	_, err = client.HeadObject(ctx, "resumable")
+	require.Error(t, err)
+
+	require.Nil(t, client.CompleteMultipartUpload(ctx, "resumable", uploadID, []string{etag1, etag2}))
+	size, err := client.HeadObject(ctx, "resumable")
+	require.Nil(t, err)
+	require.Equal(t, int64(MinPartSize+7), size)
+}
+
+func TestClient_AbortIncompleteUploads_Keep(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	uploadID1, err := client.CreateMultipartUpload(ctx, "abandoned")
+	require.Nil(t, err)
+	uploadID2, err := client.CreateMultipartUpload(ctx, "inprogress")
+	require.Nil(t, err)
+
+	// Abort all uploads, except the one that is still in progress
+	require.Nil(t, client.AbortIncompleteUploads(ctx, time.Now().Add(time.Minute), uploadID2))
+	uploads, err := client.listMultipartUploads(ctx)
+	require.Nil(t, err)
+	require.Len(t, uploads, 1)
+	require.Equal(t, "inprogress", uploads[0].Key)
+	require.Equal(t, uploadID2, uploads[0].UploadID)
+	require.NotEqual(t, uploadID1, uploads[0].UploadID)
+	require.Nil(t, client.AbortMultipartUpload(ctx, "inprogress", uploadID2))
+}
+
 func newTestClient(t *testing.T) *Client {
 	t.Helper()
 	s3URL := os.Getenv("NTFY_TEST_S3_URL")
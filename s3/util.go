@@ -40,6 +40,9 @@ const (
 	maxDeleteBatchSize = 1000
 )
 
+// MinPartSize is the minimum size of all parts of a multipart upload except the last (see UploadPart)
+const MinPartSize = partSize
+
 // ParseURL parses an S3 URL of the form:
 //
 //	s3://ACCESS_KEY:SECRET_KEY@BUCKET[/PREFIX]?region=REGION[&endpoint=ENDPOINT][&disable_http2=true]
@@ -178,6 +181,19 @@ func uriEncode(s string) string {
 	return buf.String()
 }
 
+// parseContentRangeSize returns the total size from a Content-Range header, e.g. "bytes 0-99/1234" returns 1234
+func parseContentRangeSize(contentRange string) (int64, error) {
+	i := strings.LastIndex(contentRange, "/")
+	if !strings.HasPrefix(contentRange, "bytes ") || i == -1 {
+		return 0, fmt.Errorf("invalid Content-Range header %q", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range header %q", contentRange)
+	}
+	return size, nil
+}
+
 func isHTTPSuccess(resp *http.Response) bool {
 	return resp.StatusCode/100 == 2
 }
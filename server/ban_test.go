@@ -53,3 +53,41 @@ func TestServer_BanFeed_DisabledByDefault(t *testing.T) {
 	response := request(t, s, "PUT", "/mytopic", "", reject) // guarded callsite, no Record
 	require.Equal(t, 400, response.Code)
 }
+
+// TestServer_BanFeed_InfectedAttachment verifies that infected attachment uploads ban fast with the
+// default weights: each upload weighs 50, so two uploads use up the default threshold of 100 without
+// a ban, and the third one breaches it and bans the prefix.
+func TestServer_BanFeed_InfectedAttachment(t *testing.T) {
+	weights, err := ban.ParseWeights(DefaultBanWeights)
+	require.NoError(t, err)
+	uploadInfected := func(t *testing.T, uploads int) string {
+		banFile := filepath.Join(t.TempDir(), "ban.log")
+		conf := newTestConfig(t, "")
+		conf.BanFile = banFile
+		conf.BanWindow = time.Hour
+		conf.BanThreshold = DefaultBanThreshold
+		conf.BanWeights = weights
+		conf.AttachmentScanURL = newTestScannerServer(t).URL
+		s := newTestServer(t, conf)
+		for i := 0; i < uploads; i++ {
+			response := request(t, s, "PUT", "/mytopic", "this is EICAR", map[string]string{"Filename": "virus.txt"})
+			require.Equal(t, 400, response.Code)
+			require.Equal(t, 40066, toHTTPError(t, response.Body.String()).Code)
+		}
+		s.ban.Close() // Flushes the ban file
+		data, err := os.ReadFile(banFile)
+		if os.IsNotExist(err) {
+			return "" // Nothing was flushed, no ban
+		}
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Two uploads reach the threshold, but do not breach it
+	require.Empty(t, uploadInfected(t, 2))
+
+	// The third upload breaches the threshold, and the prefix is banned exactly once
+	lines := strings.Split(strings.TrimRight(uploadInfected(t, 3), "\n"), "\n")
+	require.Len(t, lines, 1)
+	require.True(t, strings.HasSuffix(lines[0], " 9.9.9.9 9.9.9.9/32 400 40066"))
+}
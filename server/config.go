@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"heckel.io/ntfy/v2/ban"
+	"heckel.io/ntfy/v2/ldap"
+	"heckel.io/ntfy/v2/tracing"
 	"heckel.io/ntfy/v2/user"
 )
 
@@ -41,6 +43,14 @@ var (
 const (
 	DefaultWebPushExpiryWarningDuration = 55 * 24 * time.Hour
 	DefaultWebPushExpiryDuration        = 60 * 24 * time.Hour
+	DefaultWebPushRetryInterval         = 10 * time.Second
+)
+
+// Defines the telephony providers for phone calls and SMS (see TelephonyProvider)
+const (
+	TelephonyProviderTwilio  = "twilio"
+	TelephonyProviderHTTP    = "http"
+	DefaultTelephonyProvider = TelephonyProviderTwilio
 )
 
 // Defines default abuse ban-feed settings (see BanFile, BanWindow, BanThreshold, BanWeights)
@@ -49,10 +59,18 @@ const (
 	DefaultBanThreshold = 100 // Weighted strikes per BanWindow before a prefix is banned
 )
 
-// DefaultBanWeights is the ban-feed's default per-code strike weights: the auth-failure flood bans fast,
-// and everything else defaults to weight 1 (no "*" rule needed; see BanWeights.WeightFor).
+// Defines the defaults for OpenID Connect logins, see Config.AuthOIDCIssuer
+var (
+	DefaultAuthOIDCScopes        = []string{"openid", "profile", "email"}
+	DefaultAuthOIDCUsernameClaim = "preferred_username"
+	DefaultAuthOIDCGroupsClaim   = "groups"
+)
+
+// DefaultBanWeights is the ban-feed's default per-code strike weights: the auth-failure flood and infected
+// attachment uploads ban fast, and everything else defaults to weight 1 (no "*" rule needed; see BanWeights.WeightFor).
 var DefaultBanWeights = []string{
 	banWeight(errHTTPTooManyRequestsLimitAuthFailure, 10), // brute-force auth flood -> ban fast
+	banWeight(errHTTPBadRequestAttachmentRejected, 50),    // infected attachment upload -> ban fast
 }
 
 // banWeight formats a "CODE:WEIGHT" ban-feed default from an ntfy error, so the codes stay in sync with
@@ -66,13 +84,14 @@ func banWeight(err *errHTTP, weight int) string {
 // - total topic limit: max number of topics overall
 // - various attachment limits
 const (
-	DefaultMessageSizeLimit            = 4096 // Bytes; note that FCM/APNS have a limit of ~4 KB for the entire message
-	DefaultTotalTopicLimit             = 15000
-	DefaultAttachmentTotalSizeLimit    = int64(5 * 1024 * 1024 * 1024) // 5 GB
-	DefaultAttachmentFileSizeLimit     = int64(15 * 1024 * 1024)       // 15 MB
-	DefaultAttachmentExpiryDuration    = 3 * time.Hour
-	DefaultAttachmentOrphanGracePeriod = time.Hour // Don't delete orphaned objects younger than this to avoid races with in-flight uploads
-
+	DefaultMessageSizeLimit                = 4096 // Bytes; note that FCM/APNS have a limit of ~4 KB for the entire message
+	DefaultTotalTopicLimit                 = 15000
+	DefaultAttachmentTotalSizeLimit        = int64(5 * 1024 * 1024 * 1024) // 5 GB
+	DefaultAttachmentFileSizeLimit         = int64(15 * 1024 * 1024)       // 15 MB
+	DefaultAttachmentExpiryDuration        = 3 * time.Hour
+	DefaultAttachmentOrphanGracePeriod     = time.Hour // Don't delete orphaned objects younger than this to avoid races with in-flight uploads
+	DefaultAttachmentPresignExpiryDuration = 5 * time.Minute
+	DefaultAttachmentScanTimeout           = time.Minute
 )
 
 // Defines all per-visitor limits
@@ -127,22 +146,59 @@ type Config struct {
 	CacheStartupQueries                  string
 	CacheBatchSize                       int
 	CacheBatchTimeout                    time.Duration
+	EncryptionKeyFile                    string // Key file to encrypt cached messages and attachments at rest with, see encryption package
 	AuthFile                             string
 	AuthStartupQueries                   string
 	AuthDefault                          user.Permission
 	AuthUsers                            []*user.User `hash:"-"`
 	AuthAccess                           map[string][]*user.Grant
 	AuthTokens                           map[string][]*user.Token `hash:"-"`
+	AuthTiers                            []*user.Tier
+	AuthReservations                     map[string][]*user.Reservation
 	AuthBcryptCost                       int
 	AuthStatsQueueWriterInterval         time.Duration
 	AuthAccessCacheEnabled               bool          // Enables the in-memory ACL cache (high volume servers only)
 	AuthAccessCacheReloadInterval        time.Duration // Reload interval for access cache, relevant for ACL writes from CLI
+	AuthOIDCIssuer                       string        // OpenID Connect issuer URL for SSO logins; disabled if empty
+	AuthOIDCClientID                     string
+	AuthOIDCClientSecret                 string `hash:"-"`
+	AuthOIDCScopes                       []string
+	AuthOIDCUsernameClaim                string                   // ID token claim used as username for new users
+	AuthOIDCGroupsClaim                  string                   // ID token claim with the user's groups, used for the mappings below
+	AuthOIDCAdminGroups                  []string                 // Users in any of these groups are admins, all other users have the user role; not synced if empty
+	AuthOIDCTiers                        []*OIDCTierMapping       // Group -> tier, first match wins; not synced if empty
+	AuthOIDCAccess                       map[string][]*user.Grant // Group -> ACL entries
+	AuthProxyHeader                      string                   // Header set by an authenticating proxy with the username (e.g. X-Forwarded-User); trusted only from ProxyTrustedPrefixes
+	AuthProxyCreateUsers                 bool                     // Create users from AuthProxyHeader on first use
+	AuthLDAPURL                          string                   // LDAP server URL for password checks of LDAP users (see user.Config.LDAP); disabled if empty
+	AuthLDAPStartTLS                     bool
+	AuthLDAPBindDN                       string // Service account used to search for users; anonymous bind if empty
+	AuthLDAPBindPassword                 string `hash:"-"`
+	AuthLDAPBaseDN                       string
+	AuthLDAPUserFilter                   string                   // Filter to find a user entry, "%s" is replaced with the username
+	AuthLDAPGroupAttribute               string                   // Attribute of the user entry with the user's groups, used for the mappings below
+	AuthLDAPGroupBaseDN                  string                   // If set, groups directly below this DN can be referred to by name in the mappings below
+	AuthLDAPAdminGroups                  []string                 // Users in any of these groups are admins, all other users have the user role; not synced if empty
+	AuthLDAPAccess                       map[string][]*user.Grant // Group -> ACL entries
+	AuthTwoFactorRequired                []user.Role              // Users with these roles must set up two-factor authentication (TOTP) before they can log in
+	AuditTopic                           string                   // If set, every audit log entry is also published to this topic
 	AttachmentCacheDir                   string
 	AttachmentTotalSizeLimit             int64
 	AttachmentFileSizeLimit              int64
 	AttachmentExpiryDuration             time.Duration
 	AttachmentOrphanGracePeriod          time.Duration
-	TemplateDir                          string // Directory to load named templates from
+	AttachmentURLSecret                  string        `hash:"-"` // Secret to sign attachment URLs with; attachment URLs are not signed if empty
+	AttachmentURLExpiryDuration          time.Duration // Duration after which signed attachment URLs expire; 0 means when the attachment expires
+	AttachmentRequireAuthorization       bool          // Require a signed attachment URL or read access to the topic for attachment downloads
+	AttachmentStripMetadata              bool          // Remove EXIF and other metadata from uploaded JPEG and PNG images
+	AttachmentThumbnails                 bool          // Generate thumbnails for uploaded images, see Attachment.Thumbnail
+	AttachmentRedirect                   bool          // Redirect attachment downloads to a presigned S3 URL instead of proxying them
+	AttachmentPresignedUpload            bool          // Allow uploading attachments directly to S3 via presigned URLs, see /v1/attachments/upload
+	AttachmentPresignExpiryDuration      time.Duration // Duration after which presigned S3 download and upload URLs expire
+	AttachmentResumableUpload            bool          // Allow uploading attachments in chunks via /v1/attachments/upload/<id>, see handleAttachmentUploadChunk
+	AttachmentScanURL                    string        `hash:"-"` // Scan uploaded attachments with clamd (tcp://, unix://) or an HTTP hook (http[s]://), see attachment.NewScanner
+	AttachmentScanTimeout                time.Duration // Max. duration of scanning a single attachment
+	TemplateDir                          string        // Directory to load named templates from
 	KeepaliveInterval                    time.Duration
 	ManagerInterval                      time.Duration
 	ManagerBatchSize                     int
@@ -169,7 +225,15 @@ type Config struct {
 	TwilioVerifyBaseURL                  string
 	TwilioVerifyService                  string
 	TwilioCallFormat                     *template.Template
+	TwilioMessagesBaseURL                string
+	TwilioSMSFormat                      *template.Template
+	TelephonyProvider                    string // "twilio" or "http", see createTelephonyProvider
+	TelephonyHTTPBaseURL                 string
+	TelephonyHTTPToken                   string `hash:"-"`
+	TelephonyHTTPPhoneNumber             string
 	MetricsListenHTTP                    string
+	TracingEndpoint                      string // OTLP collector URL to export traces to; tracing is disabled if empty
+	TracingProtocol                      string // "http" or "grpc", see tracing.New
 	ProfileListenHTTP                    string
 	MessageDelayMin                      time.Duration
 	MessageDelayMax                      time.Duration
@@ -215,6 +279,7 @@ type Config struct {
 	WebPushStartupQueries                string
 	WebPushExpiryDuration                time.Duration
 	WebPushExpiryWarningDuration         time.Duration
+	WebPushRetryInterval                 time.Duration
 	BanFile                              string        // Abuse ban-feed: file that fail2ban tails; empty string disables the feature
 	BanWindow                            time.Duration // Abuse ban-feed: rolling window over which weighted strikes are counted
 	BanThreshold                         int           // Abuse ban-feed: weighted strikes per window before a prefix is banned
@@ -249,11 +314,18 @@ func NewConfig() *Config {
 		AuthStatsQueueWriterInterval:         user.DefaultUserStatsQueueWriterInterval,
 		AuthAccessCacheEnabled:               user.DefaultAccessCacheEnabled,
 		AuthAccessCacheReloadInterval:        user.DefaultAccessCacheReloadInterval,
+		AuthOIDCScopes:                       DefaultAuthOIDCScopes,
+		AuthOIDCUsernameClaim:                DefaultAuthOIDCUsernameClaim,
+		AuthOIDCGroupsClaim:                  DefaultAuthOIDCGroupsClaim,
+		AuthLDAPUserFilter:                   ldap.DefaultUserFilter,
+		AuthLDAPGroupAttribute:               ldap.DefaultGroupAttribute,
 		AttachmentCacheDir:                   "",
 		AttachmentTotalSizeLimit:             DefaultAttachmentTotalSizeLimit,
 		AttachmentFileSizeLimit:              DefaultAttachmentFileSizeLimit,
 		AttachmentExpiryDuration:             DefaultAttachmentExpiryDuration,
 		AttachmentOrphanGracePeriod:          DefaultAttachmentOrphanGracePeriod,
+		AttachmentPresignExpiryDuration:      DefaultAttachmentPresignExpiryDuration,
+		AttachmentScanTimeout:                DefaultAttachmentScanTimeout,
 		TemplateDir:                          DefaultTemplateDir,
 		KeepaliveInterval:                    DefaultKeepaliveInterval,
 		ManagerInterval:                      DefaultManagerInterval,
@@ -281,6 +353,14 @@ func NewConfig() *Config {
 		TwilioVerifyBaseURL:                  "https://verify.twilio.com", // Override for tests
 		TwilioVerifyService:                  "",
 		TwilioCallFormat:                     nil,
+		TwilioMessagesBaseURL:                "https://api.twilio.com", // Override for tests
+		TwilioSMSFormat:                      nil,
+		TelephonyProvider:                    DefaultTelephonyProvider,
+		TelephonyHTTPBaseURL:                 "",
+		TelephonyHTTPToken:                   "",
+		TelephonyHTTPPhoneNumber:             "",
+		TracingEndpoint:                      "",
+		TracingProtocol:                      tracing.DefaultProtocol,
 		MessageSizeLimit:                     DefaultMessageSizeLimit,
 		MessageDelayMin:                      DefaultMessageDelayMin,
 		MessageDelayMax:                      DefaultMessageDelayMax,
@@ -322,6 +402,7 @@ func NewConfig() *Config {
 		WebPushEmailAddress:                  "",
 		WebPushExpiryDuration:                DefaultWebPushExpiryDuration,
 		WebPushExpiryWarningDuration:         DefaultWebPushExpiryWarningDuration,
+		WebPushRetryInterval:                 DefaultWebPushRetryInterval,
 		BanFile:                              "",
 		BanWindow:                            DefaultBanWindow,
 		BanThreshold:                         DefaultBanThreshold,
@@ -332,6 +413,17 @@ func NewConfig() *Config {
 	}
 }
 
+// TelephonyEnabled returns true if the selected telephony provider (for phone calls and SMS) is configured
+func (c *Config) TelephonyEnabled() bool {
+	switch c.TelephonyProvider {
+	case TelephonyProviderTwilio:
+		return c.TwilioAccount != ""
+	case TelephonyProviderHTTP:
+		return c.TelephonyHTTPBaseURL != ""
+	}
+	return false
+}
+
 // Hash computes an SHA-256 hash of the configuration. This is used to detect
 // configuration changes for the web app version check feature. It uses reflection
 // to include all JSON-serializable fields automatically.
@@ -353,3 +445,9 @@ func (c *Config) Hash() string {
 	}
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(result)))
 }
+
+// OIDCTierMapping maps an identity provider group to a tier, see Config.AuthOIDCTiers
+type OIDCTierMapping struct {
+	Group string
+	Tier  string // Tier code
+}
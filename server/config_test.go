@@ -22,6 +22,7 @@ func TestConfig_HashExcludesSecrets(t *testing.T) {
 	conf2.StripeSecretKey = "sk_live_topsecret"
 	conf2.StripeWebhookKey = "whsec_topsecret"
 	conf2.TwilioAuthToken = "twilio-auth-token"
+	conf2.TelephonyHTTPToken = "telephony-token"
 	conf2.UpstreamAccessToken = "tk_upstream"
 	conf2.WebPushPrivateKey = "web-push-private-key"
 	conf2.SMTPSenderPass = "hunter2"
@@ -149,9 +149,31 @@ var (
 	errHTTPBadRequestAnonymousEmailNotAllowed        = &errHTTP{40053, http.StatusBadRequest, "invalid request: anonymous email sending is not allowed", "https://ntfy.sh/docs/publish/#e-mail-notifications", nil}
 	errHTTPBadRequestResetLinkInvalid                = &errHTTP{40054, http.StatusBadRequest, "invalid request: password reset link invalid or expired", "", nil}
 	errHTTPBadRequestTemplateTooLarge                = &errHTTP{40056, http.StatusBadRequest, "invalid request: template too large", "https://ntfy.sh/docs/publish/#message-templating", nil}
+	errHTTPBadRequestSMSDisabled                     = &errHTTP{40057, http.StatusBadRequest, "invalid request: SMS is disabled", "https://ntfy.sh/docs/config/#phone-calls", nil}
+	errHTTPBadRequestDelayNoSMS                      = &errHTTP{40058, http.StatusBadRequest, "invalid request: delayed SMS notifications are not supported", "", nil}
+	errHTTPBadRequestAttachmentUploadInvalid         = &errHTTP{40059, http.StatusBadRequest, "invalid request: attachment upload request invalid", "https://ntfy.sh/docs/config/#presigned-s3-urls", nil}
+	errHTTPBadRequestAttachmentUploadNotFound        = &errHTTP{40060, http.StatusBadRequest, "invalid request: attachment upload unknown, expired, or not completed", "https://ntfy.sh/docs/config/#presigned-s3-urls", nil}
+	errHTTPBadRequestEncodingInvalid                 = &errHTTP{40061, http.StatusBadRequest, "invalid request: unsupported encoding", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestEncryptedMessageInvalid         = &errHTTP{40062, http.StatusBadRequest, "invalid request: encrypted message is not a valid JWE", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestEncryptedMessageNotAllowed      = &errHTTP{40063, http.StatusBadRequest, "invalid request: encrypted messages cannot be combined with templates, attachments, UnifiedPush, e-mail, phone calls or SMS", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestAttachmentUploadOffsetMissing   = &errHTTP{40064, http.StatusBadRequest, "invalid request: Upload-Offset header and non-empty body required", "https://ntfy.sh/docs/config/#resumable-uploads", nil}
+	errHTTPBadRequestAttachmentUploadChunkTooSmall   = &errHTTP{40065, http.StatusBadRequest, "invalid request: upload chunk smaller than the minimum chunk size", "https://ntfy.sh/docs/config/#resumable-uploads", nil}
+	errHTTPBadRequestAttachmentRejected              = &errHTTP{40066, http.StatusBadRequest, "invalid request: attachment rejected by virus scanner", "https://ntfy.sh/docs/config/#attachment-scanning", nil}
+	errHTTPBadRequestOIDCLoginInvalid                = &errHTTP{40067, http.StatusBadRequest, "invalid request: login expired or was started in another browser, please try again", "https://ntfy.sh/docs/config/#openid-connect-sso", nil}
+	errHTTPBadRequestOIDCUsernameInvalid             = &errHTTP{40068, http.StatusBadRequest, "invalid request: username claim from identity provider is missing or not a valid username", "https://ntfy.sh/docs/config/#openid-connect-sso", nil}
+	errHTTPBadRequestTwoFactorCodeInvalid            = &errHTTP{40069, http.StatusBadRequest, "invalid request: two-factor authentication code is not correct", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPBadRequestTwoFactorNotSetUp               = &errHTTP{40070, http.StatusBadRequest, "invalid request: two-factor authentication setup was not started", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPBadRequestGroupNotFound                   = &errHTTP{40071, http.StatusBadRequest, "invalid request: group does not exist", "https://ntfy.sh/docs/config/#groups", nil}
+	errHTTPBadRequestReservationNotFound             = &errHTTP{40072, http.StatusBadRequest, "invalid request: topic reservation does not exist", "", nil}
+	errHTTPBadRequestWebPushFilterInvalid            = &errHTTP{40073, http.StatusBadRequest, "invalid request: web push filter invalid, or topic of filter not in topic list", "", nil}
 	errHTTPNotFound                                  = &errHTTP{40401, http.StatusNotFound, "page not found", "", nil}
 	errHTTPUnauthorized                              = &errHTTP{40101, http.StatusUnauthorized, "unauthorized", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPUnauthorizedTwoFactorRequired             = &errHTTP{40102, http.StatusUnauthorized, "unauthorized: two-factor authentication code required, log in with the code and use the access token", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPUnauthorizedTwoFactorInvalid              = &errHTTP{40103, http.StatusUnauthorized, "unauthorized: two-factor authentication code is not correct", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
 	errHTTPForbidden                                 = &errHTTP{40301, http.StatusForbidden, "forbidden", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPForbiddenAttachmentURLInvalid             = &errHTTP{40302, http.StatusForbidden, "forbidden: attachment URL signature invalid or expired", "https://ntfy.sh/docs/config/#access-controlled-attachments", nil}
+	errHTTPForbiddenTwoFactorSetupRequired           = &errHTTP{40303, http.StatusForbidden, "forbidden: two-factor authentication must be set up before the password can be used", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPForbiddenTwoFactorRequired                = &errHTTP{40304, http.StatusForbidden, "forbidden: two-factor authentication is required for this account and cannot be disabled", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
 	errHTTPConflictUserExists                        = &errHTTP{40901, http.StatusConflict, "conflict: user already exists", "", nil}
 	errHTTPConflictTopicReserved                     = &errHTTP{40902, http.StatusConflict, "conflict: access control entry for topic or topic pattern already exists", "", nil}
 	errHTTPConflictSubscriptionExists                = &errHTTP{40903, http.StatusConflict, "conflict: topic subscription already exists", "", nil}
@@ -160,10 +182,20 @@ var (
 	errHTTPConflictProvisionedTokenChange            = &errHTTP{40906, http.StatusConflict, "conflict: cannot change or delete provisioned token", "", nil}
 	errHTTPConflictEmailExists                       = &errHTTP{40907, http.StatusConflict, "conflict: email address already exists", "", nil}
 	errHTTPConflictEmailPrimaryElsewhere             = &errHTTP{40908, http.StatusConflict, "conflict: email address is the primary email on another account", "", nil}
+	errHTTPConflictAttachmentUploadOffset            = &errHTTP{40909, http.StatusConflict, "conflict: upload offset does not match the current offset of the upload", "https://ntfy.sh/docs/config/#resumable-uploads", nil}
+	errHTTPConflictOIDCUserExists                    = &errHTTP{40910, http.StatusConflict, "conflict: a user with this username already exists, but is not linked to the identity provider", "https://ntfy.sh/docs/config/#openid-connect-sso", nil}
+	errHTTPConflictTwoFactorEnabled                  = &errHTTP{40911, http.StatusConflict, "conflict: two-factor authentication is already enabled", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPConflictGroupExists                       = &errHTTP{40912, http.StatusConflict, "conflict: group already exists", "https://ntfy.sh/docs/config/#groups", nil}
+	errHTTPConflictTierExists                        = &errHTTP{40913, http.StatusConflict, "conflict: tier already exists", "https://ntfy.sh/docs/config/#tiers", nil}
+	errHTTPConflictTierInUse                         = &errHTTP{40914, http.StatusConflict, "conflict: tier is still assigned to users", "https://ntfy.sh/docs/config/#tiers", nil}
+	errHTTPConflictProvisionedTierChange             = &errHTTP{40915, http.StatusConflict, "conflict: cannot change or delete provisioned tier", "https://ntfy.sh/docs/config/#tiers-via-the-config", nil}
+	errHTTPConflictProvisionedReservationChange      = &errHTTP{40916, http.StatusConflict, "conflict: cannot change or delete provisioned reservation", "https://ntfy.sh/docs/config/#reservations-via-the-config", nil}
 	errHTTPGonePhoneVerificationExpired              = &errHTTP{41001, http.StatusGone, "phone number verification expired or does not exist", "", nil}
 	errHTTPEntityTooLargeAttachment                  = &errHTTP{41301, http.StatusRequestEntityTooLarge, "attachment too large, or bandwidth limit reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPEntityTooLargeMatrixRequest               = &errHTTP{41302, http.StatusRequestEntityTooLarge, "Matrix request is larger than the max allowed length", "", nil}
 	errHTTPEntityTooLargeJSONBody                    = &errHTTP{41303, http.StatusRequestEntityTooLarge, "JSON body too large", "", nil}
+	errHTTPEntityTooLargeEncryptedMessage            = &errHTTP{41304, http.StatusRequestEntityTooLarge, "encrypted message too large", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPRequestedRangeNotSatisfiable              = &errHTTP{41601, http.StatusRequestedRangeNotSatisfiable, "requested range not satisfiable", "", nil}
 	errHTTPTooManyRequestsLimitRequests              = &errHTTP{42901, http.StatusTooManyRequests, "limit reached: too many requests", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitEmails                = &errHTTP{42902, http.StatusTooManyRequests, "limit reached: too many emails", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitSubscriptions         = &errHTTP{42903, http.StatusTooManyRequests, "limit reached: too many active subscriptions", "https://ntfy.sh/docs/publish/#limitations", nil}
@@ -175,6 +207,7 @@ var (
 	errHTTPTooManyRequestsLimitAuthFailure           = &errHTTP{42909, http.StatusTooManyRequests, "limit reached: too many auth failures", "https://ntfy.sh/docs/publish/#limitations", nil} // FIXME document limit
 	errHTTPTooManyRequestsLimitCalls                 = &errHTTP{42910, http.StatusTooManyRequests, "limit reached: daily phone call quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitTopicCreation         = &errHTTP{42911, http.StatusTooManyRequests, "limit reached: too many new topics, please wait", "https://ntfy.sh/docs/publish/#limitations", nil}
+	errHTTPTooManyRequestsLimitSMS                   = &errHTTP{42912, http.StatusTooManyRequests, "limit reached: daily SMS quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPInternalError                             = &errHTTP{50001, http.StatusInternalServerError, "internal server error", "", nil}
 	errHTTPInternalErrorInvalidPath                  = &errHTTP{50002, http.StatusInternalServerError, "internal server error: invalid path", "", nil}
 	errHTTPInternalErrorMissingBaseURL               = &errHTTP{50003, http.StatusInternalServerError, "internal server error: base-url must be be configured for this feature", "https://ntfy.sh/docs/config/", nil}
@@ -16,21 +16,25 @@ import (
 
 // Log tags
 const (
-	tagStartup   = "startup"
-	tagHTTP      = "http"
-	tagPublish   = "publish"
-	tagSubscribe = "subscribe"
-	tagFirebase  = "firebase"
-	tagSMTP      = "smtp"  // Receive email
-	tagEmail     = "email" // Send email
-	tagTwilio    = "twilio"
-	tagStripe    = "stripe"
-	tagAccount   = "account"
-	tagManager   = "manager"
-	tagResetter  = "resetter"
-	tagWebsocket = "websocket"
-	tagMatrix    = "matrix"
-	tagWebPush   = "webpush"
+	tagStartup    = "startup"
+	tagHTTP       = "http"
+	tagPublish    = "publish"
+	tagSubscribe  = "subscribe"
+	tagFirebase   = "firebase"
+	tagSMTP       = "smtp"  // Receive email
+	tagEmail      = "email" // Send email
+	tagTwilio     = "twilio"
+	tagStripe     = "stripe"
+	tagAccount    = "account"
+	tagManager    = "manager"
+	tagResetter   = "resetter"
+	tagWebsocket  = "websocket"
+	tagMatrix     = "matrix"
+	tagWebPush    = "webpush"
+	tagTracing    = "tracing"
+	tagAttachment = "attachment"
+	tagOIDC       = "oidc"
+	tagAudit      = "audit"
 )
 
 var (
@@ -34,13 +34,17 @@ import (
 	"heckel.io/ntfy/v2/ban"
 	"heckel.io/ntfy/v2/db"
 	"heckel.io/ntfy/v2/db/pg"
+	"heckel.io/ntfy/v2/encryption"
+	"heckel.io/ntfy/v2/ldap"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/mail"
 	"heckel.io/ntfy/v2/message"
 	"heckel.io/ntfy/v2/metrics"
 	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/oidc"
 	"heckel.io/ntfy/v2/payments"
-	"heckel.io/ntfy/v2/twilio"
+	"heckel.io/ntfy/v2/telephony"
+	"heckel.io/ntfy/v2/tracing"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"heckel.io/ntfy/v2/webpush"
@@ -62,7 +66,11 @@ type Server struct {
 	visitors          map[string]*visitor // ip:<ip> or user:<user>
 	ban               *ban.Service        // Abuse ban-feed; nil when the feature is disabled (no ban file)
 	firebaseClient    *firebaseClient
-	twilio            *twilio.Client
+	telephony         telephony.Provider                  // Phone calls and SMS; nil if not configured
+	tracer            *tracing.Tracer                     // OpenTelemetry tracing; a no-op tracer if not configured
+	attachmentUploads map[string]*attachmentUpload        // Pending presigned or resumable attachment uploads (message ID -> upload), see handleAttachmentUploadCreate
+	oidc              *oidc.Provider                      // OpenID Connect identity provider for logins; nil if not configured
+	oidcLogins        map[string]*oidcLogin               // Pending OpenID Connect logins (state -> login), see handleAccountOIDCLogin
 	messages          int64                               // Total number of messages (persisted if messageCache enabled)
 	messagesHistory   []int64                             // Last n values of the messages counter, used to determine rate
 	userManager       *user.Manager                       // Might be nil!
@@ -96,6 +104,7 @@ var (
 	sequenceIDRegex        = topicRegex
 
 	webAppConfigPath              = "/config.js"
+	webAppLoginPath               = "/login"
 	webAppManifestPath            = "/manifest.webmanifest"
 	webAppEmailVerifyPathPrefix   = "/account/email/verify/"                                       // Browser landing route; raw token appended
 	webAppEmailVerifyRegex        = regexp.MustCompile(`^/account/email/verify/[-_A-Za-z0-9]+$`)   // Magic-link landing (served by the web app)
@@ -111,12 +120,24 @@ var (
 	apiStatsPath                                         = "/v1/stats"
 	apiWebPushPath                                       = "/v1/webpush"
 	apiTiersPath                                         = "/v1/tiers"
+	apiAttachmentsUploadPath                             = "/v1/attachments/upload"
 	apiUsersPath                                         = "/v1/users"
 	apiUsersAccessPath                                   = "/v1/users/access"
+	apiUsersGroupsPath                                   = "/v1/users/groups"
+	apiUsersGroupsMembersPath                            = "/v1/users/groups/members"
+	apiUsersGroupsReservationsPath                       = "/v1/users/groups/reservations"
+	apiUsersTiersPath                                    = "/v1/users/tiers"
+	apiUsersTokensPath                                   = "/v1/users/tokens"
+	apiUsersReservationsPath                             = "/v1/users/reservations"
+	apiUsersPhonePath                                    = "/v1/users/phone"
+	apiUsersEmailPath                                    = "/v1/users/email"
+	apiUsersWebPushPath                                  = "/v1/users/webpush"
+	apiAuditPath                                         = "/v1/audit"
 	apiAccountPath                                       = "/v1/account"
 	apiAccountLoginPath                                  = "/v1/account/login"
 	apiAccountTokenPath                                  = "/v1/account/token"
 	apiAccountPasswordPath                               = "/v1/account/password"
+	apiAccount2FAPath                                    = "/v1/account/2fa"
 	apiAccountSettingsPath                               = "/v1/account/settings"
 	apiAccountSubscriptionPath                           = "/v1/account/subscription"
 	apiAccountReservationPath                            = "/v1/account/reservation"
@@ -128,15 +149,22 @@ var (
 	apiAccountEmailResendPath                            = "/v1/account/email/resend"
 	apiAccountPasswordResetRequestPath                   = "/v1/account/password/reset/request"
 	apiAccountPasswordResetPath                          = "/v1/account/password/reset"
+	apiAccountOIDCLoginPath                              = "/v1/account/oidc/login"
+	apiAccountOIDCCallbackPath                           = "/v1/account/oidc/callback"
 	apiAccountBillingPortalPath                          = "/v1/account/billing/portal"
 	apiAccountBillingWebhookPath                         = "/v1/account/billing/webhook"
+	apiTwilioSMSStatusPath                               = "/v1/twilio/sms/status"
+	apiTwilioCallAckPath                                 = "/v1/twilio/call/ack"
 	apiAccountBillingSubscriptionPath                    = "/v1/account/billing/subscription"
 	apiAccountBillingSubscriptionCheckoutSuccessTemplate = "/v1/account/billing/subscription/success/{CHECKOUT_SESSION_ID}"
 	apiAccountBillingSubscriptionCheckoutSuccessRegex    = regexp.MustCompile(`/v1/account/billing/subscription/success/(.+)$`)
 	apiAccountReservationSingleRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})$`)
+	apiAttachmentsUploadSingleRegex                      = regexp.MustCompile(`^/v1/attachments/upload/([-_A-Za-z0-9]{1,64})$`)
 	staticRegex                                          = regexp.MustCompile(`^/(static/.+|app.html|sw.js|sw.js.map)$`)
 	docsRegex                                            = regexp.MustCompile(`^/docs(|/.*)$`)
 	fileRegex                                            = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})(?:\.[A-Za-z0-9]{1,16})?$`)
+	fileThumbnailRegex                                   = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})/thumbnail\.(jpg|png)$`)
+	fileExtRegex                                         = regexp.MustCompile(`^\.[A-Za-z0-9]{1,16}$`) // Must match the extension in fileRegex
 	urlRegex                                             = regexp.MustCompile(`^https?://`)
 	phoneNumberRegex                                     = regexp.MustCompile(`^\+\d{1,100}$`)
 	emailAddressRegex                                    = regexp.MustCompile(`^[^\s,;]+@[^\s,;]+$`)
@@ -159,10 +187,12 @@ const (
 	newMessageBody           = "New message"             // Used in poll requests as generic message
 	defaultAttachmentMessage = "You received a file: %s" // Used if message body is empty, and there is an attachment
 	encodingBase64           = "base64"                  // Used mainly for binary UnifiedPush messages
+	encodingJWE              = "jwe"                     // End-to-end encrypted messages, opaque to the server
 	jsonBodyBytesLimit       = 131072                    // Max number of bytes for a request bodys (unless MessageLimit is higher)
 	unifiedPushTopicPrefix   = "up"                      // Temporarily, we rate limit all "up*" topics based on the subscriber
 	unifiedPushTopicLength   = 14                        // Length of UnifiedPush topics, including the "up" part
 	messagesHistoryMax       = 10                        // Number of message count values to keep in memory
+	tracerShutdownTimeout    = 5 * time.Second           // Max time to wait for pending spans to be exported on shutdown
 )
 
 // WebSocket constants
@@ -212,7 +242,14 @@ func New(conf *Config) (*Server, error) {
 		}
 		pool = db.New(primary, replicas)
 	}
-	messageCache, err := createMessageCache(conf, pool)
+	var keyring *encryption.Keyring
+	if conf.EncryptionKeyFile != "" {
+		var err error
+		if keyring, err = encryption.LoadKeyring(conf.EncryptionKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load encryption key file: %w", err)
+		}
+	}
+	messageCache, err := createMessageCache(conf, pool, keyring)
 	if err != nil {
 		return nil, err
 	}
@@ -239,20 +276,29 @@ func New(conf *Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	attachmentStore, err := createAttachmentStore(conf, messageCache)
+	attachmentStore, err := createAttachmentStore(conf, messageCache, keyring)
+	if err != nil {
+		return nil, err
+	}
+	if attachmentStore != nil && conf.AttachmentScanURL != "" {
+		scanner, err := attachment.NewScanner(conf.AttachmentScanURL, conf.AttachmentScanTimeout)
+		if err != nil {
+			return nil, err
+		}
+		attachmentStore.SetScanner(scanner)
+	}
+	telephonyProvider, err := createTelephonyProvider(conf)
 	if err != nil {
 		return nil, err
 	}
-	twilioClient := twilio.NewClient(&twilio.Config{
-		Account:       conf.TwilioAccount,
-		AuthToken:     conf.TwilioAuthToken,
-		PhoneNumber:   conf.TwilioPhoneNumber,
-		CallsBaseURL:  conf.TwilioCallsBaseURL,
-		VerifyBaseURL: conf.TwilioVerifyBaseURL,
-		VerifyService: conf.TwilioVerifyService,
-		CallFormat:    conf.TwilioCallFormat,
-		BuildVersion:  conf.BuildVersion,
+	tracer, err := tracing.New(&tracing.Config{
+		Endpoint:       conf.TracingEndpoint,
+		Protocol:       conf.TracingProtocol,
+		ServiceVersion: conf.BuildVersion,
 	})
+	if err != nil {
+		return nil, err
+	}
 	var userManager *user.Manager
 	if conf.AuthFile != "" || pool != nil {
 		authConfig := &user.Config{
@@ -264,10 +310,26 @@ func New(conf *Config) (*Server, error) {
 			Users:                     conf.AuthUsers,
 			Access:                    conf.AuthAccess,
 			Tokens:                    conf.AuthTokens,
+			Tiers:                     conf.AuthTiers,
+			Reservations:              conf.AuthReservations,
 			BcryptCost:                conf.AuthBcryptCost,
 			QueueWriterInterval:       conf.AuthStatsQueueWriterInterval,
 			AccessCacheEnabled:        conf.AuthAccessCacheEnabled,
 			AccessCacheReloadInterval: conf.AuthAccessCacheReloadInterval,
+			LDAPAdminGroups:           conf.AuthLDAPAdminGroups,
+			LDAPAccess:                conf.AuthLDAPAccess,
+		}
+		if conf.AuthLDAPURL != "" {
+			authConfig.LDAP = ldap.New(&ldap.Config{
+				URL:            conf.AuthLDAPURL,
+				StartTLS:       conf.AuthLDAPStartTLS,
+				BindDN:         conf.AuthLDAPBindDN,
+				BindPassword:   conf.AuthLDAPBindPassword,
+				BaseDN:         conf.AuthLDAPBaseDN,
+				UserFilter:     conf.AuthLDAPUserFilter,
+				GroupAttribute: conf.AuthLDAPGroupAttribute,
+				GroupBaseDN:    conf.AuthLDAPGroupBaseDN,
+			})
 		}
 		if pool != nil {
 			userManager, err = user.NewPostgresManager(pool, authConfig)
@@ -292,6 +354,16 @@ func New(conf *Config) (*Server, error) {
 		}
 		firebaseClient = newFirebaseClient(sender, auther)
 	}
+	var oidcProvider *oidc.Provider
+	if userManager != nil && conf.AuthOIDCIssuer != "" {
+		oidcProvider = oidc.New(&oidc.Config{
+			Issuer:       conf.AuthOIDCIssuer,
+			ClientID:     conf.AuthOIDCClientID,
+			ClientSecret: conf.AuthOIDCClientSecret,
+			RedirectURL:  strings.TrimSuffix(conf.BaseURL, "/") + apiAccountOIDCCallbackPath,
+			Scopes:       conf.AuthOIDCScopes,
+		})
+	}
 	var banner *ban.Service
 	if conf.BanFile != "" {
 		banner = ban.NewService(&ban.Config{
@@ -304,42 +376,44 @@ func New(conf *Config) (*Server, error) {
 		})
 	}
 	s := &Server{
-		config:          conf,
-		db:              pool,
-		messageCache:    messageCache,
-		webPush:         wp,
-		attachment:      attachmentStore,
-		firebaseClient:  firebaseClient,
-		twilio:          twilioClient,
-		mailer:          sender,
-		ban:             banner,
-		topics:          topics,
-		userManager:     userManager,
-		messages:        messages,
-		messagesHistory: []int64{messages},
-		visitors:        make(map[string]*visitor),
-		stripe:          stripe,
+		config:            conf,
+		db:                pool,
+		messageCache:      messageCache,
+		webPush:           wp,
+		attachment:        attachmentStore,
+		firebaseClient:    firebaseClient,
+		telephony:         telephonyProvider,
+		tracer:            tracer,
+		mailer:            sender,
+		ban:               banner,
+		topics:            topics,
+		userManager:       userManager,
+		messages:          messages,
+		messagesHistory:   []int64{messages},
+		visitors:          make(map[string]*visitor),
+		attachmentUploads: make(map[string]*attachmentUpload),
+		oidc:              oidcProvider,
+		oidcLogins:        make(map[string]*oidcLogin),
+		stripe:            stripe,
 	}
 	s.priceCache = util.NewLookupCache(s.fetchStripePrices, conf.StripePriceCacheDuration)
 	return s, nil
 }
 
-func createMessageCache(conf *Config, pool *db.DB) (*message.Cache, error) {
+func createMessageCache(conf *Config, pool *db.DB, keyring *encryption.Keyring) (*message.Cache, error) {
 	if conf.CacheDuration == 0 {
 		return message.NewNopStore()
 	} else if pool != nil {
-		return message.NewPostgresStore(pool, conf.CacheBatchSize, conf.CacheBatchTimeout)
+		return message.NewPostgresStore(pool, conf.CacheBatchSize, conf.CacheBatchTimeout, keyring)
 	} else if conf.CacheFile != "" {
-		return message.NewSQLiteStore(conf.CacheFile, conf.CacheStartupQueries, conf.CacheDuration, conf.CacheBatchSize, conf.CacheBatchTimeout, false)
+		return message.NewSQLiteStore(conf.CacheFile, conf.CacheStartupQueries, conf.CacheDuration, conf.CacheBatchSize, conf.CacheBatchTimeout, keyring, false)
 	}
 	return message.NewMemStore()
 }
 
-func createAttachmentStore(conf *Config, messageCache *message.Cache) (*attachment.Store, error) {
-	if strings.HasPrefix(conf.AttachmentCacheDir, "s3://") {
-		return attachment.NewS3Store(conf.AttachmentCacheDir, conf.AttachmentTotalSizeLimit, conf.AttachmentOrphanGracePeriod, messageCache.AttachmentsWithSizes)
-	} else if conf.AttachmentCacheDir != "" {
-		return attachment.NewFileStore(conf.AttachmentCacheDir, conf.AttachmentTotalSizeLimit, conf.AttachmentOrphanGracePeriod, messageCache.AttachmentsWithSizes)
+func createAttachmentStore(conf *Config, messageCache *message.Cache, keyring *encryption.Keyring) (*attachment.Store, error) {
+	if conf.AttachmentCacheDir != "" {
+		return attachment.NewStore(conf.AttachmentCacheDir, conf.AttachmentTotalSizeLimit, conf.AttachmentOrphanGracePeriod, keyring, messageCache)
 	}
 	return nil, nil
 }
@@ -442,6 +516,7 @@ func (s *Server) Run() error {
 	go s.runStatsResetter()
 	go s.runDelayedSender()
 	go s.runFirebaseKeepaliver()
+	go s.runWebPushRetrier()
 
 	return <-errChan
 }
@@ -469,6 +544,7 @@ func (s *Server) Stop() {
 	if s.ban != nil {
 		s.ban.Close()
 	}
+	s.shutdownTracer()
 	if s.closeChan != nil {
 		close(s.closeChan)
 	}
@@ -489,10 +565,25 @@ func (s *Server) closeDatabases() {
 	}
 }
 
+// shutdownTracer flushes pending spans to the tracing collector, waiting at most tracerShutdownTimeout
+func (s *Server) shutdownTracer() {
+	ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+	defer cancel()
+	if err := s.tracer.Shutdown(ctx); err != nil {
+		log.Tag(tagTracing).Err(err).Warn("Unable to flush traces")
+	}
+}
+
 // handle is the main entry point for all HTTP requests
 func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	r, span := s.tracer.StartHTTP(r) // Continues the caller's trace, if a traceparent header is present
+	defer span.End()
 	r, v, err := s.maybeAuthenticate(r) // Note: Always returns v (and r, with the client IP in its context), even on error
+	if err != nil && s.signedAttachmentRequest(r) {
+		err = nil // The signature alone authorizes the download, continue as anonymous visitor (see authorizeAttachmentRead)
+	}
 	if err != nil {
+		tracing.SetError(span, err)
 		s.handleError(w, r, v, err)
 		return
 	}
@@ -505,6 +596,7 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 	logvr(v, r).
 		Timing(func() {
 			if err := s.handleInternal(w, r, v); err != nil {
+				tracing.SetError(span, err)
 				s.handleError(w, r, v, err)
 				return
 			}
@@ -587,6 +679,58 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureAdmin(s.handleAccessAllow)(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersAccessPath {
 		return s.ensureAdmin(s.handleAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersGroupsPath {
+		return s.ensureAdmin(s.handleGroupsGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiUsersGroupsPath {
+		return s.ensureAdmin(s.handleGroupsAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersGroupsPath {
+		return s.ensureAdmin(s.handleGroupsDelete)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiUsersGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupMemberAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupMemberRemove)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiUsersGroupsReservationsPath {
+		return s.ensureAdmin(s.handleGroupReservationAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersGroupsReservationsPath {
+		return s.ensureAdmin(s.handleGroupReservationDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersTiersPath {
+		return s.ensureAdmin(s.handleTiersGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiUsersTiersPath {
+		return s.ensureAdmin(s.handleTiersAdd)(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiUsersTiersPath {
+		return s.ensureAdmin(s.handleTiersUpdate)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersTiersPath {
+		return s.ensureAdmin(s.handleTiersDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersTokensPath {
+		return s.ensureAdmin(s.handleUserTokensGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiUsersTokensPath {
+		return s.ensureAdmin(s.handleUserTokenCreate)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersTokensPath {
+		return s.ensureAdmin(s.handleUserTokenDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersReservationsPath {
+		return s.ensureAdmin(s.handleUserReservationsGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiUsersReservationsPath {
+		return s.ensureAdmin(s.handleUserReservationAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersReservationsPath {
+		return s.ensureAdmin(s.handleUserReservationDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersPhonePath {
+		return s.ensureAdmin(s.handleUserPhoneNumbersGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiUsersPhonePath {
+		return s.ensureAdmin(s.handleUserPhoneNumberAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersPhonePath {
+		return s.ensureAdmin(s.handleUserPhoneNumberDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersEmailPath {
+		return s.ensureAdmin(s.handleUserEmailsGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiUsersEmailPath {
+		return s.ensureAdmin(s.handleUserEmailAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersEmailPath {
+		return s.ensureAdmin(s.handleUserEmailDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersWebPushPath {
+		return s.ensureWebPushEnabled(s.ensureAdmin(s.handleUserWebPushGet))(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersWebPushPath {
+		return s.ensureWebPushEnabled(s.ensureAdmin(s.handleUserWebPushDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAuditPath {
+		return s.ensureAdmin(s.handleAuditGet)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPath {
 		return s.ensureUserManager(s.handleAccountCreate)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountPath {
@@ -595,8 +739,18 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountDelete))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPasswordPath {
 		return s.ensureUser(s.handleAccountPasswordChange)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccount2FAPath {
+		return s.ensureUser(s.handleAccount2FASetup)(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiAccount2FAPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccount2FAEnable))(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccount2FAPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccount2FADisable))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountLoginPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountLogin))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountOIDCLoginPath {
+		return s.ensureOIDCEnabled(s.limitRequests(s.handleAccountOIDCLogin))(w, r, v) // Unauthenticated
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountOIDCCallbackPath {
+		return s.ensureOIDCEnabled(s.limitRequests(s.handleAccountOIDCCallback))(w, r, v) // Redirect from the identity provider
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTokenPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenCreate))(w, r, v)
 	} else if r.Method == http.MethodPatch && r.URL.Path == apiAccountTokenPath {
@@ -627,6 +781,10 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensurePaymentsEnabled(s.ensureStripeCustomer(s.handleAccountBillingPortalSessionCreate))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountBillingWebhookPath {
 		return s.ensurePaymentsEnabled(s.ensureUserManager(s.handleAccountBillingWebhook))(w, r, v) // This request comes from Stripe!
+	} else if r.Method == http.MethodPost && r.URL.Path == apiTwilioSMSStatusPath {
+		return s.ensureCallsEnabled(s.handleTwilioSMSStatus)(w, r, v) // This request comes from Twilio!
+	} else if r.Method == http.MethodPost && r.URL.Path == apiTwilioCallAckPath {
+		return s.ensureCallsEnabled(s.handleTwilioCallAck)(w, r, v) // This request comes from Twilio!
 	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountPhoneVerifyPath {
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberVerify)))(w, r, v)
 	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountPhonePath {
@@ -663,8 +821,18 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureWebEnabled(s.handleStatic)(w, r, v)
 	} else if r.Method == http.MethodGet && docsRegex.MatchString(r.URL.Path) {
 		return s.ensureWebEnabled(s.handleDocs)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAttachmentsUploadPath && s.attachment != nil {
+		return s.ensureAttachmentUploadEnabled(s.limitRequests(s.handleAttachmentUploadCreate))(w, r, v)
+	} else if r.Method == http.MethodPatch && apiAttachmentsUploadSingleRegex.MatchString(r.URL.Path) && s.attachment != nil {
+		return s.ensureAttachmentResumableUploadEnabled(s.limitRequests(s.handleAttachmentUploadChunk))(w, r, v)
+	} else if r.Method == http.MethodHead && apiAttachmentsUploadSingleRegex.MatchString(r.URL.Path) && s.attachment != nil {
+		return s.ensureAttachmentResumableUploadEnabled(s.limitRequests(s.handleAttachmentUploadOffset))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAttachmentsUploadSingleRegex.MatchString(r.URL.Path) && s.attachment != nil {
+		return s.ensureAttachmentResumableUploadEnabled(s.limitRequests(s.handleAttachmentUploadDelete))(w, r, v)
 	} else if (r.Method == http.MethodGet || r.Method == http.MethodHead) && fileRegex.MatchString(r.URL.Path) && s.attachment != nil {
 		return s.limitRequests(s.handleFile)(w, r, v)
+	} else if (r.Method == http.MethodGet || r.Method == http.MethodHead) && fileThumbnailRegex.MatchString(r.URL.Path) && s.attachment != nil {
+		return s.limitRequests(s.handleFileThumbnail)(w, r, v)
 	} else if r.Method == http.MethodOptions {
 		return s.limitRequests(s.handleOptions)(w, r, v) // Should work even if the web app is not enabled, see #598
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == "/" {
@@ -757,7 +925,40 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 		return errHTTPInternalErrorInvalidPath
 	}
 	messageID := matches[1]
-	reader, size, err := s.attachment.Read(messageID)
+	// Find message in database, to check access, to resolve byte ranges, and to associate bandwidth to the uploader user
+	m, err := s.attachmentMessage(messageID)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeAttachmentRead(r, v, m); err != nil {
+		return err
+	}
+	// Attachments are never modified, so the message ID is a strong entity tag
+	etag := fmt.Sprintf(`"%s"`, m.ID)
+	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	// Resolve the byte range, if any. If-Range makes the range conditional on the entity tag; invalid or
+	// multiple ranges are ignored, and the entire file is sent instead.
+	offset, length := int64(0), int64(-1)
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" && (r.Header.Get("If-Range") == "" || etagMatches(r.Header.Get("If-Range"), etag, false)) {
+		offset, length, err = parseByteRange(rangeHeader, m.Attachment.Size)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", m.Attachment.Size))
+			return errHTTPRequestedRangeNotSatisfiable.With(m)
+		} else if err != nil {
+			offset, length = 0, -1
+		}
+	}
+	if s.config.AttachmentRedirect && s.attachment.CanPresign() {
+		return s.handleFileRedirect(w, r, v, m, offset, length)
+	}
+	reader, size, err := s.attachment.ReadRange(m.Attachment.ObjectID(m.ID), offset, length)
 	if err != nil {
 		return errHTTPNotFound.Fields(log.Context{
 			"message_id":    messageID,
@@ -765,50 +966,40 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 		})
 	}
 	defer reader.Close()
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	partial := length >= 0
+	servedSize := size
+	if partial {
+		// The content type cannot be sniffed from the middle of the file, so we use the type detected on upload,
+		// and make sure the browser doesn't sniff it either
+		contentType := util.SafeContentType(m.Attachment.Type)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		servedSize = length
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", servedSize))
 	if r.Method == http.MethodHead {
+		if partial {
+			w.WriteHeader(http.StatusPartialContent)
+		}
 		return nil
 	}
-	// Find message in database, and associate bandwidth to the uploader user
-	// This is an easy way to
-	//   - avoid abuse (e.g. 1 uploader, 1k downloaders)
-	//   - and also uses the higher bandwidth limits of a paying user
-	m, err := s.messageCache.Message(messageID)
-	if errors.Is(err, model.ErrMessageNotFound) {
-		if s.config.CacheBatchTimeout > 0 {
-			// Strange edge case: If we immediately after upload request the file (the web app does this for images),
-			// and messages are persisted asynchronously, retry fetching from the database
-			m, err = util.Retry(func() (*model.Message, error) {
-				return s.messageCache.Message(messageID)
-			}, s.config.CacheBatchTimeout, 100*time.Millisecond, 300*time.Millisecond, 600*time.Millisecond)
-		}
-		if err != nil {
-			return errHTTPNotFound.Fields(log.Context{
-				"message_id":    messageID,
-				"error_context": "message_cache",
-			})
-		}
-	} else if err != nil {
+	// Only the bytes actually served are charged, so resuming a download doesn't count the entire file twice
+	if err := s.chargeAttachmentBandwidth(v, m, servedSize); err != nil {
 		return err
 	}
-	bandwidthVisitor := v
-	if s.userManager != nil && m.User != "" {
-		u, err := s.userManager.UserByID(m.User)
-		if err != nil {
-			return err
-		}
-		bandwidthVisitor = s.visitor(v.IP(), u)
-	} else if m.Sender.IsValid() {
-		bandwidthVisitor = s.visitor(m.Sender, nil)
-	}
-	if !bandwidthVisitor.BandwidthAllowed(size) {
-		return errHTTPTooManyRequestsLimitAttachmentBandwidth.With(m)
-	}
 	// Actually send file
 	if m.Attachment.Name != "" {
 		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(m.Attachment.Name))
 	}
+	if partial {
+		w.WriteHeader(http.StatusPartialContent)
+		_, err = io.Copy(w, reader)
+		return err
+	}
 	_, err = io.Copy(util.NewContentTypeWriter(w, r.URL.Path), reader)
 	return err
 }
@@ -823,40 +1014,63 @@ func (s *Server) handleMatrixDiscovery(w http.ResponseWriter) error {
 // dispatch delivers m to local subscribers and fires the requested side-effect targets. It is
 // the single choke point through which every published message must pass; t may be nil when
 // the topic has no local subscribers (delayed sender).
+//
+// The side effects run after the handler has returned, so they must not be canceled together with the
+// request context. The context is detached from its cancellation, but keeps its values (e.g. the trace span).
 func (s *Server) dispatch(v *visitor, t *topic, m *model.Message, opts dispatchOpts) error {
+	ctx := context.Background()
+	if opts.ctx != nil {
+		ctx = context.WithoutCancel(opts.ctx)
+	}
 	// Deliver to local subscribers
 	if t != nil {
+		publish := func(ctx context.Context) error {
+			return t.Publish(v, m)
+		}
 		if opts.async {
 			go func() {
-				if err := t.Publish(v, m); err != nil {
+				if err := s.tracer.Trace(ctx, "topic.publish", m, publish); err != nil {
 					logvm(v, m).Err(err).Warn("Unable to publish message")
 				}
 			}()
-		} else if err := t.Publish(v, m); err != nil {
+		} else if err := s.tracer.Trace(ctx, "topic.publish", m, publish); err != nil {
 			return err
 		}
 	}
 	// Fire the requested side-effect targets
 	if s.firebaseClient != nil && opts.firebase {
-		go s.sendToFirebase(v, m)
+		go s.sendToFirebase(ctx, v, m)
 	}
 	if s.mailer != nil && opts.email != "" {
-		go s.sendEmail(v, m, opts.email)
+		go s.sendEmail(ctx, v, m, opts.email)
 	}
-	if s.config.TwilioAccount != "" && opts.call != "" {
-		go s.callPhone(v, m, opts.call)
+	if s.telephony != nil && opts.call != "" {
+		go s.callPhone(ctx, v, m, opts.call)
+	}
+	if s.telephony != nil && opts.sms != "" {
+		go s.sendSMS(ctx, v, m, opts.sms)
 	}
 	if s.config.UpstreamBaseURL != "" && opts.upstream {
-		go s.forwardPollRequest(v, m)
+		go s.forwardPollRequest(ctx, v, m)
 	}
 	if s.config.WebPushPublicKey != "" && opts.webPush {
-		go s.publishToWebPushEndpoints(v, m)
+		go s.publishToWebPushEndpoints(ctx, v, m)
 	}
 	return nil
 }
 
+// addMessageToCache adds m to the message cache, in a span of the trace in ctx
+func (s *Server) addMessageToCache(ctx context.Context, m *model.Message) error {
+	return s.tracer.Trace(ctx, "cache.add", m, func(ctx context.Context) error {
+		return s.messageCache.AddMessage(m)
+	})
+}
+
 func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Message, error) {
 	start := time.Now()
+	ctx, span := s.tracer.Start(r.Context(), "publish")
+	defer span.End()
+	r = r.WithContext(ctx)
 	t, err := fromContext[*topic](r, contextTopic)
 	if err != nil {
 		return nil, err
@@ -870,7 +1084,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Mess
 		return nil, err
 	}
 	m := model.NewDefaultMessage(t.ID, "")
-	cache, firebase, email, call, template, unifiedpush, priorityStr, e := s.parsePublishParams(r, m)
+	cache, firebase, email, call, sms, template, unifiedpush, priorityStr, e := s.parsePublishParams(r, m)
 	if e != nil {
 		return nil, e.With(t)
 	}
@@ -901,6 +1115,15 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Mess
 			return nil, errHTTPTooManyRequestsLimitCalls.With(t)
 		}
 	}
+	if sms != "" {
+		var httpErr *errHTTP
+		sms, httpErr = s.convertPhoneNumber(v.User(), sms)
+		if httpErr != nil {
+			return nil, httpErr.With(t)
+		} else if !vrate.SMSAllowed() {
+			return nil, errHTTPTooManyRequestsLimitSMS.With(t)
+		}
+	}
 	if m.PollID != "" {
 		m = model.NewPollRequestMessage(t.ID, m.PollID)
 	}
@@ -916,6 +1139,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Mess
 		m.Message = emptyMessageBody
 	}
 	m.SanitizeUTF8()
+	tracing.SetMessage(span, m)
 	delayed := m.Time > time.Now().Unix()
 	ev := logvrm(v, r, m).
 		Tag(tagPublish).
@@ -926,6 +1150,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Mess
 			"message_unifiedpush": unifiedpush,
 			"message_email":       email,
 			"message_call":        call,
+			"message_sms":         sms,
 		})
 	if ev.IsTrace() {
 		ev.Field("message_body", util.MaybeMarshalJSON(m)).Trace("Received message")
@@ -934,9 +1159,11 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Mess
 	}
 	if !delayed {
 		err := s.dispatch(v, t, m, dispatchOpts{
+			ctx:      ctx,
 			firebase: firebase,
 			email:    email,
 			call:     call,
+			sms:      sms,
 			upstream: !unifiedpush, // UP messages are not sent to upstream
 			webPush:  true,
 		})
@@ -959,7 +1186,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*model.Mess
 			}
 		}
 		logvrm(v, r, m).Tag(tagPublish).Debug("Adding message to cache")
-		if err := s.messageCache.AddMessage(m); err != nil {
+		if err := s.addMessageToCache(ctx, m); err != nil {
 			return nil, err
 		}
 	}
@@ -984,7 +1211,7 @@ func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visito
 		return err
 	}
 	metrics.MessagesPublishedSuccess.Inc()
-	return s.writeJSON(w, m.ForJSON())
+	return s.writeJSON(w, s.withSignedAttachmentURLs(m).ForJSON())
 }
 
 func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -1041,8 +1268,10 @@ func (s *Server) handleActionMessage(w http.ResponseWriter, r *http.Request, v *
 	m.Sender = v.IP()
 	m.User = v.MaybeUserID()
 	m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
+	ctx, span := s.tracer.StartMessage(r.Context(), "publish", m)
+	defer span.End()
 	// Publish to subscribers, Firebase (for Android clients), and web push endpoints
-	if err := s.dispatch(v, t, m, dispatchOpts{firebase: true, webPush: true}); err != nil {
+	if err := s.dispatch(v, t, m, dispatchOpts{ctx: ctx, firebase: true, webPush: true}); err != nil {
 		return err
 	}
 	if event == model.MessageDeleteEvent {
@@ -1059,7 +1288,7 @@ func (s *Server) handleActionMessage(w http.ResponseWriter, r *http.Request, v *
 		}
 	}
 	// Add to message cache
-	if err := s.messageCache.AddMessage(m); err != nil {
+	if err := s.addMessageToCache(ctx, m); err != nil {
 		return err
 	}
 	logvrm(v, r, m).Tag(tagPublish).Debug("Published %s for sequence ID %s", event, sequenceID)
@@ -1069,9 +1298,12 @@ func (s *Server) handleActionMessage(w http.ResponseWriter, r *http.Request, v *
 	return s.writeJSON(w, m.ForJSON())
 }
 
-func (s *Server) sendToFirebase(v *visitor, m *model.Message) {
+func (s *Server) sendToFirebase(ctx context.Context, v *visitor, m *model.Message) {
+	_, span := s.tracer.StartMessage(ctx, "firebase.send", m)
+	defer span.End()
 	logvm(v, m).Tag(tagFirebase).Debug("Publishing to Firebase")
-	if err := s.firebaseClient.Send(v, m); err != nil {
+	if err := s.firebaseClient.Send(v, s.withSignedAttachmentURLs(m)); err != nil {
+		tracing.SetError(span, err)
 		metrics.FirebasePublishedFailure.Inc()
 		if errors.Is(err, errFirebaseTemporarilyBanned) {
 			logvm(v, m).Tag(tagFirebase).Err(err).Debug("Unable to publish to Firebase: %v", err.Error())
@@ -1083,9 +1315,12 @@ func (s *Server) sendToFirebase(v *visitor, m *model.Message) {
 	metrics.FirebasePublishedSuccess.Inc()
 }
 
-func (s *Server) sendEmail(v *visitor, m *model.Message, email string) {
+func (s *Server) sendEmail(ctx context.Context, v *visitor, m *model.Message, email string) {
+	_, span := s.tracer.StartMessage(ctx, "email.send", m)
+	defer span.End()
 	logvm(v, m).Tag(tagEmail).Field("email", email).Info("Sending email to %s", email)
 	if err := s.mailer.SendNotification(email, m, v.ip.String()); err != nil {
+		tracing.SetError(span, err)
 		logvm(v, m).Tag(tagEmail).Field("email", email).Err(err).Warn("Unable to send email to %s: %v", email, err.Error())
 		metrics.EmailsPublishedFailure.Inc()
 		return
@@ -1093,12 +1328,14 @@ func (s *Server) sendEmail(v *visitor, m *model.Message, email string) {
 	metrics.EmailsPublishedSuccess.Inc()
 }
 
-func (s *Server) forwardPollRequest(v *visitor, m *model.Message) {
+func (s *Server) forwardPollRequest(ctx context.Context, v *visitor, m *model.Message) {
+	ctx, span := s.tracer.StartMessage(ctx, "upstream.forward", m)
+	defer span.End()
 	topicURL := fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic)
 	topicHash := fmt.Sprintf("%x", sha256.Sum256([]byte(topicURL)))
 	forwardURL := fmt.Sprintf("%s/%s", s.config.UpstreamBaseURL, topicHash)
 	logvm(v, m).Debug("Publishing poll request to %s", forwardURL)
-	req, err := http.NewRequest("POST", forwardURL, strings.NewReader(""))
+	req, err := http.NewRequestWithContext(ctx, "POST", forwardURL, strings.NewReader(""))
 	if err != nil {
 		logvm(v, m).Err(err).Warn("Unable to publish poll request")
 		return
@@ -1108,14 +1345,17 @@ func (s *Server) forwardPollRequest(v *visitor, m *model.Message) {
 	if s.config.UpstreamAccessToken != "" {
 		req.Header.Set("Authorization", util.BearerAuth(s.config.UpstreamAccessToken))
 	}
+	tracing.Inject(ctx, req.Header)
 	var httpClient = &http.Client{
 		Timeout: time.Second * 10,
 	}
 	response, err := httpClient.Do(req)
 	if err != nil {
+		tracing.SetError(span, err)
 		logvm(v, m).Err(err).Warn("Unable to publish poll request")
 		return
 	} else if response.StatusCode != http.StatusOK {
+		tracing.SetError(span, fmt.Errorf("upstream server responded with HTTP %s", response.Status))
 		if response.StatusCode == http.StatusTooManyRequests {
 			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s; you may solve this by sending fewer daily messages, or by configuring upstream-access-token (assuming you have an account with higher rate limits) ", s.config.UpstreamBaseURL, response.Status)
 		} else {
@@ -1125,11 +1365,11 @@ func (s *Server) forwardPollRequest(v *visitor, m *model.Message) {
 	}
 }
 
-func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bool, firebase bool, email, call string, template templateMode, unifiedpush bool, priorityStr string, err *errHTTP) {
+func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bool, firebase bool, email, call, sms string, template templateMode, unifiedpush bool, priorityStr string, err *errHTTP) {
 	if r.Method != http.MethodGet && updatePathRegex.MatchString(r.URL.Path) {
 		pathSequenceID, err := s.sequenceIDFromPath(r.URL.Path)
 		if err != nil {
-			return false, false, "", "", "", false, "", err
+			return false, false, "", "", "", "", false, "", err
 		}
 		m.SequenceID = pathSequenceID
 	} else {
@@ -1138,7 +1378,7 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 			if sequenceIDRegex.MatchString(sequenceID) {
 				m.SequenceID = sequenceID
 			} else {
-				return false, false, "", "", "", false, "", errHTTPBadRequestSequenceIDInvalid
+				return false, false, "", "", "", "", false, "", errHTTPBadRequestSequenceIDInvalid
 			}
 		} else {
 			m.SequenceID = m.ID
@@ -1159,7 +1399,7 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 	}
 	if attach != "" {
 		if !urlRegex.MatchString(attach) {
-			return false, false, "", "", "", false, "", errHTTPBadRequestAttachmentURLInvalid
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestAttachmentURLInvalid
 		}
 		m.Attachment.URL = attach
 		if m.Attachment.Name == "" {
@@ -1177,22 +1417,28 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 	}
 	if icon != "" {
 		if !urlRegex.MatchString(icon) {
-			return false, false, "", "", "", false, "", errHTTPBadRequestIconURLInvalid
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestIconURLInvalid
 		}
 		m.Icon = icon
 	}
 	email = readParam(r, "x-email", "x-e-mail", "email", "e-mail", "mail", "e")
 	if email != "" && !emailAddressRegex.MatchString(email) && !toBool(email) {
-		return false, false, "", "", "", false, "", errHTTPBadRequestEmailAddressInvalid
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestEmailAddressInvalid
 	}
 	if s.mailer == nil && email != "" {
-		return false, false, "", "", "", false, "", errHTTPBadRequestEmailDisabled
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestEmailDisabled
 	}
 	call = readParam(r, "x-call", "call")
-	if call != "" && (s.config.TwilioAccount == "" || s.userManager == nil) {
-		return false, false, "", "", "", false, "", errHTTPBadRequestPhoneCallsDisabled
+	if call != "" && (s.telephony == nil || s.userManager == nil) {
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestPhoneCallsDisabled
 	} else if call != "" && !isBoolValue(call) && !phoneNumberRegex.MatchString(call) {
-		return false, false, "", "", "", false, "", errHTTPBadRequestPhoneNumberInvalid
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestPhoneNumberInvalid
+	}
+	sms = readParam(r, "x-sms", "sms")
+	if sms != "" && (s.telephony == nil || s.userManager == nil) {
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestSMSDisabled
+	} else if sms != "" && !isBoolValue(sms) && !phoneNumberRegex.MatchString(sms) {
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestPhoneNumberInvalid
 	}
 	template = templateMode(readParam(r, "x-template", "template", "tpl"))
 	messageStr := readParam(r, "x-message", "message", "m")
@@ -1208,7 +1454,7 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 	if !template.Enabled() {
 		m.Priority, e = util.ParsePriority(priorityStr)
 		if e != nil {
-			return false, false, "", "", "", false, "", errHTTPBadRequestPriorityInvalid
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestPriorityInvalid
 		}
 		priorityStr = "" // Clear since it's already parsed
 	}
@@ -1216,21 +1462,24 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 	delayStr := readParam(r, "x-delay", "delay", "x-at", "at", "x-in", "in")
 	if delayStr != "" {
 		if !cache {
-			return false, false, "", "", "", false, "", errHTTPBadRequestDelayNoCache
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayNoCache
 		}
 		if email != "" {
-			return false, false, "", "", "", false, "", errHTTPBadRequestDelayNoEmail // we cannot store the email address (yet)
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayNoEmail // we cannot store the email address (yet)
 		}
 		if call != "" {
-			return false, false, "", "", "", false, "", errHTTPBadRequestDelayNoCall // we cannot store the phone number (yet)
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayNoCall // we cannot store the phone number (yet)
+		}
+		if sms != "" {
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayNoSMS // same as above
 		}
 		delay, err := util.ParseFutureTime(delayStr, time.Now())
 		if err != nil {
-			return false, false, "", "", "", false, "", errHTTPBadRequestDelayCannotParse
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayCannotParse
 		} else if delay.Unix() < time.Now().Add(s.config.MessageDelayMin).Unix() {
-			return false, false, "", "", "", false, "", errHTTPBadRequestDelayTooSmall
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayTooSmall
 		} else if delay.Unix() > time.Now().Add(s.config.MessageDelayMax).Unix() {
-			return false, false, "", "", "", false, "", errHTTPBadRequestDelayTooLarge
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestDelayTooLarge
 		}
 		m.Time = delay.Unix()
 	}
@@ -1238,7 +1487,7 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 	if actionsStr != "" {
 		m.Actions, e = action.Parse(actionsStr)
 		if e != nil {
-			return false, false, "", "", "", false, "", errHTTPBadRequestActionsInvalid.Wrap("%s", e.Error())
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestActionsInvalid.Wrap("%s", e.Error())
 		}
 	}
 	contentType, markdown := readParam(r, "content-type", "content_type"), readBoolParam(r, false, "x-markdown", "markdown", "md")
@@ -1257,7 +1506,16 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 		cache = false
 		email = ""
 	}
-	return cache, firebase, email, call, template, unifiedpush, priorityStr, nil
+	encoding := strings.ToLower(readParam(r, "x-encoding", "encoding"))
+	if encoding != "" && encoding != encodingJWE {
+		return false, false, "", "", "", "", false, "", errHTTPBadRequestEncodingInvalid
+	} else if encoding == encodingJWE {
+		if template.Enabled() || unifiedpush || email != "" || call != "" || sms != "" || filename != "" {
+			return false, false, "", "", "", "", false, "", errHTTPBadRequestEncryptedMessageNotAllowed
+		}
+		m.Encoding = encodingJWE
+	}
+	return cache, firebase, email, call, sms, template, unifiedpush, priorityStr, nil
 }
 
 // handlePublishBody consumes the PUT/POST body and decides whether the body is an attachment or the message.
@@ -1266,8 +1524,12 @@ func (s *Server) parsePublishParams(r *http.Request, m *model.Message) (cache bo
 //     If a message is flagged as poll request, the body does not matter and is discarded
 //  2. curl -T somebinarydata.bin "ntfy.sh/mytopic?up=1"
 //     If UnifiedPush is enabled, encode as base64 if body is binary, and do not trim
+//     2a. curl -H "Encoding: jwe" -d "eyJhbGciOiJkaXIi..." ntfy.sh/mytopic
+//     Body must be an end-to-end encrypted message, which is passed through as is
 //  3. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
 //     Body must be a message, because we attached an external URL
+//     3a. curl -H "Upload: AbCd1234" ntfy.sh/mytopic
+//     Body must be a message, because the attachment was uploaded directly to S3 (see handleAttachmentUploadCreate)
 //  4. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
 //     Body must be attachment, because we passed a filename
 //  5. curl -H "Template: yes" -T file.txt ntfy.sh/mytopic
@@ -1281,8 +1543,12 @@ func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *model.Message
 		return s.handleBodyDiscard(body)
 	} else if unifiedpush {
 		return s.handleBodyAsMessageAutoDetect(m, body) // Case 2
+	} else if m.Encoding == encodingJWE {
+		return s.handleBodyAsEncryptedMessage(m, body) // Case 2a
 	} else if m.Attachment != nil && m.Attachment.URL != "" {
 		return s.handleBodyAsTextMessage(m, body) // Case 3
+	} else if uploadID := readParam(r, "x-upload", "upload"); uploadID != "" {
+		return s.handleBodyAsUploadedAttachment(v, m, body, uploadID) // Case 3a
 	} else if m.Attachment != nil && m.Attachment.Name != "" {
 		return s.handleBodyAsAttachment(r, v, m, body) // Case 4
 	} else if template.Enabled() {
@@ -1309,6 +1575,21 @@ func (s *Server) handleBodyAsMessageAutoDetect(m *model.Message, body *util.Peek
 	return nil
 }
 
+func (s *Server) handleBodyAsEncryptedMessage(m *model.Message, body *util.PeekedReadCloser) error {
+	if body.LimitReached {
+		return errHTTPEntityTooLargeEncryptedMessage.With(m)
+	}
+	message := strings.TrimSpace(string(body.PeekedBytes))
+	if message == "" && m.Message != "" {
+		message = m.Message // Publish via GET, or "Message" header
+	}
+	if !encryption.ValidJWE(message) {
+		return errHTTPBadRequestEncryptedMessageInvalid.With(m)
+	}
+	m.Message = message
+	return nil
+}
+
 func (s *Server) handleBodyAsTextMessage(m *model.Message, body *util.PeekedReadCloser) error {
 	if !utf8.Valid(body.PeekedBytes) {
 		return errHTTPBadRequestMessageNotUTF8.With(m)
@@ -1338,10 +1619,11 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *model.Me
 		return errHTTPBadRequestAttachmentsExpiryBeforeDelivery.With(m)
 	}
 	// Early "do-not-trust" check, hard limit see below
-	if r.ContentLength > 0 && (r.ContentLength > vinfo.Stats.AttachmentTotalSizeRemaining || r.ContentLength > vinfo.Limits.AttachmentFileSizeLimit) {
+	totalSizeRemaining := vinfo.Stats.AttachmentTotalSizeRemaining - s.pendingAttachmentUploadsSize(v)
+	if r.ContentLength > 0 && (r.ContentLength > totalSizeRemaining || r.ContentLength > vinfo.Limits.AttachmentFileSizeLimit) {
 		return errHTTPEntityTooLargeAttachment.With(m).Fields(log.Context{
 			"message_content_length":          r.ContentLength,
-			"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
+			"attachment_total_size_remaining": totalSizeRemaining,
 			"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
 		})
 	}
@@ -1351,7 +1633,7 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *model.Me
 	var ext string
 	m.Attachment.Expires = attachmentExpiry
 	m.Attachment.Type, ext = util.DetectContentType(body.PeekedBytes, m.Attachment.Name)
-	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, m.ID, ext)
+	m.Attachment.URL = s.attachmentURL(m.ID, ext)
 	if m.Attachment.Name == "" {
 		m.Attachment.Name = fmt.Sprintf("attachment%s", ext)
 	}
@@ -1361,14 +1643,34 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *model.Me
 	limiters := []util.Limiter{
 		v.BandwidthLimiter(),
 		util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit),
-		util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining),
+		util.NewFixedLimiter(totalSizeRemaining),
 	}
-	m.Attachment.Size, err = s.attachment.Write(m.ID, body, r.ContentLength, limiters...)
+	var reader io.Reader = body
+	untrustedLength := r.ContentLength
+	if s.config.AttachmentStripMetadata {
+		if stripped, ok := attachment.StripMetadata(body, m.Attachment.Type); ok {
+			reader, untrustedLength = stripped, 0 // Stripping metadata changes the length
+		}
+	}
+	object, err := s.attachment.WriteDeduplicated(m.ID, reader, untrustedLength, limiters...)
+	var infected *attachment.InfectedError
 	if errors.Is(err, util.ErrLimitReached) {
 		return errHTTPEntityTooLargeAttachment.With(m)
+	} else if errors.As(err, &infected) {
+		return errHTTPBadRequestAttachmentRejected.With(m).Fields(log.Context{
+			"attachment_signature": infected.Signature,
+		})
 	} else if err != nil {
 		return err
 	}
+	m.Attachment.Size = object.Size
+	m.Attachment.SHA256 = object.SHA256
+	if object.ID != m.ID {
+		m.Attachment.Object = object.ID // Identical to an existing attachment, which is shared instead of stored twice
+	}
+	if s.config.AttachmentThumbnails && attachment.ThumbnailSupported(m.Attachment.Type) {
+		s.writeAttachmentThumbnail(v, m)
+	}
 	return nil
 }
 
@@ -1389,7 +1691,7 @@ func (s *Server) handleSubscribeSSE(w http.ResponseWriter, r *http.Request, v *v
 		if err := util.EncodeJSON(&buf, msg.ForJSON()); err != nil {
 			return "", err
 		}
-		if msg.Event != model.MessageEvent && msg.Event != model.MessageDeleteEvent && msg.Event != model.MessageClearEvent {
+		if msg.Event != model.MessageEvent && msg.Event != model.MessageDeleteEvent && msg.Event != model.MessageClearEvent && msg.Event != model.MessageAckEvent {
 			return fmt.Sprintf("event: %s\ndata: %s\n", msg.Event, buf.String()), nil // Browser's .onmessage() does not fire on this!
 		}
 		return fmt.Sprintf("data: %s\n", buf.String()), nil
@@ -1438,7 +1740,7 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 		if !filters.Pass(msg) {
 			return nil
 		}
-		m, err := encoder(msg)
+		m, err := encoder(s.withSignedAttachmentURLs(msg))
 		if err != nil {
 			return err
 		}
@@ -1605,7 +1907,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		if err := conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
 			return err
 		}
-		return conn.WriteJSON(msg)
+		return conn.WriteJSON(s.withSignedAttachmentURLs(msg))
 	}
 	if err := s.maybeSetRateVisitors(r, v, topics); err != nil {
 		return err
@@ -1940,14 +2242,14 @@ func (s *Server) runFirebaseKeepaliver() {
 	for {
 		select {
 		case <-time.After(s.config.FirebaseKeepaliveInterval):
-			s.sendToFirebase(v, model.NewKeepaliveMessage(firebaseControlTopic))
+			s.sendToFirebase(context.Background(), v, model.NewKeepaliveMessage(firebaseControlTopic))
 		/*
 			FIXME: Disable iOS polling entirely for now due to thundering herd problem (see #677)
 			       To solve this, we'd have to shard the iOS poll topics to spread out the polling evenly.
 			       Given that it's not really necessary to poll, turning it off for now should not have any impact.
 
 			case <-time.After(s.config.FirebasePollInterval):
-				s.sendToFirebase(v, model.NewKeepaliveMessage(firebasePollTopic))
+				s.sendToFirebase(context.Background(), v, model.NewKeepaliveMessage(firebasePollTopic))
 		*/
 		case <-s.closeChan:
 			return
@@ -1991,6 +2293,8 @@ func (s *Server) sendDelayedMessages() error {
 }
 
 func (s *Server) sendDelayedMessage(v *visitor, m *model.Message) error {
+	ctx, span := s.tracer.StartMessage(context.Background(), "publish.delayed", m)
+	defer span.End()
 	logvm(v, m).Debug("Sending delayed message")
 	s.mu.RLock()
 	t := s.topics[m.Topic] // May be nil if there are no local subscribers; dispatch handles that
@@ -1998,6 +2302,7 @@ func (s *Server) sendDelayedMessage(v *visitor, m *model.Message) error {
 	// We do not rate-limit messages here, since we've rate limited them in the PUT/POST handler.
 	// Firebase subscribers may not show up in the topics map, so side effects fire regardless.
 	err := s.dispatch(v, t, m, dispatchOpts{
+		ctx:      ctx,
 		firebase: true,
 		upstream: true,
 		webPush:  true,
@@ -2068,6 +2373,9 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.Call != "" {
 			r.Header.Set("X-Call", m.Call)
 		}
+		if m.SMS != "" {
+			r.Header.Set("X-SMS", m.SMS)
+		}
 		if m.Cache != "" {
 			r.Header.Set("X-Cache", m.Cache)
 		}
@@ -2077,6 +2385,9 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.SequenceID != "" {
 			r.Header.Set("X-Sequence-ID", m.SequenceID)
 		}
+		if m.Encoding != "" {
+			r.Header.Set("X-Encoding", m.Encoding)
+		}
 		return next(w, r, v)
 	}
 }
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	twoFactorCodeHeader = "X-2FA-Code" // TOTP or recovery code, sent along with username and password, see checkTwoFactor
+	totpDefaultIssuer   = "ntfy"       // Issuer shown in authenticator apps if base-url is not set
+)
+
+// handleAccount2FASetup starts the setup of two-factor authentication: it generates a new TOTP secret, and returns it
+// along with the otpauth:// provisioning URI, which can be shown as QR code and scanned with an authenticator app.
+// Two-factor authentication is only enabled once the user confirms a code, see handleAccount2FAEnable.
+func (s *Server) handleAccount2FASetup(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	secret, err := s.userManager.AddTOTPSecret(u.ID)
+	if errors.Is(err, user.ErrTOTPEnabled) {
+		return errHTTPConflictTwoFactorEnabled
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Debug("Starting two-factor authentication setup for user %s", u.Name)
+	response := &apiAccount2FASetupResponse{
+		Secret: secret,
+		URI:    user.TOTPProvisioningURI(s.totpIssuer(), u.Name, secret),
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAccount2FAEnable enables two-factor authentication, if the code matches the secret generated in
+// handleAccount2FASetup, and returns the recovery codes. They are only stored hashed, and cannot be shown again.
+func (s *Server) handleAccount2FAEnable(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccount2FAEnableRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	recoveryCodes, err := s.userManager.EnableTOTP(u.ID, req.Code)
+	if errors.Is(err, user.ErrTOTPNotFound) {
+		return errHTTPBadRequestTwoFactorNotSetUp
+	} else if errors.Is(err, user.ErrTOTPCodeInvalid) {
+		return errHTTPBadRequestTwoFactorCodeInvalid
+	} else if errors.Is(err, user.ErrTOTPEnabled) {
+		return errHTTPConflictTwoFactorEnabled
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Info("Enabled two-factor authentication for user %s", u.Name)
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionUser2FA, u.Name, "disabled", "enabled")
+	response := &apiAccount2FAEnableResponse{
+		RecoveryCodes: recoveryCodes,
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAccount2FADisable disables two-factor authentication, after confirming the user's password. Users whose
+// role requires two-factor authentication (auth-2fa-required) cannot disable it.
+func (s *Server) handleAccount2FADisable(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccount2FADisableRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Password == "" {
+		return errHTTPBadRequest
+	}
+	if _, err := s.userManager.Authenticate(u.Name, req.Password); err != nil {
+		return errHTTPBadRequestIncorrectPasswordConfirmation
+	} else if slices.Contains(s.config.AuthTwoFactorRequired, u.Role) {
+		return errHTTPForbiddenTwoFactorRequired
+	}
+	logvr(v, r).Tag(tagAccount).Info("Disabling two-factor authentication for user %s", u.Name)
+	if err := s.userManager.RemoveTOTP(u.ID); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionUser2FA, u.Name, "enabled", "disabled")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// checkTwoFactor is the second step of a password login (see handleAccountLogin): if the user has two-factor
+// authentication enabled, the request must carry a TOTP code or recovery code in the X-2FA-Code header. Users
+// whose role requires two-factor authentication cannot log in with a password until they have set it up.
+func (s *Server) checkTwoFactor(r *http.Request, v *visitor, u *user.User) error {
+	enabled, err := s.userManager.TOTPEnabled(u.ID)
+	if err != nil {
+		return err
+	} else if !enabled {
+		if slices.Contains(s.config.AuthTwoFactorRequired, u.Role) {
+			return errHTTPForbiddenTwoFactorSetupRequired
+		}
+		return nil
+	}
+	code := strings.TrimSpace(r.Header.Get(twoFactorCodeHeader))
+	if code == "" {
+		return errHTTPUnauthorizedTwoFactorRequired
+	}
+	if err := s.userManager.VerifyTOTP(u.ID, code); errors.Is(err, user.ErrTOTPCodeInvalid) {
+		s.visitor(v.IP(), nil).AuthFailed() // Count towards the auth rate limit, to prevent guessing codes
+		logvr(v, r).Tag(tagAccount).Info("Invalid two-factor authentication code for user %s", u.Name)
+		return errHTTPUnauthorizedTwoFactorInvalid
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// totpIssuer returns the issuer shown in authenticator apps, which is the host of the base URL if it is set
+func (s *Server) totpIssuer() string {
+	if u, err := url.Parse(s.config.BaseURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return totpDefaultIssuer
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestAccount_2FA_SetupAndLogin(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		conf := newTestConfigWithAuthFile(t, databaseURL)
+		conf.BaseURL = "https://ntfy.example.com"
+		s := newTestServer(t, conf)
+		defer s.closeDatabases()
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+
+		// Setup returns the secret and provisioning URI, but does not enable 2FA yet
+		rr := request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		setup, err := util.UnmarshalJSON[apiAccount2FASetupResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		require.Equal(t, "otpauth://totp/ntfy.example.com:phil?algorithm=SHA1&digits=6&issuer=ntfy.example.com&period=30&secret="+setup.Secret, setup.URI)
+
+		rr = request(t, s, "PUT", "/v1/account/2fa", `{"code":"000000"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 40069, toHTTPError(t, rr.Body.String()).Code)
+
+		code, err := user.TOTPCode(setup.Secret, time.Now())
+		require.Nil(t, err)
+		rr = request(t, s, "PUT", "/v1/account/2fa", `{"code":"`+code+`"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		enabled, err := util.UnmarshalJSON[apiAccount2FAEnableResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		require.Len(t, enabled.RecoveryCodes, 10)
+
+		// Password alone is no longer enough, neither for logging in nor for anything else
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 401, rr.Code)
+		require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "PUT", "/mytopic", "test", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 401, rr.Code)
+		require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"X-2FA-Code":    "123456",
+		})
+		require.Equal(t, 401, rr.Code)
+		require.Equal(t, 40103, toHTTPError(t, rr.Body.String()).Code)
+
+		// Log in with the next TOTP code (the one used for enabling cannot be replayed), and with a recovery code
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"X-2FA-Code":    code,
+		})
+		require.Equal(t, 40103, toHTTPError(t, rr.Body.String()).Code)
+		next, err := user.TOTPCode(setup.Secret, time.Now().Add(30*time.Second))
+		require.Nil(t, err)
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"X-2FA-Code":    next,
+		})
+		require.Equal(t, 200, rr.Code)
+		login, err := util.UnmarshalJSON[apiAccountLoginResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"X-2FA-Code":    enabled.RecoveryCodes[0],
+		})
+		require.Equal(t, 200, rr.Code)
+
+		// Access tokens are exempt
+		rr = request(t, s, "PUT", "/mytopic", "test", map[string]string{
+			"Authorization": util.BearerAuth(login.Token),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Authorization": util.BearerAuth(login.Token),
+		})
+		require.Equal(t, 200, rr.Code)
+		account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		require.True(t, account.TwoFactor)
+		rr = request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+			"Authorization": util.BearerAuth(login.Token),
+		})
+		require.Equal(t, 409, rr.Code)
+
+		// Disabling requires the password
+		rr = request(t, s, "DELETE", "/v1/account/2fa", `{"password":"wrong"}`, map[string]string{
+			"Authorization": util.BearerAuth(login.Token),
+		})
+		require.Equal(t, 40026, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "DELETE", "/v1/account/2fa", `{"password":"phil"}`, map[string]string{
+			"Authorization": util.BearerAuth(login.Token),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+	})
+}
+
+func TestAccount_2FA_RequiredForRole(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t, "")
+	conf.AuthTwoFactorRequired = []user.Role{user.RoleAdmin}
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	// Other roles are not affected
+	rr := request(t, s, "POST", "/v1/account/login", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Admin cannot log in before setting up 2FA, but can set it up with the password
+	rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+
+	// ... and cannot use the password anywhere else either, e.g. to publish, subscribe or access the account
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+
+	// Other roles can still use their password
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	setup, err := util.UnmarshalJSON[apiAccount2FASetupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	code, err := user.TOTPCode(setup.Secret, time.Now())
+	require.Nil(t, err)
+	rr = request(t, s, "PUT", "/v1/account/2fa", `{"code":"`+code+`"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	enabled, err := util.UnmarshalJSON[apiAccount2FAEnableResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+
+	rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-2FA-Code":    enabled.RecoveryCodes[0],
+	})
+	require.Equal(t, 200, rr.Code)
+	login, err := util.UnmarshalJSON[apiAccountLoginResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+
+	// After setup, the password is only accepted with a code at the login endpoint, and the token works
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BearerAuth(login.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Cannot be disabled
+	rr = request(t, s, "DELETE", "/v1/account/2fa", `{"password":"phil"}`, map[string]string{
+		"Authorization": util.BearerAuth(login.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40304, toHTTPError(t, rr.Body.String()).Code)
+}
@@ -5,12 +5,13 @@ import (
 	"errors"
 	"net/http"
 	"net/netip"
+	"slices"
 	"strings"
 	"time"
 
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/model"
-	"heckel.io/ntfy/v2/twilio"
+	"heckel.io/ntfy/v2/telephony"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 )
@@ -51,6 +52,7 @@ func (s *Server) handleAccountCreate(w http.ResponseWriter, r *http.Request, v *
 		}
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionUserAdd, newAccount.Username, "", string(user.RoleUser))
 	v.AccountActionPerformed()
 	// If an email was provided and email sending is configured, start verification (best-effort).
 	// The address becomes the primary email on verify (the new account has no primary yet); a
@@ -79,6 +81,7 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			MessagesExpiryDuration:   int64(limits.MessageExpiryDuration.Seconds()),
 			Emails:                   limits.EmailLimit,
 			Calls:                    limits.CallLimit,
+			SMS:                      limits.SMSLimit,
 			Reservations:             limits.ReservationsLimit,
 			AttachmentTotalSize:      limits.AttachmentTotalSizeLimit,
 			AttachmentFileSize:       limits.AttachmentFileSizeLimit,
@@ -92,6 +95,8 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			EmailsRemaining:              stats.EmailsRemaining,
 			Calls:                        stats.Calls,
 			CallsRemaining:               stats.CallsRemaining,
+			SMS:                          stats.SMS,
+			SMSRemaining:                 stats.SMSRemaining,
 			Reservations:                 stats.Reservations,
 			ReservationsRemaining:        stats.ReservationsRemaining,
 			AttachmentTotalSize:          stats.AttachmentTotalSize,
@@ -144,14 +149,42 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			}
 			if len(reservations) > 0 {
 				response.Reservations = make([]*apiAccountReservation, 0)
+				for _, r := range reservations {
+					response.Reservations = append(response.Reservations, &apiAccountReservation{
+						Topic:       r.Topic,
+						Everyone:    r.Everyone.String(),
+						Provisioned: r.Provisioned,
+					})
+				}
+			}
+		}
+		groups, err := s.userManager.UserGroups(u.Name)
+		if err != nil {
+			return err
+		}
+		if len(groups) > 0 {
+			response.Groups = groups
+		}
+		if s.config.EnableReservations {
+			for _, group := range groups {
+				reservations, err := s.userManager.GroupReservations(group)
+				if err != nil {
+					return err
+				}
 				for _, r := range reservations {
 					response.Reservations = append(response.Reservations, &apiAccountReservation{
 						Topic:    r.Topic,
 						Everyone: r.Everyone.String(),
+						Group:    group,
 					})
 				}
 			}
 		}
+		twoFactor, err := s.userManager.TOTPEnabled(u.ID)
+		if err != nil {
+			return err
+		}
+		response.TwoFactor = twoFactor
 		tokens, err := s.userManager.Tokens(u.ID)
 		if err != nil {
 			return err
@@ -173,7 +206,7 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 				})
 			}
 		}
-		if s.config.TwilioAccount != "" {
+		if s.telephony != nil {
 			phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
 			if err != nil {
 				return err
@@ -245,6 +278,7 @@ func (s *Server) handleAccountDelete(w http.ResponseWriter, r *http.Request, v *
 	if err := s.userManager.MarkUserRemoved(u); err != nil {
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionUserRemove, u.Name, string(u.Role), "")
 	return s.writeJSON(w, newSuccessResponse())
 }
 
@@ -266,6 +300,7 @@ func (s *Server) handleAccountPasswordChange(w http.ResponseWriter, r *http.Requ
 		}
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionUserPassword, u.Name, "", "")
 	return s.writeJSON(w, newSuccessResponse())
 }
 
@@ -275,6 +310,11 @@ func (s *Server) handleAccountPasswordChange(w http.ResponseWriter, r *http.Requ
 // user in, so it also reports who they are (the identifier they typed may be a primary email).
 func (s *Server) handleAccountLogin(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
+	if passwordAuth(r) {
+		if err := s.checkTwoFactor(r, v, u); err != nil {
+			return err
+		}
+	}
 	logvr(v, r).Tag(tagAccount).Info("Logging in user %s", u.Name)
 	token, err := s.userManager.CreateToken(u.ID, "", time.Now().Add(tokenExpiryDuration), v.IP(), false)
 	if err != nil {
@@ -312,6 +352,7 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionTokenCreate, u.Name, "", user.FormatToken(token.Value))
 	response := &apiAccountTokenResponse{
 		Token:      token.Value,
 		Label:      token.Label,
@@ -353,6 +394,9 @@ func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request
 		}
 		return err
 	}
+	if req.Label != nil || req.Expires != nil { // Don't audit regular extensions of session tokens
+		s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionTokenChange, u.Name, "", user.FormatToken(token.Value))
+	}
 	response := &apiAccountTokenResponse{
 		Token:      token.Value,
 		Label:      token.Label,
@@ -382,6 +426,9 @@ func (s *Server) handleAccountTokenDelete(w http.ResponseWriter, r *http.Request
 		Tag(tagAccount).
 		Field("token", token).
 		Debug("Deleted token for user %s", u.Name)
+	if token != u.Token { // Don't audit logouts
+		s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionTokenRemove, u.Name, user.FormatToken(token), "")
+	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
@@ -518,6 +565,9 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return errHTTPBadRequestPermissionInvalid
 	}
+	if req.Group != "" {
+		return s.handleAccountGroupReservationAdd(w, r, v, req.Group, req.Topic, everyone)
+	}
 	// Check if we are allowed to reserve this topic
 	if u.IsUser() && u.Tier == nil {
 		return errHTTPUnauthorized
@@ -539,9 +589,12 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 	if err := s.userManager.AddReservation(u.Name, req.Topic, everyone, limit); err != nil {
 		if errors.Is(err, user.ErrTooManyReservations) {
 			return errHTTPTooManyRequestsLimitReservations
+		} else if errors.Is(err, user.ErrProvisionedReservationChange) {
+			return errHTTPConflictProvisionedReservationChange
 		}
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionReservationAdd, u.Name, "", user.FormatGrant(req.Topic, everyone))
 	// Kill existing subscribers
 	t, err := s.topicFromID(v, req.Topic)
 	if err != nil {
@@ -551,7 +604,50 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 	return s.writeJSON(w, newSuccessResponse())
 }
 
-// handleAccountReservationDelete deletes a topic reservation if it is owned by the current user
+// handleAccountGroupReservationAdd adds or updates a topic reservation on behalf of a group. Any member of the
+// group may change the Everyone permission of an existing group reservation, but only admins may reserve new
+// topics for a group, since group reservations do not count towards the reservation limit of a tier.
+func (s *Server) handleAccountGroupReservationAdd(w http.ResponseWriter, r *http.Request, v *visitor, group, topic string, everyone user.Permission) error {
+	u := v.User()
+	member, err := s.isGroupMember(u, group)
+	if err != nil {
+		return err
+	} else if !member {
+		return errHTTPUnauthorized
+	}
+	owner, err := s.userManager.GroupReservationOwner(topic)
+	if err != nil {
+		return err
+	} else if owner != group && !u.IsAdmin() {
+		return errHTTPUnauthorized
+	} else if err := s.userManager.AllowGroupReservation(group, topic); errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return errHTTPConflictTopicReserved
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic":    topic,
+			"group":    group,
+			"everyone": everyone.String(),
+		}).
+		Debug("Adding group topic reservation")
+	if err := s.userManager.AddGroupReservation(group, topic, everyone); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionGroupReservationAdd, group, "", user.FormatGrant(topic, everyone))
+	// Kill existing subscribers; other group members will simply re-subscribe
+	t, err := s.topicFromID(v, topic)
+	if err != nil {
+		return err
+	}
+	t.CancelSubscribersExceptUser(u.ID)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleAccountReservationDelete deletes a topic reservation if it is owned by the current user,
+// or by a group the current user is a member of
 func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	matches := apiAccountReservationSingleRegex.FindStringSubmatch(r.URL.Path)
 	if len(matches) != 2 {
@@ -565,19 +661,43 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 	authorized, err := s.userManager.HasReservation(u.Name, topic)
 	if err != nil {
 		return err
-	} else if !authorized {
-		return errHTTPUnauthorized
+	}
+	var group string
+	if !authorized {
+		group, err = s.userManager.GroupReservationOwner(topic)
+		if err != nil {
+			return err
+		} else if group == "" {
+			return errHTTPUnauthorized
+		}
+		authorized, err = s.isGroupMember(u, group)
+		if err != nil {
+			return err
+		} else if !authorized {
+			return errHTTPUnauthorized
+		}
 	}
 	deleteMessages := readBoolParam(r, false, "X-Delete-Messages", "Delete-Messages")
 	logvr(v, r).
 		Tag(tagAccount).
 		Fields(log.Context{
 			"topic":           topic,
+			"group":           group,
 			"delete_messages": deleteMessages,
 		}).
 		Debug("Removing topic reservation")
-	if err := s.userManager.RemoveReservations(u.Name, topic); err != nil {
-		return err
+	if group != "" {
+		if err := s.userManager.RemoveGroupReservations(group, topic); err != nil {
+			return err
+		}
+		s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionGroupReservationRemove, group, topic, "")
+	} else {
+		if err := s.userManager.RemoveReservations(u.Name, topic); errors.Is(err, user.ErrProvisionedReservationChange) {
+			return errHTTPConflictProvisionedReservationChange
+		} else if err != nil {
+			return err
+		}
+		s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionReservationRemove, u.Name, topic, "")
 	}
 	if deleteMessages {
 		if err := s.messageCache.ExpireMessages(topic); err != nil {
@@ -588,6 +708,19 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// isGroupMember returns true if the user is a member of the given group. Admins are considered
+// members of all groups.
+func (s *Server) isGroupMember(u *user.User, group string) (bool, error) {
+	if u.IsAdmin() {
+		return true, nil
+	}
+	groups, err := s.userManager.UserGroups(u.Name)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(groups, group), nil
+}
+
 // maybeRemoveMessagesAndExcessReservations deletes topic reservations for the given user (if too many for tier),
 // and marks associated messages for the topics as deleted. This also eventually deletes attachments.
 // The process relies on the manager to perform the actual deletions (see runManager).
@@ -620,7 +753,7 @@ func (s *Server) handleAccountPhoneNumberVerify(w http.ResponseWriter, r *http.R
 	// Check user is allowed to add phone numbers
 	if u == nil || (u.IsUser() && u.Tier == nil) {
 		return errHTTPUnauthorized
-	} else if u.IsUser() && u.Tier.CallLimit == 0 {
+	} else if u.IsUser() && u.Tier.CallLimit == 0 && u.Tier.SMSLimit == 0 {
 		return errHTTPUnauthorized
 	}
 	// Check if phone number exists
@@ -632,7 +765,7 @@ func (s *Server) handleAccountPhoneNumberVerify(w http.ResponseWriter, r *http.R
 	}
 	// Actually add the unverified number, and send verification
 	logvr(v, r).Tag(tagAccount).Field("phone_number", req.Number).Debug("Sending phone number verification")
-	if err := s.twilio.Verify(req.Number, req.Channel); err != nil {
+	if err := s.telephony.Verify(req.Number, req.Channel); err != nil {
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
@@ -647,8 +780,8 @@ func (s *Server) handleAccountPhoneNumberAdd(w http.ResponseWriter, r *http.Requ
 	if !phoneNumberRegex.MatchString(req.Number) {
 		return errHTTPBadRequestPhoneNumberInvalid
 	}
-	if err := s.twilio.CheckVerify(req.Number, req.Code); err != nil {
-		if errors.Is(err, twilio.ErrVerificationExpired) {
+	if err := s.telephony.CheckVerify(req.Number, req.Code); err != nil {
+		if errors.Is(err, telephony.ErrVerificationExpired) {
 			return errHTTPGonePhoneVerificationExpired
 		}
 		return err
@@ -881,13 +1014,14 @@ func (s *Server) handleAccountPasswordReset(w http.ResponseWriter, r *http.Reque
 	} else if req.Password == "" {
 		return errHTTPBadRequest
 	}
-	err = s.userManager.ResetPassword(req.Token, req.Password)
+	u, err := s.userManager.ResetPassword(req.Token, req.Password)
 	if errors.Is(err, user.ErrMagicLinkNotFound) || errors.Is(err, user.ErrProvisionedUserChange) {
 		return errHTTPBadRequestResetLinkInvalid // Generic 400 (provisioned users can't be reset; don't leak that)
 	} else if err != nil {
 		return err
 	}
-	logvr(v, r).Tag(tagAccount).Info("Password reset performed")
+	logvr(v, r).Tag(tagAccount).Info("Password reset performed for user %s", u.Name)
+	s.audit(r, v, user.AuditSourceAccountAPI, user.AuditActionUserPassword, u.Name, "", "")
 	return s.writeJSON(w, newSuccessResponse())
 }
 
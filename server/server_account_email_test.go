@@ -257,6 +257,14 @@ func TestAccount_PasswordReset_ByUsername(t *testing.T) {
 
 		require.True(t, canLogin(t, s, "ben", "brandnew"))
 		require.False(t, canLogin(t, s, "ben", "ben"))
+
+		// Reset is recorded in the audit log, without an actor (the user is not logged in)
+		entries, err := s.userManager.AuditEntries(&user.AuditFilter{Target: "ben", Action: string(user.AuditActionUserPassword)})
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "", entries[0].Actor)
+		require.Equal(t, user.AuditSourceAccountAPI, entries[0].Source)
+		require.Equal(t, "9.9.9.9", entries[0].Origin.String())
 	})
 }
 
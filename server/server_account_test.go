@@ -615,6 +615,59 @@ func TestAccount_Reservation_AddAdminSuccess(t *testing.T) {
 	})
 }
 
+func TestAccount_Reservation_Provisioned(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		conf := newTestConfigWithAuthFile(t, databaseURL)
+		conf.EnableReservations = true
+		conf.AuthUsers = []*user.User{
+			{Name: "philuser", Hash: "$2a$10$U4WSIYY6evyGmZaraavM2e2JeVG6EMGUKN1uUwufUeeRd4Jpg6cGC", Role: user.RoleUser}, // philuser:philpass
+		}
+		conf.AuthTiers = []*user.Tier{
+			{Code: "pro", Name: "Pro", MessageLimit: 100, ReservationLimit: 2},
+		}
+		conf.AuthReservations = map[string][]*user.Reservation{
+			"philuser": {{Topic: "alerts", Owner: user.PermissionReadWrite, Everyone: user.PermissionRead}},
+		}
+		s := newTestServer(t, conf)
+		defer s.closeDatabases()
+		require.Nil(t, s.userManager.ChangeTier("philuser", "pro"))
+
+		rr := request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Authorization": util.BasicAuth("philuser", "philpass"),
+		})
+		require.Equal(t, 200, rr.Code)
+		account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+		require.Equal(t, "pro", account.Tier.Code)
+		require.Equal(t, 1, len(account.Reservations))
+		require.Equal(t, "alerts", account.Reservations[0].Topic)
+		require.Equal(t, "read-only", account.Reservations[0].Everyone)
+		require.True(t, account.Reservations[0].Provisioned)
+
+		// Provisioned reservations cannot be changed or removed
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"read-write"}`, map[string]string{
+			"Authorization": util.BasicAuth("philuser", "philpass"),
+		})
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40916, toHTTPError(t, rr.Body.String()).Code)
+
+		rr = request(t, s, "DELETE", "/v1/account/reservation/alerts", "", map[string]string{
+			"Authorization": util.BasicAuth("philuser", "philpass"),
+		})
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40916, toHTTPError(t, rr.Body.String()).Code)
+
+		// Other reservations are not affected
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"other","everyone":"deny-all"}`, map[string]string{
+			"Authorization": util.BasicAuth("philuser", "philpass"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/account/reservation/other", "", map[string]string{
+			"Authorization": util.BasicAuth("philuser", "philpass"),
+		})
+		require.Equal(t, 200, rr.Code)
+	})
+}
+
 func TestAccount_Reservation_AddRemoveUserWithTierSuccess(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		conf := newTestConfigWithAuthFile(t, databaseURL)
@@ -779,7 +832,7 @@ func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 		m1 := toMessage(t, rr.Body.String())
 		require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m1.ID))
 
-		rr = request(t, s, "POST", "/mytopic2?f=attach.txt", `Howdy`, map[string]string{
+		rr = request(t, s, "POST", "/mytopic2?f=attach.txt", `Howdy!`, map[string]string{ // Identical files would be shared
 			"Authorization": util.BasicAuth("phil", "mypass"),
 		})
 		require.Equal(t, 200, rr.Code)
@@ -898,3 +951,68 @@ func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
 	require.Equal(t, int64(2), account.Stats.Messages) // Is not reset!
 }*/
+
+func TestAccount_Reservation_GroupSharedManagement(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		conf := newTestConfigWithAuthFile(t, databaseURL)
+		conf.EnableReservations = true
+		s := newTestServer(t, conf)
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+		require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser, false))
+		require.Nil(t, s.userManager.AddUser("noone", "noone", user.RoleUser, false))
+		require.Nil(t, s.userManager.AddGroup("oncall"))
+		require.Nil(t, s.userManager.AddGroupMember("oncall", "ben"))
+		require.Nil(t, s.userManager.AddGroupMember("oncall", "emma"))
+
+		// Members cannot reserve new topics for the group, admins can
+		rr := request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"deny-all","group":"oncall"}`, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 401, rr.Code)
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"deny-all","group":"oncall"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+
+		// Members see the reservation, and can change it
+		rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Authorization": util.BasicAuth("emma", "emma"),
+		})
+		require.Equal(t, 200, rr.Code)
+		account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+		require.Equal(t, []string{"oncall"}, account.Groups)
+		require.Equal(t, 1, len(account.Reservations))
+		require.Equal(t, "alerts", account.Reservations[0].Topic)
+		require.Equal(t, "deny-all", account.Reservations[0].Everyone)
+		require.Equal(t, "oncall", account.Reservations[0].Group)
+
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"read-only","group":"oncall"}`, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+		reservations, err := s.userManager.GroupReservations("oncall")
+		require.Nil(t, err)
+		require.Equal(t, user.PermissionRead, reservations[0].Everyone)
+
+		// Non-members can neither change nor delete it
+		rr = request(t, s, "POST", "/v1/account/reservation", `{"topic":"alerts","everyone":"read-write","group":"oncall"}`, map[string]string{
+			"Authorization": util.BasicAuth("noone", "noone"),
+		})
+		require.Equal(t, 401, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/account/reservation/alerts", "", map[string]string{
+			"Authorization": util.BasicAuth("noone", "noone"),
+		})
+		require.Equal(t, 401, rr.Code)
+
+		// Any member can delete it
+		rr = request(t, s, "DELETE", "/v1/account/reservation/alerts", "", map[string]string{
+			"Authorization": util.BasicAuth("emma", "emma"),
+		})
+		require.Equal(t, 200, rr.Code)
+		reservations, err = s.userManager.GroupReservations("oncall")
+		require.Nil(t, err)
+		require.Empty(t, reservations)
+	})
+}
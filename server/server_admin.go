@@ -3,7 +3,12 @@ package server
 import (
 	"errors"
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/webpush"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
 )
 
 func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -23,6 +28,16 @@ func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visit
 	if err != nil {
 		return err
 	}
+	groups, err := s.userManager.Groups()
+	if err != nil {
+		return err
+	}
+	userGroups := make(map[string][]string)
+	for _, g := range groups {
+		for _, member := range g.Members {
+			userGroups[member] = append(userGroups[member], g.Name)
+		}
+	}
 	usersResponse := make([]*apiUserResponse, len(users))
 	for i, u := range users {
 		tier := ""
@@ -41,6 +56,7 @@ func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visit
 			Role:     string(u.Role),
 			Tier:     tier,
 			Grants:   userGrants,
+			Groups:   userGroups[u.Name],
 		}
 	}
 	return s.writeJSON(w, usersResponse)
@@ -75,10 +91,12 @@ func (s *Server) handleUsersAdd(w http.ResponseWriter, r *http.Request, v *visit
 	if err := s.userManager.AddUser(req.Username, password, user.RoleUser, hashed); err != nil {
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserAdd, req.Username, "", string(user.RoleUser))
 	if tier != nil {
 		if err := s.userManager.ChangeTier(req.Username, req.Tier); err != nil {
 			return err
 		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserTier, req.Username, "", req.Tier)
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
@@ -103,10 +121,12 @@ func (s *Server) handleUsersUpdate(w http.ResponseWriter, r *http.Request, v *vi
 			if err := s.userManager.ChangePassword(req.Username, req.Hash, true); err != nil {
 				return err
 			}
+			s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserPassword, req.Username, "", "")
 		} else if req.Password != "" {
 			if err := s.userManager.ChangePassword(req.Username, req.Password, false); err != nil {
 				return err
 			}
+			s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserPassword, req.Username, "", "")
 		}
 	} else {
 		password, hashed := req.Password, false
@@ -116,6 +136,7 @@ func (s *Server) handleUsersUpdate(w http.ResponseWriter, r *http.Request, v *vi
 		if err := s.userManager.AddUser(req.Username, password, user.RoleUser, hashed); err != nil {
 			return err
 		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserAdd, req.Username, "", string(user.RoleUser))
 	}
 	if req.Tier != "" {
 		if _, err = s.userManager.Tier(req.Tier); errors.Is(err, user.ErrTierNotFound) {
@@ -126,6 +147,11 @@ func (s *Server) handleUsersUpdate(w http.ResponseWriter, r *http.Request, v *vi
 		if err := s.userManager.ChangeTier(req.Username, req.Tier); err != nil {
 			return err
 		}
+		var oldTier string
+		if u != nil && u.Tier != nil {
+			oldTier = u.Tier.Code
+		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserTier, req.Username, oldTier, req.Tier)
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
@@ -146,6 +172,7 @@ func (s *Server) handleUsersDelete(w http.ResponseWriter, r *http.Request, v *vi
 	if err := s.userManager.RemoveUser(req.Username); err != nil {
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionUserRemove, req.Username, string(u.Role), "")
 	if err := s.killUserSubscriber(u, "*"); err != nil { // FIXME super inefficient
 		return err
 	}
@@ -156,6 +183,25 @@ func (s *Server) handleAccessAllow(w http.ResponseWriter, r *http.Request, v *vi
 	req, err := readJSONWithLimit[apiAccessAllowRequest](r.Body, jsonBodyBytesLimit, false)
 	if err != nil {
 		return err
+	} else if (req.Username == "") == (req.Group == "") {
+		return errHTTPBadRequest.Wrap("need to provide exactly one of \"username\" or \"group\"")
+	}
+	permission, err := user.ParsePermission(req.Permission)
+	if err != nil {
+		return errHTTPBadRequestPermissionInvalid
+	}
+	if req.Group != "" {
+		before, err := s.groupGrantValue(req.Group, req.Topic)
+		if errors.Is(err, user.ErrGroupNotFound) {
+			return errHTTPBadRequestGroupNotFound
+		} else if err != nil {
+			return err
+		}
+		if err := s.userManager.AllowGroupAccess(req.Group, req.Topic, permission); err != nil {
+			return err
+		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupAccessAllow, req.Group, before, user.FormatGrant(req.Topic, permission))
+		return s.writeJSON(w, newSuccessResponse())
 	}
 	_, err = s.userManager.User(req.Username)
 	if errors.Is(err, user.ErrUserNotFound) {
@@ -163,13 +209,14 @@ func (s *Server) handleAccessAllow(w http.ResponseWriter, r *http.Request, v *vi
 	} else if err != nil {
 		return err
 	}
-	permission, err := user.ParsePermission(req.Permission)
+	before, err := s.grantValue(req.Username, req.Topic)
 	if err != nil {
-		return errHTTPBadRequestPermissionInvalid
+		return err
 	}
 	if err := s.userManager.AllowAccess(req.Username, req.Topic, permission); err != nil {
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionAccessAllow, req.Username, before, user.FormatGrant(req.Topic, permission))
 	return s.writeJSON(w, newSuccessResponse())
 }
 
@@ -177,20 +224,250 @@ func (s *Server) handleAccessReset(w http.ResponseWriter, r *http.Request, v *vi
 	req, err := readJSONWithLimit[apiAccessResetRequest](r.Body, jsonBodyBytesLimit, false)
 	if err != nil {
 		return err
+	} else if (req.Username == "") == (req.Group == "") {
+		return errHTTPBadRequest.Wrap("need to provide exactly one of \"username\" or \"group\"")
+	}
+	if req.Group != "" {
+		before, err := s.groupGrantValue(req.Group, req.Topic)
+		if errors.Is(err, user.ErrGroupNotFound) {
+			return errHTTPBadRequestGroupNotFound
+		} else if err != nil {
+			return err
+		}
+		if err := s.userManager.ResetGroupAccess(req.Group, req.Topic); err != nil {
+			return err
+		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupAccessReset, req.Group, before, "")
+		if err := s.killGroupSubscribers(req.Group, req.Topic); err != nil {
+			return err
+		}
+		return s.writeJSON(w, newSuccessResponse())
 	}
 	u, err := s.userManager.User(req.Username)
 	if err != nil {
 		return err
 	}
+	before, err := s.grantValue(req.Username, req.Topic)
+	if err != nil {
+		return err
+	}
 	if err := s.userManager.ResetAccess(req.Username, req.Topic); err != nil {
 		return err
 	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionAccessReset, req.Username, before, "")
 	if err := s.killUserSubscriber(u, req.Topic); err != nil { // This may be a pattern
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+func (s *Server) handleGroupsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	groups, err := s.userManager.Groups()
+	if err != nil {
+		return err
+	}
+	groupsResponse := make([]*apiGroupResponse, len(groups))
+	for i, g := range groups {
+		grants, err := s.userManager.GroupGrants(g.Name)
+		if err != nil {
+			return err
+		}
+		reservations, err := s.userManager.GroupReservations(g.Name)
+		if err != nil {
+			return err
+		}
+		groupGrants := make([]*apiUserGrantResponse, len(grants))
+		for i, grant := range grants {
+			groupGrants[i] = &apiUserGrantResponse{
+				Topic:      grant.TopicPattern,
+				Permission: grant.Permission.String(),
+			}
+		}
+		groupReservations := make([]*apiAccountReservation, len(reservations))
+		for i, reservation := range reservations {
+			groupReservations[i] = &apiAccountReservation{
+				Topic:    reservation.Topic,
+				Everyone: reservation.Everyone.String(),
+			}
+		}
+		groupsResponse[i] = &apiGroupResponse{
+			Name:         g.Name,
+			Members:      g.Members,
+			Grants:       groupGrants,
+			Reservations: groupReservations,
+		}
+	}
+	return s.writeJSON(w, groupsResponse)
+}
+
+func (s *Server) handleGroupsAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedGroup(req.Name) {
+		return errHTTPBadRequest.Wrap("group name invalid")
+	}
+	if err := s.userManager.AddGroup(req.Name); errors.Is(err, user.ErrGroupExists) {
+		return errHTTPConflictGroupExists
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupAdd, req.Name, "", "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupsDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveGroup(req.Name); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupRemove, req.Name, strings.Join(group.Members, ","), "")
+	if err := s.killMemberSubscribers(group.Members, "*"); err != nil { // FIXME super inefficient
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupMemberAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMemberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.AddGroupMember(req.Group, req.Username); err != nil {
+		return groupMemberError(err)
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupMemberAdd, req.Group, "", req.Username)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupMemberRemove(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMemberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveGroupMember(req.Group, req.Username); err != nil {
+		return groupMemberError(err)
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupMemberRemove, req.Group, req.Username, "")
+	if err := s.killMemberSubscribers([]string{req.Username}, "*"); err != nil { // FIXME super inefficient
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupReservationAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupReservationRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	everyone, err := user.ParsePermission(req.Everyone)
+	if err != nil {
+		return errHTTPBadRequestPermissionInvalid
+	}
+	if err := s.userManager.AllowGroupReservation(req.Group, req.Topic); errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return errHTTPConflictTopicReserved
+	}
+	if err := s.userManager.AddGroupReservation(req.Group, req.Topic, everyone); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupReservationAdd, req.Group, "", user.FormatGrant(req.Topic, everyone))
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupReservationDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupReservationRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	if err := s.userManager.RemoveGroupReservations(req.Group, req.Topic); errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionGroupReservationRemove, req.Group, req.Topic, "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// grantValue returns the audit log representation of the user's access control entry for exactly
+// the given topic pattern, or an empty string if there is none
+func (s *Server) grantValue(username, topicPattern string) (string, error) {
+	grants, err := s.userManager.Grants(username)
+	if err != nil {
+		return "", err
+	}
+	return findGrantValue(grants, topicPattern), nil
+}
+
+// groupGrantValue is like grantValue, but for an access control entry of a group
+func (s *Server) groupGrantValue(group, topicPattern string) (string, error) {
+	grants, err := s.userManager.GroupGrants(group)
+	if err != nil {
+		return "", err
+	}
+	return findGrantValue(grants, topicPattern), nil
+}
+
+func findGrantValue(grants []user.Grant, topicPattern string) string {
+	for _, grant := range grants {
+		if grant.TopicPattern == topicPattern {
+			return user.FormatGrant(grant.TopicPattern, grant.Permission)
+		}
+	}
+	return ""
+}
+
+func groupMemberError(err error) error {
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, user.ErrInvalidArgument) {
+		return errHTTPBadRequestUserNotFound
+	}
+	return err
+}
+
+// killGroupSubscribers cancels the subscriptions of all members of the group to topics
+// matching the given pattern, so that they re-subscribe with their new permissions
+func (s *Server) killGroupSubscribers(group, topicPattern string) error {
+	g, err := s.userManager.Group(group)
+	if err != nil {
+		return err
+	}
+	if topicPattern == "" {
+		topicPattern = "*"
+	}
+	return s.killMemberSubscribers(g.Members, topicPattern)
+}
+
+func (s *Server) killMemberSubscribers(usernames []string, topicPattern string) error {
+	for _, username := range usernames {
+		u, err := s.userManager.User(username)
+		if errors.Is(err, user.ErrUserNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := s.killUserSubscriber(u, topicPattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	topics, err := s.topicsFromPattern(topicPattern)
 	if err != nil {
@@ -201,3 +478,526 @@ func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	}
 	return nil
 }
+
+func (s *Server) handleTiersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	tiers, err := s.userManager.Tiers()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiTierResponse, len(tiers))
+	for i, tier := range tiers {
+		response[i] = &apiTierResponse{
+			Code: tier.Code,
+			Name: tier.Name,
+			Limits: &apiAccountLimits{
+				Basis:                    string(visitorLimitBasisTier),
+				Messages:                 tier.MessageLimit,
+				MessagesExpiryDuration:   int64(tier.MessageExpiryDuration.Seconds()),
+				Emails:                   tier.EmailLimit,
+				Calls:                    tier.CallLimit,
+				SMS:                      tier.SMSLimit,
+				Reservations:             tier.ReservationLimit,
+				AttachmentTotalSize:      tier.AttachmentTotalSizeLimit,
+				AttachmentFileSize:       tier.AttachmentFileSizeLimit,
+				AttachmentExpiryDuration: int64(tier.AttachmentExpiryDuration.Seconds()),
+				AttachmentBandwidth:      tier.AttachmentBandwidthLimit,
+			},
+			StripeMonthlyPriceID: tier.StripeMonthlyPriceID,
+			StripeYearlyPriceID:  tier.StripeYearlyPriceID,
+			Provisioned:          tier.Provisioned,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleTiersAdd adds a new tier. Limits that are not set in the request are zero.
+func (s *Server) handleTiersAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiTierRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedTier(req.Code) {
+		return errHTTPBadRequest.Wrap("tier code invalid")
+	}
+	if _, err := s.userManager.Tier(req.Code); err == nil {
+		return errHTTPConflictTierExists
+	} else if !errors.Is(err, user.ErrTierNotFound) {
+		return err
+	}
+	tier := &user.Tier{
+		Code: req.Code,
+		Name: req.Code,
+	}
+	if err := updateTierFromRequest(tier, req); err != nil {
+		return err
+	}
+	if err := s.userManager.AddTier(tier); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionTierAdd, tier.Code, "", "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleTiersUpdate updates the fields of a tier that are set in the request. Similar to handleUsersUpdate,
+// the tier is created if it does not exist yet.
+func (s *Server) handleTiersUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiTierRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedTier(req.Code) {
+		return errHTTPBadRequest.Wrap("tier code invalid")
+	}
+	tier, err := s.userManager.Tier(req.Code)
+	if errors.Is(err, user.ErrTierNotFound) {
+		tier = &user.Tier{
+			Code: req.Code,
+			Name: req.Code,
+		}
+		if err := updateTierFromRequest(tier, req); err != nil {
+			return err
+		}
+		if err := s.userManager.AddTier(tier); err != nil {
+			return err
+		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionTierAdd, tier.Code, "", "")
+		return s.writeJSON(w, newSuccessResponse())
+	} else if err != nil {
+		return err
+	}
+	if err := updateTierFromRequest(tier, req); err != nil {
+		return err
+	}
+	if err := s.userManager.UpdateTier(tier); errors.Is(err, user.ErrProvisionedTierChange) {
+		return errHTTPConflictProvisionedTierChange
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionTierChange, tier.Code, "", "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleTiersDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiTierRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if tier, err := s.userManager.Tier(req.Code); errors.Is(err, user.ErrTierNotFound) {
+		return errHTTPBadRequestTierInvalid
+	} else if err != nil {
+		return err
+	} else if tier.Provisioned {
+		return errHTTPConflictProvisionedTierChange
+	}
+	users, err := s.userManager.Users()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.Tier != nil && u.Tier.Code == req.Code {
+			return errHTTPConflictTierInUse
+		}
+	}
+	if err := s.userManager.RemoveTier(req.Code); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionTierRemove, req.Code, "", "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserTokensGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.userForAdminRequest(readParam(r, "x-username", "username"))
+	if err != nil {
+		return err
+	}
+	tokens, err := s.userManager.Tokens(u.ID)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountTokenResponse, len(tokens))
+	for i, t := range tokens {
+		var lastOrigin string
+		if t.LastOrigin != netip.IPv4Unspecified() {
+			lastOrigin = t.LastOrigin.String()
+		}
+		response[i] = &apiAccountTokenResponse{
+			Token:       t.Value,
+			Label:       t.Label,
+			LastAccess:  t.LastAccess.Unix(),
+			LastOrigin:  lastOrigin,
+			Expires:     t.Expires.Unix(),
+			Provisioned: t.Provisioned,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleUserTokenCreate creates a token for another user. Unlike tokens created via the account API,
+// tokens created by admins never expire, unless an expiry time is given.
+func (s *Server) handleUserTokenCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserTokenRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	expires := time.Unix(req.Expires, 0)
+	token, err := s.userManager.CreateToken(u.ID, req.Label, expires, netip.IPv4Unspecified(), false)
+	if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionTokenCreate, u.Name, "", user.FormatToken(token.Value))
+	return s.writeJSON(w, &apiAccountTokenResponse{
+		Token:   token.Value,
+		Label:   token.Label,
+		Expires: token.Expires.Unix(),
+	})
+}
+
+func (s *Server) handleUserTokenDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserTokenRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Token == "" {
+		return errHTTPBadRequestNoTokenProvided
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveToken(u.ID, req.Token); errors.Is(err, user.ErrProvisionedTokenChange) {
+		return errHTTPConflictProvisionedTokenChange
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionTokenRemove, u.Name, user.FormatToken(req.Token), "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserReservationsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.userForAdminRequest(readParam(r, "x-username", "username"))
+	if err != nil {
+		return err
+	}
+	reservations, err := s.userManager.Reservations(u.Name)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountReservation, len(reservations))
+	for i, reservation := range reservations {
+		response[i] = &apiAccountReservation{
+			Topic:       reservation.Topic,
+			Everyone:    reservation.Everyone.String(),
+			Provisioned: reservation.Provisioned,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleUserReservationAdd reserves a topic for a user. Since this is done by an admin, the reservation
+// limit of the user's tier is not enforced, and the user does not need to have a tier.
+func (s *Server) handleUserReservationAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserReservationRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	everyone, err := user.ParsePermission(req.Everyone)
+	if err != nil {
+		return errHTTPBadRequestPermissionInvalid
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.AllowReservation(u.Name, req.Topic); err != nil {
+		return errHTTPConflictTopicReserved
+	}
+	if err := s.userManager.AddReservation(u.Name, req.Topic, everyone, 0); errors.Is(err, user.ErrProvisionedReservationChange) {
+		return errHTTPConflictProvisionedReservationChange
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionReservationAdd, u.Name, "", user.FormatGrant(req.Topic, everyone))
+	t, err := s.topicFromID(v, req.Topic)
+	if err != nil {
+		return err
+	}
+	t.CancelSubscribersExceptUser(u.ID)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserReservationDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserReservationRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if reserved, err := s.userManager.HasReservation(u.Name, req.Topic); err != nil {
+		return err
+	} else if !reserved {
+		return errHTTPBadRequestReservationNotFound
+	}
+	if err := s.userManager.RemoveReservations(u.Name, req.Topic); errors.Is(err, user.ErrProvisionedReservationChange) {
+		return errHTTPConflictProvisionedReservationChange
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionReservationRemove, u.Name, req.Topic, "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserPhoneNumbersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.userForAdminRequest(readParam(r, "x-username", "username"))
+	if err != nil {
+		return err
+	}
+	phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, phoneNumbers)
+}
+
+// handleUserPhoneNumberAdd adds a phone number to a user. The number is added as verified, without
+// sending a verification code.
+func (s *Server) handleUserPhoneNumberAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserPhoneNumberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !phoneNumberRegex.MatchString(req.Number) {
+		return errHTTPBadRequestPhoneNumberInvalid
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.AddPhoneNumber(u.ID, req.Number); errors.Is(err, user.ErrPhoneNumberExists) {
+		return errHTTPConflictPhoneNumberExists
+	} else if err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionPhoneAdd, u.Name, "", req.Number)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserPhoneNumberDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserPhoneNumberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !phoneNumberRegex.MatchString(req.Number) {
+		return errHTTPBadRequestPhoneNumberInvalid
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemovePhoneNumber(u.ID, req.Number); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionPhoneRemove, u.Name, req.Number, "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserEmailsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.userForAdminRequest(readParam(r, "x-username", "username"))
+	if err != nil {
+		return err
+	}
+	emails, err := s.userManager.Emails(u.ID)
+	if err != nil {
+		return err
+	}
+	pendingEmails, err := s.userManager.PendingEmails(u.ID)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountEmailInfo, 0, len(emails)+len(pendingEmails))
+	for _, email := range emails {
+		response = append(response, &apiAccountEmailInfo{Address: email.Address, Primary: email.Primary})
+	}
+	for _, email := range pendingEmails {
+		response = append(response, &apiAccountEmailInfo{Address: email, Pending: true})
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleUserEmailAdd adds an email address to a user. The address is added as verified, without sending
+// a verification link, and is optionally made the user's primary (recovery) email.
+func (s *Server) handleUserEmailAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserEmailRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !emailAddressRegex.MatchString(req.Email) {
+		return errHTTPBadRequestEmailAddressInvalid
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.AddEmail(u.ID, req.Email); errors.Is(err, user.ErrEmailExists) {
+		return errHTTPConflictEmailExists
+	} else if err != nil {
+		return err
+	}
+	if req.Primary {
+		if err := s.userManager.SetPrimaryEmail(u.ID, req.Email); errors.Is(err, user.ErrEmailPrimaryElsewhere) {
+			return errHTTPConflictEmailPrimaryElsewhere
+		} else if err != nil {
+			return err
+		}
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionEmailAdd, u.Name, "", req.Email)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserEmailDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserEmailRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !emailAddressRegex.MatchString(req.Email) {
+		return errHTTPBadRequestEmailAddressInvalid
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveEmail(u.ID, req.Email); err != nil {
+		return err
+	}
+	if err := s.userManager.DeleteEmailVerification(u.ID, req.Email); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionEmailRemove, u.Name, req.Email, "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleUserWebPushGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.userForAdminRequest(readParam(r, "x-username", "username"))
+	if err != nil {
+		return err
+	}
+	subscriptions, err := s.webPush.SubscriptionsForUserID(u.ID)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiUserWebPushSubscriptionResponse, len(subscriptions))
+	for i, subscription := range subscriptions {
+		response[i] = &apiUserWebPushSubscriptionResponse{
+			ID:       subscription.ID,
+			Endpoint: subscription.Endpoint,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleUserWebPushDelete removes a single web push subscription of a user, or all of them if no
+// endpoint is given
+func (s *Server) handleUserWebPushDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserWebPushRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userForAdminRequest(req.Username)
+	if err != nil {
+		return err
+	}
+	if req.Endpoint == "" {
+		if err := s.webPush.RemoveSubscriptionsByUserID(u.ID); err != nil {
+			return err
+		}
+		s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionWebPushRemove, u.Name, "*", "")
+		return s.writeJSON(w, newSuccessResponse())
+	}
+	subscriptions, err := s.webPush.SubscriptionsForUserID(u.ID)
+	if err != nil {
+		return err
+	}
+	found := slices.ContainsFunc(subscriptions, func(subscription *webpush.Subscription) bool {
+		return subscription.Endpoint == req.Endpoint
+	})
+	if !found {
+		return errHTTPBadRequestWebPushEndpointUnknown
+	}
+	if err := s.webPush.RemoveSubscriptionsByEndpoint(req.Endpoint); err != nil {
+		return err
+	}
+	s.audit(r, v, user.AuditSourceAdminAPI, user.AuditActionWebPushRemove, u.Name, req.Endpoint, "")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// userForAdminRequest returns the user with the given username, as referenced in an admin API request
+func (s *Server) userForAdminRequest(username string) (*user.User, error) {
+	if !user.AllowedUsername(username) || username == user.Everyone {
+		return nil, errHTTPBadRequest.Wrap("username invalid")
+	}
+	u, err := s.userManager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil, errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// updateTierFromRequest sets the fields of the tier that are set in the request
+func updateTierFromRequest(tier *user.Tier, req *apiTierRequest) error {
+	if req.Name != nil {
+		tier.Name = *req.Name
+	}
+	if req.StripeMonthlyPriceID != nil {
+		tier.StripeMonthlyPriceID = *req.StripeMonthlyPriceID
+	}
+	if req.StripeYearlyPriceID != nil {
+		tier.StripeYearlyPriceID = *req.StripeYearlyPriceID
+	}
+	if (tier.StripeMonthlyPriceID == "") != (tier.StripeYearlyPriceID == "") {
+		return errHTTPBadRequest.Wrap("stripe_monthly_price_id and stripe_yearly_price_id must either both be set, or neither")
+	}
+	if req.Limits == nil {
+		return nil
+	}
+	limits := req.Limits
+	for _, limit := range []*int64{limits.Messages, limits.MessagesExpiryDuration, limits.Emails, limits.Calls, limits.SMS, limits.Reservations, limits.AttachmentTotalSize, limits.AttachmentFileSize, limits.AttachmentExpiryDuration, limits.AttachmentBandwidth} {
+		if limit != nil && *limit < 0 {
+			return errHTTPBadRequest.Wrap("limits must not be negative")
+		}
+	}
+	if limits.Messages != nil {
+		tier.MessageLimit = *limits.Messages
+	}
+	if limits.MessagesExpiryDuration != nil {
+		tier.MessageExpiryDuration = time.Duration(*limits.MessagesExpiryDuration) * time.Second
+	}
+	if limits.Emails != nil {
+		tier.EmailLimit = *limits.Emails
+	}
+	if limits.Calls != nil {
+		tier.CallLimit = *limits.Calls
+	}
+	if limits.SMS != nil {
+		tier.SMSLimit = *limits.SMS
+	}
+	if limits.Reservations != nil {
+		tier.ReservationLimit = *limits.Reservations
+	}
+	if limits.AttachmentTotalSize != nil {
+		tier.AttachmentTotalSizeLimit = *limits.AttachmentTotalSize
+	}
+	if limits.AttachmentFileSize != nil {
+		tier.AttachmentFileSizeLimit = *limits.AttachmentFileSize
+	}
+	if limits.AttachmentExpiryDuration != nil {
+		tier.AttachmentExpiryDuration = time.Duration(*limits.AttachmentExpiryDuration) * time.Second
+	}
+	if limits.AttachmentBandwidth != nil {
+		tier.AttachmentBandwidthLimit = *limits.AttachmentBandwidth
+	}
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/netip"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -459,3 +462,478 @@ func TestAccess_AllowReset_KillConnection(t *testing.T) {
 		})
 	})
 }
+
+func TestGroup_AddMemberAccessRemove(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthDefault = user.PermissionDenyAll
+		s := newTestServer(t, c)
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+		admin := map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		}
+
+		// Create group, add member, and grant access via API
+		rr := request(t, s, "POST", "/v1/users/groups", `{"name": "oncall"}`, admin)
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "POST", "/v1/users/groups", `{"name": "oncall"}`, admin)
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40912, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "PUT", "/v1/users/groups/members", `{"group": "oncall", "username": "ben"}`, admin)
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/v1/users/groups/members", `{"group": "nope", "username": "ben"}`, admin)
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40071, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "PUT", "/v1/users/access", `{"group": "oncall", "topic": "alerts*", "permission": "rw"}`, admin)
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/v1/users/access", `{"username": "ben", "group": "oncall", "topic": "alerts*", "permission": "rw"}`, admin)
+		require.Equal(t, 400, rr.Code)
+		rr = request(t, s, "POST", "/v1/users/groups/reservations", `{"group": "oncall", "topic": "status", "everyone": "read-only"}`, admin)
+		require.Equal(t, 200, rr.Code)
+
+		// Members inherit the access
+		rr = request(t, s, "PUT", "/alerts-db", "boom", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/status", "all good", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/status", "not good", nil)
+		require.Equal(t, 403, rr.Code)
+
+		// List groups and users
+		rr = request(t, s, "GET", "/v1/users/groups", "", admin)
+		require.Equal(t, 200, rr.Code)
+		groups, err := util.UnmarshalJSON[[]*apiGroupResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		require.Equal(t, 1, len(*groups))
+		group := (*groups)[0]
+		require.Equal(t, "oncall", group.Name)
+		require.Equal(t, []string{"ben"}, group.Members)
+		require.Equal(t, 2, len(group.Grants))
+		require.Equal(t, 1, len(group.Reservations))
+		require.Equal(t, "status", group.Reservations[0].Topic)
+		require.Equal(t, "read-only", group.Reservations[0].Everyone)
+
+		rr = request(t, s, "GET", "/v1/users", "", admin)
+		require.Equal(t, 200, rr.Code)
+		users, err := util.UnmarshalJSON[[]*apiUserResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		require.Equal(t, "ben", (*users)[1].Username)
+		require.Equal(t, []string{"oncall"}, (*users)[1].Groups)
+
+		// Reset group access, remove member and group
+		rr = request(t, s, "DELETE", "/v1/users/access", `{"group": "oncall", "topic": "alerts*"}`, admin)
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/alerts-db", "boom", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 403, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/users/groups/members", `{"group": "oncall", "username": "ben"}`, admin)
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/status", "all good", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 403, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/users/groups", `{"name": "oncall"}`, admin)
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/users/groups", `{"name": "oncall"}`, admin)
+		require.Equal(t, 400, rr.Code)
+		groupsAfter, err := s.userManager.Groups()
+		require.Nil(t, err)
+		require.Empty(t, groupsAfter)
+	})
+}
+
+func TestGroup_NonAdminAttempt(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithAuthFile(t, databaseURL))
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+		rr := request(t, s, "POST", "/v1/users/groups", `{"name": "oncall"}`, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 401, rr.Code)
+		rr = request(t, s, "GET", "/v1/users/groups", "", nil)
+		require.Equal(t, 401, rr.Code)
+	})
+}
+
+func TestAudit_AdminAndAccountChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuditTopic = "ntfy-audit"
+		s := newTestServer(t, c)
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+		// Admin changes an ACL twice, user creates a token
+		rr := request(t, s, "PUT", "/v1/users/access", `{"username": "ben", "topic":"gold", "permission":"ro"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/v1/users/access", `{"username": "ben", "topic":"gold", "permission":"rw"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "POST", "/v1/account/token", `{"label": "backups"}`, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+		token, _ := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+
+		// Only admins can read the audit log
+		rr = request(t, s, "GET", "/v1/audit", "", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 401, rr.Code)
+
+		rr = request(t, s, "GET", "/v1/audit", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		entries, _ := util.UnmarshalJSON[[]*apiAuditEntryResponse](io.NopCloser(rr.Body))
+		require.Equal(t, 3, len(*entries))
+		require.Equal(t, "ben", (*entries)[0].Actor)
+		require.Equal(t, "account-api", (*entries)[0].Source)
+		require.Equal(t, "token.create", (*entries)[0].Action)
+		require.Equal(t, token.Token[:8]+"...", (*entries)[0].After)
+		require.Equal(t, "phil", (*entries)[1].Actor)
+		require.Equal(t, "admin-api", (*entries)[1].Source)
+		require.Equal(t, "access.allow", (*entries)[1].Action)
+		require.Equal(t, "ben", (*entries)[1].Target)
+		require.Equal(t, "gold:read-only", (*entries)[1].Before)
+		require.Equal(t, "gold:read-write", (*entries)[1].After)
+		require.Equal(t, "9.9.9.9", (*entries)[1].Origin)
+		require.Equal(t, "", (*entries)[2].Before)
+
+		// Filters
+		rr = request(t, s, "GET", "/v1/audit?actor=phil&action=access&limit=1", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		entries, _ = util.UnmarshalJSON[[]*apiAuditEntryResponse](io.NopCloser(rr.Body))
+		require.Equal(t, 1, len(*entries))
+		require.Equal(t, "gold:read-write", (*entries)[0].After)
+
+		rr = request(t, s, "GET", "/v1/audit?limit=100000", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+
+		// Entries are mirrored to the audit topic
+		waitFor(t, func() bool {
+			rr = request(t, s, "GET", "/ntfy-audit/json?poll=1", "", map[string]string{
+				"Authorization": util.BasicAuth("phil", "phil"),
+			})
+			return len(toMessages(t, rr.Body.String())) == 3
+		})
+		require.Contains(t, rr.Body.String(), "phil: access.allow of ben (admin-api, 9.9.9.9): gold:read-only -> gold:read-write")
+	})
+}
+
+func TestTier_AdminAddUpdateRemove(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithAuthFile(t, databaseURL))
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+		// Non-admins cannot manage tiers
+		rr := request(t, s, "POST", "/v1/users/tiers", `{"code":"pro"}`, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 401, rr.Code)
+
+		// Add tier
+		rr = request(t, s, "POST", "/v1/users/tiers", `{"code":"pro","name":"Pro","limits":{"messages":1000,"messages_expiry_duration":86400,"reservations":5}}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "POST", "/v1/users/tiers", `{"code":"pro"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40913, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "POST", "/v1/users/tiers", `{"code":"pro-2","stripe_monthly_price_id":"price_123"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+
+		// Update only some fields, or create a tier via PUT
+		rr = request(t, s, "PUT", "/v1/users/tiers", `{"code":"pro","limits":{"emails":20}}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/v1/users/tiers", `{"code":"business","limits":{"messages":5000}}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+
+		rr = request(t, s, "GET", "/v1/users/tiers", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		tiers, _ := util.UnmarshalJSON[[]*apiTierResponse](io.NopCloser(rr.Body))
+		require.Equal(t, 2, len(*tiers))
+		tiersByCode := make(map[string]*apiTierResponse)
+		for _, tier := range *tiers {
+			tiersByCode[tier.Code] = tier
+		}
+		require.Equal(t, "business", tiersByCode["business"].Name)
+		require.Equal(t, int64(5000), tiersByCode["business"].Limits.Messages)
+		require.Equal(t, "Pro", tiersByCode["pro"].Name)
+		require.Equal(t, int64(1000), tiersByCode["pro"].Limits.Messages)
+		require.Equal(t, int64(86400), tiersByCode["pro"].Limits.MessagesExpiryDuration)
+		require.Equal(t, int64(20), tiersByCode["pro"].Limits.Emails)
+		require.Equal(t, int64(5), tiersByCode["pro"].Limits.Reservations)
+
+		// Cannot remove a tier that is in use
+		require.Nil(t, s.userManager.ChangeTier("ben", "pro"))
+		rr = request(t, s, "DELETE", "/v1/users/tiers", `{"code":"pro"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40914, toHTTPError(t, rr.Body.String()).Code)
+		require.Nil(t, s.userManager.ResetTier("ben"))
+		rr = request(t, s, "DELETE", "/v1/users/tiers", `{"code":"pro"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/users/tiers", `{"code":"pro"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40030, toHTTPError(t, rr.Body.String()).Code)
+	})
+}
+
+func TestTier_AdminProvisioned(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		conf := newTestConfigWithAuthFile(t, databaseURL)
+		conf.AuthTiers = []*user.Tier{
+			{Code: "pro", Name: "Pro", MessageLimit: 1000},
+		}
+		s := newTestServer(t, conf)
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+		rr := request(t, s, "GET", "/v1/users/tiers", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		tiers, _ := util.UnmarshalJSON[[]*apiTierResponse](io.NopCloser(rr.Body))
+		require.Equal(t, 1, len(*tiers))
+		require.Equal(t, "pro", (*tiers)[0].Code)
+		require.True(t, (*tiers)[0].Provisioned)
+
+		// Provisioned tiers cannot be changed or removed
+		rr = request(t, s, "PUT", "/v1/users/tiers", `{"code":"pro","limits":{"messages":5000}}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40915, toHTTPError(t, rr.Body.String()).Code)
+		rr = request(t, s, "DELETE", "/v1/users/tiers", `{"code":"pro"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40915, toHTTPError(t, rr.Body.String()).Code)
+
+		tier, err := s.userManager.Tier("pro")
+		require.Nil(t, err)
+		require.Equal(t, int64(1000), tier.MessageLimit)
+	})
+}
+
+func TestUser_AdminTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithAuthFile(t, databaseURL))
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+		rr := request(t, s, "POST", "/v1/users/tokens", `{"username":"ben","label":"backups"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		token, _ := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+		require.Equal(t, "backups", token.Label)
+		require.Equal(t, int64(0), token.Expires)
+
+		// The token works for ben
+		rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+			"Authorization": util.BearerAuth(token.Token),
+		})
+		require.Equal(t, 200, rr.Code)
+
+		rr = request(t, s, "GET", "/v1/users/tokens?username=ben", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		tokens, _ := util.UnmarshalJSON[[]*apiAccountTokenResponse](io.NopCloser(rr.Body))
+		require.Equal(t, 1, len(*tokens))
+		require.Equal(t, token.Token, (*tokens)[0].Token)
+
+		rr = request(t, s, "GET", "/v1/users/tokens?username=nobody", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+
+		rr = request(t, s, "DELETE", "/v1/users/tokens", `{"username":"ben","token":"`+token.Token+`"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+			"Authorization": util.BearerAuth(token.Token),
+		})
+		require.Equal(t, 401, rr.Code)
+	})
+}
+
+func TestUser_AdminReservationsPhoneNumbersEmails(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthDefault = user.PermissionDenyAll
+		s := newTestServer(t, c)
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+		// Reservations, no tier required
+		rr := request(t, s, "POST", "/v1/users/reservations", `{"username":"ben","topic":"alerts","everyone":"read-only"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "GET", "/v1/users/reservations?username=ben", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		reservations, _ := util.UnmarshalJSON[[]*apiAccountReservation](io.NopCloser(rr.Body))
+		require.Equal(t, 1, len(*reservations))
+		require.Equal(t, "alerts", (*reservations)[0].Topic)
+		require.Equal(t, "read-only", (*reservations)[0].Everyone)
+		rr = request(t, s, "PUT", "/alerts", "hi", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+
+		rr = request(t, s, "DELETE", "/v1/users/reservations", `{"username":"ben","topic":"alerts"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "DELETE", "/v1/users/reservations", `{"username":"ben","topic":"alerts"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40072, toHTTPError(t, rr.Body.String()).Code)
+
+		// Phone numbers
+		rr = request(t, s, "POST", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "POST", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 409, rr.Code)
+		rr = request(t, s, "GET", "/v1/users/phone?username=ben", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		require.Equal(t, `["+12223334444"]`, strings.TrimSpace(rr.Body.String()))
+		rr = request(t, s, "DELETE", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+
+		// Emails
+		rr = request(t, s, "POST", "/v1/users/email", `{"username":"ben","email":"ben@example.com","primary":true}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "GET", "/v1/users/email?username=ben", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		emails, _ := util.UnmarshalJSON[[]*apiAccountEmailInfo](io.NopCloser(rr.Body))
+		require.Equal(t, 1, len(*emails))
+		require.Equal(t, "ben@example.com", (*emails)[0].Address)
+		require.True(t, (*emails)[0].Primary)
+		rr = request(t, s, "DELETE", "/v1/users/email", `{"username":"ben","email":"ben@example.com"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		rr = request(t, s, "GET", "/v1/users/email?username=ben", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, "[]", strings.TrimSpace(rr.Body.String()))
+
+		// All changes are in the audit log
+		entries, err := s.userManager.AuditEntries(&user.AuditFilter{Target: "ben"})
+		require.Nil(t, err)
+		require.Equal(t, 6, len(entries))
+		require.Equal(t, user.AuditActionEmailRemove, entries[0].Action)
+		require.Equal(t, user.AuditActionReservationAdd, entries[5].Action)
+	})
+}
+
+func TestUser_AdminWebPush(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		webPushConf := newTestConfigWithWebPush(t, databaseURL)
+		c.WebPushFile = webPushConf.WebPushFile
+		c.WebPushEmailAddress = webPushConf.WebPushEmailAddress
+		c.WebPushPrivateKey = webPushConf.WebPushPrivateKey
+		c.WebPushPublicKey = webPushConf.WebPushPublicKey
+		s := newTestServer(t, c)
+		defer s.closeDatabases()
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+		ben, err := s.userManager.User("ben")
+		require.Nil(t, err)
+		require.Nil(t, s.webPush.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", ben.ID, netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}, nil))
+		require.Nil(t, s.webPush.UpsertSubscription(testWebPushEndpoint+"2", "auth-key", "p256dh-key", ben.ID, netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}, nil))
+		require.Nil(t, s.webPush.UpsertSubscription(testWebPushEndpoint+"3", "auth-key", "p256dh-key", "", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}, nil))
+
+		rr := request(t, s, "GET", "/v1/users/webpush?username=ben", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		subscriptions, _ := util.UnmarshalJSON[[]*apiUserWebPushSubscriptionResponse](io.NopCloser(rr.Body))
+		require.Equal(t, 2, len(*subscriptions))
+		require.Equal(t, testWebPushEndpoint+"1", (*subscriptions)[0].Endpoint)
+
+		// Cannot remove subscriptions of other users
+		rr = request(t, s, "DELETE", "/v1/users/webpush", `{"username":"ben","endpoint":"`+testWebPushEndpoint+`3"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40039, toHTTPError(t, rr.Body.String()).Code)
+
+		rr = request(t, s, "DELETE", "/v1/users/webpush", `{"username":"ben","endpoint":"`+testWebPushEndpoint+`1"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		requireSubscriptionCount(t, s, "test-topic", 2)
+
+		rr = request(t, s, "DELETE", "/v1/users/webpush", `{"username":"ben"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		requireSubscriptionCount(t, s, "test-topic", 1)
+	})
+}
@@ -0,0 +1,644 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/attachment"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Query parameters of signed attachment URLs, e.g. /file/AbCd1234.png?expires=1700000000&signature=...
+const (
+	attachmentURLExpiresParam   = "expires"
+	attachmentURLSignatureParam = "signature"
+)
+
+// attachmentUploadPublishWindow is the time a client has to publish a message referencing a presigned upload
+// after the upload URL expired, e.g. to finish uploading a large file
+const attachmentUploadPublishWindow = 15 * time.Minute
+
+// attachmentUpload is a pending attachment upload via a presigned URL or a resumable upload (see
+// handleAttachmentUploadCreate). It is consumed when a message referencing it is published (see
+// handleBodyAsUploadedAttachment).
+type attachmentUpload struct {
+	topic     string
+	filename  string
+	size      int64
+	resumable bool   // Uploaded in chunks via handleAttachmentUploadChunk, instead of via a presigned URL
+	userID    string // Owner of the upload, if authenticated
+	ip        string // Owner of the upload, if anonymous
+	expires   time.Time
+}
+
+// ownedBy returns true if the upload was created by the given visitor
+func (u *attachmentUpload) ownedBy(v *visitor) bool {
+	if u.userID != "" {
+		return u.userID == v.MaybeUserID()
+	}
+	return v.MaybeUserID() == "" && u.ip == v.IP().String()
+}
+
+// attachmentThumbnailSize is the max. width and height of attachment thumbnails, in pixels
+const attachmentThumbnailSize = 320
+
+// attachmentURL returns the download URL for an uploaded attachment. The URL is stored in the message cache
+// unsigned; see withSignedAttachmentURLs for how it is signed when the message is sent to subscribers.
+func (s *Server) attachmentURL(messageID, ext string) string {
+	return fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, messageID, ext)
+}
+
+// attachmentThumbnailURL returns the URL of the thumbnail of an uploaded attachment
+func (s *Server) attachmentThumbnailURL(messageID, ext string) string {
+	return fmt.Sprintf("%s/file/%s/thumbnail%s", s.config.BaseURL, messageID, ext)
+}
+
+// withSignedAttachmentURLs returns a copy of the message with signed attachment and thumbnail URLs, if an attachment
+// URL secret is configured and the attachment is stored on this server. The signed URL carries an expiry and an
+// HMAC signature, which lets anyone who received the message download the attachment until the URL expires, without
+// having to authenticate (e.g. in an <img> tag or on a phone). URLs are signed whenever a message is rendered for a
+// subscriber rather than when it is published, so that cached messages never carry already expired URLs.
+func (s *Server) withSignedAttachmentURLs(m *model.Message) *model.Message {
+	if s.config.AttachmentURLSecret == "" || m.Attachment == nil || !strings.HasPrefix(m.Attachment.URL, fmt.Sprintf("%s/file/%s", s.config.BaseURL, m.ID)) {
+		return m
+	}
+	attachment := *m.Attachment
+	attachment.URL = s.signAttachmentURL(attachment.URL, attachment.Expires)
+	if attachment.Thumbnail != "" {
+		attachment.Thumbnail = s.signAttachmentURL(attachment.Thumbnail, attachment.Expires)
+	}
+	clone := *m
+	clone.Attachment = &attachment
+	return &clone
+}
+
+// signAttachmentURL appends the expiry and signature to an attachment URL. The URL expires when the attachment
+// expires, or after the attachment URL expiry duration, whichever comes first.
+func (s *Server) signAttachmentURL(fileURL string, attachmentExpires int64) string {
+	fileURL, _, _ = strings.Cut(fileURL, "?") // Messages cached by older versions carry a signature already
+	expires := attachmentExpires
+	if s.config.AttachmentURLExpiryDuration > 0 {
+		if urlExpires := time.Now().Add(s.config.AttachmentURLExpiryDuration).Unix(); urlExpires < expires {
+			expires = urlExpires
+		}
+	}
+	signature := s.attachmentURLSignature(strings.TrimPrefix(fileURL, s.config.BaseURL), expires)
+	return fmt.Sprintf("%s?%s=%d&%s=%s", fileURL, attachmentURLExpiresParam, expires, attachmentURLSignatureParam, signature)
+}
+
+// attachmentURLSignature returns the HMAC-SHA256 signature of the URL path and expiry, base64url-encoded. The
+// path is part of the signature, so that a signed attachment URL cannot be used to download the thumbnail, and
+// vice versa.
+func (s *Server) attachmentURLSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(s.config.AttachmentURLSecret))
+	mac.Write([]byte(fmt.Sprintf("%s:%d", path, expires)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// attachmentURLSigned returns true if the request URL carries a valid, unexpired signature for the request path
+func (s *Server) attachmentURLSigned(r *http.Request) bool {
+	if s.config.AttachmentURLSecret == "" {
+		return false
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get(attachmentURLExpiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	signature := r.URL.Query().Get(attachmentURLSignatureParam)
+	return hmac.Equal([]byte(signature), []byte(s.attachmentURLSignature(r.URL.Path, expires)))
+}
+
+// signedAttachmentRequest returns true if the request is an attachment or thumbnail download with a valid signature.
+// Such requests are authorized by the signature alone (see authorizeAttachmentRead), so they are let through even if
+// the Authorization header is invalid.
+func (s *Server) signedAttachmentRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	} else if !fileRegex.MatchString(r.URL.Path) && !fileThumbnailRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	return s.attachmentURLSigned(r)
+}
+
+// authorizeAttachmentRead checks if the visitor may download the attachment of message m. If the request URL
+// carries a signature, the signature alone decides: a valid one grants access, and an invalid or expired one is
+// rejected, regardless of whether the visitor is authenticated. Otherwise, authenticated users must be allowed to
+// read the message's topic. Anonymous visitors may download any attachment, unless attachment-require-authorization
+// is set, in which case they may only download attachments of topics that everyone can read.
+func (s *Server) authorizeAttachmentRead(r *http.Request, v *visitor, m *model.Message) error {
+	if r.URL.Query().Has(attachmentURLSignatureParam) {
+		if !s.attachmentURLSigned(r) {
+			return errHTTPForbiddenAttachmentURLInvalid.With(m)
+		}
+		return nil
+	}
+	if s.userManager != nil && (v.User() != nil || s.config.AttachmentRequireAuthorization) {
+		if err := s.userManager.Authorize(v.User(), m.Topic, user.PermissionRead); err != nil {
+			logvrm(v, r, m).Err(err).Debug("Access to attachment not authorized")
+			return errHTTPForbidden.With(m)
+		}
+		return nil
+	}
+	if s.config.AttachmentRequireAuthorization {
+		return errHTTPForbiddenAttachmentURLInvalid.With(m)
+	}
+	return nil
+}
+
+// attachmentMessage returns the message of the attachment with the given ID, or a "not found" error
+// if the message does not exist or has no attachment
+func (s *Server) attachmentMessage(messageID string) (*model.Message, error) {
+	m, err := s.messageCache.Message(messageID)
+	if errors.Is(err, model.ErrMessageNotFound) {
+		if s.config.CacheBatchTimeout > 0 {
+			// Strange edge case: If we immediately after upload request the file (the web app does this for images),
+			// and messages are persisted asynchronously, retry fetching from the database
+			m, err = util.Retry(func() (*model.Message, error) {
+				return s.messageCache.Message(messageID)
+			}, s.config.CacheBatchTimeout, 100*time.Millisecond, 300*time.Millisecond, 600*time.Millisecond)
+		}
+		if err != nil {
+			return nil, errHTTPNotFound.Fields(log.Context{
+				"message_id":    messageID,
+				"error_context": "message_cache",
+			})
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if m.Attachment == nil {
+		return nil, errHTTPNotFound.With(m)
+	}
+	return m, nil
+}
+
+// handleFileThumbnail serves the thumbnail of an image attachment (see writeAttachmentThumbnail). Access is checked
+// like for the attachment itself, and the thumbnail size is charged against the uploader's bandwidth.
+func (s *Server) handleFileThumbnail(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := fileThumbnailRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	m, err := s.attachmentMessage(matches[1])
+	if err != nil {
+		return err
+	} else if m.Attachment.Thumbnail == "" {
+		return errHTTPNotFound.With(m)
+	}
+	if err := s.authorizeAttachmentRead(r, v, m); err != nil {
+		return err
+	}
+	etag := fmt.Sprintf(`"%s-thumbnail"`, m.ID)
+	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	reader, size, err := s.attachment.ReadThumbnail(m.Attachment.ObjectID(m.ID))
+	if err != nil {
+		return errHTTPNotFound.With(m).Fields(log.Context{
+			"error_context": "attachment_store",
+		})
+	}
+	defer reader.Close()
+	if matches[2] == "png" {
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		w.Header().Set("Content-Type", "image/jpeg")
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if err := s.chargeAttachmentBandwidth(v, m, size); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+// writeAttachmentThumbnail generates a thumbnail of an image attachment, stores it next to the attachment, and
+// sets the thumbnail URL. Failing to create a thumbnail is not an error; the message is published without one.
+// If the attachment is shared with an identical attachment of another message, its thumbnail is reused.
+func (s *Server) writeAttachmentThumbnail(v *visitor, m *model.Message) {
+	objectID := m.Attachment.ObjectID(m.ID)
+	if m.Attachment.Object != "" {
+		if reader, _, err := s.attachment.ReadThumbnail(objectID); err == nil {
+			reader.Close()
+			ext := ".png"
+			if m.Attachment.Type == "image/jpeg" {
+				ext = ".jpg" // See attachment.Thumbnail
+			}
+			m.Attachment.Thumbnail = s.attachmentThumbnailURL(m.ID, ext)
+			return
+		}
+	}
+	reader, _, err := s.attachment.Read(objectID)
+	if err != nil {
+		logvm(v, m).Tag(tagAttachment).Err(err).Warn("Cannot read attachment to create thumbnail")
+		return
+	}
+	defer reader.Close()
+	thumbnail, ext, err := attachment.Thumbnail(reader, attachmentThumbnailSize)
+	if err != nil {
+		logvm(v, m).Tag(tagAttachment).Err(err).Debug("Cannot create attachment thumbnail")
+		return
+	}
+	if _, err := s.attachment.WriteThumbnail(objectID, bytes.NewReader(thumbnail)); err != nil {
+		logvm(v, m).Tag(tagAttachment).Err(err).Warn("Cannot write attachment thumbnail")
+		return
+	}
+	m.Attachment.Thumbnail = s.attachmentThumbnailURL(m.ID, ext)
+}
+
+// handleFileRedirect redirects an attachment download to a short-lived presigned URL of the attachment backend,
+// so that the file does not have to be proxied through the server. Since the download bypasses the server, the
+// bandwidth is charged when the redirect is issued. HEAD requests are redirected as well, but not charged.
+func (s *Server) handleFileRedirect(w http.ResponseWriter, r *http.Request, v *visitor, m *model.Message, offset, length int64) error {
+	servedSize := m.Attachment.Size
+	if length >= 0 {
+		servedSize = length
+	}
+	if r.Method != http.MethodHead {
+		if err := s.chargeAttachmentBandwidth(v, m, servedSize); err != nil {
+			return err
+		}
+	}
+	presignedURL, err := s.attachment.PresignRead(m.Attachment.ObjectID(m.ID), s.config.AttachmentPresignExpiryDuration, m.Attachment.Name)
+	if err != nil {
+		return err
+	}
+	logvrm(v, r, m).
+		Tag(tagAttachment).
+		Fields(log.Context{
+			"attachment_offset": offset,
+			"attachment_length": length,
+		}).
+		Debug("Redirecting attachment download to presigned URL")
+	http.Redirect(w, r, presignedURL, http.StatusFound)
+	return nil
+}
+
+// chargeAttachmentBandwidth associates the download bandwidth of an attachment to the uploader user. This is an
+// easy way to
+//   - avoid abuse (e.g. 1 uploader, 1k downloaders)
+//   - and also uses the higher bandwidth limits of a paying user
+func (s *Server) chargeAttachmentBandwidth(v *visitor, m *model.Message, size int64) error {
+	bandwidthVisitor := v
+	if s.userManager != nil && m.User != "" {
+		u, err := s.userManager.UserByID(m.User)
+		if err != nil {
+			return err
+		}
+		bandwidthVisitor = s.visitor(v.IP(), u)
+	} else if m.Sender.IsValid() {
+		bandwidthVisitor = s.visitor(m.Sender, nil)
+	}
+	if !bandwidthVisitor.BandwidthAllowed(size) {
+		return errHTTPTooManyRequestsLimitAttachmentBandwidth.With(m)
+	}
+	return nil
+}
+
+// attachmentPresignedUploadEnabled returns true if attachments may be uploaded directly to S3 via presigned URLs
+func (s *Server) attachmentPresignedUploadEnabled() bool {
+	return s.attachment != nil && s.config.BaseURL != "" && s.config.AttachmentPresignedUpload && s.attachment.CanPresign()
+}
+
+// attachmentResumableUploadEnabled returns true if attachments may be uploaded in chunks via resumable uploads
+func (s *Server) attachmentResumableUploadEnabled() bool {
+	return s.attachment != nil && s.config.BaseURL != "" && s.config.AttachmentResumableUpload && s.attachment.CanResumeUploads()
+}
+
+// handleAttachmentUploadCreate starts the upload of a large attachment, and returns the URL to upload it to:
+//   - By default, it hands out a presigned URL that allows uploading the attachment directly to the attachment
+//     backend (S3), instead of streaming it through the server. The upload is charged against the visitor's
+//     bandwidth right away.
+//   - If "resumable" is set, the attachment is uploaded in chunks to the server instead (see
+//     handleAttachmentUploadChunk), so that an upload that was interrupted can be continued where it left off.
+//     Each chunk is charged against the visitor's bandwidth as it is uploaded.
+//
+// In both cases, the upload is checked against the visitor's attachment limits, and counts against them until it
+// is published or expires. Once uploaded, the client publishes a message with the "Upload: <id>" header to attach
+// the file to the message.
+func (s *Server) handleAttachmentUploadCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAttachmentUploadRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil || !topicRegex.MatchString(req.Topic) || req.Size <= 0 || len(req.Filename) > 255 {
+		return errHTTPBadRequestAttachmentUploadInvalid
+	} else if (req.Resumable && !s.attachmentResumableUploadEnabled()) || (!req.Resumable && !s.attachmentPresignedUploadEnabled()) {
+		return errHTTPNotFound
+	}
+	topics, err := s.topicsFromIDs(v, req.Topic)
+	if err != nil {
+		return err
+	}
+	t := topics[0]
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionWrite); err != nil {
+			logvr(v, r).With(t).Err(err).Debug("Access to topic %s not authorized", t.ID)
+			return errHTTPForbidden.With(t)
+		}
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	totalSizeRemaining := vinfo.Stats.AttachmentTotalSizeRemaining - s.pendingAttachmentUploadsSize(v)
+	if req.Size > totalSizeRemaining || req.Size > vinfo.Limits.AttachmentFileSizeLimit || (!req.Resumable && !v.BandwidthAllowed(req.Size)) {
+		return errHTTPEntityTooLargeAttachment.With(t).Fields(log.Context{
+			"attachment_size":                 req.Size,
+			"attachment_total_size_remaining": totalSizeRemaining,
+			"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
+		})
+	}
+	id := model.GenerateMessageID()
+	var uploadURL string
+	var minChunkSize int64
+	var expires, uploadExpires time.Time
+	if req.Resumable {
+		minChunkSize, err = s.attachment.CreateUpload(id, req.Size)
+		uploadURL = fmt.Sprintf("%s%s/%s", s.config.BaseURL, apiAttachmentsUploadPath, id)
+		expires = time.Now().Add(s.config.AttachmentOrphanGracePeriod) // Extended with every chunk
+		uploadExpires = expires
+	} else {
+		uploadURL, err = s.attachment.PresignWrite(id, req.Size, s.config.AttachmentPresignExpiryDuration)
+		expires = time.Now().Add(s.config.AttachmentPresignExpiryDuration)
+		uploadExpires = expires.Add(attachmentUploadPublishWindow)
+	}
+	if errors.Is(err, util.ErrLimitReached) {
+		return errHTTPEntityTooLargeAttachment.With(t)
+	} else if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.attachmentUploads[id] = &attachmentUpload{
+		topic:     t.ID,
+		filename:  req.Filename,
+		size:      req.Size,
+		resumable: req.Resumable,
+		userID:    v.MaybeUserID(),
+		ip:        v.IP().String(),
+		expires:   uploadExpires,
+	}
+	s.mu.Unlock()
+	logvr(v, r).
+		Tag(tagAttachment).
+		With(t).
+		Fields(log.Context{
+			"message_id":           id,
+			"attachment_size":      req.Size,
+			"attachment_resumable": req.Resumable,
+		}).
+		Debug("Created attachment upload")
+	return s.writeJSON(w, &apiAttachmentUploadResponse{
+		ID:           id,
+		UploadURL:    uploadURL,
+		Expires:      expires.Unix(),
+		MinChunkSize: minChunkSize,
+	})
+}
+
+// handleAttachmentUploadChunk appends a chunk to a resumable attachment upload (see handleAttachmentUploadCreate).
+// Like in the tus protocol, the Upload-Offset header must match the current offset of the upload, so that a client
+// whose connection dropped can ask for the offset (see handleAttachmentUploadOffset) and continue from there. A
+// chunk that is not received in full is discarded. Each chunk is charged against the visitor's bandwidth.
+func (s *Server) handleAttachmentUploadChunk(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	id, upload, err := s.attachmentUploadFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 || r.ContentLength <= 0 {
+		return errHTTPBadRequestAttachmentUploadOffsetMissing
+	}
+	newOffset, err := s.attachment.WriteUploadChunk(id, offset, r.Body, r.ContentLength, v.BandwidthLimiter())
+	var infected *attachment.InfectedError
+	if errors.Is(err, attachment.ErrUploadOffsetMismatch) {
+		return errHTTPConflictAttachmentUploadOffset.Fields(log.Context{
+			"message_id":        id,
+			"attachment_offset": offset,
+		})
+	} else if errors.Is(err, attachment.ErrUploadChunkTooSmall) {
+		return errHTTPBadRequestAttachmentUploadChunkTooSmall
+	} else if errors.Is(err, util.ErrLimitReached) {
+		return errHTTPEntityTooLargeAttachment
+	} else if errors.Is(err, attachment.ErrUploadNotFound) {
+		return errHTTPNotFound
+	} else if errors.As(err, &infected) {
+		s.mu.Lock()
+		delete(s.attachmentUploads, id)
+		s.mu.Unlock()
+		return errHTTPBadRequestAttachmentRejected.Fields(log.Context{
+			"message_id":           id,
+			"attachment_signature": infected.Signature,
+		})
+	} else if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	upload.expires = time.Now().Add(s.config.AttachmentOrphanGracePeriod)
+	s.mu.Unlock()
+	logvr(v, r).
+		Tag(tagAttachment).
+		Fields(log.Context{
+			"message_id":        id,
+			"attachment_offset": newOffset,
+			"attachment_size":   upload.size,
+		}).
+		Debug("Received attachment upload chunk")
+	s.writeAttachmentUploadHeaders(w, newOffset, upload.size)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleAttachmentUploadOffset returns the current offset of a resumable attachment upload in the Upload-Offset
+// header, so that a client can continue an interrupted upload
+func (s *Server) handleAttachmentUploadOffset(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	id, _, err := s.attachmentUploadFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	offset, length, err := s.attachment.UploadOffset(id)
+	if errors.Is(err, attachment.ErrUploadNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	s.writeAttachmentUploadHeaders(w, offset, length)
+	return nil
+}
+
+// handleAttachmentUploadDelete cancels a resumable attachment upload, and deletes the chunks uploaded so far
+func (s *Server) handleAttachmentUploadDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	id, _, err := s.attachmentUploadFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	if err := s.attachment.AbortUpload(id); err != nil && !errors.Is(err, attachment.ErrUploadNotFound) {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.attachmentUploads, id)
+	s.mu.Unlock()
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// writeAttachmentUploadHeaders sets the tus-style Upload-Offset and Upload-Length headers, and makes them readable
+// for cross-origin requests (e.g. from the web app)
+func (s *Server) writeAttachmentUploadHeaders(w http.ResponseWriter, offset, length int64) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Access-Control-Expose-Headers", "Upload-Offset, Upload-Length")
+}
+
+// attachmentUploadFromPath returns the ID and the pending resumable upload from the request path. The upload must
+// exist, must not have expired, and must belong to the visitor.
+func (s *Server) attachmentUploadFromPath(r *http.Request, v *visitor) (string, *attachmentUpload, error) {
+	matches := apiAttachmentsUploadSingleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return "", nil, errHTTPInternalErrorInvalidPath
+	}
+	id := matches[1]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	upload, ok := s.attachmentUploads[id]
+	if !ok || !upload.resumable || time.Now().After(upload.expires) || !upload.ownedBy(v) {
+		return "", nil, errHTTPNotFound
+	}
+	return id, upload, nil
+}
+
+// pendingAttachmentUploadsSize returns the total size of the visitor's pending presigned and resumable uploads.
+// Since they are not associated with a message yet, they are not part of the visitor's stats (see visitor.Info),
+// but must still count against the visitor's total attachment size limit.
+func (s *Server) pendingAttachmentUploadsSize(v *visitor) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var size int64
+	for _, upload := range s.attachmentUploads {
+		if upload.ownedBy(v) {
+			size += upload.size
+		}
+	}
+	return size
+}
+
+// handleBodyAsUploadedAttachment attaches a file that was previously uploaded via a presigned URL or a resumable
+// upload to the message (see handleAttachmentUploadCreate). The upload must belong to the same visitor and topic,
+// and must be complete. The message takes over the upload ID, so that the attachment file is associated with
+// the message.
+func (s *Server) handleBodyAsUploadedAttachment(v *visitor, m *model.Message, body *util.PeekedReadCloser, uploadID string) error {
+	if !s.attachmentPresignedUploadEnabled() && !s.attachmentResumableUploadEnabled() {
+		return errHTTPBadRequestAttachmentsDisallowed.With(m)
+	}
+	upload, object := s.takeAttachmentUpload(v, m.Topic, uploadID)
+	if upload == nil {
+		return errHTTPBadRequestAttachmentUploadNotFound.With(m)
+	}
+	size := upload.size
+	if object == nil {
+		var err error
+		size, err = s.attachment.Stat(uploadID)
+		if err != nil || size != upload.size {
+			return errHTTPBadRequestAttachmentUploadNotFound.With(m).Fields(log.Context{
+				"attachment_size":          size,
+				"attachment_expected_size": upload.size,
+			})
+		}
+		// Resumable uploads are scanned when the last chunk is written, presigned uploads bypass the server
+		var infected *attachment.InfectedError
+		if err := s.attachment.Scan(uploadID); errors.As(err, &infected) {
+			return errHTTPBadRequestAttachmentRejected.With(m).Fields(log.Context{
+				"attachment_signature": infected.Signature,
+			})
+		} else if err != nil {
+			return err
+		}
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	attachmentExpiry := time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix()
+	if m.Expires > 0 && attachmentExpiry > m.Expires {
+		attachmentExpiry = m.Expires // Attachment must never outlive the message
+	}
+	if m.Time > attachmentExpiry {
+		return errHTTPBadRequestAttachmentsExpiryBeforeDelivery.With(m)
+	}
+	if m.SequenceID == m.ID {
+		m.SequenceID = uploadID
+	}
+	m.ID = uploadID
+	filename := upload.filename
+	contentType, ext := util.DetectContentType(nil, filename)
+	if filename == "" {
+		filename = fmt.Sprintf("attachment%s", ext)
+	} else if fileExt := filepath.Ext(filename); fileExt != "" && fileExtRegex.MatchString(fileExt) {
+		ext = fileExt
+		if t := mime.TypeByExtension(fileExt); t != "" {
+			contentType = t
+		}
+	}
+	m.Attachment = &model.Attachment{
+		Name:    filename,
+		Type:    contentType,
+		Size:    size,
+		Expires: attachmentExpiry,
+		URL:     s.attachmentURL(m.ID, ext),
+	}
+	if object != nil {
+		m.Attachment.SHA256 = object.SHA256
+		if object.ID != m.ID {
+			m.Attachment.Object = object.ID // Identical to an existing attachment, see Store.FinishUpload
+		}
+		if s.config.AttachmentThumbnails && attachment.ThumbnailSupported(m.Attachment.Type) {
+			s.writeAttachmentThumbnail(v, m)
+		}
+	}
+	return s.handleBodyAsTextMessage(m, body)
+}
+
+// takeAttachmentUpload removes and returns the pending upload with the given ID, if it exists, has not expired,
+// and belongs to the given visitor and topic. It returns nil otherwise. Resumable uploads are only taken if they
+// are complete, so that the client can still finish an incomplete upload; their attachment object is returned.
+func (s *Server) takeAttachmentUpload(v *visitor, topic, id string) (*attachmentUpload, *model.AttachmentObject) {
+	s.mu.Lock()
+	upload, ok := s.attachmentUploads[id]
+	if !ok || upload.topic != topic || time.Now().After(upload.expires) || !upload.ownedBy(v) {
+		s.mu.Unlock()
+		return nil, nil
+	} else if !upload.resumable {
+		delete(s.attachmentUploads, id)
+		s.mu.Unlock()
+		return upload, nil
+	}
+	s.mu.Unlock()
+	// Outside the lock, since this waits for a chunk that is currently being written. Only one
+	// concurrent publisher can finish the upload.
+	object, err := s.attachment.FinishUpload(id)
+	if err != nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	delete(s.attachmentUploads, id)
+	s.mu.Unlock()
+	return upload, object
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	auditMaxLimit = 1000 // Max number of audit log entries returned by GET /v1/audit
+)
+
+// audit appends an entry to the audit log, and publishes it to the audit topic (if configured). The
+// actor is the user of the visitor, if any. Since the change has already been made at this point,
+// errors are only logged, and never fail the request.
+func (s *Server) audit(r *http.Request, v *visitor, source user.AuditSource, action user.AuditAction, target, before, after string) {
+	if s.userManager == nil {
+		return
+	}
+	entry := &user.AuditEntry{
+		Time:   time.Now(),
+		Source: source,
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+		Origin: v.IP(),
+	}
+	if u := v.User(); u != nil {
+		entry.Actor = u.Name
+	}
+	ev := logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{
+			"audit_action": string(action),
+			"audit_source": string(source),
+			"audit_target": target,
+		})
+	if err := s.userManager.AddAuditEntry(entry); err != nil {
+		ev.Err(err).Warn("Unable to write audit log entry")
+		return
+	}
+	ev.Debug("Added audit log entry")
+	if s.config.AuditTopic != "" {
+		go func() {
+			if err := s.publishAuditEntry(v, entry); err != nil {
+				logv(v).Tag(tagAudit).Err(err).Warn("Unable to publish audit log entry to audit topic")
+			}
+		}()
+	}
+}
+
+// publishAuditEntry publishes a human-readable message describing the audit log entry to the audit topic
+func (s *Server) publishAuditEntry(v *visitor, entry *user.AuditEntry) error {
+	t, err := s.topicFromID(nil, s.config.AuditTopic) // internal: no rate limit
+	if err != nil {
+		return err
+	}
+	m := model.NewDefaultMessage(t.ID, formatAuditEntry(entry))
+	m.Title = fmt.Sprintf("Audit: %s %s", entry.Action, entry.Target)
+	m.Tags = []string{"audit", string(entry.Source)}
+	if err := s.addMessageToCache(context.Background(), m); err != nil {
+		return err
+	}
+	return s.dispatch(v, t, m, dispatchOpts{webPush: true})
+}
+
+func (s *Server) handleAuditGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	filter := &user.AuditFilter{
+		Actor:  readParam(r, "x-actor", "actor"),
+		Target: readParam(r, "x-target", "target"),
+		Action: readParam(r, "x-action", "action"),
+	}
+	if limit := readParam(r, "x-limit", "limit"); limit != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > auditMaxLimit {
+			return errHTTPBadRequest.Wrap("limit must be between 1 and %d", auditMaxLimit)
+		}
+	}
+	if since := readParam(r, "x-since", "since"); since != "" {
+		if ts, err := strconv.ParseInt(since, 10, 64); err == nil {
+			filter.Since = time.Unix(ts, 0)
+		} else if d, err := util.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else {
+			return errHTTPBadRequest.Wrap("since must be a Unix timestamp or a duration (e.g. 24h)")
+		}
+	}
+	entries, err := s.userManager.AuditEntries(filter)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAuditEntryResponse, len(entries))
+	for i, entry := range entries {
+		var origin string
+		if entry.Origin.IsValid() {
+			origin = entry.Origin.String()
+		}
+		response[i] = &apiAuditEntryResponse{
+			ID:     entry.ID,
+			Time:   entry.Time.Unix(),
+			Actor:  entry.Actor,
+			Source: string(entry.Source),
+			Action: string(entry.Action),
+			Target: entry.Target,
+			Before: entry.Before,
+			After:  entry.After,
+			Origin: origin,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// formatAuditEntry returns a one-line description of the audit log entry, e.g.
+// "phil: access.allow of ben (admin-api, 1.2.3.4): mytopic:read-only -> mytopic:read-write"
+func formatAuditEntry(entry *user.AuditEntry) string {
+	actor := entry.Actor
+	if actor == "" {
+		actor = "anonymous"
+	}
+	origin := string(entry.Source)
+	if entry.Origin.IsValid() {
+		origin += ", " + entry.Origin.String()
+	}
+	message := fmt.Sprintf("%s: %s of %s (%s)", actor, entry.Action, entry.Target, origin)
+	if entry.Before != "" || entry.After != "" {
+		before, after := entry.Before, entry.After
+		if before == "" {
+			before = "(none)"
+		}
+		if after == "" {
+			after = "(none)"
+		}
+		message += fmt.Sprintf(": %s -> %s", before, after)
+	}
+	return message
+}
@@ -4,18 +4,25 @@ import (
 	"encoding/base64"
 	"errors"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strings"
 	"time"
 
 	"heckel.io/ntfy/v2/user"
 )
 
+const (
+	proxyIdentityProvider = "proxy" // Provider name of users created from the trusted proxy header, see user.Manager.UserByIdentity
+)
+
 // maybeAuthenticate reads the "Authorization" header and will try to authenticate the user
 // if it is set.
 //
 //   - If auth-file is not configured, immediately return an IP-based visitor
 //   - If the header is not set or not supported (anything non-Basic and non-Bearer),
-//     an IP-based visitor is returned
+//     the user is authenticated via the trusted proxy header (see maybeAuthenticateProxy),
+//     or an IP-based visitor is returned
 //   - If the header is set, authenticate will be called to check the username/password (Basic auth),
 //     or the token (Bearer auth), and read the user from the database
 //
@@ -36,14 +43,16 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*http.Request, *visitor, er
 	if err != nil {
 		return r, vip, err
 	} else if !supportedAuthHeader(header) {
-		return r, vip, nil
+		return s.maybeAuthenticateProxy(r, ip, vip)
 	}
 	// If we're trying to auth, check the rate limiter first
 	if !vip.AuthAllowed() {
 		return r, vip, errHTTPTooManyRequestsLimitAuthFailure // Always return visitor, even when error occurs!
 	}
 	u, err := s.authenticate(r, header)
-	if err != nil {
+	if errors.Is(err, errHTTPUnauthorizedTwoFactorRequired) || errors.Is(err, errHTTPForbiddenTwoFactorSetupRequired) {
+		return r, vip, err // Password was correct, but the user must log in with (or set up) a second factor, see checkTwoFactor
+	} else if err != nil {
 		vip.AuthFailed()
 		logr(r).Err(err).Debug("Authentication failed")
 		return r, vip, errHTTPUnauthorized // Always return visitor, even when error occurs!
@@ -52,6 +61,64 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*http.Request, *visitor, er
 	return r, s.visitor(ip, u), nil
 }
 
+// maybeAuthenticateProxy authenticates the user via the auth-proxy-header (e.g. X-Forwarded-User), which
+// is set by an authenticating proxy (e.g. oauth2-proxy or Authelia). The header is only trusted if the request
+// comes directly from one of the trusted proxies (proxy-trusted-hosts), and is ignored otherwise, so that clients
+// cannot bypass the proxy and impersonate users. The Authorization header takes precedence over this header.
+func (s *Server) maybeAuthenticateProxy(r *http.Request, ip netip.Addr, vip *visitor) (*http.Request, *visitor, error) {
+	if s.config.AuthProxyHeader == "" {
+		return r, vip, nil
+	}
+	username := strings.TrimSpace(r.Header.Get(s.config.AuthProxyHeader))
+	if username == "" {
+		return r, vip, nil
+	} else if !s.fromTrustedProxy(r) {
+		logr(r).Debug("Ignoring %s header, request is not from a trusted proxy", s.config.AuthProxyHeader)
+		return r, vip, nil
+	}
+	u, err := s.authenticateProxyUser(r, username)
+	if err != nil {
+		logr(r).Err(err).Debug("Proxy authentication failed")
+		return r, vip, errHTTPUnauthorized // Always return visitor, even when error occurs!
+	}
+	return r, s.visitor(ip, u), nil
+}
+
+// authenticateProxyUser returns the user with the given username, and creates it if it does not exist and
+// auth-proxy-create-users is set. Created users have no password, so they can only log in via the proxy.
+func (s *Server) authenticateProxyUser(r *http.Request, username string) (*user.User, error) {
+	if !user.AllowedUsername(username) {
+		return nil, user.ErrInvalidArgument
+	}
+	u, err := s.userManager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) && s.config.AuthProxyCreateUsers {
+		if err := s.userManager.AddUserWithIdentity(username, user.RoleUser, proxyIdentityProvider, username); err == nil {
+			logr(r).Tag(tagAccount).Info("Created user %s from trusted proxy header", username)
+		} else if !errors.Is(err, user.ErrUserExists) { // User may have been created by a concurrent request
+			return nil, err
+		}
+		u, err = s.userManager.User(username)
+	}
+	if err != nil {
+		return nil, err
+	} else if u.Deleted {
+		return nil, user.ErrUnauthenticated
+	}
+	return u, nil
+}
+
+// fromTrustedProxy returns true if the request comes directly from one of the trusted proxies. Unlike
+// extractIPAddress, this never looks at the forwarded header, since that can be set by anyone.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(s.config.ProxyTrustedPrefixes, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addrPort.Addr().Unmap())
+	})
+}
+
 // authenticate a user based on basic auth username/password (Authorization: Basic ...), or token auth (Authorization: Bearer ...).
 // The Authorization header can be passed as a header or the ?auth=... query param. The latter is required only to
 // support the WebSocket JavaScript class, which does not support passing headers during the initial request. The auth
@@ -94,7 +161,36 @@ func (s *Server) authenticateBasicAuth(r *http.Request, value string) (user *use
 	} else if username == "" {
 		return s.authenticateBearerAuth(r, password) // Treat password as token
 	}
-	return s.userManager.Authenticate(username, password)
+	u, err := s.userManager.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.Path != apiAccountLoginPath {
+		// Users with two-factor authentication can only use their password to log in (with a code), and
+		// must use the resulting access token for everything else. Users whose role requires two-factor
+		// authentication can only use their password to set it up. Access tokens are not affected.
+		if enabled, err := s.userManager.TOTPEnabled(u.ID); err != nil {
+			return nil, err
+		} else if enabled {
+			return nil, errHTTPUnauthorizedTwoFactorRequired
+		} else if slices.Contains(s.config.AuthTwoFactorRequired, u.Role) && !twoFactorSetupRequest(r) {
+			return nil, errHTTPForbiddenTwoFactorSetupRequired
+		}
+	}
+	return u, nil
+}
+
+// twoFactorSetupRequest returns true if the request sets up two-factor authentication (see handleAccount2FASetup
+// and handleAccount2FAEnable), which users whose role requires it must be able to do with their password
+func twoFactorSetupRequest(r *http.Request) bool {
+	return r.URL.Path == apiAccount2FAPath && (r.Method == http.MethodPost || r.Method == http.MethodPut)
+}
+
+// passwordAuth returns true if the request is authenticated with username and password (Basic auth), as
+// opposed to an access token (Bearer auth, or Basic auth with an empty username) or the trusted proxy header
+func passwordAuth(r *http.Request) bool {
+	username, _, ok := r.BasicAuth()
+	return ok && username != ""
 }
 
 func (s *Server) authenticateBearerAuth(r *http.Request, token string) (*user.User, error) {
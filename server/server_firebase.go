@@ -144,7 +144,7 @@ func toFirebaseMessage(m *model.Message, auther user.Auther) (*messaging.Message
 			"poll_id": m.PollID,
 		}
 		apnsConfig = createAPNSAlertConfig(m, data)
-	case model.MessageDeleteEvent, model.MessageClearEvent:
+	case model.MessageDeleteEvent, model.MessageClearEvent, model.MessageAckEvent:
 		data = map[string]string{
 			"id":          m.ID,
 			"time":        fmt.Sprintf("%d", m.Time),
@@ -154,7 +154,12 @@ func toFirebaseMessage(m *model.Message, auther user.Auther) (*messaging.Message
 		}
 		apnsConfig = createAPNSBackgroundConfig(data)
 	case model.MessageEvent:
-		if auther != nil {
+		if m.Encoding == encodingJWE {
+			// End-to-end encrypted messages are opaque to the server, and cannot be displayed or truncated
+			// without breaking them. Instead, we send a "poll_request" message, asking the client to poll.
+			m = toPollRequest(m)
+			m.Encoding = ""
+		} else if auther != nil {
 			// If "anonymous read" for a topic is not allowed, we cannot send the message along
 			// via Firebase. Instead, we send a "poll_request" message, asking the client to poll.
 			//
@@ -194,6 +199,9 @@ func toFirebaseMessage(m *model.Message, auther user.Auther) (*messaging.Message
 			data["attachment_size"] = fmt.Sprintf("%d", m.Attachment.Size)
 			data["attachment_expires"] = fmt.Sprintf("%d", m.Attachment.Expires)
 			data["attachment_url"] = m.Attachment.URL
+			if m.Attachment.Thumbnail != "" {
+				data["attachment_thumbnail"] = m.Attachment.Thumbnail
+			}
 		}
 		if m.PollID != "" {
 			data["poll_id"] = m.PollID
@@ -219,6 +219,26 @@ func TestToFirebaseMessage_Message_Normal_Allowed(t *testing.T) {
 	}, fbm.Data)
 }
 
+func TestToFirebaseMessage_Message_AttachmentThumbnail(t *testing.T) {
+	m := model.NewDefaultMessage("mytopic", "this is a message")
+	m.Attachment = &model.Attachment{
+		Name:      "flower.jpg",
+		Type:      "image/jpeg",
+		Size:      12345,
+		Expires:   98765543,
+		URL:       "https://example.com/file/AbCdEfGhIjKl.jpg",
+		Thumbnail: "https://example.com/file/AbCdEfGhIjKl/thumbnail.jpg",
+	}
+	fbm, err := toFirebaseMessage(m, &testAuther{Allow: true})
+	require.Nil(t, err)
+	require.Equal(t, "https://example.com/file/AbCdEfGhIjKl/thumbnail.jpg", fbm.Data["attachment_thumbnail"])
+
+	m.Attachment.Thumbnail = ""
+	fbm, err = toFirebaseMessage(m, &testAuther{Allow: true})
+	require.Nil(t, err)
+	require.NotContains(t, fbm.Data, "attachment_thumbnail")
+}
+
 func TestToFirebaseMessage_Message_Normal_Not_Allowed(t *testing.T) {
 	m := model.NewDefaultMessage("mytopic", "this is a message")
 	m.Priority = 5
@@ -250,6 +270,21 @@ func TestToFirebaseMessage_Message_Normal_Not_Allowed(t *testing.T) {
 	require.Equal(t, "New message", fbm.APNS.Payload.Aps.Alert.Body)
 }
 
+func TestToFirebaseMessage_Message_Encrypted(t *testing.T) {
+	m := model.NewDefaultMessage("mytopic", "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..AAAAAAAAAAAAAAAA.AA.AAAAAAAAAAAAAAAAAAAAAA")
+	m.Title = "not encrypted"
+	m.Encoding = encodingJWE
+	fbm, err := toFirebaseMessage(m, nil) // Anonymous read allowed, but message is opaque
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", fbm.Topic)
+	require.Equal(t, "poll_request", fbm.Data["event"])
+	require.Equal(t, "New message", fbm.Data["message"])
+	require.Equal(t, "", fbm.Data["encoding"])
+	require.Equal(t, "", fbm.Data["title"])
+	require.Equal(t, m.ID, fbm.Data["poll_id"])
+	require.Equal(t, "New message", fbm.APNS.Payload.Aps.Alert.Body)
+}
+
 func TestToFirebaseMessage_PollRequest(t *testing.T) {
 	m := model.NewPollRequestMessage("mytopic", "fOv6k1QbCzo6")
 	fbm, err := toFirebaseMessage(m, nil)
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"errors"
+	"time"
+
+	"heckel.io/ntfy/v2/attachment"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/metrics"
 	"heckel.io/ntfy/v2/util"
@@ -15,6 +19,10 @@ func (s *Server) execManager() {
 	s.pruneTokens()
 	s.pruneAttachments()
 	s.pruneMessages()
+	s.pruneCallAcks()
+	s.pruneSMSStatuses()
+	s.pruneAttachmentUploads()
+	s.pruneOIDCLogins()
 	s.pruneAndNotifyWebPushSubscriptions()
 
 	// Message count
@@ -179,3 +187,60 @@ func (s *Server) pruneMessages() {
 		}).
 		Debug("Finished deleting expired messages")
 }
+
+func (s *Server) pruneCallAcks() {
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			count, err := s.messageCache.DeleteCallAcks(time.Now().Add(-s.config.CacheDuration))
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error deleting expired call acknowledgements")
+			} else if count > 0 {
+				log.Tag(tagManager).Debug("Deleted %d expired call acknowledgement(s)", count)
+			}
+		}).
+		Debug("Finished deleting expired call acknowledgements")
+}
+
+func (s *Server) pruneSMSStatuses() {
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			count, err := s.messageCache.DeleteSMSStatuses(time.Now().Add(-s.config.CacheDuration))
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error deleting expired SMS delivery statuses")
+			} else if count > 0 {
+				log.Tag(tagManager).Debug("Deleted %d expired SMS delivery status(es)", count)
+			}
+		}).
+		Debug("Finished deleting expired SMS delivery statuses")
+}
+
+func (s *Server) pruneAttachmentUploads() {
+	expiredUploads := 0
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			expiredResumableIDs := make([]string, 0)
+			s.mu.Lock()
+			now := time.Now()
+			for id, upload := range s.attachmentUploads {
+				if now.After(upload.expires) {
+					delete(s.attachmentUploads, id)
+					if upload.resumable {
+						expiredResumableIDs = append(expiredResumableIDs, id)
+					}
+					expiredUploads++
+				}
+			}
+			s.mu.Unlock()
+			// Outside the lock, since aborting may wait for a chunk that is currently being written
+			for _, id := range expiredResumableIDs {
+				if err := s.attachment.AbortUpload(id); err != nil && !errors.Is(err, attachment.ErrUploadNotFound) {
+					log.Tag(tagManager).Field("message_id", id).Err(err).Warn("Error aborting expired attachment upload")
+				}
+			}
+		}).
+		Field("expired_attachment_uploads", expiredUploads).
+		Debug("Finished deleting expired attachment uploads")
+}
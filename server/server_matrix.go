@@ -127,7 +127,7 @@ func newRequestFromMatrixJSON(r *http.Request, baseURL string, messageLimit int)
 	if !strings.HasPrefix(pushKey, baseURL+"/") {
 		return nil, &errMatrixPushkeyRejected{rejectedPushKey: pushKey, configuredBaseURL: baseURL}
 	}
-	newRequest, err := http.NewRequest(http.MethodPost, pushKey, io.NopCloser(bytes.NewReader(body.PeekedBytes)))
+	newRequest, err := http.NewRequestWithContext(r.Context(), http.MethodPost, pushKey, io.NopCloser(bytes.NewReader(body.PeekedBytes)))
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +69,24 @@ func (s *Server) ensureWebPushEnabled(next handleFunc) handleFunc {
 	}
 }
 
+func (s *Server) ensureAttachmentUploadEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !s.attachmentPresignedUploadEnabled() && !s.attachmentResumableUploadEnabled() {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureAttachmentResumableUploadEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !s.attachmentResumableUploadEnabled() {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
 func (s *Server) ensureUserManager(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if s.userManager == nil {
@@ -78,6 +96,15 @@ func (s *Server) ensureUserManager(next handleFunc) handleFunc {
 	}
 }
 
+func (s *Server) ensureOIDCEnabled(next handleFunc) handleFunc {
+	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.oidc == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	})
+}
+
 func (s *Server) ensureUser(next handleFunc) handleFunc {
 	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if v.User() == nil {
@@ -98,7 +125,7 @@ func (s *Server) ensureAdmin(next handleFunc) handleFunc {
 
 func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.config.TwilioAccount == "" || s.userManager == nil {
+		if s.telephony == nil || s.userManager == nil {
 			return errHTTPNotFound
 		}
 		return next(w, r, v)
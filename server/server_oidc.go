@@ -0,0 +1,203 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/oidc"
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	oidcIdentityProvider = "oidc"             // Provider name of linked identities, see user.Manager.UserByIdentity
+	oidcStateCookie      = "ntfy_oidc_state"  // Binds the callback to the browser that started the login (login CSRF)
+	oidcLoginTimeout     = 10 * time.Minute   // Time the user has to log in at the identity provider
+	oidcLoginsLimit      = 10000              // Max. number of pending logins, to limit memory usage
+	oidcCookiePath       = "/v1/account/oidc" // Cookie is only sent to the login and callback endpoints
+)
+
+// oidcLogin is a pending OpenID Connect login, see handleAccountOIDCLogin
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// handleAccountOIDCLogin starts an OpenID Connect login: it remembers the nonce and PKCE code verifier of the
+// authorization request by its state, and redirects the browser to the identity provider. The state is also set
+// as a cookie, so that the callback can only be completed in the same browser.
+func (s *Server) handleAccountOIDCLogin(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	state, nonce, verifier := oidc.NewAuthRequest()
+	authURL, err := s.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		logvr(v, r).Tag(tagOIDC).Err(err).Warn("Cannot start OpenID Connect login")
+		return errHTTPInternalError
+	}
+	s.mu.Lock()
+	if len(s.oidcLogins) >= oidcLoginsLimit {
+		s.mu.Unlock()
+		return errHTTPTooManyRequestsLimitRequests
+	}
+	s.oidcLogins[state] = &oidcLogin{
+		nonce:    nonce,
+		verifier: verifier,
+		expires:  time.Now().Add(oidcLoginTimeout),
+	}
+	s.mu.Unlock()
+	http.SetCookie(w, s.oidcStateCookie(state, int(oidcLoginTimeout.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// handleAccountOIDCCallback completes an OpenID Connect login: it exchanges the authorization code for an ID token,
+// provisions or updates the user (see provisionOIDCUser), and issues a session token. If the web app is enabled,
+// the browser is redirected to the web app's login page, which picks up the token from the URL fragment. Otherwise,
+// the token is returned like in handleAccountLogin.
+func (s *Server) handleAccountOIDCCallback(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	query := r.URL.Query()
+	state := query.Get("state")
+	http.SetCookie(w, s.oidcStateCookie("", -1)) // Always clear the cookie, the state is single-use
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		return errHTTPBadRequestOIDCLoginInvalid
+	}
+	s.mu.Lock()
+	login, ok := s.oidcLogins[state]
+	delete(s.oidcLogins, state)
+	s.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return errHTTPBadRequestOIDCLoginInvalid
+	} else if idpErr := query.Get("error"); idpErr != "" {
+		logvr(v, r).Tag(tagOIDC).Field("oidc_error", idpErr).Info("Identity provider denied login: %s", query.Get("error_description"))
+		return errHTTPUnauthorized
+	}
+	claims, err := s.oidc.Exchange(r.Context(), query.Get("code"), login.verifier, login.nonce)
+	if err != nil {
+		logvr(v, r).Tag(tagOIDC).Err(err).Info("OpenID Connect login failed")
+		return errHTTPUnauthorized
+	}
+	u, err := s.provisionOIDCUser(v, r, claims)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Field("oidc_subject", claims.Subject()).Info("Logging in user %s via OpenID Connect", u.Name)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Now().Add(tokenExpiryDuration), v.IP(), false)
+	if err != nil {
+		return err
+	}
+	if s.config.WebRoot != "" {
+		fragment := url.Values{"token": {token.Value}, "username": {u.Name}}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, webAppLoginPath+"#"+fragment.Encode(), http.StatusFound)
+		return nil
+	}
+	response := &apiAccountLoginResponse{
+		Token:    token.Value,
+		Username: u.Name,
+	}
+	return s.writeJSON(w, response)
+}
+
+// provisionOIDCUser returns the user linked to the subject of the ID token, and creates it on first login, using
+// the configured username claim as username. The user's role, tier and ACL entries are then synced with the groups
+// in the ID token, see syncOIDCUser.
+func (s *Server) provisionOIDCUser(v *visitor, r *http.Request, claims oidc.Claims) (*user.User, error) {
+	u, err := s.userManager.UserByIdentity(oidcIdentityProvider, claims.Subject())
+	if errors.Is(err, user.ErrUserNotFound) {
+		username := claims.String(s.config.AuthOIDCUsernameClaim)
+		if !user.AllowedUsername(username) {
+			logvr(v, r).Tag(tagOIDC).Field("oidc_subject", claims.Subject()).Info("Invalid username claim %s: %s", s.config.AuthOIDCUsernameClaim, username)
+			return nil, errHTTPBadRequestOIDCUsernameInvalid
+		}
+		if err := s.userManager.AddUserWithIdentity(username, user.RoleUser, oidcIdentityProvider, claims.Subject()); errors.Is(err, user.ErrUserExists) {
+			logvr(v, r).Tag(tagOIDC).Field("oidc_subject", claims.Subject()).Info("Cannot create user %s, username is already taken", username)
+			return nil, errHTTPConflictOIDCUserExists
+		} else if err != nil {
+			return nil, err
+		}
+		logvr(v, r).Tag(tagOIDC).Field("oidc_subject", claims.Subject()).Info("Created user %s on first login", username)
+		u, err = s.userManager.User(username)
+	}
+	if err != nil {
+		return nil, err
+	} else if u.Deleted {
+		return nil, errHTTPUnauthorized
+	}
+	if err := s.syncOIDCUser(u, claims.Strings(s.config.AuthOIDCGroupsClaim)); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// syncOIDCUser maps the groups of the user to a role, tier and ACL entries. Each of them is only synced if
+// the corresponding mapping is configured, so that they can also be managed manually (e.g. via "ntfy user").
+func (s *Server) syncOIDCUser(u *user.User, groups []string) error {
+	ev := log.Tag(tagOIDC).Field("user_name", u.Name)
+	if len(s.config.AuthOIDCAdminGroups) > 0 {
+		role := user.RoleUser
+		if slices.ContainsFunc(s.config.AuthOIDCAdminGroups, func(group string) bool { return slices.Contains(groups, group) }) {
+			role = user.RoleAdmin
+		}
+		if role != u.Role {
+			ev.Info("Changing role from %s to %s", u.Role, role)
+			if err := s.userManager.ChangeRole(u.Name, role); err != nil {
+				return err
+			}
+			u.Role = role
+		}
+	}
+	if len(s.config.AuthOIDCTiers) > 0 {
+		var tier string
+		for _, mapping := range s.config.AuthOIDCTiers {
+			if slices.Contains(groups, mapping.Group) {
+				tier = mapping.Tier
+				break
+			}
+		}
+		if tier != "" && (u.Tier == nil || u.Tier.Code != tier) {
+			ev.Info("Changing tier to %s", tier)
+			if err := s.userManager.ChangeTier(u.Name, tier); err != nil {
+				ev.Err(err).Warn("Cannot change tier to %s", tier) // Don't fail the login, e.g. if the tier was deleted
+			}
+		} else if tier == "" && u.Tier != nil {
+			ev.Info("Removing tier %s", u.Tier.Code)
+			if err := s.userManager.ResetTier(u.Name); err != nil {
+				ev.Err(err).Warn("Cannot remove tier %s", u.Tier.Code)
+			}
+		}
+	}
+	if len(s.config.AuthOIDCAccess) > 0 && u.Role != user.RoleAdmin {
+		return s.userManager.SyncGroupAccess(u.Name, groups, s.config.AuthOIDCAccess)
+	}
+	return nil
+}
+
+// oidcStateCookie returns the cookie that binds a pending login to the browser, or a cookie that clears it
+// if maxAge is negative. SameSite=Lax is required, since the callback is a top-level redirect from another site.
+func (s *Server) oidcStateCookie(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (s *Server) pruneOIDCLogins() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for state, login := range s.oidcLogins {
+		if now.After(login.expires) {
+			delete(s.oidcLogins, state)
+		}
+	}
+}
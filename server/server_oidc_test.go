@@ -0,0 +1,227 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/oidc/oidctest"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestAccount_OIDCLogin_Success(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil"})
+		s := newTestServer(t, newTestConfigWithOIDC(t, databaseURL, issuer))
+		defer s.closeDatabases()
+
+		// Web app config advertises SSO
+		rr := request(t, s, "GET", "/config.js", "", nil)
+		require.Contains(t, rr.Body.String(), `"enable_oidc_login": true`)
+
+		// First login creates the user, and redirects to the web app with the token
+		rr = loginWithOIDC(t, s, issuer)
+		require.Equal(t, http.StatusFound, rr.Code)
+		location, err := url.Parse(rr.Header().Get("Location"))
+		require.Nil(t, err)
+		require.Equal(t, "/login", location.Path)
+		fragment, err := url.ParseQuery(location.Fragment)
+		require.Nil(t, err)
+		require.Equal(t, "phil", fragment.Get("username"))
+		require.True(t, strings.HasPrefix(fragment.Get("token"), "tk_"))
+
+		rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Authorization": util.BearerAuth(fragment.Get("token")),
+		})
+		require.Equal(t, 200, rr.Code)
+		account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+		require.Equal(t, "phil", account.Username)
+		require.Equal(t, "user", account.Role)
+
+		// Second login finds the user by subject, even if the username claim changed
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil-renamed"})
+		rr = loginWithOIDC(t, s, issuer)
+		require.Equal(t, http.StatusFound, rr.Code)
+		location, _ = url.Parse(rr.Header().Get("Location"))
+		fragment, _ = url.ParseQuery(location.Fragment)
+		require.Equal(t, "phil", fragment.Get("username"))
+
+		// Users created via OIDC cannot log in with a password
+		rr = request(t, s, "POST", "/v1/account/login", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", ""),
+		})
+		require.Equal(t, 401, rr.Code)
+	})
+}
+
+func TestAccount_OIDCLogin_WebAppDisabled(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil"})
+	conf := newTestConfigWithOIDC(t, "", issuer)
+	conf.WebRoot = ""
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	rr := loginWithOIDC(t, s, issuer)
+	require.Equal(t, 200, rr.Code)
+	response, _ := util.UnmarshalJSON[apiAccountLoginResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "phil", response.Username)
+	require.NotEmpty(t, response.Token)
+}
+
+func TestAccount_OIDCLogin_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t, ""))
+	defer s.closeDatabases()
+
+	rr := request(t, s, "GET", "/v1/account/oidc/login", "", nil)
+	require.Equal(t, 404, rr.Code)
+	rr = request(t, s, "GET", "/config.js", "", nil)
+	require.Contains(t, rr.Body.String(), `"enable_oidc_login": false`)
+}
+
+func TestAccount_OIDCLogin_StateInvalid(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil"})
+	s := newTestServer(t, newTestConfigWithOIDC(t, "", issuer))
+	defer s.closeDatabases()
+
+	rr := request(t, s, "GET", "/v1/account/oidc/login", "", nil)
+	require.Equal(t, http.StatusFound, rr.Code)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.True(t, cookies[0].HttpOnly)
+	callback, err := url.Parse(issuer.Authorize(t, rr.Header().Get("Location")))
+	require.Nil(t, err)
+
+	// Callback without the state cookie, e.g. from another browser (login CSRF)
+	rr = request(t, s, "GET", callback.RequestURI(), "", nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40067, toHTTPError(t, rr.Body.String()).Code)
+
+	// Callback with the wrong state
+	rr = request(t, s, "GET", callback.RequestURI(), "", nil, func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "wrong"})
+	})
+	require.Equal(t, 40067, toHTTPError(t, rr.Body.String()).Code)
+
+	// Correct state works once, but cannot be replayed
+	rr = request(t, s, "GET", callback.RequestURI(), "", nil, func(r *http.Request) { r.AddCookie(cookies[0]) })
+	require.Equal(t, http.StatusFound, rr.Code)
+	rr = request(t, s, "GET", callback.RequestURI(), "", nil, func(r *http.Request) { r.AddCookie(cookies[0]) })
+	require.Equal(t, 40067, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAccount_OIDCLogin_InvalidIDToken(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+	issuer.IDTokenMutator = func(claims map[string]any) { claims["aud"] = "other-client" }
+	s := newTestServer(t, newTestConfigWithOIDC(t, "", issuer))
+	defer s.closeDatabases()
+
+	rr := loginWithOIDC(t, s, issuer)
+	require.Equal(t, 401, rr.Code)
+}
+
+func TestAccount_OIDCLogin_UsernameInvalidOrTaken(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+		s := newTestServer(t, newTestConfigWithOIDC(t, databaseURL, issuer))
+		defer s.closeDatabases()
+
+		// No username claim
+		issuer.SetClaims(map[string]any{"sub": "abc123"})
+		rr := loginWithOIDC(t, s, issuer)
+		require.Equal(t, 40068, toHTTPError(t, rr.Body.String()).Code)
+
+		// Username belongs to a local user; the accounts are not linked automatically
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil"})
+		rr = loginWithOIDC(t, s, issuer)
+		require.Equal(t, 409, rr.Code)
+		require.Equal(t, 40910, toHTTPError(t, rr.Body.String()).Code)
+	})
+}
+
+func TestAccount_OIDCLogin_SyncGroups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		issuer := oidctest.NewIssuer(t, "ntfy", "secret")
+		conf := newTestConfigWithOIDC(t, databaseURL, issuer)
+		conf.AuthOIDCAdminGroups = []string{"ntfy-admins"}
+		conf.AuthOIDCTiers = []*OIDCTierMapping{
+			{Group: "ntfy-pro", Tier: "pro"},
+			{Group: "ntfy-users", Tier: "starter"},
+		}
+		conf.AuthOIDCAccess = map[string][]*user.Grant{
+			"ntfy-users": {{TopicPattern: "alerts", Permission: user.PermissionRead}},
+			"ntfy-ops":   {{TopicPattern: "alerts", Permission: user.PermissionWrite}, {TopicPattern: "ops_*", Permission: user.PermissionReadWrite}},
+		}
+		s := newTestServer(t, conf)
+		defer s.closeDatabases()
+		require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro"}))
+		require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "starter"}))
+
+		// Groups are mapped to tier and ACL entries; permissions of multiple groups are combined
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil", "groups": []string{"ntfy-users", "ntfy-ops", "ntfy-pro"}})
+		require.Equal(t, http.StatusFound, loginWithOIDC(t, s, issuer).Code)
+		u, err := s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Equal(t, user.RoleUser, u.Role)
+		require.Equal(t, "pro", u.Tier.Code)
+		grants, err := s.userManager.Grants("phil")
+		require.Nil(t, err)
+		require.ElementsMatch(t, []user.Grant{
+			{TopicPattern: "alerts", Permission: user.PermissionReadWrite},
+			{TopicPattern: "ops_*", Permission: user.PermissionReadWrite},
+		}, grants)
+
+		// Removed groups revoke access and downgrade the tier on the next login
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil", "groups": []string{"ntfy-users"}})
+		require.Equal(t, http.StatusFound, loginWithOIDC(t, s, issuer).Code)
+		u, err = s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Equal(t, "starter", u.Tier.Code)
+		grants, err = s.userManager.Grants("phil")
+		require.Nil(t, err)
+		require.Equal(t, []user.Grant{{TopicPattern: "alerts", Permission: user.PermissionRead}}, grants)
+
+		// Admin group makes the user an admin, no groups at all removes the tier
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil", "groups": "ntfy-admins"})
+		require.Equal(t, http.StatusFound, loginWithOIDC(t, s, issuer).Code)
+		u, err = s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Equal(t, user.RoleAdmin, u.Role)
+		require.Nil(t, u.Tier)
+
+		issuer.SetClaims(map[string]any{"sub": "abc123", "preferred_username": "phil"})
+		require.Equal(t, http.StatusFound, loginWithOIDC(t, s, issuer).Code)
+		u, err = s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Equal(t, user.RoleUser, u.Role)
+	})
+}
+
+func newTestConfigWithOIDC(t *testing.T, databaseURL string, issuer *oidctest.Issuer) *Config {
+	conf := newTestConfigWithAuthFile(t, databaseURL)
+	conf.AuthOIDCIssuer = issuer.URL
+	conf.AuthOIDCClientID = issuer.ClientID
+	conf.AuthOIDCClientSecret = issuer.ClientSecret
+	return conf
+}
+
+// loginWithOIDC runs a full OpenID Connect login against the mock issuer, and returns the response of the callback
+func loginWithOIDC(t *testing.T, s *Server, issuer *oidctest.Issuer) *httptest.ResponseRecorder {
+	rr := request(t, s, "GET", "/v1/account/oidc/login", "", nil)
+	require.Equal(t, http.StatusFound, rr.Code)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	callback, err := url.Parse(issuer.Authorize(t, rr.Header().Get("Location")))
+	require.Nil(t, err)
+	return request(t, s, "GET", callback.RequestURI(), "", nil, func(r *http.Request) {
+		r.AddCookie(cookies[0])
+	})
+}
@@ -48,6 +48,8 @@ const (
 )
 
 func (s *Server) handleBodyAsTemplatedTextMessage(ctx context.Context, m *model.Message, template templateMode, body *util.PeekedReadCloser, priorityStr string) error {
+	ctx, span := s.tracer.StartMessage(ctx, "template.render", m)
+	defer span.End()
 	body, err := util.Peek(body, max(s.config.MessageSizeLimit, jsonBodyBytesLimit))
 	if err != nil {
 		return err
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	_ "embed"
@@ -9,13 +10,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	"image/jpeg"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -24,11 +29,15 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"golang.org/x/crypto/bcrypt"
 	dbtest "heckel.io/ntfy/v2/db/test"
+	"heckel.io/ntfy/v2/encryption"
+	"heckel.io/ntfy/v2/ldap/ldaptest"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/message"
 	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/tracing"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 )
@@ -1174,6 +1183,130 @@ func TestServer_Auth_NonBasicHeader(t *testing.T) {
 	})
 }
 
+func TestServer_Auth_ProxyHeader(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthDefault = user.PermissionDenyAll
+		c.AuthProxyHeader = "X-Forwarded-User"
+		c.ProxyTrustedPrefixes = []netip.Prefix{netip.MustParsePrefix("9.9.9.0/24")} // request() uses 9.9.9.9
+		s := newTestServer(t, c)
+
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+		response := request(t, s, "PUT", "/mytopic", "test", map[string]string{
+			"X-Forwarded-User": "phil",
+		})
+		require.Equal(t, 200, response.Code)
+
+		// Authorization header takes precedence
+		response = request(t, s, "PUT", "/mytopic", "test", map[string]string{
+			"X-Forwarded-User": "phil",
+			"Authorization":    util.BasicAuth("phil", "wrong"),
+		})
+		require.Equal(t, 401, response.Code)
+
+		// Unknown users are rejected, users are not created by default
+		response = request(t, s, "PUT", "/mytopic", "test", map[string]string{
+			"X-Forwarded-User": "ben",
+		})
+		require.Equal(t, 401, response.Code)
+		_, err := s.userManager.User("ben")
+		require.Equal(t, user.ErrUserNotFound, err)
+	})
+}
+
+func TestServer_Auth_ProxyHeader_UntrustedProxy(t *testing.T) {
+	c := newTestConfigWithAuthFile(t, "")
+	c.AuthDefault = user.PermissionDenyAll
+	c.AuthProxyHeader = "X-Forwarded-User"
+	c.BehindProxy = true
+	c.ProxyForwardedHeader = "X-Forwarded-For"
+	c.ProxyTrustedPrefixes = []netip.Prefix{netip.MustParsePrefix("1.2.3.0/24")}
+	s := newTestServer(t, c)
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	// Header is ignored if the request does not come from a trusted proxy, even if the forwarded header claims so
+	response := request(t, s, "PUT", "/mytopic", "test", map[string]string{
+		"X-Forwarded-User": "phil",
+		"X-Forwarded-For":  "1.2.3.4",
+	})
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_Auth_ProxyHeader_CreateUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthProxyHeader = "Remote-User"
+		c.AuthProxyCreateUsers = true
+		c.ProxyTrustedPrefixes = []netip.Prefix{netip.MustParsePrefix("9.9.9.9/32")}
+		s := newTestServer(t, c)
+
+		response := request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Remote-User": "ben",
+		})
+		require.Equal(t, 200, response.Code)
+		account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+		require.Equal(t, "ben", account.Username)
+		require.Equal(t, "user", account.Role)
+
+		u, err := s.userManager.UserByIdentity(proxyIdentityProvider, "ben")
+		require.Nil(t, err)
+		require.Equal(t, "ben", u.Name)
+
+		// Invalid usernames are rejected
+		response = request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Remote-User": "ben smith",
+		})
+		require.Equal(t, 401, response.Code)
+	})
+}
+
+func TestServer_Auth_LDAP(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		directory := ldaptest.NewServer(t)
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil-pass", map[string][]string{
+			"uid":      {"phil"},
+			"memberOf": {"cn=ntfy-users,ou=groups,dc=example,dc=com"},
+		})
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthDefault = user.PermissionDenyAll
+		c.AuthLDAPURL = directory.URL
+		c.AuthLDAPBaseDN = "dc=example,dc=com"
+		c.AuthLDAPGroupBaseDN = "ou=groups,dc=example,dc=com"
+		c.AuthLDAPAccess = map[string][]*user.Grant{
+			"ntfy-users": {{TopicPattern: "alerts", Permission: user.PermissionReadWrite}},
+		}
+		s := newTestServer(t, c)
+
+		// Password is checked against the directory, access is granted via the group
+		response := request(t, s, "PUT", "/alerts", "test", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil-pass"),
+		})
+		require.Equal(t, 200, response.Code)
+		response = request(t, s, "PUT", "/other", "test", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil-pass"),
+		})
+		require.Equal(t, 403, response.Code)
+		response = request(t, s, "PUT", "/alerts", "test", map[string]string{
+			"Authorization": util.BasicAuth("phil", "wrong"),
+		})
+		require.Equal(t, 401, response.Code)
+
+		// Login issues a local token, which works without the directory
+		response = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil-pass"),
+		})
+		require.Equal(t, 200, response.Code)
+		token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		response = request(t, s, "PUT", "/alerts", "test", map[string]string{
+			"Authorization": util.BearerAuth(token.Token),
+		})
+		require.Equal(t, 200, response.Code)
+	})
+}
+
 func TestServer_StatsResetter(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		t.Parallel()
@@ -2227,6 +2360,75 @@ func TestServer_PublishMarkdown_NotMarkdown(t *testing.T) {
 	})
 }
 
+func TestServer_PublishEncrypted(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfig(t, databaseURL))
+		key, err := encryption.DeriveTopicKey("secret", "http://127.0.0.1:12345/mytopic")
+		require.Nil(t, err)
+		jwe, err := encryption.EncryptJWE([]byte("this is secret"), key)
+		require.Nil(t, err)
+
+		response := request(t, s, "PUT", "/mytopic", jwe, map[string]string{
+			"X-Encoding": "jwe",
+			"Title":      "public title",
+		})
+		require.Equal(t, 200, response.Code)
+		m := toMessage(t, response.Body.String())
+		require.Equal(t, jwe, m.Message)
+		require.Equal(t, "jwe", m.Encoding)
+		require.Equal(t, "public title", m.Title)
+		require.Nil(t, m.Attachment)
+
+		response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+		m = toMessage(t, strings.TrimSpace(response.Body.String()))
+		require.Equal(t, jwe, m.Message)
+		require.Equal(t, "jwe", m.Encoding)
+
+		plaintext, err := encryption.DecryptJWE(m.Message, key)
+		require.Nil(t, err)
+		require.Equal(t, "this is secret", string(plaintext))
+	})
+}
+
+func TestServer_PublishEncrypted_AsJSON(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t, ""))
+	jwe := "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..AAAAAAAAAAAAAAAA.AA.AAAAAAAAAAAAAAAAAAAAAA"
+	response := request(t, s, "PUT", "/", `{"topic":"mytopic","message":"`+jwe+`","encoding":"jwe"}`, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, jwe, m.Message)
+	require.Equal(t, "jwe", m.Encoding)
+}
+
+func TestServer_PublishEncrypted_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t, ""))
+	jwe := "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..AAAAAAAAAAAAAAAA.AA.AAAAAAAAAAAAAAAAAAAAAA"
+
+	response := request(t, s, "PUT", "/mytopic", "not encrypted", map[string]string{"X-Encoding": "jwe"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40062, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", jwe, map[string]string{"X-Encoding": "rot13"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic?tpl=1", jwe, map[string]string{"X-Encoding": "jwe"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40063, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic?up=1", jwe, map[string]string{"X-Encoding": "jwe"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40063, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", jwe, map[string]string{"X-Encoding": "jwe", "Filename": "secret.txt"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40063, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", jwe+strings.Repeat("A", 5000), map[string]string{"X-Encoding": "jwe"})
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41304, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_PublishAsJSON(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		s := newTestServer(t, newTestConfig(t, databaseURL))
@@ -2763,9 +2965,9 @@ func TestServer_PublishAttachmentWithTierBasedLimits(t *testing.T) {
 		require.Equal(t, 413, response.Code)
 		require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
 
-		// Publish large file as phil (4x)
+		// Publish large file as phil (4x, with different contents, since identical files are only counted once)
 		for i := 0; i < 4; i++ {
-			response = request(t, s, "PUT", "/mytopic", largeFile, map[string]string{
+			response = request(t, s, "PUT", "/mytopic", util.RandomString(50_000), map[string]string{
 				"Authorization": util.BasicAuth("phil", "phil"),
 			})
 			require.Equal(t, 200, response.Code)
@@ -2810,6 +3012,459 @@ func TestServer_PublishAttachmentBandwidthLimit(t *testing.T) {
 	})
 }
 
+func TestServer_PublishAttachmentRange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		content := "text file!" + util.RandomString(4990) // > 4096
+		s := newTestServer(t, newTestConfig(t, databaseURL))
+		response := request(t, s, "PUT", "/mytopic", content, nil)
+		msg := toMessage(t, response.Body.String())
+		path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+		// Full download advertises range support and an entity tag
+		response = request(t, s, "GET", path, "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, "bytes", response.Header().Get("Accept-Ranges"))
+		require.Equal(t, `"`+msg.ID+`"`, response.Header().Get("ETag"))
+
+		// Byte range
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Range": "bytes=1000-1999",
+		})
+		require.Equal(t, 206, response.Code)
+		require.Equal(t, "1000", response.Header().Get("Content-Length"))
+		require.Equal(t, "bytes 1000-1999/5000", response.Header().Get("Content-Range"))
+		require.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+		require.Equal(t, content[1000:2000], response.Body.String())
+
+		// Suffix range
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Range": "bytes=-10",
+		})
+		require.Equal(t, 206, response.Code)
+		require.Equal(t, "bytes 4990-4999/5000", response.Header().Get("Content-Range"))
+		require.Equal(t, content[4990:], response.Body.String())
+
+		// HEAD with range
+		response = request(t, s, "HEAD", path, "", map[string]string{
+			"Range": "bytes=4000-",
+		})
+		require.Equal(t, 206, response.Code)
+		require.Equal(t, "1000", response.Header().Get("Content-Length"))
+		require.Equal(t, "", response.Body.String())
+
+		// Unsatisfiable range
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Range": "bytes=5000-",
+		})
+		require.Equal(t, 416, response.Code)
+		require.Equal(t, "bytes */5000", response.Header().Get("Content-Range"))
+		require.Equal(t, 41601, toHTTPError(t, response.Body.String()).Code)
+
+		// If-Range with a different entity tag and multiple ranges are ignored
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Range":    "bytes=0-9",
+			"If-Range": `"someothertag"`,
+		})
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, content, response.Body.String())
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Range": "bytes=0-9,20-29",
+		})
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, content, response.Body.String())
+
+		// If-Range with the same entity tag
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Range":    "bytes=0-9",
+			"If-Range": `"` + msg.ID + `"`,
+		})
+		require.Equal(t, 206, response.Code)
+		require.Equal(t, "text file!", response.Body.String())
+	})
+}
+
+func TestServer_PublishAttachmentRangeContentType(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t, ""))
+	for _, tc := range []struct {
+		name        string
+		content     string
+		contentType string
+	}{
+		{"html", "<html><script>alert(1)</script></html>" + strings.Repeat(" ", 5000), "text/plain; charset=utf-8"},
+		{"binary", "\x00\x01\x02\x03" + strings.Repeat("\x00", 5000), "application/octet-stream"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			response := request(t, s, "PUT", "/mytopic", tc.content, nil)
+			msg := toMessage(t, response.Body.String())
+			path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+			for _, method := range []string{"GET", "HEAD"} {
+				response = request(t, s, method, path, "", map[string]string{
+					"Range": "bytes=6-",
+				})
+				require.Equal(t, 206, response.Code)
+				require.Equal(t, tc.contentType, response.Header().Get("Content-Type"))
+				require.Equal(t, "nosniff", response.Header().Get("X-Content-Type-Options"))
+			}
+		})
+	}
+}
+
+func TestServer_PublishAttachmentIfNoneMatch(t *testing.T) {
+	content := util.RandomString(5000) // > 4096
+	c := newTestConfig(t, "")
+	c.VisitorAttachmentDailyBandwidthLimit = 5000 + 5000 + 500 // Upload, download and a range
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Not modified responses don't count towards the bandwidth limit
+	for i := 0; i < 3; i++ {
+		response = request(t, s, "GET", path, "", map[string]string{
+			"If-None-Match": response.Header().Get("ETag"),
+		})
+		require.Equal(t, 304, response.Code)
+		require.Equal(t, `"`+msg.ID+`"`, response.Header().Get("ETag"))
+		require.Equal(t, "", response.Body.String())
+	}
+
+	// Ranges only count the bytes served
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=4500-",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, content[4500:], response.Body.String())
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=4500-",
+	})
+	require.Equal(t, 429, response.Code)
+}
+
+func TestServer_PublishAttachmentThumbnailAndStripMetadata(t *testing.T) {
+	// JPEG with an EXIF segment that includes a GPS marker
+	var img bytes.Buffer
+	require.Nil(t, jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 800, 600)), nil))
+	exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00GPSLatitude")
+	photo := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00, byte(len(exif) + 2)}, exif...)
+	photo = append(photo, img.Bytes()[2:]...)
+
+	c := newTestConfig(t, "")
+	c.AttachmentStripMetadata = true
+	c.AttachmentThumbnails = true
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic?f=photo.jpg", string(photo), nil)
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, "image/jpeg", msg.Attachment.Type)
+	require.Equal(t, int64(len(photo)-len(exif)-4), msg.Attachment.Size)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+msg.ID+"/thumbnail.jpg", msg.Attachment.Thumbnail)
+
+	// Attachment no longer contains the metadata
+	response = request(t, s, "GET", "/file/"+msg.ID+".jpg", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.NotContains(t, response.Body.String(), "GPSLatitude")
+
+	// Thumbnail
+	response = request(t, s, "GET", "/file/"+msg.ID+"/thumbnail.jpg", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/jpeg", response.Header().Get("Content-Type"))
+	thumbnail, err := jpeg.Decode(response.Body)
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 320, 240), thumbnail.Bounds())
+
+	// Thumbnail is part of the message in the cache
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+msg.ID+"/thumbnail.jpg", toMessage(t, response.Body.String()).Attachment.Thumbnail)
+
+	// No thumbnail for non-images
+	response = request(t, s, "PUT", "/mytopic?f=file.txt", "some text", nil)
+	msg = toMessage(t, response.Body.String())
+	require.Equal(t, "", msg.Attachment.Thumbnail)
+	response = request(t, s, "GET", "/file/"+msg.ID+"/thumbnail.jpg", "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_PublishEncryptedAtRest(t *testing.T) {
+	c := newTestConfig(t, "")
+	c.EncryptionKeyFile = filepath.Join(t.TempDir(), "keys.txt")
+	require.Nil(t, os.WriteFile(c.EncryptionKeyFile, []byte("key1:dGhpcyBpcyBhIDMyIGJ5dGUgdGVzdCBrZXkgIzEhISE=\n"), 0600))
+	s := newTestServer(t, c)
+
+	content := strings.Repeat("secret attachment ", 10000)
+	response := request(t, s, "PUT", "/mytopic?f=secret.txt", content, map[string]string{
+		"Title": "secret title",
+	})
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, int64(len(content)), msg.Attachment.Size)
+
+	// Attachment and message are encrypted at rest
+	raw, err := os.ReadFile(filepath.Join(c.AttachmentCacheDir, msg.ID))
+	require.Nil(t, err)
+	require.NotContains(t, string(raw), "secret attachment")
+	cached, err := message.NewSQLiteStore(c.CacheFile, "", c.CacheDuration, 0, 0, nil, false)
+	require.Nil(t, err)
+	defer cached.Close()
+	rawMessage, err := cached.Message(msg.ID)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(rawMessage.Title, "enc:v1:key1:"))
+
+	// But served decrypted
+	response = request(t, s, "GET", "/file/"+msg.ID+".txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+	response = request(t, s, "GET", "/file/"+msg.ID+".txt", "", map[string]string{
+		"Range": "bytes=100000-100016",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, content[100000:100017], response.Body.String())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, "secret title", toMessage(t, response.Body.String()).Title)
+}
+
+func TestServer_AttachmentPresignedUploadAndRedirect(t *testing.T) {
+	fakeS3 := newTestFakeS3Server(t)
+	c := newTestConfig(t, "")
+	c.AttachmentCacheDir = "s3://AKID:SECRET@mybucket?region=us-east-1&endpoint=" + fakeS3.URL
+	c.AttachmentPresignedUpload = true
+	c.AttachmentRedirect = true
+	c.VisitorAttachmentDailyBandwidthLimit = 600 + 600 + 100 // Upload and one download
+	s := newTestServer(t, c)
+
+	// Request upload URL
+	response := request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","filename":"report.pdf","size":600}`, nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(upload.UploadURL, fakeS3.URL+"/mybucket/"+upload.ID+"?"))
+	require.Greater(t, upload.Expires, time.Now().Unix())
+
+	// Upload directly to S3
+	req, err := http.NewRequest(http.MethodPut, upload.UploadURL, strings.NewReader(util.RandomString(600)))
+	require.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Publish message referencing the upload
+	response = request(t, s, "PUT", "/mytopic", "Here's the report", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, upload.ID, msg.ID)
+	require.Equal(t, "Here's the report", msg.Message)
+	require.Equal(t, "report.pdf", msg.Attachment.Name)
+	require.Equal(t, "application/pdf", msg.Attachment.Type)
+	require.Equal(t, int64(600), msg.Attachment.Size)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+upload.ID+".pdf", msg.Attachment.URL)
+
+	// Upload can only be used once
+	response = request(t, s, "PUT", "/mytopic", "Again", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+
+	// Download is redirected to a presigned URL, and bandwidth is charged at redirect time
+	response = request(t, s, "GET", "/file/"+upload.ID+".pdf", "", nil)
+	require.Equal(t, 302, response.Code)
+	location, err := url.Parse(response.Header().Get("Location"))
+	require.Nil(t, err)
+	require.Equal(t, "/mybucket/"+upload.ID, location.Path)
+	require.Equal(t, `attachment; filename="report.pdf"`, location.Query().Get("response-content-disposition"))
+	require.NotEmpty(t, location.Query().Get("X-Amz-Signature"))
+
+	response = request(t, s, "HEAD", "/file/"+upload.ID+".pdf", "", nil)
+	require.Equal(t, 302, response.Code) // Not charged
+
+	response = request(t, s, "GET", "/file/"+upload.ID+".pdf", "", nil)
+	require.Equal(t, 429, response.Code)
+}
+
+func TestServer_AttachmentPresignedUploadInvalid(t *testing.T) {
+	fakeS3 := newTestFakeS3Server(t)
+	c := newTestConfig(t, "")
+	c.AttachmentCacheDir = "s3://AKID:SECRET@mybucket?region=us-east-1&endpoint=" + fakeS3.URL
+	c.AttachmentPresignedUpload = true
+	c.AttachmentFileSizeLimit = 1000
+	s := newTestServer(t, c)
+
+	// Invalid requests
+	response := request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","filename":"a.txt"}`, nil)
+	require.Equal(t, 40059, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"my/topic","size":10}`, nil)
+	require.Equal(t, 40059, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":1001}`, nil)
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+
+	// Upload never happened
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":10}`, nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+
+	// Upload belongs to a different topic
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":10}`, nil)
+	require.Equal(t, 200, response.Code)
+	upload, err = util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	response = request(t, s, "PUT", "/othertopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentPresignedUploadDisabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t, ""))
+	response := request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":10}`, nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": "abcdefghijkl",
+	})
+	require.Equal(t, 40014, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentResumableUpload(t *testing.T) {
+	c := newTestConfig(t, "")
+	c.AttachmentResumableUpload = true
+	s := newTestServer(t, c)
+
+	// Create upload
+	response := request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","filename":"hello.txt","size":11,"resumable":true}`, nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "http://127.0.0.1:12345/v1/attachments/upload/"+upload.ID, upload.UploadURL)
+	require.Equal(t, int64(0), upload.MinChunkSize)
+	path := "/v1/attachments/upload/" + upload.ID
+
+	// First chunk
+	response = request(t, s, "PATCH", path, "hello ", map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 204, response.Code)
+	require.Equal(t, "6", response.Header().Get("Upload-Offset"))
+
+	// Same chunk again, e.g. because the response was lost
+	response = request(t, s, "PATCH", path, "hello ", map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 409, response.Code)
+	require.Equal(t, 40909, toHTTPError(t, response.Body.String()).Code)
+
+	// Client asks for the offset, and continues from there
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "6", response.Header().Get("Upload-Offset"))
+	require.Equal(t, "11", response.Header().Get("Upload-Length"))
+
+	// Publishing an incomplete upload fails, but does not cancel it
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PATCH", path, "world", map[string]string{
+		"Upload-Offset": "6",
+	})
+	require.Equal(t, 204, response.Code)
+	require.Equal(t, "11", response.Header().Get("Upload-Offset"))
+
+	// Publish message referencing the upload
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, upload.ID, msg.ID)
+	require.Equal(t, "You received a file: hello.txt", msg.Message)
+	require.Equal(t, "text/plain; charset=utf-8", msg.Attachment.Type)
+	require.Equal(t, int64(11), msg.Attachment.Size)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+upload.ID+".txt", msg.Attachment.URL)
+
+	response = request(t, s, "GET", "/file/"+upload.ID+".txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "hello world", response.Body.String())
+
+	// Upload is gone
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentResumableUploadLimits(t *testing.T) {
+	c := newTestConfig(t, "")
+	c.AttachmentResumableUpload = true
+	c.VisitorAttachmentTotalSizeLimit = 100
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":60,"resumable":true}`, nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	path := "/v1/attachments/upload/" + upload.ID
+
+	// Pending upload counts against the visitor's limit
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":50,"resumable":true}`, nil)
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(50), map[string]string{
+		"Filename": "a.txt",
+	})
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+
+	// Chunk beyond the upload size, missing offset, and upload of a different visitor
+	response = request(t, s, "PATCH", path, util.RandomString(61), map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PATCH", path, "abc", nil)
+	require.Equal(t, 40064, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PATCH", path, "abc", map[string]string{
+		"Upload-Offset": "0",
+	}, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4:1234"
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Cancel upload, which frees up the reserved size
+	response = request(t, s, "DELETE", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":50,"resumable":true}`, nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_AttachmentResumableUploadDisabled(t *testing.T) {
+	c := newTestConfig(t, "")
+	c.AttachmentPresignedUpload = true // Not supported by the file backend
+	s := newTestServer(t, c)
+	response := request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":10,"resumable":true}`, nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PATCH", "/v1/attachments/upload/abcdefghijkl", "abc", map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Resumable uploads only
+	c = newTestConfig(t, "")
+	c.AttachmentResumableUpload = true
+	s = newTestServer(t, c)
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":10}`, nil)
+	require.Equal(t, 404, response.Code)
+}
+
 func TestServer_PublishAttachmentBandwidthLimitUploadOnly(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		content := util.RandomString(5000) // > 4096
@@ -2881,6 +3536,300 @@ func TestServer_PublishAttachmentAccountStats(t *testing.T) {
 	})
 }
 
+func TestServer_PublishAttachmentDeduplicated(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		content := util.RandomString(4999) // > 4096
+
+		c := newTestConfig(t, databaseURL)
+		c.AttachmentFileSizeLimit = 5000
+		c.VisitorAttachmentTotalSizeLimit = 10000
+		s := newTestServer(t, c)
+
+		// Upload the same attachment to two topics
+		response := request(t, s, "PUT", "/mytopic", content, nil)
+		msg1 := toMessage(t, response.Body.String())
+		response = request(t, s, "PUT", "/another-topic", content, nil)
+		msg2 := toMessage(t, response.Body.String())
+		require.NotEqual(t, msg1.ID, msg2.ID)
+		require.Equal(t, int64(4999), msg2.Attachment.Size)
+
+		// Both are downloadable, but the file is only stored and counted once
+		for _, msg := range []*model.Message{msg1, msg2} {
+			path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+			response = request(t, s, "GET", path, "", nil)
+			require.Equal(t, 200, response.Code)
+			require.Equal(t, content, response.Body.String())
+		}
+		require.Equal(t, int64(4999), s.attachment.Size())
+		require.NoFileExists(t, filepath.Join(c.AttachmentCacheDir, msg2.ID))
+
+		response = request(t, s, "GET", "/v1/account", "", nil)
+		require.Equal(t, 200, response.Code)
+		account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		require.Equal(t, int64(4999), account.Stats.AttachmentTotalSize)
+		require.Equal(t, int64(5001), account.Stats.AttachmentTotalSizeRemaining)
+
+		// Removing the file of the first message keeps it, since the second message still references it
+		require.Nil(t, s.attachment.Remove(msg1.ID))
+		response = request(t, s, "GET", strings.TrimPrefix(msg2.Attachment.URL, "http://127.0.0.1:12345"), "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, content, response.Body.String())
+	})
+}
+
+func TestServer_PublishAttachmentInfected(t *testing.T) {
+	scanner := newTestScannerServer(t)
+	c := newTestConfig(t, "")
+	c.AttachmentScanURL = scanner.URL
+	c.AttachmentResumableUpload = true
+	s := newTestServer(t, c)
+
+	// Clean attachment
+	response := request(t, s, "PUT", "/mytopic", "this is fine", map[string]string{
+		"Filename": "fine.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	fine := toMessage(t, response.Body.String())
+	require.Equal(t, int64(12), fine.Attachment.Size)
+	require.FileExists(t, filepath.Join(c.AttachmentCacheDir, fine.ID))
+
+	// Infected attachment is rejected, and never dispatched
+	response = request(t, s, "PUT", "/mytopic", "this is EICAR", map[string]string{
+		"Filename": "virus.txt",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40066, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "fine.txt", messages[0].Attachment.Name)
+
+	// Resumable uploads are scanned when the last chunk is written
+	response = request(t, s, "POST", "/v1/attachments/upload", `{"topic":"mytopic","size":13,"resumable":true}`, nil)
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiAttachmentUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	response = request(t, s, "PATCH", "/v1/attachments/upload/"+upload.ID, "this is EICAR", map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 40066, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+
+	// Only the clean file is left in the cache
+	files, err := os.ReadDir(c.AttachmentCacheDir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(files))
+	require.Equal(t, fine.ID, files[0].Name())
+}
+
+func TestServer_PublishAttachmentScannerUnavailable(t *testing.T) {
+	scanner := newTestScannerServer(t)
+	c := newTestConfig(t, "")
+	c.AttachmentScanURL = scanner.URL
+	s := newTestServer(t, c)
+	scanner.Close()
+
+	response := request(t, s, "PUT", "/mytopic", "this is fine", map[string]string{
+		"Filename": "fine.txt",
+	})
+	require.Equal(t, 500, response.Code)
+	files, err := os.ReadDir(c.AttachmentCacheDir)
+	require.Nil(t, err)
+	require.Empty(t, files)
+}
+
+func TestServer_PublishAttachmentSignedURL(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfig(t, databaseURL)
+		c.AttachmentURLSecret = "supersecret"
+		c.AttachmentRequireAuthorization = true
+		s := newTestServer(t, c)
+		response := request(t, s, "PUT", "/mytopic", "some attachment", map[string]string{
+			"Filename": "file.txt",
+		})
+		msg := toMessage(t, response.Body.String())
+		u, err := url.Parse(msg.Attachment.URL)
+		require.Nil(t, err)
+		require.Equal(t, "/file/"+msg.ID+".txt", u.Path)
+		require.Equal(t, fmt.Sprintf("%d", msg.Attachment.Expires), u.Query().Get("expires"))
+
+		// Signed URL works
+		response = request(t, s, "GET", u.RequestURI(), "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, "some attachment", response.Body.String())
+
+		// Unsigned URL, tampered signature and tampered expiry fail
+		response = request(t, s, "GET", u.Path, "", nil)
+		require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+		response = request(t, s, "GET", u.Path+"?expires="+u.Query().Get("expires")+"&signature=invalid", "", nil)
+		require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+		response = request(t, s, "GET", u.Path+"?expires=99999999999&signature="+u.Query().Get("signature"), "", nil)
+		require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+
+		// Expired signature fails
+		expired := time.Now().Add(-time.Minute).Unix()
+		response = request(t, s, "GET", fmt.Sprintf("%s?expires=%d&signature=%s", u.Path, expired, s.attachmentURLSignature(u.Path, expired)), "", nil)
+		require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+
+		// Cache stores the unsigned URL, and polling signs it again
+		cached, err := s.messageCache.Message(msg.ID)
+		require.Nil(t, err)
+		require.Equal(t, "http://127.0.0.1:12345/file/"+msg.ID+".txt", cached.Attachment.URL)
+		response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+		polled := toMessage(t, strings.TrimSpace(response.Body.String()))
+		pu, err := url.Parse(polled.Attachment.URL)
+		require.Nil(t, err)
+		require.Equal(t, u.Path, pu.Path)
+		require.NotEmpty(t, pu.Query().Get("signature"))
+		response = request(t, s, "GET", pu.RequestURI(), "", nil)
+		require.Equal(t, 200, response.Code)
+	})
+}
+
+func TestServer_PublishAttachmentSignedURLWithAuthorization(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthDefault = user.PermissionDenyAll
+		c.AttachmentURLSecret = "supersecret"
+		s := newTestServer(t, c)
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+		require.Nil(t, s.userManager.AllowAccess("phil", "private", user.PermissionReadWrite))
+
+		response := request(t, s, "PUT", "/private?f=secret.txt", "secret attachment", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		msg := toMessage(t, response.Body.String())
+		u, err := url.Parse(msg.Attachment.URL)
+		require.Nil(t, err)
+		invalid := u.Path + "?expires=" + u.Query().Get("expires") + "&signature=invalid"
+
+		// A valid signature is accepted the same way with or without (valid) credentials
+		for _, headers := range []map[string]string{
+			nil,
+			{"Authorization": util.BasicAuth("phil", "phil")},
+			{"Authorization": util.BasicAuth("ben", "ben")}, // No read access to the topic
+			{"Authorization": util.BasicAuth("phil", "wrong")},
+			{"Authorization": "Bearer tk_invalid"},
+		} {
+			response = request(t, s, "GET", u.RequestURI(), "", headers)
+			require.Equal(t, 200, response.Code)
+			require.Equal(t, "secret attachment", response.Body.String())
+			response = request(t, s, "HEAD", u.RequestURI(), "", headers)
+			require.Equal(t, 200, response.Code)
+		}
+
+		// An invalid signature is rejected the same way with or without credentials
+		for _, headers := range []map[string]string{
+			nil,
+			{"Authorization": util.BasicAuth("phil", "phil")},
+			{"Authorization": util.BasicAuth("ben", "ben")},
+		} {
+			response = request(t, s, "GET", invalid, "", headers)
+			require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+		}
+		response = request(t, s, "GET", invalid, "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "wrong"),
+		})
+		require.Equal(t, 401, response.Code)
+	})
+}
+
+func TestServer_PublishAttachmentSignedURLBoundToPath(t *testing.T) {
+	var img bytes.Buffer
+	require.Nil(t, jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 800, 600)), nil))
+
+	c := newTestConfig(t, "")
+	c.AttachmentURLSecret = "supersecret"
+	c.AttachmentRequireAuthorization = true
+	c.AttachmentThumbnails = true
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic?f=photo.jpg", img.String(), nil)
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	u, err := url.Parse(msg.Attachment.URL)
+	require.Nil(t, err)
+	thumbnail, err := url.Parse(msg.Attachment.Thumbnail)
+	require.Nil(t, err)
+	require.Equal(t, "/file/"+msg.ID+"/thumbnail.jpg", thumbnail.Path)
+	require.NotEqual(t, u.Query().Get("signature"), thumbnail.Query().Get("signature"))
+
+	// Each URL works with its own signature
+	response = request(t, s, "GET", u.RequestURI(), "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", thumbnail.RequestURI(), "", nil)
+	require.Equal(t, 200, response.Code)
+
+	// The attachment signature does not open the thumbnail, and vice versa
+	response = request(t, s, "GET", thumbnail.Path+"?"+u.RawQuery, "", nil)
+	require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", u.Path+"?"+thumbnail.RawQuery, "", nil)
+	require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishAttachmentSignedURLExpiryDuration(t *testing.T) {
+	c := newTestConfig(t, "")
+	c.AttachmentURLSecret = "supersecret"
+	c.AttachmentURLExpiryDuration = 10 * time.Minute
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic?f=file.txt", "some attachment", nil)
+	msg := toMessage(t, response.Body.String())
+	u, err := url.Parse(msg.Attachment.URL)
+	require.Nil(t, err)
+	expires, err := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	require.Nil(t, err)
+	require.InDelta(t, time.Now().Add(10*time.Minute).Unix(), expires, 2)
+	require.Less(t, expires, msg.Attachment.Expires)
+}
+
+func TestServer_PublishAttachmentAuthorizeRead(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.AuthDefault = user.PermissionDenyAll
+		s := newTestServer(t, c)
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+		require.Nil(t, s.userManager.AllowAccess("phil", "private", user.PermissionReadWrite))
+		require.Nil(t, s.userManager.AllowAccess(user.Everyone, "public", user.PermissionReadWrite))
+
+		response := request(t, s, "PUT", "/private?f=secret.txt", "secret attachment", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		msg := toMessage(t, response.Body.String())
+		path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+		// Authenticated users must be allowed to read the topic
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, "secret attachment", response.Body.String())
+		response = request(t, s, "GET", path, "", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 403, response.Code)
+
+		// Anonymous downloads are allowed, unless authorization is required
+		response = request(t, s, "GET", path, "", nil)
+		require.Equal(t, 200, response.Code)
+		s.config.AttachmentRequireAuthorization = true
+		response = request(t, s, "GET", path, "", nil)
+		require.Equal(t, 403, response.Code)
+
+		// ... except for topics that everyone can read
+		response = request(t, s, "PUT", "/public?f=public.txt", "public attachment", nil)
+		msg = toMessage(t, response.Body.String())
+		response = request(t, s, "GET", strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345"), "", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, "public attachment", response.Body.String())
+	})
+}
+
 func TestServer_Visitor_XForwardedFor_None(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		c := newTestConfig(t, databaseURL)
@@ -3497,6 +4446,77 @@ func TestServer_UpstreamBaseURL_Success(t *testing.T) {
 	})
 }
 
+func TestServer_UpstreamBaseURL_AfterRequestFinished(t *testing.T) {
+	var pollID atomic.Pointer[string]
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pollID.Store(util.String(r.Header.Get("X-Poll-ID")))
+	}))
+	defer upstreamServer.Close()
+
+	c := newTestConfig(t, "")
+	c.BaseURL = "http://myserver.internal"
+	c.UpstreamBaseURL = upstreamServer.URL
+	s := newTestServer(t, c)
+
+	// Request context is canceled as soon as the response is written, like net/http does
+	ctx, cancel := context.WithCancel(context.Background())
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequestWithContext(ctx, "PUT", "/mytopic", strings.NewReader("hi there"))
+	require.Nil(t, err)
+	req.RemoteAddr = "9.9.9.9:1234"
+	s.handle(rr, req)
+	cancel()
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+
+	// Poll request is still forwarded
+	waitFor(t, func() bool {
+		pID := pollID.Load()
+		return pID != nil && *pID == m.ID
+	})
+}
+
+func TestServer_Tracing_PublishPropagatesTraceparent(t *testing.T) {
+	var traceparent atomic.Pointer[string]
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(util.String(r.Header.Get("traceparent")))
+	}))
+	defer upstreamServer.Close()
+
+	c := newTestConfig(t, "")
+	c.BaseURL = "http://myserver.internal"
+	c.UpstreamBaseURL = upstreamServer.URL
+	s := newTestServer(t, c)
+	exporter := tracetest.NewInMemoryExporter()
+	s.tracer = tracing.NewWithExporter(exporter, "1.2.3")
+
+	// Publish with traceparent, and wait for the upstream server to receive the same trace ID
+	response := request(t, s, "PUT", "/mytopic", `hi there`, map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	waitFor(t, func() bool {
+		tp := traceparent.Load()
+		return tp != nil && *tp != ""
+	})
+	require.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, *traceparent.Load())
+
+	// All spans belong to the caller's trace, and carry the message ID
+	waitFor(t, func() bool {
+		return len(exporter.GetSpans()) == 5
+	})
+	names := make([]string, 0)
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		if span.Name != "HTTP PUT" {
+			require.Contains(t, span.Attributes, tracing.AttributeMessageID.String(m.ID))
+		}
+	}
+	require.ElementsMatch(t, []string{"HTTP PUT", "publish", "topic.publish", "cache.add", "upstream.forward"}, names)
+}
+
 func TestServer_UpstreamBaseURL_With_Access_Token_Success(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		t.Parallel()
@@ -4856,6 +5876,21 @@ func newTestConfigWithAuthFile(t *testing.T, databaseURL string) *Config {
 	return conf
 }
 
+// newTestScannerServer starts an HTTP scanner hook (see attachment.NewScanner) that rejects files containing "EICAR"
+func newTestScannerServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "EICAR") {
+			w.Header().Set("X-Virus-ID", "Eicar-Signature")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
 func newTestServer(t *testing.T, config *Config) *Server {
 	server, err := New(config)
 	require.Nil(t, err)
@@ -5261,3 +6296,39 @@ func TestServer_BanFeed_SuccessfulRequestsNotBanned(t *testing.T) {
 	s.ban.Close() // Flush any buffered bans (there should be none) before asserting no file
 	require.NoFileExists(t, banFile)
 }
+
+// newTestFakeS3Server returns a minimal in-memory S3 server (path-style, no signature checks) that supports
+// PUT, GET, HEAD and DELETE of objects, and an empty object listing
+func newTestFakeS3Server(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+		case http.MethodHead, http.MethodGet:
+			if r.URL.Query().Has("list-type") {
+				w.Header().Set("Content-Type", "application/xml")
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult></ListBucketResult>`)
+				return
+			}
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			if r.Method == http.MethodGet {
+				w.Write(body)
+			}
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
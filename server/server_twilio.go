@@ -1,13 +1,56 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/message"
 	"heckel.io/ntfy/v2/metrics"
 	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/telephony"
+	"heckel.io/ntfy/v2/tracing"
 	"heckel.io/ntfy/v2/twilio"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 )
 
+// createTelephonyProvider creates the provider for phone calls and SMS selected by telephony-provider,
+// or returns nil if the selected provider is not configured
+func createTelephonyProvider(conf *Config) (telephony.Provider, error) {
+	if conf.TelephonyProvider != TelephonyProviderTwilio && conf.TelephonyProvider != TelephonyProviderHTTP {
+		return nil, fmt.Errorf("invalid telephony provider %q, must be %q or %q", conf.TelephonyProvider, TelephonyProviderTwilio, TelephonyProviderHTTP)
+	} else if !conf.TelephonyEnabled() {
+		return nil, nil
+	} else if conf.TelephonyProvider == TelephonyProviderHTTP {
+		return telephony.NewHTTPProvider(&telephony.HTTPConfig{
+			BaseURL:      conf.TelephonyHTTPBaseURL,
+			Token:        conf.TelephonyHTTPToken,
+			PhoneNumber:  conf.TelephonyHTTPPhoneNumber,
+			CallFormat:   conf.TwilioCallFormat,
+			SMSFormat:    conf.TwilioSMSFormat,
+			BuildVersion: conf.BuildVersion,
+		}), nil
+	}
+	return twilio.NewClient(&twilio.Config{
+		Account:         conf.TwilioAccount,
+		AuthToken:       conf.TwilioAuthToken,
+		PhoneNumber:     conf.TwilioPhoneNumber,
+		CallsBaseURL:    conf.TwilioCallsBaseURL,
+		MessagesBaseURL: conf.TwilioMessagesBaseURL,
+		VerifyBaseURL:   conf.TwilioVerifyBaseURL,
+		VerifyService:   conf.TwilioVerifyService,
+		CallFormat:      conf.TwilioCallFormat,
+		SMSFormat:       conf.TwilioSMSFormat,
+		BuildVersion:    conf.BuildVersion,
+	}), nil
+}
+
 // convertPhoneNumber checks if the given phone number is verified for the given user, and if so, returns the verified
 // phone number. It also converts a boolean string ("yes", "1", "true") to the first verified phone number.
 // If the user is anonymous, it will return an error.
@@ -29,26 +72,197 @@ func (s *Server) convertPhoneNumber(u *user.User, phoneNumber string) (string, *
 	return "", errHTTPBadRequestPhoneNumberNotVerified
 }
 
-// callPhone calls the Twilio API to make a phone call to the given phone number, using the given message.
+// callPhone calls the Twilio API to make a phone call to the given phone number, using the given message. If a
+// base URL is configured, the callee can acknowledge the call by pressing a key (see handleTwilioCallAck). Calls
+// to a phone number that already acknowledged the message's sequence ID are skipped.
 // Failures will be logged, but not returned to the caller.
-func (s *Server) callPhone(v *visitor, m *model.Message, to string) {
+func (s *Server) callPhone(ctx context.Context, v *visitor, m *model.Message, to string) {
+	ctx, span := s.tracer.StartMessage(ctx, "telephony.call", m)
+	defer span.End()
+	if acknowledged, err := s.messageCache.CallAcknowledged(m.Topic, m.SequenceID, to); err != nil {
+		logvm(v, m).Tag(tagTwilio).Field("twilio_to", to).Err(err).Warn("Unable to check if call to %s was acknowledged", to)
+	} else if acknowledged {
+		logvm(v, m).Tag(tagTwilio).Field("twilio_to", to).Info("Not calling phone %s, message was already acknowledged", to)
+		return
+	}
 	u, sender := v.User(), m.Sender.String()
 	if u != nil {
 		sender = u.Name
 	}
+	var ackURL string
+	if s.config.BaseURL != "" {
+		params := url.Values{}
+		params.Set("id", m.ID)
+		params.Set("topic", m.Topic)
+		params.Set("sid", m.SequenceID)
+		params.Set("to", to)
+		ackURL = fmt.Sprintf("%s%s?%s", s.config.BaseURL, apiTwilioCallAckPath, params.Encode())
+	}
 	logvm(v, m).Tag(tagTwilio).Field("twilio_to", to).Info("Making phone call to %s", to)
-	err := s.twilio.Call(to, &twilio.CallData{
+	err := s.telephony.Call(ctx, to, &telephony.CallData{
 		Topic:    m.Topic,
 		Title:    m.Title,
 		Message:  m.Message,
 		Priority: m.Priority,
 		Tags:     m.Tags,
 		Sender:   sender,
+		AckURL:   ackURL,
 	})
 	if err != nil {
+		tracing.SetError(span, err)
 		logvm(v, m).Tag(tagTwilio).Field("twilio_to", to).Err(err).Warn("Unable to call phone %s: %v", to, err.Error())
 		metrics.CallsMadeFailure.Inc()
 		return
 	}
 	metrics.CallsMadeSuccess.Inc()
 }
+
+// sendSMS calls the Twilio API to send an SMS to the given phone number, using the given message. If a base URL
+// is configured, Twilio will report the delivery status back to handleTwilioSMSStatus.
+// Failures will be logged, but not returned to the caller.
+func (s *Server) sendSMS(ctx context.Context, v *visitor, m *model.Message, to string) {
+	ctx, span := s.tracer.StartMessage(ctx, "telephony.sms", m)
+	defer span.End()
+	u, sender := v.User(), m.Sender.String()
+	if u != nil {
+		sender = u.Name
+	}
+	var statusCallbackURL string
+	if s.config.BaseURL != "" {
+		statusCallbackURL = fmt.Sprintf("%s%s?id=%s", s.config.BaseURL, apiTwilioSMSStatusPath, url.QueryEscape(m.ID))
+	}
+	logvm(v, m).Tag(tagTwilio).Field("twilio_to", to).Info("Sending SMS to %s", to)
+	err := s.telephony.SMS(ctx, to, &telephony.SMSData{
+		Topic:    m.Topic,
+		Title:    m.Title,
+		Message:  m.Message,
+		Priority: m.Priority,
+		Tags:     m.Tags,
+		Sender:   sender,
+	}, statusCallbackURL)
+	if err != nil {
+		tracing.SetError(span, err)
+		logvm(v, m).Tag(tagTwilio).Field("twilio_to", to).Err(err).Warn("Unable to send SMS to %s: %v", to, err.Error())
+		metrics.SMSSentFailure.Inc()
+		return
+	}
+	metrics.SMSSentSuccess.Inc()
+}
+
+// handleTwilioSMSStatus handles the status callbacks Twilio sends for outgoing SMS messages, and records the status
+// in the message cache (see message.Cache.SMSStatuses). The request is authorized via the X-Twilio-Signature header. Note that the visitor (v) in this endpoint is the Twilio API.
+func (s *Server) handleTwilioSMSStatus(_ http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.config.BaseURL == "" {
+		return errHTTPInternalErrorMissingBaseURL
+	}
+	if err := r.ParseForm(); err != nil {
+		return errHTTPBadRequest
+	}
+	signature := r.Header.Get("X-Twilio-Signature")
+	if signature == "" || !s.telephony.ValidateSignature(s.config.BaseURL+r.URL.RequestURI(), r.PostForm, signature) {
+		return errHTTPUnauthorized
+	}
+	messageID, sid, status := r.URL.Query().Get("id"), r.PostForm.Get("MessageSid"), r.PostForm.Get("MessageStatus")
+	if messageID == "" || sid == "" || status == "" {
+		return errHTTPBadRequest
+	}
+	ev := logvr(v, r).
+		Tag(tagTwilio).
+		Fields(log.Context{
+			"message_id":          messageID,
+			"twilio_message_sid":  sid,
+			"twilio_sms_status":   status,
+			"twilio_sms_error_id": r.PostForm.Get("ErrorCode"),
+		})
+	if err := s.messageCache.AddSMSStatus(&message.SMSStatus{
+		MessageID:   messageID,
+		SID:         sid,
+		PhoneNumber: r.PostForm.Get("To"),
+		Status:      status,
+		ErrorCode:   r.PostForm.Get("ErrorCode"),
+		Time:        time.Now().Unix(),
+	}); err != nil {
+		return err
+	}
+	switch status {
+	case "delivered":
+		ev.Debug("SMS %s delivered", sid)
+		metrics.SMSDeliveredSuccess.Inc()
+	case "failed", "undelivered":
+		ev.Warn("SMS %s not delivered, status %s", sid, status)
+		metrics.SMSDeliveredFailure.Inc()
+	default:
+		ev.Trace("SMS %s status changed to %s", sid, status)
+	}
+	return nil
+}
+
+// handleTwilioCallAck handles the keypress Twilio sends when the callee presses a key during a phone call (see
+// callPhone). If the callee pressed telephony.CallAckDigit, the message is marked as acknowledged for the phone
+// number, and a message_ack event is published to the topic. The request is authorized via the X-Twilio-Signature
+// header. Note that the visitor (v) in this endpoint is the Twilio API.
+func (s *Server) handleTwilioCallAck(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.config.BaseURL == "" {
+		return errHTTPInternalErrorMissingBaseURL
+	}
+	if err := r.ParseForm(); err != nil {
+		return errHTTPBadRequest
+	}
+	signature := r.Header.Get("X-Twilio-Signature")
+	if signature == "" || !s.telephony.ValidateSignature(s.config.BaseURL+r.URL.RequestURI(), r.PostForm, signature) {
+		return errHTTPUnauthorized
+	}
+	query := r.URL.Query()
+	messageID, topicID, sequenceID, to := query.Get("id"), query.Get("topic"), query.Get("sid"), query.Get("to")
+	if !topicRegex.MatchString(topicID) || sequenceID == "" || to == "" {
+		return errHTTPBadRequest
+	}
+	ev := logvr(v, r).
+		Tag(tagTwilio).
+		Fields(log.Context{
+			"message_id":          messageID,
+			"message_topic":       topicID,
+			"message_sequence_id": sequenceID,
+			"twilio_to":           to,
+		})
+	if r.PostForm.Get("Digits") != telephony.CallAckDigit {
+		ev.Debug("Call to %s not acknowledged, callee pressed %q", to, r.PostForm.Get("Digits"))
+		return writeTwiML(w, "Message not acknowledged. Goodbye.")
+	}
+	t, err := s.topicFromID(nil, topicID)
+	if err != nil {
+		return err
+	}
+	added, err := s.messageCache.AddCallAck(topicID, sequenceID, to)
+	if err != nil {
+		return err
+	} else if !added {
+		ev.Debug("Call to %s already acknowledged, ignoring repeated acknowledgement", to)
+		return writeTwiML(w, "Message acknowledged. Goodbye.")
+	}
+	m := model.NewActionMessage(model.MessageAckEvent, t.ID, sequenceID)
+	m.Expires = time.Unix(m.Time, 0).Add(s.config.CacheDuration).Unix()
+	if err := s.dispatch(v, t, m, dispatchOpts{ctx: r.Context(), firebase: true, webPush: true}); err != nil {
+		return err
+	}
+	if err := s.addMessageToCache(r.Context(), m); err != nil {
+		return err
+	}
+	ev.Info("Call to %s acknowledged", to)
+	metrics.CallsAcknowledged.Inc()
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+	return writeTwiML(w, "Message acknowledged. Goodbye.")
+}
+
+// writeTwiML responds to a Twilio callback with a TwiML document that reads out the given text and hangs up
+func writeTwiML(w http.ResponseWriter, text string) error {
+	var buf bytes.Buffer
+	if err := xml.EscapeText(&buf, []byte(text)); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	_, err := fmt.Fprintf(w, "<Response><Say>%s</Say></Response>", buf.String())
+	return err
+}
@@ -1,14 +1,27 @@
 package server
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/telephony"
+	"heckel.io/ntfy/v2/twilio"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 )
@@ -46,7 +59,7 @@ func TestServer_Twilio_Call_Add_Verify_Call_Delete_Success(t *testing.T) {
 			require.Nil(t, err)
 			require.Equal(t, "/2010-04-01/Accounts/AC1234567890/Calls.json", r.URL.Path)
 			require.Equal(t, "Basic QUMxMjM0NTY3ODkwOkFBRUFBMTIzNDU2Nzg5MA==", r.Header.Get("Authorization"))
-			require.Equal(t, "From=%2B1234567890&To=%2B12223334444&Twiml=%0A%3CResponse%3E%0A%09%3CPause+length%3D%221%22%2F%3E%0A%09%3CSay+loop%3D%223%22%3E%0A%09%09You+have+a+message+from+notify+on+topic+mytopic.+Message%3A%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09hi+there%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09End+of+message.%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09This+message+was+sent+by+user+phil.+It+will+be+repeated+three+times.%0A%09%09To+unsubscribe+from+calls+like+this%2C+remove+your+phone+number+in+the+notify+web+app.%0A%09%09%3Cbreak+time%3D%223s%22%2F%3E%0A%09%3C%2FSay%3E%0A%09%3CSay%3EGoodbye.%3C%2FSay%3E%0A%3C%2FResponse%3E", string(body))
+			require.Equal(t, "From=%2B1234567890&To=%2B12223334444&Twiml=%0A%3CResponse%3E%0A%09%3CPause+length%3D%221%22%2F%3E%0A%09%3CGather+numDigits%3D%221%22+action%3D%22http%3A%2F%2F127.0.0.1%3A12345%2Fv1%2Ftwilio%2Fcall%2Fack%3Fid%3DMESSAGEID%26amp%3Bsid%3DMESSAGEID%26amp%3Bto%3D%252B12223334444%26amp%3Btopic%3Dmytopic%22+method%3D%22POST%22%3E%0A%09%3CSay+loop%3D%223%22%3E%0A%09%09You+have+a+message+from+notify+on+topic+mytopic.+Message%3A%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09hi+there%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09End+of+message.%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09This+message+was+sent+by+user+phil.+It+will+be+repeated+three+times.%0A%09%09To+unsubscribe+from+calls+like+this%2C+remove+your+phone+number+in+the+notify+web+app.%0A%09%09Press+1+to+acknowledge+this+message.%0A%09%09%3Cbreak+time%3D%223s%22%2F%3E%0A%09%3C%2FSay%3E%0A%09%3C%2FGather%3E%0A%09%3CSay%3EGoodbye.%3C%2FSay%3E%0A%3C%2FResponse%3E", maskMessageIDs(string(body)))
 			called.Store(true)
 		}))
 		defer twilioCallsServer.Close()
@@ -127,7 +140,7 @@ func TestServer_Twilio_Call_Success(t *testing.T) {
 			require.Nil(t, err)
 			require.Equal(t, "/2010-04-01/Accounts/AC1234567890/Calls.json", r.URL.Path)
 			require.Equal(t, "Basic QUMxMjM0NTY3ODkwOkFBRUFBMTIzNDU2Nzg5MA==", r.Header.Get("Authorization"))
-			require.Equal(t, "From=%2B1234567890&To=%2B11122233344&Twiml=%0A%3CResponse%3E%0A%09%3CPause+length%3D%221%22%2F%3E%0A%09%3CSay+loop%3D%223%22%3E%0A%09%09You+have+a+message+from+notify+on+topic+mytopic.+Message%3A%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09hi+there%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09End+of+message.%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09This+message+was+sent+by+user+phil.+It+will+be+repeated+three+times.%0A%09%09To+unsubscribe+from+calls+like+this%2C+remove+your+phone+number+in+the+notify+web+app.%0A%09%09%3Cbreak+time%3D%223s%22%2F%3E%0A%09%3C%2FSay%3E%0A%09%3CSay%3EGoodbye.%3C%2FSay%3E%0A%3C%2FResponse%3E", string(body))
+			require.Equal(t, "From=%2B1234567890&To=%2B11122233344&Twiml=%0A%3CResponse%3E%0A%09%3CPause+length%3D%221%22%2F%3E%0A%09%3CGather+numDigits%3D%221%22+action%3D%22http%3A%2F%2F127.0.0.1%3A12345%2Fv1%2Ftwilio%2Fcall%2Fack%3Fid%3DMESSAGEID%26amp%3Bsid%3DMESSAGEID%26amp%3Bto%3D%252B11122233344%26amp%3Btopic%3Dmytopic%22+method%3D%22POST%22%3E%0A%09%3CSay+loop%3D%223%22%3E%0A%09%09You+have+a+message+from+notify+on+topic+mytopic.+Message%3A%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09hi+there%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09End+of+message.%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09This+message+was+sent+by+user+phil.+It+will+be+repeated+three+times.%0A%09%09To+unsubscribe+from+calls+like+this%2C+remove+your+phone+number+in+the+notify+web+app.%0A%09%09Press+1+to+acknowledge+this+message.%0A%09%09%3Cbreak+time%3D%223s%22%2F%3E%0A%09%3C%2FSay%3E%0A%09%3C%2FGather%3E%0A%09%3CSay%3EGoodbye.%3C%2FSay%3E%0A%3C%2FResponse%3E", maskMessageIDs(string(body)))
 			called.Store(true)
 		}))
 		defer twilioServer.Close()
@@ -174,7 +187,7 @@ func TestServer_Twilio_Call_Success_With_Yes(t *testing.T) {
 			require.Nil(t, err)
 			require.Equal(t, "/2010-04-01/Accounts/AC1234567890/Calls.json", r.URL.Path)
 			require.Equal(t, "Basic QUMxMjM0NTY3ODkwOkFBRUFBMTIzNDU2Nzg5MA==", r.Header.Get("Authorization"))
-			require.Equal(t, "From=%2B1234567890&To=%2B11122233344&Twiml=%0A%3CResponse%3E%0A%09%3CPause+length%3D%221%22%2F%3E%0A%09%3CSay+loop%3D%223%22%3E%0A%09%09You+have+a+message+from+notify+on+topic+mytopic.+Message%3A%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09hi+there%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09End+of+message.%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09This+message+was+sent+by+user+phil.+It+will+be+repeated+three+times.%0A%09%09To+unsubscribe+from+calls+like+this%2C+remove+your+phone+number+in+the+notify+web+app.%0A%09%09%3Cbreak+time%3D%223s%22%2F%3E%0A%09%3C%2FSay%3E%0A%09%3CSay%3EGoodbye.%3C%2FSay%3E%0A%3C%2FResponse%3E", string(body))
+			require.Equal(t, "From=%2B1234567890&To=%2B11122233344&Twiml=%0A%3CResponse%3E%0A%09%3CPause+length%3D%221%22%2F%3E%0A%09%3CGather+numDigits%3D%221%22+action%3D%22http%3A%2F%2F127.0.0.1%3A12345%2Fv1%2Ftwilio%2Fcall%2Fack%3Fid%3DMESSAGEID%26amp%3Bsid%3DMESSAGEID%26amp%3Bto%3D%252B11122233344%26amp%3Btopic%3Dmytopic%22+method%3D%22POST%22%3E%0A%09%3CSay+loop%3D%223%22%3E%0A%09%09You+have+a+message+from+notify+on+topic+mytopic.+Message%3A%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09hi+there%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09End+of+message.%0A%09%09%3Cbreak+time%3D%221s%22%2F%3E%0A%09%09This+message+was+sent+by+user+phil.+It+will+be+repeated+three+times.%0A%09%09To+unsubscribe+from+calls+like+this%2C+remove+your+phone+number+in+the+notify+web+app.%0A%09%09Press+1+to+acknowledge+this+message.%0A%09%09%3Cbreak+time%3D%223s%22%2F%3E%0A%09%3C%2FSay%3E%0A%09%3C%2FGather%3E%0A%09%3CSay%3EGoodbye.%3C%2FSay%3E%0A%3C%2FResponse%3E", maskMessageIDs(string(body)))
 			called.Store(true)
 		}))
 		defer twilioServer.Close()
@@ -341,3 +354,414 @@ func TestServer_Twilio_Call_Unconfigured(t *testing.T) {
 		require.Equal(t, 40032, toHTTPError(t, response.Body.String()).Code)
 	})
 }
+
+func TestServer_Twilio_SMS_Success(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		var called atomic.Bool
+		twilioServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if called.Load() {
+				t.Fatal("Should be only called once")
+			}
+			require.Equal(t, "/2010-04-01/Accounts/AC1234567890/Messages.json", r.URL.Path)
+			require.Equal(t, "Basic QUMxMjM0NTY3ODkwOkFBRUFBMTIzNDU2Nzg5MA==", r.Header.Get("Authorization"))
+			require.Nil(t, r.ParseForm())
+			require.Equal(t, "+1234567890", r.PostForm.Get("From"))
+			require.Equal(t, "+11122233344", r.PostForm.Get("To"))
+			require.Equal(t, "ntfy message on topic mytopic from phil:\nhi there", r.PostForm.Get("Body"))
+			require.Regexp(t, `^http://127\.0\.0\.1:12345/v1/twilio/sms/status\?id=[-_A-Za-z0-9]{12}$`, r.PostForm.Get("StatusCallback"))
+			called.Store(true)
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer twilioServer.Close()
+
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.BaseURL = "http://127.0.0.1:12345"
+		c.TwilioMessagesBaseURL = twilioServer.URL
+		c.TwilioAccount = "AC1234567890"
+		c.TwilioAuthToken = "AAEAA1234567890"
+		c.TwilioPhoneNumber = "+1234567890"
+		s := newTestServer(t, c)
+
+		// Add tier and user
+		require.Nil(t, s.userManager.AddTier(&user.Tier{
+			Code:         "pro",
+			MessageLimit: 10,
+			SMSLimit:     1,
+		}))
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+		u, err := s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Nil(t, s.userManager.AddPhoneNumber(u.ID, "+11122233344"))
+
+		// Do the thing
+		response := request(t, s, "POST", "/mytopic", "hi there", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-sms":         "yes",
+		})
+		require.Equal(t, "hi there", toMessage(t, response.Body.String()).Message)
+		waitFor(t, func() bool {
+			return called.Load()
+		})
+
+		// Second SMS exceeds the tier limit
+		response = request(t, s, "POST", "/mytopic", "hi there", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-sms":         "yes",
+		})
+		require.Equal(t, 42912, toHTTPError(t, response.Body.String()).Code)
+
+		// SMS is counted in the account stats
+		response = request(t, s, "GET", "/v1/account", "", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+		})
+		account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+		require.Equal(t, int64(1), account.Limits.SMS)
+		require.Equal(t, int64(1), account.Stats.SMS)
+		require.Equal(t, int64(0), account.Stats.SMSRemaining)
+	})
+}
+
+func TestServer_Twilio_SMS_NoLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.TwilioMessagesBaseURL = "https://127.0.0.1"
+		c.TwilioAccount = "AC1234567890"
+		c.TwilioAuthToken = "AAEAA1234567890"
+		c.TwilioPhoneNumber = "+1234567890"
+		s := newTestServer(t, c)
+
+		// A call limit does not allow sending SMS
+		require.Nil(t, s.userManager.AddTier(&user.Tier{
+			Code:         "pro",
+			MessageLimit: 10,
+			CallLimit:    1,
+		}))
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+		u, err := s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Nil(t, s.userManager.AddPhoneNumber(u.ID, "+11122233344"))
+
+		response := request(t, s, "POST", "/mytopic", "test", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-sms":         "+11122233344",
+		})
+		require.Equal(t, 42912, toHTTPError(t, response.Body.String()).Code)
+	})
+}
+
+func TestServer_Twilio_SMS_InvalidAnonymousUnconfigured(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.TwilioMessagesBaseURL = "https://127.0.0.1"
+		c.TwilioAccount = "AC1234567890"
+		c.TwilioAuthToken = "AAEAA1234567890"
+		c.TwilioPhoneNumber = "+1234567890"
+		s := newTestServer(t, c)
+
+		response := request(t, s, "POST", "/mytopic", "test", map[string]string{
+			"x-sms": "+invalid",
+		})
+		require.Equal(t, 40033, toHTTPError(t, response.Body.String()).Code)
+
+		response = request(t, s, "POST", "/mytopic", "test", map[string]string{
+			"x-sms": "+123123",
+		})
+		require.Equal(t, 40035, toHTTPError(t, response.Body.String()).Code)
+
+		response = request(t, s, "POST", "/mytopic", "test", map[string]string{
+			"x-sms":   "+123123",
+			"x-delay": "1h",
+		})
+		require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+
+		s = newTestServer(t, newTestConfig(t, databaseURL))
+		response = request(t, s, "POST", "/mytopic", "test", map[string]string{
+			"x-sms": "+1234",
+		})
+		require.Equal(t, 40057, toHTTPError(t, response.Body.String()).Code)
+	})
+}
+
+func TestServer_Twilio_SMS_StatusCallback(t *testing.T) {
+	c := newTestConfigWithAuthFile(t, "")
+	c.BaseURL = "https://ntfy.example.com"
+	c.TwilioAccount = "AC1234567890"
+	c.TwilioAuthToken = "AAEAA1234567890"
+	c.TwilioPhoneNumber = "+1234567890"
+	s := newTestServer(t, c)
+
+	body := "AccountSid=AC1234567890&MessageSid=SM123&MessageStatus=delivered&To=%2B12223334444"
+	mac := hmac.New(sha1.New, []byte("AAEAA1234567890"))
+	mac.Write([]byte("https://ntfy.example.com/v1/twilio/sms/status?id=abc" + "AccountSidAC1234567890" + "MessageSidSM123" + "MessageStatusdelivered" + "To+12223334444"))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	// Valid signature
+	response := request(t, s, "POST", "/v1/twilio/sms/status?id=abc", body, map[string]string{
+		"Content-Type":       "application/x-www-form-urlencoded",
+		"X-Twilio-Signature": signature,
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Tampered body
+	response = request(t, s, "POST", "/v1/twilio/sms/status?id=abc", strings.Replace(body, "delivered", "failed", 1), map[string]string{
+		"Content-Type":       "application/x-www-form-urlencoded",
+		"X-Twilio-Signature": signature,
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Missing signature
+	response = request(t, s, "POST", "/v1/twilio/sms/status?id=abc", body, map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Only the authenticated callback was recorded
+	statuses, err := s.messageCache.SMSStatuses("abc")
+	require.Nil(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, "abc", statuses[0].MessageID)
+	require.Equal(t, "SM123", statuses[0].SID)
+	require.Equal(t, "+12223334444", statuses[0].PhoneNumber)
+	require.Equal(t, "delivered", statuses[0].Status)
+	require.Equal(t, "", statuses[0].ErrorCode)
+}
+
+func TestServer_Telephony_FakeProvider(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithAuthFile(t, databaseURL))
+		provider := &testTelephony{}
+		s.telephony = provider
+
+		require.Nil(t, s.userManager.AddTier(&user.Tier{
+			Code:         "pro",
+			MessageLimit: 10,
+			CallLimit:    1,
+			SMSLimit:     1,
+		}))
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+		// Verify and add phone number
+		response := request(t, s, "PUT", "/v1/account/phone/verify", `{"number":"+12223334444","channel":"call"}`, map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+		response = request(t, s, "PUT", "/v1/account/phone", `{"number":"+12223334444","code":"000000"}`, map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 41001, toHTTPError(t, response.Body.String()).Code)
+		response = request(t, s, "PUT", "/v1/account/phone", `{"number":"+12223334444","code":"123456"}`, map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+
+		// Call and SMS
+		response = request(t, s, "POST", "/mytopic", "hi there", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-call":        "yes",
+			"x-sms":         "+12223334444",
+		})
+		require.Equal(t, 200, response.Code)
+		waitFor(t, func() bool {
+			return provider.Count() == 2
+		})
+		require.Equal(t, []string{"call:+12223334444:hi there", "sms:+12223334444:hi there"}, provider.Sent())
+	})
+}
+
+func TestServer_Telephony_CallAck(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		c := newTestConfigWithAuthFile(t, databaseURL)
+		c.BaseURL = "https://ntfy.example.com"
+		s := newTestServer(t, c)
+		provider := &testTelephony{}
+		s.telephony = provider
+
+		require.Nil(t, s.userManager.AddTier(&user.Tier{
+			Code:         "pro",
+			MessageLimit: 10,
+			CallLimit:    10,
+		}))
+		require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+		require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+		u, err := s.userManager.User("phil")
+		require.Nil(t, err)
+		require.Nil(t, s.userManager.AddPhoneNumber(u.ID, "+12223334444"))
+
+		// Call with sequence ID, ack URL is passed to the provider
+		response := request(t, s, "POST", "/mytopic/incident1", "server down", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-call":        "yes",
+		})
+		require.Equal(t, 200, response.Code)
+		waitFor(t, func() bool {
+			return provider.Count() == 1
+		})
+		ackURL := provider.AckURLs()[0]
+		require.Regexp(t, `^https://ntfy\.example\.com/v1/twilio/call/ack\?id=[-_A-Za-z0-9]{12}&sid=incident1&to=%2B12223334444&topic=mytopic$`, ackURL)
+		ackPath := strings.TrimPrefix(ackURL, c.BaseURL)
+
+		// Invalid signature
+		response = request(t, s, "POST", ackPath, "Digits=1", map[string]string{
+			"Content-Type":       "application/x-www-form-urlencoded",
+			"X-Twilio-Signature": "invalid",
+		})
+		require.Equal(t, 401, response.Code)
+
+		// Wrong digit, not acknowledged
+		response = request(t, s, "POST", ackPath, "Digits=2", map[string]string{
+			"Content-Type":       "application/x-www-form-urlencoded",
+			"X-Twilio-Signature": "valid",
+		})
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, "text/xml", response.Header().Get("Content-Type"))
+		require.Contains(t, response.Body.String(), "Message not acknowledged")
+
+		// Acknowledged, publishes message_ack event
+		response = request(t, s, "POST", ackPath, "Digits=1", map[string]string{
+			"Content-Type":       "application/x-www-form-urlencoded",
+			"X-Twilio-Signature": "valid",
+		})
+		require.Equal(t, 200, response.Code)
+		require.Contains(t, response.Body.String(), "Message acknowledged")
+
+		// Replayed callback (e.g. webhook retry) does not publish another message_ack event
+		response = request(t, s, "POST", ackPath, "Digits=1", map[string]string{
+			"Content-Type":       "application/x-www-form-urlencoded",
+			"X-Twilio-Signature": "valid",
+		})
+		require.Equal(t, 200, response.Code)
+		require.Contains(t, response.Body.String(), "Message acknowledged")
+
+		// Acknowledgement is persisted in the message cache
+		acknowledged, err := s.messageCache.CallAcknowledged("mytopic", "incident1", "+12223334444")
+		require.Nil(t, err)
+		require.True(t, acknowledged)
+
+		response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+		lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+		require.Equal(t, 2, len(lines))
+		ack := toMessage(t, lines[1])
+		require.Equal(t, model.MessageAckEvent, ack.Event)
+		require.Equal(t, "incident1", ack.SequenceID)
+
+		// Updating the same sequence ID does not call again, a new sequence ID does
+		response = request(t, s, "POST", "/mytopic/incident1", "server still down", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-call":        "yes",
+		})
+		require.Equal(t, 200, response.Code)
+		response = request(t, s, "POST", "/mytopic/incident2", "database down", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-call":        "yes",
+		})
+		require.Equal(t, 200, response.Code)
+		waitFor(t, func() bool {
+			return provider.Count() == 2
+		})
+		time.Sleep(100 * time.Millisecond)
+		require.Equal(t, []string{"call:+12223334444:database down", "call:+12223334444:server down"}, provider.Sent())
+	})
+}
+
+func TestServer_Telephony_CreateProvider(t *testing.T) {
+	conf := NewConfig()
+	provider, err := createTelephonyProvider(conf)
+	require.Nil(t, err)
+	require.Nil(t, provider)
+
+	conf.TwilioAccount = "AC1234567890"
+	provider, err = createTelephonyProvider(conf)
+	require.Nil(t, err)
+	require.IsType(t, &twilio.Client{}, provider)
+
+	conf.TelephonyProvider = TelephonyProviderHTTP
+	provider, err = createTelephonyProvider(conf)
+	require.Nil(t, err)
+	require.Nil(t, provider) // Twilio is configured, but not selected
+
+	conf.TelephonyHTTPBaseURL = "https://telephony.example.com"
+	provider, err = createTelephonyProvider(conf)
+	require.Nil(t, err)
+	require.IsType(t, &telephony.HTTPProvider{}, provider)
+
+	conf.TelephonyProvider = "carrier-pigeon"
+	_, err = createTelephonyProvider(conf)
+	require.Error(t, err)
+}
+
+// testTelephony is an in-process fake telephony.Provider. It accepts the verification code 123456
+// for any phone number, and records all calls and SMS.
+type testTelephony struct {
+	sent    []string
+	ackURLs []string
+	mu      sync.Mutex
+}
+
+var _ telephony.Provider = (*testTelephony)(nil)
+
+func (t *testTelephony) Call(_ context.Context, to string, data *telephony.CallData) error {
+	t.mu.Lock()
+	t.ackURLs = append(t.ackURLs, data.AckURL)
+	t.mu.Unlock()
+	t.record("call:" + to + ":" + data.Message)
+	return nil
+}
+
+func (t *testTelephony) SMS(_ context.Context, to string, data *telephony.SMSData, _ string) error {
+	t.record("sms:" + to + ":" + data.Message)
+	return nil
+}
+
+func (t *testTelephony) Verify(_, _ string) error {
+	return nil
+}
+
+func (t *testTelephony) CheckVerify(_, code string) error {
+	if code != "123456" {
+		return telephony.ErrVerificationExpired
+	}
+	return nil
+}
+
+func (t *testTelephony) ValidateSignature(_ string, _ url.Values, signature string) bool {
+	return signature == "valid"
+}
+
+func (t *testTelephony) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sent)
+}
+
+func (t *testTelephony) Sent() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sent := append([]string{}, t.sent...)
+	sort.Strings(sent)
+	return sent
+}
+
+// maskMessageIDs replaces the (random) message ID in the call acknowledgement URL of a form-encoded TwiML body
+func maskMessageIDs(body string) string {
+	return callAckIDRegex.ReplaceAllString(body, "${1}MESSAGEID")
+}
+
+var callAckIDRegex = regexp.MustCompile(`(id%3D)[-_A-Za-z0-9]{12}`)
+
+func (t *testTelephony) AckURLs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.ackURLs...)
+}
+
+func (t *testTelephony) record(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, s)
+}
@@ -81,10 +81,11 @@ func (s *Server) configResponse() *apiConfigResponse {
 		RequireLogin:        s.config.RequireLogin,
 		EnableSignup:        s.config.EnableSignup,
 		EnablePayments:      s.config.StripeSecretKey != "",
-		EnableCalls:         s.config.TwilioAccount != "",
+		EnableCalls:         s.telephony != nil,
 		EnableEmails:        s.config.SMTPSenderFrom != "",
 		EnableResetPassword: s.config.SMTPSenderFrom != "" && s.config.BaseURL != "", // Reset links need SMTP + an absolute base-url
 		EnableReservations:  s.config.EnableReservations,
+		EnableOIDCLogin:     s.oidc != nil,
 		EnableWebPush:       s.config.WebPushPublicKey != "",
 		BillingContact:      s.config.BillingContact,
 		WebPushPublicKey:    s.config.WebPushPublicKey,
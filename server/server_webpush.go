@@ -3,15 +3,23 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/model"
+	"heckel.io/ntfy/v2/tracing"
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
 	wpush "heckel.io/ntfy/v2/webpush"
 )
 
@@ -21,8 +29,53 @@ const (
 	WebPushAvailable = true
 
 	webPushTopicSubscribeLimit = 50
+
+	webPushRetryMaxAttempts    = 8                // Max number of delivery attempts for a message (incl. the first one)
+	webPushRetryBackoffInitial = 30 * time.Second // Wait time after the first failed attempt, doubled after each attempt
+	webPushRetryBackoffMax     = time.Hour
+	webPushRetryBatchSize      = 100 // Max number of queued deliveries retried per run
+	webPushTopicHeaderLength   = 32  // Max length of the RFC 8030 Topic header
 )
 
+// webPushUrgencies maps message priorities to the RFC 8030 Urgency header. The default priority maps to
+// "high" as well, since iOS does not reliably deliver notifications with a lower urgency.
+var webPushUrgencies = map[int]webpush.Urgency{
+	1: webpush.UrgencyLow,
+	2: webpush.UrgencyNormal,
+	3: webpush.UrgencyHigh,
+	4: webpush.UrgencyHigh,
+	5: webpush.UrgencyHigh,
+}
+
+// webPushMaxTTLs caps the RFC 8030 TTL of low priority messages, so that the push service does not deliver them
+// long after they were published. All other messages are kept until they expire from the message cache.
+var webPushMaxTTLs = map[int]time.Duration{
+	1: time.Hour,
+	2: 6 * time.Hour,
+}
+
+// webPushOptions are the RFC 8030 options a web push message is sent with
+type webPushOptions struct {
+	urgency webpush.Urgency
+	ttl     time.Duration
+	topic   string // Topic header, replaces pending messages with the same topic on the push service
+}
+
+// webPushTransientError is returned by sendWebPushNotification if the push service could not be reached,
+// was unavailable, or rate limited the request. The delivery should be retried, but not before retryAfter (if set).
+type webPushTransientError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *webPushTransientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *webPushTransientError) Unwrap() error {
+	return e.err
+}
+
 // webPushAllowedEndpointsRegexes is the host-level allow-list of web push services ntfy
 // will deliver to. Each regex anchors the scheme and matches the stable service host,
 // followed by the authority/path boundary "/". Instance-specific labels (e.g. the
@@ -69,12 +122,55 @@ func (s *Server) handleWebPushUpdate(w http.ResponseWriter, r *http.Request, v *
 			}
 		}
 	}
-	if err := s.webPush.UpsertSubscription(req.Endpoint, req.Auth, req.P256dh, v.MaybeUserID(), v.IP(), req.Topics); err != nil {
+	filters, err := parseWebPushFilters(req.Topics, req.Filters)
+	if err != nil {
+		return err
+	}
+	if err := s.webPush.UpsertSubscription(req.Endpoint, req.Auth, req.P256dh, v.MaybeUserID(), v.IP(), req.Topics, filters); err != nil {
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// parseWebPushFilters validates the per-topic filters of a web push subscription request, and converts
+// them to filters the web push store understands. Filters that do not restrict anything are dropped.
+func parseWebPushFilters(topics []string, filters map[string]*apiWebPushSubscriptionFilter) (map[string]*wpush.Filter, error) {
+	result := make(map[string]*wpush.Filter)
+	for topic, f := range filters {
+		if !util.Contains(topics, topic) {
+			return nil, errHTTPBadRequestWebPushFilterInvalid
+		} else if f == nil {
+			continue
+		}
+		priorities := make([]int, 0)
+		for _, p := range f.Priority {
+			priority, err := util.ParsePriority(p)
+			if err != nil {
+				return nil, errHTTPBadRequestPriorityInvalid
+			}
+			priorities = append(priorities, priority)
+		}
+		if f.MinPriority != 0 {
+			if f.MinPriority < 1 || f.MinPriority > 5 || len(priorities) > 0 {
+				return nil, errHTTPBadRequestWebPushFilterInvalid
+			}
+			for p := f.MinPriority; p <= 5; p++ {
+				priorities = append(priorities, p)
+			}
+		}
+		filter := &wpush.Filter{
+			Message:  f.Message,
+			Title:    f.Title,
+			Tags:     f.Tags,
+			Priority: priorities,
+		}
+		if !filter.IsEmpty() {
+			result[topic] = filter
+		}
+	}
+	return result, nil
+}
+
 func (s *Server) handleWebPushDelete(w http.ResponseWriter, r *http.Request, _ *visitor) error {
 	req, err := readJSONWithLimit[apiWebPushUpdateSubscriptionRequest](r.Body, jsonBodyBytesLimit, false)
 	if err != nil || req.Endpoint == "" {
@@ -86,25 +182,177 @@ func (s *Server) handleWebPushDelete(w http.ResponseWriter, r *http.Request, _ *
 	return s.writeJSON(w, newSuccessResponse())
 }
 
-func (s *Server) publishToWebPushEndpoints(v *visitor, m *model.Message) {
+func (s *Server) publishToWebPushEndpoints(ctx context.Context, v *visitor, m *model.Message) {
+	_, span := s.tracer.StartMessage(ctx, "webpush.send", m)
+	defer span.End()
 	subscriptions, err := s.webPush.SubscriptionsForTopic(m.Topic)
 	if err != nil {
+		tracing.SetError(span, err)
 		logvm(v, m).Err(err).With(v, m).Warn("Unable to publish web push messages")
 		return
 	}
+	subscriptions = util.Filter(subscriptions, func(subscription *wpush.Subscription) bool {
+		return webPushFilterPass(subscription.Filter, m)
+	})
 	log.Tag(tagWebPush).With(v, m).Debug("Publishing web push message to %d subscribers", len(subscriptions))
-	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic), m.ForJSON()))
+	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic), s.withSignedAttachmentURLs(m).ForJSON()))
 	if err != nil {
 		log.Tag(tagWebPush).Err(err).With(v, m).Warn("Unable to marshal expiring payload")
 		return
 	}
+	options := s.webPushOptionsForMessage(m)
 	for _, subscription := range subscriptions {
-		if err := s.sendWebPushNotification(subscription, payload, v, m); err != nil {
+		var transientErr *webPushTransientError
+		if err := s.sendWebPushNotification(subscription, payload, options, v, m); errors.As(err, &transientErr) {
+			s.queueWebPushDelivery(subscription, payload, options, transientErr.retryAfter, v, m)
+		} else if err != nil {
 			log.Tag(tagWebPush).Err(err).With(v, m, subscription).Warn("Unable to publish web push message")
 		}
 	}
 }
 
+// webPushOptionsForMessage returns the RFC 8030 options for a message: the urgency and TTL depend on the message
+// priority, and all messages of a sequence (the original message, its updates, deletes and clears) share a Topic
+// header, so that the push service only delivers the latest one if the browser is offline.
+func (s *Server) webPushOptionsForMessage(m *model.Message) *webPushOptions {
+	priority := m.Priority
+	if priority == 0 {
+		priority = 3
+	}
+	ttl := s.config.CacheDuration
+	if m.Expires > 0 {
+		ttl = max(time.Until(time.Unix(m.Expires, 0)), 0)
+	}
+	if maxTTL, ok := webPushMaxTTLs[priority]; ok && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	var topic string
+	if m.SequenceID != "" {
+		topic = webPushTopic(m.Topic, m.SequenceID)
+	}
+	return &webPushOptions{
+		urgency: webPushUrgencies[priority],
+		ttl:     ttl,
+		topic:   topic,
+	}
+}
+
+// webPushTopic returns the RFC 8030 Topic header for a message sequence. The header may only contain up to
+// 32 characters of the URL-safe base64 alphabet, so the topic and sequence ID are hashed.
+func webPushTopic(topic, sequenceID string) string {
+	hash := sha256.Sum256([]byte(topic + "/" + sequenceID))
+	return base64.RawURLEncoding.EncodeToString(hash[:])[:webPushTopicHeaderLength]
+}
+
+// queueWebPushDelivery adds a message that could not be delivered due to a temporary error to the retry
+// queue, unless the message would expire before the next attempt
+func (s *Server) queueWebPushDelivery(sub *wpush.Subscription, payload []byte, options *webPushOptions, retryAfter time.Duration, contexters ...log.Contexter) {
+	now := time.Now()
+	delivery := &wpush.Delivery{
+		Subscription:  sub,
+		Payload:       payload,
+		Urgency:       string(options.urgency),
+		Topic:         options.topic,
+		Attempts:      1,
+		NextAttemptAt: now.Add(webPushRetryDelay(1, retryAfter)),
+		ExpiresAt:     now.Add(options.ttl),
+	}
+	ev := log.Tag(tagWebPush).With(sub).With(contexters...)
+	if !delivery.NextAttemptAt.Before(delivery.ExpiresAt) {
+		ev.Debug("Unable to publish web push message, message expires before next attempt, not retrying")
+		return
+	}
+	if err := s.webPush.QueueDelivery(delivery); err != nil {
+		ev.Err(err).Warn("Unable to queue web push message for retry")
+		return
+	}
+	ev.With(delivery).Debug("Queued web push message for retry at %s", delivery.NextAttemptAt.Format(time.RFC3339))
+}
+
+// webPushRetryDelay returns the time to wait before the next delivery attempt: the time requested by the push
+// service via the Retry-After header if any, or an exponential backoff based on the number of failed attempts.
+func webPushRetryDelay(attempts int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := webPushRetryBackoffInitial
+	for i := 1; i < attempts && delay < webPushRetryBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webPushRetryBackoffMax)
+}
+
+func (s *Server) runWebPushRetrier() {
+	if s.webPush == nil {
+		return
+	}
+	for {
+		select {
+		case <-time.After(s.config.WebPushRetryInterval):
+			if err := s.retryWebPushDeliveries(); err != nil {
+				log.Tag(tagWebPush).Err(err).Warn("Error retrying web push messages")
+			}
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+// retryWebPushDeliveries sends all queued web push messages that are due. Messages that fail again are
+// rescheduled with a longer backoff, until they expire or the max number of attempts is reached.
+func (s *Server) retryWebPushDeliveries() error {
+	if err := s.webPush.RemoveExpiredDeliveries(); err != nil {
+		return err
+	}
+	deliveries, err := s.webPush.DeliveriesDue(webPushRetryBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		options := &webPushOptions{
+			urgency: webpush.Urgency(delivery.Urgency),
+			ttl:     time.Until(delivery.ExpiresAt),
+			topic:   delivery.Topic,
+		}
+		ev := log.Tag(tagWebPush).With(delivery.Subscription, delivery)
+		var transientErr *webPushTransientError
+		if err := s.sendWebPushNotification(delivery.Subscription, delivery.Payload, options, delivery); errors.As(err, &transientErr) {
+			attempts := delivery.Attempts + 1
+			nextAttemptAt := time.Now().Add(webPushRetryDelay(attempts, transientErr.retryAfter))
+			if attempts < webPushRetryMaxAttempts && nextAttemptAt.Before(delivery.ExpiresAt) {
+				if err := s.webPush.UpdateDeliveryAttempt(delivery.ID, attempts, nextAttemptAt); err != nil {
+					return err
+				}
+				continue
+			}
+			ev.Err(err).Warn("Unable to publish web push message after %d attempts, giving up", attempts)
+		} else if err != nil {
+			ev.Err(err).Warn("Unable to publish web push message")
+		} else {
+			ev.Debug("Published web push message after %d failed attempts", delivery.Attempts)
+		}
+		if err := s.webPush.RemoveDelivery(delivery.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webPushFilterPass returns true if the message passes the filter of a web push subscription. Filters
+// are evaluated exactly like the query filters of regular subscriptions.
+func webPushFilterPass(filter *wpush.Filter, m *model.Message) bool {
+	if filter == nil {
+		return true
+	}
+	q := &queryFilter{
+		Message:  filter.Message,
+		Title:    filter.Title,
+		Tags:     filter.Tags,
+		Priority: filter.Priority,
+	}
+	return q.Pass(m)
+}
+
 func (s *Server) pruneAndNotifyWebPushSubscriptions() {
 	if s.config.WebPushPublicKey == "" {
 		return
@@ -121,6 +369,9 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	if err := s.webPush.RemoveExpiredSubscriptions(s.config.WebPushExpiryDuration); err != nil {
 		return err
 	}
+	if err := s.webPush.RemoveExpiredDeliveries(); err != nil {
+		return err
+	}
 	// Notify subscriptions that will expire soon
 	subscriptions, err := s.webPush.SubscriptionsExpiring(s.config.WebPushExpiryWarningDuration)
 	if err != nil {
@@ -132,9 +383,13 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	if err != nil {
 		return err
 	}
+	options := &webPushOptions{
+		urgency: webpush.UrgencyHigh,
+		ttl:     s.config.CacheDuration,
+	}
 	warningSent := make([]*wpush.Subscription, 0)
 	for _, subscription := range subscriptions {
-		if err := s.sendWebPushNotification(subscription, payload); err != nil {
+		if err := s.sendWebPushNotification(subscription, payload, options); err != nil {
 			log.Tag(tagWebPush).Err(err).With(subscription).Warn("Unable to publish expiry imminent warning")
 			continue
 		}
@@ -147,7 +402,10 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	return nil
 }
 
-func (s *Server) sendWebPushNotification(sub *wpush.Subscription, message []byte, contexters ...log.Contexter) error {
+// sendWebPushNotification sends a single web push message to the push service of the subscription. Subscriptions
+// that are rejected by the push service (e.g. 410 Gone) are removed. Temporary errors are returned as a
+// webPushTransientError, so that the caller can decide to retry.
+func (s *Server) sendWebPushNotification(sub *wpush.Subscription, message []byte, options *webPushOptions, contexters ...log.Contexter) error {
 	log.Tag(tagWebPush).With(sub).With(contexters...).Debug("Sending web push message")
 	payload := &webpush.Subscription{
 		Endpoint: sub.Endpoint,
@@ -160,17 +418,20 @@ func (s *Server) sendWebPushNotification(sub *wpush.Subscription, message []byte
 		Subscriber:      s.config.WebPushEmailAddress,
 		VAPIDPublicKey:  s.config.WebPushPublicKey,
 		VAPIDPrivateKey: s.config.WebPushPrivateKey,
-		Urgency:         webpush.UrgencyHigh, // iOS requires this to ensure delivery
-		TTL:             int(s.config.CacheDuration.Seconds()),
+		Urgency:         options.urgency,
+		TTL:             int(options.ttl.Seconds()),
+		Topic:           options.topic,
 	})
 	if err != nil {
-		log.Tag(tagWebPush).With(sub).With(contexters...).Err(err).Debug("Unable to publish web push message, removing endpoint")
-		if err := s.webPush.RemoveSubscriptionsByEndpoint(sub.Endpoint); err != nil {
-			return err
-		}
-		return err
+		log.Tag(tagWebPush).With(sub).With(contexters...).Err(err).Debug("Unable to publish web push message, push service unreachable")
+		return &webPushTransientError{err: err}
 	}
-	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != 429 {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		log.Tag(tagWebPush).With(sub).With(contexters...).Fields(log.Context{"response_code": resp.StatusCode, "retry_after": retryAfter.String()}).Debug("Unable to publish web push message, temporary error")
+		return &webPushTransientError{retryAfter: retryAfter, err: errHTTPInternalErrorWebPushUnableToPublish.With(sub).With(contexters...)}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		log.Tag(tagWebPush).With(sub).With(contexters...).Field("response_code", resp.StatusCode).Debug("Unable to publish web push message, unexpected response")
 		if err := s.webPush.RemoveSubscriptionsByEndpoint(sub.Endpoint); err != nil {
 			return err
@@ -179,3 +440,16 @@ func (s *Server) sendWebPushNotification(sub *wpush.Subscription, message []byte
 	}
 	return nil
 }
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or an
+// HTTP date. It returns 0 if the header is empty or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	} else if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	} else if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -10,7 +10,9 @@ import (
 	"net/http/httptest"
 	"net/netip"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -19,6 +21,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	wpush "heckel.io/ntfy/v2/webpush"
 )
 
 const (
@@ -274,7 +277,7 @@ func TestServer_WebPush_Publish(t *testing.T) {
 			require.Nil(t, err)
 			require.Equal(t, "/push-receive", r.URL.Path)
 			require.Equal(t, "high", r.Header.Get("Urgency"))
-			require.Equal(t, "", r.Header.Get("Topic"))
+			require.Len(t, r.Header.Get("Topic"), 32)
 			received.Store(true)
 		}))
 		defer pushService.Close()
@@ -288,6 +291,81 @@ func TestServer_WebPush_Publish(t *testing.T) {
 	})
 }
 
+func TestServer_WebPush_TopicAdd_Filters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
+
+		response := request(t, s, "POST", "/v1/webpush", fmt.Sprintf(`{
+			"topics": ["alerts", "builds", "other"],
+			"endpoint": "%s",
+			"p256dh": "p256dh-key",
+			"auth": "auth-key",
+			"filters": {
+				"alerts": {"min_priority": 4},
+				"builds": {"tags": ["failed"], "priority": ["urgent", "3"]},
+				"other": {}
+			}
+		}`, testWebPushEndpoint), nil)
+		require.Equal(t, 200, response.Code)
+
+		subs, err := s.webPush.SubscriptionsForTopic("alerts")
+		require.Nil(t, err)
+		require.Len(t, subs, 1)
+		require.Equal(t, &wpush.Filter{Priority: []int{4, 5}}, subs[0].Filter)
+
+		subs, err = s.webPush.SubscriptionsForTopic("builds")
+		require.Nil(t, err)
+		require.Len(t, subs, 1)
+		require.Equal(t, &wpush.Filter{Tags: []string{"failed"}, Priority: []int{5, 3}}, subs[0].Filter)
+
+		subs, err = s.webPush.SubscriptionsForTopic("other")
+		require.Nil(t, err)
+		require.Len(t, subs, 1)
+		require.Nil(t, subs[0].Filter)
+
+		// Filter for a topic that is not subscribed
+		response = request(t, s, "POST", "/v1/webpush", fmt.Sprintf(`{"topics": ["alerts"], "endpoint": "%s", "p256dh": "p256dh-key", "auth": "auth-key", "filters": {"builds": {"min_priority": 4}}}`, testWebPushEndpoint), nil)
+		require.Equal(t, 40073, toHTTPError(t, response.Body.String()).Code)
+
+		// Invalid priorities
+		response = request(t, s, "POST", "/v1/webpush", fmt.Sprintf(`{"topics": ["alerts"], "endpoint": "%s", "p256dh": "p256dh-key", "auth": "auth-key", "filters": {"alerts": {"priority": ["super-high"]}}}`, testWebPushEndpoint), nil)
+		require.Equal(t, 40007, toHTTPError(t, response.Body.String()).Code)
+		response = request(t, s, "POST", "/v1/webpush", fmt.Sprintf(`{"topics": ["alerts"], "endpoint": "%s", "p256dh": "p256dh-key", "auth": "auth-key", "filters": {"alerts": {"min_priority": 6}}}`, testWebPushEndpoint), nil)
+		require.Equal(t, 40073, toHTTPError(t, response.Body.String()).Code)
+	})
+}
+
+func TestServer_WebPush_Publish_Filters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
+
+		var highReceived, allReceived atomic.Int32
+		pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			if r.URL.Path == "/push-high" {
+				highReceived.Add(1)
+			} else {
+				allReceived.Add(1)
+			}
+		}))
+		defer pushService.Close()
+
+		filters := map[string]*wpush.Filter{"test-topic": {Priority: []int{4, 5}}}
+		require.Nil(t, s.webPush.UpsertSubscription(pushService.URL+"/push-high", "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", "u_123", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}, filters))
+		addSubscription(t, s, pushService.URL+"/push-all", "test-topic")
+
+		request(t, s, "POST", "/test-topic", "normal priority", nil)
+		request(t, s, "POST", "/test-topic", "high priority", map[string]string{"Priority": "high"})
+		request(t, s, "POST", "/test-topic", "low priority", map[string]string{"Priority": "1"})
+
+		waitFor(t, func() bool {
+			return allReceived.Load() == 3
+		})
+		require.Equal(t, int32(1), highReceived.Load())
+	})
+}
+
 func TestServer_WebPush_Publish_RemoveOnError(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
@@ -317,6 +395,154 @@ func TestServer_WebPush_Publish_RemoveOnError(t *testing.T) {
 	})
 }
 
+func TestServer_WebPush_Publish_UrgencyTTLAndTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
+
+		var mu sync.Mutex
+		headers := make([]http.Header, 0)
+		pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			headers = append(headers, r.Header)
+		}))
+		defer pushService.Close()
+
+		addSubscription(t, s, pushService.URL+"/push-receive", "test-topic")
+		waitForHeaders := func(count int) http.Header {
+			waitFor(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(headers) == count
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			return headers[count-1]
+		}
+
+		// Low priority: low urgency, and short TTL
+		request(t, s, "POST", "/test-topic", "low priority", map[string]string{"Priority": "min"})
+		h := waitForHeaders(1)
+		require.Equal(t, "low", h.Get("Urgency"))
+		require.Equal(t, "3600", h.Get("TTL"))
+		require.Len(t, h.Get("Topic"), 32)
+
+		// Max priority: high urgency, and TTL of the message cache
+		request(t, s, "POST", "/test-topic", "urgent", map[string]string{"Priority": "urgent"})
+		h = waitForHeaders(2)
+		require.Equal(t, "high", h.Get("Urgency"))
+		ttl, err := strconv.Atoi(h.Get("TTL"))
+		require.Nil(t, err)
+		require.InDelta(t, s.config.CacheDuration.Seconds(), ttl, 2)
+
+		// Updates and deletes of a message share a topic
+		request(t, s, "PUT", "/test-topic/seq123", "original", nil)
+		h = waitForHeaders(3)
+		topic := h.Get("Topic")
+		require.Len(t, topic, 32)
+		request(t, s, "PUT", "/test-topic/seq123", "updated", nil)
+		h = waitForHeaders(4)
+		require.Equal(t, topic, h.Get("Topic"))
+		request(t, s, "DELETE", "/test-topic/seq123", "", nil)
+		h = waitForHeaders(5)
+		require.Equal(t, topic, h.Get("Topic"))
+		request(t, s, "PUT", "/test-topic/seq456", "other", nil)
+		h = waitForHeaders(6)
+		require.NotEqual(t, topic, h.Get("Topic"))
+	})
+}
+
+func TestServer_WebPush_Publish_TopicOfOriginalMessage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
+
+		var mu sync.Mutex
+		topics := make([]string, 0)
+		pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			topics = append(topics, r.Header.Get("Topic"))
+		}))
+		defer pushService.Close()
+
+		addSubscription(t, s, pushService.URL+"/push-receive", "test-topic")
+
+		// Message without explicit sequence ID, deleted by its message ID
+		response := request(t, s, "POST", "/test-topic", "original", nil)
+		m := toMessage(t, response.Body.String())
+		request(t, s, "DELETE", "/test-topic/"+m.ID, "", nil)
+		waitFor(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(topics) == 2
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, topics[0], 32)
+		require.Equal(t, topics[0], topics[1])
+	})
+}
+
+func TestServer_WebPush_Publish_Retry(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, databaseURL string) {
+		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
+
+		var attempts atomic.Int32
+		pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			switch attempts.Add(1) {
+			case 1:
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case 2:
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			default:
+				w.WriteHeader(http.StatusCreated)
+			}
+		}))
+		defer pushService.Close()
+
+		addSubscription(t, s, pushService.URL+"/push-receive", "test-topic")
+		request(t, s, "POST", "/test-topic", "web push test", nil)
+		waitFor(t, func() bool {
+			return attempts.Load() == 1
+		})
+
+		// Not due yet, because of the Retry-After header
+		require.Nil(t, s.retryWebPushDeliveries())
+		require.Equal(t, int32(1), attempts.Load())
+
+		// Retried after the Retry-After time, until the push service accepts the message
+		waitFor(t, func() bool {
+			require.Nil(t, s.retryWebPushDeliveries())
+			return attempts.Load() == 3
+		})
+		deliveries, err := s.webPush.DeliveriesDue(100)
+		require.Nil(t, err)
+		require.Empty(t, deliveries)
+		requireSubscriptionCount(t, s, "test-topic", 1) // Temporary errors do not remove the subscription
+	})
+}
+
+func TestServer_WebPush_RetryDelay(t *testing.T) {
+	require.Equal(t, 30*time.Second, webPushRetryDelay(1, 0))
+	require.Equal(t, 2*time.Minute, webPushRetryDelay(3, 0))
+	require.Equal(t, time.Hour, webPushRetryDelay(20, 0))
+	require.Equal(t, 5*time.Second, webPushRetryDelay(3, 5*time.Second))
+
+	require.Equal(t, time.Duration(0), parseRetryAfter(""))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	require.Equal(t, 120*time.Second, parseRetryAfter("120"))
+	require.InDelta(t, time.Hour, parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)), float64(2*time.Second))
+	require.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+}
+
 func TestServer_WebPush_Expiry(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, databaseURL string) {
 		s := newTestServer(t, newTestConfigWithWebPush(t, databaseURL))
@@ -369,7 +595,7 @@ func payloadForTopics(t *testing.T, topics []string, endpoint string) string {
 }
 
 func addSubscription(t *testing.T, s *Server, endpoint string, topics ...string) {
-	require.Nil(t, s.webPush.UpsertSubscription(endpoint, "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", "u_123", netip.MustParseAddr("1.2.3.4"), topics)) // Test auth and p256dh
+	require.Nil(t, s.webPush.UpsertSubscription(endpoint, "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", "u_123", netip.MustParseAddr("1.2.3.4"), topics, nil)) // Test auth and p256dh
 }
 
 func requireSubscriptionCount(t *testing.T, s *Server, topic string, expectedLength int) {
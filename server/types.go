@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net/http"
 
 	"heckel.io/ntfy/v2/model"
@@ -24,20 +25,24 @@ type publishMessage struct {
 	Filename   string         `json:"filename"`
 	Email      string         `json:"email"`
 	Call       string         `json:"call"`
+	SMS        string         `json:"sms"`
 	Cache      string         `json:"cache"`    // use string as it defaults to true (or use &bool instead)
 	Firebase   string         `json:"firebase"` // use string as it defaults to true (or use &bool instead)
 	Delay      string         `json:"delay"`
+	Encoding   string         `json:"encoding"`
 }
 
 // dispatchOpts selects which delivery targets fire for a published message, beyond delivery
 // to local subscribers (see Server.dispatch)
 type dispatchOpts struct {
-	firebase bool   // Send to Firebase (if configured)
-	email    string // Send an email to this address (if a mailer is configured)
-	call     string // Call this phone number (if Twilio is configured)
-	upstream bool   // Forward a poll request to the upstream server (if configured)
-	webPush  bool   // Publish to web push endpoints (if configured)
-	async    bool   // Deliver to local subscribers in a goroutine, logging errors instead of returning them
+	ctx      context.Context // Trace context of the publish request (cancellation is ignored); if nil, side effects start a new trace
+	firebase bool            // Send to Firebase (if configured)
+	email    string          // Send an email to this address (if a mailer is configured)
+	call     string          // Call this phone number (if Twilio is configured)
+	sms      string          // Send an SMS to this phone number (if Twilio is configured)
+	upstream bool            // Forward a poll request to the upstream server (if configured)
+	webPush  bool            // Publish to web push endpoints (if configured)
+	async    bool            // Deliver to local subscribers in a goroutine, logging errors instead of returning them
 }
 
 // messageEncoder is a function that knows how to encode a message
@@ -74,7 +79,7 @@ func parseQueryFilters(r *http.Request) (*queryFilter, error) {
 }
 
 func (q *queryFilter) Pass(msg *model.Message) bool {
-	if msg.Event != model.MessageEvent && msg.Event != model.MessageDeleteEvent && msg.Event != model.MessageClearEvent {
+	if msg.Event != model.MessageEvent && msg.Event != model.MessageDeleteEvent && msg.Event != model.MessageClearEvent && msg.Event != model.MessageAckEvent {
 		return true // filters only apply to messages
 	} else if q.ID != "" && msg.ID != q.ID {
 		return false
@@ -171,6 +176,7 @@ type apiUserResponse struct {
 	Role     string                  `json:"role"`
 	Tier     string                  `json:"tier,omitempty"`
 	Grants   []*apiUserGrantResponse `json:"grants,omitempty"`
+	Groups   []string                `json:"groups,omitempty"`
 }
 
 type apiUserGrantResponse struct {
@@ -184,15 +190,118 @@ type apiUserDeleteRequest struct {
 
 type apiAccessAllowRequest struct {
 	Username   string `json:"username"`
+	Group      string `json:"group"` // Either username or group must be set
 	Topic      string `json:"topic"` // This may be a pattern
 	Permission string `json:"permission"`
 }
 
 type apiAccessResetRequest struct {
 	Username string `json:"username"`
+	Group    string `json:"group"` // Either username or group must be set
 	Topic    string `json:"topic"`
 }
 
+type apiTierResponse struct {
+	Code                 string            `json:"code"`
+	Name                 string            `json:"name"`
+	Limits               *apiAccountLimits `json:"limits"`
+	StripeMonthlyPriceID string            `json:"stripe_monthly_price_id,omitempty"`
+	StripeYearlyPriceID  string            `json:"stripe_yearly_price_id,omitempty"`
+	Provisioned          bool              `json:"provisioned,omitempty"` // True if this tier was provisioned by the server config
+}
+
+// apiTierRequest is the body of the admin tier endpoints. When updating a tier, only the fields
+// that are set are changed. Limits use the same fields as apiAccountLimits (durations in seconds,
+// sizes in bytes), so that a tier returned by GET can be sent back as is.
+type apiTierRequest struct {
+	Code                 string                `json:"code"`
+	Name                 *string               `json:"name"`
+	Limits               *apiTierLimitsRequest `json:"limits"`
+	StripeMonthlyPriceID *string               `json:"stripe_monthly_price_id"`
+	StripeYearlyPriceID  *string               `json:"stripe_yearly_price_id"`
+}
+
+type apiTierLimitsRequest struct {
+	Messages                 *int64 `json:"messages"`
+	MessagesExpiryDuration   *int64 `json:"messages_expiry_duration"`
+	Emails                   *int64 `json:"emails"`
+	Calls                    *int64 `json:"calls"`
+	SMS                      *int64 `json:"sms"`
+	Reservations             *int64 `json:"reservations"`
+	AttachmentTotalSize      *int64 `json:"attachment_total_size"`
+	AttachmentFileSize       *int64 `json:"attachment_file_size"`
+	AttachmentExpiryDuration *int64 `json:"attachment_expiry_duration"`
+	AttachmentBandwidth      *int64 `json:"attachment_bandwidth"`
+}
+
+type apiUserTokenRequest struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`   // Only used when deleting a token
+	Label    string `json:"label"`   // Only used when creating a token
+	Expires  int64  `json:"expires"` // Unix timestamp; only used when creating a token, 0 means never
+}
+
+type apiUserReservationRequest struct {
+	Username string `json:"username"`
+	Topic    string `json:"topic"`
+	Everyone string `json:"everyone"` // Only used when adding a reservation
+}
+
+type apiUserPhoneNumberRequest struct {
+	Username string `json:"username"`
+	Number   string `json:"number"`
+}
+
+type apiUserEmailRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"` // Only used when adding an email
+}
+
+type apiUserWebPushRequest struct {
+	Username string `json:"username"`
+	Endpoint string `json:"endpoint"` // If empty, all subscriptions of the user are removed
+}
+
+type apiUserWebPushSubscriptionResponse struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+}
+
+type apiAuditEntryResponse struct {
+	ID     int64  `json:"id"`
+	Time   int64  `json:"time"`
+	Actor  string `json:"actor,omitempty"` // Empty if the change was made anonymously, e.g. when signing up
+	Source string `json:"source"`
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Origin string `json:"origin,omitempty"`
+}
+
+type apiGroupResponse struct {
+	Name         string                   `json:"name"`
+	Members      []string                 `json:"members"`
+	Grants       []*apiUserGrantResponse  `json:"grants,omitempty"`
+	Reservations []*apiAccountReservation `json:"reservations,omitempty"`
+}
+
+type apiGroupRequest struct {
+	Name string `json:"name"`
+}
+
+type apiGroupMemberRequest struct {
+	Group    string `json:"group"`
+	Username string `json:"username"`
+}
+
+type apiGroupReservationRequest struct {
+	Group    string `json:"group"`
+	Topic    string `json:"topic"`
+	Everyone string `json:"everyone"` // Only used when adding a reservation
+}
+
 type apiAccountCreateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -241,6 +350,23 @@ type apiAccountPhoneNumberVerifyRequest struct {
 	Channel string `json:"channel"`
 }
 
+type apiAccount2FASetupResponse struct {
+	Secret string `json:"secret"` // Base32-encoded TOTP secret, for manual entry
+	URI    string `json:"uri"`    // otpauth:// provisioning URI, to be shown as QR code
+}
+
+type apiAccount2FAEnableRequest struct {
+	Code string `json:"code"`
+}
+
+type apiAccount2FAEnableResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type apiAccount2FADisableRequest struct {
+	Password string `json:"password"`
+}
+
 type apiAccountPhoneNumberAddRequest struct {
 	Number string `json:"number"`
 	Code   string `json:"code"` // Only set when adding a phone number
@@ -282,6 +408,7 @@ type apiAccountLimits struct {
 	MessagesExpiryDuration   int64  `json:"messages_expiry_duration"`
 	Emails                   int64  `json:"emails"`
 	Calls                    int64  `json:"calls"`
+	SMS                      int64  `json:"sms"`
 	Reservations             int64  `json:"reservations"`
 	AttachmentTotalSize      int64  `json:"attachment_total_size"`
 	AttachmentFileSize       int64  `json:"attachment_file_size"`
@@ -296,6 +423,8 @@ type apiAccountStats struct {
 	EmailsRemaining              int64 `json:"emails_remaining"`
 	Calls                        int64 `json:"calls"`
 	CallsRemaining               int64 `json:"calls_remaining"`
+	SMS                          int64 `json:"sms"`
+	SMSRemaining                 int64 `json:"sms_remaining"`
 	Reservations                 int64 `json:"reservations"`
 	ReservationsRemaining        int64 `json:"reservations_remaining"`
 	AttachmentTotalSize          int64 `json:"attachment_total_size"`
@@ -303,8 +432,10 @@ type apiAccountStats struct {
 }
 
 type apiAccountReservation struct {
-	Topic    string `json:"topic"`
-	Everyone string `json:"everyone"`
+	Topic       string `json:"topic"`
+	Everyone    string `json:"everyone"`
+	Group       string `json:"group,omitempty"`       // Set if the topic is reserved by a group the user is a member of
+	Provisioned bool   `json:"provisioned,omitempty"` // True if this reservation was provisioned by the server config
 }
 
 // apiAccountEmailInfo describes one email address on the account, as returned by GET /v1/account.
@@ -336,7 +467,9 @@ type apiAccountResponse struct {
 	Notification  *user.NotificationPrefs    `json:"notification,omitempty"`
 	Subscriptions []*user.Subscription       `json:"subscriptions,omitempty"`
 	Reservations  []*apiAccountReservation   `json:"reservations,omitempty"`
+	Groups        []string                   `json:"groups,omitempty"`
 	Tokens        []*apiAccountTokenResponse `json:"tokens,omitempty"`
+	TwoFactor     bool                       `json:"two_factor,omitempty"`
 	PhoneNumbers  []string                   `json:"phone_numbers,omitempty"`
 	Emails        []*apiAccountEmailInfo     `json:"emails,omitempty"`
 	Tier          *apiAccountTier            `json:"tier,omitempty"`
@@ -348,6 +481,7 @@ type apiAccountResponse struct {
 type apiAccountReservationRequest struct {
 	Topic    string `json:"topic"`
 	Everyone string `json:"everyone"`
+	Group    string `json:"group"` // Optional; reserve the topic on behalf of a group the user is a member of
 }
 
 type apiConfigResponse struct {
@@ -361,6 +495,7 @@ type apiConfigResponse struct {
 	EnableEmails        bool     `json:"enable_emails"`
 	EnableResetPassword bool     `json:"enable_reset_password"`
 	EnableReservations  bool     `json:"enable_reservations"`
+	EnableOIDCLogin     bool     `json:"enable_oidc_login"`
 	EnableWebPush       bool     `json:"enable_web_push"`
 	BillingContact      string   `json:"billing_contact"`
 	WebPushPublicKey    string   `json:"web_push_public_key"`
@@ -430,11 +565,37 @@ type apiStripeSubscriptionDeletedEvent struct {
 	Customer string `json:"customer"`
 }
 
+type apiAttachmentUploadRequest struct {
+	Topic     string `json:"topic"`
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Resumable bool   `json:"resumable"`
+}
+
+type apiAttachmentUploadResponse struct {
+	ID           string `json:"id"`
+	UploadURL    string `json:"upload_url"`
+	Expires      int64  `json:"expires"`
+	MinChunkSize int64  `json:"min_chunk_size,omitempty"` // Resumable uploads only, 0 means any size
+}
+
 type apiWebPushUpdateSubscriptionRequest struct {
-	Endpoint string   `json:"endpoint"`
-	Auth     string   `json:"auth"`
-	P256dh   string   `json:"p256dh"`
-	Topics   []string `json:"topics"`
+	Endpoint string                                   `json:"endpoint"`
+	Auth     string                                   `json:"auth"`
+	P256dh   string                                   `json:"p256dh"`
+	Topics   []string                                 `json:"topics"`
+	Filters  map[string]*apiWebPushSubscriptionFilter `json:"filters,omitempty"` // Topic -> filter
+}
+
+// apiWebPushSubscriptionFilter restricts the messages delivered to a web push subscription for a topic,
+// see queryFilter. Priorities may be names or numbers, and min_priority is a shortcut for a list of
+// priorities, e.g. "min_priority": 4 is the same as "priority": ["4", "5"].
+type apiWebPushSubscriptionFilter struct {
+	Message     string   `json:"message,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Priority    []string `json:"priority,omitempty"`
+	MinPriority int      `json:"min_priority,omitempty"`
 }
 
 // List of possible Web Push events (see sw.js)
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/netip"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"heckel.io/ntfy/v2/util"
@@ -30,6 +31,66 @@ var (
 	forwardedHeaderRegex = regexp.MustCompile(`(?i)\bfor="?(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}|\[[0-9a-f:]+])(?::\d+)?"?`)
 )
 
+var (
+	errRangeInvalid        = errors.New("invalid range")
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// parseByteRange parses a single byte range from a Range header (RFC 9110, section 14.1.2) for a file of the
+// given size, e.g. "bytes=0-99", "bytes=100-" or "bytes=-100", and returns the offset and length of the range.
+// Multiple ranges are not supported, and return errRangeInvalid, just like malformed ranges. Callers should
+// ignore the Range header in that case. errRangeNotSatisfiable is returned if the range starts beyond the file.
+func parseByteRange(header string, size int64) (offset int64, length int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errRangeInvalid
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeInvalid
+	}
+	if startStr == "" { // Suffix range, e.g. "bytes=-100" (the last 100 bytes)
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errRangeInvalid
+		} else if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, nil
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeInvalid
+	}
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+			return 0, 0, errRangeInvalid
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// etagMatches returns true if the If-None-Match or If-Range header contains the given entity tag, or "*".
+// Weak entity tags (W/"...") only match if weak is true, i.e. for If-None-Match, but not for If-Range.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag || (weak && tag == "*") {
+			return true
+		}
+	}
+	return false
+}
+
 func readBoolParam(r *http.Request, defaultValue bool, names ...string) bool {
 	value := strings.ToLower(readParam(r, names...))
 	if value == "" {
@@ -162,3 +162,41 @@ func TestVisitorID(t *testing.T) {
 	require.Equal(t, "ip:1.2.0.0", visitorID(netip.MustParseAddr("1.2.3.4"), nil, confWithShortenedPrefixes))
 	require.Equal(t, "ip:2a01:599:b26:2300::", visitorID(netip.MustParseAddr("2a01:599:b26:2397:dbe7:5aa2:95ce:1e83"), nil, confWithShortenedPrefixes))
 }
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header         string
+		offset, length int64
+		err            error
+	}{
+		{"bytes=0-99", 0, 100, nil},
+		{"bytes=100-", 100, 900, nil},
+		{"bytes=-100", 900, 100, nil},
+		{"bytes=-5000", 0, 1000, nil},
+		{"bytes=900-5000", 900, 100, nil},
+		{"bytes=999-999", 999, 1, nil},
+		{"bytes=1000-", 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, errRangeNotSatisfiable},
+		{"bytes=0-1,5-6", 0, 0, errRangeInvalid},
+		{"bytes=10-5", 0, 0, errRangeInvalid},
+		{"bytes=abc", 0, 0, errRangeInvalid},
+		{"items=0-1", 0, 0, errRangeInvalid},
+	}
+	for _, tt := range tests {
+		offset, length, err := parseByteRange(tt.header, 1000)
+		require.Equal(t, tt.err, err, tt.header)
+		require.Equal(t, tt.offset, offset, tt.header)
+		require.Equal(t, tt.length, length, tt.header)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	require.True(t, etagMatches(`"abc"`, `"abc"`, true))
+	require.True(t, etagMatches(`"xyz", W/"abc"`, `"abc"`, true))
+	require.True(t, etagMatches(`*`, `"abc"`, true))
+	require.False(t, etagMatches(`"xyz"`, `"abc"`, true))
+	require.False(t, etagMatches(``, `"abc"`, true))
+	require.True(t, etagMatches(`"abc"`, `"abc"`, false))
+	require.False(t, etagMatches(`W/"abc"`, `"abc"`, false))
+	require.False(t, etagMatches(`Wed, 21 Oct 2015 07:28:00 GMT`, `"abc"`, false))
+}
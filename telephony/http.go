@@ -0,0 +1,150 @@
+package telephony
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/tracing"
+)
+
+const (
+	tagTelephony = "telephony"
+
+	// httpResponseBodyLimit caps how much of a provider response is read (for logging)
+	httpResponseBodyLimit = 16 * 1024
+)
+
+// HTTPConfig holds the configuration for the generic HTTP provider
+type HTTPConfig struct {
+	BaseURL      string             // Base URL of the provider API, e.g. https://telephony.example.com/ntfy
+	Token        string             // Bearer token sent with every request, and secret for callback signatures
+	PhoneNumber  string             // Number to use for outgoing calls and SMS
+	CallFormat   *template.Template // TwiML template for calls; if nil, the default template is used
+	SMSFormat    *template.Template // Text template for SMS; if nil, the default template is used
+	BuildVersion string             // ntfy version, used for the User-Agent header
+}
+
+// HTTPProvider is a generic Provider for telephony gateways that speak a simple, Twilio-compatible
+// HTTP API. All requests are form-encoded POSTs, authenticated with a bearer token:
+//
+//   - POST <base-url>/calls with From, To and Twiml (the TwiML document to execute)
+//   - POST <base-url>/messages with From, To, Body and (optionally) StatusCallback
+//   - POST <base-url>/verify with To and Channel ("sms" or "call")
+//   - POST <base-url>/verify/check with To and Code; a 404 means the verification expired
+//
+// Any 2xx response is treated as success. Status callbacks must be signed like Twilio's
+// (see Signature), using the token as the secret.
+type HTTPProvider struct {
+	config *HTTPConfig
+}
+
+var _ Provider = (*HTTPProvider)(nil)
+
+// NewHTTPProvider creates a new generic HTTP provider with the given config
+func NewHTTPProvider(config *HTTPConfig) *HTTPProvider {
+	return &HTTPProvider{config: config}
+}
+
+// Call asks the provider to make a phone call to the given phone number, passing the rendered TwiML
+func (p *HTTPProvider) Call(ctx context.Context, to string, data *CallData) error {
+	body, err := RenderCall(p.config.CallFormat, data)
+	if err != nil {
+		log.Tag(tagTelephony).Err(err).Warn("Error executing call format template")
+		return err
+	}
+	form := url.Values{}
+	form.Set("From", p.config.PhoneNumber)
+	form.Set("To", to)
+	form.Set("Twiml", body)
+	return p.post(ctx, "/calls", form, "call")
+}
+
+// SMS asks the provider to send a text message to the given phone number
+func (p *HTTPProvider) SMS(ctx context.Context, to string, data *SMSData, statusCallbackURL string) error {
+	body, err := RenderSMS(p.config.SMSFormat, data)
+	if err != nil {
+		log.Tag(tagTelephony).Err(err).Warn("Error executing SMS format template")
+		return err
+	}
+	form := url.Values{}
+	form.Set("From", p.config.PhoneNumber)
+	form.Set("To", to)
+	form.Set("Body", body)
+	if statusCallbackURL != "" {
+		form.Set("StatusCallback", statusCallbackURL)
+	}
+	return p.post(ctx, "/messages", form, "sms")
+}
+
+// Verify asks the provider to send a verification code to the given phone number
+func (p *HTTPProvider) Verify(phoneNumber, channel string) error {
+	form := url.Values{}
+	form.Set("To", phoneNumber)
+	form.Set("Channel", channel)
+	return p.post(context.Background(), "/verify", form, "phone verification")
+}
+
+// CheckVerify asks the provider to check the verification code for the given phone number.
+// It returns ErrVerificationExpired if the provider responds with 404 Not Found.
+func (p *HTTPProvider) CheckVerify(phoneNumber, code string) error {
+	form := url.Values{}
+	form.Set("To", phoneNumber)
+	form.Set("Code", code)
+	status, err := p.request(context.Background(), "/verify/check", form, "phone verification check")
+	if err != nil && status == http.StatusNotFound {
+		return ErrVerificationExpired
+	}
+	return err
+}
+
+// ValidateSignature checks the signature of a status callback request, see Signature
+func (p *HTTPProvider) ValidateSignature(requestURL string, params url.Values, signature string) bool {
+	return ValidateSignature(p.config.Token, requestURL, params, signature)
+}
+
+func (p *HTTPProvider) post(ctx context.Context, path string, form url.Values, what string) error {
+	_, err := p.request(ctx, path, form, what)
+	return err
+}
+
+// request POSTs the given form to the given path, and returns the status code. A non-2xx status
+// code is returned as an error, along with the status code itself. The trace context of ctx is
+// propagated via the traceparent header.
+func (p *HTTPProvider) request(ctx context.Context, path string, form url.Values, what string) (int, error) {
+	ev := log.Tag(tagTelephony).
+		Field("telephony_to", form.Get("To")).
+		FieldIf("telephony_request", form.Encode(), log.TraceLevel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.config.BaseURL, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "ntfy/"+p.config.BuildVersion)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	}
+	tracing.Inject(ctx, req.Header)
+	ev.Debug("Sending %s request", what)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ev.Err(err).Warn("Error sending %s request", what)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(io.LimitReader(resp.Body, httpResponseBodyLimit))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		ev.Field("telephony_status", resp.StatusCode).Field("telephony_response", string(response)).Warn("The %s request failed with status code %d", what, resp.StatusCode)
+		return resp.StatusCode, fmt.Errorf("%s request failed with status code %d", what, resp.StatusCode)
+	}
+	ev.FieldIf("telephony_response", string(response), log.TraceLevel).Debug("Received successful %s response", what)
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,78 @@
+package telephony
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"heckel.io/ntfy/v2/tracing"
+)
+
+func TestHTTPProvider_Call_SMS_Verify(t *testing.T) {
+	requests := make(map[string]*http.Request)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.Equal(t, "ntfy/1.2.3", r.Header.Get("User-Agent"))
+		require.Nil(t, r.ParseForm())
+		requests[r.URL.Path] = r
+		if r.URL.Path == "/api/verify/check" && r.PostForm.Get("Code") != "123456" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider(&HTTPConfig{
+		BaseURL:      server.URL + "/api/",
+		Token:        "secret",
+		PhoneNumber:  "+1234567890",
+		BuildVersion: "1.2.3",
+	})
+	require.Nil(t, p.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
+	require.Equal(t, "+1234567890", requests["/api/calls"].PostForm.Get("From"))
+	require.Equal(t, "+11122233344", requests["/api/calls"].PostForm.Get("To"))
+	require.Contains(t, requests["/api/calls"].PostForm.Get("Twiml"), "hi there")
+
+	require.Nil(t, p.SMS(context.Background(), "+11122233344", &SMSData{Topic: "mytopic", Message: "hi there"}, "https://ntfy.example.com/callback"))
+	require.Equal(t, "ntfy message on topic mytopic:\nhi there", requests["/api/messages"].PostForm.Get("Body"))
+	require.Equal(t, "https://ntfy.example.com/callback", requests["/api/messages"].PostForm.Get("StatusCallback"))
+
+	require.Nil(t, p.Verify("+11122233344", "sms"))
+	require.Equal(t, "sms", requests["/api/verify"].PostForm.Get("Channel"))
+
+	require.Nil(t, p.CheckVerify("+11122233344", "123456"))
+	require.Equal(t, ErrVerificationExpired, p.CheckVerify("+11122233344", "000000"))
+}
+
+func TestHTTPProvider_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider(&HTTPConfig{BaseURL: server.URL})
+	err := p.SMS(context.Background(), "+11122233344", &SMSData{Message: "hi"}, "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "400")
+	require.NotEqual(t, ErrVerificationExpired, p.CheckVerify("+11122233344", "123456"))
+}
+
+func TestHTTPProvider_Call_Traceparent(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	tracer := tracing.NewWithExporter(tracetest.NewInMemoryExporter(), "1.2.3")
+	ctx, span := tracer.Start(context.Background(), "publish")
+	defer span.End()
+	p := NewHTTPProvider(&HTTPConfig{BaseURL: server.URL})
+	require.Nil(t, p.Call(ctx, "+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
+	require.Equal(t, "00-"+span.SpanContext().TraceID().String()+"-"+span.SpanContext().SpanID().String()+"-01", traceparent)
+}
@@ -0,0 +1,199 @@
+// Package telephony defines the interface the ntfy server uses to make phone calls, send SMS and
+// verify phone numbers, so that the server is not tied to a single provider. The Twilio implementation
+// lives in the twilio package; this package contains the shared message rendering and a generic HTTP
+// provider for Twilio-compatible (TwiML) APIs.
+package telephony
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// SMSBodyLimit is the maximum number of characters in an SMS body; longer bodies are truncated
+const SMSBodyLimit = 1600
+
+// ErrVerificationExpired is returned by CheckVerify if the verification code has
+// expired, or if it never existed in the first place
+var ErrVerificationExpired = errors.New("phone number verification expired or does not exist")
+
+// Provider makes phone calls, sends SMS and verifies phone numbers. Implementations must be safe
+// for concurrent use, since calls and SMS are sent from their own goroutines.
+type Provider interface {
+	// Call makes a phone call to the given phone number, reading out the given data. The trace
+	// context in ctx (if any) is propagated to the provider.
+	Call(ctx context.Context, to string, data *CallData) error
+
+	// SMS sends a text message to the given phone number. If statusCallbackURL is not empty,
+	// the provider reports delivery status updates to it.
+	SMS(ctx context.Context, to string, data *SMSData, statusCallbackURL string) error
+
+	// Verify sends a verification code to the given phone number, via the given channel ("sms" or "call")
+	Verify(phoneNumber, channel string) error
+
+	// CheckVerify checks the verification code for the given phone number. It returns
+	// ErrVerificationExpired if the code has expired or never existed.
+	CheckVerify(phoneNumber, code string) error
+
+	// ValidateSignature checks the signature of a status callback request sent by the provider
+	ValidateSignature(requestURL string, params url.Values, signature string) bool
+}
+
+// CallData holds the data passed to the call format template. String fields are XML-escaped
+// before the template is executed, so callers pass them unescaped.
+type CallData struct {
+	Topic    string
+	Title    string
+	Message  string
+	Priority int
+	Tags     []string
+	Sender   string
+	AckURL   string // If set, the callee can press CallAckDigit to acknowledge; the digit is POSTed here
+}
+
+// CallAckDigit is the key the callee has to press to acknowledge a call, see CallData.AckURL
+const CallAckDigit = "1"
+
+// SMSData holds the data passed to the SMS format template
+type SMSData struct {
+	Topic    string
+	Title    string
+	Message  string
+	Priority int
+	Tags     []string
+	Sender   string
+}
+
+// defaultCallFormatTemplate is the default TwiML template used for calls. It can be overridden
+// in the server configuration's twilio-call-format field.
+//
+// The format uses Go template syntax with the following fields:
+// {{.Topic}}, {{.Title}}, {{.Message}}, {{.Priority}}, {{.Tags}}, {{.Sender}}, {{.AckURL}}
+// String fields are automatically XML-escaped. If {{.AckURL}} is set, the message is wrapped
+// in a <Gather>, so that the callee can acknowledge it by pressing 1.
+var defaultCallFormatTemplate = template.Must(template.New("twiml").Parse(`
+<Response>
+	<Pause length="1"/>{{if .AckURL}}
+	<Gather numDigits="1" action="{{.AckURL}}" method="POST">{{end}}
+	<Say loop="3">
+		You have a message from notify on topic {{.Topic}}. Message:
+		<break time="1s"/>
+		{{.Message}}
+		<break time="1s"/>
+		End of message.
+		<break time="1s"/>
+		This message was sent by user {{.Sender}}. It will be repeated three times.
+		To unsubscribe from calls like this, remove your phone number in the notify web app.{{if .AckURL}}
+		Press 1 to acknowledge this message.{{end}}
+		<break time="3s"/>
+	</Say>{{if .AckURL}}
+	</Gather>{{end}}
+	<Say>Goodbye.</Say>
+</Response>`))
+
+// defaultSMSFormatTemplate is the default template used for SMS. It can be overridden in the
+// server configuration's twilio-sms-format field. It uses the same fields as the call format
+// template, but the fields are not escaped, since SMS bodies are plain text.
+var defaultSMSFormatTemplate = template.Must(template.New("sms").Parse(`
+ntfy message on topic {{.Topic}}{{if .Sender}} from {{.Sender}}{{end}}:
+{{if .Title}}{{.Title}}: {{end}}{{.Message}}`))
+
+// RenderCall renders the TwiML for a phone call. If tmpl is nil, the default template is used.
+func RenderCall(tmpl *template.Template, data *CallData) (string, error) {
+	if tmpl == nil {
+		tmpl = defaultCallFormatTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data.escaped()); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderSMS renders the body of a text message, truncated to SMSBodyLimit characters. If tmpl
+// is nil, the default template is used.
+func RenderSMS(tmpl *template.Template, data *SMSData) (string, error) {
+	if tmpl == nil {
+		tmpl = defaultSMSFormatTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return truncate(strings.TrimSpace(buf.String()), SMSBodyLimit), nil
+}
+
+// Signature computes the signature of a callback request, as used by Twilio (and compatible
+// providers): a HMAC-SHA1 with the given secret over the full request URL, followed by all POST
+// parameters, sorted by name and concatenated as name+value.
+// See https://www.twilio.com/docs/usage/security#validating-requests
+func Signature(secret, requestURL string, params url.Values) []byte {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var data strings.Builder
+	data.WriteString(requestURL)
+	for _, key := range keys {
+		for _, value := range params[key] {
+			data.WriteString(key)
+			data.WriteString(value)
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(data.String()))
+	return mac.Sum(nil)
+}
+
+// ValidateSignature checks the base64-encoded signature of a callback request, see Signature
+func ValidateSignature(secret, requestURL string, params url.Values, signature string) bool {
+	if signature == "" || secret == "" {
+		return false
+	}
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, Signature(secret, requestURL, params))
+}
+
+// escaped returns a copy of the call data with all string fields XML-escaped
+func (d *CallData) escaped() *CallData {
+	tags := make([]string, len(d.Tags))
+	for i, tag := range d.Tags {
+		tags[i] = xmlEscapeText(tag)
+	}
+	return &CallData{
+		Topic:    xmlEscapeText(d.Topic),
+		Title:    xmlEscapeText(d.Title),
+		Message:  xmlEscapeText(d.Message),
+		Priority: d.Priority,
+		Tags:     tags,
+		Sender:   xmlEscapeText(d.Sender),
+		AckURL:   xmlEscapeText(d.AckURL),
+	}
+}
+
+func xmlEscapeText(text string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+// truncate shortens the given string to at most limit characters (runes, not bytes)
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit])
+}
@@ -0,0 +1,70 @@
+package telephony
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderCall_EscapesXML(t *testing.T) {
+	data := &CallData{Topic: "mytopic", Message: `</Say><Say>evil</Say>`, Sender: `phil & "friends"`}
+	twiml, err := RenderCall(nil, data)
+	require.Nil(t, err)
+	require.NotContains(t, twiml, "<Say>evil</Say>")
+	require.Contains(t, twiml, "&lt;/Say&gt;&lt;Say&gt;evil&lt;/Say&gt;")
+	require.Contains(t, twiml, "phil &amp; &#34;friends&#34;")
+	require.Equal(t, `</Say><Say>evil</Say>`, data.Message) // Caller's data is not modified
+}
+
+func TestRenderCall_CustomFormat(t *testing.T) {
+	tmpl := template.Must(template.New("").Parse(`<Response><Say>{{.Message}}</Say></Response>`))
+	twiml, err := RenderCall(tmpl, &CallData{Message: "hi & bye"})
+	require.Nil(t, err)
+	require.Equal(t, "<Response><Say>hi &amp; bye</Say></Response>", twiml)
+}
+
+func TestRenderSMS_DefaultAndTruncate(t *testing.T) {
+	body, err := RenderSMS(nil, &SMSData{Topic: "mytopic", Message: "hi <there>"})
+	require.Nil(t, err)
+	require.Equal(t, "ntfy message on topic mytopic:\nhi <there>", body)
+
+	body, err = RenderSMS(nil, &SMSData{Topic: "mytopic", Message: strings.Repeat("ä", 2000)})
+	require.Nil(t, err)
+	require.Equal(t, SMSBodyLimit, utf8.RuneCountInString(body))
+}
+
+func TestValidateSignature(t *testing.T) {
+	// Example from https://www.twilio.com/docs/usage/security#validating-requests
+	requestURL := "https://example.com/myapp.php?foo=1&bar=2"
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	signature := base64.StdEncoding.EncodeToString(Signature("12345", requestURL, params))
+	require.True(t, ValidateSignature("12345", requestURL, params, signature))
+	require.False(t, ValidateSignature("54321", requestURL, params, signature))
+	require.False(t, ValidateSignature("12345", requestURL+"&baz=3", params, signature))
+	require.False(t, ValidateSignature("12345", requestURL, params, ""))
+	require.False(t, ValidateSignature("", requestURL, params, signature))
+}
+
+func TestRenderCall_AckURL(t *testing.T) {
+	twiml, err := RenderCall(nil, &CallData{Topic: "mytopic", Message: "hi there"})
+	require.Nil(t, err)
+	require.NotContains(t, twiml, "<Gather")
+	require.NotContains(t, twiml, "Press 1")
+
+	twiml, err = RenderCall(nil, &CallData{Topic: "mytopic", Message: "hi there", AckURL: "https://ntfy.example.com/v1/twilio/call/ack?sid=abc&topic=mytopic"})
+	require.Nil(t, err)
+	require.Contains(t, twiml, `<Gather numDigits="1" action="https://ntfy.example.com/v1/twilio/call/ack?sid=abc&amp;topic=mytopic" method="POST">`)
+	require.Contains(t, twiml, "Press 1 to acknowledge this message.")
+	require.Contains(t, twiml, "</Gather>")
+}
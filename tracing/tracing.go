@@ -0,0 +1,193 @@
+// Package tracing provides OpenTelemetry tracing for the ntfy server, so that a message can be traced end to end:
+// from the HTTP request, through template rendering, the message cache and the topic fan-out, to the delivery
+// via Firebase, web push, email, phone calls and the upstream server.
+//
+// Spans are exported to an OTLP collector (via HTTP or gRPC). If no collector endpoint is configured, the
+// Tracer is a no-op. Trace context is propagated via the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"heckel.io/ntfy/v2/model"
+)
+
+// Defines the protocols used to export spans to the collector (see Config.Protocol)
+const (
+	ProtocolHTTP    = "http"
+	ProtocolGRPC    = "grpc"
+	DefaultProtocol = ProtocolHTTP
+)
+
+const (
+	serviceName         = "ntfy"
+	instrumentationName = "heckel.io/ntfy"
+	httpTracesPath      = "/v1/traces"
+)
+
+// Attribute keys used for message spans
+const (
+	AttributeMessageID         = attribute.Key("ntfy.message.id")
+	AttributeMessageEvent      = attribute.Key("ntfy.message.event")
+	AttributeMessageSequenceID = attribute.Key("ntfy.message.sequence_id")
+	AttributeTopic             = attribute.Key("ntfy.topic")
+)
+
+// propagator injects and extracts the W3C traceparent/tracestate headers
+var propagator = propagation.TraceContext{}
+
+// Config holds the configuration for the Tracer
+type Config struct {
+	Endpoint       string // Collector URL, e.g. http://localhost:4318 (HTTP) or http://localhost:4317 (gRPC); tracing is disabled if empty
+	Protocol       string // Export protocol, "http" or "grpc"
+	ServiceVersion string // ntfy version, reported as service.version
+}
+
+// Tracer creates spans and exports them to the configured collector. A Tracer without a collector
+// (see New) creates non-recording spans, so it is safe (and cheap) to use unconditionally.
+type Tracer struct {
+	tracer   trace.Tracer
+	provider *sdktrace.TracerProvider // nil if tracing is disabled
+}
+
+// New creates a new Tracer for the given config. If no endpoint is configured, a no-op Tracer is returned.
+// The exporter respects the standard OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER* environment variables,
+// e.g. to set headers or a sampling ratio.
+func New(conf *Config) (*Tracer, error) {
+	if conf.Endpoint == "" {
+		return &Tracer{tracer: noop.NewTracerProvider().Tracer(instrumentationName)}, nil
+	}
+	exporter, err := newExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+	return newTracer(sdktrace.WithBatcher(exporter), conf.ServiceVersion), nil
+}
+
+// NewWithExporter creates a Tracer that synchronously exports every span to the given exporter
+// when it ends. This is meant for testing and debugging, since it blocks on every span.
+func NewWithExporter(exporter sdktrace.SpanExporter, serviceVersion string) *Tracer {
+	return newTracer(sdktrace.WithSyncer(exporter), serviceVersion)
+}
+
+func newTracer(processor sdktrace.TracerProviderOption, serviceVersion string) *Tracer {
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(serviceVersion),
+		)),
+	)
+	return &Tracer{
+		tracer:   provider.Tracer(instrumentationName),
+		provider: provider,
+	}
+}
+
+func newExporter(conf *Config) (sdktrace.SpanExporter, error) {
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid tracing endpoint %q, must be a URL, e.g. http://localhost:4318", conf.Endpoint)
+	}
+	switch conf.Protocol {
+	case ProtocolHTTP:
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = httpTracesPath
+		}
+		return otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint.String()))
+	case ProtocolGRPC:
+		return otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpointURL(endpoint.String()))
+	}
+	return nil, fmt.Errorf("invalid tracing protocol %q, must be %q or %q", conf.Protocol, ProtocolHTTP, ProtocolGRPC)
+}
+
+// Enabled returns true if spans are exported to a collector
+func (t *Tracer) Enabled() bool {
+	return t.provider != nil
+}
+
+// Start starts a new internal span with the given name and attributes, as a child of the span in ctx (if any)
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartMessage starts a new internal span for the given message, see Start and MessageAttributes
+func (t *Tracer) StartMessage(ctx context.Context, name string, m *model.Message) (context.Context, trace.Span) {
+	return t.Start(ctx, name, MessageAttributes(m)...)
+}
+
+// StartHTTP starts a server span for an incoming HTTP request. If the request carries a traceparent header,
+// the span continues the caller's trace. The returned request carries the span in its context.
+func (t *Tracer) StartHTTP(r *http.Request) (*http.Request, trace.Span) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer.Start(ctx, "HTTP "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// Trace runs fn in a new span for the given message, and records the error returned by fn (if any) in the span
+func (t *Tracer) Trace(ctx context.Context, name string, m *model.Message, fn func(ctx context.Context) error) error {
+	ctx, span := t.StartMessage(ctx, name, m)
+	defer span.End()
+	err := fn(ctx)
+	SetError(span, err)
+	return err
+}
+
+// Shutdown flushes all pending spans to the collector and stops the Tracer
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// Inject adds the W3C traceparent header for the span in ctx to the given outgoing request headers
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// SetMessage adds the message attributes to the given span, e.g. once the message ID is known
+func SetMessage(span trace.Span, m *model.Message) {
+	span.SetAttributes(MessageAttributes(m)...)
+}
+
+// SetError records the given error in the span and marks the span as failed. It does nothing if err is nil.
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// MessageAttributes returns the span attributes identifying the given message
+func MessageAttributes(m *model.Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttributeMessageID.String(m.ID),
+		AttributeMessageEvent.String(m.Event),
+		AttributeTopic.String(m.Topic),
+	}
+	if m.SequenceID != "" {
+		attrs = append(attrs, AttributeMessageSequenceID.String(m.SequenceID))
+	}
+	return attrs
+}
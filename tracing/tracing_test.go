@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"heckel.io/ntfy/v2/model"
+)
+
+func TestNew_Disabled(t *testing.T) {
+	tracer, err := New(&Config{})
+	require.Nil(t, err)
+	require.False(t, tracer.Enabled())
+	_, span := tracer.Start(context.Background(), "test")
+	require.False(t, span.IsRecording())
+	span.End()
+	require.Nil(t, tracer.Shutdown(context.Background()))
+}
+
+func TestNew_Enabled(t *testing.T) {
+	tracer, err := New(&Config{Endpoint: "http://localhost:4318", Protocol: ProtocolHTTP})
+	require.Nil(t, err)
+	require.True(t, tracer.Enabled())
+	require.Nil(t, tracer.Shutdown(context.Background()))
+
+	tracer, err = New(&Config{Endpoint: "http://localhost:4317", Protocol: ProtocolGRPC})
+	require.Nil(t, err)
+	require.True(t, tracer.Enabled())
+	require.Nil(t, tracer.Shutdown(context.Background()))
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New(&Config{Endpoint: "http://localhost:4318", Protocol: "carrier-pigeon"})
+	require.Error(t, err)
+	_, err = New(&Config{Endpoint: "localhost", Protocol: ProtocolHTTP})
+	require.Error(t, err)
+}
+
+func TestTracer_StartHTTP_Propagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewWithExporter(exporter, "1.2.3")
+
+	r := httptest.NewRequest(http.MethodPost, "/mytopic", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r, span := tracer.StartHTTP(r)
+	m := model.NewDefaultMessage("mytopic", "hi there")
+	require.Nil(t, tracer.Trace(r.Context(), "cache.add", m, func(ctx context.Context) error {
+		header := http.Header{}
+		Inject(ctx, header)
+		require.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, header.Get("traceparent"))
+		return nil
+	}))
+	span.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "cache.add", spans[0].Name)
+	require.Equal(t, "HTTP POST", spans[1].Name)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+	require.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	require.Contains(t, spans[0].Attributes, AttributeMessageID.String(m.ID))
+	require.Contains(t, spans[0].Attributes, AttributeTopic.String("mytopic"))
+}
+
+func TestTracer_Trace_Error(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewWithExporter(exporter, "1.2.3")
+	err := tracer.Trace(context.Background(), "firebase.send", model.NewDefaultMessage("mytopic", "hi"), func(ctx context.Context) error {
+		return errors.New("oh no")
+	})
+	require.Equal(t, "oh no", err.Error())
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status.Code)
+	require.Equal(t, "oh no", spans[0].Status.Description)
+}
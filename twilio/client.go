@@ -1,10 +1,10 @@
-// Package twilio talks to the Twilio API to make phone calls (for the "Call" feature) and to
-// verify phone numbers. It holds the Twilio configuration, so that this functionality is
-// decoupled from the ntfy server.
+// Package twilio talks to the Twilio API to make phone calls (for the "Call" feature), send SMS
+// and to verify phone numbers. The Client implements telephony.Provider, and holds the Twilio
+// configuration, so that this functionality is decoupled from the ntfy server.
 package twilio
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +12,8 @@ import (
 	"strings"
 
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/telephony"
+	"heckel.io/ntfy/v2/tracing"
 	"heckel.io/ntfy/v2/util"
 )
 
@@ -24,23 +26,20 @@ type Client struct {
 	config *Config
 }
 
+var _ telephony.Provider = (*Client)(nil)
+
 // NewClient creates a new Twilio Client with the given config
 func NewClient(config *Config) *Client {
 	return &Client{config: config}
 }
 
 // Call calls the Twilio API to make a phone call to the given phone number, using the given data
-func (c *Client) Call(to string, data *CallData) error {
-	tmpl := defaultCallFormatTemplate
-	if c.config.CallFormat != nil {
-		tmpl = c.config.CallFormat
-	}
-	var bodyBuf bytes.Buffer
-	if err := tmpl.Execute(&bodyBuf, data.escaped()); err != nil {
+func (c *Client) Call(ctx context.Context, to string, data *CallData) error {
+	body, err := telephony.RenderCall(c.config.CallFormat, data)
+	if err != nil {
 		log.Tag(tagTwilio).Err(err).Warn("Error executing Twilio call format template")
 		return err
 	}
-	body := bodyBuf.String()
 	form := url.Values{}
 	form.Set("From", c.config.PhoneNumber)
 	form.Set("To", to)
@@ -50,7 +49,7 @@ func (c *Client) Call(to string, data *CallData) error {
 		FieldIf("twilio_body", body, log.TraceLevel).
 		Debug("Sending Twilio request")
 	requestURL := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", c.config.CallsBaseURL, c.config.Account)
-	response, code, err := c.request(requestURL, form)
+	response, code, err := c.request(ctx, requestURL, form)
 	if err != nil {
 		ev.Field("twilio_response", response).Err(err).Warn("Error sending Twilio request")
 		return err
@@ -64,6 +63,44 @@ func (c *Client) Call(to string, data *CallData) error {
 	return nil
 }
 
+// SMS calls the Twilio Messages API to send a text message to the given phone number, using the
+// given data. If statusCallbackURL is not empty, Twilio will POST delivery status updates to it.
+func (c *Client) SMS(ctx context.Context, to string, data *SMSData, statusCallbackURL string) error {
+	body, err := telephony.RenderSMS(c.config.SMSFormat, data)
+	if err != nil {
+		log.Tag(tagTwilio).Err(err).Warn("Error executing Twilio SMS format template")
+		return err
+	}
+	form := url.Values{}
+	form.Set("From", c.config.PhoneNumber)
+	form.Set("To", to)
+	form.Set("Body", body)
+	if statusCallbackURL != "" {
+		form.Set("StatusCallback", statusCallbackURL)
+	}
+	ev := log.Tag(tagTwilio).
+		Field("twilio_to", to).
+		FieldIf("twilio_body", body, log.TraceLevel).
+		Debug("Sending Twilio SMS request")
+	requestURL := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", c.config.MessagesBaseURL, c.config.Account)
+	response, code, err := c.request(ctx, requestURL, form)
+	if err != nil {
+		ev.Field("twilio_response", response).Err(err).Warn("Error sending Twilio SMS request")
+		return err
+	} else if !success(code) {
+		ev.Field("twilio_status", code).Field("twilio_response", response).Warn("Twilio SMS failed with status code %d", code)
+		return fmt.Errorf("twilio sms failed with status code %d", code)
+	}
+	ev.FieldIf("twilio_response", response, log.TraceLevel).Debug("Received successful Twilio SMS response")
+	return nil
+}
+
+// ValidateSignature checks the X-Twilio-Signature header of a Twilio webhook request (e.g. a
+// status callback), see telephony.Signature
+func (c *Client) ValidateSignature(requestURL string, params url.Values, signature string) bool {
+	return telephony.ValidateSignature(c.config.AuthToken, requestURL, params, signature)
+}
+
 // Verify calls the Twilio Verify API to send a verification code to the given phone
 // number, via the given channel ("sms" or "call")
 func (c *Client) Verify(phoneNumber, channel string) error {
@@ -72,7 +109,7 @@ func (c *Client) Verify(phoneNumber, channel string) error {
 	form.Set("To", phoneNumber)
 	form.Set("Channel", channel)
 	requestURL := fmt.Sprintf("%s/v2/Services/%s/Verifications", c.config.VerifyBaseURL, c.config.VerifyService)
-	response, code, err := c.request(requestURL, form)
+	response, code, err := c.request(context.Background(), requestURL, form)
 	if err != nil {
 		ev.Err(err).Warn("Error sending Twilio phone verification request")
 		return err
@@ -94,7 +131,7 @@ func (c *Client) CheckVerify(phoneNumber, code string) error {
 	form.Set("To", phoneNumber)
 	form.Set("Code", code)
 	requestURL := fmt.Sprintf("%s/v2/Services/%s/VerificationCheck", c.config.VerifyBaseURL, c.config.VerifyService)
-	req, err := c.newRequest(requestURL, form)
+	req, err := c.newRequest(context.Background(), requestURL, form)
 	if err != nil {
 		return err
 	}
@@ -132,8 +169,8 @@ func (c *Client) CheckVerify(phoneNumber, code string) error {
 // request POSTs the given form to the given Twilio API URL, and returns the raw response body
 // and status code. It does not treat a non-2xx status code as an error; that is up to the
 // caller. The response body is returned even if the request failed, so that it can be logged.
-func (c *Client) request(requestURL string, form url.Values) (string, int, error) {
-	req, err := c.newRequest(requestURL, form)
+func (c *Client) request(ctx context.Context, requestURL string, form url.Values) (string, int, error) {
+	req, err := c.newRequest(ctx, requestURL, form)
 	if err != nil {
 		return "", 0, err
 	}
@@ -156,14 +193,15 @@ func success(code int) bool {
 }
 
 // newRequest creates a form-encoded POST request against the Twilio API, with the auth and
-// User-Agent headers set
-func (c *Client) newRequest(requestURL string, form url.Values) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(form.Encode()))
+// User-Agent headers set, and the trace context of ctx propagated via the traceparent header
+func (c *Client) newRequest(ctx context.Context, requestURL string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "ntfy/"+c.config.BuildVersion)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Authorization", util.BasicAuth(c.config.Account, c.config.AuthToken))
+	tracing.Inject(ctx, req.Header)
 	return req, nil
 }
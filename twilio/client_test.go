@@ -1,15 +1,20 @@
 package twilio
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"text/template"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/telephony"
 )
 
 func TestClient_Call_Success(t *testing.T) {
@@ -26,7 +31,7 @@ func TestClient_Call_Success(t *testing.T) {
 	defer server.Close()
 
 	c := NewClient(testConfig(server.URL))
-	require.Nil(t, c.Call("+11122233344", &CallData{Topic: "mytopic", Message: "hi there", Sender: "phil"}))
+	require.Nil(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there", Sender: "phil"}))
 
 	form, err := url.ParseQuery(body)
 	require.Nil(t, err)
@@ -55,7 +60,7 @@ func TestClient_Call_EscapesXML(t *testing.T) {
 		Tags:    []string{"<tag>"},
 		Sender:  `phil & "friends"`,
 	}
-	require.Nil(t, c.Call("+11122233344", data))
+	require.Nil(t, c.Call(context.Background(), "+11122233344", data))
 
 	form, err := url.ParseQuery(body)
 	require.Nil(t, err)
@@ -80,7 +85,7 @@ func TestClient_Call_CustomCallFormat(t *testing.T) {
 	conf := testConfig(server.URL)
 	conf.CallFormat = template.Must(template.New("twiml").Parse(`<Response><Say>{{.Message}} von {{.Sender}}</Say></Response>`))
 	c := NewClient(conf)
-	require.Nil(t, c.Call("+11122233344", &CallData{Topic: "mytopic", Message: "hi there", Sender: "phil"}))
+	require.Nil(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there", Sender: "phil"}))
 
 	form, err := url.ParseQuery(body)
 	require.Nil(t, err)
@@ -107,7 +112,7 @@ func TestClient_Call_RendersAllFields(t *testing.T) {
 		Priority: 5,
 		Tags:     []string{"<one>", "two & three"},
 	}
-	require.Nil(t, c.Call("+11122233344", data))
+	require.Nil(t, c.Call(context.Background(), "+11122233344", data))
 
 	form, err := url.ParseQuery(body)
 	require.Nil(t, err)
@@ -118,7 +123,7 @@ func TestClient_Call_TemplateError(t *testing.T) {
 	conf := testConfig("http://dummy.invalid")
 	conf.CallFormat = template.Must(template.New("twiml").Parse(`{{.DoesNotExist}}`))
 	c := NewClient(conf)
-	require.Error(t, c.Call("+11122233344", &CallData{Topic: "mytopic"}))
+	require.Error(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic"}))
 }
 
 // TestClient_Call_Created ensures that a 201 Created is treated as a success. The Twilio Calls
@@ -131,7 +136,7 @@ func TestClient_Call_Created(t *testing.T) {
 	defer server.Close()
 
 	c := NewClient(testConfig(server.URL))
-	require.Nil(t, c.Call("+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
+	require.Nil(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
 }
 
 // TestClient_Call_TwilioError ensures that a non-2xx response from Twilio is returned as an
@@ -145,7 +150,7 @@ func TestClient_Call_TwilioError(t *testing.T) {
 	defer server.Close()
 
 	c := NewClient(testConfig(server.URL))
-	err := c.Call("+invalid", &CallData{Topic: "mytopic", Message: "hi there"})
+	err := c.Call(context.Background(), "+invalid", &CallData{Topic: "mytopic", Message: "hi there"})
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "400")
 }
@@ -157,19 +162,19 @@ func TestClient_Call_TwilioServerError(t *testing.T) {
 	defer server.Close()
 
 	c := NewClient(testConfig(server.URL))
-	require.Error(t, c.Call("+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
+	require.Error(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
 }
 
 // TestClient_Call_TransportError ensures that a call to an unreachable Twilio API returns an
 // error, so that the server can count it as a failure
 func TestClient_Call_TransportError(t *testing.T) {
 	c := NewClient(testConfig(closedServerURL(t)))
-	require.Error(t, c.Call("+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
+	require.Error(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
 }
 
 func TestClient_Call_InvalidBaseURL(t *testing.T) {
 	c := NewClient(testConfig("://invalid"))
-	require.Error(t, c.Call("+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
+	require.Error(t, c.Call(context.Background(), "+11122233344", &CallData{Topic: "mytopic", Message: "hi there"}))
 }
 
 // TestClient_Verify_Created ensures that a 201 Created is treated as a success. The Twilio
@@ -278,6 +283,88 @@ func TestClient_CheckVerify_OtherError(t *testing.T) {
 	require.False(t, errors.Is(err, ErrVerificationExpired))
 }
 
+func TestClient_SMS_Success(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/2010-04-01/Accounts/AC1234567890/Messages.json", r.URL.Path)
+		require.Equal(t, "Basic QUMxMjM0NTY3ODkwOkFBRUFBMTIzNDU2Nzg5MA==", r.Header.Get("Authorization"))
+		b, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		body = string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	c := NewClient(testConfig(server.URL))
+	require.Nil(t, c.SMS(context.Background(), "+11122233344", &SMSData{Topic: "mytopic", Title: "Alert", Message: "hi <there>", Sender: "phil"}, "https://ntfy.example.com/v1/twilio/sms/status?id=abc"))
+
+	form, err := url.ParseQuery(body)
+	require.Nil(t, err)
+	require.Equal(t, "+1234567890", form.Get("From"))
+	require.Equal(t, "+11122233344", form.Get("To"))
+	require.Equal(t, "ntfy message on topic mytopic from phil:\nAlert: hi <there>", form.Get("Body"))
+	require.Equal(t, "https://ntfy.example.com/v1/twilio/sms/status?id=abc", form.Get("StatusCallback"))
+}
+
+func TestClient_SMS_CustomFormatAndTruncate(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	conf := testConfig(server.URL)
+	conf.SMSFormat = template.Must(template.New("sms").Parse(`[{{.Topic}}] {{.Message}}`))
+	c := NewClient(conf)
+	require.Nil(t, c.SMS(context.Background(), "+11122233344", &SMSData{Topic: "mytopic", Message: strings.Repeat("ä", 2000)}, ""))
+
+	form, err := url.ParseQuery(body)
+	require.Nil(t, err)
+	require.Equal(t, 1600, utf8.RuneCountInString(form.Get("Body")))
+	require.True(t, strings.HasPrefix(form.Get("Body"), "[mytopic] ää"))
+	require.False(t, form.Has("StatusCallback"))
+}
+
+func TestClient_SMS_TwilioError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":21211,"message":"Invalid 'To' Phone Number"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(testConfig(server.URL))
+	err := c.SMS(context.Background(), "+invalid", &SMSData{Topic: "mytopic", Message: "hi there"}, "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "400")
+}
+
+func TestClient_SMS_TransportError(t *testing.T) {
+	c := NewClient(testConfig(closedServerURL(t)))
+	require.Error(t, c.SMS(context.Background(), "+11122233344", &SMSData{Topic: "mytopic", Message: "hi there"}, ""))
+}
+
+func TestClient_ValidateSignature(t *testing.T) {
+	c := NewClient(testConfig("http://127.0.0.1"))
+	requestURL := "https://ntfy.example.com/v1/twilio/sms/status?id=abc"
+	params := url.Values{
+		"MessageSid":    {"SM123"},
+		"MessageStatus": {"delivered"},
+		"AccountSid":    {"AC1234567890"},
+	}
+	signature := base64.StdEncoding.EncodeToString(telephony.Signature("AAEAA1234567890", requestURL, params))
+	require.True(t, c.ValidateSignature(requestURL, params, signature))
+
+	// Any change to the URL, params or signature must invalidate it
+	require.False(t, c.ValidateSignature("https://ntfy.example.com/v1/twilio/sms/status?id=xyz", params, signature))
+	tampered := url.Values{"MessageSid": {"SM123"}, "MessageStatus": {"failed"}, "AccountSid": {"AC1234567890"}}
+	require.False(t, c.ValidateSignature(requestURL, tampered, signature))
+	require.False(t, c.ValidateSignature(requestURL, params, ""))
+	require.False(t, c.ValidateSignature(requestURL, params, "not base64!"))
+	require.False(t, NewClient(&Config{AuthToken: "other"}).ValidateSignature(requestURL, params, signature))
+}
+
 // closedServerURL returns the URL of a server that is not listening anymore, to simulate an
 // unreachable Twilio API
 func closedServerURL(t *testing.T) string {
@@ -290,12 +377,13 @@ func closedServerURL(t *testing.T) string {
 
 func testConfig(baseURL string) *Config {
 	return &Config{
-		Account:       "AC1234567890",
-		AuthToken:     "AAEAA1234567890",
-		PhoneNumber:   "+1234567890",
-		CallsBaseURL:  baseURL,
-		VerifyBaseURL: baseURL,
-		VerifyService: "VA1234567890",
-		BuildVersion:  "1.2.3",
+		Account:         "AC1234567890",
+		AuthToken:       "AAEAA1234567890",
+		PhoneNumber:     "+1234567890",
+		CallsBaseURL:    baseURL,
+		MessagesBaseURL: baseURL,
+		VerifyBaseURL:   baseURL,
+		VerifyService:   "VA1234567890",
+		BuildVersion:    "1.2.3",
 	}
 }
@@ -20,11 +20,18 @@ import (
 // pattern[username] is the linear-scan list of %-bearing rules for that user.
 // Walked per request; trivially small in practice. Wildcards are NOT u_everyone-
 // only -- any user can create them.
+//
+// groupExact, groupPattern and groups index user_group_access and
+// user_group_member the same way, keyed by group name. They are always
+// reloaded as a whole (see ReloadGroups), since group tables are small.
 type accessCache struct {
-	exact   map[string]map[string]aclEntry
-	pattern map[string][]aclEntry
-	seq     uint64       // Bumped on every reload; lets a full reload detect a per-user reload that raced its scan
-	mu      sync.RWMutex // Protect exact, pattern, and seq
+	exact        map[string]map[string]aclEntry
+	pattern      map[string][]aclEntry
+	groupExact   map[string]map[string]aclEntry
+	groupPattern map[string][]aclEntry
+	groups       map[string][]string // Username -> names of the groups the user is a member of
+	seq          uint64              // Bumped on every reload; lets a full reload detect a per-user reload that raced its scan
+	mu           sync.RWMutex        // Protect all maps, and seq
 }
 
 // testHookReloadScanned, if non-nil, is invoked by Reload after the DB scan but
@@ -46,15 +53,18 @@ type aclEntry struct {
 
 func newAccessCache() *accessCache {
 	return &accessCache{
-		exact:   make(map[string]map[string]aclEntry),
-		pattern: make(map[string][]aclEntry),
+		exact:        make(map[string]map[string]aclEntry),
+		pattern:      make(map[string][]aclEntry),
+		groupExact:   make(map[string]map[string]aclEntry),
+		groupPattern: make(map[string][]aclEntry),
+		groups:       make(map[string][]string),
 	}
 }
 
 // Lookup returns the effective (read, write, found) permission for the given
-// (username, topic), preserving the priority ordering of the original SQL query:
-//  1. specific user beats Everyone
-//  2. longer pattern beats shorter (more specific wins)
+// (username, topic), preserving the priority ordering of the original SQL queries:
+//  1. specific user beats the user's groups, which beat Everyone
+//  2. longer pattern beats shorter (more specific wins), also across groups
 //  3. write beats read at equal length (write is "stronger")
 func (c *accessCache) Lookup(username, topic string) (read, write, found bool) {
 	escapedTopic := escapeUnderscore(topic)
@@ -65,6 +75,11 @@ func (c *accessCache) Lookup(username, topic string) (read, write, found bool) {
 			maybeLogACLDecision(username, username, topic, entry.read, entry.write)
 			return entry.read, entry.write, true
 		}
+		if group, entry, found := c.lookupGroupsNoLock(username, topic, escapedTopic); found {
+			c.mu.RUnlock()
+			maybeLogACLDecision(username, "group:"+group, topic, entry.read, entry.write)
+			return entry.read, entry.write, true
+		}
 	}
 	if entry, found := c.lookupNoLock(Everyone, topic, escapedTopic); found {
 		c.mu.RUnlock()
@@ -172,6 +187,69 @@ func (c *accessCache) Reload(d *db.DB, query string, usernames ...string) error
 	return nil
 }
 
+// ReloadGroups scans all group access entries (group_name, topic, read, write)
+// and group memberships (group_name, user_name), and replaces the group part of
+// the cache. Unlike a full Reload, it is never skipped: it does not touch the
+// per-user maps, so it cannot race with a per-user reload.
+func (c *accessCache) ReloadGroups(d *db.DB, accessQuery, membersQuery string) error {
+	started := time.Now()
+	rows, err := d.Query(accessQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	exacts := make(map[string]map[string]aclEntry)
+	patterns := make(map[string][]aclEntry)
+	for rows.Next() {
+		var group, escapedTopic string
+		var read, write bool
+		if err := rows.Scan(&group, &escapedTopic, &read, &write); err != nil {
+			return err
+		}
+		entry, hasWildcard, err := toACLEntry(escapedTopic, read, write)
+		if err != nil {
+			return err
+		}
+		if hasWildcard {
+			patterns[group] = append(patterns[group], entry)
+		} else {
+			if exacts[group] == nil {
+				exacts[group] = make(map[string]aclEntry)
+			}
+			exacts[group][escapedTopic] = entry
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	memberRows, err := d.Query(membersQuery)
+	if err != nil {
+		return err
+	}
+	defer memberRows.Close()
+	groups := make(map[string][]string)
+	for memberRows.Next() {
+		var group, username string
+		if err := memberRows.Scan(&group, &username); err != nil {
+			return err
+		}
+		groups[username] = append(groups[username], group)
+	}
+	if err := memberRows.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.groupExact = exacts
+	c.groupPattern = patterns
+	c.groups = groups
+	c.mu.Unlock()
+	log.Tag(tag).
+		Field("reload_scope", "groups").
+		Field("duration_ms", time.Since(started).Milliseconds()).
+		Debug("ACL cache reloaded")
+	return nil
+}
+
 // lookupNoLock returns the highest-priority entry for a single user. When
 // more than one of that user's rules matches the requested topic, the winner
 // is chosen by:
@@ -184,14 +262,31 @@ func (c *accessCache) Reload(d *db.DB, query string, usernames ...string) error
 // an exact "foo" (length 3) beats a wildcard "f%" (length 2), but a wildcard
 // "foo%" (length 4) beats an exact "foo" (length 3).
 func (c *accessCache) lookupNoLock(username, topic, escapedTopic string) (*aclEntry, bool) {
+	return lookupEntries(c.exact[username], c.pattern[username], topic, escapedTopic)
+}
+
+// lookupGroupsNoLock returns the highest-priority entry across all groups of
+// the given user, ranked by the same criteria as lookupNoLock, as well as the
+// name of the group it belongs to.
+func (c *accessCache) lookupGroupsNoLock(username, topic, escapedTopic string) (string, *aclEntry, bool) {
+	var best *aclEntry
+	var bestGroup string
+	for _, group := range c.groups[username] {
+		entry, found := lookupEntries(c.groupExact[group], c.groupPattern[group], topic, escapedTopic)
+		if found && (best == nil || better(*entry, *best)) {
+			best, bestGroup = entry, group
+		}
+	}
+	return bestGroup, best, best != nil
+}
+
+func lookupEntries(exact map[string]aclEntry, patterns []aclEntry, topic, escapedTopic string) (*aclEntry, bool) {
 	var best aclEntry
 	var found bool
-	if exact, exists := c.exact[username]; exists {
-		if entry, exists := exact[escapedTopic]; exists {
-			best, found = entry, true
-		}
+	if entry, exists := exact[escapedTopic]; exists {
+		best, found = entry, true
 	}
-	for _, pattern := range c.pattern[username] {
+	for _, pattern := range patterns {
 		if !pattern.pattern.MatchString(topic) {
 			continue
 		} else if !found || better(pattern, best) {
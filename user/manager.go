@@ -2,6 +2,9 @@
 package user
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
@@ -27,13 +30,17 @@ const (
 	syncTopicLength                 = 16
 	userIDPrefix                    = "u_"
 	userIDLength                    = 12
+	groupIDPrefix                   = "gr_"
+	groupIDLength                   = 12
 	userAuthIntentionalSlowDownHash = "$2a$10$YFCQvqQDwIIwnJM1xkAYOeih0dg17UVGanaTStnrSzC8NCWxcLDwy" // Cost should match DefaultUserPasswordBcryptCost
 	userHardDeleteAfterDuration     = 7 * 24 * time.Hour
 	tokenPrefix                     = "tk_"
 	tokenLength                     = 32
-	tokenMaxCount                   = 60 // Only keep this many tokens in the table per user
+	tokenMaxCount                   = 60  // Only keep this many tokens in the table per user
+	auditDefaultLimit               = 100 // Number of audit log entries returned if AuditFilter.Limit is not set
 	tag                             = "user_manager"
 	schemaStore                     = "user" // Store name in the schema_version table (see db/schema)
+	identityProviderLDAP            = "ldap" // Provider name of users linked to the LDAP directory, see Config.LDAP
 )
 
 // Default constants that may be overridden by configs
@@ -42,7 +49,8 @@ const (
 	DefaultUserPasswordBcryptCost       = 10
 	DefaultAccessCacheEnabled           = false
 	DefaultAccessCacheReloadInterval    = 87 * time.Second
-	DefaultExpiredMagicLinkReapInterval = time.Hour // How often expired email-verify/password-reset links are swept
+	DefaultExpiredMagicLinkReapInterval = time.Hour   // How often expired email-verify/password-reset links are swept
+	DefaultLDAPCacheDuration            = time.Minute // How long a successful LDAP authentication is reused, see authenticateLDAP
 )
 
 var (
@@ -56,13 +64,22 @@ type Manager struct {
 	config      *Config
 	db          *db.DB
 	queries     queries
-	statsQueue  map[string]*Stats       // "Queue" to asynchronously write user stats to the database (UserID -> Stats)
-	tokenQueue  map[string]*TokenUpdate // "Queue" to asynchronously write token access stats to the database (Token ID -> TokenUpdate)
-	accessCache *accessCache            // In-memory snapshot of user_access; refreshed by maybeReloadAccessCache after every ACL mutation
-	quit        chan struct{}           // Closed by Close() to signal background goroutines to stop
+	statsQueue  map[string]*Stats          // "Queue" to asynchronously write user stats to the database (UserID -> Stats)
+	tokenQueue  map[string]*TokenUpdate    // "Queue" to asynchronously write token access stats to the database (Token ID -> TokenUpdate)
+	accessCache *accessCache               // In-memory snapshot of user_access; refreshed by maybeReloadAccessCache after every ACL mutation
+	ldapCache   map[string]*ldapCacheEntry // Username -> last successful LDAP authentication, see authenticateLDAP
+	ldapKey     []byte                     // Random key for the MACs in ldapCache, so that passwords are never kept in memory
+	quit        chan struct{}              // Closed by Close() to signal background goroutines to stop
+	ldapMu      sync.Mutex                 // Protects ldapCache
 	mu          sync.Mutex
 }
 
+// ldapCacheEntry is a successful LDAP authentication, identified by a MAC of the username and password
+type ldapCacheEntry struct {
+	mac     []byte
+	expires time.Time
+}
+
 var _ Auther = (*Manager)(nil)
 
 func newManager(d *db.DB, queries queries, config *Config) (*Manager, error) {
@@ -78,11 +95,20 @@ func newManager(d *db.DB, queries queries, config *Config) (*Manager, error) {
 	if config.ExpiredMagicLinkReapInterval <= 0 {
 		config.ExpiredMagicLinkReapInterval = DefaultExpiredMagicLinkReapInterval
 	}
+	if config.LDAPCacheDuration <= 0 {
+		config.LDAPCacheDuration = DefaultLDAPCacheDuration
+	}
+	ldapKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(ldapKey); err != nil {
+		return nil, err
+	}
 	manager := &Manager{
 		config:     config,
 		db:         d,
 		statsQueue: make(map[string]*Stats),
 		tokenQueue: make(map[string]*TokenUpdate),
+		ldapCache:  make(map[string]*ldapCacheEntry),
+		ldapKey:    ldapKey,
 		quit:       make(chan struct{}),
 		queries:    queries,
 	}
@@ -103,18 +129,30 @@ func newManager(d *db.DB, queries queries, config *Config) (*Manager, error) {
 
 // maybeReloadAccessCache refreshes the in-memory access cache from the
 // primary database. No-op when the cache is disabled. With no usernames it
-// does a full bulk reload; with one or more it refreshes only those users'
-// slices in a single DB round-trip via an IN clause.
+// does a full bulk reload (including groups); with one or more it refreshes
+// only those users' slices in a single DB round-trip via an IN clause.
 func (a *Manager) maybeReloadAccessCache(usernames ...string) error {
 	if a.accessCache == nil {
 		return nil
 	}
 	if len(usernames) == 0 {
-		return a.accessCache.Reload(a.db, a.queries.selectAccessCacheAll)
+		if err := a.accessCache.Reload(a.db, a.queries.selectAccessCacheAll); err != nil {
+			return err
+		}
+		return a.maybeReloadGroupAccessCache()
 	}
 	return a.accessCache.Reload(a.db, a.queries.selectAccessCacheUsers(len(usernames)), usernames...)
 }
 
+// maybeReloadGroupAccessCache refreshes the group entries and memberships of the
+// in-memory access cache. No-op when the cache is disabled.
+func (a *Manager) maybeReloadGroupAccessCache() error {
+	if a.accessCache == nil {
+		return nil
+	}
+	return a.accessCache.ReloadGroups(a.db, a.queries.selectGroupAccessCacheAll, a.queries.selectGroupMembersAll)
+}
+
 // asyncAccessCacheReloadLoop periodically bulk-reloads the access cache so that
 // writes made by other processes against the same database (most notably the
 // `ntfy access` CLI subcommand running while a server holds the cache) become
@@ -164,6 +202,18 @@ func (a *Manager) Authenticate(identifier, password string) (*User, error) {
 		return nil, ErrUnauthenticated
 	}
 	user, err := a.userByNameOrEmail(identifier)
+	if a.config.LDAP != nil {
+		if errors.Is(err, ErrUserNotFound) && AllowedUsername(identifier) {
+			return a.authenticateLDAP(nil, identifier, password) // May be an LDAP user that never logged in before
+		} else if err == nil && !user.Deleted {
+			ldapUser, err := a.hasIdentity(user.ID, identityProviderLDAP)
+			if err != nil {
+				return nil, err
+			} else if ldapUser {
+				return a.authenticateLDAP(user, user.Name, password)
+			}
+		}
+	}
 	if err != nil {
 		log.Tag(tag).Field("user_name", identifier).Err(err).Trace("Authentication of user failed (1)")
 		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
@@ -179,6 +229,93 @@ func (a *Manager) Authenticate(identifier, password string) (*User, error) {
 	return user, nil
 }
 
+// authenticateLDAP verifies the password against the LDAP directory (Config.LDAP), creates the user on first
+// login (if user is nil), and syncs the role and ACL entries with the user's LDAP groups. The user's password hash
+// in the local database is never used for LDAP users; it is empty for users created here.
+//
+// Since Basic auth sends the password with every request, successful authentications are reused for
+// Config.LDAPCacheDuration, so that the directory is not queried (and the role and ACL entries are not synced)
+// on every request.
+func (a *Manager) authenticateLDAP(user *User, username, password string) (*User, error) {
+	if user != nil && a.ldapCached(username, password) {
+		return user, nil
+	}
+	groups, err := a.config.LDAP.Authenticate(username, password)
+	if err != nil {
+		log.Tag(tag).Field("user_name", username).Err(err).Debug("LDAP authentication of user failed")
+		return nil, ErrUnauthenticated
+	}
+	if user == nil {
+		if err := a.AddUserWithIdentity(username, RoleUser, identityProviderLDAP, username); err == nil {
+			log.Tag(tag).Field("user_name", username).Info("Created user from LDAP directory")
+		} else if !errors.Is(err, ErrUserExists) { // User may have been created by a concurrent login
+			return nil, err
+		}
+		if user, err = a.User(username); err != nil {
+			return nil, err
+		}
+	}
+	if len(a.config.LDAPAdminGroups) > 0 {
+		role := RoleUser
+		if slices.ContainsFunc(a.config.LDAPAdminGroups, func(group string) bool { return slices.Contains(groups, group) }) {
+			role = RoleAdmin
+		}
+		if role != user.Role {
+			log.Tag(tag).Field("user_name", username).Info("Changing role of LDAP user from %s to %s", user.Role, role)
+			if err := a.ChangeRole(username, role); err != nil {
+				return nil, err
+			}
+			user.Role = role
+		}
+	}
+	if len(a.config.LDAPAccess) > 0 && user.Role != RoleAdmin {
+		if err := a.SyncGroupAccess(username, groups, a.config.LDAPAccess); err != nil {
+			return nil, err
+		}
+	}
+	a.cacheLDAP(username, password)
+	return user, nil
+}
+
+// ldapCached returns true if the user was recently authenticated against the LDAP directory with the same password
+func (a *Manager) ldapCached(username, password string) bool {
+	a.ldapMu.Lock()
+	defer a.ldapMu.Unlock()
+	entry, ok := a.ldapCache[username]
+	return ok && time.Now().Before(entry.expires) && hmac.Equal(entry.mac, a.ldapMAC(username, password))
+}
+
+// cacheLDAP remembers a successful LDAP authentication, and removes expired ones
+func (a *Manager) cacheLDAP(username, password string) {
+	a.ldapMu.Lock()
+	defer a.ldapMu.Unlock()
+	now := time.Now()
+	for name, entry := range a.ldapCache {
+		if !now.Before(entry.expires) {
+			delete(a.ldapCache, name)
+		}
+	}
+	a.ldapCache[username] = &ldapCacheEntry{
+		mac:     a.ldapMAC(username, password),
+		expires: now.Add(a.config.LDAPCacheDuration),
+	}
+}
+
+func (a *Manager) ldapMAC(username, password string) []byte {
+	mac := hmac.New(sha256.New, a.ldapKey)
+	mac.Write([]byte(username + "\x00" + password))
+	return mac.Sum(nil)
+}
+
+// hasIdentity returns true if the user is linked to an identity of the given provider, see AddUserWithIdentity
+func (a *Manager) hasIdentity(userID, provider string) (bool, error) {
+	var exists bool
+	if err := a.db.QueryRow(a.queries.selectIdentityExists, userID, provider).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
 // AuthenticateToken checks if the token exists and returns the associated User if it does.
 // The method sets the User.Token value to the token that was used for authentication.
 func (a *Manager) AuthenticateToken(token string) (*User, error) {
@@ -205,6 +342,29 @@ func (a *Manager) AddUser(username, password string, role Role, hashed bool) err
 	})
 }
 
+// AddUserWithIdentity adds a user with the given username and role, and links it to the external identity with the
+// given provider (e.g. "oidc") and subject, see UserByIdentity. The user has no password, so it cannot log in with
+// a password, only via the provider. It returns ErrUserExists if the username is taken, and ErrIdentityExists if the
+// identity is already linked to another user.
+func (a *Manager) AddUserWithIdentity(username string, role Role, provider, subject string) error {
+	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if err := a.addUserTx(tx, username, "", role, false); err != nil {
+			return err
+		}
+		var userID string
+		if err := tx.QueryRow(a.queries.selectUserIDFromUsername, username).Scan(&userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(a.queries.insertIdentity, provider, subject, userID, time.Now().Unix()); err != nil {
+			if isUniqueConstraintError(err) {
+				return ErrIdentityExists
+			}
+			return err
+		}
+		return nil
+	})
+}
+
 // addUserTx adds a user with the given username, password hash and role to the database
 func (a *Manager) addUserTx(tx *sql.Tx, username, hash string, role Role, provisioned bool) error {
 	if !AllowedUsername(username) || !AllowedRole(role) {
@@ -234,8 +394,11 @@ func (a *Manager) RemoveUser(username string) error {
 	if err != nil {
 		return err
 	}
-	// Reload user-specific parts of the access cache
-	return a.maybeReloadAccessCache(username, Everyone)
+	// Reload user-specific parts of the access cache, and the group memberships (deleted via foreign keys)
+	if err := a.maybeReloadAccessCache(username, Everyone); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
 }
 
 // removeUserTx deletes the user with the given username
@@ -490,9 +653,10 @@ func (a *Manager) writeUserStatsQueue() error {
 					"messages_count": update.Messages,
 					"emails_count":   update.Emails,
 					"calls_count":    update.Calls,
+					"sms_count":      update.SMS,
 				}).
 				Trace("Updating stats for user %s", userID)
-			if _, err := tx.Exec(a.queries.updateUserStats, update.Messages, update.Emails, update.Calls, userID); err != nil {
+			if _, err := tx.Exec(a.queries.updateUserStats, update.Messages, update.Emails, update.Calls, update.SMS, userID); err != nil {
 				return err
 			}
 		}
@@ -568,6 +732,16 @@ func (a *Manager) UserByStripeCustomer(customerID string) (*User, error) {
 	return a.readUser(rows)
 }
 
+// UserByIdentity returns the user that is linked to the external identity with the given provider and subject
+// (see AddUserWithIdentity) if it exists, or ErrUserNotFound otherwise
+func (a *Manager) UserByIdentity(provider, subject string) (*User, error) {
+	rows, err := a.db.Query(a.queries.selectUserByIdentity, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	return a.readUser(rows)
+}
+
 // Users returns a list of users. It loads all users in a single query
 // rather than one query per user to avoid N+1 performance issues.
 func (a *Manager) Users() ([]*User, error) {
@@ -624,9 +798,9 @@ func (a *Manager) scanUser(rows *sql.Rows) (*User, error) {
 	var id, username, hash, role, prefs, syncTopic string
 	var provisioned bool
 	var stripeCustomerID, stripeSubscriptionID, stripeSubscriptionStatus, stripeSubscriptionInterval, stripeMonthlyPriceID, stripeYearlyPriceID, tierID, tierCode, tierName sql.NullString
-	var messages, emails, calls int64
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, stripeSubscriptionPaidUntil, stripeSubscriptionCancelAt, deleted sql.NullInt64
-	if err := rows.Scan(&id, &username, &hash, &role, &prefs, &syncTopic, &provisioned, &messages, &emails, &calls, &stripeCustomerID, &stripeSubscriptionID, &stripeSubscriptionStatus, &stripeSubscriptionInterval, &stripeSubscriptionPaidUntil, &stripeSubscriptionCancelAt, &deleted, &tierID, &tierCode, &tierName, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	var messages, emails, calls, sms int64
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, smsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, stripeSubscriptionPaidUntil, stripeSubscriptionCancelAt, deleted sql.NullInt64
+	if err := rows.Scan(&id, &username, &hash, &role, &prefs, &syncTopic, &provisioned, &messages, &emails, &calls, &sms, &stripeCustomerID, &stripeSubscriptionID, &stripeSubscriptionStatus, &stripeSubscriptionInterval, &stripeSubscriptionPaidUntil, &stripeSubscriptionCancelAt, &deleted, &tierID, &tierCode, &tierName, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &smsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -643,6 +817,7 @@ func (a *Manager) scanUser(rows *sql.Rows) (*User, error) {
 			Messages: messages,
 			Emails:   emails,
 			Calls:    calls,
+			SMS:      sms,
 		},
 		Billing: &Billing{
 			StripeCustomerID:            stripeCustomerID.String,                                            // May be empty
@@ -667,6 +842,7 @@ func (a *Manager) scanUser(rows *sql.Rows) (*User, error) {
 			MessageExpiryDuration:    time.Duration(messagesExpiryDuration.Int64) * time.Second,
 			EmailLimit:               emailsLimit.Int64,
 			CallLimit:                callsLimit.Int64,
+			SMSLimit:                 smsLimit.Int64,
 			ReservationLimit:         reservationsLimit.Int64,
 			AttachmentFileSizeLimit:  attachmentFileSizeLimit.Int64,
 			AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
@@ -783,13 +959,60 @@ func (a *Manager) resetAccessTx(tx *sql.Tx, username string, topicPattern string
 	return a.resetTopicAccessTx(tx, username, topicPattern)
 }
 
+// SyncGroupAccess syncs the ACL entries of a user with the groups it is a member of in an external identity
+// provider (e.g. LDAP or OpenID Connect). The access map maps groups to the grants of their members. The user is
+// granted access to all topic patterns that are mapped to one of its groups (combining the permissions of multiple
+// groups), and access to mapped topic patterns that none of its groups grant anymore is revoked. Topic patterns that
+// are not in the map, and topics reserved by the user, are left alone.
+func (a *Manager) SyncGroupAccess(username string, groups []string, access map[string][]*Grant) error {
+	grants, err := a.Grants(username)
+	if err != nil {
+		return err
+	}
+	reservations, err := a.Reservations(username)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]Permission)
+	for _, grant := range grants {
+		existing[grant.TopicPattern] = grant.Permission
+	}
+	wanted := make(map[string]Permission)
+	for group, groupGrants := range access {
+		for _, grant := range groupGrants {
+			p := wanted[grant.TopicPattern] // PermissionDenyAll if not set
+			if slices.Contains(groups, group) {
+				p = NewPermission(p.IsRead() || grant.Permission.IsRead(), p.IsWrite() || grant.Permission.IsWrite())
+			}
+			wanted[grant.TopicPattern] = p
+		}
+	}
+	for pattern, permission := range wanted {
+		reserved := slices.ContainsFunc(reservations, func(r Reservation) bool { return r.Topic == pattern })
+		current, granted := existing[pattern]
+		if reserved || (granted && current == permission) {
+			continue
+		} else if permission != PermissionDenyAll {
+			if err := a.AllowAccess(username, pattern, permission); err != nil {
+				return err
+			}
+		} else if granted {
+			if err := a.ResetAccess(username, pattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // DefaultAccess returns the default read/write access if no access control entry matches
 func (a *Manager) DefaultAccess() Permission {
 	return a.config.DefaultAccess
 }
 
 // AllowReservation tests if a user may create an access control entry for the given topic.
-// If there are any ACL entries that are not owned by the user, an error is returned.
+// If there are any ACL entries (including group entries) that are not owned by the user, an
+// error is returned.
 func (a *Manager) AllowReservation(username string, topic string) error {
 	if (!AllowedUsername(username) && username != Everyone) || !AllowedTopic(topic) {
 		return ErrInvalidArgument
@@ -808,14 +1031,14 @@ func (a *Manager) AllowReservation(username string, topic string) error {
 // The found return value indicates whether an ACL entry was found at all.
 //
 // Priority:
-//   - Specific user beats Everyone
+//   - Specific user beats the user's groups, which beat Everyone
 //   - Longer pattern beats shorter (a more specific rule beats a more general one,
-//     e.g. "test*" > "*")
+//     e.g. "test*" > "*"), also across groups
 //   - Write beats read at equal length
 //
 // When AccessCacheEnabled is true (config), the lookup is served entirely from
 // the in-memory snapshot maintained by accessCache. Otherwise the original SQL
-// query is executed against the database on every call.
+// queries are executed against the database on every call.
 func (a *Manager) authorizeTopicAccess(usernameOrEveryone, topic string) (read, write, found bool, err error) {
 	if a.accessCache != nil {
 		read, write, found = a.accessCache.Lookup(usernameOrEveryone, topic)
@@ -826,13 +1049,41 @@ func (a *Manager) authorizeTopicAccess(usernameOrEveryone, topic string) (read,
 		return false, false, false, err
 	}
 	defer rows.Close()
+	var matchedUser string
+	if rows.Next() {
+		if err := rows.Scan(&matchedUser, &read, &write); err != nil {
+			return false, false, false, err
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return false, false, false, err
+	} else if found && matchedUser == usernameOrEveryone {
+		return read, write, true, nil // User-specific entry, or Everyone entry for anonymous users
+	} else if usernameOrEveryone != Everyone {
+		groupRead, groupWrite, groupFound, err := a.authorizeGroupTopicAccess(usernameOrEveryone, topic)
+		if err != nil {
+			return false, false, false, err
+		} else if groupFound {
+			return groupRead, groupWrite, true, nil
+		}
+	}
+	return read, write, found, nil // Everyone entry, or no entry at all
+}
+
+// authorizeGroupTopicAccess returns the read/write permissions for the given topic granted to the
+// user by any of its groups, see authorizeTopicAccess
+func (a *Manager) authorizeGroupTopicAccess(username, topic string) (read, write, found bool, err error) {
+	rows, err := a.db.ReadOnly().Query(a.queries.selectGroupTopicPerms, username, topic)
+	if err != nil {
+		return false, false, false, err
+	}
+	defer rows.Close()
 	if !rows.Next() {
-		return false, false, false, nil
+		return false, false, false, rows.Err()
 	}
 	if err := rows.Scan(&read, &write); err != nil {
 		return false, false, false, err
-	} else if err := rows.Err(); err != nil {
-		return false, false, false, err
 	}
 	return read, write, true, nil
 }
@@ -899,6 +1150,9 @@ func (a *Manager) AddReservation(username string, topic string, everyone Permiss
 		return ErrInvalidArgument
 	}
 	err := db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if err := a.canChangeReservationTx(tx, username, topic); err != nil {
+			return err
+		}
 		if limit > 0 {
 			hasReservation, err := a.hasReservationTx(tx, username, topic)
 			if err != nil {
@@ -943,6 +1197,9 @@ func (a *Manager) RemoveReservations(username string, topics ...string) error {
 	}
 	err := db.ExecTx(a.db, func(tx *sql.Tx) error {
 		for _, topic := range topics {
+			if err := a.canChangeReservationTx(tx, username, topic); err != nil {
+				return err
+			}
 			if err := a.removeReservationAccessTx(tx, username, topic); err != nil {
 				return err
 			}
@@ -973,22 +1230,38 @@ func (a *Manager) reservationsTx(tx db.Querier, username string) ([]Reservation,
 	reservations := make([]Reservation, 0)
 	for rows.Next() {
 		var topic string
-		var ownerRead, ownerWrite bool
+		var ownerRead, ownerWrite, provisioned bool
 		var everyoneRead, everyoneWrite sql.NullBool
-		if err := rows.Scan(&topic, &ownerRead, &ownerWrite, &everyoneRead, &everyoneWrite); err != nil {
+		if err := rows.Scan(&topic, &ownerRead, &ownerWrite, &everyoneRead, &everyoneWrite, &provisioned); err != nil {
 			return nil, err
 		} else if err := rows.Err(); err != nil {
 			return nil, err
 		}
 		reservations = append(reservations, Reservation{
-			Topic:    fromSQLWildcard(topic),
-			Owner:    NewPermission(ownerRead, ownerWrite),
-			Everyone: NewPermission(everyoneRead.Bool, everyoneWrite.Bool),
+			Topic:       fromSQLWildcard(topic),
+			Owner:       NewPermission(ownerRead, ownerWrite),
+			Everyone:    NewPermission(everyoneRead.Bool, everyoneWrite.Bool),
+			Provisioned: provisioned,
 		})
 	}
 	return reservations, nil
 }
 
+// canChangeReservationTx checks if the user's reservation of the given topic can be changed. If the
+// reservation is provisioned, it cannot be changed. Topics that are not reserved can always be changed.
+func (a *Manager) canChangeReservationTx(tx db.Querier, username, topic string) error {
+	reservations, err := a.reservationsTx(tx, username)
+	if err != nil {
+		return err
+	}
+	for _, reservation := range reservations {
+		if reservation.Topic == topic && reservation.Provisioned {
+			return ErrProvisionedReservationChange
+		}
+	}
+	return nil
+}
+
 // HasReservation returns true if the given topic access is owned by the user
 func (a *Manager) HasReservation(username, topic string) (bool, error) {
 	return a.hasReservationTx(a.db, username, topic)
@@ -1060,13 +1333,19 @@ func (a *Manager) RemoveExcessReservations(username string, limit int64) ([]stri
 		if int64(len(reservations)) <= limit {
 			return []string{}, nil
 		}
+		// Provisioned reservations are defined in the config file, so they are never removed
 		removedTopics := make([]string, 0)
-		for i := int64(len(reservations)) - 1; i >= limit; i-- {
+		excess := int64(len(reservations)) - limit
+		for i := len(reservations) - 1; i >= 0 && excess > 0; i-- {
+			if reservations[i].Provisioned {
+				continue
+			}
 			topic := reservations[i].Topic
 			if err := a.removeReservationAccessTx(tx, username, topic); err != nil {
 				return nil, err
 			}
 			removedTopics = append(removedTopics, topic)
+			excess--
 		}
 		return removedTopics, nil
 	})
@@ -1085,7 +1364,11 @@ func (a *Manager) RemoveExcessReservations(username string, limit int64) ([]stri
 
 // otherAccessCount returns the number of access entries for the given topic that are not owned by the user
 func (a *Manager) otherAccessCount(username, topic string) (int, error) {
-	rows, err := a.db.Query(a.queries.selectOtherAccessCount, escapeUnderscore(topic), escapeUnderscore(topic), username)
+	return a.otherAccessCountTx(a.db, username, topic)
+}
+
+func (a *Manager) otherAccessCountTx(tx db.Querier, username, topic string) (int, error) {
+	rows, err := tx.Query(a.queries.selectOtherAccessCount, escapeUnderscore(topic), escapeUnderscore(topic), username, escapeUnderscore(topic), escapeUnderscore(topic))
 	if err != nil {
 		return 0, err
 	}
@@ -1125,113 +1408,539 @@ func (a *Manager) resetTopicAccessTx(tx *sql.Tx, username, topicPattern string)
 	return err
 }
 
-// CreateToken generates a random token for the given user and returns it. The token expires
-// after a fixed duration unless ChangeToken is called. This function also prunes tokens for the
-// given user, if there are too many of them.
-func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr, provisioned bool) (*Token, error) {
-	return db.QueryTx(a.db, func(tx *sql.Tx) (*Token, error) {
-		return a.createTokenTx(tx, userID, GenerateToken(), label, time.Now(), origin, expires, tokenMaxCount, provisioned)
-	})
+// AddGroup creates a new, empty group with the given name
+func (a *Manager) AddGroup(name string) error {
+	if !AllowedGroup(name) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.Group(name); err == nil {
+		return ErrGroupExists
+	} else if !errors.Is(err, ErrGroupNotFound) {
+		return err
+	}
+	groupID := util.RandomStringPrefix(groupIDPrefix, groupIDLength)
+	if _, err := a.db.Exec(a.queries.insertGroup, groupID, name, time.Now().Unix()); err != nil {
+		return err
+	}
+	return nil
 }
 
-// createTokenTx creates a new token and prunes excess tokens if the count exceeds maxTokenCount.
-// If maxTokenCount is 0, no pruning is performed.
-func (a *Manager) createTokenTx(tx *sql.Tx, userID, token, label string, lastAccess time.Time, lastOrigin netip.Addr, expires time.Time, maxTokenCount int, provisioned bool) (*Token, error) {
-	if _, err := tx.Exec(a.queries.upsertToken, userID, token, label, lastAccess.Unix(), lastOrigin.String(), expires.Unix(), provisioned); err != nil {
+// RemoveGroup deletes the group with the given name. Its memberships, access control entries and
+// reservations (including the Everyone entries of its reservations) are deleted via foreign keys.
+func (a *Manager) RemoveGroup(name string) error {
+	group, err := a.Group(name)
+	if err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(a.queries.deleteGroup, group.ID); err != nil {
+		return err
+	}
+	if err := a.maybeReloadAccessCache(Everyone); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
+}
+
+// Group returns the group with the given name, including its members
+func (a *Manager) Group(name string) (*Group, error) {
+	group := &Group{}
+	if err := a.db.QueryRow(a.queries.selectGroupByName, name).Scan(&group.ID, &group.Name); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGroupNotFound
+	} else if err != nil {
 		return nil, err
 	}
-	if maxTokenCount > 0 {
-		var tokenCount int
-		if err := tx.QueryRow(a.queries.selectTokenCount, userID).Scan(&tokenCount); err != nil {
+	rows, err := a.db.Query(a.queries.selectGroupMembers, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	group.Members = make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
 			return nil, err
 		}
-		if tokenCount > maxTokenCount {
-			// This pruning logic is done in two queries for efficiency. The SELECT above is a lookup
-			// on two indices, whereas the query below is a full table scan.
-			if _, err := tx.Exec(a.queries.deleteExcessTokens, userID, userID, maxTokenCount); err != nil {
-				return nil, err
-			}
-		}
+		group.Members = append(group.Members, username)
 	}
-	return &Token{
-		Value:       token,
-		Label:       label,
-		LastAccess:  lastAccess,
-		LastOrigin:  lastOrigin,
-		Expires:     expires,
-		Provisioned: provisioned,
-	}, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return group, nil
 }
 
-// ChangeToken updates a token's label and/or expiry date
-func (a *Manager) ChangeToken(userID, token string, label *string, expires *time.Time) (*Token, error) {
-	if token == "" {
-		return nil, errNoTokenProvided
+// Groups returns all groups, including their members, sorted by name
+func (a *Manager) Groups() ([]*Group, error) {
+	rows, err := a.db.Query(a.queries.selectGroups)
+	if err != nil {
+		return nil, err
 	}
-	if err := a.canChangeToken(userID, token); err != nil {
+	defer rows.Close()
+	groups := make([]*Group, 0)
+	groupsByName := make(map[string]*Group)
+	for rows.Next() {
+		group := &Group{Members: make([]string, 0)}
+		if err := rows.Scan(&group.ID, &group.Name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+		groupsByName[group.Name] = group
+	}
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	t, err := a.Token(userID, token)
+	memberRows, err := a.db.Query(a.queries.selectGroupMembersAll)
 	if err != nil {
 		return nil, err
 	}
-	if label != nil {
-		t.Label = *label
+	defer memberRows.Close()
+	for memberRows.Next() {
+		var name, username string
+		if err := memberRows.Scan(&name, &username); err != nil {
+			return nil, err
+		}
+		if group, ok := groupsByName[name]; ok {
+			group.Members = append(group.Members, username)
+		}
 	}
-	if expires != nil {
-		t.Expires = *expires
+	if err := memberRows.Err(); err != nil {
+		return nil, err
 	}
-	if _, err := a.db.Exec(a.queries.updateToken, t.Label, t.Expires.Unix(), userID, token); err != nil {
+	return groups, nil
+}
+
+// UserGroups returns the names of the groups the given user is a member of, sorted by name
+func (a *Manager) UserGroups(username string) ([]string, error) {
+	rows, err := a.db.Query(a.queries.selectUserGroups, username)
+	if err != nil {
 		return nil, err
 	}
-	return t, nil
+	defer rows.Close()
+	groups := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
 }
 
-// RemoveToken deletes the token defined in User.Token
-func (a *Manager) RemoveToken(userID, token string) error {
-	if err := a.canChangeToken(userID, token); err != nil {
+// AddGroupMember adds the user to the group. The function returns nil if the user is
+// already a member of the group.
+func (a *Manager) AddGroupMember(group, username string) error {
+	g, u, err := a.groupAndUser(group, username)
+	if err != nil {
 		return err
 	}
-	if token == "" {
-		return errNoTokenProvided
-	}
-	if _, err := a.db.Exec(a.queries.deleteToken, userID, token); err != nil {
+	if _, err := a.db.Exec(a.queries.insertGroupMember, g.ID, u.ID); err != nil {
 		return err
 	}
-	return nil
+	return a.maybeReloadGroupAccessCache()
 }
 
-// canChangeToken checks if the token can be changed. If the token is provisioned, it cannot be changed.
-func (a *Manager) canChangeToken(userID, token string) error {
-	t, err := a.Token(userID, token)
+// RemoveGroupMember removes the user from the group. The function returns nil if the user
+// was not a member of the group in the first place.
+func (a *Manager) RemoveGroupMember(group, username string) error {
+	g, u, err := a.groupAndUser(group, username)
 	if err != nil {
 		return err
-	} else if t.Provisioned {
-		return ErrProvisionedTokenChange
 	}
-	return nil
+	if _, err := a.db.Exec(a.queries.deleteGroupMember, g.ID, u.ID); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
 }
 
-// Token returns a specific token for a user
-func (a *Manager) Token(userID, token string) (*Token, error) {
-	rows, err := a.db.ReadOnly().Query(a.queries.selectToken, userID, token)
+func (a *Manager) groupAndUser(group, username string) (*Group, *User, error) {
+	g, err := a.Group(group)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer rows.Close()
-	return a.readToken(rows)
+	u, err := a.User(username)
+	if err != nil {
+		return nil, nil, err
+	} else if u.Name == Everyone {
+		return nil, nil, ErrInvalidArgument
+	}
+	return g, u, nil
 }
 
-// Tokens returns all existing tokens for the user with the given user ID
-func (a *Manager) Tokens(userID string) ([]*Token, error) {
-	// Primary read: backs GET /account (read-your-writes after a sync event).
-	rows, err := a.db.Query(a.queries.selectTokens, userID)
+// AllowGroupAccess adds or updates an access control entry for all members of a group. The parameter
+// topicPattern may include wildcards (*). Entries of a user take precedence over entries of its groups.
+func (a *Manager) AllowGroupAccess(group, topicPattern string, permission Permission) error {
+	if !AllowedTopicPattern(topicPattern) {
+		return ErrInvalidArgument
+	}
+	g, err := a.Group(group)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-	tokens := make([]*Token, 0)
-	for {
+	if _, err := a.db.Exec(a.queries.upsertGroupAccess, g.ID, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), false); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
+}
+
+// ResetGroupAccess removes the access control entry of a group for the given topic pattern, or (if topicPattern
+// is empty) all entries of the group. Reservations of the group are removed as well.
+func (a *Manager) ResetGroupAccess(group, topicPattern string) error {
+	if !AllowedTopicPattern(topicPattern) && topicPattern != "" {
+		return ErrInvalidArgument
+	}
+	g, err := a.Group(group)
+	if err != nil {
+		return err
+	}
+	err = db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if topicPattern == "" {
+			if _, err := tx.Exec(a.queries.deleteGroupAccess, g.ID); err != nil {
+				return err
+			}
+			_, err := tx.Exec(a.queries.deleteGroupOwnedAccess, g.ID)
+			return err
+		}
+		return a.removeGroupTopicAccessTx(tx, g.ID, topicPattern)
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.maybeReloadAccessCache(Everyone); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
+}
+
+// GroupGrants returns all access control entries of a group, including its reservations
+func (a *Manager) GroupGrants(group string) ([]Grant, error) {
+	g, err := a.Group(group)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.db.Query(a.queries.selectGroupAccess, g.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	grants := make([]Grant, 0)
+	for rows.Next() {
+		var topic string
+		var read, write, reserved bool
+		if err := rows.Scan(&topic, &read, &write, &reserved); err != nil {
+			return nil, err
+		}
+		grants = append(grants, Grant{
+			TopicPattern: fromSQLWildcard(topic),
+			Permission:   NewPermission(read, write),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// AllowGroupReservation tests if a group may reserve the given topic. If there are any ACL entries
+// that are not owned by the group, an error is returned.
+func (a *Manager) AllowGroupReservation(group, topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	g, err := a.Group(group)
+	if err != nil {
+		return err
+	}
+	var count int
+	escapedTopic := escapeUnderscore(topic)
+	if err := a.db.QueryRow(a.queries.selectGroupOtherAccessCount, escapedTopic, escapedTopic, g.ID, escapedTopic, escapedTopic, g.ID).Scan(&count); err != nil {
+		return err
+	} else if count > 0 {
+		return errTopicOwnedByOthers
+	}
+	return nil
+}
+
+// AddGroupReservation creates (or updates) a reservation of a topic that is shared by all members of a
+// group: the members get read/write access, and Everyone gets the given permission. Any member can
+// change or remove the reservation. Unlike user reservations, group reservations are not counted
+// towards the reservation limit of a tier.
+func (a *Manager) AddGroupReservation(group, topic string, everyone Permission) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	g, err := a.Group(group)
+	if err != nil {
+		return err
+	}
+	err = db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(a.queries.upsertGroupAccess, g.ID, toSQLWildcard(topic), true, true, true); err != nil {
+			return err
+		}
+		_, err := tx.Exec(a.queries.upsertGroupOwnedAccess, Everyone, toSQLWildcard(topic), everyone.IsRead(), everyone.IsWrite(), g.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.maybeReloadAccessCache(Everyone); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
+}
+
+// RemoveGroupReservations deletes the reservations of the given topics by the group, as well
+// as the Everyone entries of the reservations
+func (a *Manager) RemoveGroupReservations(group string, topics ...string) error {
+	if len(topics) == 0 {
+		return ErrInvalidArgument
+	}
+	for _, topic := range topics {
+		if !AllowedTopic(topic) {
+			return ErrInvalidArgument
+		}
+	}
+	g, err := a.Group(group)
+	if err != nil {
+		return err
+	}
+	err = db.ExecTx(a.db, func(tx *sql.Tx) error {
+		for _, topic := range topics {
+			if err := a.removeGroupTopicAccessTx(tx, g.ID, topic); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.maybeReloadAccessCache(Everyone); err != nil {
+		return err
+	}
+	return a.maybeReloadGroupAccessCache()
+}
+
+// GroupReservations returns all topics reserved by the group, and the associated everyone-access
+func (a *Manager) GroupReservations(group string) ([]Reservation, error) {
+	g, err := a.Group(group)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := a.db.Query(a.queries.selectGroupReservations, Everyone, g.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reservations := make([]Reservation, 0)
+	for rows.Next() {
+		var topic string
+		var ownerRead, ownerWrite bool
+		var everyoneRead, everyoneWrite sql.NullBool
+		if err := rows.Scan(&topic, &ownerRead, &ownerWrite, &everyoneRead, &everyoneWrite); err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, Reservation{
+			Topic:    fromSQLWildcard(topic),
+			Owner:    NewPermission(ownerRead, ownerWrite),
+			Everyone: NewPermission(everyoneRead.Bool, everyoneWrite.Bool),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// GroupReservationOwner returns the name of the group that reserved this topic, or an empty string
+// if it's not reserved by a group
+func (a *Manager) GroupReservationOwner(topic string) (string, error) {
+	var group string
+	if err := a.db.QueryRow(a.queries.selectGroupReservationOwner, escapeUnderscore(topic)).Scan(&group); errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return group, nil
+}
+
+func (a *Manager) removeGroupTopicAccessTx(tx *sql.Tx, groupID, topicPattern string) error {
+	if _, err := tx.Exec(a.queries.deleteGroupTopicAccess, groupID, toSQLWildcard(topicPattern)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(a.queries.deleteGroupOwnedTopicAccess, groupID, toSQLWildcard(topicPattern))
+	return err
+}
+
+// AddAuditEntry appends an entry to the audit log. If the entry's time is not set, the current time is used.
+func (a *Manager) AddAuditEntry(entry *AuditEntry) error {
+	created := entry.Time
+	if created.IsZero() {
+		created = time.Now()
+	}
+	var origin string
+	if entry.Origin.IsValid() {
+		origin = entry.Origin.String()
+	}
+	_, err := a.db.Exec(a.queries.insertAuditEntry, created.Unix(), entry.Actor, string(entry.Source), string(entry.Action), entry.Target, entry.Before, entry.After, origin)
+	return err
+}
+
+// AuditEntries returns the entries of the audit log matching the given filter, newest first
+func (a *Manager) AuditEntries(filter *AuditFilter) ([]*AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = auditDefaultLimit
+	}
+	var since int64
+	if !filter.Since.IsZero() {
+		since = filter.Since.Unix()
+	}
+	rows, err := a.db.Query(a.queries.selectAuditEntries, filter.Actor, filter.Actor, filter.Target, filter.Target, filter.Action, filter.Action+"%", since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		var id, created int64
+		var actor, source, action, target, before, after, origin string
+		if err := rows.Scan(&id, &created, &actor, &source, &action, &target, &before, &after, &origin); err != nil {
+			return nil, err
+		}
+		entry := &AuditEntry{
+			ID:     id,
+			Time:   time.Unix(created, 0),
+			Actor:  actor,
+			Source: AuditSource(source),
+			Action: AuditAction(action),
+			Target: target,
+			Before: before,
+			After:  after,
+		}
+		if origin != "" {
+			entry.Origin, err = netip.ParseAddr(origin)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CreateToken generates a random token for the given user and returns it. The token expires
+// after a fixed duration unless ChangeToken is called. This function also prunes tokens for the
+// given user, if there are too many of them.
+func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr, provisioned bool) (*Token, error) {
+	return db.QueryTx(a.db, func(tx *sql.Tx) (*Token, error) {
+		return a.createTokenTx(tx, userID, GenerateToken(), label, time.Now(), origin, expires, tokenMaxCount, provisioned)
+	})
+}
+
+// createTokenTx creates a new token and prunes excess tokens if the count exceeds maxTokenCount.
+// If maxTokenCount is 0, no pruning is performed.
+func (a *Manager) createTokenTx(tx *sql.Tx, userID, token, label string, lastAccess time.Time, lastOrigin netip.Addr, expires time.Time, maxTokenCount int, provisioned bool) (*Token, error) {
+	if _, err := tx.Exec(a.queries.upsertToken, userID, token, label, lastAccess.Unix(), lastOrigin.String(), expires.Unix(), provisioned); err != nil {
+		return nil, err
+	}
+	if maxTokenCount > 0 {
+		var tokenCount int
+		if err := tx.QueryRow(a.queries.selectTokenCount, userID).Scan(&tokenCount); err != nil {
+			return nil, err
+		}
+		if tokenCount > maxTokenCount {
+			// This pruning logic is done in two queries for efficiency. The SELECT above is a lookup
+			// on two indices, whereas the query below is a full table scan.
+			if _, err := tx.Exec(a.queries.deleteExcessTokens, userID, userID, maxTokenCount); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Token{
+		Value:       token,
+		Label:       label,
+		LastAccess:  lastAccess,
+		LastOrigin:  lastOrigin,
+		Expires:     expires,
+		Provisioned: provisioned,
+	}, nil
+}
+
+// ChangeToken updates a token's label and/or expiry date
+func (a *Manager) ChangeToken(userID, token string, label *string, expires *time.Time) (*Token, error) {
+	if token == "" {
+		return nil, errNoTokenProvided
+	}
+	if err := a.canChangeToken(userID, token); err != nil {
+		return nil, err
+	}
+	t, err := a.Token(userID, token)
+	if err != nil {
+		return nil, err
+	}
+	if label != nil {
+		t.Label = *label
+	}
+	if expires != nil {
+		t.Expires = *expires
+	}
+	if _, err := a.db.Exec(a.queries.updateToken, t.Label, t.Expires.Unix(), userID, token); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RemoveToken deletes the token defined in User.Token
+func (a *Manager) RemoveToken(userID, token string) error {
+	if err := a.canChangeToken(userID, token); err != nil {
+		return err
+	}
+	if token == "" {
+		return errNoTokenProvided
+	}
+	if _, err := a.db.Exec(a.queries.deleteToken, userID, token); err != nil {
+		return err
+	}
+	return nil
+}
+
+// canChangeToken checks if the token can be changed. If the token is provisioned, it cannot be changed.
+func (a *Manager) canChangeToken(userID, token string) error {
+	t, err := a.Token(userID, token)
+	if err != nil {
+		return err
+	} else if t.Provisioned {
+		return ErrProvisionedTokenChange
+	}
+	return nil
+}
+
+// Token returns a specific token for a user
+func (a *Manager) Token(userID, token string) (*Token, error) {
+	rows, err := a.db.ReadOnly().Query(a.queries.selectToken, userID, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readToken(rows)
+}
+
+// Tokens returns all existing tokens for the user with the given user ID
+func (a *Manager) Tokens(userID string) ([]*Token, error) {
+	// Primary read: backs GET /account (read-your-writes after a sync event).
+	rows, err := a.db.Query(a.queries.selectTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]*Token, 0)
+	for {
 		token, err := a.readToken(rows)
 		if errors.Is(err, ErrTokenNotFound) {
 			break
@@ -1336,27 +2045,44 @@ func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
 
 // AddTier creates a new tier in the database
 func (a *Manager) AddTier(tier *Tier) error {
+	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+		return a.addTierTx(tx, tier)
+	})
+}
+
+func (a *Manager) addTierTx(tx *sql.Tx, tier *Tier) error {
 	if tier.ID == "" {
 		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
 	}
-	if _, err := a.db.Exec(a.queries.insertTier, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
+	if _, err := tx.Exec(a.queries.insertTier, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.SMSLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Provisioned); err != nil {
 		return err
 	}
 	return nil
 }
 
-// UpdateTier updates a tier's properties in the database
+// UpdateTier updates a tier's properties in the database. Provisioned tiers cannot be changed.
 func (a *Manager) UpdateTier(tier *Tier) error {
-	if _, err := a.db.Exec(a.queries.updateTier, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code); err != nil {
+	if err := a.canChangeTier(tier.Code); err != nil {
+		return err
+	}
+	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+		return a.updateTierTx(tx, tier)
+	})
+}
+
+func (a *Manager) updateTierTx(tx *sql.Tx, tier *Tier) error {
+	if _, err := tx.Exec(a.queries.updateTier, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.SMSLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Provisioned, tier.Code); err != nil {
 		return err
 	}
 	return nil
 }
 
-// RemoveTier deletes the tier with the given code
+// RemoveTier deletes the tier with the given code. Provisioned tiers cannot be removed.
 func (a *Manager) RemoveTier(code string) error {
 	if !AllowedTier(code) {
 		return ErrInvalidArgument
+	} else if err := a.canChangeTier(code); err != nil {
+		return err
 	}
 	// This fails if any user has this tier
 	if _, err := a.db.Exec(a.queries.deleteTier, code); err != nil {
@@ -1365,6 +2091,20 @@ func (a *Manager) RemoveTier(code string) error {
 	return nil
 }
 
+// canChangeTier checks if the tier can be changed. If the tier is provisioned, it cannot be changed.
+// Tiers that do not exist can be "changed", to keep the behavior of UpdateTier and RemoveTier.
+func (a *Manager) canChangeTier(code string) error {
+	t, err := a.Tier(code)
+	if errors.Is(err, ErrTierNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if t.Provisioned {
+		return ErrProvisionedTierChange
+	}
+	return nil
+}
+
 // Tiers returns a list of all Tier structs
 func (a *Manager) Tiers() ([]*Tier, error) {
 	rows, err := a.db.ReadOnly().Query(a.queries.selectTiers)
@@ -1408,11 +2148,12 @@ func (a *Manager) TierByStripePrice(priceID string) (*Tier, error) {
 func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
 	var id, code, name string
 	var stripeMonthlyPriceID, stripeYearlyPriceID sql.NullString
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, smsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
+	var provisioned bool
 	if !rows.Next() {
 		return nil, ErrTierNotFound
 	}
-	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &smsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID, &provisioned); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -1426,6 +2167,7 @@ func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
 		MessageExpiryDuration:    time.Duration(messagesExpiryDuration.Int64) * time.Second,
 		EmailLimit:               emailsLimit.Int64,
 		CallLimit:                callsLimit.Int64,
+		SMSLimit:                 smsLimit.Int64,
 		ReservationLimit:         reservationsLimit.Int64,
 		AttachmentFileSizeLimit:  attachmentFileSizeLimit.Int64,
 		AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
@@ -1433,6 +2175,7 @@ func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
 		AttachmentBandwidthLimit: attachmentBandwidthLimit.Int64,
 		StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
 		StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
+		Provisioned:              provisioned,
 	}, nil
 }
 
@@ -1745,27 +2488,28 @@ func (a *Manager) VerifyEmail(rawToken string) (*MagicLink, error) {
 // ResetPassword consumes a password-reset magic link, identified by its raw token: after
 // validating the token (kind + expiry), it sets the user's password and deletes the link in one
 // transaction. Existing access tokens are intentionally left valid (only the password changes).
-// Returns ErrMagicLinkNotFound if the token is invalid, expired, or not a reset token.
-func (a *Manager) ResetPassword(rawToken, newPassword string) error {
+// Returns the user whose password was reset, or ErrMagicLinkNotFound if the token is invalid, expired,
+// or not a reset token.
+func (a *Manager) ResetPassword(rawToken, newPassword string) (*User, error) {
 	m, err := a.MagicLinkByHash(hashToken(rawToken))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if m.Kind != MagicLinkKindPasswordReset || time.Now().Unix() > m.Expires {
-		return ErrMagicLinkNotFound
+		return nil, ErrMagicLinkNotFound
 	}
 	u, err := a.UserByID(m.UserID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if u.Provisioned {
-		return ErrProvisionedUserChange // Provisioned users get their password from the config file, not reset
+		return nil, ErrProvisionedUserChange // Provisioned users get their password from the config file, not reset
 	}
 	hash, err := HashPassword(newPassword, a.config.BcryptCost)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+	err = db.ExecTx(a.db, func(tx *sql.Tx) error {
 		if err := a.changePasswordHashTx(tx, u.Name, hash); err != nil {
 			return err
 		}
@@ -1774,6 +2518,10 @@ func (a *Manager) ResetPassword(rawToken, newPassword string) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
 // deleteExpiredMagicLinks removes magic links whose expiry has passed. Expiry is also enforced
@@ -1797,6 +2545,131 @@ func (a *Manager) readEmail(rows *sql.Rows) (*Email, error) {
 	return &Email{Address: address, Primary: primary}, nil
 }
 
+// TOTPEnabled returns true if TOTP two-factor authentication is enabled for the user, see EnableTOTP
+func (a *Manager) TOTPEnabled(userID string) (bool, error) {
+	_, enabled, _, err := a.readTOTP(userID)
+	if errors.Is(err, ErrTOTPNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// AddTOTPSecret generates a new TOTP secret for the user and returns it, e.g. to show it as a QR code
+// (see TOTPProvisioningURI). The secret is only used once it is confirmed with EnableTOTP. Calling this
+// again replaces a pending secret, but fails with ErrTOTPEnabled if TOTP is already enabled.
+func (a *Manager) AddTOTPSecret(userID string) (string, error) {
+	secret := generateTOTPSecret()
+	res, err := a.db.Exec(a.queries.upsertTOTP, userID, secret, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	} else if affected == 0 {
+		return "", ErrTOTPEnabled
+	}
+	return secret, nil
+}
+
+// EnableTOTP enables TOTP two-factor authentication for the user, if the code matches the pending secret
+// (see AddTOTPSecret). It returns new recovery codes, which can be used instead of a TOTP code if the
+// authenticator is lost (see VerifyTOTP). The recovery codes are stored hashed, and cannot be shown again.
+func (a *Manager) EnableTOTP(userID, code string) ([]string, error) {
+	secret, enabled, _, err := a.readTOTP(userID)
+	if err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, ErrTOTPEnabled
+	}
+	counter, ok := validateTOTPCode(secret, code, time.Now())
+	if !ok {
+		return nil, ErrTOTPCodeInvalid
+	}
+	codes := generateRecoveryCodes()
+	err = db.ExecTx(a.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(a.queries.updateTOTPEnabled, counter, userID)
+		if err != nil {
+			return err
+		} else if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrTOTPEnabled // Enabled concurrently
+		}
+		if _, err := tx.Exec(a.queries.deleteRecoveryCodes, userID); err != nil {
+			return err
+		}
+		for _, code := range codes {
+			if _, err := tx.Exec(a.queries.insertRecoveryCode, userID, hashRecoveryCode(code)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyTOTP checks the second factor of a user that has TOTP enabled. The code is either a TOTP code, which
+// is only accepted once to prevent replays, or one of the recovery codes, which is removed when used.
+func (a *Manager) VerifyTOTP(userID, code string) error {
+	secret, enabled, lastCounter, err := a.readTOTP(userID)
+	if err != nil {
+		return err
+	} else if !enabled {
+		return ErrTOTPNotFound
+	}
+	if counter, ok := validateTOTPCode(secret, code, time.Now()); ok {
+		if counter <= lastCounter {
+			return ErrTOTPCodeInvalid // Code was already used
+		}
+		res, err := a.db.Exec(a.queries.updateTOTPLastCounter, counter, userID, counter)
+		if err != nil {
+			return err
+		} else if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrTOTPCodeInvalid // Code was used concurrently
+		}
+		return nil
+	}
+	res, err := a.db.Exec(a.queries.deleteRecoveryCode, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	} else if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrTOTPCodeInvalid
+	}
+	log.Tag(tag).Field("user_id", userID).Info("Recovery code used for two-factor authentication")
+	return nil
+}
+
+// RemoveTOTP disables TOTP two-factor authentication for the user, and removes the secret and recovery codes
+func (a *Manager) RemoveTOTP(userID string) error {
+	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(a.queries.deleteTOTP, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(a.queries.deleteRecoveryCodes, userID)
+		return err
+	})
+}
+
+func (a *Manager) readTOTP(userID string) (secret string, enabled bool, lastCounter int64, err error) {
+	err = a.db.QueryRow(a.queries.selectTOTP, userID).Scan(&secret, &enabled, &lastCounter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, 0, ErrTOTPNotFound
+	} else if err != nil {
+		return "", false, 0, err
+	}
+	return secret, enabled, lastCounter, nil
+}
+
 // ChangeBilling updates a user's billing fields
 func (a *Manager) ChangeBilling(username string, billing *Billing) error {
 	if _, err := a.db.Exec(a.queries.updateBilling, nullString(billing.StripeCustomerID), nullString(billing.StripeSubscriptionID), nullString(string(billing.StripeSubscriptionStatus)), nullString(string(billing.StripeSubscriptionInterval)), nullInt64(billing.StripeSubscriptionPaidUntil.Unix()), nullInt64(billing.StripeSubscriptionCancelAt.Unix()), username); err != nil {
@@ -1805,15 +2678,21 @@ func (a *Manager) ChangeBilling(username string, billing *Billing) error {
 	return nil
 }
 
-// maybeProvisionUsersAccessAndTokens provisions users, access control entries, and tokens based on the config.
+// maybeProvisionUsersAccessAndTokens provisions tiers, users, access control entries, reservations, and
+// tokens based on the config.
 func (a *Manager) maybeProvisionUsersAccessAndTokens() error {
 	if !a.config.ProvisionEnabled {
 		return nil
 	}
+	// Tiers are few, so they are always reconciled, even in the fast path below
+	existingTiers, err := a.Tiers()
+	if err != nil {
+		return err
+	}
 	// If there is nothing to provision, remove any previously provisioned items using
 	// cheap targeted queries, avoiding the expensive Users() call that loads all users.
-	if len(a.config.Users) == 0 && len(a.config.Access) == 0 && len(a.config.Tokens) == 0 {
-		return a.removeAllProvisioned()
+	if len(a.config.Users) == 0 && len(a.config.Access) == 0 && len(a.config.Tokens) == 0 && len(a.config.Reservations) == 0 {
+		return a.removeAllProvisioned(existingTiers)
 	}
 	// If there are provisioned users, do it the slow way
 	existingUsers, err := a.Users()
@@ -1828,12 +2707,18 @@ func (a *Manager) maybeProvisionUsersAccessAndTokens() error {
 		return err
 	}
 	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if err := a.maybeProvisionTiers(tx, existingTiers); err != nil {
+			return fmt.Errorf("failed to provision tiers: %v", err)
+		}
 		if err := a.maybeProvisionUsers(tx, provisionUsernames, existingUsers); err != nil {
 			return fmt.Errorf("failed to provision users: %v", err)
 		}
 		if err := a.maybeProvisionGrants(tx); err != nil {
 			return fmt.Errorf("failed to provision grants: %v", err)
 		}
+		if err := a.maybeProvisionReservations(tx, provisionUsernames); err != nil {
+			return fmt.Errorf("failed to provision reservations: %v", err)
+		}
 		if err := a.maybeProvisionTokens(tx, provisionUsernames, existingTokens); err != nil {
 			return fmt.Errorf("failed to provision tokens: %v", err)
 		}
@@ -1841,10 +2726,14 @@ func (a *Manager) maybeProvisionUsersAccessAndTokens() error {
 	})
 }
 
-// removeAllProvisioned removes all provisioned users, access entries, and tokens. This is the fast path
-// for when there is nothing to provision, avoiding the expensive Users() call.
-func (a *Manager) removeAllProvisioned() error {
+// removeAllProvisioned removes all provisioned users, access entries, reservations, and tokens, and
+// reconciles the provisioned tiers. This is the fast path for when there are no users to provision,
+// avoiding the expensive Users() call.
+func (a *Manager) removeAllProvisioned(existingTiers []*Tier) error {
 	return db.ExecTx(a.db, func(tx *sql.Tx) error {
+		if err := a.maybeProvisionTiers(tx, existingTiers); err != nil {
+			return fmt.Errorf("failed to provision tiers: %v", err)
+		}
 		if _, err := tx.Exec(a.queries.deleteUserAccessProvisioned); err != nil {
 			return err
 		}
@@ -1858,6 +2747,48 @@ func (a *Manager) removeAllProvisioned() error {
 	})
 }
 
+// maybeProvisionTiers checks if the tiers in the config are provisioned, and adds or updates them.
+// It also removes tiers that are provisioned, but not in the config anymore. Users that still have
+// a removed tier are reset to having no tier.
+func (a *Manager) maybeProvisionTiers(tx *sql.Tx, existingTiers []*Tier) error {
+	provisionCodes := util.Map(a.config.Tiers, func(t *Tier) string {
+		return t.Code
+	})
+	// Remove tiers that are provisioned, but not in the config anymore
+	for _, tier := range existingTiers {
+		if tier.Provisioned && !slices.Contains(provisionCodes, tier.Code) {
+			if _, err := tx.Exec(a.queries.deleteTierFromUsers, tier.Code); err != nil {
+				return fmt.Errorf("failed to reset users of provisioned tier %s: %v", tier.Code, err)
+			}
+			if _, err := tx.Exec(a.queries.deleteTier, tier.Code); err != nil {
+				return fmt.Errorf("failed to remove provisioned tier %s: %v", tier.Code, err)
+			}
+		}
+	}
+	// Add or update provisioned tiers
+	for _, tier := range a.config.Tiers {
+		provisionTier := *tier // Copy, so the config is not modified
+		provisionTier.Provisioned = true
+		existingTier, exists := util.Find(existingTiers, func(t *Tier) bool {
+			return t.Code == tier.Code
+		})
+		if !exists {
+			provisionTier.ID = ""
+			if err := a.addTierTx(tx, &provisionTier); err != nil {
+				return fmt.Errorf("failed to add provisioned tier %s: %v", tier.Code, err)
+			}
+		} else {
+			provisionTier.ID = existingTier.ID
+			if provisionTier != *existingTier {
+				if err := a.updateTierTx(tx, &provisionTier); err != nil {
+					return fmt.Errorf("failed to update provisioned tier %s: %v", tier.Code, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // maybeProvisionUsers checks if the users in the config are provisioned, and adds or updates them.
 // It also removes users that are provisioned, but not in the config anymore.
 func (a *Manager) maybeProvisionUsers(tx *sql.Tx, provisionUsernames []string, existingUsers []*User) error {
@@ -1935,6 +2866,32 @@ func (a *Manager) maybeProvisionGrants(tx *sql.Tx) error {
 	return nil
 }
 
+// maybeProvisionReservations (re-)adds the reservations from the config. It must be called after
+// maybeProvisionGrants, which removes all provisioned access control entries, including the ones of
+// previously provisioned reservations.
+func (a *Manager) maybeProvisionReservations(tx *sql.Tx, provisionUsernames []string) error {
+	for username, reservations := range a.config.Reservations {
+		if !slices.Contains(provisionUsernames, username) {
+			return fmt.Errorf("user %s is not a provisioned user, refusing to add reservations", username)
+		}
+		for _, reservation := range reservations {
+			otherCount, err := a.otherAccessCountTx(tx, username, reservation.Topic)
+			if err != nil {
+				return err
+			} else if otherCount > 0 {
+				return fmt.Errorf("topic %s is already reserved or has access control entries of other users, refusing to reserve it for user %s", reservation.Topic, username)
+			}
+			if _, err := tx.Exec(a.queries.upsertUserAccess, username, toSQLWildcard(reservation.Topic), true, true, username, username, true); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(a.queries.upsertUserAccess, Everyone, toSQLWildcard(reservation.Topic), reservation.Everyone.IsRead(), reservation.Everyone.IsWrite(), username, username, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (a *Manager) maybeProvisionTokens(tx *sql.Tx, provisionUsernames []string, existingTokens []*Token) error {
 	// Remove tokens that are provisioned, but not in the config anymore
 	var provisionTokens []string
@@ -12,7 +12,7 @@ import (
 const (
 	// User queries
 	postgresSelectUsersQuery = `
-		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM "user" u
 		LEFT JOIN tier t on t.id = u.tier_id
 		ORDER BY
@@ -23,19 +23,19 @@ const (
 			END, u.user_name
 	`
 	postgresSelectUserByIDQuery = `
-		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM "user" u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.id = $1
 	`
 	postgresSelectUserByNameQuery = `
-		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM "user" u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE user_name = $1
 	`
 	postgresSelectUserByNameOrPrimaryEmailQuery = `
-		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM "user" u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.user_name = $1
@@ -44,18 +44,25 @@ const (
 		LIMIT 1
 	`
 	postgresSelectUserByTokenQuery = `
-		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM "user" u
 		JOIN user_token tk on u.id = tk.user_id
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE tk.token = $1 AND (tk.expires = 0 OR tk.expires >= $2)
 	`
 	postgresSelectUserByStripeCustomerIDQuery = `
-		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM "user" u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.stripe_customer_id = $1
 	`
+	postgresSelectUserByIdentityQuery = `
+		SELECT u.id, u.user_name, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		FROM "user" u
+		JOIN user_identity i on u.id = i.user_id
+		LEFT JOIN tier t on t.id = u.tier_id
+		WHERE i.provider = $1 AND i.subject = $2
+	`
 	postgresSelectUsernamesQuery = `
 		SELECT user_name
 		FROM "user"
@@ -73,8 +80,8 @@ const (
 	postgresUpdateUserRoleQuery           = `UPDATE "user" SET role = $1 WHERE user_name = $2`
 	postgresUpdateUserProvisionedQuery    = `UPDATE "user" SET provisioned = $1 WHERE user_name = $2`
 	postgresUpdateUserPrefsQuery          = `UPDATE "user" SET prefs = $1 WHERE id = $2`
-	postgresUpdateUserStatsQuery          = `UPDATE "user" SET stats_messages = $1, stats_emails = $2, stats_calls = $3 WHERE id = $4`
-	postgresUpdateUserStatsResetAllQuery  = `UPDATE "user" SET stats_messages = 0, stats_emails = 0, stats_calls = 0`
+	postgresUpdateUserStatsQuery          = `UPDATE "user" SET stats_messages = $1, stats_emails = $2, stats_calls = $3, stats_sms = $4 WHERE id = $5`
+	postgresUpdateUserStatsResetAllQuery  = `UPDATE "user" SET stats_messages = 0, stats_emails = 0, stats_calls = 0, stats_sms = 0`
 	postgresUpdateUserTierQuery           = `UPDATE "user" SET tier_id = (SELECT id FROM tier WHERE code = $1) WHERE user_name = $2`
 	postgresUpdateUserDeletedQuery        = `UPDATE "user" SET deleted = $1 WHERE id = $2`
 	postgresDeleteUserQuery               = `DELETE FROM "user" WHERE user_name = $1`
@@ -84,7 +91,7 @@ const (
 
 	// Access queries
 	postgresSelectTopicPermsQuery = `
-		SELECT read, write
+		SELECT u.user_name, read, write
 		FROM user_access a
 		JOIN "user" u ON u.id = a.user_id
 		WHERE (u.user_name = $1 OR u.user_name = $2) AND $3 LIKE a.topic ESCAPE '\'
@@ -107,7 +114,7 @@ const (
 		ORDER BY LENGTH(topic) DESC, CASE WHEN write THEN 1 ELSE 0 END DESC, CASE WHEN read THEN 1 ELSE 0 END DESC, topic
 	`
 	postgresSelectUserReservationsQuery = `
-		SELECT a_user.topic, a_user.read, a_user.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write
+		SELECT a_user.topic, a_user.read, a_user.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write, a_user.provisioned
 		FROM user_access a_user
 		LEFT JOIN  user_access a_everyone ON a_user.topic = a_everyone.topic AND a_everyone.user_id = (SELECT id FROM "user" WHERE user_name = $1)
 		WHERE a_user.user_id = a_user.owner_user_id
@@ -134,10 +141,14 @@ const (
 		  AND topic = $2
 	`
 	postgresSelectOtherAccessCountQuery = `
-		SELECT COUNT(*)
-		FROM user_access
-		WHERE (topic = $1 OR $2 LIKE topic ESCAPE '\')
-		  AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM "user" WHERE user_name = $3))
+		SELECT
+			(SELECT COUNT(*)
+			 FROM user_access
+			 WHERE (topic = $1 OR $2 LIKE topic ESCAPE '\')
+			   AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM "user" WHERE user_name = $3)))
+			+ (SELECT COUNT(*)
+			 FROM user_group_access
+			 WHERE topic = $4 OR $5 LIKE topic ESCAPE '\')
 	`
 	postgresUpsertUserAccessQuery = `
 		INSERT INTO user_access (user_id, topic, read, write, owner_user_id, provisioned)
@@ -150,7 +161,7 @@ const (
 			$7
 		)
 		ON CONFLICT (user_id, topic)
-		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id, provisioned=excluded.provisioned
+		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id, owner_group_id=NULL, provisioned=excluded.provisioned
 	`
 	postgresDeleteUserAccessQuery = `
 		DELETE FROM user_access
@@ -197,29 +208,30 @@ const (
 
 	// Tier queries
 	postgresInsertTierQuery = `
-		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
 	postgresUpdateTierQuery = `
 		UPDATE tier
-		SET name = $1, messages_limit = $2, messages_expiry_duration = $3, emails_limit = $4, calls_limit = $5, reservations_limit = $6, attachment_file_size_limit = $7, attachment_total_size_limit = $8, attachment_expiry_duration = $9, attachment_bandwidth_limit = $10, stripe_monthly_price_id = $11, stripe_yearly_price_id = $12
-		WHERE code = $13
+		SET name = $1, messages_limit = $2, messages_expiry_duration = $3, emails_limit = $4, calls_limit = $5, sms_limit = $6, reservations_limit = $7, attachment_file_size_limit = $8, attachment_total_size_limit = $9, attachment_expiry_duration = $10, attachment_bandwidth_limit = $11, stripe_monthly_price_id = $12, stripe_yearly_price_id = $13, provisioned = $14
+		WHERE code = $15
 	`
 	postgresSelectTiersQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned
 		FROM tier
 	`
 	postgresSelectTierByCodeQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned
 		FROM tier
 		WHERE code = $1
 	`
 	postgresSelectTierByPriceIDQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned
 		FROM tier
 		WHERE (stripe_monthly_price_id = $1 OR stripe_yearly_price_id = $2)
 	`
-	postgresDeleteTierQuery = `DELETE FROM tier WHERE code = $1`
+	postgresDeleteTierQuery          = `DELETE FROM tier WHERE code = $1`
+	postgresDeleteTierFromUsersQuery = `UPDATE "user" SET tier_id = null WHERE tier_id = (SELECT id FROM tier WHERE code = $1)`
 
 	// Phone queries
 	postgresSelectPhoneNumbersQuery = `SELECT phone_number FROM user_phone WHERE user_id = $1`
@@ -245,7 +257,132 @@ const (
 	postgresSelectPendingEmailsQuery     = `SELECT email FROM user_magic_link WHERE kind = $1 AND user_id = $2 ORDER BY email`
 	postgresDeleteExpiredMagicLinksQuery = `DELETE FROM user_magic_link WHERE expires < $1`
 
+	// Identity queries
+	postgresInsertIdentityQuery       = `INSERT INTO user_identity (provider, subject, user_id, created) VALUES ($1, $2, $3, $4)`
+	postgresSelectIdentityExistsQuery = `SELECT EXISTS (SELECT 1 FROM user_identity WHERE user_id = $1 AND provider = $2)`
+
+	// TOTP queries
+	postgresSelectTOTPQuery = `SELECT secret, enabled, last_counter FROM user_totp WHERE user_id = $1`
+	postgresUpsertTOTPQuery = `
+		INSERT INTO user_totp (user_id, secret, enabled, last_counter, created) VALUES ($1, $2, FALSE, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, enabled = FALSE, last_counter = 0, created = excluded.created
+		WHERE NOT user_totp.enabled
+	`
+	postgresUpdateTOTPEnabledQuery     = `UPDATE user_totp SET enabled = TRUE, last_counter = $1 WHERE user_id = $2 AND NOT enabled`
+	postgresUpdateTOTPLastCounterQuery = `UPDATE user_totp SET last_counter = $1 WHERE user_id = $2 AND enabled AND last_counter < $3`
+	postgresDeleteTOTPQuery            = `DELETE FROM user_totp WHERE user_id = $1`
+	postgresInsertRecoveryCodeQuery    = `INSERT INTO user_recovery_code (user_id, code_hash) VALUES ($1, $2)`
+	postgresDeleteRecoveryCodeQuery    = `DELETE FROM user_recovery_code WHERE user_id = $1 AND code_hash = $2`
+	postgresDeleteRecoveryCodesQuery   = `DELETE FROM user_recovery_code WHERE user_id = $1`
+
+	// Group queries
+	postgresSelectGroupsQuery       = `SELECT id, name FROM user_group ORDER BY name`
+	postgresSelectGroupByNameQuery  = `SELECT id, name FROM user_group WHERE name = $1`
+	postgresSelectGroupMembersQuery = `
+		SELECT u.user_name
+		FROM user_group_member m
+		JOIN "user" u ON u.id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY u.user_name
+	`
+	postgresSelectGroupMembersAllQuery = `
+		SELECT g.name, u.user_name
+		FROM user_group_member m
+		JOIN user_group g ON g.id = m.group_id
+		JOIN "user" u ON u.id = m.user_id
+		ORDER BY g.name, u.user_name
+	`
+	postgresSelectUserGroupsQuery = `
+		SELECT g.name
+		FROM user_group g
+		JOIN user_group_member m ON m.group_id = g.id
+		JOIN "user" u ON u.id = m.user_id
+		WHERE u.user_name = $1
+		ORDER BY g.name
+	`
+	postgresInsertGroupQuery           = `INSERT INTO user_group (id, name, created) VALUES ($1, $2, $3)`
+	postgresDeleteGroupQuery           = `DELETE FROM user_group WHERE id = $1`
+	postgresInsertGroupMemberQuery     = `INSERT INTO user_group_member (group_id, user_id) VALUES ($1, $2) ON CONFLICT (group_id, user_id) DO NOTHING`
+	postgresDeleteGroupMemberQuery     = `DELETE FROM user_group_member WHERE group_id = $1 AND user_id = $2`
+	postgresSelectGroupTopicPermsQuery = `
+		SELECT a.read, a.write
+		FROM user_group_access a
+		JOIN user_group_member m ON m.group_id = a.group_id
+		JOIN "user" u ON u.id = m.user_id
+		WHERE u.user_name = $1 AND $2 LIKE a.topic ESCAPE '\'
+		ORDER BY LENGTH(a.topic) DESC, CASE WHEN a.write THEN 1 ELSE 0 END DESC
+	`
+	postgresSelectGroupAccessCacheAllQuery = `
+		SELECT g.name, a.topic, a.read, a.write
+		FROM user_group_access a
+		JOIN user_group g ON g.id = a.group_id
+	`
+	postgresSelectGroupAccessQuery = `
+		SELECT topic, read, write, reserved
+		FROM user_group_access
+		WHERE group_id = $1
+		ORDER BY LENGTH(topic) DESC, CASE WHEN write THEN 1 ELSE 0 END DESC, CASE WHEN read THEN 1 ELSE 0 END DESC, topic
+	`
+	postgresSelectGroupReservationsQuery = `
+		SELECT a_group.topic, a_group.read, a_group.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write
+		FROM user_group_access a_group
+		LEFT JOIN user_access a_everyone ON a_group.topic = a_everyone.topic AND a_everyone.owner_group_id = a_group.group_id AND a_everyone.user_id = (SELECT id FROM "user" WHERE user_name = $1)
+		WHERE a_group.group_id = $2
+		  AND a_group.reserved = true
+		ORDER BY a_group.topic
+	`
+	postgresSelectGroupReservationOwnerQuery = `
+		SELECT g.name
+		FROM user_group_access a
+		JOIN user_group g ON g.id = a.group_id
+		WHERE a.topic = $1
+		  AND a.reserved = true
+	`
+	postgresSelectGroupOtherAccessCountQuery = `
+		SELECT
+			(SELECT COUNT(*)
+			 FROM user_access
+			 WHERE (topic = $1 OR $2 LIKE topic ESCAPE '\')
+			   AND (owner_group_id IS NULL OR owner_group_id != $3))
+			+ (SELECT COUNT(*)
+			 FROM user_group_access
+			 WHERE (topic = $4 OR $5 LIKE topic ESCAPE '\')
+			   AND group_id != $6)
+	`
+	postgresUpsertGroupAccessQuery = `
+		INSERT INTO user_group_access (group_id, topic, read, write, reserved)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (group_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write, reserved=excluded.reserved
+	`
+	postgresUpsertGroupOwnedAccessQuery = `
+		INSERT INTO user_access (user_id, topic, read, write, owner_group_id, provisioned)
+		VALUES ((SELECT id FROM "user" WHERE user_name = $1), $2, $3, $4, $5, false)
+		ON CONFLICT (user_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=NULL, owner_group_id=excluded.owner_group_id, provisioned=excluded.provisioned
+	`
+	postgresDeleteGroupAccessQuery           = `DELETE FROM user_group_access WHERE group_id = $1`
+	postgresDeleteGroupTopicAccessQuery      = `DELETE FROM user_group_access WHERE group_id = $1 AND topic = $2`
+	postgresDeleteGroupOwnedAccessQuery      = `DELETE FROM user_access WHERE owner_group_id = $1`
+	postgresDeleteGroupOwnedTopicAccessQuery = `DELETE FROM user_access WHERE owner_group_id = $1 AND topic = $2`
+
 	// Billing queries
+	// Audit log queries
+	postgresInsertAuditEntryQuery = `
+		INSERT INTO user_audit (created, actor, source, action, target, old_value, new_value, origin)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	postgresSelectAuditEntriesQuery = `
+		SELECT id, created, actor, source, action, target, old_value, new_value, origin
+		FROM user_audit
+		WHERE ($1 = '' OR actor = $2)
+		  AND ($3 = '' OR target = $4)
+		  AND ($5 = '' OR action LIKE $6)
+		  AND created >= $7
+		ORDER BY id DESC
+		LIMIT $8
+	`
+
 	postgresUpdateBillingQuery = `
 		UPDATE "user"
 		SET stripe_customer_id = $1, stripe_subscription_id = $2, stripe_subscription_status = $3, stripe_subscription_interval = $4, stripe_subscription_paid_until = $5, stripe_subscription_cancel_at = $6
@@ -275,6 +412,7 @@ var postgresQueries = queries{
 	selectUserByNameOrPrimaryEmail: postgresSelectUserByNameOrPrimaryEmailQuery,
 	selectUserByToken:              postgresSelectUserByTokenQuery,
 	selectUserByStripeCustomerID:   postgresSelectUserByStripeCustomerIDQuery,
+	selectUserByIdentity:           postgresSelectUserByIdentityQuery,
 	selectUsernames:                postgresSelectUsernamesQuery,
 	selectUsers:                    postgresSelectUsersQuery,
 	selectUserCount:                postgresSelectUserCountQuery,
@@ -326,6 +464,7 @@ var postgresQueries = queries{
 	selectTierByPriceID:            postgresSelectTierByPriceIDQuery,
 	updateTier:                     postgresUpdateTierQuery,
 	deleteTier:                     postgresDeleteTierQuery,
+	deleteTierFromUsers:            postgresDeleteTierFromUsersQuery,
 	selectPhoneNumbers:             postgresSelectPhoneNumbersQuery,
 	insertPhoneNumber:              postgresInsertPhoneNumberQuery,
 	deletePhoneNumber:              postgresDeletePhoneNumberQuery,
@@ -344,6 +483,39 @@ var postgresQueries = queries{
 	deleteMagicLinkResetPassword:   postgresDeleteResetScopeQuery,
 	selectPendingEmails:            postgresSelectPendingEmailsQuery,
 	deleteExpiredMagicLinks:        postgresDeleteExpiredMagicLinksQuery,
+	insertIdentity:                 postgresInsertIdentityQuery,
+	selectIdentityExists:           postgresSelectIdentityExistsQuery,
+	selectTOTP:                     postgresSelectTOTPQuery,
+	upsertTOTP:                     postgresUpsertTOTPQuery,
+	updateTOTPEnabled:              postgresUpdateTOTPEnabledQuery,
+	updateTOTPLastCounter:          postgresUpdateTOTPLastCounterQuery,
+	deleteTOTP:                     postgresDeleteTOTPQuery,
+	insertRecoveryCode:             postgresInsertRecoveryCodeQuery,
+	deleteRecoveryCode:             postgresDeleteRecoveryCodeQuery,
+	deleteRecoveryCodes:            postgresDeleteRecoveryCodesQuery,
+	selectGroups:                   postgresSelectGroupsQuery,
+	selectGroupByName:              postgresSelectGroupByNameQuery,
+	selectGroupMembers:             postgresSelectGroupMembersQuery,
+	selectGroupMembersAll:          postgresSelectGroupMembersAllQuery,
+	selectUserGroups:               postgresSelectUserGroupsQuery,
+	insertGroup:                    postgresInsertGroupQuery,
+	deleteGroup:                    postgresDeleteGroupQuery,
+	insertGroupMember:              postgresInsertGroupMemberQuery,
+	deleteGroupMember:              postgresDeleteGroupMemberQuery,
+	selectGroupTopicPerms:          postgresSelectGroupTopicPermsQuery,
+	selectGroupAccessCacheAll:      postgresSelectGroupAccessCacheAllQuery,
+	selectGroupAccess:              postgresSelectGroupAccessQuery,
+	selectGroupReservations:        postgresSelectGroupReservationsQuery,
+	selectGroupReservationOwner:    postgresSelectGroupReservationOwnerQuery,
+	selectGroupOtherAccessCount:    postgresSelectGroupOtherAccessCountQuery,
+	upsertGroupAccess:              postgresUpsertGroupAccessQuery,
+	upsertGroupOwnedAccess:         postgresUpsertGroupOwnedAccessQuery,
+	deleteGroupAccess:              postgresDeleteGroupAccessQuery,
+	deleteGroupTopicAccess:         postgresDeleteGroupTopicAccessQuery,
+	deleteGroupOwnedAccess:         postgresDeleteGroupOwnedAccessQuery,
+	deleteGroupOwnedTopicAccess:    postgresDeleteGroupOwnedTopicAccessQuery,
+	insertAuditEntry:               postgresInsertAuditEntryQuery,
+	selectAuditEntries:             postgresSelectAuditEntriesQuery,
 	updateBilling:                  postgresUpdateBillingQuery,
 }
 
@@ -15,6 +15,7 @@ const (
 			messages_expiry_duration BIGINT NOT NULL,
 			emails_limit BIGINT NOT NULL,
 			calls_limit BIGINT NOT NULL,
+			sms_limit BIGINT NOT NULL DEFAULT 0,
 			reservations_limit BIGINT NOT NULL,
 			attachment_file_size_limit BIGINT NOT NULL,
 			attachment_total_size_limit BIGINT NOT NULL,
@@ -22,6 +23,7 @@ const (
 			attachment_bandwidth_limit BIGINT NOT NULL,
 			stripe_monthly_price_id TEXT,
 			stripe_yearly_price_id TEXT,
+			provisioned BOOLEAN NOT NULL DEFAULT FALSE,
 			UNIQUE(code),
 			UNIQUE(stripe_monthly_price_id),
 			UNIQUE(stripe_yearly_price_id)
@@ -38,6 +40,7 @@ const (
 			stats_messages BIGINT NOT NULL DEFAULT 0,
 			stats_emails BIGINT NOT NULL DEFAULT 0,
 			stats_calls BIGINT NOT NULL DEFAULT 0,
+			stats_sms BIGINT NOT NULL DEFAULT 0,
 			stripe_customer_id TEXT UNIQUE,
 			stripe_subscription_id TEXT UNIQUE,
 			stripe_subscription_status TEXT,
@@ -47,12 +50,32 @@ const (
 			created BIGINT NOT NULL,
 			deleted BIGINT
 		);
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			created BIGINT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL REFERENCES user_group(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			PRIMARY KEY (group_id, user_id)
+		);
+		CREATE INDEX idx_user_group_member_user_id ON user_group_member (user_id);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL REFERENCES user_group(id) ON DELETE CASCADE,
+			topic TEXT NOT NULL,
+			read BOOLEAN NOT NULL,
+			write BOOLEAN NOT NULL,
+			reserved BOOLEAN NOT NULL,
+			PRIMARY KEY (group_id, topic)
+		);
 		CREATE TABLE IF NOT EXISTS user_access (
 			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
 			topic TEXT NOT NULL,
 			read BOOLEAN NOT NULL,
 			write BOOLEAN NOT NULL,
 			owner_user_id TEXT REFERENCES "user"(id) ON DELETE CASCADE,
+			owner_group_id TEXT REFERENCES user_group(id) ON DELETE CASCADE,
 			provisioned BOOLEAN NOT NULL,
 			PRIMARY KEY (user_id, topic)
 		);
@@ -89,6 +112,39 @@ const (
 			PRIMARY KEY (token_hash)
 		);
 		CREATE INDEX idx_magic_link_user_kind ON user_magic_link (user_id, kind);
+		CREATE TABLE IF NOT EXISTS user_identity (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			created BIGINT NOT NULL,
+			PRIMARY KEY (provider, subject)
+		);
+		CREATE INDEX idx_user_identity_user_id ON user_identity (user_id);
+		CREATE TABLE IF NOT EXISTS user_totp (
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			secret TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			last_counter BIGINT NOT NULL,
+			created BIGINT NOT NULL,
+			PRIMARY KEY (user_id)
+		);
+		CREATE TABLE IF NOT EXISTS user_recovery_code (
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash)
+		);
+		CREATE TABLE IF NOT EXISTS user_audit (
+			id BIGSERIAL PRIMARY KEY,
+			created BIGINT NOT NULL,
+			actor TEXT NOT NULL,
+			source TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			origin TEXT NOT NULL
+		);
+		CREATE INDEX idx_user_audit_created ON user_audit (created);
 		INSERT INTO "user" (id, user_name, pass, role, sync_topic, provisioned, created)
 		VALUES ('` + everyoneID + `', '*', '', 'anonymous', '', false, EXTRACT(EPOCH FROM NOW())::BIGINT)
 		ON CONFLICT (id) DO NOTHING;
@@ -96,7 +152,7 @@ const (
 )
 
 const (
-	postgresCurrentSchemaVersion = 9
+	postgresCurrentSchemaVersion = 15
 )
 
 const (
@@ -125,6 +181,86 @@ const (
 		);
 		CREATE INDEX idx_magic_link_user_kind ON user_magic_link (user_id, kind);
 	`
+
+	// 9 -> 10: SMS limit and stats, next to the existing call limit and stats
+	postgresMigrate9To10UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN sms_limit BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE "user" ADD COLUMN stats_sms BIGINT NOT NULL DEFAULT 0;
+	`
+
+	// 10 -> 11: External identities (e.g. OpenID Connect subjects) linked to users
+	postgresMigrate10To11UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_identity (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			created BIGINT NOT NULL,
+			PRIMARY KEY (provider, subject)
+		);
+		CREATE INDEX idx_user_identity_user_id ON user_identity (user_id);
+	`
+
+	// 11 -> 12: TOTP two-factor authentication and (hashed) recovery codes
+	postgresMigrate11To12UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_totp (
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			secret TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			last_counter BIGINT NOT NULL,
+			created BIGINT NOT NULL,
+			PRIMARY KEY (user_id)
+		);
+		CREATE TABLE IF NOT EXISTS user_recovery_code (
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash)
+		);
+	`
+
+	// 12 -> 13: Groups with shared access control entries and group-owned reservations
+	postgresMigrate12To13UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			created BIGINT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL REFERENCES user_group(id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+			PRIMARY KEY (group_id, user_id)
+		);
+		CREATE INDEX idx_user_group_member_user_id ON user_group_member (user_id);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL REFERENCES user_group(id) ON DELETE CASCADE,
+			topic TEXT NOT NULL,
+			read BOOLEAN NOT NULL,
+			write BOOLEAN NOT NULL,
+			reserved BOOLEAN NOT NULL,
+			PRIMARY KEY (group_id, topic)
+		);
+		ALTER TABLE user_access ADD COLUMN owner_group_id TEXT REFERENCES user_group(id) ON DELETE CASCADE;
+	`
+
+	// 13 -> 14: Append-only audit log of account, ACL and token changes
+	postgresMigrate13To14UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_audit (
+			id BIGSERIAL PRIMARY KEY,
+			created BIGINT NOT NULL,
+			actor TEXT NOT NULL,
+			source TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			origin TEXT NOT NULL
+		);
+		CREATE INDEX idx_user_audit_created ON user_audit (created);
+	`
+
+	// 14 -> 15: Tiers provisioned via the config file
+	postgresMigrate14To15UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN provisioned BOOLEAN NOT NULL DEFAULT FALSE;
+	`
 )
 
 var (
@@ -133,8 +269,14 @@ var (
 	// postgresMigrations maps a schema version to the migration upgrading it to the next
 	// version. Always append migrations at the end, never insert in the middle.
 	postgresMigrations = map[int]schema.MigrateFunc{
-		6: schema.AsMigrateFunc(postgresMigrate6To7UpdateQueries),
-		7: schema.AsMigrateFunc(postgresMigrate7To8UpdateQueries),
-		8: schema.NopMigrateFunc, // 8 -> 9 repairs a SQLite-only foreign key defect; nothing to do on Postgres
+		6:  schema.AsMigrateFunc(postgresMigrate6To7UpdateQueries),
+		7:  schema.AsMigrateFunc(postgresMigrate7To8UpdateQueries),
+		8:  schema.NopMigrateFunc, // 8 -> 9 repairs a SQLite-only foreign key defect; nothing to do on Postgres
+		9:  schema.AsMigrateFunc(postgresMigrate9To10UpdateQueries),
+		10: schema.AsMigrateFunc(postgresMigrate10To11UpdateQueries),
+		11: schema.AsMigrateFunc(postgresMigrate11To12UpdateQueries),
+		12: schema.AsMigrateFunc(postgresMigrate12To13UpdateQueries),
+		13: schema.AsMigrateFunc(postgresMigrate13To14UpdateQueries),
+		14: schema.AsMigrateFunc(postgresMigrate14To15UpdateQueries),
 	}
 )
@@ -16,7 +16,7 @@ import (
 const (
 	// User queries
 	sqliteSelectUsersQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		ORDER BY
@@ -27,19 +27,19 @@ const (
 			END, u.user
 	`
 	sqliteSelectUserByIDQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.id = ?
 	`
 	sqliteSelectUserByNameQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE user = ?
 	`
 	sqliteSelectUserByNameOrPrimaryEmailQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.user = ?
@@ -48,18 +48,25 @@ const (
 		LIMIT 1
 	`
 	sqliteSelectUserByTokenQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		JOIN user_token tk on u.id = tk.user_id
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE tk.token = ? AND (tk.expires = 0 OR tk.expires >= ?)
 	`
 	sqliteSelectUserByStripeCustomerIDQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.stripe_customer_id = ?
 	`
+	sqliteSelectUserByIdentityQuery = `
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stats_sms, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.sms_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		FROM user u
+		JOIN user_identity i on u.id = i.user_id
+		LEFT JOIN tier t on t.id = u.tier_id
+		WHERE i.provider = ? AND i.subject = ?
+	`
 	sqliteSelectUsernamesQuery = `
 		SELECT user
 		FROM user
@@ -77,8 +84,8 @@ const (
 	sqliteUpdateUserRoleQuery           = `UPDATE user SET role = ? WHERE user = ?`
 	sqliteUpdateUserProvisionedQuery    = `UPDATE user SET provisioned = ? WHERE user = ?`
 	sqliteUpdateUserPrefsQuery          = `UPDATE user SET prefs = ? WHERE id = ?`
-	sqliteUpdateUserStatsQuery          = `UPDATE user SET stats_messages = ?, stats_emails = ?, stats_calls = ? WHERE id = ?`
-	sqliteUpdateUserStatsResetAllQuery  = `UPDATE user SET stats_messages = 0, stats_emails = 0, stats_calls = 0`
+	sqliteUpdateUserStatsQuery          = `UPDATE user SET stats_messages = ?, stats_emails = ?, stats_calls = ?, stats_sms = ? WHERE id = ?`
+	sqliteUpdateUserStatsResetAllQuery  = `UPDATE user SET stats_messages = 0, stats_emails = 0, stats_calls = 0, stats_sms = 0`
 	sqliteUpdateUserTierQuery           = `UPDATE user SET tier_id = (SELECT id FROM tier WHERE code = ?) WHERE user = ?`
 	sqliteUpdateUserDeletedQuery        = `UPDATE user SET deleted = ? WHERE id = ?`
 	sqliteDeleteUserQuery               = `DELETE FROM user WHERE user = ?`
@@ -88,7 +95,7 @@ const (
 
 	// Access queries
 	sqliteSelectTopicPermsQuery = `
-		SELECT read, write
+		SELECT u.user, read, write
 		FROM user_access a
 		JOIN user u ON u.id = a.user_id
 		WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\'
@@ -111,7 +118,7 @@ const (
 		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
 	`
 	sqliteSelectUserReservationsQuery = `
-		SELECT a_user.topic, a_user.read, a_user.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write
+		SELECT a_user.topic, a_user.read, a_user.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write, a_user.provisioned
 		FROM user_access a_user
 		LEFT JOIN  user_access a_everyone ON a_user.topic = a_everyone.topic AND a_everyone.user_id = (SELECT id FROM user WHERE user = ?)
 		WHERE a_user.user_id = a_user.owner_user_id
@@ -138,16 +145,20 @@ const (
 		  AND topic = ?
 	`
 	sqliteSelectOtherAccessCountQuery = `
-		SELECT COUNT(*)
-		FROM user_access
-		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
-		  AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM user WHERE user = ?))
+		SELECT
+			(SELECT COUNT(*)
+			 FROM user_access
+			 WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+			   AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM user WHERE user = ?)))
+			+ (SELECT COUNT(*)
+			 FROM user_group_access
+			 WHERE topic = ? OR ? LIKE topic ESCAPE '\')
 	`
 	sqliteUpsertUserAccessQuery = `
 		INSERT INTO user_access (user_id, topic, read, write, owner_user_id, provisioned)
 		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, (SELECT IIF(?='',NULL,(SELECT id FROM user WHERE user=?))), ?)
 		ON CONFLICT (user_id, topic)
-		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id, provisioned=excluded.provisioned
+		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id, owner_group_id=NULL, provisioned=excluded.provisioned
 	`
 	sqliteDeleteUserAccessQuery = `
 		DELETE FROM user_access
@@ -194,29 +205,30 @@ const (
 
 	// Tier queries
 	sqliteInsertTierQuery = `
-		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	sqliteUpdateTierQuery = `
 		UPDATE tier
-		SET name = ?, messages_limit = ?, messages_expiry_duration = ?, emails_limit = ?, calls_limit = ?, reservations_limit = ?, attachment_file_size_limit = ?, attachment_total_size_limit = ?, attachment_expiry_duration = ?, attachment_bandwidth_limit = ?, stripe_monthly_price_id = ?, stripe_yearly_price_id = ?
+		SET name = ?, messages_limit = ?, messages_expiry_duration = ?, emails_limit = ?, calls_limit = ?, sms_limit = ?, reservations_limit = ?, attachment_file_size_limit = ?, attachment_total_size_limit = ?, attachment_expiry_duration = ?, attachment_bandwidth_limit = ?, stripe_monthly_price_id = ?, stripe_yearly_price_id = ?, provisioned = ?
 		WHERE code = ?
 	`
 	sqliteSelectTiersQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned
 		FROM tier
 	`
 	sqliteSelectTierByCodeQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned
 		FROM tier
 		WHERE code = ?
 	`
 	sqliteSelectTierByPriceIDQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, sms_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, provisioned
 		FROM tier
 		WHERE (stripe_monthly_price_id = ? OR stripe_yearly_price_id = ?)
 	`
-	sqliteDeleteTierQuery = `DELETE FROM tier WHERE code = ?`
+	sqliteDeleteTierQuery          = `DELETE FROM tier WHERE code = ?`
+	sqliteDeleteTierFromUsersQuery = `UPDATE user SET tier_id = null WHERE tier_id = (SELECT id FROM tier WHERE code = ?)`
 
 	// Phone queries
 	sqliteSelectPhoneNumbersQuery = `SELECT phone_number FROM user_phone WHERE user_id = ?`
@@ -242,7 +254,132 @@ const (
 	sqliteSelectPendingEmailsQuery     = `SELECT email FROM user_magic_link WHERE kind = ? AND user_id = ? ORDER BY email`
 	sqliteDeleteExpiredMagicLinksQuery = `DELETE FROM user_magic_link WHERE expires < ?`
 
+	// Identity queries
+	sqliteInsertIdentityQuery       = `INSERT INTO user_identity (provider, subject, user_id, created) VALUES (?, ?, ?, ?)`
+	sqliteSelectIdentityExistsQuery = `SELECT EXISTS (SELECT 1 FROM user_identity WHERE user_id = ? AND provider = ?)`
+
+	// TOTP queries
+	sqliteSelectTOTPQuery = `SELECT secret, enabled, last_counter FROM user_totp WHERE user_id = ?`
+	sqliteUpsertTOTPQuery = `
+		INSERT INTO user_totp (user_id, secret, enabled, last_counter, created) VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, enabled = 0, last_counter = 0, created = excluded.created
+		WHERE user_totp.enabled = 0
+	`
+	sqliteUpdateTOTPEnabledQuery     = `UPDATE user_totp SET enabled = 1, last_counter = ? WHERE user_id = ? AND enabled = 0`
+	sqliteUpdateTOTPLastCounterQuery = `UPDATE user_totp SET last_counter = ? WHERE user_id = ? AND enabled = 1 AND last_counter < ?`
+	sqliteDeleteTOTPQuery            = `DELETE FROM user_totp WHERE user_id = ?`
+	sqliteInsertRecoveryCodeQuery    = `INSERT INTO user_recovery_code (user_id, code_hash) VALUES (?, ?)`
+	sqliteDeleteRecoveryCodeQuery    = `DELETE FROM user_recovery_code WHERE user_id = ? AND code_hash = ?`
+	sqliteDeleteRecoveryCodesQuery   = `DELETE FROM user_recovery_code WHERE user_id = ?`
+
+	// Group queries
+	sqliteSelectGroupsQuery       = `SELECT id, name FROM user_group ORDER BY name`
+	sqliteSelectGroupByNameQuery  = `SELECT id, name FROM user_group WHERE name = ?`
+	sqliteSelectGroupMembersQuery = `
+		SELECT u.user
+		FROM user_group_member m
+		JOIN user u ON u.id = m.user_id
+		WHERE m.group_id = ?
+		ORDER BY u.user
+	`
+	sqliteSelectGroupMembersAllQuery = `
+		SELECT g.name, u.user
+		FROM user_group_member m
+		JOIN user_group g ON g.id = m.group_id
+		JOIN user u ON u.id = m.user_id
+		ORDER BY g.name, u.user
+	`
+	sqliteSelectUserGroupsQuery = `
+		SELECT g.name
+		FROM user_group g
+		JOIN user_group_member m ON m.group_id = g.id
+		JOIN user u ON u.id = m.user_id
+		WHERE u.user = ?
+		ORDER BY g.name
+	`
+	sqliteInsertGroupQuery           = `INSERT INTO user_group (id, name, created) VALUES (?, ?, ?)`
+	sqliteDeleteGroupQuery           = `DELETE FROM user_group WHERE id = ?`
+	sqliteInsertGroupMemberQuery     = `INSERT INTO user_group_member (group_id, user_id) VALUES (?, ?) ON CONFLICT (group_id, user_id) DO NOTHING`
+	sqliteDeleteGroupMemberQuery     = `DELETE FROM user_group_member WHERE group_id = ? AND user_id = ?`
+	sqliteSelectGroupTopicPermsQuery = `
+		SELECT a.read, a.write
+		FROM user_group_access a
+		JOIN user_group_member m ON m.group_id = a.group_id
+		JOIN user u ON u.id = m.user_id
+		WHERE u.user = ? AND ? LIKE a.topic ESCAPE '\'
+		ORDER BY LENGTH(a.topic) DESC, a.write DESC
+	`
+	sqliteSelectGroupAccessCacheAllQuery = `
+		SELECT g.name, a.topic, a.read, a.write
+		FROM user_group_access a
+		JOIN user_group g ON g.id = a.group_id
+	`
+	sqliteSelectGroupAccessQuery = `
+		SELECT topic, read, write, reserved
+		FROM user_group_access
+		WHERE group_id = ?
+		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
+	`
+	sqliteSelectGroupReservationsQuery = `
+		SELECT a_group.topic, a_group.read, a_group.write, a_everyone.read AS everyone_read, a_everyone.write AS everyone_write
+		FROM user_group_access a_group
+		LEFT JOIN user_access a_everyone ON a_group.topic = a_everyone.topic AND a_everyone.owner_group_id = a_group.group_id AND a_everyone.user_id = (SELECT id FROM user WHERE user = ?)
+		WHERE a_group.group_id = ?
+		  AND a_group.reserved = 1
+		ORDER BY a_group.topic
+	`
+	sqliteSelectGroupReservationOwnerQuery = `
+		SELECT g.name
+		FROM user_group_access a
+		JOIN user_group g ON g.id = a.group_id
+		WHERE a.topic = ?
+		  AND a.reserved = 1
+	`
+	sqliteSelectGroupOtherAccessCountQuery = `
+		SELECT
+			(SELECT COUNT(*)
+			 FROM user_access
+			 WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+			   AND (owner_group_id IS NULL OR owner_group_id != ?))
+			+ (SELECT COUNT(*)
+			 FROM user_group_access
+			 WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+			   AND group_id != ?)
+	`
+	sqliteUpsertGroupAccessQuery = `
+		INSERT INTO user_group_access (group_id, topic, read, write, reserved)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (group_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write, reserved=excluded.reserved
+	`
+	sqliteUpsertGroupOwnedAccessQuery = `
+		INSERT INTO user_access (user_id, topic, read, write, owner_group_id, provisioned)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, ?, 0)
+		ON CONFLICT (user_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=NULL, owner_group_id=excluded.owner_group_id, provisioned=excluded.provisioned
+	`
+	sqliteDeleteGroupAccessQuery           = `DELETE FROM user_group_access WHERE group_id = ?`
+	sqliteDeleteGroupTopicAccessQuery      = `DELETE FROM user_group_access WHERE group_id = ? AND topic = ?`
+	sqliteDeleteGroupOwnedAccessQuery      = `DELETE FROM user_access WHERE owner_group_id = ?`
+	sqliteDeleteGroupOwnedTopicAccessQuery = `DELETE FROM user_access WHERE owner_group_id = ? AND topic = ?`
+
 	// Billing queries
+	// Audit log queries
+	sqliteInsertAuditEntryQuery = `
+		INSERT INTO user_audit (created, actor, source, action, target, old_value, new_value, origin)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	sqliteSelectAuditEntriesQuery = `
+		SELECT id, created, actor, source, action, target, old_value, new_value, origin
+		FROM user_audit
+		WHERE (? = '' OR actor = ?)
+		  AND (? = '' OR target = ?)
+		  AND (? = '' OR action LIKE ?)
+		  AND created >= ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
 	sqliteUpdateBillingQuery = `
 		UPDATE user
 		SET stripe_customer_id = ?, stripe_subscription_id = ?, stripe_subscription_status = ?, stripe_subscription_interval = ?, stripe_subscription_paid_until = ?, stripe_subscription_cancel_at = ?
@@ -271,6 +408,7 @@ var sqliteQueries = queries{
 	selectUserByNameOrPrimaryEmail: sqliteSelectUserByNameOrPrimaryEmailQuery,
 	selectUserByToken:              sqliteSelectUserByTokenQuery,
 	selectUserByStripeCustomerID:   sqliteSelectUserByStripeCustomerIDQuery,
+	selectUserByIdentity:           sqliteSelectUserByIdentityQuery,
 	selectUsernames:                sqliteSelectUsernamesQuery,
 	selectUsers:                    sqliteSelectUsersQuery,
 	selectUserCount:                sqliteSelectUserCountQuery,
@@ -322,6 +460,7 @@ var sqliteQueries = queries{
 	selectTierByPriceID:            sqliteSelectTierByPriceIDQuery,
 	updateTier:                     sqliteUpdateTierQuery,
 	deleteTier:                     sqliteDeleteTierQuery,
+	deleteTierFromUsers:            sqliteDeleteTierFromUsersQuery,
 	selectPhoneNumbers:             sqliteSelectPhoneNumbersQuery,
 	insertPhoneNumber:              sqliteInsertPhoneNumberQuery,
 	deletePhoneNumber:              sqliteDeletePhoneNumberQuery,
@@ -340,6 +479,39 @@ var sqliteQueries = queries{
 	deleteMagicLinkResetPassword:   sqliteDeleteResetScopeQuery,
 	selectPendingEmails:            sqliteSelectPendingEmailsQuery,
 	deleteExpiredMagicLinks:        sqliteDeleteExpiredMagicLinksQuery,
+	insertIdentity:                 sqliteInsertIdentityQuery,
+	selectIdentityExists:           sqliteSelectIdentityExistsQuery,
+	selectTOTP:                     sqliteSelectTOTPQuery,
+	upsertTOTP:                     sqliteUpsertTOTPQuery,
+	updateTOTPEnabled:              sqliteUpdateTOTPEnabledQuery,
+	updateTOTPLastCounter:          sqliteUpdateTOTPLastCounterQuery,
+	deleteTOTP:                     sqliteDeleteTOTPQuery,
+	insertRecoveryCode:             sqliteInsertRecoveryCodeQuery,
+	deleteRecoveryCode:             sqliteDeleteRecoveryCodeQuery,
+	deleteRecoveryCodes:            sqliteDeleteRecoveryCodesQuery,
+	selectGroups:                   sqliteSelectGroupsQuery,
+	selectGroupByName:              sqliteSelectGroupByNameQuery,
+	selectGroupMembers:             sqliteSelectGroupMembersQuery,
+	selectGroupMembersAll:          sqliteSelectGroupMembersAllQuery,
+	selectUserGroups:               sqliteSelectUserGroupsQuery,
+	insertGroup:                    sqliteInsertGroupQuery,
+	deleteGroup:                    sqliteDeleteGroupQuery,
+	insertGroupMember:              sqliteInsertGroupMemberQuery,
+	deleteGroupMember:              sqliteDeleteGroupMemberQuery,
+	selectGroupTopicPerms:          sqliteSelectGroupTopicPermsQuery,
+	selectGroupAccessCacheAll:      sqliteSelectGroupAccessCacheAllQuery,
+	selectGroupAccess:              sqliteSelectGroupAccessQuery,
+	selectGroupReservations:        sqliteSelectGroupReservationsQuery,
+	selectGroupReservationOwner:    sqliteSelectGroupReservationOwnerQuery,
+	selectGroupOtherAccessCount:    sqliteSelectGroupOtherAccessCountQuery,
+	upsertGroupAccess:              sqliteUpsertGroupAccessQuery,
+	upsertGroupOwnedAccess:         sqliteUpsertGroupOwnedAccessQuery,
+	deleteGroupAccess:              sqliteDeleteGroupAccessQuery,
+	deleteGroupTopicAccess:         sqliteDeleteGroupTopicAccessQuery,
+	deleteGroupOwnedAccess:         sqliteDeleteGroupOwnedAccessQuery,
+	deleteGroupOwnedTopicAccess:    sqliteDeleteGroupOwnedTopicAccessQuery,
+	insertAuditEntry:               sqliteInsertAuditEntryQuery,
+	selectAuditEntries:             sqliteSelectAuditEntriesQuery,
 	updateBilling:                  sqliteUpdateBillingQuery,
 }
 
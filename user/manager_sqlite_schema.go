@@ -18,13 +18,15 @@ const (
 			messages_expiry_duration INT NOT NULL,
 			emails_limit INT NOT NULL,
 			calls_limit INT NOT NULL,
+			sms_limit INT NOT NULL DEFAULT (0),
 			reservations_limit INT NOT NULL,
 			attachment_file_size_limit INT NOT NULL,
 			attachment_total_size_limit INT NOT NULL,
 			attachment_expiry_duration INT NOT NULL,
 			attachment_bandwidth_limit INT NOT NULL,
 			stripe_monthly_price_id TEXT,
-			stripe_yearly_price_id TEXT
+			stripe_yearly_price_id TEXT,
+			provisioned INT NOT NULL DEFAULT (0)
 		);
 		CREATE UNIQUE INDEX idx_tier_code ON tier (code);
 		CREATE UNIQUE INDEX idx_tier_stripe_monthly_price_id ON tier (stripe_monthly_price_id);
@@ -41,6 +43,7 @@ const (
 			stats_messages INT NOT NULL DEFAULT (0),
 			stats_emails INT NOT NULL DEFAULT (0),
 			stats_calls INT NOT NULL DEFAULT (0),
+			stats_sms INT NOT NULL DEFAULT (0),
 			stripe_customer_id TEXT,
 			stripe_subscription_id TEXT,
 			stripe_subscription_status TEXT,
@@ -60,10 +63,12 @@ const (
 			read INT NOT NULL,
 			write INT NOT NULL,
 			owner_user_id INT,
+			owner_group_id TEXT,
 			provisioned INT NOT NULL,
 			PRIMARY KEY (user_id, topic),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE,
-		    FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		    FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE,
+		    FOREIGN KEY (owner_group_id) REFERENCES user_group (id) ON DELETE CASCADE
 		);
 		CREATE TABLE IF NOT EXISTS user_token (
 			user_id TEXT NOT NULL,
@@ -103,6 +108,65 @@ const (
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
 		CREATE INDEX idx_magic_link_user_kind ON user_magic_link (user_id, kind);
+		CREATE TABLE IF NOT EXISTS user_identity (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (provider, subject),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_identity_user_id ON user_identity (user_id);
+		CREATE TABLE IF NOT EXISTS user_totp (
+			user_id TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			enabled INT NOT NULL,
+			last_counter INT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (user_id),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_recovery_code (
+			user_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_group_member_user_id ON user_group_member (user_id);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			reserved INT NOT NULL,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created INT NOT NULL,
+			actor TEXT NOT NULL,
+			source TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			origin TEXT NOT NULL
+		);
+		CREATE INDEX idx_user_audit_created ON user_audit (created);
 		INSERT INTO user (id, user, pass, role, sync_topic, provisioned, created)
 		VALUES ('` + everyoneID + `', '*', '', 'anonymous', '', false, UNIXEPOCH())
 		ON CONFLICT (id) DO NOTHING;
@@ -114,7 +178,7 @@ const (
 )
 
 const (
-	sqliteCurrentSchemaVersion = 9
+	sqliteCurrentSchemaVersion = 15
 )
 
 // Schema migrations for SQLite
@@ -366,6 +430,93 @@ const (
 		WHERE user_id IN (SELECT id FROM user); -- Drop orphaned rows that the broken foreign key failed to cascade-delete
 		DROP TABLE user_phone_old;
 	`
+
+	// 9 -> 10: SMS limit and stats, next to the existing call limit and stats
+	sqliteMigrate9To10UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN sms_limit INT NOT NULL DEFAULT (0);
+		ALTER TABLE user ADD COLUMN stats_sms INT NOT NULL DEFAULT (0);
+	`
+
+	// 10 -> 11: External identities (e.g. OpenID Connect subjects) linked to users
+	sqliteMigrate10To11UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_identity (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (provider, subject),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_identity_user_id ON user_identity (user_id);
+	`
+
+	// 11 -> 12: TOTP two-factor authentication and (hashed) recovery codes
+	sqliteMigrate11To12UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_totp (
+			user_id TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			enabled INT NOT NULL,
+			last_counter INT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (user_id),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_recovery_code (
+			user_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 12 -> 13: Groups with shared access control entries and group-owned reservations
+	sqliteMigrate12To13UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_group_member_user_id ON user_group_member (user_id);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			reserved INT NOT NULL,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE
+		);
+		ALTER TABLE user_access ADD COLUMN owner_group_id TEXT REFERENCES user_group (id) ON DELETE CASCADE;
+	`
+
+	// 13 -> 14: Append-only audit log of account, ACL and token changes
+	sqliteMigrate13To14UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created INT NOT NULL,
+			actor TEXT NOT NULL,
+			source TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			origin TEXT NOT NULL
+		);
+		CREATE INDEX idx_user_audit_created ON user_audit (created);
+	`
+
+	// 14 -> 15: Tiers provisioned via the config file
+	sqliteMigrate14To15UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN provisioned INT NOT NULL DEFAULT (0);
+	`
 )
 
 var (
@@ -374,14 +525,20 @@ var (
 	// sqliteMigrations maps a schema version to the migration upgrading it to the next
 	// version. Always append migrations at the end, never insert in the middle.
 	sqliteMigrations = map[int]schema.MigrateFunc{
-		1: sqliteMigrateFrom1,
-		2: schema.AsMigrateFunc(sqliteMigrate2To3UpdateQueries),
-		3: schema.AsMigrateFunc(sqliteMigrate3To4UpdateQueries),
-		4: schema.AsMigrateFunc(sqliteMigrate4To5UpdateQueries),
-		5: schema.AsMigrateFunc(sqliteMigrate5To6UpdateQueries),
-		6: schema.AsMigrateFunc(sqliteMigrate6To7UpdateQueries),
-		7: schema.AsMigrateFunc(sqliteMigrate7To8UpdateQueries),
-		8: schema.AsMigrateFunc(sqliteMigrate8To9UpdateQueries),
+		1:  sqliteMigrateFrom1,
+		2:  schema.AsMigrateFunc(sqliteMigrate2To3UpdateQueries),
+		3:  schema.AsMigrateFunc(sqliteMigrate3To4UpdateQueries),
+		4:  schema.AsMigrateFunc(sqliteMigrate4To5UpdateQueries),
+		5:  schema.AsMigrateFunc(sqliteMigrate5To6UpdateQueries),
+		6:  schema.AsMigrateFunc(sqliteMigrate6To7UpdateQueries),
+		7:  schema.AsMigrateFunc(sqliteMigrate7To8UpdateQueries),
+		8:  schema.AsMigrateFunc(sqliteMigrate8To9UpdateQueries),
+		9:  schema.AsMigrateFunc(sqliteMigrate9To10UpdateQueries),
+		10: schema.AsMigrateFunc(sqliteMigrate10To11UpdateQueries),
+		11: schema.AsMigrateFunc(sqliteMigrate11To12UpdateQueries),
+		12: schema.AsMigrateFunc(sqliteMigrate12To13UpdateQueries),
+		13: schema.AsMigrateFunc(sqliteMigrate13To14UpdateQueries),
+		14: schema.AsMigrateFunc(sqliteMigrate14To15UpdateQueries),
 	}
 )
 
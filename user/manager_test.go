@@ -16,6 +16,8 @@ import (
 	"heckel.io/ntfy/v2/db/pg"
 	"heckel.io/ntfy/v2/db/schema"
 	dbtest "heckel.io/ntfy/v2/db/test"
+	"heckel.io/ntfy/v2/ldap"
+	"heckel.io/ntfy/v2/ldap/ldaptest"
 	"heckel.io/ntfy/v2/util"
 )
 
@@ -515,6 +517,206 @@ func TestManager_Reservations(t *testing.T) {
 	})
 }
 
+func TestManager_Groups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		a := newTestManager(t, newManager, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+
+		require.Nil(t, a.AddGroup("oncall"))
+		require.Nil(t, a.AddGroup("devs"))
+		require.Equal(t, ErrGroupExists, a.AddGroup("oncall"))
+		require.Equal(t, ErrInvalidArgument, a.AddGroup("on call"))
+
+		require.Nil(t, a.AddGroupMember("oncall", "phil"))
+		require.Nil(t, a.AddGroupMember("oncall", "ben"))
+		require.Nil(t, a.AddGroupMember("oncall", "ben")) // Idempotent
+		require.Nil(t, a.AddGroupMember("devs", "ben"))
+		require.Equal(t, ErrGroupNotFound, a.AddGroupMember("nope", "ben"))
+		require.Equal(t, ErrUserNotFound, a.AddGroupMember("oncall", "nobody"))
+		require.Equal(t, ErrInvalidArgument, a.AddGroupMember("oncall", Everyone))
+
+		group, err := a.Group("oncall")
+		require.Nil(t, err)
+		require.Equal(t, "oncall", group.Name)
+		require.True(t, strings.HasPrefix(group.ID, "gr_"))
+		require.Equal(t, []string{"ben", "phil"}, group.Members)
+
+		groups, err := a.Groups()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(groups))
+		require.Equal(t, "devs", groups[0].Name)
+		require.Equal(t, []string{"ben"}, groups[0].Members)
+		require.Equal(t, "oncall", groups[1].Name)
+		require.Equal(t, []string{"ben", "phil"}, groups[1].Members)
+
+		userGroups, err := a.UserGroups("ben")
+		require.Nil(t, err)
+		require.Equal(t, []string{"devs", "oncall"}, userGroups)
+
+		// Remove member, and remove group
+		require.Nil(t, a.RemoveGroupMember("oncall", "ben"))
+		group, err = a.Group("oncall")
+		require.Nil(t, err)
+		require.Equal(t, []string{"phil"}, group.Members)
+
+		require.Nil(t, a.RemoveGroup("devs"))
+		_, err = a.Group("devs")
+		require.Equal(t, ErrGroupNotFound, err)
+		userGroups, err = a.UserGroups("ben")
+		require.Nil(t, err)
+		require.Empty(t, userGroups)
+
+		// Removing a user removes the membership
+		require.Nil(t, a.RemoveUser("phil"))
+		group, err = a.Group("oncall")
+		require.Nil(t, err)
+		require.Empty(t, group.Members)
+	})
+}
+
+func TestManager_GroupAccess(t *testing.T) {
+	for _, cache := range []bool{true, false} {
+		t.Run(fmt.Sprintf("cache=%t", cache), func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+				a := newTestManagerFromConfig(t, newManager, &Config{
+					DefaultAccess:      PermissionDenyAll,
+					BcryptCost:         bcrypt.MinCost,
+					AccessCacheEnabled: cache,
+				})
+				require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+				require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+				require.Nil(t, a.AddUser("alice", "alice", RoleUser, false))
+				require.Nil(t, a.AddGroup("oncall"))
+				require.Nil(t, a.AddGroup("readers"))
+				require.Nil(t, a.AddGroupMember("oncall", "phil"))
+				require.Nil(t, a.AddGroupMember("oncall", "ben"))
+				require.Nil(t, a.AddGroupMember("readers", "ben"))
+
+				require.Nil(t, a.AllowGroupAccess("oncall", "alerts", PermissionReadWrite))
+				require.Nil(t, a.AllowGroupAccess("oncall", "status*", PermissionRead))
+				require.Nil(t, a.AllowGroupAccess("readers", "status-internal", PermissionWrite))
+				require.Nil(t, a.AllowAccess("ben", "alerts", PermissionRead))            // User entry beats group entry
+				require.Nil(t, a.AllowAccess(Everyone, "status-page", PermissionDenyAll)) // Group entry beats Everyone entry
+
+				phil, err := a.User("phil")
+				require.Nil(t, err)
+				ben, err := a.User("ben")
+				require.Nil(t, err)
+				alice, err := a.User("alice")
+				require.Nil(t, err)
+
+				require.Nil(t, a.Authorize(phil, "alerts", PermissionRead))
+				require.Nil(t, a.Authorize(phil, "alerts", PermissionWrite))
+				require.Nil(t, a.Authorize(phil, "status-page", PermissionRead))
+				require.Equal(t, ErrUnauthorized, a.Authorize(phil, "status-page", PermissionWrite))
+				require.Nil(t, a.Authorize(ben, "alerts", PermissionRead))
+				require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts", PermissionWrite))
+				require.Nil(t, a.Authorize(ben, "status-internal", PermissionWrite)) // Longer pattern of other group wins
+				require.Equal(t, ErrUnauthorized, a.Authorize(ben, "status-internal", PermissionRead))
+				require.Equal(t, ErrUnauthorized, a.Authorize(alice, "alerts", PermissionRead))
+				require.Equal(t, ErrUnauthorized, a.Authorize(nil, "alerts", PermissionRead))
+				require.Equal(t, ErrUnauthorized, a.Authorize(nil, "status-page", PermissionRead))
+
+				grants, err := a.GroupGrants("oncall")
+				require.Nil(t, err)
+				require.Equal(t, []Grant{
+					{TopicPattern: "status*", Permission: PermissionRead},
+					{TopicPattern: "alerts", Permission: PermissionReadWrite},
+				}, grants)
+
+				// New members get access, removed members lose it
+				require.Nil(t, a.AddGroupMember("oncall", "alice"))
+				require.Nil(t, a.Authorize(alice, "alerts", PermissionWrite))
+				require.Nil(t, a.RemoveGroupMember("oncall", "phil"))
+				require.Equal(t, ErrUnauthorized, a.Authorize(phil, "alerts", PermissionRead))
+
+				// Reset access
+				require.Nil(t, a.ResetGroupAccess("oncall", "alerts"))
+				require.Equal(t, ErrUnauthorized, a.Authorize(alice, "alerts", PermissionRead))
+				require.Nil(t, a.Authorize(alice, "status-page", PermissionRead))
+				require.Nil(t, a.ResetGroupAccess("oncall", ""))
+				require.Equal(t, ErrUnauthorized, a.Authorize(alice, "status-page", PermissionRead))
+				grants, err = a.GroupGrants("oncall")
+				require.Nil(t, err)
+				require.Empty(t, grants)
+
+				// A re-created user does not inherit the memberships of a removed user
+				require.Nil(t, a.RemoveUser("ben"))
+				require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+				ben, err = a.User("ben")
+				require.Nil(t, err)
+				require.Equal(t, ErrUnauthorized, a.Authorize(ben, "status-internal", PermissionWrite))
+			})
+		})
+	}
+}
+
+func TestManager_GroupReservations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		a := newTestManager(t, newManager, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+		require.Nil(t, a.AddGroup("oncall"))
+		require.Nil(t, a.AddGroup("devs"))
+		require.Nil(t, a.AddGroupMember("oncall", "phil"))
+		require.Nil(t, a.AddReservation("ben", "bens-topic", PermissionDenyAll, 0))
+		require.Nil(t, a.AllowGroupAccess("devs", "builds", PermissionRead))
+
+		require.Nil(t, a.AllowGroupReservation("oncall", "alerts"))
+		require.Equal(t, errTopicOwnedByOthers, a.AllowGroupReservation("oncall", "bens-topic"))
+		require.Equal(t, errTopicOwnedByOthers, a.AllowGroupReservation("oncall", "builds"))
+		require.Nil(t, a.AllowGroupReservation("devs", "builds")) // Own entry
+		require.Equal(t, ErrGroupNotFound, a.AllowGroupReservation("nope", "alerts"))
+
+		require.Nil(t, a.AddGroupReservation("oncall", "alerts", PermissionRead))
+		require.Nil(t, a.AddGroupReservation("oncall", "alerts_2", PermissionDenyAll))
+		require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("ben", "alerts"))
+		require.Equal(t, errTopicOwnedByOthers, a.AllowGroupReservation("devs", "alerts"))
+		require.Nil(t, a.AllowGroupReservation("oncall", "alerts")) // Updating is allowed
+
+		reservations, err := a.GroupReservations("oncall")
+		require.Nil(t, err)
+		require.Equal(t, []Reservation{
+			{Topic: "alerts", Owner: PermissionReadWrite, Everyone: PermissionRead},
+			{Topic: "alerts_2", Owner: PermissionReadWrite, Everyone: PermissionDenyAll},
+		}, reservations)
+
+		owner, err := a.GroupReservationOwner("alerts_2")
+		require.Nil(t, err)
+		require.Equal(t, "oncall", owner)
+		owner, err = a.GroupReservationOwner("alertsX2") // _ != X
+		require.Nil(t, err)
+		require.Equal(t, "", owner)
+		owner, err = a.GroupReservationOwner("bens-topic")
+		require.Nil(t, err)
+		require.Equal(t, "", owner)
+
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(phil, "alerts", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "alerts", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts", PermissionWrite))
+		require.Nil(t, a.Authorize(nil, "alerts", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "alerts_2", PermissionRead))
+
+		// Remove a reservation, and then the group (which removes the rest)
+		require.Nil(t, a.RemoveGroupReservations("oncall", "alerts_2"))
+		reservations, err = a.GroupReservations("oncall")
+		require.Nil(t, err)
+		require.Equal(t, 1, len(reservations))
+		require.Nil(t, a.RemoveGroup("oncall"))
+		require.Nil(t, a.AllowReservation("ben", "alerts"))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "alerts", PermissionRead))
+		grants, err := a.Grants(Everyone)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(grants)) // Only ben's reservation is left
+		require.Equal(t, "bens-topic", grants[0].TopicPattern)
+	})
+}
+
 func TestManager_ChangeRoleFromTierUserToAdmin(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
 		a := newTestManager(t, newManager, PermissionDenyAll)
@@ -1131,6 +1333,248 @@ func TestUser_PhoneNumberAddListRemove(t *testing.T) {
 	})
 }
 
+func TestManager_AddUserWithIdentity(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		a := newTestManager(t, newManager, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+		require.Nil(t, a.AddUserWithIdentity("phil", RoleAdmin, "oidc", "f1a2b3"))
+
+		phil, err := a.UserByIdentity("oidc", "f1a2b3")
+		require.Nil(t, err)
+		require.Equal(t, "phil", phil.Name)
+		require.Equal(t, RoleAdmin, phil.Role)
+		require.False(t, phil.Provisioned)
+		_, err = a.UserByIdentity("oidc", "other")
+		require.Equal(t, ErrUserNotFound, err)
+		_, err = a.UserByIdentity("ldap", "f1a2b3")
+		require.Equal(t, ErrUserNotFound, err)
+
+		// Users without identity cannot log in with a password
+		_, err = a.Authenticate("phil", "")
+		require.Equal(t, ErrUnauthenticated, err)
+
+		// Username taken, or identity already linked
+		require.Equal(t, ErrUserExists, a.AddUserWithIdentity("ben", RoleUser, "oidc", "c4d5e6"))
+		require.Equal(t, ErrIdentityExists, a.AddUserWithIdentity("phil2", RoleUser, "oidc", "f1a2b3"))
+		_, err = a.User("phil2")
+		require.Equal(t, ErrUserNotFound, err)
+
+		// Identity is removed with the user
+		require.Nil(t, a.RemoveUser("phil"))
+		_, err = a.UserByIdentity("oidc", "f1a2b3")
+		require.Equal(t, ErrUserNotFound, err)
+		require.Nil(t, a.AddUserWithIdentity("phil", RoleUser, "oidc", "f1a2b3"))
+	})
+}
+
+func TestManager_TOTP(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		a := newTestManager(t, newManager, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+
+		// Pending secret is not enabled until confirmed with a code
+		secret, err := a.AddTOTPSecret(phil.ID)
+		require.Nil(t, err)
+		enabled, err := a.TOTPEnabled(phil.ID)
+		require.Nil(t, err)
+		require.False(t, enabled)
+		require.Equal(t, ErrTOTPNotFound, a.VerifyTOTP(phil.ID, "123456"))
+		_, err = a.EnableTOTP(phil.ID, "000000")
+		require.Equal(t, ErrTOTPCodeInvalid, err)
+
+		code, err := totpCode(secret, totpCounter(time.Now()))
+		require.Nil(t, err)
+		recoveryCodes, err := a.EnableTOTP(phil.ID, code)
+		require.Nil(t, err)
+		require.Len(t, recoveryCodes, recoveryCodeCount)
+		enabled, err = a.TOTPEnabled(phil.ID)
+		require.Nil(t, err)
+		require.True(t, enabled)
+		_, err = a.AddTOTPSecret(phil.ID)
+		require.Equal(t, ErrTOTPEnabled, err)
+
+		// Code used for enabling cannot be replayed, the next one works once
+		require.Equal(t, ErrTOTPCodeInvalid, a.VerifyTOTP(phil.ID, code))
+		next, err := totpCode(secret, totpCounter(time.Now())+1)
+		require.Nil(t, err)
+		require.Nil(t, a.VerifyTOTP(phil.ID, next))
+		require.Equal(t, ErrTOTPCodeInvalid, a.VerifyTOTP(phil.ID, next))
+
+		// Recovery codes work once each, and are stored hashed
+		require.Nil(t, a.VerifyTOTP(phil.ID, recoveryCodes[0]))
+		require.Equal(t, ErrTOTPCodeInvalid, a.VerifyTOTP(phil.ID, recoveryCodes[0]))
+		require.Nil(t, a.VerifyTOTP(phil.ID, strings.ToUpper(recoveryCodes[1])))
+		var count int
+		require.Nil(t, testDB(a).QueryRow(`SELECT COUNT(*) FROM user_recovery_code WHERE code_hash = '`+recoveryCodes[2]+`'`).Scan(&count))
+		require.Equal(t, 0, count)
+
+		// Removing resets everything
+		require.Nil(t, a.RemoveTOTP(phil.ID))
+		enabled, err = a.TOTPEnabled(phil.ID)
+		require.Nil(t, err)
+		require.False(t, enabled)
+		require.Equal(t, ErrTOTPNotFound, a.VerifyTOTP(phil.ID, recoveryCodes[2]))
+		_, err = a.AddTOTPSecret(phil.ID)
+		require.Nil(t, err)
+	})
+}
+
+func TestManager_Authenticate_LDAP(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		directory := ldaptest.NewServer(t)
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil-pass", map[string][]string{"uid": {"phil"}})
+		directory.AddEntry("uid=ben,ou=people,dc=example,dc=com", "ben-ldap-pass", map[string][]string{"uid": {"ben"}})
+		a := newTestManagerFromConfig(t, newManager, &Config{
+			DefaultAccess:       PermissionDenyAll,
+			BcryptCost:          bcrypt.MinCost,
+			QueueWriterInterval: DefaultUserStatsQueueWriterInterval,
+			LDAP:                ldap.New(&ldap.Config{URL: directory.URL, BaseDN: "dc=example,dc=com"}),
+		})
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+
+		// First login creates the user; the password is checked against the directory every time
+		_, err := a.Authenticate("phil", "wrong")
+		require.Equal(t, ErrUnauthenticated, err)
+		_, err = a.User("phil")
+		require.Equal(t, ErrUserNotFound, err)
+		phil, err := a.Authenticate("phil", "phil-pass")
+		require.Nil(t, err)
+		require.Equal(t, "phil", phil.Name)
+		require.Equal(t, RoleUser, phil.Role)
+		phil, err = a.Authenticate("phil", "phil-pass")
+		require.Nil(t, err)
+		require.Equal(t, "phil", phil.Name)
+		_, err = a.Authenticate("phil", "")
+		require.Equal(t, ErrUnauthenticated, err)
+
+		// Tokens, reservations, etc. stay in the local database
+		token, err := a.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+		require.Nil(t, err)
+		u, err := a.AuthenticateToken(token.Value)
+		require.Nil(t, err)
+		require.Equal(t, "phil", u.Name)
+
+		// Local users are not affected, even if the directory has a user with the same name
+		_, err = a.Authenticate("ben", "ben-ldap-pass")
+		require.Equal(t, ErrUnauthenticated, err)
+		ben, err := a.Authenticate("ben", "ben")
+		require.Nil(t, err)
+		require.Equal(t, "ben", ben.Name)
+
+		// Users that are neither local nor in the directory
+		_, err = a.Authenticate("nobody", "nobody")
+		require.Equal(t, ErrUnauthenticated, err)
+	})
+}
+
+func TestManager_Authenticate_LDAPGroups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		directory := ldaptest.NewServer(t)
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil", map[string][]string{
+			"uid":      {"phil"},
+			"memberOf": {"cn=ops,ou=groups,dc=example,dc=com", "cn=users,ou=groups,dc=example,dc=com"},
+		})
+		a := newTestManagerFromConfig(t, newManager, &Config{
+			DefaultAccess:       PermissionDenyAll,
+			BcryptCost:          bcrypt.MinCost,
+			QueueWriterInterval: DefaultUserStatsQueueWriterInterval,
+			LDAP:                ldap.New(&ldap.Config{URL: directory.URL, BaseDN: "dc=example,dc=com", GroupBaseDN: "ou=groups,dc=example,dc=com"}),
+			LDAPAdminGroups:     []string{"admins"},
+			LDAPCacheDuration:   time.Nanosecond, // Directory changes below must be picked up on the next login
+			LDAPAccess: map[string][]*Grant{
+				"users": {{TopicPattern: "alerts", Permission: PermissionRead}},
+				"ops":   {{TopicPattern: "alerts", Permission: PermissionWrite}, {TopicPattern: "ops_*", Permission: PermissionReadWrite}},
+			},
+		})
+
+		// Groups are mapped to ACL entries, permissions of multiple groups are combined
+		_, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		grants, err := a.Grants("phil")
+		require.Nil(t, err)
+		require.ElementsMatch(t, []Grant{
+			{TopicPattern: "alerts", Permission: PermissionReadWrite},
+			{TopicPattern: "ops_*", Permission: PermissionReadWrite},
+		}, grants)
+
+		// Removed groups revoke access on the next login
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil", map[string][]string{
+			"uid":      {"phil"},
+			"memberOf": {"cn=users,ou=groups,dc=example,dc=com"},
+		})
+		_, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		grants, err = a.Grants("phil")
+		require.Nil(t, err)
+		require.Equal(t, []Grant{{TopicPattern: "alerts", Permission: PermissionRead}}, grants)
+
+		// Group with the same name outside of the group base DN does not match
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil", map[string][]string{
+			"uid":      {"phil"},
+			"memberOf": {"cn=admins,ou=other,dc=example,dc=com"},
+		})
+		phil, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, RoleUser, phil.Role)
+
+		// Admin group makes the user an admin, and removing it demotes the user again
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil", map[string][]string{
+			"uid":      {"phil"},
+			"memberOf": {"cn=admins,ou=groups,dc=example,dc=com"},
+		})
+		phil, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, RoleAdmin, phil.Role)
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil", map[string][]string{"uid": {"phil"}})
+		phil, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, RoleUser, phil.Role)
+		grants, err = a.Grants("phil")
+		require.Nil(t, err)
+		require.Empty(t, grants)
+	})
+}
+
+func TestManager_Authenticate_LDAPCache(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		directory := ldaptest.NewServer(t)
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "phil", map[string][]string{
+			"uid":      {"phil"},
+			"memberOf": {"cn=admins,ou=groups,dc=example,dc=com"},
+		})
+		a := newTestManagerFromConfig(t, newManager, &Config{
+			DefaultAccess:       PermissionDenyAll,
+			BcryptCost:          bcrypt.MinCost,
+			QueueWriterInterval: DefaultUserStatsQueueWriterInterval,
+			LDAP:                ldap.New(&ldap.Config{URL: directory.URL, BaseDN: "dc=example,dc=com"}),
+			LDAPAdminGroups:     []string{"cn=admins,ou=groups,dc=example,dc=com"},
+			LDAPCacheDuration:   time.Hour,
+		})
+		phil, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, RoleAdmin, phil.Role)
+
+		// Successful authentication is reused, without asking the directory again or syncing the role
+		directory.AddEntry("uid=phil,ou=people,dc=example,dc=com", "new-pass", map[string][]string{"uid": {"phil"}})
+		phil, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, RoleAdmin, phil.Role)
+
+		// Other passwords are still checked against the directory, which syncs the role again
+		_, err = a.Authenticate("phil", "wrong")
+		require.Equal(t, ErrUnauthenticated, err)
+		phil, err = a.Authenticate("phil", "new-pass")
+		require.Nil(t, err)
+		require.Equal(t, RoleUser, phil.Role)
+
+		// ... and the old password is no longer cached
+		_, err = a.Authenticate("phil", "phil")
+		require.Equal(t, ErrUnauthenticated, err)
+	})
+}
+
 func TestUser_PhoneNumberAdd_Multiple_Users_Same_Number(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
 		a := newTestManager(t, newManager, PermissionDenyAll)
@@ -1553,6 +1997,121 @@ func TestManager_RemoveProvisionedOnEmptyConfig(t *testing.T) {
 	})
 }
 
+func TestManager_WithProvisionedTiersAndReservations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		conf := &Config{
+			DefaultAccess:    PermissionDenyAll,
+			ProvisionEnabled: true,
+			BcryptCost:       bcrypt.MinCost,
+			Users: []*User{
+				{Name: "philuser", Hash: "$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C", Role: RoleUser},
+			},
+			Tiers: []*Tier{
+				{Code: "starter", Name: "Starter", MessageLimit: 100},
+				{Code: "pro", Name: "Pro", MessageLimit: 1000, ReservationLimit: 5, MessageExpiryDuration: time.Hour},
+			},
+			Reservations: map[string][]*Reservation{
+				"philuser": {
+					{Topic: "alerts", Owner: PermissionReadWrite, Everyone: PermissionRead},
+					{Topic: "private", Owner: PermissionReadWrite, Everyone: PermissionDenyAll},
+				},
+			},
+		}
+		a := newTestManagerFromConfig(t, newManager, conf)
+
+		// Manually add tier and user
+		require.Nil(t, a.AddTier(&Tier{Code: "manual", Name: "Manual"}))
+		require.Nil(t, a.AddUser("philmanual", "manual", RoleUser, false))
+		require.Nil(t, a.ChangeTier("philmanual", "pro"))
+
+		// Check provisioned tiers, which cannot be changed
+		tier, err := a.Tier("pro")
+		require.Nil(t, err)
+		require.Equal(t, "Pro", tier.Name)
+		require.Equal(t, int64(1000), tier.MessageLimit)
+		require.Equal(t, time.Hour, tier.MessageExpiryDuration)
+		require.True(t, tier.Provisioned)
+		tier.MessageLimit = 2000
+		require.Equal(t, ErrProvisionedTierChange, a.UpdateTier(tier))
+		require.Equal(t, ErrProvisionedTierChange, a.RemoveTier("starter"))
+		tier, err = a.Tier("manual")
+		require.Nil(t, err)
+		require.False(t, tier.Provisioned)
+		tier.MessageLimit = 2000
+		require.Nil(t, a.UpdateTier(tier))
+
+		// Check provisioned reservations, which cannot be changed
+		reservations, err := a.Reservations("philuser")
+		require.Nil(t, err)
+		require.Equal(t, []Reservation{
+			{Topic: "alerts", Owner: PermissionReadWrite, Everyone: PermissionRead, Provisioned: true},
+			{Topic: "private", Owner: PermissionReadWrite, Everyone: PermissionDenyAll, Provisioned: true},
+		}, reservations)
+		require.Nil(t, a.Authorize(nil, "alerts", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "private", PermissionRead))
+		require.Equal(t, ErrProvisionedReservationChange, a.AddReservation("philuser", "alerts", PermissionReadWrite, 0))
+		require.Equal(t, ErrProvisionedReservationChange, a.RemoveReservations("philuser", "alerts"))
+		require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("philmanual", "alerts"))
+
+		// Excess reservations only remove non-provisioned reservations
+		require.Nil(t, a.AddReservation("philuser", "manual-topic", PermissionDenyAll, 0))
+		removed, err := a.RemoveExcessReservations("philuser", 0)
+		require.Nil(t, err)
+		require.Equal(t, []string{"manual-topic"}, removed)
+
+		// Re-open the DB (second app start): "starter" and "private" are gone, "pro" is updated
+		require.Nil(t, a.Close())
+		conf.Tiers = []*Tier{
+			{Code: "pro", Name: "Pro Plus", MessageLimit: 5000, ReservationLimit: 5},
+		}
+		conf.Reservations = map[string][]*Reservation{
+			"philuser": {
+				{Topic: "alerts", Owner: PermissionReadWrite, Everyone: PermissionDenyAll},
+			},
+		}
+		a = newTestManagerFromConfig(t, newManager, conf)
+
+		_, err = a.Tier("starter")
+		require.Equal(t, ErrTierNotFound, err)
+		tier, err = a.Tier("pro")
+		require.Nil(t, err)
+		require.Equal(t, "Pro Plus", tier.Name)
+		require.Equal(t, int64(5000), tier.MessageLimit)
+		u, err := a.User("philmanual")
+		require.Nil(t, err)
+		require.Equal(t, "pro", u.Tier.Code)
+		reservations, err = a.Reservations("philuser")
+		require.Nil(t, err)
+		require.Equal(t, []Reservation{
+			{Topic: "alerts", Owner: PermissionReadWrite, Everyone: PermissionDenyAll, Provisioned: true},
+		}, reservations)
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "alerts", PermissionRead))
+
+		// Topics reserved by other users cannot be provisioned
+		require.Nil(t, a.AddReservation("philmanual", "taken", PermissionDenyAll, 0))
+		a.config.Reservations["philuser"] = append(a.config.Reservations["philuser"], &Reservation{Topic: "taken", Owner: PermissionReadWrite, Everyone: PermissionDenyAll})
+		require.ErrorContains(t, a.maybeProvisionUsersAccessAndTokens(), "topic taken is already reserved")
+
+		// Re-open with empty provisioning config: provisioned tier is removed, and users lose the tier
+		require.Nil(t, a.Close())
+		conf.Users = nil
+		conf.Tiers = nil
+		conf.Reservations = nil
+		a = newTestManagerFromConfig(t, newManager, conf)
+
+		tiers, err := a.Tiers()
+		require.Nil(t, err)
+		require.Len(t, tiers, 1)
+		require.Equal(t, "manual", tiers[0].Code)
+		u, err = a.User("philmanual")
+		require.Nil(t, err)
+		require.Nil(t, u.Tier)
+		reservations, err = a.Reservations("philmanual")
+		require.Nil(t, err)
+		require.Len(t, reservations, 1)
+	})
+}
+
 func TestToFromSQLWildcard(t *testing.T) {
 	require.Equal(t, "up%", toSQLWildcard("up*"))
 	require.Equal(t, "up\\_%", toSQLWildcard("up_*"))
@@ -2703,6 +3262,7 @@ func TestStoreTiers(t *testing.T) {
 			MessageExpiryDuration:    24 * time.Hour,
 			EmailLimit:               100,
 			CallLimit:                10,
+			SMSLimit:                 15,
 			ReservationLimit:         20,
 			AttachmentFileSizeLimit:  10 * 1024 * 1024,
 			AttachmentTotalSizeLimit: 100 * 1024 * 1024,
@@ -2720,6 +3280,7 @@ func TestStoreTiers(t *testing.T) {
 		require.Equal(t, int64(5000), t2.MessageLimit)
 		require.Equal(t, int64(100), t2.EmailLimit)
 		require.Equal(t, int64(10), t2.CallLimit)
+		require.Equal(t, int64(15), t2.SMSLimit)
 		require.Equal(t, int64(20), t2.ReservationLimit)
 
 		// List all tiers
@@ -2890,7 +3451,7 @@ func TestStoreUpdateStats(t *testing.T) {
 		u, err := manager.User("phil")
 		require.Nil(t, err)
 
-		manager.EnqueueUserStats(u.ID, &Stats{Messages: 42, Emails: 3, Calls: 1})
+		manager.EnqueueUserStats(u.ID, &Stats{Messages: 42, Emails: 3, Calls: 1, SMS: 2})
 		require.Nil(t, manager.writeUserStatsQueue())
 
 		u2, err := manager.User("phil")
@@ -2898,6 +3459,7 @@ func TestStoreUpdateStats(t *testing.T) {
 		require.Equal(t, int64(42), u2.Stats.Messages)
 		require.Equal(t, int64(3), u2.Stats.Emails)
 		require.Equal(t, int64(1), u2.Stats.Calls)
+		require.Equal(t, int64(2), u2.Stats.SMS)
 	})
 }
 
@@ -2907,7 +3469,7 @@ func TestStoreResetStats(t *testing.T) {
 		u, err := manager.User("phil")
 		require.Nil(t, err)
 
-		manager.EnqueueUserStats(u.ID, &Stats{Messages: 42, Emails: 3, Calls: 1})
+		manager.EnqueueUserStats(u.ID, &Stats{Messages: 42, Emails: 3, Calls: 1, SMS: 2})
 		require.Nil(t, manager.writeUserStatsQueue())
 		require.Nil(t, manager.ResetStats())
 
@@ -2916,6 +3478,7 @@ func TestStoreResetStats(t *testing.T) {
 		require.Equal(t, int64(0), u2.Stats.Messages)
 		require.Equal(t, int64(0), u2.Stats.Emails)
 		require.Equal(t, int64(0), u2.Stats.Calls)
+		require.Equal(t, int64(0), u2.Stats.SMS)
 	})
 }
 
@@ -3360,7 +3923,9 @@ func TestUser_MagicLink_ResetPassword(t *testing.T) {
 		_, err = a.Authenticate("phil", "oldpass")
 		require.Nil(t, err)
 
-		require.Nil(t, a.ResetPassword(raw, "newpass"))
+		u, err := a.ResetPassword(raw, "newpass")
+		require.Nil(t, err)
+		require.Equal(t, "phil", u.Name)
 
 		// New password works, old does not
 		_, err = a.Authenticate("phil", "newpass")
@@ -3369,7 +3934,8 @@ func TestUser_MagicLink_ResetPassword(t *testing.T) {
 		require.ErrorIs(t, err, ErrUnauthenticated)
 
 		// Token is single-use
-		require.ErrorIs(t, a.ResetPassword(raw, "againpass"), ErrMagicLinkNotFound)
+		_, err = a.ResetPassword(raw, "againpass")
+		require.ErrorIs(t, err, ErrMagicLinkNotFound)
 	})
 }
 
@@ -3382,7 +3948,8 @@ func TestUser_MagicLink_ResetPassword_WrongKindRejected(t *testing.T) {
 
 		// An email-verification token must not be usable for password reset...
 		verifyToken := addVerifyLink(t, a, phil.ID, "phil@example.com", time.Hour)
-		require.ErrorIs(t, a.ResetPassword(verifyToken, "newpass"), ErrMagicLinkNotFound)
+		_, err = a.ResetPassword(verifyToken, "newpass")
+		require.ErrorIs(t, err, ErrMagicLinkNotFound)
 
 		// ...and a reset token must not be usable for email verification
 		resetToken, err := a.AddMagicLink(MagicLinkKindPasswordReset, phil.ID, "", time.Hour)
@@ -3440,7 +4007,8 @@ func TestUser_MagicLink_ResetPassword_ProvisionedRejected(t *testing.T) {
 		// (their password comes from the config file, like change-pass).
 		raw, err := a.AddMagicLink(MagicLinkKindPasswordReset, prov.ID, "", time.Hour)
 		require.Nil(t, err)
-		require.ErrorIs(t, a.ResetPassword(raw, "newpass"), ErrProvisionedUserChange)
+		_, err = a.ResetPassword(raw, "newpass")
+		require.ErrorIs(t, err, ErrProvisionedUserChange)
 	})
 }
 
@@ -3453,7 +4021,8 @@ func TestUser_MagicLink_ResetPassword_Expired(t *testing.T) {
 
 		raw, err := a.AddMagicLink(MagicLinkKindPasswordReset, phil.ID, "", -time.Minute)
 		require.Nil(t, err)
-		require.ErrorIs(t, a.ResetPassword(raw, "newpass"), ErrMagicLinkNotFound)
+		_, err = a.ResetPassword(raw, "newpass")
+		require.ErrorIs(t, err, ErrMagicLinkNotFound)
 		_, err = a.Authenticate("phil", "oldpass")
 		require.Nil(t, err)
 	})
@@ -3560,3 +4129,64 @@ func TestManager_AccountReadsUsePrimary(t *testing.T) {
 	require.Nil(t, err)
 	require.Len(t, pendingEmails, 1)
 }
+
+func TestManager_AuditEntries(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, newManager newManagerFunc) {
+		a := newTestManager(t, newManager, PermissionDenyAll)
+		require.Nil(t, a.AddAuditEntry(&AuditEntry{
+			Time:   time.Unix(1000, 0),
+			Actor:  "root",
+			Source: AuditSourceCLI,
+			Action: AuditActionUserAdd,
+			Target: "phil",
+		}))
+		require.Nil(t, a.AddAuditEntry(&AuditEntry{
+			Time:   time.Unix(2000, 0),
+			Actor:  "phil",
+			Source: AuditSourceAdminAPI,
+			Action: AuditActionAccessAllow,
+			Target: "ben",
+			Before: "mytopic:read-only",
+			After:  "mytopic:read-write",
+			Origin: netip.MustParseAddr("1.2.3.4"),
+		}))
+		require.Nil(t, a.AddAuditEntry(&AuditEntry{
+			Actor:  "ben",
+			Source: AuditSourceAccountAPI,
+			Action: AuditActionAccessReset,
+			Target: "ben",
+			Before: "mytopic:read-write",
+		}))
+
+		// All entries, newest first
+		entries, err := a.AuditEntries(&AuditFilter{})
+		require.Nil(t, err)
+		require.Equal(t, 3, len(entries))
+		require.Equal(t, AuditActionAccessReset, entries[0].Action)
+		require.True(t, time.Since(entries[0].Time) < time.Minute)
+		require.False(t, entries[0].Origin.IsValid())
+		require.Equal(t, "phil", entries[1].Actor)
+		require.Equal(t, AuditSourceAdminAPI, entries[1].Source)
+		require.Equal(t, "ben", entries[1].Target)
+		require.Equal(t, "mytopic:read-only", entries[1].Before)
+		require.Equal(t, "mytopic:read-write", entries[1].After)
+		require.Equal(t, "1.2.3.4", entries[1].Origin.String())
+		require.Equal(t, int64(1000), entries[2].Time.Unix())
+
+		// Filters
+		entries, err = a.AuditEntries(&AuditFilter{Action: "access."})
+		require.Nil(t, err)
+		require.Equal(t, 2, len(entries))
+		entries, err = a.AuditEntries(&AuditFilter{Target: "ben", Actor: "phil"})
+		require.Nil(t, err)
+		require.Equal(t, 1, len(entries))
+		require.Equal(t, AuditActionAccessAllow, entries[0].Action)
+		entries, err = a.AuditEntries(&AuditFilter{Since: time.Unix(1500, 0)})
+		require.Nil(t, err)
+		require.Equal(t, 2, len(entries))
+		entries, err = a.AuditEntries(&AuditFilter{Limit: 1})
+		require.Nil(t, err)
+		require.Equal(t, 1, len(entries))
+		require.Equal(t, "ben", entries[0].Actor)
+	})
+}
@@ -0,0 +1,116 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults of the otpauth:// URI format, and the only
+// ones that all authenticator apps support.
+const (
+	totpSecretBytes    = 20 // 160 bits, as recommended for HMAC-SHA1 (RFC 4226)
+	totpDigits         = 6
+	totpPeriod         = 30 * time.Second
+	totpSkew           = 1  // Number of periods before and after the current one that are accepted, for clock drift
+	recoveryCodeCount  = 10 // Number of recovery codes generated when TOTP is enabled
+	recoveryCodeLength = 10 // Length of a recovery code (without the dash), lowercase letters and digits
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPProvisioningURI returns the otpauth:// URI for the given TOTP secret, which authenticator apps
+// read from a QR code, see https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TOTPProvisioningURI(issuer, username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("period", fmt.Sprintf("%d", int(totpPeriod.Seconds())))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(username), query.Encode())
+}
+
+// TOTPCode returns the TOTP code of the given secret at the given time, as an authenticator app would show it
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, totpCounter(t))
+}
+
+// generateTOTPSecret returns a new random TOTP secret, base32-encoded without padding
+func generateTOTPSecret() string {
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err) // Cannot happen, see crypto/rand
+	}
+	return totpEncoding.EncodeToString(secret)
+}
+
+// totpCounter returns the TOTP counter (number of periods since the Unix epoch) for the given time
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod.Seconds())
+}
+
+// totpCode returns the TOTP code for the given base32-encoded secret and counter (RFC 4226, section 5.3)
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha1.New, key)
+	if err := binary.Write(mac, binary.BigEndian, counter); err != nil {
+		return "", err
+	}
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits))), nil
+}
+
+// validateTOTPCode checks the code against the codes of the periods around the given time (see totpSkew),
+// and returns the matching counter. Callers must make sure that a counter is only used once.
+func validateTOTPCode(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpCounter(now)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		expected, err := totpCode(secret, counter)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// generateRecoveryCodes returns new random recovery codes, formatted as "xxxxx-xxxxx" for readability
+func generateRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := util.RandomLowerStringPrefix("", recoveryCodeLength)
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+	}
+	return codes
+}
+
+// hashRecoveryCode returns the hash of a recovery code, ignoring case, dashes and whitespace, so that
+// codes can be typed in loosely. Like magic-link tokens, codes have enough entropy for a fast hash.
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+	return hashToken(normalized)
+}
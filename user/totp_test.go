@@ -0,0 +1,61 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B (SHA1), truncated to 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := totpCode(secret, totpCounter(time.Unix(unix, 0)))
+		require.Nil(t, err)
+		require.Equal(t, expected, code)
+	}
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	secret := generateTOTPSecret()
+	now := time.Unix(1700000000, 0)
+	current, _ := totpCode(secret, totpCounter(now))
+	previous, _ := totpCode(secret, totpCounter(now)-1)
+	old, _ := totpCode(secret, totpCounter(now)-2)
+
+	counter, ok := validateTOTPCode(secret, current, now)
+	require.True(t, ok)
+	require.Equal(t, totpCounter(now), counter)
+	counter, ok = validateTOTPCode(secret, " "+previous+" ", now)
+	require.True(t, ok)
+	require.Equal(t, totpCounter(now)-1, counter)
+	_, ok = validateTOTPCode(secret, old, now)
+	require.False(t, ok)
+	_, ok = validateTOTPCode(secret, "", now)
+	require.False(t, ok)
+	_, ok = validateTOTPCode(secret, current+"0", now)
+	require.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	require.Equal(t,
+		"otpauth://totp/ntfy.example.com:phil?algorithm=SHA1&digits=6&issuer=ntfy.example.com&period=30&secret=JBSWY3DPEHPK3PXP",
+		TOTPProvisioningURI("ntfy.example.com", "phil", "JBSWY3DPEHPK3PXP"),
+	)
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes := generateRecoveryCodes()
+	require.Len(t, codes, recoveryCodeCount)
+	require.Regexp(t, `^[a-z0-9]{5}-[a-z0-9]{5}$`, codes[0])
+	require.NotEqual(t, codes[0], codes[1])
+	require.Equal(t, hashRecoveryCode(codes[0]), hashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))))
+}
@@ -112,6 +112,7 @@ type Tier struct {
 	MessageExpiryDuration    time.Duration // Cache duration for messages
 	EmailLimit               int64         // Daily email limit
 	CallLimit                int64         // Daily phone call limit
+	SMSLimit                 int64         // Daily SMS limit
 	ReservationLimit         int64         // Number of topic reservations allowed by user
 	AttachmentFileSizeLimit  int64         // Max file size per file (bytes)
 	AttachmentTotalSizeLimit int64         // Total file size for all files of this user (bytes)
@@ -119,6 +120,7 @@ type Tier struct {
 	AttachmentBandwidthLimit int64         // Daily bandwidth limit for the user
 	StripeMonthlyPriceID     string        // Monthly price ID for paid tiers (price_...)
 	StripeYearlyPriceID      string        // Yearly price ID for paid tiers (price_...)
+	Provisioned              bool          // Whether the tier was provisioned by the config file
 }
 
 // Context returns fields for the log
@@ -158,6 +160,7 @@ type Stats struct {
 	Messages int64
 	Emails   int64
 	Calls    int64
+	SMS      int64
 }
 
 // Billing is a struct holding a user's billing information
@@ -179,11 +182,88 @@ type Grant struct {
 
 // Reservation is a struct that represents the ownership over a topic by a user
 type Reservation struct {
-	Topic    string
-	Owner    Permission
-	Everyone Permission
+	Topic       string
+	Owner       Permission
+	Everyone    Permission
+	Provisioned bool // Whether the reservation was provisioned by the config file
 }
 
+// Group is a named set of users that share access control entries and topic reservations
+type Group struct {
+	ID      string
+	Name    string
+	Members []string // Usernames, sorted
+}
+
+// AuditEntry is a single record in the audit log of account, ACL and token changes. Entries
+// are never changed or deleted.
+type AuditEntry struct {
+	ID     int64
+	Time   time.Time
+	Actor  string      // Username of the user that made the change, or the OS user for CLI changes; empty if anonymous
+	Source AuditSource // Where the change was made
+	Action AuditAction
+	Target string // Affected user or group
+	Before string // Previous value (if any), e.g. "mytopic:read-only" for ACL entries
+	After  string // New value (if any)
+	Origin netip.Addr
+}
+
+// AuditFilter narrows down the entries returned by Manager.AuditEntries. Empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Target string
+	Action string // Action or action prefix, e.g. "access." to match all ACL changes
+	Since  time.Time
+	Limit  int
+}
+
+// AuditSource describes where an audited change was made
+type AuditSource string
+
+// All audit sources
+const (
+	AuditSourceCLI        = AuditSource("cli")
+	AuditSourceAdminAPI   = AuditSource("admin-api")
+	AuditSourceAccountAPI = AuditSource("account-api")
+)
+
+// AuditAction describes the kind of change of an AuditEntry
+type AuditAction string
+
+// All audited actions
+const (
+	AuditActionUserAdd                = AuditAction("user.add")
+	AuditActionUserRemove             = AuditAction("user.remove")
+	AuditActionUserPassword           = AuditAction("user.password")
+	AuditActionUserRole               = AuditAction("user.role")
+	AuditActionUserTier               = AuditAction("user.tier")
+	AuditActionUser2FA                = AuditAction("user.2fa")
+	AuditActionAccessAllow            = AuditAction("access.allow")
+	AuditActionAccessReset            = AuditAction("access.reset")
+	AuditActionReservationAdd         = AuditAction("reservation.add")
+	AuditActionReservationRemove      = AuditAction("reservation.remove")
+	AuditActionTokenCreate            = AuditAction("token.create")
+	AuditActionTokenChange            = AuditAction("token.change")
+	AuditActionTokenRemove            = AuditAction("token.remove")
+	AuditActionGroupAdd               = AuditAction("group.add")
+	AuditActionGroupRemove            = AuditAction("group.remove")
+	AuditActionGroupMemberAdd         = AuditAction("group.member.add")
+	AuditActionGroupMemberRemove      = AuditAction("group.member.remove")
+	AuditActionGroupAccessAllow       = AuditAction("group.access.allow")
+	AuditActionGroupAccessReset       = AuditAction("group.access.reset")
+	AuditActionGroupReservationAdd    = AuditAction("group.reservation.add")
+	AuditActionGroupReservationRemove = AuditAction("group.reservation.remove")
+	AuditActionTierAdd                = AuditAction("tier.add")
+	AuditActionTierChange             = AuditAction("tier.change")
+	AuditActionTierRemove             = AuditAction("tier.remove")
+	AuditActionPhoneAdd               = AuditAction("phone.add")
+	AuditActionPhoneRemove            = AuditAction("phone.remove")
+	AuditActionEmailAdd               = AuditAction("email.add")
+	AuditActionEmailRemove            = AuditAction("email.remove")
+	AuditActionWebPushRemove          = AuditAction("webpush.remove")
+)
+
 // Email is a verified email address on a user account, along with whether it is the user's
 // designated primary (recovery) address.
 type Email struct {
@@ -298,41 +378,63 @@ const (
 
 // Config holds the configuration for the user Manager
 type Config struct {
-	Filename                     string              // Database filename, e.g. "/var/lib/ntfy/user.db" (SQLite)
-	DatabaseURL                  string              // Database connection string (PostgreSQL)
-	StartupQueries               string              // Queries to run on startup, e.g. to create initial users or tiers (SQLite only)
-	DefaultAccess                Permission          // Default permission if no ACL matches
-	ProvisionEnabled             bool                // Hack: Enable auto-provisioning of users and access grants, disabled for "ntfy user" commands
-	Users                        []*User             // Predefined users to create on startup
-	Access                       map[string][]*Grant // Predefined access grants to create on startup (username -> []*Grant)
-	Tokens                       map[string][]*Token // Predefined users to create on startup (username -> []*Token)
-	QueueWriterInterval          time.Duration       // Interval for the async queue writer to flush stats and token updates to the database
-	BcryptCost                   int                 // Cost of generated passwords; lowering makes testing faster
-	AccessCacheEnabled           bool                // Enables the in-memory ACL cache (high volume servers only)
-	AccessCacheReloadInterval    time.Duration       // Reload interval for access cache, relevant for ACL writes from CLI
-	ExpiredMagicLinkReapInterval time.Duration       // Interval for sweeping expired email-verify/password-reset links
+	Filename                     string                    // Database filename, e.g. "/var/lib/ntfy/user.db" (SQLite)
+	DatabaseURL                  string                    // Database connection string (PostgreSQL)
+	StartupQueries               string                    // Queries to run on startup, e.g. to create initial users or tiers (SQLite only)
+	DefaultAccess                Permission                // Default permission if no ACL matches
+	ProvisionEnabled             bool                      // Hack: Enable auto-provisioning of users and access grants, disabled for "ntfy user" commands
+	Users                        []*User                   // Predefined users to create on startup
+	Access                       map[string][]*Grant       // Predefined access grants to create on startup (username -> []*Grant)
+	Tokens                       map[string][]*Token       // Predefined users to create on startup (username -> []*Token)
+	Tiers                        []*Tier                   // Predefined tiers to create on startup
+	Reservations                 map[string][]*Reservation // Predefined topic reservations to create on startup (username -> []*Reservation)
+	QueueWriterInterval          time.Duration             // Interval for the async queue writer to flush stats and token updates to the database
+	BcryptCost                   int                       // Cost of generated passwords; lowering makes testing faster
+	AccessCacheEnabled           bool                      // Enables the in-memory ACL cache (high volume servers only)
+	AccessCacheReloadInterval    time.Duration             // Reload interval for access cache, relevant for ACL writes from CLI
+	ExpiredMagicLinkReapInterval time.Duration             // Interval for sweeping expired email-verify/password-reset links
+	LDAP                         Directory                 // Verifies passwords of LDAP users (see Manager.Authenticate); disabled if nil
+	LDAPAdminGroups              []string                  // Members of these LDAP groups are admins, all others have the user role; not synced if empty
+	LDAPAccess                   map[string][]*Grant       // LDAP group -> ACL entries, synced on every login (see Manager.SyncGroupAccess)
+	LDAPCacheDuration            time.Duration             // How long a successful LDAP authentication is reused before the directory is asked again
+}
+
+// Directory is an external user directory (e.g. LDAP, see the ldap package) that verifies passwords
+// of users that are linked to it, see Config.LDAP
+type Directory interface {
+	// Authenticate verifies the password of the user with the given username, and returns the
+	// groups the user is a member of
+	Authenticate(username, password string) (groups []string, err error)
 }
 
 // Error constants used by the package
 var (
-	ErrUnauthenticated        = errors.New("unauthenticated")
-	ErrUnauthorized           = errors.New("unauthorized")
-	ErrInvalidArgument        = errors.New("invalid argument")
-	ErrUserNotFound           = errors.New("user not found")
-	ErrUserExists             = errors.New("user already exists")
-	ErrPasswordHashInvalid    = errors.New("password hash must be a bcrypt hash, use 'ntfy user hash' to generate")
-	ErrPasswordHashWeak       = errors.New("password hash too weak, use 'ntfy user hash' to generate")
-	ErrTierNotFound           = errors.New("tier not found")
-	ErrTokenNotFound          = errors.New("token not found")
-	ErrPhoneNumberNotFound    = errors.New("phone number not found")
-	ErrTooManyReservations    = errors.New("new tier has lower reservation limit")
-	ErrPhoneNumberExists      = errors.New("phone number already exists")
-	ErrEmailNotFound          = errors.New("email not found")
-	ErrEmailExists            = errors.New("email already exists")
-	ErrEmailPrimaryElsewhere  = errors.New("email is the primary email on another account")
-	ErrMagicLinkNotFound      = errors.New("magic link not found")
-	ErrProvisionedUserChange  = errors.New("cannot change or delete provisioned user")
-	ErrProvisionedTokenChange = errors.New("cannot change or delete provisioned token")
+	ErrUnauthenticated              = errors.New("unauthenticated")
+	ErrUnauthorized                 = errors.New("unauthorized")
+	ErrInvalidArgument              = errors.New("invalid argument")
+	ErrUserNotFound                 = errors.New("user not found")
+	ErrUserExists                   = errors.New("user already exists")
+	ErrPasswordHashInvalid          = errors.New("password hash must be a bcrypt hash, use 'ntfy user hash' to generate")
+	ErrPasswordHashWeak             = errors.New("password hash too weak, use 'ntfy user hash' to generate")
+	ErrTierNotFound                 = errors.New("tier not found")
+	ErrTokenNotFound                = errors.New("token not found")
+	ErrPhoneNumberNotFound          = errors.New("phone number not found")
+	ErrTooManyReservations          = errors.New("new tier has lower reservation limit")
+	ErrPhoneNumberExists            = errors.New("phone number already exists")
+	ErrEmailNotFound                = errors.New("email not found")
+	ErrEmailExists                  = errors.New("email already exists")
+	ErrEmailPrimaryElsewhere        = errors.New("email is the primary email on another account")
+	ErrMagicLinkNotFound            = errors.New("magic link not found")
+	ErrIdentityExists               = errors.New("identity is already linked to another user")
+	ErrGroupNotFound                = errors.New("group not found")
+	ErrGroupExists                  = errors.New("group already exists")
+	ErrTOTPNotFound                 = errors.New("two-factor authentication is not set up")
+	ErrTOTPEnabled                  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeInvalid              = errors.New("invalid two-factor authentication code")
+	ErrProvisionedUserChange        = errors.New("cannot change or delete provisioned user")
+	ErrProvisionedTokenChange       = errors.New("cannot change or delete provisioned token")
+	ErrProvisionedTierChange        = errors.New("cannot change or delete provisioned tier")
+	ErrProvisionedReservationChange = errors.New("cannot change or delete provisioned reservation")
 )
 
 // queries holds the database-specific SQL queries
@@ -343,6 +445,7 @@ type queries struct {
 	selectUserByNameOrPrimaryEmail string
 	selectUserByToken              string
 	selectUserByStripeCustomerID   string
+	selectUserByIdentity           string
 	selectUsernames                string
 	selectUsers                    string
 	selectUserCount                string
@@ -400,6 +503,7 @@ type queries struct {
 	selectTierByPriceID string
 	updateTier          string
 	deleteTier          string
+	deleteTierFromUsers string
 
 	// Phone queries
 	selectPhoneNumbers string
@@ -425,6 +529,47 @@ type queries struct {
 	selectPendingEmails          string // Pending (unverified) email addresses for a user
 	deleteExpiredMagicLinks      string
 
+	// Identity queries
+	insertIdentity       string
+	selectIdentityExists string
+
+	// TOTP queries
+	selectTOTP            string
+	upsertTOTP            string
+	updateTOTPEnabled     string
+	updateTOTPLastCounter string
+	deleteTOTP            string
+	insertRecoveryCode    string
+	deleteRecoveryCode    string
+	deleteRecoveryCodes   string
+
+	// Group queries
+	selectGroups                string
+	selectGroupByName           string
+	selectGroupMembers          string
+	selectGroupMembersAll       string // (group_name, user_name) for all groups; also used for the in-memory ACL cache
+	selectUserGroups            string
+	insertGroup                 string
+	deleteGroup                 string
+	insertGroupMember           string
+	deleteGroupMember           string
+	selectGroupTopicPerms       string // Direct-DB authorizeTopicAccess query for group entries; used when the in-memory cache is disabled
+	selectGroupAccessCacheAll   string // Bulk load: (group_name, topic, read, write) for the in-memory ACL cache
+	selectGroupAccess           string
+	selectGroupReservations     string
+	selectGroupReservationOwner string
+	selectGroupOtherAccessCount string
+	upsertGroupAccess           string
+	upsertGroupOwnedAccess      string // Everyone entry of a group-owned reservation
+	deleteGroupAccess           string
+	deleteGroupTopicAccess      string
+	deleteGroupOwnedAccess      string
+	deleteGroupOwnedTopicAccess string
+
+	// Audit log queries
+	insertAuditEntry   string
+	selectAuditEntries string
+
 	// Billing queries
 	updateBilling string
 }
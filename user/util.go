@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -21,6 +22,7 @@ var (
 	allowedTopicRegex        = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)  // No '*'
 	allowedTopicPatternRegex = regexp.MustCompile(`^[-_*A-Za-z0-9]{1,64}$`) // Adds '*' for wildcards!
 	allowedTierRegex         = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+	allowedGroupRegex        = regexp.MustCompile(`^[-_.A-Za-z0-9]{1,64}$`)
 	allowedTokenRegex        = regexp.MustCompile(`^tk_[-_A-Za-z0-9]{29}$`) // Must be tokenLength-len(tokenPrefix)
 )
 
@@ -49,6 +51,11 @@ func AllowedTier(tier string) bool {
 	return allowedTierRegex.MatchString(tier)
 }
 
+// AllowedGroup returns true if the given group name is valid
+func AllowedGroup(group string) bool {
+	return allowedGroupRegex.MatchString(group)
+}
+
 // ValidPasswordHash checks if the given password hash is a valid bcrypt hash
 func ValidPasswordHash(hash string, minCost int) error {
 	if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
@@ -131,3 +138,18 @@ func escapeUnderscore(s string) string {
 func unescapeUnderscore(s string) string {
 	return strings.ReplaceAll(s, "\\_", "_")
 }
+
+// FormatGrant returns the representation of an access control entry in the audit log,
+// e.g. "mytopic:read-only", in the same format as the auth-access config option
+func FormatGrant(topicPattern string, permission Permission) string {
+	return fmt.Sprintf("%s:%s", topicPattern, permission.String())
+}
+
+// FormatToken returns the representation of an access token in the audit log. To avoid leaking
+// tokens, it only includes the prefix and the first few random characters, e.g. "tk_abcde..."
+func FormatToken(token string) string {
+	if len(token) <= len(tokenPrefix)+5 {
+		return token
+	}
+	return token[:len(tokenPrefix)+5] + "..."
+}
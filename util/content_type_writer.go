@@ -29,14 +29,21 @@ func (w *ContentTypeWriter) Write(p []byte) (n int, err error) {
 	// Fix content types that we don't want to inline-render in the browser. In particular,
 	// we don't want to render HTML in the browser for security reasons.
 	contentType, _ := DetectContentType(p, w.filename)
-	if strings.HasPrefix(contentType, "text/html") {
-		contentType = strings.ReplaceAll(contentType, "text/html", "text/plain")
-	} else if contentType == "application/octet-stream" {
-		contentType = "" // Reset to let downstream http.ResponseWriter take care of it
-	}
-	if contentType != "" {
+	if contentType = SafeContentType(contentType); contentType != "" {
 		w.w.Header().Set("Content-Type", contentType)
 	}
 	w.sniffed = true
 	return w.w.Write(p)
 }
+
+// SafeContentType returns the content type to be sent to the browser for the given detected content type. It
+// replaces "text/html" with "text/plain", so that HTML is never rendered in the browser for security reasons, and it
+// returns an empty string for "application/octet-stream" to let the downstream http.ResponseWriter take care of it.
+func SafeContentType(contentType string) string {
+	if strings.HasPrefix(contentType, "text/html") {
+		return strings.ReplaceAll(contentType, "text/html", "text/plain")
+	} else if contentType == "application/octet-stream" {
+		return ""
+	}
+	return contentType
+}
@@ -47,12 +47,13 @@ class AccountApi {
     this.listener = null;
   }
 
-  async login(user) {
+  async login(user, code) {
     const url = accountLoginUrl(config.base_url);
     console.log(`[AccountApi] Logging in at ${url}`);
+    const headers = code ? { "X-2FA-Code": code } : {}; // TOTP or recovery code, if two-factor authentication is enabled
     const response = await fetchOrThrow(url, {
       method: "POST",
-      headers: withBasicAuth({}, user.username, user.password),
+      headers: withBasicAuth(headers, user.username, user.password),
     });
     const json = await response.json(); // May throw SyntaxError
     if (!json.token) {
@@ -235,7 +236,7 @@ class AccountApi {
     });
   }
 
-  async upsertReservation(topic, everyone) {
+  async upsertReservation(topic, everyone, group) {
     const url = accountReservationUrl(config.base_url);
     console.log(`[AccountApi] Upserting user access to topic ${topic}, everyone=${everyone}`);
     await fetchOrThrow(url, {
@@ -244,6 +245,7 @@ class AccountApi {
       body: JSON.stringify({
         topic,
         everyone,
+        group, // Omitted if undefined, i.e. for user reservations
       }),
     });
   }
@@ -63,12 +63,43 @@ export class EmailPrimaryElsewhereError extends Error {
   }
 }
 
+export class TwoFactorRequiredError extends Error {
+  static CODE = 40102; // errHTTPUnauthorizedTwoFactorRequired
+
+  constructor() {
+    super("Two-factor authentication code required");
+  }
+}
+
+export class TwoFactorInvalidError extends Error {
+  static CODE = 40103; // errHTTPUnauthorizedTwoFactorInvalid
+
+  constructor() {
+    super("Two-factor authentication code invalid");
+  }
+}
+
+export class TwoFactorSetupRequiredError extends Error {
+  static CODE = 40303; // errHTTPForbiddenTwoFactorSetupRequired
+
+  constructor() {
+    super("Two-factor authentication must be set up");
+  }
+}
+
 export const throwAppError = async (response) => {
+  const error = await maybeToJson(response);
   if (response.status === 401 || response.status === 403) {
     console.log(`[Error] HTTP ${response.status}`, response);
+    if (error?.code === TwoFactorRequiredError.CODE) {
+      throw new TwoFactorRequiredError();
+    } else if (error?.code === TwoFactorInvalidError.CODE) {
+      throw new TwoFactorInvalidError();
+    } else if (error?.code === TwoFactorSetupRequiredError.CODE) {
+      throw new TwoFactorSetupRequiredError();
+    }
     throw new UnauthorizedError();
   }
-  const error = await maybeToJson(response);
   if (error?.code) {
     console.log(`[Error] HTTP ${response.status}, ntfy error ${error.code}: ${error.error || ""}`, response);
     if (error.code === UserExistsError.CODE) {
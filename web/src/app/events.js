@@ -6,6 +6,7 @@ export const EVENT_KEEPALIVE = "keepalive";
 export const EVENT_MESSAGE = "message";
 export const EVENT_MESSAGE_DELETE = "message_delete";
 export const EVENT_MESSAGE_CLEAR = "message_clear";
+export const EVENT_MESSAGE_ACK = "message_ack";
 export const EVENT_POLL_REQUEST = "poll_request";
 
 export const SW_WEBPUSH_EVENT_MESSAGE = "message";
@@ -1,5 +1,5 @@
 import * as React from "react";
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { Typography, TextField, Button, Box, IconButton, InputAdornment } from "@mui/material";
 import WarningAmberIcon from "@mui/icons-material/WarningAmber";
 import { NavLink } from "react-router-dom";
@@ -10,7 +10,7 @@ import accountApi from "../app/AccountApi";
 import AvatarBox from "./AvatarBox";
 import session from "../app/Session";
 import routes from "./routes";
-import { UnauthorizedError } from "../app/errors";
+import { TwoFactorInvalidError, TwoFactorRequiredError, TwoFactorSetupRequiredError, UnauthorizedError } from "../app/errors";
 import { fadeReload } from "../app/transition";
 
 const Login = () => {
@@ -19,18 +19,43 @@ const Login = () => {
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
   const [showPassword, setShowPassword] = useState(false);
+  const [codeRequired, setCodeRequired] = useState(false);
+  const [code, setCode] = useState("");
+
+  useEffect(() => {
+    // Single sign-on: the server redirects here after an OpenID Connect login, with the session token in the URL fragment
+    const params = new URLSearchParams(window.location.hash.substring(1));
+    const token = params.get("token");
+    const ssoUsername = params.get("username");
+    if (!token || !ssoUsername) {
+      return;
+    }
+    window.history.replaceState(null, "", window.location.pathname); // Don't keep the token in the history
+    (async () => {
+      console.log(`[Login] Single sign-on successful, logged in as ${ssoUsername}`);
+      await session.store(ssoUsername, token);
+      fadeReload(routes.app);
+    })();
+  }, []);
 
   const handleSubmit = async (event) => {
     event.preventDefault();
     const user = { username, password };
     try {
-      const { token, username: canonicalUsername } = await accountApi.login(user);
+      const { token, username: canonicalUsername } = await accountApi.login(user, codeRequired ? code : undefined);
       console.log(`[Login] User auth for user ${user.username} successful, logged in as ${canonicalUsername}`);
       await session.store(canonicalUsername, token);
       fadeReload(routes.app);
     } catch (e) {
       console.log(`[Login] User auth for user ${user.username} failed`, e);
-      if (e instanceof UnauthorizedError) {
+      if (e instanceof TwoFactorRequiredError) {
+        setCodeRequired(true);
+        setError("");
+      } else if (e instanceof TwoFactorInvalidError) {
+        setError(t("login_error_two_factor_code_invalid"));
+      } else if (e instanceof TwoFactorSetupRequiredError) {
+        setError(t("login_error_two_factor_setup_required"));
+      } else if (e instanceof UnauthorizedError) {
         setError(t("Login failed: Invalid username/email or password"));
       } else {
         setError(e.message);
@@ -87,9 +112,35 @@ const Login = () => {
             },
           }}
         />
-        <Button type="submit" fullWidth variant="contained" disabled={username === "" || password === ""} sx={{ mt: 2, mb: 2 }}>
+        {codeRequired && (
+          <TextField
+            margin="dense"
+            required
+            fullWidth
+            id="code"
+            name="code"
+            label={t("login_form_two_factor_code_label")}
+            helperText={t("login_form_two_factor_code_description")}
+            value={code}
+            onChange={(ev) => setCode(ev.target.value.trim())}
+            autoComplete="one-time-code"
+            autoFocus
+          />
+        )}
+        <Button
+          type="submit"
+          fullWidth
+          variant="contained"
+          disabled={username === "" || password === "" || (codeRequired && code === "")}
+          sx={{ mt: 2, mb: 2 }}
+        >
           {t("login_form_button_submit")}
         </Button>
+        {config.enable_oidc_login && (
+          <Button fullWidth variant="outlined" href="/v1/account/oidc/login" sx={{ mb: 2 }}>
+            {t("login_form_button_sso")}
+          </Button>
+        )}
         {error && (
           <Box
             sx={{
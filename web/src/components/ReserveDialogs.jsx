@@ -97,7 +97,7 @@ export const ReserveEditDialog = (props) => {
 
   const handleSubmit = async () => {
     try {
-      await accountApi.upsertReservation(props.reservation.topic, everyone);
+      await accountApi.upsertReservation(props.reservation.topic, everyone, props.reservation.group);
       console.debug(`[ReserveEditDialog] Updated reservation for topic ${t}: ${everyone}`);
     } catch (e) {
       console.log(`[ReserveEditDialog] Error updating topic reservation.`, e);
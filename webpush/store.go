@@ -2,6 +2,7 @@ package webpush
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"net/netip"
 	"time"
@@ -14,6 +15,8 @@ const (
 	subscriptionIDPrefix                     = "wps_"
 	subscriptionIDLength                     = 10
 	subscriptionEndpointLimitPerSubscriberIP = 10
+	deliveryIDPrefix                         = "wpd_"
+	deliveryIDLength                         = 10
 
 	schemaStore = "webpush"
 )
@@ -35,6 +38,7 @@ type queries struct {
 	selectSubscriptionIDByEndpoint             string
 	selectSubscriptionCountBySubscriberIP      string
 	selectSubscriptionsForTopic                string
+	selectSubscriptionsForUserID               string
 	selectSubscriptionsExpiringSoon            string
 	upsertSubscription                         string
 	updateSubscriptionWarningSent              string
@@ -45,10 +49,17 @@ type queries struct {
 	insertSubscriptionTopic                    string
 	deleteSubscriptionTopicAll                 string
 	deleteSubscriptionTopicWithoutSubscription string
+	insertDelivery                             string
+	selectDeliveriesDue                        string
+	updateDeliveryAttempt                      string
+	deleteDelivery                             string
+	deleteDeliveryBySubscriptionAndTopic       string
+	deleteDeliveryExpired                      string
 }
 
-// UpsertSubscription adds or updates Web Push subscriptions for the given topics and user ID.
-func (s *Store) UpsertSubscription(endpoint string, auth, p256dh, userID string, subscriberIP netip.Addr, topics []string) error {
+// UpsertSubscription adds or updates Web Push subscriptions for the given topics and user ID. The optional
+// filters map topics to the filter of that topic; topics without a filter receive all messages.
+func (s *Store) UpsertSubscription(endpoint string, auth, p256dh, userID string, subscriberIP netip.Addr, topics []string, filters map[string]*Filter) error {
 	return db.ExecTx(s.db, func(tx *sql.Tx) error {
 		// Read number of subscriptions for subscriber IP address
 		var subscriptionCount int
@@ -76,7 +87,15 @@ func (s *Store) UpsertSubscription(endpoint string, auth, p256dh, userID string,
 			return err
 		}
 		for _, topic := range topics {
-			if _, err := tx.Exec(s.queries.insertSubscriptionTopic, subscriptionID, topic); err != nil {
+			var filter string
+			if f := filters[topic]; !f.IsEmpty() {
+				b, err := json.Marshal(f)
+				if err != nil {
+					return err
+				}
+				filter = string(b)
+			}
+			if _, err := tx.Exec(s.queries.insertSubscriptionTopic, subscriptionID, topic, filter); err != nil {
 				return err
 			}
 		}
@@ -91,6 +110,19 @@ func (s *Store) SubscriptionsForTopic(topic string) ([]*Subscription, error) {
 		return nil, err
 	}
 	defer rows.Close()
+	return subscriptionsWithFilterFromRows(rows)
+}
+
+// SubscriptionsForUserID returns all subscriptions for the given user ID.
+func (s *Store) SubscriptionsForUserID(userID string) ([]*Subscription, error) {
+	if userID == "" {
+		return nil, ErrWebPushUserIDCannotBeEmpty
+	}
+	rows, err := s.db.ReadOnly().Query(s.queries.selectSubscriptionsForUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	return subscriptionsFromRows(rows)
 }
 
@@ -142,6 +174,81 @@ func (s *Store) RemoveExpiredSubscriptions(expireAfter time.Duration) error {
 	})
 }
 
+// QueueDelivery adds a web push message to the retry queue. If the delivery has a topic, queued deliveries
+// with the same topic for the same subscription are replaced, just like the push service would do.
+func (s *Store) QueueDelivery(delivery *Delivery) error {
+	if delivery.ID == "" {
+		delivery.ID = util.RandomStringPrefix(deliveryIDPrefix, deliveryIDLength)
+	}
+	return db.ExecTx(s.db, func(tx *sql.Tx) error {
+		if delivery.Topic != "" {
+			if _, err := tx.Exec(s.queries.deleteDeliveryBySubscriptionAndTopic, delivery.Subscription.ID, delivery.Topic); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(
+			s.queries.insertDelivery,
+			delivery.ID,
+			delivery.Subscription.ID,
+			string(delivery.Payload),
+			delivery.Urgency,
+			delivery.Topic,
+			delivery.Attempts,
+			delivery.NextAttemptAt.Unix(),
+			delivery.ExpiresAt.Unix(),
+		)
+		return err
+	})
+}
+
+// DeliveriesDue returns up to limit queued deliveries that are due to be retried, oldest first.
+func (s *Store) DeliveriesDue(limit int) ([]*Delivery, error) {
+	rows, err := s.db.Query(s.queries.selectDeliveriesDue, time.Now().Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*Delivery, 0)
+	for rows.Next() {
+		var id, payload, urgency, topic string
+		var attempts int
+		var nextAttemptAt, expiresAt int64
+		subscription := &Subscription{}
+		if err := rows.Scan(&id, &payload, &urgency, &topic, &attempts, &nextAttemptAt, &expiresAt, &subscription.ID, &subscription.Endpoint, &subscription.Auth, &subscription.P256dh, &subscription.UserID); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &Delivery{
+			ID:            id,
+			Subscription:  subscription,
+			Payload:       []byte(payload),
+			Urgency:       urgency,
+			Topic:         topic,
+			Attempts:      attempts,
+			NextAttemptAt: time.Unix(nextAttemptAt, 0),
+			ExpiresAt:     time.Unix(expiresAt, 0),
+		})
+	}
+	return deliveries, nil
+}
+
+// UpdateDeliveryAttempt records a failed delivery attempt, and reschedules the delivery.
+func (s *Store) UpdateDeliveryAttempt(id string, attempts int, nextAttemptAt time.Time) error {
+	_, err := s.db.Exec(s.queries.updateDeliveryAttempt, attempts, nextAttemptAt.Unix(), id)
+	return err
+}
+
+// RemoveDelivery removes a delivery from the retry queue.
+func (s *Store) RemoveDelivery(id string) error {
+	_, err := s.db.Exec(s.queries.deleteDelivery, id)
+	return err
+}
+
+// RemoveExpiredDeliveries removes all deliveries from the retry queue whose TTL has passed.
+func (s *Store) RemoveExpiredDeliveries() error {
+	_, err := s.db.Exec(s.queries.deleteDeliveryExpired, time.Now().Unix())
+	return err
+}
+
 // SetSubscriptionUpdatedAt updates the updated_at timestamp for a subscription by endpoint. This is
 // exported for testing purposes.
 func (s *Store) SetSubscriptionUpdatedAt(endpoint string, updatedAt int64) error {
@@ -171,3 +278,28 @@ func subscriptionsFromRows(rows *sql.Rows) ([]*Subscription, error) {
 	}
 	return subscriptions, nil
 }
+
+func subscriptionsWithFilterFromRows(rows *sql.Rows) ([]*Subscription, error) {
+	subscriptions := make([]*Subscription, 0)
+	for rows.Next() {
+		var id, endpoint, auth, p256dh, userID, filter string
+		if err := rows.Scan(&id, &endpoint, &auth, &p256dh, &userID, &filter); err != nil {
+			return nil, err
+		}
+		subscription := &Subscription{
+			ID:       id,
+			Endpoint: endpoint,
+			Auth:     auth,
+			P256dh:   p256dh,
+			UserID:   userID,
+		}
+		if filter != "" {
+			subscription.Filter = &Filter{}
+			if err := json.Unmarshal([]byte(filter), subscription.Filter); err != nil {
+				return nil, err
+			}
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
@@ -9,12 +9,18 @@ const (
 	postgresSelectSubscriptionIDByEndpointQuery        = `SELECT id FROM webpush_subscription WHERE endpoint = $1`
 	postgresSelectSubscriptionCountBySubscriberIPQuery = `SELECT COUNT(*) FROM webpush_subscription WHERE subscriber_ip = $1`
 	postgresSelectSubscriptionsForTopicQuery           = `
-		SELECT s.id, s.endpoint, s.key_auth, s.key_p256dh, s.user_id
+		SELECT s.id, s.endpoint, s.key_auth, s.key_p256dh, s.user_id, st.filter
 		FROM webpush_subscription_topic st
 		JOIN webpush_subscription s ON s.id = st.subscription_id
 		WHERE st.topic = $1
 		ORDER BY s.endpoint
 	`
+	postgresSelectSubscriptionsForUserIDQuery = `
+		SELECT id, endpoint, key_auth, key_p256dh, user_id
+		FROM webpush_subscription
+		WHERE user_id = $1
+		ORDER BY endpoint
+	`
 	postgresSelectSubscriptionsExpiringSoonQuery = `
 		SELECT id, endpoint, key_auth, key_p256dh, user_id
 		FROM webpush_subscription
@@ -33,14 +39,31 @@ const (
 	postgresDeleteSubscriptionByUserIDQuery    = `DELETE FROM webpush_subscription WHERE user_id = $1`
 	postgresDeleteSubscriptionByAgeQuery       = `DELETE FROM webpush_subscription WHERE updated_at <= $1`
 
-	postgresInsertSubscriptionTopicQuery                    = `INSERT INTO webpush_subscription_topic (subscription_id, topic) VALUES ($1, $2)`
+	postgresInsertSubscriptionTopicQuery                    = `INSERT INTO webpush_subscription_topic (subscription_id, topic, filter) VALUES ($1, $2, $3)`
 	postgresDeleteSubscriptionTopicAllQuery                 = `DELETE FROM webpush_subscription_topic WHERE subscription_id = $1`
 	postgresDeleteSubscriptionTopicWithoutSubscriptionQuery = `DELETE FROM webpush_subscription_topic WHERE subscription_id NOT IN (SELECT id FROM webpush_subscription)`
+
+	postgresInsertDeliveryQuery = `
+		INSERT INTO webpush_delivery (id, subscription_id, payload, urgency, topic, attempts, next_attempt_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	postgresSelectDeliveriesDueQuery = `
+		SELECT d.id, d.payload, d.urgency, d.topic, d.attempts, d.next_attempt_at, d.expires_at, s.id, s.endpoint, s.key_auth, s.key_p256dh, s.user_id
+		FROM webpush_delivery d
+		JOIN webpush_subscription s ON s.id = d.subscription_id
+		WHERE d.next_attempt_at <= $1
+		ORDER BY d.next_attempt_at
+		LIMIT $2
+	`
+	postgresUpdateDeliveryAttemptQuery                = `UPDATE webpush_delivery SET attempts = $1, next_attempt_at = $2 WHERE id = $3`
+	postgresDeleteDeliveryQuery                       = `DELETE FROM webpush_delivery WHERE id = $1`
+	postgresDeleteDeliveryBySubscriptionAndTopicQuery = `DELETE FROM webpush_delivery WHERE subscription_id = $1 AND topic = $2`
+	postgresDeleteDeliveryExpiredQuery                = `DELETE FROM webpush_delivery WHERE expires_at <= $1`
 )
 
 // Schema version and queries
 const (
-	postgresCurrentSchemaVersion = 1
+	postgresCurrentSchemaVersion = 3
 )
 
 var (
@@ -61,15 +84,55 @@ var (
 		CREATE TABLE IF NOT EXISTS webpush_subscription_topic (
 			subscription_id TEXT NOT NULL REFERENCES webpush_subscription (id) ON DELETE CASCADE,
 			topic TEXT NOT NULL,
+			filter TEXT NOT NULL DEFAULT '',
 			PRIMARY KEY (subscription_id, topic)
 		);
 		CREATE INDEX IF NOT EXISTS idx_webpush_topic ON webpush_subscription_topic (topic);
+		CREATE TABLE IF NOT EXISTS webpush_delivery (
+			id TEXT PRIMARY KEY,
+			subscription_id TEXT NOT NULL REFERENCES webpush_subscription (id) ON DELETE CASCADE,
+			payload TEXT NOT NULL,
+			urgency TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			attempts INT NOT NULL,
+			next_attempt_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_webpush_delivery_subscription_id ON webpush_delivery (subscription_id);
+		CREATE INDEX IF NOT EXISTS idx_webpush_delivery_next_attempt_at ON webpush_delivery (next_attempt_at);
 	`)
+
+	// postgresMigrations maps a schema version to the migration upgrading it to the next
+	// version. Always append migrations at the end, never insert in the middle.
+	postgresMigrations = map[int]schema.MigrateFunc{
+		1: schema.AsMigrateFunc(postgresMigrate1To2UpdateQueries), // 1 -> 2: Per-topic filters
+		2: schema.AsMigrateFunc(postgresMigrate2To3UpdateQueries), // 2 -> 3: Delivery retry queue
+	}
+)
+
+const (
+	postgresMigrate1To2UpdateQueries = `
+		ALTER TABLE webpush_subscription_topic ADD COLUMN filter TEXT NOT NULL DEFAULT '';
+	`
+	postgresMigrate2To3UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS webpush_delivery (
+			id TEXT PRIMARY KEY,
+			subscription_id TEXT NOT NULL REFERENCES webpush_subscription (id) ON DELETE CASCADE,
+			payload TEXT NOT NULL,
+			urgency TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			attempts INT NOT NULL,
+			next_attempt_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_webpush_delivery_subscription_id ON webpush_delivery (subscription_id);
+		CREATE INDEX IF NOT EXISTS idx_webpush_delivery_next_attempt_at ON webpush_delivery (next_attempt_at);
+	`
 )
 
 // NewPostgresStore creates a new PostgreSQL-backed web push store using an existing database connection pool.
 func NewPostgresStore(d *db.DB) (*Store, error) {
-	if err := schema.Migrate(d.Primary(), schema.Postgres, schemaStore, postgresCurrentSchemaVersion, postgresCreateTables, nil); err != nil {
+	if err := schema.Migrate(d.Primary(), schema.Postgres, schemaStore, postgresCurrentSchemaVersion, postgresCreateTables, postgresMigrations); err != nil {
 		return nil, err
 	}
 	return &Store{
@@ -78,6 +141,7 @@ func NewPostgresStore(d *db.DB) (*Store, error) {
 			selectSubscriptionIDByEndpoint:             postgresSelectSubscriptionIDByEndpointQuery,
 			selectSubscriptionCountBySubscriberIP:      postgresSelectSubscriptionCountBySubscriberIPQuery,
 			selectSubscriptionsForTopic:                postgresSelectSubscriptionsForTopicQuery,
+			selectSubscriptionsForUserID:               postgresSelectSubscriptionsForUserIDQuery,
 			selectSubscriptionsExpiringSoon:            postgresSelectSubscriptionsExpiringSoonQuery,
 			upsertSubscription:                         postgresUpsertSubscriptionQuery,
 			updateSubscriptionWarningSent:              postgresUpdateSubscriptionWarningSentQuery,
@@ -88,6 +152,12 @@ func NewPostgresStore(d *db.DB) (*Store, error) {
 			insertSubscriptionTopic:                    postgresInsertSubscriptionTopicQuery,
 			deleteSubscriptionTopicAll:                 postgresDeleteSubscriptionTopicAllQuery,
 			deleteSubscriptionTopicWithoutSubscription: postgresDeleteSubscriptionTopicWithoutSubscriptionQuery,
+			insertDelivery:                             postgresInsertDeliveryQuery,
+			selectDeliveriesDue:                        postgresSelectDeliveriesDueQuery,
+			updateDeliveryAttempt:                      postgresUpdateDeliveryAttemptQuery,
+			deleteDelivery:                             postgresDeleteDeliveryQuery,
+			deleteDeliveryBySubscriptionAndTopic:       postgresDeleteDeliveryBySubscriptionAndTopicQuery,
+			deleteDeliveryExpired:                      postgresDeleteDeliveryExpiredQuery,
 		},
 	}, nil
 }
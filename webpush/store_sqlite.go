@@ -16,12 +16,18 @@ const (
 	sqliteSelectSubscriptionIDByEndpointQuery        = `SELECT id FROM subscription WHERE endpoint = ?`
 	sqliteSelectSubscriptionCountBySubscriberIPQuery = `SELECT COUNT(*) FROM subscription WHERE subscriber_ip = ?`
 	sqliteSelectSubscriptionsForTopicQuery           = `
-		SELECT id, endpoint, key_auth, key_p256dh, user_id
+		SELECT id, endpoint, key_auth, key_p256dh, user_id, st.filter
 		FROM subscription_topic st
 		JOIN subscription s ON s.id = st.subscription_id
 		WHERE st.topic = ?
 		ORDER BY endpoint
 	`
+	sqliteSelectSubscriptionsForUserIDQuery = `
+		SELECT id, endpoint, key_auth, key_p256dh, user_id
+		FROM subscription
+		WHERE user_id = ?
+		ORDER BY endpoint
+	`
 	sqliteSelectSubscriptionsExpiringSoonQuery = `
 		SELECT id, endpoint, key_auth, key_p256dh, user_id 
 		FROM subscription 
@@ -40,14 +46,31 @@ const (
 	sqliteDeleteSubscriptionByUserIDQuery    = `DELETE FROM subscription WHERE user_id = ?`
 	sqliteDeleteSubscriptionByAgeQuery       = `DELETE FROM subscription WHERE updated_at <= ?` // Full table scan!
 
-	sqliteInsertSubscriptionTopicQuery                    = `INSERT INTO subscription_topic (subscription_id, topic) VALUES (?, ?)`
+	sqliteInsertSubscriptionTopicQuery                    = `INSERT INTO subscription_topic (subscription_id, topic, filter) VALUES (?, ?, ?)`
 	sqliteDeleteSubscriptionTopicAllQuery                 = `DELETE FROM subscription_topic WHERE subscription_id = ?`
 	sqliteDeleteSubscriptionTopicWithoutSubscriptionQuery = `DELETE FROM subscription_topic WHERE subscription_id NOT IN (SELECT id FROM subscription)`
+
+	sqliteInsertDeliveryQuery = `
+		INSERT INTO delivery (id, subscription_id, payload, urgency, topic, attempts, next_attempt_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	sqliteSelectDeliveriesDueQuery = `
+		SELECT d.id, d.payload, d.urgency, d.topic, d.attempts, d.next_attempt_at, d.expires_at, s.id, s.endpoint, s.key_auth, s.key_p256dh, s.user_id
+		FROM delivery d
+		JOIN subscription s ON s.id = d.subscription_id
+		WHERE d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at
+		LIMIT ?
+	`
+	sqliteUpdateDeliveryAttemptQuery                = `UPDATE delivery SET attempts = ?, next_attempt_at = ? WHERE id = ?`
+	sqliteDeleteDeliveryQuery                       = `DELETE FROM delivery WHERE id = ?`
+	sqliteDeleteDeliveryBySubscriptionAndTopicQuery = `DELETE FROM delivery WHERE subscription_id = ? AND topic = ?`
+	sqliteDeleteDeliveryExpiredQuery                = `DELETE FROM delivery WHERE expires_at <= ?`
 )
 
 // Schema version and queries
 const (
-	sqliteCurrentSchemaVersion = 1
+	sqliteCurrentSchemaVersion = 3
 )
 
 var (
@@ -67,11 +90,53 @@ var (
 		CREATE TABLE IF NOT EXISTS subscription_topic (
 			subscription_id TEXT NOT NULL,
 			topic TEXT NOT NULL,
+			filter TEXT NOT NULL DEFAULT '',
 			PRIMARY KEY (subscription_id, topic),
 			FOREIGN KEY (subscription_id) REFERENCES subscription (id) ON DELETE CASCADE
 		);
 		CREATE INDEX IF NOT EXISTS idx_topic ON subscription_topic (topic);
+		CREATE TABLE IF NOT EXISTS delivery (
+			id TEXT PRIMARY KEY,
+			subscription_id TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			urgency TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			attempts INT NOT NULL,
+			next_attempt_at INT NOT NULL,
+			expires_at INT NOT NULL,
+			FOREIGN KEY (subscription_id) REFERENCES subscription (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_delivery_subscription_id ON delivery (subscription_id);
+		CREATE INDEX IF NOT EXISTS idx_delivery_next_attempt_at ON delivery (next_attempt_at);
 	`)
+
+	// sqliteMigrations maps a schema version to the migration upgrading it to the next
+	// version. Always append migrations at the end, never insert in the middle.
+	sqliteMigrations = map[int]schema.MigrateFunc{
+		1: schema.AsMigrateFunc(sqliteMigrate1To2UpdateQueries), // 1 -> 2: Per-topic filters
+		2: schema.AsMigrateFunc(sqliteMigrate2To3UpdateQueries), // 2 -> 3: Delivery retry queue
+	}
+)
+
+const (
+	sqliteMigrate1To2UpdateQueries = `
+		ALTER TABLE subscription_topic ADD COLUMN filter TEXT NOT NULL DEFAULT '';
+	`
+	sqliteMigrate2To3UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS delivery (
+			id TEXT PRIMARY KEY,
+			subscription_id TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			urgency TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			attempts INT NOT NULL,
+			next_attempt_at INT NOT NULL,
+			expires_at INT NOT NULL,
+			FOREIGN KEY (subscription_id) REFERENCES subscription (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_delivery_subscription_id ON delivery (subscription_id);
+		CREATE INDEX IF NOT EXISTS idx_delivery_next_attempt_at ON delivery (next_attempt_at);
+	`
 )
 
 // NewSQLiteStore creates a new SQLite-backed web push store.
@@ -80,7 +145,7 @@ func NewSQLiteStore(filename, startupQueries string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := schema.Migrate(d, schema.SQLite, schemaStore, sqliteCurrentSchemaVersion, sqliteCreateTables, nil); err != nil {
+	if err := schema.Migrate(d, schema.SQLite, schemaStore, sqliteCurrentSchemaVersion, sqliteCreateTables, sqliteMigrations); err != nil {
 		return nil, err
 	}
 	if err := runSQLiteStartupQueries(d, startupQueries); err != nil {
@@ -92,6 +157,7 @@ func NewSQLiteStore(filename, startupQueries string) (*Store, error) {
 			selectSubscriptionIDByEndpoint:             sqliteSelectSubscriptionIDByEndpointQuery,
 			selectSubscriptionCountBySubscriberIP:      sqliteSelectSubscriptionCountBySubscriberIPQuery,
 			selectSubscriptionsForTopic:                sqliteSelectSubscriptionsForTopicQuery,
+			selectSubscriptionsForUserID:               sqliteSelectSubscriptionsForUserIDQuery,
 			selectSubscriptionsExpiringSoon:            sqliteSelectSubscriptionsExpiringSoonQuery,
 			upsertSubscription:                         sqliteUpsertSubscriptionQuery,
 			updateSubscriptionWarningSent:              sqliteUpdateSubscriptionWarningSentQuery,
@@ -102,6 +168,12 @@ func NewSQLiteStore(filename, startupQueries string) (*Store, error) {
 			insertSubscriptionTopic:                    sqliteInsertSubscriptionTopicQuery,
 			deleteSubscriptionTopicAll:                 sqliteDeleteSubscriptionTopicAllQuery,
 			deleteSubscriptionTopicWithoutSubscription: sqliteDeleteSubscriptionTopicWithoutSubscriptionQuery,
+			insertDelivery:                             sqliteInsertDeliveryQuery,
+			selectDeliveriesDue:                        sqliteSelectDeliveriesDueQuery,
+			updateDeliveryAttempt:                      sqliteUpdateDeliveryAttemptQuery,
+			deleteDelivery:                             sqliteDeleteDeliveryQuery,
+			deleteDeliveryBySubscriptionAndTopic:       sqliteDeleteDeliveryBySubscriptionAndTopicQuery,
+			deleteDeliveryExpired:                      sqliteDeleteDeliveryExpiredQuery,
 		},
 	}, nil
 }
@@ -128,7 +128,7 @@ func TestStorePostgresOpensExistingDatabase(t *testing.T) {
 
 func requireStoreUsable(t *testing.T, store *webpush.Store) {
 	t.Helper()
-	err := store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"mytopic"})
+	err := store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"mytopic"}, nil)
 	require.Nil(t, err)
 	subs, err := store.SubscriptionsForTopic("mytopic")
 	require.Nil(t, err)
@@ -153,7 +153,7 @@ func forEachBackend(t *testing.T, f func(t *testing.T, store *webpush.Store)) {
 
 func TestStoreUpsertSubscriptionSubscriptionsForTopic(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}, nil))
 
 		subs, err := store.SubscriptionsForTopic("test-topic")
 		require.Nil(t, err)
@@ -170,30 +170,59 @@ func TestStoreUpsertSubscriptionSubscriptionsForTopic(t *testing.T) {
 	})
 }
 
+func TestStoreUpsertSubscriptionFilters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
+		filters := map[string]*webpush.Filter{
+			"topic1": {Tags: []string{"warning"}, Priority: []int{4, 5}},
+			"topic2": {}, // Empty filters are not stored
+		}
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2", "topic3"}, filters))
+
+		subs, err := store.SubscriptionsForTopic("topic1")
+		require.Nil(t, err)
+		require.Len(t, subs, 1)
+		require.Equal(t, &webpush.Filter{Tags: []string{"warning"}, Priority: []int{4, 5}}, subs[0].Filter)
+
+		for _, topic := range []string{"topic2", "topic3"} {
+			subs, err = store.SubscriptionsForTopic(topic)
+			require.Nil(t, err)
+			require.Len(t, subs, 1)
+			require.Nil(t, subs[0].Filter)
+		}
+
+		// Updating the subscription replaces the filters
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
+		subs, err = store.SubscriptionsForTopic("topic1")
+		require.Nil(t, err)
+		require.Len(t, subs, 1)
+		require.Nil(t, subs[0].Filter)
+	})
+}
+
 func TestStoreUpsertSubscriptionSubscriberIPLimitReached(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert 10 subscriptions with the same IP address
 		for i := 0; i < 10; i++ {
 			endpoint := fmt.Sprintf(testWebPushEndpoint+"%d", i)
-			require.Nil(t, store.UpsertSubscription(endpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+			require.Nil(t, store.UpsertSubscription(endpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}, nil))
 		}
 
 		// Another one for the same endpoint should be fine
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}, nil))
 
 		// But with a different endpoint it should fail
-		require.Equal(t, webpush.ErrWebPushTooManySubscriptions, store.UpsertSubscription(testWebPushEndpoint+"11", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+		require.Equal(t, webpush.ErrWebPushTooManySubscriptions, store.UpsertSubscription(testWebPushEndpoint+"11", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}, nil))
 
 		// But with a different IP address it should be fine again
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"99", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("9.9.9.9"), []string{"test-topic", "mytopic"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"99", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("9.9.9.9"), []string{"test-topic", "mytopic"}, nil))
 	})
 }
 
 func TestStoreUpsertSubscriptionUpdateTopics(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert subscription with two topics, and another with one topic
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}, nil))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}, nil))
 
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
@@ -207,7 +236,7 @@ func TestStoreUpsertSubscriptionUpdateTopics(t *testing.T) {
 		require.Equal(t, testWebPushEndpoint+"0", subs[0].Endpoint)
 
 		// Update the first subscription to have only one topic
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
 
 		subs, err = store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
@@ -223,7 +252,7 @@ func TestStoreUpsertSubscriptionUpdateTopics(t *testing.T) {
 func TestStoreUpsertSubscriptionUpdateFields(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert a subscription
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
 
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
@@ -233,7 +262,7 @@ func TestStoreUpsertSubscriptionUpdateFields(t *testing.T) {
 		require.Equal(t, "u_1234", subs[0].UserID)
 
 		// Re-upsert the same endpoint with different auth, p256dh, and userID
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "new-auth", "new-p256dh", "u_5678", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "new-auth", "new-p256dh", "u_5678", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
 
 		subs, err = store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
@@ -248,9 +277,9 @@ func TestStoreUpsertSubscriptionUpdateFields(t *testing.T) {
 func TestStoreRemoveByUserIDMultiple(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert two subscriptions for u_1234 and one for u_5678
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}))
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}))
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"2", "auth-key", "p256dh-key", "u_5678", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"2", "auth-key", "p256dh-key", "u_5678", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}, nil))
 
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
@@ -268,10 +297,32 @@ func TestStoreRemoveByUserIDMultiple(t *testing.T) {
 	})
 }
 
+func TestStoreSubscriptionsForUserID(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"1", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"0", "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic2"}, nil))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"2", "auth-key", "p256dh-key", "u_5678", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}, nil))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint+"3", "auth-key", "p256dh-key", "", netip.MustParseAddr("9.9.9.9"), []string{"topic1"}, nil))
+
+		subs, err := store.SubscriptionsForUserID("u_1234")
+		require.Nil(t, err)
+		require.Len(t, subs, 2)
+		require.Equal(t, testWebPushEndpoint+"0", subs[0].Endpoint)
+		require.Equal(t, testWebPushEndpoint+"1", subs[1].Endpoint)
+
+		subs, err = store.SubscriptionsForUserID("u_9999")
+		require.Nil(t, err)
+		require.Len(t, subs, 0)
+
+		_, err = store.SubscriptionsForUserID("")
+		require.Equal(t, webpush.ErrWebPushUserIDCannotBeEmpty, err)
+	})
+}
+
 func TestStoreRemoveByEndpoint(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert subscription with two topics
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}, nil))
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
 		require.Len(t, subs, 1)
@@ -287,7 +338,7 @@ func TestStoreRemoveByEndpoint(t *testing.T) {
 func TestStoreRemoveByUserID(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert subscription with two topics
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}, nil))
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
 		require.Len(t, subs, 1)
@@ -309,7 +360,7 @@ func TestStoreRemoveByUserIDEmpty(t *testing.T) {
 func TestStoreExpiryWarningSent(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert subscription with two topics
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}, nil))
 
 		// Set updated_at to the past so it shows up as expiring
 		require.Nil(t, store.SetSubscriptionUpdatedAt(testWebPushEndpoint, time.Now().Add(-8*24*time.Hour).Unix()))
@@ -333,7 +384,7 @@ func TestStoreExpiryWarningSent(t *testing.T) {
 func TestStoreExpiring(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert subscription with two topics
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}, nil))
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
 		require.Len(t, subs, 1)
@@ -355,7 +406,7 @@ func TestStoreExpiring(t *testing.T) {
 func TestStoreRemoveExpired(t *testing.T) {
 	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
 		// Insert subscription with two topics
-		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}))
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1", "topic2"}, nil))
 		subs, err := store.SubscriptionsForTopic("topic1")
 		require.Nil(t, err)
 		require.Len(t, subs, 1)
@@ -372,3 +423,54 @@ func TestStoreRemoveExpired(t *testing.T) {
 		require.Len(t, subs, 0)
 	})
 }
+
+func TestStoreDeliveries(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *webpush.Store) {
+		require.Nil(t, store.UpsertSubscription(testWebPushEndpoint, "auth-key", "p256dh-key", "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"topic1"}, nil))
+		subs, err := store.SubscriptionsForTopic("topic1")
+		require.Nil(t, err)
+		require.Len(t, subs, 1)
+
+		// Queue deliveries: two due, one not due yet, and one expired
+		now := time.Now()
+		require.Nil(t, store.QueueDelivery(&webpush.Delivery{Subscription: subs[0], Payload: []byte(`{"a":1}`), Urgency: "high", Attempts: 1, NextAttemptAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}))
+		require.Nil(t, store.QueueDelivery(&webpush.Delivery{Subscription: subs[0], Payload: []byte(`{"b":2}`), Urgency: "low", Topic: "seq1", Attempts: 2, NextAttemptAt: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)}))
+		require.Nil(t, store.QueueDelivery(&webpush.Delivery{Subscription: subs[0], Payload: []byte(`{"c":3}`), Urgency: "high", Attempts: 1, NextAttemptAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}))
+		require.Nil(t, store.QueueDelivery(&webpush.Delivery{Subscription: subs[0], Payload: []byte(`{"d":4}`), Urgency: "high", Attempts: 1, NextAttemptAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}))
+		require.Nil(t, store.RemoveExpiredDeliveries())
+
+		deliveries, err := store.DeliveriesDue(10)
+		require.Nil(t, err)
+		require.Len(t, deliveries, 2)
+		require.Equal(t, `{"a":1}`, string(deliveries[0].Payload))
+		require.Equal(t, testWebPushEndpoint, deliveries[0].Subscription.Endpoint)
+		require.Equal(t, "p256dh-key", deliveries[0].Subscription.P256dh)
+		require.Equal(t, "auth-key", deliveries[0].Subscription.Auth)
+		require.Equal(t, `{"b":2}`, string(deliveries[1].Payload))
+		require.Equal(t, "low", deliveries[1].Urgency)
+		require.Equal(t, "seq1", deliveries[1].Topic)
+		require.Equal(t, 2, deliveries[1].Attempts)
+		require.Equal(t, now.Add(time.Hour).Unix(), deliveries[1].ExpiresAt.Unix())
+
+		// Queueing a delivery with the same topic replaces the old one
+		require.Nil(t, store.QueueDelivery(&webpush.Delivery{Subscription: subs[0], Payload: []byte(`{"b":3}`), Urgency: "low", Topic: "seq1", Attempts: 1, NextAttemptAt: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)}))
+		deliveries, err = store.DeliveriesDue(10)
+		require.Nil(t, err)
+		require.Len(t, deliveries, 2)
+		require.Equal(t, `{"b":3}`, string(deliveries[1].Payload))
+
+		// Reschedule and remove
+		require.Nil(t, store.UpdateDeliveryAttempt(deliveries[0].ID, 2, now.Add(time.Minute)))
+		require.Nil(t, store.RemoveDelivery(deliveries[1].ID))
+		deliveries, err = store.DeliveriesDue(10)
+		require.Nil(t, err)
+		require.Len(t, deliveries, 0)
+
+		// Removing the subscription removes its deliveries
+		require.Nil(t, store.QueueDelivery(&webpush.Delivery{Subscription: subs[0], Payload: []byte(`{"e":5}`), Urgency: "high", Attempts: 1, NextAttemptAt: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)}))
+		require.Nil(t, store.RemoveSubscriptionsByEndpoint(testWebPushEndpoint))
+		deliveries, err = store.DeliveriesDue(10)
+		require.Nil(t, err)
+		require.Len(t, deliveries, 0)
+	})
+}
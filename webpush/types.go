@@ -1,6 +1,10 @@
 package webpush
 
-import "heckel.io/ntfy/v2/log"
+import (
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
 
 // Subscription represents a web push subscription.
 type Subscription struct {
@@ -9,6 +13,35 @@ type Subscription struct {
 	Auth     string
 	P256dh   string
 	UserID   string
+	Filter   *Filter // Filter for the topic, only set by SubscriptionsForTopic; nil if all messages are delivered
+}
+
+// Delivery is a web push message that could not be delivered due to a temporary error, and that is
+// queued to be retried later.
+type Delivery struct {
+	ID            string
+	Subscription  *Subscription
+	Payload       []byte
+	Urgency       string // RFC 8030 Urgency header
+	Topic         string // RFC 8030 Topic header, may be empty
+	Attempts      int    // Number of failed attempts so far
+	NextAttemptAt time.Time
+	ExpiresAt     time.Time // Time after which the message is no longer delivered (TTL)
+}
+
+// Filter restricts the messages of a topic that are delivered to a subscription. Empty fields match
+// all messages. Filters are evaluated like the query filters of regular subscriptions, i.e. a message
+// must match all non-empty fields, and it must have all tags and one of the priorities.
+type Filter struct {
+	Message  string   `json:"message,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Priority []int    `json:"priority,omitempty"`
+}
+
+// IsEmpty returns true if the filter does not restrict any messages
+func (f *Filter) IsEmpty() bool {
+	return f == nil || (f.Message == "" && f.Title == "" && len(f.Tags) == 0 && len(f.Priority) == 0)
 }
 
 // Context returns the logging context for the subscription.
@@ -19,3 +52,11 @@ func (w *Subscription) Context() log.Context {
 		"web_push_subscription_endpoint": w.Endpoint,
 	}
 }
+
+// Context returns the logging context for the delivery.
+func (d *Delivery) Context() log.Context {
+	return map[string]any{
+		"web_push_delivery_id":       d.ID,
+		"web_push_delivery_attempts": d.Attempts,
+	}
+}